@@ -0,0 +1,84 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+)
+
+var (
+	ErrBundleNotFound = errors.New("archived job not found")
+)
+
+const (
+	SINK_LOCAL = "local"
+	SINK_S3    = "s3"
+)
+
+var idPattern = regexp.MustCompile(`^[a-zA-Z0-9][-_a-zA-Z0-9]*$`)
+
+// Bundle is the archived copy of a job -- including its
+// execution -- along with the log parts of its tasks.
+type Bundle struct {
+	Job        *tork.Job           `json:"job"`
+	Logs       []*tork.TaskLogPart `json:"logs,omitempty"`
+	ArchivedAt time.Time           `json:"archivedAt"`
+}
+
+// Sink keeps the compressed bundles of jobs which
+// were expunged from the datastore.
+type Sink interface {
+	Put(ctx context.Context, jobID string, r io.Reader, size int64) error
+	Get(ctx context.Context, jobID string) (io.ReadCloser, error)
+}
+
+// ValidateJobID ensures that a job id can be safely
+// used as a file name or as part of an object key.
+func ValidateJobID(id string) error {
+	if !idPattern.MatchString(id) || len(id) > 64 {
+		return errors.Errorf("invalid job id: %s", id)
+	}
+	return nil
+}
+
+// Write puts the bundle into the sink as gzipped JSON.
+func Write(ctx context.Context, s Sink, b *Bundle) error {
+	if b.Job == nil {
+		return errors.New("bundle is missing the job")
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(b); err != nil {
+		return errors.Wrapf(err, "error encoding job %s", b.Job.ID)
+	}
+	if err := zw.Close(); err != nil {
+		return errors.Wrapf(err, "error compressing job %s", b.Job.ID)
+	}
+	return s.Put(ctx, b.Job.ID, &buf, int64(buf.Len()))
+}
+
+// Read fetches the bundle of the given job from the sink.
+func Read(ctx context.Context, s Sink, jobID string) (*Bundle, error) {
+	r, err := s.Get(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error decompressing job %s", jobID)
+	}
+	defer zr.Close()
+	b := &Bundle{}
+	if err := json.NewDecoder(zr).Decode(b); err != nil {
+		return nil, errors.Wrapf(err, "error decoding job %s", jobID)
+	}
+	return b, nil
+}
@@ -0,0 +1,60 @@
+package archive_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/archive"
+	"github.com/runabol/tork/archive/local"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteRead(t *testing.T) {
+	ctx := context.Background()
+	sink, err := local.NewLocalSink(t.TempDir())
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+	j := &tork.Job{
+		ID:          uuid.NewUUID(),
+		Name:        "test job",
+		State:       tork.JobStateCompleted,
+		CompletedAt: &now,
+		Execution: []*tork.Task{{
+			ID:    uuid.NewUUID(),
+			Name:  "task 1",
+			State: tork.TaskStateCompleted,
+		}},
+	}
+	err = archive.Write(ctx, sink, &archive.Bundle{
+		Job: j,
+		Logs: []*tork.TaskLogPart{{
+			Number:   1,
+			TaskID:   j.Execution[0].ID,
+			Contents: "hello world",
+		}},
+		ArchivedAt: now,
+	})
+	assert.NoError(t, err)
+
+	b, err := archive.Read(ctx, sink, j.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, j.ID, b.Job.ID)
+	assert.Equal(t, "test job", b.Job.Name)
+	assert.Len(t, b.Job.Execution, 1)
+	assert.Len(t, b.Logs, 1)
+	assert.Equal(t, "hello world", b.Logs[0].Contents)
+
+	_, err = archive.Read(ctx, sink, uuid.NewUUID())
+	assert.ErrorIs(t, err, archive.ErrBundleNotFound)
+}
+
+func TestValidateJobID(t *testing.T) {
+	assert.NoError(t, archive.ValidateJobID(uuid.NewUUID()))
+	assert.Error(t, archive.ValidateJobID(""))
+	assert.Error(t, archive.ValidateJobID("../etc/passwd"))
+	assert.Error(t, archive.ValidateJobID("a/b"))
+}
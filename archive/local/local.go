@@ -0,0 +1,69 @@
+package local
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork/archive"
+)
+
+// LocalSink keeps archived jobs on the local
+// filesystem under <dir>/<job id>.json.gz.
+type LocalSink struct {
+	dir string
+}
+
+func NewLocalSink(dir string) (*LocalSink, error) {
+	if dir == "" {
+		return nil, errors.New("archive directory is required")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "error creating archive directory %s", dir)
+	}
+	return &LocalSink{dir: dir}, nil
+}
+
+func (s *LocalSink) filename(jobID string) string {
+	return filepath.Join(s.dir, jobID+".json.gz")
+}
+
+func (s *LocalSink) Put(ctx context.Context, jobID string, r io.Reader, size int64) error {
+	if err := archive.ValidateJobID(jobID); err != nil {
+		return err
+	}
+	// write to a temporary file first so that a
+	// partially written bundle is never visible
+	f, err := os.CreateTemp(s.dir, ".archive-*")
+	if err != nil {
+		return errors.Wrapf(err, "error creating archive file")
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "error archiving job %s", jobID)
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "error archiving job %s", jobID)
+	}
+	if err := os.Rename(f.Name(), s.filename(jobID)); err != nil {
+		return errors.Wrapf(err, "error archiving job %s", jobID)
+	}
+	return nil
+}
+
+func (s *LocalSink) Get(ctx context.Context, jobID string) (io.ReadCloser, error) {
+	if err := archive.ValidateJobID(jobID); err != nil {
+		return nil, archive.ErrBundleNotFound
+	}
+	f, err := os.Open(s.filename(jobID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, archive.ErrBundleNotFound
+		}
+		return nil, errors.Wrapf(err, "error opening archived job %s", jobID)
+	}
+	return f, nil
+}
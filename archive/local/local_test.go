@@ -0,0 +1,55 @@
+package local
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/runabol/tork/archive"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalSinkPutGet(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := NewLocalSink(dir)
+	assert.NoError(t, err)
+
+	jobID := uuid.NewUUID()
+	err = s.Put(ctx, jobID, strings.NewReader("hello world"), 11)
+	assert.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(dir, jobID+".json.gz"))
+	assert.NoError(t, err)
+
+	r, err := s.Get(ctx, jobID)
+	assert.NoError(t, err)
+	b, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, "hello world", string(b))
+
+	_, err = s.Get(ctx, uuid.NewUUID())
+	assert.ErrorIs(t, err, archive.ErrBundleNotFound)
+}
+
+func TestLocalSinkInvalidJobID(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewLocalSink(t.TempDir())
+	assert.NoError(t, err)
+
+	err = s.Put(ctx, "../escape", strings.NewReader("bad"), 3)
+	assert.Error(t, err)
+
+	_, err = s.Get(ctx, "../escape")
+	assert.ErrorIs(t, err, archive.ErrBundleNotFound)
+}
+
+func TestNewLocalSinkNoDir(t *testing.T) {
+	_, err := NewLocalSink("")
+	assert.Error(t, err)
+}
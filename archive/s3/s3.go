@@ -0,0 +1,90 @@
+package s3
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+	"github.com/runabol/tork/archive"
+)
+
+// S3Sink keeps archived jobs in an S3-compatible
+// bucket under <prefix>/<job id>.json.gz.
+type S3Sink struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+type Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	Secure    bool
+}
+
+func NewS3Sink(cfg Config) (*S3Sink, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("s3 endpoint is required")
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3 bucket is required")
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.Secure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating s3 client")
+	}
+	return &S3Sink{
+		client: client,
+		bucket: cfg.Bucket,
+		prefix: strings.Trim(cfg.Prefix, "/"),
+	}, nil
+}
+
+func (s *S3Sink) key(jobID string) string {
+	return path.Join(s.prefix, jobID+".json.gz")
+}
+
+func (s *S3Sink) Put(ctx context.Context, jobID string, r io.Reader, size int64) error {
+	if err := archive.ValidateJobID(jobID); err != nil {
+		return err
+	}
+	_, err := s.client.PutObject(ctx, s.bucket, s.key(jobID), r, size, minio.PutObjectOptions{
+		ContentType: "application/gzip",
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error archiving job %s", jobID)
+	}
+	return nil
+}
+
+func (s *S3Sink) Get(ctx context.Context, jobID string) (io.ReadCloser, error) {
+	if err := archive.ValidateJobID(jobID); err != nil {
+		return nil, archive.ErrBundleNotFound
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, s.key(jobID), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error fetching archived job %s", jobID)
+	}
+	// GetObject is lazy so we stat the object
+	// to find out whether it actually exists
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, archive.ErrBundleNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching archived job %s", jobID)
+	}
+	return obj, nil
+}
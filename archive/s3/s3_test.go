@@ -0,0 +1,106 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/runabol/tork/archive"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeS3 implements just enough of the S3
+// API to exercise the sink: put and get.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	key := ""
+	if len(parts) > 1 {
+		key = parts[1]
+	}
+	switch r.Method {
+	case http.MethodPut:
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		f.objects[key] = b
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet, http.MethodHead:
+		b, ok := f.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				fmt.Fprintf(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message><Key>%s</Key></Error>`, key)
+			}
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(b)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(b)
+		}
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func newTestSink(t *testing.T) (*S3Sink, *fakeS3) {
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	s, err := NewS3Sink(Config{
+		Endpoint: strings.TrimPrefix(srv.URL, "http://"),
+		Region:   "us-east-1",
+		Bucket:   "archive",
+		Prefix:   "/tork/",
+	})
+	assert.NoError(t, err)
+	return s, fake
+}
+
+func TestNewS3SinkConfig(t *testing.T) {
+	_, err := NewS3Sink(Config{Bucket: "archive"})
+	assert.Error(t, err)
+	_, err = NewS3Sink(Config{Endpoint: "localhost:9000"})
+	assert.Error(t, err)
+}
+
+func TestS3SinkPutGet(t *testing.T) {
+	ctx := context.Background()
+	s, fake := newTestSink(t)
+
+	jobID := uuid.NewUUID()
+	err := s.Put(ctx, jobID, strings.NewReader("hello world"), 11)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(fake.objects["tork/"+jobID+".json.gz"]))
+
+	r, err := s.Get(ctx, jobID)
+	assert.NoError(t, err)
+	b, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, "hello world", string(b))
+
+	_, err = s.Get(ctx, uuid.NewUUID())
+	assert.ErrorIs(t, err, archive.ErrBundleNotFound)
+
+	err = s.Put(ctx, "../escape", strings.NewReader("bad"), 3)
+	assert.Error(t, err)
+}
@@ -0,0 +1,85 @@
+package artifact
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrArtifactNotFound = errors.New("artifact not found")
+)
+
+const (
+	STORE_LOCAL = "local"
+	STORE_S3    = "s3"
+)
+
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9][-_.a-zA-Z0-9]*$`)
+
+type Artifact struct {
+	Name      string     `json:"name,omitempty"`
+	Size      int64      `json:"size"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+}
+
+// Store holds the files produced by tasks so that they can be
+// handed over to subsequent tasks of the same job.
+type Store interface {
+	Put(ctx context.Context, jobID, name string, r io.Reader, size int64) error
+	Get(ctx context.Context, jobID, name string) (io.ReadCloser, error)
+	List(ctx context.Context, jobID string) ([]*Artifact, error)
+}
+
+// ValidateName ensures that an artifact name can be safely
+// used as a file name or as part of an object key.
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) || len(name) > 256 {
+		return errors.Errorf("invalid artifact name: %s", name)
+	}
+	return nil
+}
+
+// Upload puts the file found at path into the store.
+func Upload(ctx context.Context, s Store, jobID, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "error opening artifact %s", name)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "error reading artifact %s", name)
+	}
+	if fi.IsDir() {
+		return errors.Errorf("artifact %s: %s is a directory", name, path)
+	}
+	return s.Put(ctx, jobID, name, f, fi.Size())
+}
+
+// Download writes the artifact to path, creating any
+// missing parent directories along the way.
+func Download(ctx context.Context, s Store, jobID, name, path string) error {
+	r, err := s.Get(ctx, jobID, name)
+	if err != nil {
+		return errors.Wrapf(err, "error fetching artifact %s", name)
+	}
+	defer r.Close()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrapf(err, "error creating directory for artifact %s", name)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "error creating artifact file %s", path)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return errors.Wrapf(err, "error writing artifact %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package artifact_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/runabol/tork/artifact"
+	"github.com/runabol/tork/artifact/local"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateName(t *testing.T) {
+	assert.NoError(t, artifact.ValidateName("report.txt"))
+	assert.NoError(t, artifact.ValidateName("out-1_final.tar.gz"))
+	assert.Error(t, artifact.ValidateName(""))
+	assert.Error(t, artifact.ValidateName(".hidden"))
+	assert.Error(t, artifact.ValidateName("../report.txt"))
+	assert.Error(t, artifact.ValidateName("some/report.txt"))
+}
+
+func TestUploadDownload(t *testing.T) {
+	ctx := context.Background()
+	s, err := local.NewLocalStore(t.TempDir())
+	assert.NoError(t, err)
+
+	src := filepath.Join(t.TempDir(), "report.txt")
+	assert.NoError(t, os.WriteFile(src, []byte("hello world"), 0644))
+
+	jobID := uuid.NewUUID()
+	assert.NoError(t, artifact.Upload(ctx, s, jobID, "report", src))
+
+	dst := filepath.Join(t.TempDir(), "some", "dir", "report.txt")
+	assert.NoError(t, artifact.Download(ctx, s, jobID, "report", dst))
+	b, err := os.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+
+	err = artifact.Upload(ctx, s, jobID, "dir", t.TempDir())
+	assert.Error(t, err)
+
+	err = artifact.Download(ctx, s, jobID, "nosuch", dst)
+	assert.ErrorIs(t, err, artifact.ErrArtifactNotFound)
+}
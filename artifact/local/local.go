@@ -0,0 +1,102 @@
+package local
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork/artifact"
+)
+
+// LocalStore keeps artifacts on the local filesystem under
+// <dir>/<job id>/<artifact name>. When the coordinator and
+// the workers run on different hosts the directory should
+// be on a shared filesystem.
+type LocalStore struct {
+	dir string
+}
+
+func NewLocalStore(dir string) (*LocalStore, error) {
+	if dir == "" {
+		return nil, errors.New("artifacts directory is required")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "error creating artifacts directory %s", dir)
+	}
+	return &LocalStore{dir: dir}, nil
+}
+
+func (s *LocalStore) Put(ctx context.Context, jobID, name string, r io.Reader, size int64) error {
+	if err := artifact.ValidateName(name); err != nil {
+		return err
+	}
+	dir := filepath.Join(s.dir, jobID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "error creating artifacts directory %s", dir)
+	}
+	// write to a temporary file first so that a
+	// partially written artifact is never visible
+	f, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return errors.Wrapf(err, "error creating artifact file")
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "error writing artifact %s", name)
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "error writing artifact %s", name)
+	}
+	if err := os.Rename(f.Name(), filepath.Join(dir, name)); err != nil {
+		return errors.Wrapf(err, "error writing artifact %s", name)
+	}
+	return nil
+}
+
+func (s *LocalStore) Get(ctx context.Context, jobID, name string) (io.ReadCloser, error) {
+	if err := artifact.ValidateName(name); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(s.dir, jobID, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, artifact.ErrArtifactNotFound
+		}
+		return nil, errors.Wrapf(err, "error opening artifact %s", name)
+	}
+	return f, nil
+}
+
+func (s *LocalStore) List(ctx context.Context, jobID string) ([]*artifact.Artifact, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, jobID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*artifact.Artifact{}, nil
+		}
+		return nil, errors.Wrapf(err, "error listing artifacts")
+	}
+	result := make([]*artifact.Artifact, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || artifact.ValidateName(e.Name()) != nil {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading artifact %s", e.Name())
+		}
+		modTime := fi.ModTime().UTC()
+		result = append(result, &artifact.Artifact{
+			Name:      e.Name(),
+			Size:      fi.Size(),
+			CreatedAt: &modTime,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
@@ -0,0 +1,69 @@
+package local
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/runabol/tork/artifact"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalStorePutGet(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewLocalStore(t.TempDir())
+	assert.NoError(t, err)
+
+	jobID := uuid.NewUUID()
+	err = s.Put(ctx, jobID, "report.txt", strings.NewReader("hello world"), 11)
+	assert.NoError(t, err)
+
+	r, err := s.Get(ctx, jobID, "report.txt")
+	assert.NoError(t, err)
+	b, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, "hello world", string(b))
+
+	_, err = s.Get(ctx, jobID, "nosuch.txt")
+	assert.ErrorIs(t, err, artifact.ErrArtifactNotFound)
+
+	_, err = s.Get(ctx, uuid.NewUUID(), "report.txt")
+	assert.ErrorIs(t, err, artifact.ErrArtifactNotFound)
+}
+
+func TestLocalStoreInvalidName(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewLocalStore(t.TempDir())
+	assert.NoError(t, err)
+	err = s.Put(ctx, uuid.NewUUID(), "../../etc/passwd", strings.NewReader("bad"), 3)
+	assert.Error(t, err)
+	_, err = s.Get(ctx, uuid.NewUUID(), "../secret")
+	assert.Error(t, err)
+}
+
+func TestLocalStoreList(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewLocalStore(t.TempDir())
+	assert.NoError(t, err)
+
+	jobID := uuid.NewUUID()
+	l, err := s.List(ctx, jobID)
+	assert.NoError(t, err)
+	assert.Len(t, l, 0)
+
+	assert.NoError(t, s.Put(ctx, jobID, "b.txt", strings.NewReader("bb"), 2))
+	assert.NoError(t, s.Put(ctx, jobID, "a.txt", strings.NewReader("a"), 1))
+	assert.NoError(t, s.Put(ctx, uuid.NewUUID(), "c.txt", strings.NewReader("c"), 1))
+
+	l, err = s.List(ctx, jobID)
+	assert.NoError(t, err)
+	assert.Len(t, l, 2)
+	assert.Equal(t, "a.txt", l[0].Name)
+	assert.Equal(t, int64(1), l[0].Size)
+	assert.NotNil(t, l[0].CreatedAt)
+	assert.Equal(t, "b.txt", l[1].Name)
+	assert.Equal(t, int64(2), l[1].Size)
+}
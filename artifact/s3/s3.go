@@ -0,0 +1,115 @@
+package s3
+
+import (
+	"context"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+	"github.com/runabol/tork/artifact"
+)
+
+// S3Store keeps artifacts in an S3-compatible bucket
+// under <prefix>/<job id>/<artifact name>.
+type S3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+type Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	Secure    bool
+}
+
+func NewS3Store(cfg Config) (*S3Store, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("s3 endpoint is required")
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3 bucket is required")
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.Secure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating s3 client")
+	}
+	return &S3Store{
+		client: client,
+		bucket: cfg.Bucket,
+		prefix: strings.Trim(cfg.Prefix, "/"),
+	}, nil
+}
+
+func (s *S3Store) key(parts ...string) string {
+	return path.Join(append([]string{s.prefix}, parts...)...)
+}
+
+func (s *S3Store) Put(ctx context.Context, jobID, name string, r io.Reader, size int64) error {
+	if err := artifact.ValidateName(name); err != nil {
+		return err
+	}
+	_, err := s.client.PutObject(ctx, s.bucket, s.key(jobID, name), r, size, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error uploading artifact %s", name)
+	}
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, jobID, name string) (io.ReadCloser, error) {
+	if err := artifact.ValidateName(name); err != nil {
+		return nil, err
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, s.key(jobID, name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error fetching artifact %s", name)
+	}
+	// GetObject is lazy so we stat the object
+	// to find out whether it actually exists
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, artifact.ErrArtifactNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching artifact %s", name)
+	}
+	return obj, nil
+}
+
+func (s *S3Store) List(ctx context.Context, jobID string) ([]*artifact.Artifact, error) {
+	prefix := s.key(jobID) + "/"
+	result := make([]*artifact.Artifact, 0)
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			return nil, errors.Wrapf(obj.Err, "error listing artifacts")
+		}
+		name := strings.TrimPrefix(obj.Key, prefix)
+		if artifact.ValidateName(name) != nil {
+			continue
+		}
+		lastModified := obj.LastModified.UTC()
+		result = append(result, &artifact.Artifact{
+			Name:      name,
+			Size:      obj.Size,
+			CreatedAt: &lastModified,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
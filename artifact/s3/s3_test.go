@@ -0,0 +1,163 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/runabol/tork/artifact"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeS3 implements just enough of the S3 API
+// to exercise the store: put, get and list.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+type listResult struct {
+	XMLName  xml.Name     `xml:"ListBucketResult"`
+	Name     string       `xml:"Name"`
+	Prefix   string       `xml:"Prefix"`
+	KeyCount int          `xml:"KeyCount"`
+	Contents []listObject `xml:"Contents"`
+}
+
+type listObject struct {
+	Key          string `xml:"Key"`
+	Size         int64  `xml:"Size"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket := parts[0]
+	key := ""
+	if len(parts) > 1 {
+		key = parts[1]
+	}
+	switch {
+	case r.Method == http.MethodPut:
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		f.objects[key] = b
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == http.MethodGet && key == "":
+		prefix := r.URL.Query().Get("prefix")
+		res := listResult{Name: bucket, Prefix: prefix}
+		keys := make([]string, 0)
+		for k := range f.objects {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			res.Contents = append(res.Contents, listObject{
+				Key:          k,
+				Size:         int64(len(f.objects[k])),
+				LastModified: time.Now().UTC().Format(time.RFC3339),
+				ETag:         `"etag"`,
+			})
+		}
+		res.KeyCount = len(res.Contents)
+		w.Header().Set("Content-Type", "application/xml")
+		_ = xml.NewEncoder(w).Encode(res)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		b, ok := f.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				fmt.Fprintf(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message><Key>%s</Key></Error>`, key)
+			}
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(b)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(b)
+		}
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func newTestStore(t *testing.T) (*S3Store, *fakeS3) {
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	s, err := NewS3Store(Config{
+		Endpoint: strings.TrimPrefix(srv.URL, "http://"),
+		Region:   "us-east-1",
+		Bucket:   "artifacts",
+		Prefix:   "/tork/",
+	})
+	assert.NoError(t, err)
+	return s, fake
+}
+
+func TestNewS3StoreConfig(t *testing.T) {
+	_, err := NewS3Store(Config{Bucket: "artifacts"})
+	assert.Error(t, err)
+	_, err = NewS3Store(Config{Endpoint: "localhost:9000"})
+	assert.Error(t, err)
+}
+
+func TestS3StorePutGet(t *testing.T) {
+	ctx := context.Background()
+	s, fake := newTestStore(t)
+
+	jobID := uuid.NewUUID()
+	err := s.Put(ctx, jobID, "report.txt", strings.NewReader("hello world"), 11)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(fake.objects["tork/"+jobID+"/report.txt"]))
+
+	r, err := s.Get(ctx, jobID, "report.txt")
+	assert.NoError(t, err)
+	b, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, "hello world", string(b))
+
+	_, err = s.Get(ctx, jobID, "nosuch.txt")
+	assert.ErrorIs(t, err, artifact.ErrArtifactNotFound)
+
+	err = s.Put(ctx, jobID, "../escape", strings.NewReader("bad"), 3)
+	assert.Error(t, err)
+}
+
+func TestS3StoreList(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t)
+
+	jobID := uuid.NewUUID()
+	assert.NoError(t, s.Put(ctx, jobID, "b.txt", strings.NewReader("bb"), 2))
+	assert.NoError(t, s.Put(ctx, jobID, "a.txt", strings.NewReader("a"), 1))
+	assert.NoError(t, s.Put(ctx, uuid.NewUUID(), "c.txt", strings.NewReader("c"), 1))
+
+	l, err := s.List(ctx, jobID)
+	assert.NoError(t, err)
+	assert.Len(t, l, 2)
+	assert.Equal(t, "a.txt", l[0].Name)
+	assert.Equal(t, int64(1), l[0].Size)
+	assert.Equal(t, "b.txt", l[1].Name)
+	assert.Equal(t, int64(2), l[1].Size)
+}
@@ -6,7 +6,9 @@ import (
 	"github.com/runabol/tork/conf"
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/datastore/postgres"
+	"github.com/runabol/tork/datastore/sqlite"
 	schema "github.com/runabol/tork/db/postgres"
+	sqliteschema "github.com/runabol/tork/db/sqlite"
 	ucli "github.com/urfave/cli/v2"
 )
 
@@ -33,6 +35,17 @@ func migration(ctx *ucli.Context) error {
 		if err := pg.ExecScript(schema.SCHEMA); err != nil {
 			return errors.Wrapf(err, "error when trying to create db schema")
 		}
+	case datastore.DATASTORE_SQLITE:
+		ds, err := sqlite.NewSQLiteDatastore(
+			conf.StringDefault("datastore.sqlite.path", "tork.db"),
+			sqlite.WithDisableCleanup(true),
+		)
+		if err != nil {
+			return err
+		}
+		if err := ds.ExecScript(sqliteschema.SCHEMA); err != nil {
+			return errors.Wrapf(err, "error when trying to create db schema")
+		}
 	default:
 		return errors.Errorf("can't perform db migration on: %s", dstype)
 	}
@@ -2,6 +2,7 @@ package datastore
 
 import (
 	"context"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/runabol/tork"
@@ -16,11 +17,20 @@ var (
 	ErrUserNotFound    = errors.New("user not found")
 	ErrRoleNotFound    = errors.New("role not found")
 	ErrContextNotFound = errors.New("context not found")
+	ErrUserInUse       = errors.New("user is in use")
+	ErrRoleInUse       = errors.New("role is in use")
+
+	ErrDuplicateIdempotencyKey = errors.New("duplicate idempotency key")
+
+	ErrScheduledJobNotFound = errors.New("scheduled job not found")
+	ErrJobTemplateNotFound  = errors.New("job template not found")
+	ErrLibraryTaskNotFound  = errors.New("library task not found")
 )
 
 const (
 	DATASTORE_INMEMORY = "inmemory"
 	DATASTORE_POSTGRES = "postgres"
+	DATASTORE_SQLITE   = "sqlite"
 )
 
 type Datastore interface {
@@ -29,6 +39,9 @@ type Datastore interface {
 	GetTaskByID(ctx context.Context, id string) (*tork.Task, error)
 	GetActiveTasks(ctx context.Context, jobID string) ([]*tork.Task, error)
 	GetNextTask(ctx context.Context, parentTaskID string) (*tork.Task, error)
+	GetReadyTasks(ctx context.Context, jobID string) ([]*tork.Task, error)
+	GetDelayedTasks(ctx context.Context, before time.Time) ([]*tork.Task, error)
+	GetOrphanedTasks(ctx context.Context, heartbeatBefore, scheduledBefore time.Time) ([]*tork.Task, error)
 	CreateTaskLogPart(ctx context.Context, p *tork.TaskLogPart) error
 	GetTaskLogParts(ctx context.Context, taskID, q string, page, size int) (*Page[*tork.TaskLogPart], error)
 
@@ -40,15 +53,40 @@ type Datastore interface {
 	CreateJob(ctx context.Context, j *tork.Job) error
 	UpdateJob(ctx context.Context, id string, modify func(u *tork.Job) error) error
 	GetJobByID(ctx context.Context, id string) (*tork.Job, error)
+	GetJobByIdempotencyKey(ctx context.Context, username, key string) (*tork.Job, error)
 	GetJobLogParts(ctx context.Context, jobID, q string, page, size int) (*Page[*tork.TaskLogPart], error)
 	GetJobs(ctx context.Context, currentUser, q string, page, size int) (*Page[*tork.JobSummary], error)
+	GetJobsByConcurrencyKey(ctx context.Context, key string) ([]*tork.JobSummary, error)
+	LockConcurrencyKey(ctx context.Context, key string) error
+	GetExpiredJobs(ctx context.Context, currentUser string, page, size int) (*Page[*tork.JobSummary], error)
+
+	CreateScheduledJob(ctx context.Context, sj *tork.ScheduledJob) error
+	UpdateScheduledJob(ctx context.Context, id string, modify func(u *tork.ScheduledJob) error) error
+	GetScheduledJobByID(ctx context.Context, id string) (*tork.ScheduledJob, error)
+	GetScheduledJobs(ctx context.Context, page, size int) (*Page[*tork.ScheduledJobSummary], error)
+	GetActiveScheduledJobs(ctx context.Context) ([]*tork.ScheduledJob, error)
+	DeleteScheduledJob(ctx context.Context, id string) error
+
+	CreateJobTemplate(ctx context.Context, t *tork.JobTemplate) error
+	GetJobTemplate(ctx context.Context, name string, version int) (*tork.JobTemplate, error)
+	GetJobTemplates(ctx context.Context, currentUser string, page, size int) (*Page[*tork.JobTemplate], error)
+	DeleteJobTemplate(ctx context.Context, name string, version int) error
+
+	CreateLibraryTask(ctx context.Context, t *tork.LibraryTask) error
+	GetLibraryTask(ctx context.Context, name string, version int) (*tork.LibraryTask, error)
+	GetLibraryTasks(ctx context.Context, page, size int) (*Page[*tork.LibraryTask], error)
+	DeleteLibraryTask(ctx context.Context, name string, version int) error
 
 	CreateUser(ctx context.Context, u *tork.User) error
 	GetUser(ctx context.Context, username string) (*tork.User, error)
+	GetUsers(ctx context.Context, page, size int) (*Page[*tork.User], error)
+	UpdateUser(ctx context.Context, id string, modify func(u *tork.User) error) error
+	DeleteUser(ctx context.Context, id string) error
 
 	CreateRole(ctx context.Context, r *tork.Role) error
 	GetRole(ctx context.Context, id string) (*tork.Role, error)
 	GetRoles(ctx context.Context) ([]*tork.Role, error)
+	DeleteRole(ctx context.Context, id string) error
 	GetUserRoles(ctx context.Context, userID string) ([]*tork.Role, error)
 	AssignRole(ctx context.Context, userID, roleID string) error
 	UnassignRole(ctx context.Context, userID, roleID string) error
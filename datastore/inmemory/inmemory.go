@@ -9,7 +9,9 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/archive"
 
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/internal/cache"
@@ -34,15 +36,24 @@ type InMemoryDatastore struct {
 	tasks           *cache.Cache[*tork.Task]
 	nodes           *cache.Cache[*tork.Node]
 	jobs            *cache.Cache[*tork.Job]
+	scheduledJobs   *cache.Cache[*tork.ScheduledJob]
+	jobTemplates    *cache.Cache[*tork.JobTemplate]
+	jobTemplatesMu  sync.Mutex
+	libraryTasks    *cache.Cache[*tork.LibraryTask]
+	libraryTasksMu  sync.Mutex
 	usersByID       *cache.Cache[*tork.User]
 	usersByUsername *cache.Cache[*tork.User]
 	roles           *cache.Cache[*tork.Role]
 	userRoles       *cache.Cache[[]*tork.UserRole]
 	logs            *cache.Cache[[]*tork.TaskLogPart]
 	logsMu          sync.RWMutex
+	idempotencyMu   sync.Mutex
+	jobLocks        jobLocks
 	nodeExpiration  *time.Duration
 	jobExpiration   *time.Duration
 	cleanupInterval *time.Duration
+	retention       datastore.RetentionPolicy
+	archive         archive.Sink
 }
 
 type Option = func(ds *InMemoryDatastore)
@@ -64,6 +75,20 @@ func WithCleanupInterval(ci time.Duration) Option {
 	}
 }
 
+func WithRetentionPolicy(p datastore.RetentionPolicy) Option {
+	return func(ds *InMemoryDatastore) {
+		ds.retention = p
+	}
+}
+
+// WithArchive sets the sink which expired jobs
+// are archived to before they are evicted.
+func WithArchive(s archive.Sink) Option {
+	return func(ds *InMemoryDatastore) {
+		ds.archive = s
+	}
+}
+
 func NewInMemoryDatastore(opts ...Option) *InMemoryDatastore {
 	ds := &InMemoryDatastore{}
 	for _, opt := range opts {
@@ -80,6 +105,9 @@ func NewInMemoryDatastore(opts ...Option) *InMemoryDatastore {
 	}
 	ds.nodes = cache.New[*tork.Node](nodeExp, ci)
 	ds.jobs = cache.New[*tork.Job](cache.NoExpiration, ci)
+	ds.scheduledJobs = cache.New[*tork.ScheduledJob](cache.NoExpiration, ci)
+	ds.jobTemplates = cache.New[*tork.JobTemplate](cache.NoExpiration, ci)
+	ds.libraryTasks = cache.New[*tork.LibraryTask](cache.NoExpiration, ci)
 	ds.logs = cache.New[[]*tork.TaskLogPart](cache.NoExpiration, ci)
 	ds.usersByID = cache.New[*tork.User](cache.NoExpiration, ci)
 	ds.usersByUsername = cache.New[*tork.User](cache.NoExpiration, ci)
@@ -173,6 +201,13 @@ func (ds *InMemoryDatastore) CreateJob(ctx context.Context, j *tork.Job) error {
 	if j.CreatedBy == nil {
 		j.CreatedBy = guestUser
 	}
+	if j.IdempotencyKey != "" {
+		ds.idempotencyMu.Lock()
+		defer ds.idempotencyMu.Unlock()
+		if _, err := ds.getJobByIdempotencyKey(j.CreatedBy.ID, j.IdempotencyKey); err == nil {
+			return datastore.ErrDuplicateIdempotencyKey
+		}
+	}
 	ds.jobs.Set(j.ID, j.Clone())
 	return nil
 }
@@ -200,19 +235,72 @@ func (ds *InMemoryDatastore) UpdateJob(ctx context.Context, id string, modify fu
 		return datastore.ErrJobNotFound
 	}
 
-	if j.State == tork.JobStateCompleted || j.State == tork.JobStateFailed {
-		exp := defaultJobExpiration
-		if ds.jobExpiration != nil {
-			exp = *ds.jobExpiration
-		}
-		if err := ds.jobs.SetExpiration(j.ID, exp); err != nil {
-			return errors.Wrap(err, "error modifying job expiration")
-		}
+	exp, ok := ds.expirationOf(j)
+	if !ok {
+		// e.g. a finished job which is re-run
+		exp = cache.NoExpiration
+	}
+	if err := ds.jobs.SetExpiration(j.ID, exp); err != nil {
+		return errors.Wrap(err, "error modifying job expiration")
 	}
 
 	return nil
 }
 
+// expirationOf returns how long a finished job is to be kept in
+// memory. Jobs with an autoDelete or matching a retention rule are
+// kept for as long as they say, all other completed or failed jobs
+// are kept for the configured job expiration.
+func (ds *InMemoryDatastore) expirationOf(j *tork.Job) (time.Duration, bool) {
+	if j.DeleteAt != nil {
+		return untilExpired(*j.DeleteAt), true
+	}
+	js := tork.NewJobSummary(j)
+	if r, ok := ds.retention.Match(js); ok {
+		if r.After == nil {
+			return cache.NoExpiration, true
+		}
+		return untilExpired(datastore.FinishedAt(js).Add(*r.After)), true
+	}
+	if j.State == tork.JobStateCompleted || j.State == tork.JobStateFailed {
+		return ds.defaultExpiration(), true
+	}
+	return 0, false
+}
+
+// expiresAt returns the time at which the job is
+// to be expunged, following the same rules as
+// expirationOf.
+func (ds *InMemoryDatastore) expiresAt(j *tork.Job) (time.Time, bool) {
+	if j.DeleteAt != nil {
+		return *j.DeleteAt, true
+	}
+	js := tork.NewJobSummary(j)
+	if _, ok := ds.retention.Match(js); ok {
+		return ds.retention.ExpiresAt(js)
+	}
+	if j.State == tork.JobStateCompleted || j.State == tork.JobStateFailed {
+		return datastore.FinishedAt(js).Add(ds.defaultExpiration()), true
+	}
+	return time.Time{}, false
+}
+
+func (ds *InMemoryDatastore) defaultExpiration() time.Duration {
+	if ds.jobExpiration != nil {
+		return *ds.jobExpiration
+	}
+	return defaultJobExpiration
+}
+
+func untilExpired(t time.Time) time.Duration {
+	exp := time.Until(t)
+	if exp <= 0 {
+		// expire right away
+		return time.Nanosecond
+	}
+	return exp
+}
+
 func (ds *InMemoryDatastore) getExecution(id string) []*tork.Task {
 	execution := make([]*tork.Task, 0)
 	ds.tasks.Iterate(func(_ string, t *tork.Task) {
@@ -228,8 +316,36 @@ func (ds *InMemoryDatastore) GetJobByID(ctx context.Context, id string) (*tork.J
 	if !ok {
 		return nil, datastore.ErrJobNotFound
 	}
+	return ds.withExecution(j), nil
+}
+
+func (ds *InMemoryDatastore) GetJobByIdempotencyKey(ctx context.Context, username, key string) (*tork.Job, error) {
+	u, err := ds.GetUser(ctx, username)
+	if errors.Is(err, datastore.ErrUserNotFound) {
+		return nil, datastore.ErrJobNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	j, err := ds.getJobByIdempotencyKey(u.ID, key)
+	if err != nil {
+		return nil, err
+	}
+	return ds.withExecution(j), nil
+}
+
+func (ds *InMemoryDatastore) getJobByIdempotencyKey(userID, key string) (*tork.Job, error) {
+	result := ds.jobs.List(func(j *tork.Job) bool {
+		return j.IdempotencyKey == key && j.CreatedBy.ID == userID
+	})
+	if len(result) == 0 {
+		return nil, datastore.ErrJobNotFound
+	}
+	return result[0], nil
+}
+
+func (ds *InMemoryDatastore) withExecution(j *tork.Job) *tork.Job {
 	j = j.Clone()
-	execution := ds.getExecution(id)
+	execution := ds.getExecution(j.ID)
 	sort.Slice(execution, func(i, j int) bool {
 		posi := execution[i].Position
 		posj := execution[j].Position
@@ -247,7 +363,7 @@ func (ds *InMemoryDatastore) GetJobByID(ctx context.Context, id string) (*tork.J
 		return ci.Before(*cj)
 	})
 	j.Execution = execution
-	return j.Clone(), nil
+	return j.Clone()
 }
 
 func (ds *InMemoryDatastore) GetActiveTasks(ctx context.Context, jobID string) ([]*tork.Task, error) {
@@ -262,7 +378,7 @@ func (ds *InMemoryDatastore) GetActiveTasks(ctx context.Context, jobID string) (
 
 func (ds *InMemoryDatastore) GetNextTask(ctx context.Context, parentTaskID string) (*tork.Task, error) {
 	result := ds.tasks.List(func(v *tork.Task) bool {
-		return v.ParentID == parentTaskID && v.State == tork.TaskStateCreated
+		return v.ParentID == parentTaskID && v.State == tork.TaskStateCreated && v.NotBefore == nil
 	})
 	if len(result) == 0 {
 		return nil, datastore.ErrTaskNotFound
@@ -270,6 +386,71 @@ func (ds *InMemoryDatastore) GetNextTask(ctx context.Context, parentTaskID strin
 	return result[0], nil
 }
 
+func (ds *InMemoryDatastore) GetDelayedTasks(ctx context.Context, before time.Time) ([]*tork.Task, error) {
+	result := ds.tasks.List(func(t *tork.Task) bool {
+		return t.State == tork.TaskStateCreated && t.NotBefore != nil && !t.NotBefore.After(before)
+	})
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].NotBefore.Before(*result[j].NotBefore)
+	})
+	return result, nil
+}
+
+func (ds *InMemoryDatastore) GetOrphanedTasks(ctx context.Context, heartbeatBefore, scheduledBefore time.Time) ([]*tork.Task, error) {
+	result := ds.tasks.List(func(t *tork.Task) bool {
+		if t.State == tork.TaskStateScheduled && t.ScheduledAt != nil && t.ScheduledAt.Before(scheduledBefore) {
+			return true
+		}
+		if (t.State != tork.TaskStateRunning && t.State != tork.TaskStateScheduled) || t.NodeID == "" {
+			return false
+		}
+		n, ok := ds.nodes.Get(t.NodeID)
+		return !ok || n.LastHeartbeatAt.Before(heartbeatBefore)
+	})
+	sort.Slice(result, func(i, j int) bool {
+		ci, cj := result[i].CreatedAt, result[j].CreatedAt
+		return ci != nil && cj != nil && ci.Before(*cj)
+	})
+	return result, nil
+}
+
+func (ds *InMemoryDatastore) GetReadyTasks(ctx context.Context, jobID string) ([]*tork.Task, error) {
+	j, ok := ds.jobs.Get(jobID)
+	if !ok {
+		return nil, datastore.ErrJobNotFound
+	}
+	created := make(map[int]bool)
+	completed := make(map[string]bool)
+	ds.tasks.Iterate(func(_ string, t *tork.Task) {
+		if t.JobID != jobID || t.ParentID != "" {
+			return
+		}
+		created[t.Position] = true
+		if t.Var != "" && (t.State == tork.TaskStateCompleted || t.State == tork.TaskStateSkipped) {
+			completed[t.Var] = true
+		}
+	})
+	result := make([]*tork.Task, 0)
+	for i, t := range j.Tasks {
+		if created[i+1] {
+			continue
+		}
+		ready := true
+		for _, dep := range t.DependsOn {
+			if !completed[dep] {
+				ready = false
+				break
+			}
+		}
+		if ready {
+			rt := t.Clone()
+			rt.Position = i + 1
+			result = append(result, rt)
+		}
+	}
+	return result, nil
+}
+
 func (ds *InMemoryDatastore) GetJobs(ctx context.Context, currentUser, q string, page, size int) (*datastore.Page[*tork.JobSummary], error) {
 	var urs []*tork.Role
 	var user *tork.User
@@ -289,26 +470,8 @@ func (ds *InMemoryDatastore) GetJobs(ctx context.Context, currentUser, q string,
 	searchTerm, tags := parseQuery(q)
 	offset := (page - 1) * size
 	filtered := make([]*tork.Job, 0)
-	hasPermission := func(user *tork.User, uroles []*tork.Role, job *tork.Job) bool {
-		if len(job.Permissions) == 0 {
-			return true
-		}
-		for _, p := range job.Permissions {
-			if p.User != nil && p.User.Username == user.Username {
-				return true
-			}
-			if p.Role != nil {
-				for _, ur := range uroles {
-					if p.Role.Slug == ur.Slug {
-						return true
-					}
-				}
-			}
-		}
-		return false
-	}
 	ds.jobs.Iterate(func(_ string, j *tork.Job) {
-		if currentUser != "" && !hasPermission(user, urs, j) {
+		if currentUser != "" && !hasPermission(user, urs, j.Permissions) {
 			return
 		}
 		if searchTerm != "" {
@@ -345,6 +508,358 @@ func (ds *InMemoryDatastore) GetJobs(ctx context.Context, currentUser, q string,
 	}, nil
 }
 
+func hasPermission(user *tork.User, uroles []*tork.Role, perms []*tork.Permission) bool {
+	if len(perms) == 0 {
+		return true
+	}
+	for _, p := range perms {
+		if p.User != nil && p.User.Username == user.Username {
+			return true
+		}
+		if p.Role != nil {
+			for _, ur := range uroles {
+				if p.Role.Slug == ur.Slug {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (ds *InMemoryDatastore) GetJobsByConcurrencyKey(ctx context.Context, key string) ([]*tork.JobSummary, error) {
+	result := make([]*tork.JobSummary, 0)
+	ds.jobs.Iterate(func(_ string, j *tork.Job) {
+		if j.Concurrency == nil || j.Concurrency.Key != key {
+			return
+		}
+		switch j.State {
+		case tork.JobStatePending, tork.JobStateScheduled, tork.JobStateRunning, tork.JobStatePaused:
+			result = append(result, tork.NewJobSummary(j))
+		}
+	})
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// LockConcurrencyKey is a no-op outside of a transaction.
+func (ds *InMemoryDatastore) LockConcurrencyKey(ctx context.Context, key string) error {
+	return nil
+}
+
+func (ds *InMemoryDatastore) GetExpiredJobs(ctx context.Context, currentUser string, page, size int) (*datastore.Page[*tork.JobSummary], error) {
+	var urs []*tork.Role
+	var user *tork.User
+	if currentUser != "" {
+		u, err := ds.GetUser(ctx, currentUser)
+		if err != nil {
+			return nil, err
+		}
+		user = u
+		ur, err := ds.GetUserRoles(ctx, u.ID)
+		if err != nil {
+			return nil, err
+		}
+		urs = ur
+	}
+	now := time.Now().UTC()
+	expired := make([]*tork.JobSummary, 0)
+	ds.jobs.Iterate(func(_ string, j *tork.Job) {
+		if currentUser != "" && !hasPermission(user, urs, j.Permissions) {
+			return
+		}
+		if exp, ok := ds.expiresAt(j); ok && exp.Before(now) {
+			expired = append(expired, tork.NewJobSummary(j))
+		}
+	})
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].CreatedAt.Before(expired[j].CreatedAt)
+	})
+	offset := (page - 1) * size
+	result := make([]*tork.JobSummary, 0)
+	for i := offset; i < (offset+size) && i < len(expired); i++ {
+		result = append(result, expired[i])
+	}
+	totalPages := len(expired) / size
+	if len(expired)%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.JobSummary]{
+		Items:      result,
+		Number:     page,
+		Size:       len(result),
+		TotalPages: totalPages,
+		TotalItems: len(expired),
+	}, nil
+}
+
+func (ds *InMemoryDatastore) CreateScheduledJob(ctx context.Context, sj *tork.ScheduledJob) error {
+	if sj.ID == "" {
+		return errors.New("must provide ID")
+	}
+	if sj.CreatedBy == nil {
+		sj.CreatedBy = guestUser
+	}
+	ds.scheduledJobs.Set(sj.ID, sj.Clone())
+	return nil
+}
+
+func (ds *InMemoryDatastore) UpdateScheduledJob(ctx context.Context, id string, modify func(u *tork.ScheduledJob) error) error {
+	_, ok := ds.scheduledJobs.Get(id)
+	if !ok {
+		return datastore.ErrScheduledJobNotFound
+	}
+	return ds.scheduledJobs.Modify(id, func(sj *tork.ScheduledJob) (*tork.ScheduledJob, error) {
+		update := sj.Clone()
+		if err := modify(update); err != nil {
+			return nil, errors.Wrapf(err, "error modifying scheduled job %s", id)
+		}
+		return update, nil
+	})
+}
+
+func (ds *InMemoryDatastore) GetScheduledJobByID(ctx context.Context, id string) (*tork.ScheduledJob, error) {
+	sj, ok := ds.scheduledJobs.Get(id)
+	if !ok {
+		return nil, datastore.ErrScheduledJobNotFound
+	}
+	return sj.Clone(), nil
+}
+
+func (ds *InMemoryDatastore) GetScheduledJobs(ctx context.Context, page, size int) (*datastore.Page[*tork.ScheduledJobSummary], error) {
+	all := ds.scheduledJobs.List()
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].CreatedAt.After(all[j].CreatedAt)
+	})
+	offset := (page - 1) * size
+	result := make([]*tork.ScheduledJobSummary, 0)
+	for i := offset; i < (offset+size) && i < len(all); i++ {
+		result = append(result, tork.NewScheduledJobSummary(all[i]))
+	}
+	totalPages := len(all) / size
+	if len(all)%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.ScheduledJobSummary]{
+		Items:      result,
+		Number:     page,
+		Size:       len(result),
+		TotalPages: totalPages,
+		TotalItems: len(all),
+	}, nil
+}
+
+func (ds *InMemoryDatastore) GetActiveScheduledJobs(ctx context.Context) ([]*tork.ScheduledJob, error) {
+	result := make([]*tork.ScheduledJob, 0)
+	ds.scheduledJobs.Iterate(func(_ string, sj *tork.ScheduledJob) {
+		if sj.State == tork.ScheduledJobStateActive {
+			result = append(result, sj.Clone())
+		}
+	})
+	return result, nil
+}
+
+func (ds *InMemoryDatastore) DeleteScheduledJob(ctx context.Context, id string) error {
+	if _, ok := ds.scheduledJobs.Get(id); !ok {
+		return datastore.ErrScheduledJobNotFound
+	}
+	ds.scheduledJobs.Delete(id)
+	return nil
+}
+
+func (ds *InMemoryDatastore) CreateJobTemplate(ctx context.Context, t *tork.JobTemplate) error {
+	if t.ID == "" {
+		return errors.New("must provide ID")
+	}
+	if t.CreatedBy == nil {
+		t.CreatedBy = guestUser
+	}
+	ds.jobTemplatesMu.Lock()
+	defer ds.jobTemplatesMu.Unlock()
+	latest, err := ds.GetJobTemplate(ctx, t.Name, 0)
+	if err != nil && !errors.Is(err, datastore.ErrJobTemplateNotFound) {
+		return err
+	}
+	t.Version = 1
+	if latest != nil {
+		t.Version = latest.Version + 1
+	}
+	ds.jobTemplates.Set(t.Ref(), t.Clone())
+	return nil
+}
+
+func (ds *InMemoryDatastore) GetJobTemplate(ctx context.Context, name string, version int) (*tork.JobTemplate, error) {
+	var found *tork.JobTemplate
+	ds.jobTemplates.Iterate(func(_ string, t *tork.JobTemplate) {
+		if t.Name != name || (version != 0 && t.Version != version) {
+			return
+		}
+		if found == nil || t.Version > found.Version {
+			found = t
+		}
+	})
+	if found == nil {
+		return nil, datastore.ErrJobTemplateNotFound
+	}
+	return found.Clone(), nil
+}
+
+func (ds *InMemoryDatastore) GetJobTemplates(ctx context.Context, currentUser string, page, size int) (*datastore.Page[*tork.JobTemplate], error) {
+	var urs []*tork.Role
+	var user *tork.User
+	if currentUser != "" {
+		u, err := ds.GetUser(ctx, currentUser)
+		if err != nil {
+			return nil, err
+		}
+		user = u
+		ur, err := ds.GetUserRoles(ctx, u.ID)
+		if err != nil {
+			return nil, err
+		}
+		urs = ur
+	}
+	latest := make(map[string]*tork.JobTemplate)
+	ds.jobTemplates.Iterate(func(_ string, t *tork.JobTemplate) {
+		if l, ok := latest[t.Name]; !ok || t.Version > l.Version {
+			latest[t.Name] = t
+		}
+	})
+	filtered := make([]*tork.JobTemplate, 0)
+	for _, t := range latest {
+		if currentUser != "" && !hasPermission(user, urs, t.Permissions) {
+			continue
+		}
+		filtered = append(filtered, t)
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		return filtered[i].Name < filtered[j].Name
+	})
+	offset := (page - 1) * size
+	result := make([]*tork.JobTemplate, 0)
+	for i := offset; i < (offset+size) && i < len(filtered); i++ {
+		result = append(result, filtered[i].Clone())
+	}
+	totalPages := len(filtered) / size
+	if len(filtered)%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.JobTemplate]{
+		Items:      result,
+		Number:     page,
+		Size:       len(result),
+		TotalPages: totalPages,
+		TotalItems: len(filtered),
+	}, nil
+}
+
+func (ds *InMemoryDatastore) DeleteJobTemplate(ctx context.Context, name string, version int) error {
+	ds.jobTemplatesMu.Lock()
+	defer ds.jobTemplatesMu.Unlock()
+	refs := make([]string, 0)
+	ds.jobTemplates.Iterate(func(ref string, t *tork.JobTemplate) {
+		if t.Name == name && (version == 0 || t.Version == version) {
+			refs = append(refs, ref)
+		}
+	})
+	if len(refs) == 0 {
+		return datastore.ErrJobTemplateNotFound
+	}
+	for _, ref := range refs {
+		ds.jobTemplates.Delete(ref)
+	}
+	return nil
+}
+
+func (ds *InMemoryDatastore) CreateLibraryTask(ctx context.Context, t *tork.LibraryTask) error {
+	if t.ID == "" {
+		return errors.New("must provide ID")
+	}
+	if t.CreatedBy == nil {
+		t.CreatedBy = guestUser
+	}
+	ds.libraryTasksMu.Lock()
+	defer ds.libraryTasksMu.Unlock()
+	latest, err := ds.GetLibraryTask(ctx, t.Name, 0)
+	if err != nil && !errors.Is(err, datastore.ErrLibraryTaskNotFound) {
+		return err
+	}
+	t.Version = 1
+	if latest != nil {
+		t.Version = latest.Version + 1
+	}
+	ds.libraryTasks.Set(t.Ref(), t.Clone())
+	return nil
+}
+
+func (ds *InMemoryDatastore) GetLibraryTask(ctx context.Context, name string, version int) (*tork.LibraryTask, error) {
+	var found *tork.LibraryTask
+	ds.libraryTasks.Iterate(func(_ string, t *tork.LibraryTask) {
+		if t.Name != name || (version != 0 && t.Version != version) {
+			return
+		}
+		if found == nil || t.Version > found.Version {
+			found = t
+		}
+	})
+	if found == nil {
+		return nil, datastore.ErrLibraryTaskNotFound
+	}
+	return found.Clone(), nil
+}
+
+func (ds *InMemoryDatastore) GetLibraryTasks(ctx context.Context, page, size int) (*datastore.Page[*tork.LibraryTask], error) {
+	latest := make(map[string]*tork.LibraryTask)
+	ds.libraryTasks.Iterate(func(_ string, t *tork.LibraryTask) {
+		if l, ok := latest[t.Name]; !ok || t.Version > l.Version {
+			latest[t.Name] = t
+		}
+	})
+	all := make([]*tork.LibraryTask, 0, len(latest))
+	for _, t := range latest {
+		all = append(all, t)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name < all[j].Name
+	})
+	offset := (page - 1) * size
+	result := make([]*tork.LibraryTask, 0)
+	for i := offset; i < (offset+size) && i < len(all); i++ {
+		result = append(result, all[i].Clone())
+	}
+	totalPages := len(all) / size
+	if len(all)%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.LibraryTask]{
+		Items:      result,
+		Number:     page,
+		Size:       len(result),
+		TotalPages: totalPages,
+		TotalItems: len(all),
+	}, nil
+}
+
+func (ds *InMemoryDatastore) DeleteLibraryTask(ctx context.Context, name string, version int) error {
+	ds.libraryTasksMu.Lock()
+	defer ds.libraryTasksMu.Unlock()
+	refs := make([]string, 0)
+	ds.libraryTasks.Iterate(func(ref string, t *tork.LibraryTask) {
+		if t.Name == name && (version == 0 || t.Version == version) {
+			refs = append(refs, ref)
+		}
+	})
+	if len(refs) == 0 {
+		return datastore.ErrLibraryTaskNotFound
+	}
+	for _, ref := range refs {
+		ds.libraryTasks.Delete(ref)
+	}
+	return nil
+}
+
 func (ds *InMemoryDatastore) CreateTaskLogPart(ctx context.Context, p *tork.TaskLogPart) error {
 	if p.TaskID == "" {
 		return errors.Errorf("must provide task id")
@@ -543,6 +1058,64 @@ func (ds *InMemoryDatastore) CreateUser(ctx context.Context, u *tork.User) error
 	return nil
 }
 
+func (ds *InMemoryDatastore) GetUsers(ctx context.Context, page, size int) (*datastore.Page[*tork.User], error) {
+	all := ds.usersByID.List()
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Username < all[j].Username
+	})
+	offset := (page - 1) * size
+	result := make([]*tork.User, 0)
+	for i := offset; i < (offset+size) && i < len(all); i++ {
+		result = append(result, all[i].Clone())
+	}
+	totalPages := len(all) / size
+	if len(all)%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.User]{
+		Items:      result,
+		Number:     page,
+		Size:       len(result),
+		TotalPages: totalPages,
+		TotalItems: len(all),
+	}, nil
+}
+
+func (ds *InMemoryDatastore) UpdateUser(ctx context.Context, id string, modify func(u *tork.User) error) error {
+	u, ok := ds.usersByID.Get(id)
+	if !ok {
+		return datastore.ErrUserNotFound
+	}
+	update := u.Clone()
+	if err := modify(update); err != nil {
+		return errors.Wrapf(err, "error modifying user %s", id)
+	}
+	// the username is immutable
+	update.Username = u.Username
+	ds.usersByID.Set(id, update)
+	ds.usersByUsername.Set(update.Username, update)
+	return nil
+}
+
+func (ds *InMemoryDatastore) DeleteUser(ctx context.Context, id string) error {
+	u, ok := ds.usersByID.Get(id)
+	if !ok {
+		return datastore.ErrUserNotFound
+	}
+	// like the SQL datastores' foreign keys, keep users
+	// who are still referenced by other records
+	if ds.isReferenced(func(createdBy *tork.User, perm *tork.Permission) bool {
+		return (createdBy != nil && createdBy.ID == id) ||
+			(perm != nil && perm.User != nil && perm.User.ID == id)
+	}) {
+		return datastore.ErrUserInUse
+	}
+	ds.usersByID.Delete(u.ID)
+	ds.usersByUsername.Delete(u.Username)
+	ds.userRoles.Delete(u.ID)
+	return nil
+}
+
 func (ds *InMemoryDatastore) CreateRole(ctx context.Context, r *tork.Role) error {
 	r.ID = uuid.NewUUID()
 	now := time.Now().UTC()
@@ -562,6 +1135,65 @@ func (ds *InMemoryDatastore) GetRoles(ctx context.Context) ([]*tork.Role, error)
 	return roles, nil
 }
 
+func (ds *InMemoryDatastore) DeleteRole(ctx context.Context, id string) error {
+	if _, ok := ds.roles.Get(id); !ok {
+		return datastore.ErrRoleNotFound
+	}
+	if ds.isReferenced(func(_ *tork.User, perm *tork.Permission) bool {
+		return perm != nil && perm.Role != nil && perm.Role.ID == id
+	}) {
+		return datastore.ErrRoleInUse
+	}
+	ds.roles.Delete(id)
+	updates := make(map[string][]*tork.UserRole)
+	ds.userRoles.Iterate(func(userID string, urs []*tork.UserRole) {
+		nurs := make([]*tork.UserRole, 0)
+		for _, ur := range urs {
+			if ur.RoleID != id {
+				nurs = append(nurs, ur)
+			}
+		}
+		if len(nurs) != len(urs) {
+			updates[userID] = nurs
+		}
+	})
+	for userID, nurs := range updates {
+		ds.userRoles.Set(userID, nurs)
+	}
+	return nil
+}
+
+// isReferenced returns true if the creator or any of the
+// permissions of a job, scheduled job, job template or
+// library task match.
+func (ds *InMemoryDatastore) isReferenced(match func(createdBy *tork.User, perm *tork.Permission) bool) bool {
+	matches := func(createdBy *tork.User, perms []*tork.Permission) bool {
+		if match(createdBy, nil) {
+			return true
+		}
+		for _, p := range perms {
+			if match(nil, p) {
+				return true
+			}
+		}
+		return false
+	}
+	found := false
+	ds.jobs.Iterate(func(_ string, j *tork.Job) {
+		found = found || matches(j.CreatedBy, j.Permissions)
+	})
+	ds.scheduledJobs.Iterate(func(_ string, sj *tork.ScheduledJob) {
+		found = found || matches(sj.CreatedBy, sj.Permissions)
+	})
+	ds.jobTemplates.Iterate(func(_ string, t *tork.JobTemplate) {
+		found = found || matches(t.CreatedBy, t.Permissions)
+	})
+	ds.libraryTasks.Iterate(func(_ string, t *tork.LibraryTask) {
+		found = found || matches(t.CreatedBy, nil)
+	})
+	return found
+}
+
 func (ds *InMemoryDatastore) AssignRole(ctx context.Context, userID, roleID string) error {
 	now := time.Now().UTC()
 	ur := &tork.UserRole{
@@ -611,10 +1243,20 @@ func (ds *InMemoryDatastore) GetUserRoles(ctx context.Context, userID string) ([
 }
 
 func (ds *InMemoryDatastore) WithTx(ctx context.Context, f func(tx datastore.Datastore) error) error {
-	return f(ds)
+	tx := &txDatastore{
+		InMemoryDatastore: ds,
+		locked:            make(map[string]bool),
+	}
+	defer tx.release()
+	return f(tx)
 }
 
 func (ds *InMemoryDatastore) onJobEviction(s string, job *tork.Job) {
+	if ds.archive != nil {
+		if err := ds.archiveJob(job); err != nil {
+			log.Error().Err(err).Msgf("error archiving job %s", job.ID)
+		}
+	}
 	tasks := make([]*tork.Task, 0)
 	ds.tasks.Iterate(func(_ string, t *tork.Task) {
 		if t.JobID == job.ID {
@@ -626,6 +1268,23 @@ func (ds *InMemoryDatastore) onJobEviction(s string, job *tork.Job) {
 	}
 }
 
+func (ds *InMemoryDatastore) archiveJob(job *tork.Job) error {
+	j := ds.withExecution(job)
+	logs := make([]*tork.TaskLogPart, 0)
+	ds.logsMu.RLock()
+	for _, t := range j.Execution {
+		if parts, ok := ds.logs.Get(t.ID); ok {
+			logs = append(logs, parts...)
+		}
+	}
+	ds.logsMu.RUnlock()
+	return archive.Write(context.Background(), ds.archive, &archive.Bundle{
+		Job:        j,
+		Logs:       logs,
+		ArchivedAt: time.Now().UTC(),
+	})
+}
+
 func (ds *InMemoryDatastore) HealthCheck(ctx context.Context) error {
 	return nil
 }
@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/archive"
+	"github.com/runabol/tork/archive/local"
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/datastore/inmemory"
 
@@ -223,6 +225,53 @@ func TestInMemoryUpdateJobConcurrently(t *testing.T) {
 	assert.Equal(t, 1000, j2.TaskCount)
 }
 
+func TestInMemoryWithTxLocksJob(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err := ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+
+	w := sync.WaitGroup{}
+	w.Add(100)
+	for i := 0; i < 100; i++ {
+		go func() {
+			defer w.Done()
+			err := ds.WithTx(ctx, func(tx datastore.Datastore) error {
+				if err := tx.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+					return nil
+				}); err != nil {
+					return err
+				}
+				// only the first transaction to lock the
+				// job should see that no task exists
+				active, err := tx.GetActiveTasks(ctx, j1.ID)
+				if err != nil {
+					return err
+				}
+				if len(active) > 0 {
+					return nil
+				}
+				time.Sleep(time.Millisecond)
+				return tx.CreateTask(ctx, &tork.Task{
+					ID:    uuid.NewUUID(),
+					JobID: j1.ID,
+					State: tork.TaskStatePending,
+				})
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	w.Wait()
+
+	active, err := ds.GetActiveTasks(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Len(t, active, 1)
+}
+
 func TestInMemoryCreateAndGetNode(t *testing.T) {
 	ctx := context.Background()
 	ds := inmemory.NewInMemoryDatastore()
@@ -753,6 +802,167 @@ func TestInMemoryCreateRole(t *testing.T) {
 	assert.Len(t, uroles, 0)
 }
 
+func TestInMemoryDeleteRole(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	r := &tork.Role{
+		Slug: "test-role",
+		Name: "Test Role",
+	}
+	err := ds.CreateRole(ctx, r)
+	assert.NoError(t, err)
+
+	u := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: uuid.NewShortUUID(),
+	}
+	err = ds.CreateUser(ctx, u)
+	assert.NoError(t, err)
+
+	err = ds.AssignRole(ctx, u.ID, r.ID)
+	assert.NoError(t, err)
+
+	err = ds.DeleteRole(ctx, r.ID)
+	assert.NoError(t, err)
+
+	_, err = ds.GetRole(ctx, r.ID)
+	assert.ErrorIs(t, err, datastore.ErrRoleNotFound)
+
+	uroles, err := ds.GetUserRoles(ctx, u.ID)
+	assert.NoError(t, err)
+	assert.Len(t, uroles, 0)
+
+	err = ds.DeleteRole(ctx, r.ID)
+	assert.ErrorIs(t, err, datastore.ErrRoleNotFound)
+
+	// roles which are granted permissions can't be deleted
+	r2 := &tork.Role{
+		Slug: "other-role",
+		Name: "Other Role",
+	}
+	err = ds.CreateRole(ctx, r2)
+	assert.NoError(t, err)
+	err = ds.CreateJob(ctx, &tork.Job{
+		ID:          uuid.NewUUID(),
+		Permissions: []*tork.Permission{{Role: r2}},
+	})
+	assert.NoError(t, err)
+	err = ds.DeleteRole(ctx, r2.ID)
+	assert.ErrorIs(t, err, datastore.ErrRoleInUse)
+}
+
+func TestInMemoryGetUsers(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	for i := 0; i < 5; i++ {
+		err := ds.CreateUser(ctx, &tork.User{
+			ID:       uuid.NewUUID(),
+			Username: fmt.Sprintf("user-%d", i),
+		})
+		assert.NoError(t, err)
+	}
+	p1, err := ds.GetUsers(ctx, 1, 2)
+	assert.NoError(t, err)
+	assert.Len(t, p1.Items, 2)
+	assert.Equal(t, 3, p1.TotalPages)
+	assert.Equal(t, 5, p1.TotalItems)
+	assert.Equal(t, "user-0", p1.Items[0].Username)
+
+	p3, err := ds.GetUsers(ctx, 3, 2)
+	assert.NoError(t, err)
+	assert.Len(t, p3.Items, 1)
+	assert.Equal(t, "user-4", p3.Items[0].Username)
+}
+
+func TestInMemoryUpdateUser(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	u := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: uuid.NewShortUUID(),
+		Name:     "Tester",
+	}
+	err := ds.CreateUser(ctx, u)
+	assert.NoError(t, err)
+
+	err = ds.UpdateUser(ctx, u.ID, func(u *tork.User) error {
+		u.Disabled = true
+		u.PasswordHash = "some-hash"
+		u.Username = "other"
+		return nil
+	})
+	assert.NoError(t, err)
+
+	u2, err := ds.GetUser(ctx, u.Username)
+	assert.NoError(t, err)
+	assert.True(t, u2.Disabled)
+	assert.Equal(t, "some-hash", u2.PasswordHash)
+	assert.Equal(t, u.Username, u2.Username)
+
+	_, err = ds.GetUser(ctx, "other")
+	assert.ErrorIs(t, err, datastore.ErrUserNotFound)
+
+	err = ds.UpdateUser(ctx, "no-such-user", func(u *tork.User) error {
+		return nil
+	})
+	assert.ErrorIs(t, err, datastore.ErrUserNotFound)
+}
+
+func TestInMemoryDeleteUser(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	u := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: uuid.NewShortUUID(),
+	}
+	err := ds.CreateUser(ctx, u)
+	assert.NoError(t, err)
+
+	err = ds.DeleteUser(ctx, u.ID)
+	assert.NoError(t, err)
+
+	_, err = ds.GetUser(ctx, u.ID)
+	assert.ErrorIs(t, err, datastore.ErrUserNotFound)
+	_, err = ds.GetUser(ctx, u.Username)
+	assert.ErrorIs(t, err, datastore.ErrUserNotFound)
+
+	err = ds.DeleteUser(ctx, u.ID)
+	assert.ErrorIs(t, err, datastore.ErrUserNotFound)
+
+	// users who created jobs can't be deleted
+	u2 := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: uuid.NewShortUUID(),
+	}
+	err = ds.CreateUser(ctx, u2)
+	assert.NoError(t, err)
+	err = ds.CreateJob(ctx, &tork.Job{
+		ID:        uuid.NewUUID(),
+		CreatedBy: u2,
+	})
+	assert.NoError(t, err)
+	err = ds.DeleteUser(ctx, u2.ID)
+	assert.ErrorIs(t, err, datastore.ErrUserInUse)
+
+	// neither can users who are granted permissions
+	u3 := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: uuid.NewShortUUID(),
+	}
+	err = ds.CreateUser(ctx, u3)
+	assert.NoError(t, err)
+	err = ds.CreateJobTemplate(ctx, &tork.JobTemplate{
+		ID:          uuid.NewUUID(),
+		Name:        "some-template",
+		Permissions: []*tork.Permission{{User: u3}},
+	})
+	assert.NoError(t, err)
+	err = ds.DeleteUser(ctx, u3.ID)
+	assert.ErrorIs(t, err, datastore.ErrUserInUse)
+	_, err = ds.GetUser(ctx, u3.ID)
+	assert.NoError(t, err)
+}
+
 func TestInMemoryGetNextTask(t *testing.T) {
 	ctx := context.Background()
 	ds := inmemory.NewInMemoryDatastore()
@@ -780,3 +990,643 @@ func TestInMemoryGetNextTask(t *testing.T) {
 	_, err = ds.GetNextTask(ctx, "no-such-id")
 	assert.Error(t, err)
 }
+
+func TestInMemoryGetJobsByConcurrencyKey(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	now := time.Now().UTC()
+	states := []tork.JobState{
+		tork.JobStateRunning,
+		tork.JobStatePending,
+		tork.JobStateCompleted,
+	}
+	for i, state := range states {
+		err := ds.CreateJob(ctx, &tork.Job{
+			ID:          uuid.NewUUID(),
+			State:       state,
+			CreatedAt:   now.Add(time.Duration(i) * time.Second),
+			Concurrency: &tork.JobConcurrency{Key: "deploy-prod", Limit: 1},
+		})
+		assert.NoError(t, err)
+	}
+	err := ds.CreateJob(ctx, &tork.Job{
+		ID:          uuid.NewUUID(),
+		State:       tork.JobStateRunning,
+		CreatedAt:   now,
+		Concurrency: &tork.JobConcurrency{Key: "deploy-dev", Limit: 1},
+	})
+	assert.NoError(t, err)
+
+	jobs, err := ds.GetJobsByConcurrencyKey(ctx, "deploy-prod")
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 2)
+	assert.Equal(t, tork.JobStateRunning, jobs[0].State)
+	assert.Equal(t, tork.JobStatePending, jobs[1].State)
+
+	jobs, err = ds.GetJobsByConcurrencyKey(ctx, "no-such-key")
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 0)
+}
+
+func TestInMemoryGetJobByIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	key := uuid.NewUUID()
+	j1 := &tork.Job{
+		ID:             uuid.NewUUID(),
+		State:          tork.JobStatePending,
+		CreatedAt:      time.Now().UTC(),
+		IdempotencyKey: key,
+	}
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	j2, err := ds.GetJobByIdempotencyKey(ctx, tork.USER_GUEST, key)
+	assert.NoError(t, err)
+	assert.Equal(t, j1.ID, j2.ID)
+	assert.Equal(t, key, j2.IdempotencyKey)
+
+	_, err = ds.GetJobByIdempotencyKey(ctx, tork.USER_GUEST, "no-such-key")
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+
+	// the key is taken
+	err = ds.CreateJob(ctx, &tork.Job{
+		ID:             uuid.NewUUID(),
+		State:          tork.JobStatePending,
+		CreatedAt:      time.Now().UTC(),
+		IdempotencyKey: key,
+	})
+	assert.ErrorIs(t, err, datastore.ErrDuplicateIdempotencyKey)
+
+	// release the key
+	err = ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+		u.IdempotencyKey = ""
+		return nil
+	})
+	assert.NoError(t, err)
+
+	j3 := &tork.Job{
+		ID:             uuid.NewUUID(),
+		State:          tork.JobStatePending,
+		CreatedAt:      time.Now().UTC(),
+		IdempotencyKey: key,
+	}
+	err = ds.CreateJob(ctx, j3)
+	assert.NoError(t, err)
+
+	j2, err = ds.GetJobByIdempotencyKey(ctx, tork.USER_GUEST, key)
+	assert.NoError(t, err)
+	assert.Equal(t, j3.ID, j2.ID)
+
+	// keys are scoped to the user who submitted the job
+	u1 := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: uuid.NewShortUUID(),
+		Name:     "Tester",
+	}
+	err = ds.CreateUser(ctx, u1)
+	assert.NoError(t, err)
+
+	_, err = ds.GetJobByIdempotencyKey(ctx, u1.Username, key)
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+
+	j4 := &tork.Job{
+		ID:             uuid.NewUUID(),
+		State:          tork.JobStatePending,
+		CreatedAt:      time.Now().UTC(),
+		CreatedBy:      u1,
+		IdempotencyKey: key,
+	}
+	err = ds.CreateJob(ctx, j4)
+	assert.NoError(t, err)
+
+	j2, err = ds.GetJobByIdempotencyKey(ctx, u1.Username, key)
+	assert.NoError(t, err)
+	assert.Equal(t, j4.ID, j2.ID)
+
+	j2, err = ds.GetJobByIdempotencyKey(ctx, tork.USER_GUEST, key)
+	assert.NoError(t, err)
+	assert.Equal(t, j3.ID, j2.ID)
+}
+
+func TestInMemoryScheduledJobs(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	now := time.Now().UTC()
+	next := now.Add(time.Minute)
+	sj := &tork.ScheduledJob{
+		ID:        uuid.NewUUID(),
+		Name:      "test scheduled job",
+		Cron:      "* * * * *",
+		State:     tork.ScheduledJobStateActive,
+		CreatedAt: now,
+		NextRunAt: &next,
+		Tasks: []*tork.Task{{
+			Name: "task-1",
+		}},
+	}
+	err := ds.CreateScheduledJob(ctx, sj)
+	assert.NoError(t, err)
+
+	sj2, err := ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, sj.Name, sj2.Name)
+	assert.Len(t, sj2.Tasks, 1)
+
+	p, err := ds.GetScheduledJobs(ctx, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, p.TotalItems)
+
+	active, err := ds.GetActiveScheduledJobs(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, active, 1)
+
+	err = ds.UpdateScheduledJob(ctx, sj.ID, func(u *tork.ScheduledJob) error {
+		u.State = tork.ScheduledJobStatePaused
+		return nil
+	})
+	assert.NoError(t, err)
+
+	active, err = ds.GetActiveScheduledJobs(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, active, 0)
+
+	err = ds.DeleteScheduledJob(ctx, sj.ID)
+	assert.NoError(t, err)
+
+	_, err = ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.ErrorIs(t, err, datastore.ErrScheduledJobNotFound)
+
+	err = ds.DeleteScheduledJob(ctx, sj.ID)
+	assert.ErrorIs(t, err, datastore.ErrScheduledJobNotFound)
+}
+
+func TestInMemoryGetReadyTasks(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	j1 := &tork.Job{
+		ID: uuid.NewUUID(),
+		Tasks: []*tork.Task{
+			{Name: "task-a", Var: "a"},
+			{Name: "task-b", Var: "b", DependsOn: []string{"a"}},
+			{Name: "task-c", Var: "c"},
+		},
+	}
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	ready, err := ds.GetReadyTasks(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Len(t, ready, 2)
+	assert.Equal(t, "task-a", ready[0].Name)
+	assert.Equal(t, 1, ready[0].Position)
+	assert.Equal(t, "task-c", ready[1].Name)
+	assert.Equal(t, 3, ready[1].Position)
+
+	now := time.Now().UTC()
+	err = ds.CreateTask(ctx, &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		Position:  1,
+		Var:       "a",
+		State:     tork.TaskStateCompleted,
+		CreatedAt: &now,
+	})
+	assert.NoError(t, err)
+
+	ready, err = ds.GetReadyTasks(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Len(t, ready, 2)
+	assert.Equal(t, "task-b", ready[0].Name)
+	assert.Equal(t, 2, ready[0].Position)
+	assert.Equal(t, "task-c", ready[1].Name)
+}
+
+func TestInMemoryRetentionPolicy(t *testing.T) {
+	ctx := context.Background()
+	keep, err := datastore.NewRetentionRule("", "audit", "forever")
+	assert.NoError(t, err)
+	failed, err := datastore.NewRetentionRule("FAILED", "", "30d")
+	assert.NoError(t, err)
+	ds := inmemory.NewInMemoryDatastore(
+		inmemory.WithCleanupInterval(time.Millisecond*20),
+		inmemory.WithJobExpiration(time.Millisecond*10),
+		inmemory.WithRetentionPolicy(datastore.RetentionPolicy{keep, failed}),
+	)
+
+	audited := &tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateRunning,
+		Tags:  []string{"audit"},
+	}
+	err = ds.CreateJob(ctx, audited)
+	assert.NoError(t, err)
+
+	oldFailed := &tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateRunning,
+	}
+	err = ds.CreateJob(ctx, oldFailed)
+	assert.NoError(t, err)
+
+	newFailed := &tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateRunning,
+	}
+	err = ds.CreateJob(ctx, newFailed)
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+	err = ds.UpdateJob(ctx, audited.ID, func(u *tork.Job) error {
+		u.State = tork.JobStateCompleted
+		u.CompletedAt = &now
+		return nil
+	})
+	assert.NoError(t, err)
+
+	longAgo := now.Add(-time.Hour * 24 * 40)
+	err = ds.UpdateJob(ctx, oldFailed.ID, func(u *tork.Job) error {
+		u.State = tork.JobStateFailed
+		u.FailedAt = &longAgo
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = ds.UpdateJob(ctx, newFailed.ID, func(u *tork.Job) error {
+		u.State = tork.JobStateFailed
+		u.FailedAt = &now
+		return nil
+	})
+	assert.NoError(t, err)
+
+	time.Sleep(time.Millisecond * 100)
+
+	// kept forever even though the
+	// job expiration already passed
+	_, err = ds.GetJobByID(ctx, audited.ID)
+	assert.NoError(t, err)
+
+	_, err = ds.GetJobByID(ctx, oldFailed.ID)
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+
+	_, err = ds.GetJobByID(ctx, newFailed.ID)
+	assert.NoError(t, err)
+
+	p, err := ds.GetExpiredJobs(ctx, "", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, p.TotalItems)
+}
+
+func TestInMemoryArchiveExpiredJob(t *testing.T) {
+	ctx := context.Background()
+	sink, err := local.NewLocalSink(t.TempDir())
+	assert.NoError(t, err)
+	ds := inmemory.NewInMemoryDatastore(
+		inmemory.WithCleanupInterval(time.Millisecond*20),
+		inmemory.WithJobExpiration(time.Millisecond*10),
+		inmemory.WithArchive(sink),
+	)
+	j := &tork.Job{
+		ID:    uuid.NewUUID(),
+		Name:  "test job",
+		State: tork.JobStateRunning,
+	}
+	err = ds.CreateJob(ctx, j)
+	assert.NoError(t, err)
+
+	ta := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Name:  "test task",
+		JobID: j.ID,
+	}
+	err = ds.CreateTask(ctx, ta)
+	assert.NoError(t, err)
+
+	err = ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+		Number:   1,
+		TaskID:   ta.ID,
+		Contents: "hello world",
+	})
+	assert.NoError(t, err)
+
+	err = ds.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
+		u.State = tork.JobStateCompleted
+		return nil
+	})
+	assert.NoError(t, err)
+
+	time.Sleep(time.Millisecond * 100)
+
+	_, err = ds.GetJobByID(ctx, j.ID)
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+
+	b, err := archive.Read(ctx, sink, j.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "test job", b.Job.Name)
+	assert.Len(t, b.Job.Execution, 1)
+	assert.Len(t, b.Logs, 1)
+	assert.Equal(t, "hello world", b.Logs[0].Contents)
+}
+
+func TestInMemoryJobTemplates(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	var err error
+
+	u1 := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: uuid.NewShortUUID(),
+		Name:     "Tester",
+	}
+	err = ds.CreateUser(ctx, u1)
+	assert.NoError(t, err)
+
+	u2 := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: uuid.NewShortUUID(),
+		Name:     "Tester",
+	}
+	err = ds.CreateUser(ctx, u2)
+	assert.NoError(t, err)
+
+	r := &tork.Role{
+		Slug: uuid.NewShortUUID(),
+		Name: "Test Role",
+	}
+	err = ds.CreateRole(ctx, r)
+	assert.NoError(t, err)
+
+	err = ds.AssignRole(ctx, u2.ID, r.ID)
+	assert.NoError(t, err)
+
+	name := uuid.NewShortUUID()
+	for i := 0; i < 2; i++ {
+		tk := &tork.JobTemplate{
+			ID:          uuid.NewUUID(),
+			Name:        name,
+			Description: fmt.Sprintf("version %d", i+1),
+			CreatedAt:   time.Now().UTC(),
+			CreatedBy:   u1,
+			Job:         []byte(`{"name":"test job"}`),
+		}
+		err = ds.CreateJobTemplate(ctx, tk)
+		assert.NoError(t, err)
+		assert.Equal(t, i+1, tk.Version)
+	}
+
+	private := &tork.JobTemplate{
+		ID:          uuid.NewUUID(),
+		Name:        uuid.NewShortUUID(),
+		CreatedAt:   time.Now().UTC(),
+		CreatedBy:   u1,
+		Permissions: []*tork.Permission{{Role: r}},
+		Job:         []byte(`{"name":"private job"}`),
+	}
+	err = ds.CreateJobTemplate(ctx, private)
+	assert.NoError(t, err)
+
+	latest, err := ds.GetJobTemplate(ctx, name, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, latest.Version)
+	assert.Equal(t, "version 2", latest.Description)
+	assert.Equal(t, u1.Username, latest.CreatedBy.Username)
+	assert.JSONEq(t, `{"name":"test job"}`, string(latest.Job))
+
+	v1, err := ds.GetJobTemplate(ctx, name, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "version 1", v1.Description)
+
+	_, err = ds.GetJobTemplate(ctx, name, 3)
+	assert.ErrorIs(t, err, datastore.ErrJobTemplateNotFound)
+
+	p, err := ds.GetJobTemplates(ctx, u2.Username, 1, 100)
+	assert.NoError(t, err)
+	refs := make([]string, 0)
+	for _, item := range p.Items {
+		refs = append(refs, item.Ref())
+	}
+	assert.Contains(t, refs, name+"@2")
+	assert.NotContains(t, refs, name+"@1")
+	assert.Contains(t, refs, private.Ref())
+
+	p, err = ds.GetJobTemplates(ctx, u1.Username, 1, 100)
+	assert.NoError(t, err)
+	refs = make([]string, 0)
+	for _, item := range p.Items {
+		refs = append(refs, item.Ref())
+	}
+	assert.Contains(t, refs, name+"@2")
+	assert.NotContains(t, refs, private.Ref())
+
+	err = ds.DeleteJobTemplate(ctx, name, 2)
+	assert.NoError(t, err)
+
+	latest, err = ds.GetJobTemplate(ctx, name, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, latest.Version)
+
+	err = ds.DeleteJobTemplate(ctx, name, 0)
+	assert.NoError(t, err)
+
+	_, err = ds.GetJobTemplate(ctx, name, 0)
+	assert.ErrorIs(t, err, datastore.ErrJobTemplateNotFound)
+
+	err = ds.DeleteJobTemplate(ctx, name, 0)
+	assert.ErrorIs(t, err, datastore.ErrJobTemplateNotFound)
+}
+
+func TestInMemoryLibraryTasks(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	var err error
+
+	name := uuid.NewShortUUID()
+	for i := 0; i < 2; i++ {
+		lt := &tork.LibraryTask{
+			ID:          uuid.NewUUID(),
+			Name:        name,
+			Description: fmt.Sprintf("version %d", i+1),
+			CreatedAt:   time.Now().UTC(),
+			Params:      []byte(`{"path":{"required":true}}`),
+			Task:        []byte(`{"name":"lint","image":"golangci/golangci-lint"}`),
+		}
+		err = ds.CreateLibraryTask(ctx, lt)
+		assert.NoError(t, err)
+		assert.Equal(t, i+1, lt.Version)
+	}
+
+	latest, err := ds.GetLibraryTask(ctx, name, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, latest.Version)
+	assert.Equal(t, "version 2", latest.Description)
+	assert.NotNil(t, latest.CreatedBy)
+	assert.JSONEq(t, `{"path":{"required":true}}`, string(latest.Params))
+	assert.JSONEq(t, `{"name":"lint","image":"golangci/golangci-lint"}`, string(latest.Task))
+
+	v1, err := ds.GetLibraryTask(ctx, name, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "version 1", v1.Description)
+
+	_, err = ds.GetLibraryTask(ctx, name, 3)
+	assert.ErrorIs(t, err, datastore.ErrLibraryTaskNotFound)
+
+	p, err := ds.GetLibraryTasks(ctx, 1, 100)
+	assert.NoError(t, err)
+	refs := make([]string, 0)
+	for _, item := range p.Items {
+		refs = append(refs, item.Ref())
+	}
+	assert.Contains(t, refs, name+"@2")
+	assert.NotContains(t, refs, name+"@1")
+
+	err = ds.DeleteLibraryTask(ctx, name, 2)
+	assert.NoError(t, err)
+
+	latest, err = ds.GetLibraryTask(ctx, name, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, latest.Version)
+
+	err = ds.DeleteLibraryTask(ctx, name, 0)
+	assert.NoError(t, err)
+
+	_, err = ds.GetLibraryTask(ctx, name, 0)
+	assert.ErrorIs(t, err, datastore.ErrLibraryTaskNotFound)
+
+	err = ds.DeleteLibraryTask(ctx, name, 0)
+	assert.ErrorIs(t, err, datastore.ErrLibraryTaskNotFound)
+}
+
+func TestInMemoryGetOrphanedTasks(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	var err error
+	now := time.Now().UTC()
+	up := &tork.Node{
+		ID:              uuid.NewUUID(),
+		LastHeartbeatAt: now,
+	}
+	err = ds.CreateNode(ctx, up)
+	assert.NoError(t, err)
+	down := &tork.Node{
+		ID:              uuid.NewUUID(),
+		LastHeartbeatAt: now.Add(-time.Minute * 10),
+	}
+	err = ds.CreateNode(ctx, down)
+	assert.NoError(t, err)
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	longAgo := now.Add(-time.Hour * 2)
+	running := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateRunning,
+		CreatedAt: &now,
+		NodeID:    up.ID,
+	}
+	orphaned := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateRunning,
+		CreatedAt: &now,
+		NodeID:    down.ID,
+	}
+	unknown := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateRunning,
+		CreatedAt: &now,
+		NodeID:    uuid.NewUUID(),
+	}
+	completed := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateCompleted,
+		CreatedAt: &now,
+		NodeID:    down.ID,
+	}
+	scheduled := &tork.Task{
+		ID:          uuid.NewUUID(),
+		JobID:       j1.ID,
+		State:       tork.TaskStateScheduled,
+		CreatedAt:   &longAgo,
+		ScheduledAt: &longAgo,
+	}
+	for _, tk := range []*tork.Task{running, orphaned, unknown, completed, scheduled} {
+		err = ds.CreateTask(ctx, tk)
+		assert.NoError(t, err)
+	}
+	ids := func(ts []*tork.Task) []string {
+		result := make([]string, 0)
+		for _, tk := range ts {
+			if tk.JobID == j1.ID {
+				result = append(result, tk.ID)
+			}
+		}
+		return result
+	}
+
+	ts, err := ds.GetOrphanedTasks(ctx, now.Add(-time.Minute), time.Time{})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{orphaned.ID, unknown.ID}, ids(ts))
+
+	ts, err = ds.GetOrphanedTasks(ctx, now.Add(-time.Minute), now.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{orphaned.ID, unknown.ID, scheduled.ID}, ids(ts))
+}
+
+func TestInMemoryGetExpiredJobsDefaultExpiration(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore(inmemory.WithJobExpiration(time.Hour))
+
+	owner := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: uuid.NewShortUUID(),
+	}
+	err := ds.CreateUser(ctx, owner)
+	assert.NoError(t, err)
+	other := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: uuid.NewShortUUID(),
+	}
+	err = ds.CreateUser(ctx, other)
+	assert.NoError(t, err)
+
+	createJob := func(age time.Duration, perms []*tork.Permission) *tork.Job {
+		j := &tork.Job{
+			ID:          uuid.NewUUID(),
+			State:       tork.JobStateRunning,
+			Permissions: perms,
+		}
+		err := ds.CreateJob(ctx, j)
+		assert.NoError(t, err)
+		completedAt := time.Now().UTC().Add(-age)
+		err = ds.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
+			u.State = tork.JobStateCompleted
+			u.CompletedAt = &completedAt
+			return nil
+		})
+		assert.NoError(t, err)
+		return j
+	}
+
+	old := createJob(time.Hour*2, nil)
+	private := createJob(time.Hour*2, []*tork.Permission{{User: owner}})
+	createJob(time.Minute, nil)
+
+	p, err := ds.GetExpiredJobs(ctx, "", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, p.TotalItems)
+
+	p, err = ds.GetExpiredJobs(ctx, owner.Username, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, p.TotalItems)
+
+	p, err = ds.GetExpiredJobs(ctx, other.Username, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, p.TotalItems)
+	assert.Equal(t, old.ID, p.Items[0].ID)
+	assert.NotEqual(t, private.ID, p.Items[0].ID)
+}
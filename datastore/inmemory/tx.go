@@ -0,0 +1,87 @@
+package inmemory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore"
+)
+
+// jobLocks serializes the transactions which update the
+// same job (or lock the same concurrency key). It's the
+// in-memory equivalent of the row and advisory locks used
+// by the SQL datastores.
+type jobLocks struct {
+	mu    sync.Mutex
+	locks map[string]*jobLock
+}
+
+type jobLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func (l *jobLocks) lock(id string) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*jobLock)
+	}
+	jl, ok := l.locks[id]
+	if !ok {
+		jl = &jobLock{}
+		l.locks[id] = jl
+	}
+	jl.refs = jl.refs + 1
+	l.mu.Unlock()
+	jl.mu.Lock()
+}
+
+func (l *jobLocks) unlock(id string) {
+	l.mu.Lock()
+	jl := l.locks[id]
+	jl.refs = jl.refs - 1
+	if jl.refs == 0 {
+		delete(l.locks, id)
+	}
+	l.mu.Unlock()
+	jl.mu.Unlock()
+}
+
+// txDatastore is handed to the function passed to WithTx.
+// Every job updated through it stays locked until the
+// transaction ends.
+type txDatastore struct {
+	*InMemoryDatastore
+	locked map[string]bool
+}
+
+func (tx *txDatastore) UpdateJob(ctx context.Context, id string, modify func(u *tork.Job) error) error {
+	tx.lock(id)
+	return tx.InMemoryDatastore.UpdateJob(ctx, id, modify)
+}
+
+// LockConcurrencyKey serializes concurrent starts of jobs
+// sharing the same key until the transaction ends.
+func (tx *txDatastore) LockConcurrencyKey(ctx context.Context, key string) error {
+	tx.lock("concurrency:" + key)
+	return nil
+}
+
+func (tx *txDatastore) WithTx(ctx context.Context, f func(tx datastore.Datastore) error) error {
+	// already in a transaction
+	return f(tx)
+}
+
+func (tx *txDatastore) lock(id string) {
+	if !tx.locked[id] {
+		tx.jobLocks.lock(id)
+		tx.locked[id] = true
+	}
+}
+
+func (tx *txDatastore) release() {
+	for id := range tx.locked {
+		tx.jobLocks.unlock(id)
+	}
+}
@@ -14,9 +14,11 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/archive"
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/internal/slices"
 	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/leader"
 )
 
 type PostgresDatastore struct {
@@ -26,6 +28,9 @@ type PostgresDatastore struct {
 	cleanupInterval         *time.Duration
 	rand                    *rand.Rand
 	disableCleanup          bool
+	retention               datastore.RetentionPolicy
+	archive                 archive.Sink
+	elector                 leader.Elector
 }
 
 var (
@@ -49,6 +54,29 @@ func WithDisableCleanup(val bool) Option {
 	}
 }
 
+func WithRetentionPolicy(p datastore.RetentionPolicy) Option {
+	return func(ds *PostgresDatastore) {
+		ds.retention = p
+	}
+}
+
+// WithArchive sets the sink which expired jobs
+// are archived to before they are expunged.
+func WithArchive(s archive.Sink) Option {
+	return func(ds *PostgresDatastore) {
+		ds.archive = s
+	}
+}
+
+// WithLeaderElector registers the cleanup process as a duty
+// of the elector so that only the leader coordinator expunges
+// expired data.
+func WithLeaderElector(e leader.Elector) Option {
+	return func(ds *PostgresDatastore) {
+		ds.elector = e
+	}
+}
+
 func NewPostgresDataStore(dsn string, opts ...Option) (*PostgresDatastore, error) {
 	db, err := sqlx.Connect("postgres", dsn)
 	if err != nil {
@@ -72,11 +100,32 @@ func NewPostgresDataStore(dsn string, opts ...Option) (*PostgresDatastore, error
 		return nil, errors.Errorf("task logs retention period can not be under 1 minute")
 	}
 	if !ds.disableCleanup {
-		go ds.cleanupProcess()
+		if ds.elector != nil {
+			ds.elector.Register("cleanup", minCleanupInterval, ds.cleanupDuty())
+		} else {
+			go ds.cleanupProcess()
+		}
 	}
 	return ds, nil
 }
 
+// cleanupDuty returns the cleanup process as a leader duty.
+// The duty runs every minute but only cleans up once the
+// cleanup interval has elapsed since the last cleanup.
+func (ds *PostgresDatastore) cleanupDuty() leader.Duty {
+	last := time.Now()
+	return func(ctx context.Context) error {
+		if time.Since(last) < *ds.cleanupInterval {
+			return nil
+		}
+		last = time.Now()
+		if err := ds.cleanup(); err != nil {
+			return errors.Wrapf(err, "error expunging task logs")
+		}
+		return nil
+	}
+}
+
 func (ds *PostgresDatastore) cleanupProcess() {
 	for {
 		jitter := time.Second * (time.Duration(ds.rand.Intn(60) + 1))
@@ -223,6 +272,33 @@ func (ds *PostgresDatastore) CreateTask(ctx context.Context, t *tork.Task) error
 		s := string(b)
 		ports = &s
 	}
+	var artifacts *string
+	if t.Artifacts != nil {
+		b, err := json.Marshal(t.Artifacts)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize task.artifacts")
+		}
+		s := string(b)
+		artifacts = &s
+	}
+	var nodeSelector *string
+	if len(t.NodeSelector) > 0 {
+		b, err := json.Marshal(t.NodeSelector)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize task.nodeSelector")
+		}
+		s := string(b)
+		nodeSelector = &s
+	}
+	var affinity *string
+	if len(t.Affinity) > 0 {
+		b, err := json.Marshal(t.Affinity)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize task.affinity")
+		}
+		s := string(b)
+		affinity = &s
+	}
 	q := `insert into tasks (
 		    id, -- $1
 			job_id, -- $2
@@ -263,13 +339,18 @@ func (ds *PostgresDatastore) CreateTask(ctx context.Context, t *tork.Task) error
 			tags, -- $37
 			priority, -- $38
 			workdir, -- $39
-			ports -- $40
+			ports, -- $40
+			depends_on, -- $41
+			not_before, -- $42
+			artifacts, -- $43
+			node_selector, -- $44
+			affinity -- $45
 		  ) 
 	      values (
 			$1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,
 		    $15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26,
 			$27,$28,$29,$30,$31,$32,$33,$34,$35,$36,$37,$38,
-			$39,$40)`
+			$39,$40,$41,$42,$43,$44,$45)`
 	_, err = ds.exec(q,
 		t.ID,                         // $1
 		t.JobID,                      // $2
@@ -311,6 +392,11 @@ func (ds *PostgresDatastore) CreateTask(ctx context.Context, t *tork.Task) error
 		t.Priority,                   // $38
 		t.Workdir,                    // $39
 		ports,                        // $40
+		pq.StringArray(t.DependsOn),  // $41
+		t.NotBefore,                  // $42
+		artifacts,                    // $43
+		nodeSelector,                 // $44
+		affinity,                     // $45
 	)
 	if err != nil {
 		return errors.Wrapf(err, "error inserting task to the db")
@@ -442,11 +528,20 @@ func (ds *PostgresDatastore) UpdateTask(ctx context.Context, id string, modify f
 }
 
 func (ds *PostgresDatastore) CreateNode(ctx context.Context, n *tork.Node) error {
+	var labels *string
+	if len(n.Labels) > 0 {
+		b, err := json.Marshal(n.Labels)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize node.labels")
+		}
+		s := string(b)
+		labels = &s
+	}
 	q := `insert into nodes 
-	       (id,name,started_at,last_heartbeat_at,cpu_percent,queue,status,hostname,task_count,version_,port)
+	       (id,name,started_at,last_heartbeat_at,cpu_percent,queue,status,hostname,task_count,version_,port,cpus,memory,allocated_cpus,allocated_memory,labels,queues)
 	      values
-	       ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)`
-	_, err := ds.exec(q, n.ID, n.Name, n.StartedAt, n.LastHeartbeatAt, n.CPUPercent, n.Queue, n.Status, n.Hostname, n.TaskCount, n.Version, n.Port)
+	       ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17)`
+	_, err := ds.exec(q, n.ID, n.Name, n.StartedAt, n.LastHeartbeatAt, n.CPUPercent, n.Queue, n.Status, n.Hostname, n.TaskCount, n.Version, n.Port, n.CPUs, n.Memory, n.AllocatedCPUs, n.AllocatedMemory, labels, pq.StringArray(n.Queues))
 	if err != nil {
 		return errors.Wrapf(err, "error inserting node to the db")
 	}
@@ -463,17 +558,35 @@ func (ds *PostgresDatastore) UpdateNode(ctx context.Context, id string, modify f
 		if err := ptx.get(&nr, `SELECT * FROM nodes where id = $1 for update`, id); err != nil {
 			return errors.Wrapf(err, "error fetching node from db")
 		}
-		n := nr.toNode()
+		n, err := nr.toNode()
+		if err != nil {
+			return err
+		}
 		if err := modify(n); err != nil {
 			return err
 		}
+		var labels *string
+		if len(n.Labels) > 0 {
+			b, err := json.Marshal(n.Labels)
+			if err != nil {
+				return errors.Wrapf(err, "failed to serialize node.labels")
+			}
+			s := string(b)
+			labels = &s
+		}
 		q := `update nodes set 
 	        last_heartbeat_at = $1,
 			cpu_percent = $2,
 			status = $3,
-			task_count = $4
-		  where id = $5`
-		_, err := ptx.exec(q, n.LastHeartbeatAt, n.CPUPercent, n.Status, n.TaskCount, id)
+			task_count = $4,
+			cpus = $5,
+			memory = $6,
+			allocated_cpus = $7,
+			allocated_memory = $8,
+			labels = $9,
+			queues = $10
+		  where id = $11`
+		_, err = ptx.exec(q, n.LastHeartbeatAt, n.CPUPercent, n.Status, n.TaskCount, n.CPUs, n.Memory, n.AllocatedCPUs, n.AllocatedMemory, labels, pq.StringArray(n.Queues), id)
 		if err != nil {
 			return errors.Wrapf(err, "error update node in db")
 		}
@@ -489,7 +602,7 @@ func (ds *PostgresDatastore) GetNodeByID(ctx context.Context, id string) (*tork.
 		}
 		return nil, errors.Wrapf(err, "error fetching task from db")
 	}
-	return nr.toNode(), nil
+	return nr.toNode()
 }
 
 func (ds *PostgresDatastore) GetActiveNodes(ctx context.Context) ([]*tork.Node, error) {
@@ -503,9 +616,12 @@ func (ds *PostgresDatastore) GetActiveNodes(ctx context.Context) ([]*tork.Node,
 		return nil, errors.Wrapf(err, "error getting active nodes from db")
 	}
 	ns := make([]*tork.Node, len(nrs))
-	for i, n := range nrs {
-
-		ns[i] = n.toNode()
+	for i, nr := range nrs {
+		n, err := nr.toNode()
+		if err != nil {
+			return nil, err
+		}
+		ns[i] = n
 	}
 	return ns, nil
 }
@@ -567,6 +683,23 @@ func (ds *PostgresDatastore) CreateJob(ctx context.Context, j *tork.Job) error {
 		s := string(b)
 		secrets = &s
 	}
+	var schedule *string
+	if j.Schedule != nil {
+		b, err := json.Marshal(j.Schedule)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize job.schedule")
+		}
+		s := string(b)
+		schedule = &s
+	}
+	concurrency, err := serializeConcurrency(j.Concurrency)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize job.concurrency")
+	}
+	var idempotencyKey *string
+	if j.IdempotencyKey != "" {
+		idempotencyKey = &j.IdempotencyKey
+	}
 	return ds.WithTx(ctx, func(tx datastore.Datastore) error {
 		ptx, ok := tx.(*PostgresDatastore)
 		if !ok {
@@ -574,12 +707,15 @@ func (ds *PostgresDatastore) CreateJob(ctx context.Context, j *tork.Job) error {
 		}
 		sql := `insert into jobs (id,name,description,state,created_at,started_at,tasks,position,
 					inputs,context,parent_id,task_count,output_,result,error_,defaults,webhooks,
-					created_by,tags,auto_delete,secrets) 
+					created_by,tags,auto_delete,secrets,schedule,concurrency,idempotency_key) 
 				values
-					($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21)`
+					($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24)`
 		if _, err := ptx.exec(sql, j.ID, j.Name, j.Description, j.State, j.CreatedAt, j.StartedAt, tasks, j.Position,
 			inputs, c, j.ParentID, j.TaskCount, j.Output, j.Result, j.Error, defaults, webhooks, j.CreatedBy.ID,
-			pq.StringArray(j.Tags), autoDelete, secrets); err != nil {
+			pq.StringArray(j.Tags), autoDelete, secrets, schedule, concurrency, idempotencyKey); err != nil {
+			if isUniqueViolation(err) {
+				return datastore.ErrDuplicateIdempotencyKey
+			}
 			return errors.Wrapf(err, "error inserting job to the db")
 		}
 		for _, perm := range j.Permissions {
@@ -634,6 +770,23 @@ func (ds *PostgresDatastore) UpdateJob(ctx context.Context, id string, modify fu
 		if err != nil {
 			return errors.Wrapf(err, "failed to serialize tork.Context")
 		}
+		concurrency, err := serializeConcurrency(j.Concurrency)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize job.concurrency")
+		}
+		var idempotencyKey *string
+		if j.IdempotencyKey != "" {
+			idempotencyKey = &j.IdempotencyKey
+		}
+		var rerun *string
+		if j.Rerun != nil {
+			b, err := json.Marshal(j.Rerun)
+			if err != nil {
+				return errors.Wrapf(err, "failed to serialize job.rerun")
+			}
+			s := string(b)
+			rerun = &s
+		}
 		q := `update jobs set 
 				state = $1,
 				started_at = $2,
@@ -644,9 +797,16 @@ func (ds *PostgresDatastore) UpdateJob(ctx context.Context, id string, modify fu
 				result = $7,
 				error_ = $8,
 				delete_at = $9,
-				progress = $10
-			  where id = $11`
-		_, err = ptx.exec(q, j.State, j.StartedAt, j.CompletedAt, j.FailedAt, j.Position, c, j.Result, j.Error, j.DeleteAt, j.Progress, j.ID)
+				progress = $10,
+				concurrency = $11,
+				idempotency_key = $12,
+				paused_at = $13,
+				rerun = $14
+			  where id = $15`
+		_, err = ptx.exec(q, j.State, j.StartedAt, j.CompletedAt, j.FailedAt, j.Position, c, j.Result, j.Error, j.DeleteAt, j.Progress, concurrency, idempotencyKey, j.PausedAt, rerun, j.ID)
+		if isUniqueViolation(err) {
+			return datastore.ErrDuplicateIdempotencyKey
+		}
 		return err
 	})
 }
@@ -711,6 +871,21 @@ func (ds *PostgresDatastore) GetJobByID(ctx context.Context, id string) (*tork.J
 	return r.toJob(tasks, exec, u, perms)
 }
 
+func (ds *PostgresDatastore) GetJobByIdempotencyKey(ctx context.Context, username, key string) (*tork.Job, error) {
+	var id string
+	q := `SELECT j.id 
+	      FROM jobs j 
+	      JOIN users u ON u.id = j.created_by 
+	      where u.username_ = $1 AND j.idempotency_key = $2`
+	if err := ds.get(&id, q, username, key); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrJobNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching job from db")
+	}
+	return ds.GetJobByID(ctx, id)
+}
+
 func (ds *PostgresDatastore) GetActiveTasks(ctx context.Context, jobID string) ([]*tork.Task, error) {
 	rs := make([]taskRecord, 0)
 	q := `SELECT * 
@@ -736,7 +911,7 @@ func (ds *PostgresDatastore) GetActiveTasks(ctx context.Context, jobID string) (
 
 func (ds *PostgresDatastore) GetNextTask(ctx context.Context, parentTaskID string) (*tork.Task, error) {
 	r := taskRecord{}
-	if err := ds.get(&r, `SELECT * FROM tasks where parent_id = $1 and state = 'CREATED' limit 1`, parentTaskID); err != nil {
+	if err := ds.get(&r, `SELECT * FROM tasks where parent_id = $1 and state = 'CREATED' and not_before is null limit 1`, parentTaskID); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, datastore.ErrTaskNotFound
 		}
@@ -745,6 +920,101 @@ func (ds *PostgresDatastore) GetNextTask(ctx context.Context, parentTaskID strin
 	return r.toTask()
 }
 
+func (ds *PostgresDatastore) GetDelayedTasks(ctx context.Context, before time.Time) ([]*tork.Task, error) {
+	rs := make([]taskRecord, 0)
+	q := `SELECT * 
+	      FROM tasks 
+	      where state = 'CREATED' 
+	      AND not_before <= $1
+	      ORDER BY not_before ASC`
+	if err := ds.select_(&rs, q, before); err != nil {
+		return nil, errors.Wrapf(err, "error getting delayed tasks from db")
+	}
+	result := make([]*tork.Task, len(rs))
+	for i, r := range rs {
+		t, err := r.toTask()
+		if err != nil {
+			return nil, err
+		}
+		result[i] = t
+	}
+	return result, nil
+}
+
+// GetOrphanedTasks returns the RUNNING or SCHEDULED tasks whose node
+// hasn't sent a heartbeat since heartbeatBefore, as well as the tasks
+// which were SCHEDULED before scheduledBefore but never started.
+func (ds *PostgresDatastore) GetOrphanedTasks(ctx context.Context, heartbeatBefore, scheduledBefore time.Time) ([]*tork.Task, error) {
+	rs := make([]taskRecord, 0)
+	q := `SELECT * 
+	      FROM tasks t
+	      where (
+	        t.state in ('RUNNING','SCHEDULED')
+	        AND coalesce(t.node_id,'') != ''
+	        AND NOT EXISTS (SELECT 1 FROM nodes n WHERE n.id = t.node_id AND n.last_heartbeat_at >= $1)
+	      ) OR (
+	        t.state = 'SCHEDULED'
+	        AND t.scheduled_at < $2
+	      )
+	      ORDER BY t.created_at ASC`
+	if err := ds.select_(&rs, q, heartbeatBefore, scheduledBefore); err != nil {
+		return nil, errors.Wrapf(err, "error getting orphaned tasks from db")
+	}
+	result := make([]*tork.Task, len(rs))
+	for i, r := range rs {
+		t, err := r.toTask()
+		if err != nil {
+			return nil, err
+		}
+		result[i] = t
+	}
+	return result, nil
+}
+
+// GetReadyTasks returns the top-level tasks of the job which
+// were not created yet and whose dependencies have all completed.
+func (ds *PostgresDatastore) GetReadyTasks(ctx context.Context, jobID string) ([]*tork.Task, error) {
+	rs := make([]readyTaskRecord, 0)
+	q := `with defs as (
+	        select d.ord::int as position, d.task
+	        from jobs j, jsonb_array_elements(j.tasks) with ordinality as d(task, ord)
+	        where j.id = $1
+	      )
+	      select defs.position, defs.task
+	      from defs
+	      where not exists (
+	        select 1 from tasks t
+	        where t.job_id = $1
+	        and coalesce(t.parent_id,'') = ''
+	        and t.position = defs.position
+	      )
+	      and not exists (
+	        select 1 from jsonb_array_elements_text(coalesce(defs.task->'dependsOn','[]'::jsonb)) as dep(var)
+	        where not exists (
+	          select 1 from tasks t
+	          where t.job_id = $1
+	          and coalesce(t.parent_id,'') = ''
+	          and t.var = dep.var
+	          and t.state = ANY($2)
+	        )
+	      )
+	      order by defs.position`
+	doneStates := []string{string(tork.TaskStateCompleted), string(tork.TaskStateSkipped)}
+	if err := ds.select_(&rs, q, jobID, pq.StringArray(doneStates)); err != nil {
+		return nil, errors.Wrapf(err, "error getting ready tasks from db")
+	}
+	ready := make([]*tork.Task, len(rs))
+	for i, r := range rs {
+		t := &tork.Task{}
+		if err := json.Unmarshal(r.Task, t); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing task")
+		}
+		t.Position = r.Position
+		ready[i] = t
+	}
+	return ready, nil
+}
+
 func (ds *PostgresDatastore) CreateTaskLogPart(ctx context.Context, p *tork.TaskLogPart) error {
 	if p.TaskID == "" {
 		return errors.Errorf("must provide task id")
@@ -782,19 +1052,34 @@ func (ds *PostgresDatastore) expungeExpiredTaskLogPart() (int, error) {
 }
 
 func (ds *PostgresDatastore) expungeExpiredJobs() (int, error) {
+	ctx := context.Background()
+	ids := []string{}
+	clause, args := ds.expiredJobsClause(time.Now().UTC())
+	if err := ds.select_(&ids, fmt.Sprintf("select id from jobs where %s limit 1000", clause), args...); err != nil {
+		return 0, errors.Wrapf(err, "error getting list of expired job ids from the db")
+	}
+	if ds.archive != nil {
+		archived := make([]string, 0, len(ids))
+		for _, id := range ids {
+			// jobs which could not be archived are
+			// kept around until the next cleanup
+			if err := ds.archiveJob(ctx, id); err != nil {
+				log.Error().Err(err).Msgf("error archiving job %s", id)
+				continue
+			}
+			archived = append(archived, id)
+		}
+		ids = archived
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
 	var n int
-	if err := ds.WithTx(context.Background(), func(tx datastore.Datastore) error {
+	if err := ds.WithTx(ctx, func(tx datastore.Datastore) error {
 		ptx, ok := tx.(*PostgresDatastore)
 		if !ok {
 			return errors.New("unable to cast to a postgres datastore")
 		}
-		ids := []string{}
-		if err := ptx.select_(&ids, "select id from jobs where delete_at < current_timestamp limit 1000"); err != nil {
-			return errors.Wrapf(err, "error getting list of expired job ids from the db")
-		}
-		if len(ids) == 0 {
-			return nil
-		}
 		if _, err := ptx.exec(`delete from jobs_perms where job_id = ANY($1);`, pq.StringArray(ids)); err != nil {
 			return errors.Wrapf(err, "error deleting expired job perms from the db")
 		}
@@ -820,6 +1105,141 @@ func (ds *PostgresDatastore) expungeExpiredJobs() (int, error) {
 	return n, nil
 }
 
+// archiveJob writes the job -- along with its execution
+// and task logs -- to the archive sink.
+func (ds *PostgresDatastore) archiveJob(ctx context.Context, id string) error {
+	j, err := ds.GetJobByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	rs := []taskLogPartRecord{}
+	q := `select tlp.* 
+	      from   tasks_log_parts tlp
+	      join   tasks t
+	      on     t.id = tlp.task_id
+	      where  t.job_id = $1
+	      order  by t.position, t.created_at, tlp.number_`
+	if err := ds.select_(&rs, q, id); err != nil {
+		return errors.Wrapf(err, "error getting the log parts of job %s", id)
+	}
+	logs := make([]*tork.TaskLogPart, len(rs))
+	for i, r := range rs {
+		logs[i] = r.toTaskLogPart()
+	}
+	return archive.Write(ctx, ds.archive, &archive.Bundle{
+		Job:        j,
+		Logs:       logs,
+		ArchivedAt: time.Now().UTC(),
+	})
+}
+
+// expiredJobsClause returns the condition matching the jobs which
+// are due to be expunged, either because their autoDelete time
+// passed or because a retention rule says so. The first rule
+// matching a job determines its expiration.
+func (ds *PostgresDatastore) expiredJobsClause(now time.Time) (string, []any) {
+	args := []any{now}
+	states := make([]string, len(datastore.RetentionStates))
+	for i, s := range datastore.RetentionStates {
+		states[i] = fmt.Sprintf("'%s'", s)
+	}
+	cases := make([]string, 0, len(ds.retention))
+	for _, r := range ds.retention {
+		conds := []string{fmt.Sprintf("state in (%s)", strings.Join(states, ","))}
+		if r.State != "" {
+			args = append(args, r.State)
+			conds = append(conds, fmt.Sprintf("state = $%d", len(args)))
+		}
+		if r.Tag != "" {
+			args = append(args, r.Tag)
+			conds = append(conds, fmt.Sprintf("$%d = ANY(tags)", len(args)))
+		}
+		expireBefore := "null::timestamp"
+		if r.After != nil {
+			args = append(args, now.Add(-*r.After))
+			expireBefore = fmt.Sprintf("$%d::timestamp", len(args))
+		}
+		cases = append(cases, fmt.Sprintf("when %s then %s", strings.Join(conds, " and "), expireBefore))
+	}
+	if len(cases) == 0 {
+		return "delete_at < $1", args
+	}
+	return fmt.Sprintf(`(delete_at < $1 or (delete_at is null and 
+	        coalesce(completed_at,failed_at,started_at,created_at) < case %s end))`, strings.Join(cases, " ")), args
+}
+
+func (ds *PostgresDatastore) GetExpiredJobs(ctx context.Context, currentUser string, page, size int) (*datastore.Page[*tork.JobSummary], error) {
+	clause, args := ds.expiredJobsClause(time.Now().UTC())
+	args = append(args, currentUser)
+	with, where := jobPermsClause(len(args))
+	offset := (page - 1) * size
+	rs := make([]jobUserRecord, 0)
+	qry := fmt.Sprintf(`%s
+	      SELECT j.*, u.username_ AS created_by_username, u.name AS created_by_name
+	      FROM (SELECT * FROM jobs WHERE %s) j
+	      JOIN users u ON u.id = j.created_by
+	      WHERE %s
+	      ORDER BY j.created_at ASC
+	      OFFSET %d LIMIT %d`, with, clause, where, offset, size)
+	if err := ds.select_(&rs, qry, args...); err != nil {
+		return nil, errors.Wrapf(err, "error getting a page of expired jobs")
+	}
+	result := make([]*tork.JobSummary, len(rs))
+	for i, r := range rs {
+		j, err := r.toJob([]*tork.Task{}, []*tork.Task{}, r.toCreatedBy(), []*tork.Permission{})
+		if err != nil {
+			return nil, err
+		}
+		result[i] = tork.NewJobSummary(j)
+	}
+	var count *int
+	if err := ds.get(&count, fmt.Sprintf(`%s
+	      SELECT count(*)
+	      FROM (SELECT * FROM jobs WHERE %s) j
+	      WHERE %s`, with, clause, where), args...); err != nil {
+		return nil, errors.Wrapf(err, "error getting the expired jobs count")
+	}
+	totalPages := *count / size
+	if *count%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.JobSummary]{
+		Items:      result,
+		Number:     page,
+		Size:       len(result),
+		TotalPages: totalPages,
+		TotalItems: *count,
+	}, nil
+}
+
+// jobPermsClause returns the CTEs and the condition matching the
+// jobs (aliased j) which the user, whose username is the query
+// parameter at the given position, is permitted to see. An empty
+// username matches all jobs.
+func jobPermsClause(param int) (string, string) {
+	with := fmt.Sprintf(`
+	  WITH user_info AS (
+	    SELECT id AS user_id
+	    FROM users
+	    WHERE username_ = $%[1]d
+	  ),
+	  role_info AS (
+	    SELECT role_id
+	    FROM users_roles ur
+	    JOIN user_info ui ON ur.user_id = ui.user_id
+	  )`, param)
+	where := fmt.Sprintf(`
+	  ($%[1]d = '' OR NOT EXISTS (
+	     SELECT 1 FROM jobs_perms jp WHERE jp.job_id = j.id
+	  ) OR EXISTS (
+	     SELECT 1
+	     FROM jobs_perms jp
+	     WHERE jp.job_id = j.id
+	     AND (jp.user_id = (SELECT user_id FROM user_info) OR jp.role_id IN (SELECT role_id FROM role_info))
+	  ))`, param)
+	return with, where
+}
+
 func (ds *PostgresDatastore) GetTaskLogParts(ctx context.Context, taskID, q string, page, size int) (*datastore.Page[*tork.TaskLogPart], error) {
 	searchTerm, _ := parseQuery(q)
 	offset := (page - 1) * size
@@ -1008,6 +1428,485 @@ func (ds *PostgresDatastore) GetJobs(ctx context.Context, currentUser, q string,
 	}, nil
 }
 
+// LockConcurrencyKey serializes concurrent starts of jobs
+// sharing the same key until the transaction ends.
+func (ds *PostgresDatastore) LockConcurrencyKey(ctx context.Context, key string) error {
+	if ds.tx == nil {
+		return errors.New("locking a concurrency key requires a transaction")
+	}
+	if _, err := ds.exec(`select pg_advisory_xact_lock(hashtext($1))`, key); err != nil {
+		return errors.Wrapf(err, "error acquiring concurrency key lock")
+	}
+	return nil
+}
+
+func (ds *PostgresDatastore) GetJobsByConcurrencyKey(ctx context.Context, key string) ([]*tork.JobSummary, error) {
+	rs := make([]jobRecord, 0)
+	q := `SELECT * 
+	      FROM jobs 
+	      where concurrency->>'key' = $1 
+	      and state in ($2,$3,$4,$5)
+	      ORDER BY created_at ASC`
+	if err := ds.select_(&rs, q, key, tork.JobStatePending, tork.JobStateScheduled,
+		tork.JobStateRunning, tork.JobStatePaused); err != nil {
+		return nil, errors.Wrapf(err, "error getting jobs by concurrency key")
+	}
+	result := make([]*tork.JobSummary, len(rs))
+	for i, r := range rs {
+		createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+		j, err := r.toJob([]*tork.Task{}, []*tork.Task{}, createdBy, []*tork.Permission{})
+		if err != nil {
+			return nil, err
+		}
+		result[i] = tork.NewJobSummary(j)
+	}
+	return result, nil
+}
+
+func (ds *PostgresDatastore) CreateScheduledJob(ctx context.Context, sj *tork.ScheduledJob) error {
+	if sj.ID == "" {
+		return errors.Errorf("scheduled job id must not be empty")
+	}
+	if sj.CreatedBy == nil {
+		guest, err := ds.GetUser(ctx, tork.USER_GUEST)
+		if err != nil {
+			return err
+		}
+		sj.CreatedBy = guest
+	}
+	tasks, err := json.Marshal(sj.Tasks)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize scheduledJob.tasks")
+	}
+	inputs, err := json.Marshal(sj.Inputs)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize scheduledJob.inputs")
+	}
+	var secrets *string
+	if sj.Secrets != nil {
+		b, err := json.Marshal(sj.Secrets)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize scheduledJob.secrets")
+		}
+		s := string(b)
+		secrets = &s
+	}
+	var defaults *string
+	if sj.Defaults != nil {
+		b, err := json.Marshal(sj.Defaults)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize scheduledJob.defaults")
+		}
+		s := string(b)
+		defaults = &s
+	}
+	webhooks, err := json.Marshal(sj.Webhooks)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize scheduledJob.webhooks")
+	}
+	perms, err := json.Marshal(sj.Permissions)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize scheduledJob.permissions")
+	}
+	var autoDelete *string
+	if sj.AutoDelete != nil {
+		b, err := json.Marshal(sj.AutoDelete)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize scheduledJob.autoDelete")
+		}
+		s := string(b)
+		autoDelete = &s
+	}
+	concurrency, err := serializeConcurrency(sj.Concurrency)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize scheduledJob.concurrency")
+	}
+	var inputTypes *string
+	if sj.InputTypes != nil {
+		b, err := json.Marshal(sj.InputTypes)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize scheduledJob.inputTypes")
+		}
+		s := string(b)
+		inputTypes = &s
+	}
+	var inputSchema *string
+	if sj.InputSchema != nil {
+		b, err := json.Marshal(sj.InputSchema)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize scheduledJob.inputSchema")
+		}
+		s := string(b)
+		inputSchema = &s
+	}
+	if sj.Tags == nil {
+		sj.Tags = make([]string, 0)
+	}
+	q := `insert into scheduled_jobs (id,name,description,tags,cron_expr,state,created_at,created_by,
+	        last_run_at,next_run_at,tasks,inputs,secrets,output_,defaults,webhooks,permissions,auto_delete,concurrency,input_types,input_schema) 
+	      values
+	        ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21)`
+	if _, err := ds.exec(q, sj.ID, sj.Name, sj.Description, pq.StringArray(sj.Tags), sj.Cron, sj.State,
+		sj.CreatedAt, sj.CreatedBy.ID, sj.LastRunAt, sj.NextRunAt, tasks, inputs, secrets, sj.Output,
+		defaults, webhooks, perms, autoDelete, concurrency, inputTypes, inputSchema); err != nil {
+		return errors.Wrapf(err, "error inserting scheduled job to the db")
+	}
+	return nil
+}
+
+func (ds *PostgresDatastore) UpdateScheduledJob(ctx context.Context, id string, modify func(u *tork.ScheduledJob) error) error {
+	return ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		ptx, ok := tx.(*PostgresDatastore)
+		if !ok {
+			return errors.New("unable to cast to a postgres datastore")
+		}
+		r := scheduledJobRecord{}
+		if err := ptx.get(&r, `SELECT * FROM scheduled_jobs where id = $1 for update`, id); err != nil {
+			if err == sql.ErrNoRows {
+				return datastore.ErrScheduledJobNotFound
+			}
+			return errors.Wrapf(err, "error fetching scheduled job from db")
+		}
+		createdBy, err := ptx.GetUser(ctx, r.CreatedBy)
+		if err != nil {
+			return err
+		}
+		sj, err := r.toScheduledJob(createdBy)
+		if err != nil {
+			return err
+		}
+		if err := modify(sj); err != nil {
+			return err
+		}
+		q := `update scheduled_jobs set 
+				state = $1,
+				last_run_at = $2,
+				next_run_at = $3
+			  where id = $4`
+		if _, err := ptx.exec(q, sj.State, sj.LastRunAt, sj.NextRunAt, sj.ID); err != nil {
+			return errors.Wrapf(err, "error updating scheduled job %s", sj.ID)
+		}
+		return nil
+	})
+}
+
+func (ds *PostgresDatastore) GetScheduledJobByID(ctx context.Context, id string) (*tork.ScheduledJob, error) {
+	r := scheduledJobRecord{}
+	if err := ds.get(&r, `SELECT * FROM scheduled_jobs where id = $1`, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrScheduledJobNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching scheduled job from db")
+	}
+	createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+	if err != nil {
+		return nil, err
+	}
+	return r.toScheduledJob(createdBy)
+}
+
+func (ds *PostgresDatastore) GetScheduledJobs(ctx context.Context, page, size int) (*datastore.Page[*tork.ScheduledJobSummary], error) {
+	offset := (page - 1) * size
+	rs := make([]scheduledJobRecord, 0)
+	qry := fmt.Sprintf(`SELECT * 
+	      FROM scheduled_jobs 
+	      ORDER BY created_at DESC 
+	      OFFSET %d LIMIT %d`, offset, size)
+	if err := ds.select_(&rs, qry); err != nil {
+		return nil, errors.Wrapf(err, "error getting a page of scheduled jobs")
+	}
+	result := make([]*tork.ScheduledJobSummary, len(rs))
+	for i, r := range rs {
+		createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+		sj, err := r.toScheduledJob(createdBy)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = tork.NewScheduledJobSummary(sj)
+	}
+	var count *int
+	if err := ds.get(&count, `select count(*) from scheduled_jobs`); err != nil {
+		return nil, errors.Wrapf(err, "error getting the scheduled jobs count")
+	}
+	totalPages := *count / size
+	if *count%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.ScheduledJobSummary]{
+		Items:      result,
+		Number:     page,
+		Size:       len(result),
+		TotalPages: totalPages,
+		TotalItems: *count,
+	}, nil
+}
+
+func (ds *PostgresDatastore) GetActiveScheduledJobs(ctx context.Context) ([]*tork.ScheduledJob, error) {
+	rs := make([]scheduledJobRecord, 0)
+	q := `SELECT * 
+	      FROM scheduled_jobs 
+	      where state = $1 
+	      ORDER BY next_run_at ASC`
+	if err := ds.select_(&rs, q, tork.ScheduledJobStateActive); err != nil {
+		return nil, errors.Wrapf(err, "error getting active scheduled jobs from db")
+	}
+	result := make([]*tork.ScheduledJob, len(rs))
+	for i, r := range rs {
+		createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+		sj, err := r.toScheduledJob(createdBy)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = sj
+	}
+	return result, nil
+}
+
+func (ds *PostgresDatastore) DeleteScheduledJob(ctx context.Context, id string) error {
+	res, err := ds.exec(`delete from scheduled_jobs where id = $1`, id)
+	if err != nil {
+		return errors.Wrapf(err, "error deleting scheduled job from db")
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "error getting the number of deleted scheduled jobs")
+	}
+	if rows == 0 {
+		return datastore.ErrScheduledJobNotFound
+	}
+	return nil
+}
+
+func (ds *PostgresDatastore) CreateJobTemplate(ctx context.Context, t *tork.JobTemplate) error {
+	if t.ID == "" {
+		return errors.Errorf("job template id must not be empty")
+	}
+	if t.CreatedBy == nil {
+		guest, err := ds.GetUser(ctx, tork.USER_GUEST)
+		if err != nil {
+			return err
+		}
+		t.CreatedBy = guest
+	}
+	var perms *string
+	if len(t.Permissions) > 0 {
+		b, err := json.Marshal(t.Permissions)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize jobTemplate.permissions")
+		}
+		s := string(b)
+		perms = &s
+	}
+	q := `insert into job_templates (id,name,version,description,created_at,created_by,permissions,job) 
+	      select $1,$2,coalesce(max(version),0)+1,$3,$4,$5,$6,$7
+	      from job_templates 
+	      where name = $2
+	      returning version`
+	if err := ds.get(&t.Version, q, t.ID, t.Name, t.Description, t.CreatedAt, t.CreatedBy.ID, perms, string(t.Job)); err != nil {
+		return errors.Wrapf(err, "error inserting job template to the db")
+	}
+	return nil
+}
+
+func (ds *PostgresDatastore) GetJobTemplate(ctx context.Context, name string, version int) (*tork.JobTemplate, error) {
+	r := jobTemplateRecord{}
+	q := `SELECT * 
+	      FROM job_templates 
+	      where name = $1 and ($2 = 0 or version = $2)
+	      ORDER BY version DESC 
+	      LIMIT 1`
+	if err := ds.get(&r, q, name, version); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrJobTemplateNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching job template from db")
+	}
+	createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+	if err != nil {
+		return nil, err
+	}
+	return r.toJobTemplate(createdBy)
+}
+
+func (ds *PostgresDatastore) GetJobTemplates(ctx context.Context, currentUser string, page, size int) (*datastore.Page[*tork.JobTemplate], error) {
+	offset := (page - 1) * size
+	with := `
+	  WITH role_info AS (
+	    SELECT r.slug
+	    FROM users u
+	    JOIN users_roles ur ON ur.user_id = u.id
+	    JOIN roles r ON r.id = ur.role_id
+	    WHERE u.username_ = $1
+	  )`
+	where := `
+	  WHERE t.version = (select max(version) from job_templates t2 where t2.name = t.name)
+	  AND ($1 = '' OR (t.permissions is null or exists (
+	        select 1 
+	        from jsonb_array_elements(t.permissions) p
+	        where p->'user'->>'username' = $1
+	        or p->'role'->>'slug' in (select slug from role_info)
+	      )))`
+	rs := make([]jobTemplateRecord, 0)
+	qry := fmt.Sprintf(`%s
+	  SELECT t.* 
+	  FROM job_templates t %s
+	  ORDER BY t.name ASC 
+	  LIMIT %d OFFSET %d`, with, where, size, offset)
+	if err := ds.select_(&rs, qry, currentUser); err != nil {
+		return nil, errors.Wrapf(err, "error getting a page of job templates")
+	}
+	result := make([]*tork.JobTemplate, len(rs))
+	for i, r := range rs {
+		createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+		t, err := r.toJobTemplate(createdBy)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = t
+	}
+	var count *int
+	if err := ds.get(&count, fmt.Sprintf(`%s SELECT count(*) FROM job_templates t %s`, with, where), currentUser); err != nil {
+		return nil, errors.Wrapf(err, "error getting the job templates count")
+	}
+	totalPages := *count / size
+	if *count%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.JobTemplate]{
+		Items:      result,
+		Number:     page,
+		Size:       len(result),
+		TotalPages: totalPages,
+		TotalItems: *count,
+	}, nil
+}
+
+func (ds *PostgresDatastore) DeleteJobTemplate(ctx context.Context, name string, version int) error {
+	res, err := ds.exec(`delete from job_templates where name = $1 and ($2 = 0 or version = $2)`, name, version)
+	if err != nil {
+		return errors.Wrapf(err, "error deleting job template from db")
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "error getting the number of deleted job templates")
+	}
+	if rows == 0 {
+		return datastore.ErrJobTemplateNotFound
+	}
+	return nil
+}
+
+func (ds *PostgresDatastore) CreateLibraryTask(ctx context.Context, t *tork.LibraryTask) error {
+	if t.ID == "" {
+		return errors.Errorf("library task id must not be empty")
+	}
+	if t.CreatedBy == nil {
+		guest, err := ds.GetUser(ctx, tork.USER_GUEST)
+		if err != nil {
+			return err
+		}
+		t.CreatedBy = guest
+	}
+	var params *string
+	if len(t.Params) > 0 {
+		s := string(t.Params)
+		params = &s
+	}
+	q := `insert into library_tasks (id,name,version,description,created_at,created_by,params,task) 
+	      select $1,$2,coalesce(max(version),0)+1,$3,$4,$5,$6,$7
+	      from library_tasks 
+	      where name = $2
+	      returning version`
+	if err := ds.get(&t.Version, q, t.ID, t.Name, t.Description, t.CreatedAt, t.CreatedBy.ID, params, string(t.Task)); err != nil {
+		return errors.Wrapf(err, "error inserting library task to the db")
+	}
+	return nil
+}
+
+func (ds *PostgresDatastore) GetLibraryTask(ctx context.Context, name string, version int) (*tork.LibraryTask, error) {
+	r := libraryTaskRecord{}
+	q := `SELECT * 
+	      FROM library_tasks 
+	      where name = $1 and ($2 = 0 or version = $2)
+	      ORDER BY version DESC 
+	      LIMIT 1`
+	if err := ds.get(&r, q, name, version); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrLibraryTaskNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching library task from db")
+	}
+	createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+	if err != nil {
+		return nil, err
+	}
+	return r.toLibraryTask(createdBy), nil
+}
+
+func (ds *PostgresDatastore) GetLibraryTasks(ctx context.Context, page, size int) (*datastore.Page[*tork.LibraryTask], error) {
+	offset := (page - 1) * size
+	where := `WHERE t.version = (select max(version) from library_tasks t2 where t2.name = t.name)`
+	rs := make([]libraryTaskRecord, 0)
+	qry := fmt.Sprintf(`
+	  SELECT t.* 
+	  FROM library_tasks t %s
+	  ORDER BY t.name ASC 
+	  LIMIT %d OFFSET %d`, where, size, offset)
+	if err := ds.select_(&rs, qry); err != nil {
+		return nil, errors.Wrapf(err, "error getting a page of library tasks")
+	}
+	result := make([]*tork.LibraryTask, len(rs))
+	for i, r := range rs {
+		createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = r.toLibraryTask(createdBy)
+	}
+	var count *int
+	if err := ds.get(&count, fmt.Sprintf(`SELECT count(*) FROM library_tasks t %s`, where)); err != nil {
+		return nil, errors.Wrapf(err, "error getting the library tasks count")
+	}
+	totalPages := *count / size
+	if *count%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.LibraryTask]{
+		Items:      result,
+		Number:     page,
+		Size:       len(result),
+		TotalPages: totalPages,
+		TotalItems: *count,
+	}, nil
+}
+
+func (ds *PostgresDatastore) DeleteLibraryTask(ctx context.Context, name string, version int) error {
+	res, err := ds.exec(`delete from library_tasks where name = $1 and ($2 = 0 or version = $2)`, name, version)
+	if err != nil {
+		return errors.Wrapf(err, "error deleting library task from db")
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "error getting the number of deleted library tasks")
+	}
+	if rows == 0 {
+		return datastore.ErrLibraryTaskNotFound
+	}
+	return nil
+}
+
 func (ds *PostgresDatastore) GetUser(ctx context.Context, uid string) (*tork.User, error) {
 	r := userRecord{}
 	if err := ds.get(&r, `SELECT * FROM users where (username_ = $1 or id = $1)`, uid); err != nil {
@@ -1034,6 +1933,93 @@ func (ds *PostgresDatastore) CreateUser(ctx context.Context, u *tork.User) error
 	return nil
 }
 
+func (ds *PostgresDatastore) GetUsers(ctx context.Context, page, size int) (*datastore.Page[*tork.User], error) {
+	offset := (page - 1) * size
+	rs := make([]userRecord, 0)
+	qry := fmt.Sprintf(`SELECT * 
+	      FROM users 
+	      ORDER BY username_ ASC 
+	      OFFSET %d LIMIT %d`, offset, size)
+	if err := ds.select_(&rs, qry); err != nil {
+		return nil, errors.Wrapf(err, "error getting a page of users")
+	}
+	result := make([]*tork.User, len(rs))
+	for i, r := range rs {
+		result[i] = r.toUser()
+	}
+	var count *int
+	if err := ds.get(&count, `select count(*) from users`); err != nil {
+		return nil, errors.Wrapf(err, "error getting the users count")
+	}
+	totalPages := *count / size
+	if *count%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.User]{
+		Items:      result,
+		Number:     page,
+		Size:       len(result),
+		TotalPages: totalPages,
+		TotalItems: *count,
+	}, nil
+}
+
+func (ds *PostgresDatastore) UpdateUser(ctx context.Context, id string, modify func(u *tork.User) error) error {
+	return ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		ptx, ok := tx.(*PostgresDatastore)
+		if !ok {
+			return errors.New("unable to cast to a postgres datastore")
+		}
+		r := userRecord{}
+		if err := ptx.get(&r, `SELECT * FROM users where id = $1 for update`, id); err != nil {
+			if err == sql.ErrNoRows {
+				return datastore.ErrUserNotFound
+			}
+			return errors.Wrapf(err, "error fetching user from db")
+		}
+		u := r.toUser()
+		if err := modify(u); err != nil {
+			return err
+		}
+		q := `update users set 
+				name = $1,
+				password_ = $2,
+				is_disabled = $3
+			  where id = $4`
+		if _, err := ptx.exec(q, u.Name, u.PasswordHash, u.Disabled, r.ID); err != nil {
+			return errors.Wrapf(err, "error updating user %s", r.ID)
+		}
+		return nil
+	})
+}
+
+func (ds *PostgresDatastore) DeleteUser(ctx context.Context, id string) error {
+	return ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		ptx, ok := tx.(*PostgresDatastore)
+		if !ok {
+			return errors.New("unable to cast to a postgres datastore")
+		}
+		if _, err := ptx.exec(`delete from users_roles where user_id = $1`, id); err != nil {
+			return errors.Wrapf(err, "error deleting user roles from db")
+		}
+		res, err := ptx.exec(`delete from users where id = $1`, id)
+		if err != nil {
+			if isForeignKeyViolation(err) {
+				return datastore.ErrUserInUse
+			}
+			return errors.Wrapf(err, "error deleting user from db")
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrapf(err, "error getting the number of deleted users")
+		}
+		if rows == 0 {
+			return datastore.ErrUserNotFound
+		}
+		return nil
+	})
+}
+
 func (ds *PostgresDatastore) CreateRole(ctx context.Context, r *tork.Role) error {
 	r.ID = uuid.NewUUID()
 	now := time.Now().UTC()
@@ -1052,6 +2038,9 @@ func (ds *PostgresDatastore) CreateRole(ctx context.Context, r *tork.Role) error
 func (ds *PostgresDatastore) GetRole(ctx context.Context, id string) (*tork.Role, error) {
 	r := roleRecord{}
 	if err := ds.get(&r, `SELECT * FROM roles where id = $1 or slug = $1`, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrRoleNotFound
+		}
 		return nil, errors.Wrapf(err, "error fetching role from db")
 	}
 	return r.toRole(), nil
@@ -1069,6 +2058,33 @@ func (ds *PostgresDatastore) GetRoles(ctx context.Context) ([]*tork.Role, error)
 	return result, nil
 }
 
+func (ds *PostgresDatastore) DeleteRole(ctx context.Context, id string) error {
+	return ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		ptx, ok := tx.(*PostgresDatastore)
+		if !ok {
+			return errors.New("unable to cast to a postgres datastore")
+		}
+		if _, err := ptx.exec(`delete from users_roles where role_id = $1`, id); err != nil {
+			return errors.Wrapf(err, "error deleting user roles from db")
+		}
+		res, err := ptx.exec(`delete from roles where id = $1`, id)
+		if err != nil {
+			if isForeignKeyViolation(err) {
+				return datastore.ErrRoleInUse
+			}
+			return errors.Wrapf(err, "error deleting role from db")
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrapf(err, "error getting the number of deleted roles")
+		}
+		if rows == 0 {
+			return datastore.ErrRoleNotFound
+		}
+		return nil
+	})
+}
+
 func (ds *PostgresDatastore) GetUserRoles(ctx context.Context, userID string) ([]*tork.Role, error) {
 	rs := []roleRecord{}
 	if err := ds.select_(&rs, `SELECT r.* FROM roles r inner join users_roles ur on ur.role_id=r.id where ur.user_id = $1`, userID); err != nil {
@@ -1123,6 +2139,20 @@ func (ds *PostgresDatastore) GetMetrics(ctx context.Context) (*tork.Metrics, err
 	return s, nil
 }
 
+// isForeignKeyViolation returns true if the error is the result
+// of deleting a row which is still referenced by another table.
+func isForeignKeyViolation(err error) bool {
+	var pqerr *pq.Error
+	return errors.As(err, &pqerr) && pqerr.Code == "23503"
+}
+
+// isUniqueViolation returns true if the error is the
+// result of violating a unique constraint.
+func isUniqueViolation(err error) bool {
+	var pqerr *pq.Error
+	return errors.As(err, &pqerr) && pqerr.Code == "23505"
+}
+
 func (ds *PostgresDatastore) get(dest interface{}, query string, args ...interface{}) error {
 	if ds.tx != nil {
 		return ds.tx.Get(dest, query, args...)
@@ -1194,6 +2224,18 @@ func (ds *PostgresDatastore) HealthCheck(ctx context.Context) error {
 	return nil
 }
 
+func serializeConcurrency(c *tork.JobConcurrency) (*string, error) {
+	if c == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	return &s, nil
+}
+
 func parseQuery(query string) (string, []string) {
 	terms := []string{}
 	tags := []string{}
@@ -10,6 +10,8 @@ import (
 	"time"
 
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/archive"
+	"github.com/runabol/tork/archive/local"
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/db/postgres"
 
@@ -50,6 +52,11 @@ func TestPostgresCreateAndGetTask(t *testing.T) {
 		Ports: []*tork.Port{{
 			Port: "1234",
 		}},
+		Artifacts: &tork.TaskArtifacts{
+			Outputs: []tork.TaskArtifact{{Name: "report", Path: "report.txt"}},
+		},
+		NodeSelector: map[string]string{"zone": "a"},
+		Affinity:     map[string]string{"arch": "arm64"},
 	}
 	err = ds.CreateTask(ctx, &t1)
 	assert.NoError(t, err)
@@ -59,6 +66,8 @@ func TestPostgresCreateAndGetTask(t *testing.T) {
 	assert.Equal(t, t1.Description, t2.Description)
 	assert.Equal(t, []string([]string{"some-network"}), t2.Networks)
 	assert.Equal(t, map[string]string{"myfile": "hello world"}, t2.Files)
+	assert.Equal(t, map[string]string{"zone": "a"}, t2.NodeSelector)
+	assert.Equal(t, map[string]string{"arch": "arm64"}, t2.Affinity)
 	assert.Equal(t, "me", t2.Registry.Username)
 	assert.Equal(t, "secret", t2.Registry.Password)
 	assert.Equal(t, "all", t2.GPUs)
@@ -68,6 +77,7 @@ func TestPostgresCreateAndGetTask(t *testing.T) {
 	assert.Equal(t, "/some/dir", t2.Workdir)
 	assert.Equal(t, 2, t2.Priority)
 	assert.Equal(t, "1234", t2.Ports[0].Port)
+	assert.Equal(t, t1.Artifacts, t2.Artifacts)
 }
 
 func TestPostgresCreateJob(t *testing.T) {
@@ -345,6 +355,7 @@ func TestPostgresCreateAndGetNode(t *testing.T) {
 		Hostname: "some-name",
 		Port:     1234,
 		Version:  "1.0.0",
+		Queues:   []string{"default", "gpu"},
 	}
 	err = ds.CreateNode(ctx, n1)
 	assert.NoError(t, err)
@@ -355,6 +366,7 @@ func TestPostgresCreateAndGetNode(t *testing.T) {
 	assert.Equal(t, 1234, n2.Port)
 	assert.Equal(t, "1.0.0", n2.Version)
 	assert.Equal(t, "some node", n2.Name)
+	assert.Equal(t, []string{"default", "gpu"}, n2.Queues)
 }
 
 func TestPostgresUpdateNode(t *testing.T) {
@@ -575,6 +587,71 @@ func TestPostgresUpdateJob(t *testing.T) {
 	assert.Equal(t, float64(56), j2.Progress)
 }
 
+func TestPostgresPauseJob(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+	j1 := tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateRunning,
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	pausedAt := time.Now().UTC()
+	err = ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+		u.State = tork.JobStatePaused
+		u.PausedAt = &pausedAt
+		return nil
+	})
+	assert.NoError(t, err)
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStatePaused, j2.State)
+	assert.Equal(t, pausedAt.Unix(), j2.PausedAt.Unix())
+	err = ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+		u.State = tork.JobStateRunning
+		u.PausedAt = nil
+		return nil
+	})
+	assert.NoError(t, err)
+	j2, err = ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateRunning, j2.State)
+	assert.Nil(t, j2.PausedAt)
+}
+
+func TestPostgresUpdateJobRerun(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+	j1 := tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateFailed,
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	err = ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+		u.State = tork.JobStatePending
+		u.Rerun = &tork.JobRerun{FromTask: "some-task", OnlyFailed: true}
+		return nil
+	})
+	assert.NoError(t, err)
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, &tork.JobRerun{FromTask: "some-task", OnlyFailed: true}, j2.Rerun)
+	err = ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+		u.State = tork.JobStateScheduled
+		u.Rerun = nil
+		return nil
+	})
+	assert.NoError(t, err)
+	j2, err = ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, j2.Rerun)
+}
+
 func TestPostgresUpdateJobConcurrently(t *testing.T) {
 	ctx := context.Background()
 	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
@@ -1367,6 +1444,127 @@ func TestPostgresCreateRole(t *testing.T) {
 	assert.Len(t, uroles, 0)
 }
 
+func TestPostgresDeleteRole(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+	r := &tork.Role{
+		Slug: "test-role-" + uuid.NewUUID(),
+		Name: "Test Role",
+	}
+	err = ds.CreateRole(ctx, r)
+	assert.NoError(t, err)
+
+	u := &tork.User{
+		Username: uuid.NewShortUUID(),
+		Name:     "Tester",
+	}
+	err = ds.CreateUser(ctx, u)
+	assert.NoError(t, err)
+
+	err = ds.AssignRole(ctx, u.ID, r.ID)
+	assert.NoError(t, err)
+
+	err = ds.DeleteRole(ctx, r.ID)
+	assert.NoError(t, err)
+
+	_, err = ds.GetRole(ctx, r.ID)
+	assert.ErrorIs(t, err, datastore.ErrRoleNotFound)
+
+	uroles, err := ds.GetUserRoles(ctx, u.ID)
+	assert.NoError(t, err)
+	assert.Len(t, uroles, 0)
+
+	err = ds.DeleteRole(ctx, r.ID)
+	assert.ErrorIs(t, err, datastore.ErrRoleNotFound)
+}
+
+func TestPostgresGetUsers(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		err := ds.CreateUser(ctx, &tork.User{
+			Username: uuid.NewShortUUID(),
+		})
+		assert.NoError(t, err)
+	}
+	p1, err := ds.GetUsers(ctx, 1, 2)
+	assert.NoError(t, err)
+	assert.Len(t, p1.Items, 2)
+	assert.GreaterOrEqual(t, p1.TotalItems, 4)
+}
+
+func TestPostgresUpdateUser(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+	u := &tork.User{
+		Username: uuid.NewShortUUID(),
+		Name:     "Tester",
+	}
+	err = ds.CreateUser(ctx, u)
+	assert.NoError(t, err)
+
+	err = ds.UpdateUser(ctx, u.ID, func(u *tork.User) error {
+		u.Disabled = true
+		u.PasswordHash = "some-hash"
+		return nil
+	})
+	assert.NoError(t, err)
+
+	u2, err := ds.GetUser(ctx, u.Username)
+	assert.NoError(t, err)
+	assert.True(t, u2.Disabled)
+	assert.Equal(t, "some-hash", u2.PasswordHash)
+
+	err = ds.UpdateUser(ctx, uuid.NewUUID(), func(u *tork.User) error {
+		return nil
+	})
+	assert.ErrorIs(t, err, datastore.ErrUserNotFound)
+}
+
+func TestPostgresDeleteUser(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+	u := &tork.User{
+		Username: uuid.NewShortUUID(),
+	}
+	err = ds.CreateUser(ctx, u)
+	assert.NoError(t, err)
+
+	err = ds.DeleteUser(ctx, u.ID)
+	assert.NoError(t, err)
+
+	_, err = ds.GetUser(ctx, u.ID)
+	assert.ErrorIs(t, err, datastore.ErrUserNotFound)
+
+	err = ds.DeleteUser(ctx, u.ID)
+	assert.ErrorIs(t, err, datastore.ErrUserNotFound)
+
+	// users who created jobs can't be deleted
+	u2 := &tork.User{
+		Username: uuid.NewShortUUID(),
+	}
+	err = ds.CreateUser(ctx, u2)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	err = ds.CreateJob(ctx, &tork.Job{
+		ID:        uuid.NewUUID(),
+		CreatedAt: now,
+		CreatedBy: u2,
+		Context:   tork.JobContext{},
+	})
+	assert.NoError(t, err)
+	err = ds.DeleteUser(ctx, u2.ID)
+	assert.ErrorIs(t, err, datastore.ErrUserInUse)
+}
+
 func TestPostgresGetNextTask(t *testing.T) {
 	ctx := context.Background()
 	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
@@ -1419,3 +1617,731 @@ func TestPostgresGetNextTask(t *testing.T) {
 	_, err = ds.GetNextTask(ctx, childTaskID)
 	assert.Error(t, err)
 }
+
+func TestPostgresGetJobsByConcurrencyKey(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	key := uuid.NewShortUUID()
+	states := []tork.JobState{
+		tork.JobStateRunning,
+		tork.JobStatePending,
+		tork.JobStateCompleted,
+	}
+	for i, state := range states {
+		err := ds.CreateJob(ctx, &tork.Job{
+			ID:          uuid.NewUUID(),
+			State:       state,
+			CreatedAt:   now.Add(time.Duration(i) * time.Second),
+			Concurrency: &tork.JobConcurrency{Key: key, Limit: 1},
+		})
+		assert.NoError(t, err)
+	}
+
+	err = ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		err := tx.LockConcurrencyKey(ctx, key)
+		assert.NoError(t, err)
+		jobs, err := tx.GetJobsByConcurrencyKey(ctx, key)
+		assert.NoError(t, err)
+		assert.Len(t, jobs, 2)
+		assert.Equal(t, tork.JobStateRunning, jobs[0].State)
+		assert.Equal(t, tork.JobStatePending, jobs[1].State)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	j, err := ds.GetJobsByConcurrencyKey(ctx, "no-such-key")
+	assert.NoError(t, err)
+	assert.Len(t, j, 0)
+
+	// the lock is only held by transactions
+	err = ds.LockConcurrencyKey(ctx, key)
+	assert.Error(t, err)
+}
+
+func TestPostgresGetJobByIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+	key := uuid.NewUUID()
+	j1 := &tork.Job{
+		ID:             uuid.NewUUID(),
+		State:          tork.JobStatePending,
+		CreatedAt:      time.Now().UTC(),
+		IdempotencyKey: key,
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	j2, err := ds.GetJobByIdempotencyKey(ctx, tork.USER_GUEST, key)
+	assert.NoError(t, err)
+	assert.Equal(t, j1.ID, j2.ID)
+	assert.Equal(t, key, j2.IdempotencyKey)
+
+	_, err = ds.GetJobByIdempotencyKey(ctx, tork.USER_GUEST, "no-such-key")
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+
+	// the key is taken
+	err = ds.CreateJob(ctx, &tork.Job{
+		ID:             uuid.NewUUID(),
+		State:          tork.JobStatePending,
+		CreatedAt:      time.Now().UTC(),
+		IdempotencyKey: key,
+	})
+	assert.ErrorIs(t, err, datastore.ErrDuplicateIdempotencyKey)
+
+	// release the key
+	err = ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+		u.IdempotencyKey = ""
+		return nil
+	})
+	assert.NoError(t, err)
+
+	j3 := &tork.Job{
+		ID:             uuid.NewUUID(),
+		State:          tork.JobStatePending,
+		CreatedAt:      time.Now().UTC(),
+		IdempotencyKey: key,
+	}
+	err = ds.CreateJob(ctx, j3)
+	assert.NoError(t, err)
+
+	j2, err = ds.GetJobByIdempotencyKey(ctx, tork.USER_GUEST, key)
+	assert.NoError(t, err)
+	assert.Equal(t, j3.ID, j2.ID)
+
+	// keys are scoped to the user who submitted the job
+	u1 := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: uuid.NewShortUUID(),
+		Name:     "Tester",
+	}
+	err = ds.CreateUser(ctx, u1)
+	assert.NoError(t, err)
+
+	_, err = ds.GetJobByIdempotencyKey(ctx, u1.Username, key)
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+
+	j4 := &tork.Job{
+		ID:             uuid.NewUUID(),
+		State:          tork.JobStatePending,
+		CreatedAt:      time.Now().UTC(),
+		CreatedBy:      u1,
+		IdempotencyKey: key,
+	}
+	err = ds.CreateJob(ctx, j4)
+	assert.NoError(t, err)
+
+	j2, err = ds.GetJobByIdempotencyKey(ctx, u1.Username, key)
+	assert.NoError(t, err)
+	assert.Equal(t, j4.ID, j2.ID)
+
+	j2, err = ds.GetJobByIdempotencyKey(ctx, tork.USER_GUEST, key)
+	assert.NoError(t, err)
+	assert.Equal(t, j3.ID, j2.ID)
+}
+
+func TestPostgresScheduledJobs(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	next := now.Add(time.Minute)
+	sj := &tork.ScheduledJob{
+		ID:        uuid.NewUUID(),
+		Name:      "test scheduled job",
+		Cron:      "* * * * *",
+		State:     tork.ScheduledJobStateActive,
+		CreatedAt: now,
+		NextRunAt: &next,
+		Secrets:   map[string]string{"key": "secret"},
+		InputTypes: map[string]tork.InputType{
+			"replicas": tork.InputTypeInt,
+		},
+		Tasks: []*tork.Task{{
+			Name: "task-1",
+		}},
+	}
+	err = ds.CreateScheduledJob(ctx, sj)
+	assert.NoError(t, err)
+
+	sj2, err := ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, sj.Name, sj2.Name)
+	assert.Equal(t, "secret", sj2.Secrets["key"])
+	assert.Equal(t, tork.InputTypeInt, sj2.InputTypes["replicas"])
+	assert.Equal(t, tork.USER_GUEST, sj2.CreatedBy.Username)
+	assert.Len(t, sj2.Tasks, 1)
+
+	p, err := ds.GetScheduledJobs(ctx, 1, 10)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, p.TotalItems, 1)
+
+	err = ds.UpdateScheduledJob(ctx, sj.ID, func(u *tork.ScheduledJob) error {
+		u.State = tork.ScheduledJobStatePaused
+		u.LastRunAt = &now
+		return nil
+	})
+	assert.NoError(t, err)
+
+	active, err := ds.GetActiveScheduledJobs(ctx)
+	assert.NoError(t, err)
+	for _, a := range active {
+		assert.NotEqual(t, sj.ID, a.ID)
+	}
+
+	sj2, err = ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.ScheduledJobStatePaused, sj2.State)
+	assert.NotNil(t, sj2.LastRunAt)
+
+	err = ds.DeleteScheduledJob(ctx, sj.ID)
+	assert.NoError(t, err)
+
+	_, err = ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.ErrorIs(t, err, datastore.ErrScheduledJobNotFound)
+}
+
+func TestPostgresGetReadyTasks(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+	j1 := &tork.Job{
+		ID: uuid.NewUUID(),
+		Tasks: []*tork.Task{
+			{Name: "task-a", Var: "a"},
+			{Name: "task-b", Var: "b", DependsOn: []string{"a"}},
+			{Name: "task-c", Var: "c"},
+		},
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	ready, err := ds.GetReadyTasks(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Len(t, ready, 2)
+	assert.Equal(t, "task-a", ready[0].Name)
+	assert.Equal(t, 1, ready[0].Position)
+	assert.Equal(t, "task-c", ready[1].Name)
+	assert.Equal(t, 3, ready[1].Position)
+
+	now := time.Now().UTC()
+	err = ds.CreateTask(ctx, &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		Position:  1,
+		Var:       "a",
+		State:     tork.TaskStateCompleted,
+		CreatedAt: &now,
+	})
+	assert.NoError(t, err)
+
+	ready, err = ds.GetReadyTasks(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Len(t, ready, 2)
+	assert.Equal(t, "task-b", ready[0].Name)
+	assert.Equal(t, []string{"a"}, ready[0].DependsOn)
+	assert.Equal(t, "task-c", ready[1].Name)
+}
+
+func TestPostgresGetDelayedTasks(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	notBefore := now.Add(time.Minute)
+	t1 := tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateCreated,
+		CreatedAt: &now,
+		NotBefore: &notBefore,
+		Retry: &tork.TaskRetry{
+			Limit:        2,
+			Attempts:     1,
+			InitialDelay: "1m",
+			RetryOn:      []string{"137"},
+		},
+	}
+	err = ds.CreateTask(ctx, &t1)
+	assert.NoError(t, err)
+
+	delayed, err := ds.GetDelayedTasks(ctx, now)
+	assert.NoError(t, err)
+	for _, d := range delayed {
+		assert.NotEqual(t, t1.ID, d.ID)
+	}
+
+	delayed, err = ds.GetDelayedTasks(ctx, notBefore)
+	assert.NoError(t, err)
+	found := false
+	for _, d := range delayed {
+		if d.ID == t1.ID {
+			found = true
+			assert.Equal(t, "1m", d.Retry.InitialDelay)
+			assert.Equal(t, []string{"137"}, d.Retry.RetryOn)
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestPostgresRetentionPolicy(t *testing.T) {
+	ctx := context.Background()
+	keepTag := uuid.NewUUID()
+	expireTag := uuid.NewUUID()
+	keep, err := datastore.NewRetentionRule("", keepTag, "forever")
+	assert.NoError(t, err)
+	failed, err := datastore.NewRetentionRule("FAILED", expireTag, "30d")
+	assert.NoError(t, err)
+	completed, err := datastore.NewRetentionRule("COMPLETED", expireTag, "7d")
+	assert.NoError(t, err)
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn, WithDisableCleanup(true), WithRetentionPolicy(datastore.RetentionPolicy{keep, failed, completed}))
+	assert.NoError(t, err)
+
+	createJob := func(state tork.JobState, age time.Duration, tags ...string) *tork.Job {
+		j := &tork.Job{
+			ID:        uuid.NewUUID(),
+			CreatedAt: time.Now().UTC().Add(-age),
+			Tags:      tags,
+		}
+		err := ds.CreateJob(ctx, j)
+		assert.NoError(t, err)
+		finishedAt := time.Now().UTC().Add(-age)
+		err = ds.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
+			u.State = state
+			switch state {
+			case tork.JobStateCompleted:
+				u.CompletedAt = &finishedAt
+			case tork.JobStateFailed:
+				u.FailedAt = &finishedAt
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		return j
+	}
+
+	oldFailed := createJob(tork.JobStateFailed, time.Hour*24*40, expireTag)
+	newFailed := createJob(tork.JobStateFailed, time.Hour*24*10, expireTag)
+	oldCompleted := createJob(tork.JobStateCompleted, time.Hour*24*10, expireTag)
+	audited := createJob(tork.JobStateCompleted, time.Hour*24*10, expireTag, keepTag)
+	running := createJob(tork.JobStateRunning, time.Hour*24*40, expireTag)
+
+	expiredIDs := func() []string {
+		p, err := ds.GetExpiredJobs(ctx, "", 1, 20)
+		assert.NoError(t, err)
+		ids := make([]string, len(p.Items))
+		for i, j := range p.Items {
+			ids[i] = j.ID
+		}
+		return ids
+	}
+
+	ids := expiredIDs()
+	assert.Contains(t, ids, oldFailed.ID)
+	assert.Contains(t, ids, oldCompleted.ID)
+	assert.NotContains(t, ids, newFailed.ID)
+	assert.NotContains(t, ids, audited.ID)
+	assert.NotContains(t, ids, running.ID)
+
+	n, err := ds.expungeExpiredJobs()
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, n, 2)
+
+	_, err = ds.GetJobByID(ctx, oldFailed.ID)
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+	_, err = ds.GetJobByID(ctx, oldCompleted.ID)
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+	for _, j := range []*tork.Job{newFailed, audited, running} {
+		_, err = ds.GetJobByID(ctx, j.ID)
+		assert.NoError(t, err)
+	}
+
+	ids = expiredIDs()
+	assert.NotContains(t, ids, oldFailed.ID)
+	assert.NotContains(t, ids, oldCompleted.ID)
+}
+
+func TestPostgresArchiveExpiredJobs(t *testing.T) {
+	ctx := context.Background()
+	sink, err := local.NewLocalSink(t.TempDir())
+	assert.NoError(t, err)
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn, WithDisableCleanup(true), WithArchive(sink))
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+	j1 := tork.Job{
+		ID:    uuid.NewUUID(),
+		Name:  "test job",
+		State: tork.JobStateCompleted,
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+
+	t1 := tork.Task{
+		ID:        uuid.NewUUID(),
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}
+	err = ds.CreateTask(ctx, &t1)
+	assert.NoError(t, err)
+
+	err = ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+		Number:   1,
+		TaskID:   t1.ID,
+		Contents: "hello world",
+	})
+	assert.NoError(t, err)
+
+	past := now.Add(-time.Minute)
+	err = ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+		u.DeleteAt = &past
+		return nil
+	})
+	assert.NoError(t, err)
+
+	n, err := ds.expungeExpiredJobs()
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, n, 1)
+
+	_, err = ds.GetJobByID(ctx, j1.ID)
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+
+	b, err := archive.Read(ctx, sink, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "test job", b.Job.Name)
+	assert.Len(t, b.Job.Execution, 1)
+	assert.Equal(t, t1.ID, b.Job.Execution[0].ID)
+	assert.Len(t, b.Logs, 1)
+	assert.Equal(t, "hello world", b.Logs[0].Contents)
+}
+
+func TestPostgresJobTemplates(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+
+	u1 := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: uuid.NewShortUUID(),
+		Name:     "Tester",
+	}
+	err = ds.CreateUser(ctx, u1)
+	assert.NoError(t, err)
+
+	u2 := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: uuid.NewShortUUID(),
+		Name:     "Tester",
+	}
+	err = ds.CreateUser(ctx, u2)
+	assert.NoError(t, err)
+
+	r := &tork.Role{
+		Slug: uuid.NewShortUUID(),
+		Name: "Test Role",
+	}
+	err = ds.CreateRole(ctx, r)
+	assert.NoError(t, err)
+
+	err = ds.AssignRole(ctx, u2.ID, r.ID)
+	assert.NoError(t, err)
+
+	name := uuid.NewShortUUID()
+	for i := 0; i < 2; i++ {
+		tk := &tork.JobTemplate{
+			ID:          uuid.NewUUID(),
+			Name:        name,
+			Description: fmt.Sprintf("version %d", i+1),
+			CreatedAt:   time.Now().UTC(),
+			CreatedBy:   u1,
+			Job:         []byte(`{"name":"test job"}`),
+		}
+		err = ds.CreateJobTemplate(ctx, tk)
+		assert.NoError(t, err)
+		assert.Equal(t, i+1, tk.Version)
+	}
+
+	private := &tork.JobTemplate{
+		ID:          uuid.NewUUID(),
+		Name:        uuid.NewShortUUID(),
+		CreatedAt:   time.Now().UTC(),
+		CreatedBy:   u1,
+		Permissions: []*tork.Permission{{Role: r}},
+		Job:         []byte(`{"name":"private job"}`),
+	}
+	err = ds.CreateJobTemplate(ctx, private)
+	assert.NoError(t, err)
+
+	latest, err := ds.GetJobTemplate(ctx, name, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, latest.Version)
+	assert.Equal(t, "version 2", latest.Description)
+	assert.Equal(t, u1.Username, latest.CreatedBy.Username)
+	assert.JSONEq(t, `{"name":"test job"}`, string(latest.Job))
+
+	v1, err := ds.GetJobTemplate(ctx, name, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "version 1", v1.Description)
+
+	_, err = ds.GetJobTemplate(ctx, name, 3)
+	assert.ErrorIs(t, err, datastore.ErrJobTemplateNotFound)
+
+	p, err := ds.GetJobTemplates(ctx, u2.Username, 1, 100)
+	assert.NoError(t, err)
+	refs := make([]string, 0)
+	for _, item := range p.Items {
+		refs = append(refs, item.Ref())
+	}
+	assert.Contains(t, refs, name+"@2")
+	assert.NotContains(t, refs, name+"@1")
+	assert.Contains(t, refs, private.Ref())
+
+	p, err = ds.GetJobTemplates(ctx, u1.Username, 1, 100)
+	assert.NoError(t, err)
+	refs = make([]string, 0)
+	for _, item := range p.Items {
+		refs = append(refs, item.Ref())
+	}
+	assert.Contains(t, refs, name+"@2")
+	assert.NotContains(t, refs, private.Ref())
+
+	err = ds.DeleteJobTemplate(ctx, name, 2)
+	assert.NoError(t, err)
+
+	latest, err = ds.GetJobTemplate(ctx, name, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, latest.Version)
+
+	err = ds.DeleteJobTemplate(ctx, name, 0)
+	assert.NoError(t, err)
+
+	_, err = ds.GetJobTemplate(ctx, name, 0)
+	assert.ErrorIs(t, err, datastore.ErrJobTemplateNotFound)
+
+	err = ds.DeleteJobTemplate(ctx, name, 0)
+	assert.ErrorIs(t, err, datastore.ErrJobTemplateNotFound)
+}
+
+func TestPostgresLibraryTasks(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+
+	name := uuid.NewShortUUID()
+	for i := 0; i < 2; i++ {
+		lt := &tork.LibraryTask{
+			ID:          uuid.NewUUID(),
+			Name:        name,
+			Description: fmt.Sprintf("version %d", i+1),
+			CreatedAt:   time.Now().UTC(),
+			Params:      []byte(`{"path":{"required":true}}`),
+			Task:        []byte(`{"name":"lint","image":"golangci/golangci-lint"}`),
+		}
+		err = ds.CreateLibraryTask(ctx, lt)
+		assert.NoError(t, err)
+		assert.Equal(t, i+1, lt.Version)
+	}
+
+	latest, err := ds.GetLibraryTask(ctx, name, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, latest.Version)
+	assert.Equal(t, "version 2", latest.Description)
+	assert.NotNil(t, latest.CreatedBy)
+	assert.JSONEq(t, `{"path":{"required":true}}`, string(latest.Params))
+	assert.JSONEq(t, `{"name":"lint","image":"golangci/golangci-lint"}`, string(latest.Task))
+
+	v1, err := ds.GetLibraryTask(ctx, name, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "version 1", v1.Description)
+
+	_, err = ds.GetLibraryTask(ctx, name, 3)
+	assert.ErrorIs(t, err, datastore.ErrLibraryTaskNotFound)
+
+	p, err := ds.GetLibraryTasks(ctx, 1, 100)
+	assert.NoError(t, err)
+	refs := make([]string, 0)
+	for _, item := range p.Items {
+		refs = append(refs, item.Ref())
+	}
+	assert.Contains(t, refs, name+"@2")
+	assert.NotContains(t, refs, name+"@1")
+
+	err = ds.DeleteLibraryTask(ctx, name, 2)
+	assert.NoError(t, err)
+
+	latest, err = ds.GetLibraryTask(ctx, name, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, latest.Version)
+
+	err = ds.DeleteLibraryTask(ctx, name, 0)
+	assert.NoError(t, err)
+
+	_, err = ds.GetLibraryTask(ctx, name, 0)
+	assert.ErrorIs(t, err, datastore.ErrLibraryTaskNotFound)
+
+	err = ds.DeleteLibraryTask(ctx, name, 0)
+	assert.ErrorIs(t, err, datastore.ErrLibraryTaskNotFound)
+}
+
+func TestPostgresGetOrphanedTasks(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	up := &tork.Node{
+		ID:              uuid.NewUUID(),
+		LastHeartbeatAt: now,
+	}
+	err = ds.CreateNode(ctx, up)
+	assert.NoError(t, err)
+	down := &tork.Node{
+		ID:              uuid.NewUUID(),
+		LastHeartbeatAt: now.Add(-time.Minute * 10),
+	}
+	err = ds.CreateNode(ctx, down)
+	assert.NoError(t, err)
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	longAgo := now.Add(-time.Hour * 2)
+	running := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateRunning,
+		CreatedAt: &now,
+		NodeID:    up.ID,
+	}
+	orphaned := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateRunning,
+		CreatedAt: &now,
+		NodeID:    down.ID,
+	}
+	unknown := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateRunning,
+		CreatedAt: &now,
+		NodeID:    uuid.NewUUID(),
+	}
+	completed := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateCompleted,
+		CreatedAt: &now,
+		NodeID:    down.ID,
+	}
+	scheduled := &tork.Task{
+		ID:          uuid.NewUUID(),
+		JobID:       j1.ID,
+		State:       tork.TaskStateScheduled,
+		CreatedAt:   &longAgo,
+		ScheduledAt: &longAgo,
+	}
+	for _, tk := range []*tork.Task{running, orphaned, unknown, completed, scheduled} {
+		err = ds.CreateTask(ctx, tk)
+		assert.NoError(t, err)
+	}
+	ids := func(ts []*tork.Task) []string {
+		result := make([]string, 0)
+		for _, tk := range ts {
+			if tk.JobID == j1.ID {
+				result = append(result, tk.ID)
+			}
+		}
+		return result
+	}
+
+	ts, err := ds.GetOrphanedTasks(ctx, now.Add(-time.Minute), time.Time{})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{orphaned.ID, unknown.ID}, ids(ts))
+
+	ts, err = ds.GetOrphanedTasks(ctx, now.Add(-time.Minute), now.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{orphaned.ID, unknown.ID, scheduled.ID}, ids(ts))
+}
+
+func TestPostgresGetExpiredJobsWithPerms(t *testing.T) {
+	ctx := context.Background()
+	dsn := "host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable"
+	ds, err := NewPostgresDataStore(dsn, WithDisableCleanup(true))
+	assert.NoError(t, err)
+
+	owner := &tork.User{
+		Username: uuid.NewShortUUID(),
+		Name:     "Owner",
+	}
+	err = ds.CreateUser(ctx, owner)
+	assert.NoError(t, err)
+	other := &tork.User{
+		Username: uuid.NewShortUUID(),
+	}
+	err = ds.CreateUser(ctx, other)
+	assert.NoError(t, err)
+
+	past := time.Now().UTC().Add(-time.Hour)
+	// listed ahead of the expired jobs left by other tests
+	createdAt := time.Now().UTC().Add(-time.Hour * 24 * 365 * 10)
+	private := &tork.Job{
+		ID:          uuid.NewUUID(),
+		State:       tork.JobStateCompleted,
+		CreatedAt:   createdAt,
+		CreatedBy:   owner,
+		Permissions: []*tork.Permission{{User: owner}},
+	}
+	err = ds.CreateJob(ctx, private)
+	assert.NoError(t, err)
+	public := &tork.Job{
+		ID:        uuid.NewUUID(),
+		State:     tork.JobStateCompleted,
+		CreatedAt: createdAt,
+		CreatedBy: owner,
+	}
+	err = ds.CreateJob(ctx, public)
+	assert.NoError(t, err)
+	for _, j := range []*tork.Job{private, public} {
+		err = ds.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
+			u.DeleteAt = &past
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+
+	expiredIDs := func(username string) []string {
+		p, err := ds.GetExpiredJobs(ctx, username, 1, 20)
+		assert.NoError(t, err)
+		ids := make([]string, len(p.Items))
+		for i, j := range p.Items {
+			ids[i] = j.ID
+		}
+		return ids
+	}
+
+	ids := expiredIDs(owner.Username)
+	assert.Contains(t, ids, private.ID)
+	assert.Contains(t, ids, public.ID)
+
+	ids = expiredIDs(other.Username)
+	assert.NotContains(t, ids, private.ID)
+	assert.Contains(t, ids, public.ID)
+}
@@ -10,77 +10,146 @@ import (
 )
 
 type taskRecord struct {
-	ID          string         `db:"id"`
-	JobID       string         `db:"job_id"`
-	Position    int            `db:"position"`
-	Name        string         `db:"name"`
-	Description string         `db:"description"`
-	State       string         `db:"state"`
-	CreatedAt   time.Time      `db:"created_at"`
-	ScheduledAt *time.Time     `db:"scheduled_at"`
-	StartedAt   *time.Time     `db:"started_at"`
-	CompletedAt *time.Time     `db:"completed_at"`
-	FailedAt    *time.Time     `db:"failed_at"`
-	CMD         pq.StringArray `db:"cmd"`
-	Entrypoint  pq.StringArray `db:"entrypoint"`
-	Run         string         `db:"run_script"`
-	Image       string         `db:"image"`
-	Registry    []byte         `db:"registry"`
-	Env         []byte         `db:"env"`
-	Files       []byte         `db:"files_"`
-	Queue       string         `db:"queue"`
-	Error       string         `db:"error_"`
-	Pre         []byte         `db:"pre_tasks"`
-	Post        []byte         `db:"post_tasks"`
-	Mounts      []byte         `db:"mounts"`
-	Networks    pq.StringArray `db:"networks"`
-	NodeID      string         `db:"node_id"`
-	Retry       []byte         `db:"retry"`
-	Limits      []byte         `db:"limits"`
-	Timeout     string         `db:"timeout"`
-	Var         string         `db:"var"`
-	Result      string         `db:"result"`
-	Parallel    []byte         `db:"parallel"`
-	ParentID    string         `db:"parent_id"`
-	Each        []byte         `db:"each_"`
-	SubJob      []byte         `db:"subjob"`
-	SubJobID    string         `db:"subjob_id"`
-	GPUs        string         `db:"gpus"`
-	IF          string         `db:"if_"`
-	Tags        pq.StringArray `db:"tags"`
-	Priority    int            `db:"priority"`
-	Workdir     string         `db:"workdir"`
-	Progress    float64        `db:"progress"`
-	Ports       []byte         `db:"ports"`
+	ID           string         `db:"id"`
+	JobID        string         `db:"job_id"`
+	Position     int            `db:"position"`
+	Name         string         `db:"name"`
+	Description  string         `db:"description"`
+	State        string         `db:"state"`
+	CreatedAt    time.Time      `db:"created_at"`
+	ScheduledAt  *time.Time     `db:"scheduled_at"`
+	StartedAt    *time.Time     `db:"started_at"`
+	CompletedAt  *time.Time     `db:"completed_at"`
+	FailedAt     *time.Time     `db:"failed_at"`
+	CMD          pq.StringArray `db:"cmd"`
+	Entrypoint   pq.StringArray `db:"entrypoint"`
+	Run          string         `db:"run_script"`
+	Image        string         `db:"image"`
+	Registry     []byte         `db:"registry"`
+	Env          []byte         `db:"env"`
+	Files        []byte         `db:"files_"`
+	Queue        string         `db:"queue"`
+	Error        string         `db:"error_"`
+	Pre          []byte         `db:"pre_tasks"`
+	Post         []byte         `db:"post_tasks"`
+	Mounts       []byte         `db:"mounts"`
+	Networks     pq.StringArray `db:"networks"`
+	NodeID       string         `db:"node_id"`
+	Retry        []byte         `db:"retry"`
+	Limits       []byte         `db:"limits"`
+	Timeout      string         `db:"timeout"`
+	Var          string         `db:"var"`
+	Result       string         `db:"result"`
+	Parallel     []byte         `db:"parallel"`
+	ParentID     string         `db:"parent_id"`
+	Each         []byte         `db:"each_"`
+	SubJob       []byte         `db:"subjob"`
+	SubJobID     string         `db:"subjob_id"`
+	GPUs         string         `db:"gpus"`
+	IF           string         `db:"if_"`
+	Tags         pq.StringArray `db:"tags"`
+	Priority     int            `db:"priority"`
+	Workdir      string         `db:"workdir"`
+	Progress     float64        `db:"progress"`
+	Ports        []byte         `db:"ports"`
+	DependsOn    pq.StringArray `db:"depends_on"`
+	NotBefore    *time.Time     `db:"not_before"`
+	Artifacts    []byte         `db:"artifacts"`
+	NodeSelector []byte         `db:"node_selector"`
+	Affinity     []byte         `db:"affinity"`
+}
+
+type readyTaskRecord struct {
+	Position int    `db:"position"`
+	Task     []byte `db:"task"`
 }
 
 type jobRecord struct {
+	ID             string         `db:"id"`
+	Name           string         `db:"name"`
+	Description    string         `db:"description"`
+	Tags           pq.StringArray `db:"tags"`
+	State          string         `db:"state"`
+	CreatedAt      time.Time      `db:"created_at"`
+	CreatedBy      string         `db:"created_by"`
+	StartedAt      *time.Time     `db:"started_at"`
+	CompletedAt    *time.Time     `db:"completed_at"`
+	FailedAt       *time.Time     `db:"failed_at"`
+	PausedAt       *time.Time     `db:"paused_at"`
+	DeleteAt       *time.Time     `db:"delete_at"`
+	Tasks          []byte         `db:"tasks"`
+	Position       int            `db:"position"`
+	Inputs         []byte         `db:"inputs"`
+	Context        []byte         `db:"context"`
+	ParentID       string         `db:"parent_id"`
+	TaskCount      int            `db:"task_count"`
+	Output         string         `db:"output_"`
+	Result         string         `db:"result"`
+	Error          string         `db:"error_"`
+	TS             string         `db:"ts"`
+	Defaults       []byte         `db:"defaults"`
+	Webhooks       []byte         `db:"webhooks"`
+	AutoDelete     []byte         `db:"auto_delete"`
+	Secrets        []byte         `db:"secrets"`
+	Progress       float64        `db:"progress"`
+	Schedule       []byte         `db:"schedule"`
+	Concurrency    []byte         `db:"concurrency"`
+	IdempotencyKey *string        `db:"idempotency_key"`
+	Rerun          []byte         `db:"rerun"`
+}
+
+// jobUserRecord is a job record along
+// with the user who created the job.
+type jobUserRecord struct {
+	jobRecord
+	CreatedByUsername string `db:"created_by_username"`
+	CreatedByName     string `db:"created_by_name"`
+}
+
+type scheduledJobRecord struct {
 	ID          string         `db:"id"`
 	Name        string         `db:"name"`
 	Description string         `db:"description"`
 	Tags        pq.StringArray `db:"tags"`
+	Cron        string         `db:"cron_expr"`
 	State       string         `db:"state"`
 	CreatedAt   time.Time      `db:"created_at"`
 	CreatedBy   string         `db:"created_by"`
-	StartedAt   *time.Time     `db:"started_at"`
-	CompletedAt *time.Time     `db:"completed_at"`
-	FailedAt    *time.Time     `db:"failed_at"`
-	DeleteAt    *time.Time     `db:"delete_at"`
+	LastRunAt   *time.Time     `db:"last_run_at"`
+	NextRunAt   *time.Time     `db:"next_run_at"`
 	Tasks       []byte         `db:"tasks"`
-	Position    int            `db:"position"`
 	Inputs      []byte         `db:"inputs"`
-	Context     []byte         `db:"context"`
-	ParentID    string         `db:"parent_id"`
-	TaskCount   int            `db:"task_count"`
+	Secrets     []byte         `db:"secrets"`
 	Output      string         `db:"output_"`
-	Result      string         `db:"result"`
-	Error       string         `db:"error_"`
-	TS          string         `db:"ts"`
 	Defaults    []byte         `db:"defaults"`
 	Webhooks    []byte         `db:"webhooks"`
+	Permissions []byte         `db:"permissions"`
 	AutoDelete  []byte         `db:"auto_delete"`
-	Secrets     []byte         `db:"secrets"`
-	Progress    float64        `db:"progress"`
+	Concurrency []byte         `db:"concurrency"`
+	InputTypes  []byte         `db:"input_types"`
+	InputSchema []byte         `db:"input_schema"`
+}
+
+type jobTemplateRecord struct {
+	ID          string    `db:"id"`
+	Name        string    `db:"name"`
+	Version     int       `db:"version"`
+	Description string    `db:"description"`
+	CreatedAt   time.Time `db:"created_at"`
+	CreatedBy   string    `db:"created_by"`
+	Permissions []byte    `db:"permissions"`
+	Job         []byte    `db:"job"`
+}
+
+type libraryTaskRecord struct {
+	ID          string    `db:"id"`
+	Name        string    `db:"name"`
+	Version     int       `db:"version"`
+	Description string    `db:"description"`
+	CreatedAt   time.Time `db:"created_at"`
+	CreatedBy   string    `db:"created_by"`
+	Params      []byte    `db:"params"`
+	Task        []byte    `db:"task"`
 }
 
 type jobPermRecord struct {
@@ -92,17 +161,23 @@ type jobPermRecord struct {
 }
 
 type nodeRecord struct {
-	ID              string    `db:"id"`
-	Name            string    `db:"name"`
-	StartedAt       time.Time `db:"started_at"`
-	LastHeartbeatAt time.Time `db:"last_heartbeat_at"`
-	CPUPercent      float64   `db:"cpu_percent"`
-	Queue           string    `db:"queue"`
-	Status          string    `db:"status"`
-	Hostname        string    `db:"hostname"`
-	Port            int       `db:"port"`
-	TaskCount       int       `db:"task_count"`
-	Version         string    `db:"version_"`
+	ID              string         `db:"id"`
+	Name            string         `db:"name"`
+	StartedAt       time.Time      `db:"started_at"`
+	LastHeartbeatAt time.Time      `db:"last_heartbeat_at"`
+	CPUPercent      float64        `db:"cpu_percent"`
+	Queue           string         `db:"queue"`
+	Status          string         `db:"status"`
+	Hostname        string         `db:"hostname"`
+	Port            int            `db:"port"`
+	TaskCount       int            `db:"task_count"`
+	Version         string         `db:"version_"`
+	CPUs            float64        `db:"cpus"`
+	Memory          int64          `db:"memory"`
+	AllocatedCPUs   float64        `db:"allocated_cpus"`
+	AllocatedMemory int64          `db:"allocated_memory"`
+	Labels          []byte         `db:"labels"`
+	Queues          pq.StringArray `db:"queues"`
 }
 
 type taskLogPartRecord struct {
@@ -209,52 +284,82 @@ func (r taskRecord) toTask() (*tork.Task, error) {
 			return nil, errors.Wrapf(err, "error deserializing task.ports")
 		}
 	}
+	var artifacts *tork.TaskArtifacts
+	if r.Artifacts != nil {
+		artifacts = &tork.TaskArtifacts{}
+		if err := json.Unmarshal(r.Artifacts, artifacts); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing task.artifacts")
+		}
+	}
+	var nodeSelector map[string]string
+	if r.NodeSelector != nil {
+		if err := json.Unmarshal(r.NodeSelector, &nodeSelector); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing task.nodeSelector")
+		}
+	}
+	var affinity map[string]string
+	if r.Affinity != nil {
+		if err := json.Unmarshal(r.Affinity, &affinity); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing task.affinity")
+		}
+	}
 	return &tork.Task{
-		ID:          r.ID,
-		JobID:       r.JobID,
-		Position:    r.Position,
-		Name:        r.Name,
-		State:       tork.TaskState(r.State),
-		CreatedAt:   &r.CreatedAt,
-		ScheduledAt: r.ScheduledAt,
-		StartedAt:   r.StartedAt,
-		CompletedAt: r.CompletedAt,
-		FailedAt:    r.FailedAt,
-		CMD:         r.CMD,
-		Entrypoint:  r.Entrypoint,
-		Run:         r.Run,
-		Image:       r.Image,
-		Registry:    registry,
-		Env:         env,
-		Files:       files,
-		Queue:       r.Queue,
-		Error:       r.Error,
-		Pre:         pre,
-		Post:        post,
-		Mounts:      mounts,
-		Networks:    r.Networks,
-		NodeID:      r.NodeID,
-		Retry:       retry,
-		Limits:      limits,
-		Timeout:     r.Timeout,
-		Var:         r.Var,
-		Result:      r.Result,
-		Parallel:    parallel,
-		ParentID:    r.ParentID,
-		Each:        each,
-		Description: r.Description,
-		SubJob:      subjob,
-		GPUs:        r.GPUs,
-		If:          r.IF,
-		Tags:        r.Tags,
-		Priority:    r.Priority,
-		Workdir:     r.Workdir,
-		Progress:    r.Progress,
-		Ports:       ports,
+		ID:           r.ID,
+		JobID:        r.JobID,
+		Position:     r.Position,
+		Name:         r.Name,
+		State:        tork.TaskState(r.State),
+		CreatedAt:    &r.CreatedAt,
+		ScheduledAt:  r.ScheduledAt,
+		StartedAt:    r.StartedAt,
+		CompletedAt:  r.CompletedAt,
+		FailedAt:     r.FailedAt,
+		CMD:          r.CMD,
+		Entrypoint:   r.Entrypoint,
+		Run:          r.Run,
+		Image:        r.Image,
+		Registry:     registry,
+		Env:          env,
+		Files:        files,
+		Queue:        r.Queue,
+		Error:        r.Error,
+		Pre:          pre,
+		Post:         post,
+		Mounts:       mounts,
+		Networks:     r.Networks,
+		NodeID:       r.NodeID,
+		Retry:        retry,
+		Limits:       limits,
+		Timeout:      r.Timeout,
+		Var:          r.Var,
+		Result:       r.Result,
+		Parallel:     parallel,
+		ParentID:     r.ParentID,
+		Each:         each,
+		Description:  r.Description,
+		SubJob:       subjob,
+		GPUs:         r.GPUs,
+		If:           r.IF,
+		Tags:         r.Tags,
+		Priority:     r.Priority,
+		Workdir:      r.Workdir,
+		Progress:     r.Progress,
+		Ports:        ports,
+		DependsOn:    r.DependsOn,
+		NotBefore:    r.NotBefore,
+		Artifacts:    artifacts,
+		NodeSelector: nodeSelector,
+		Affinity:     affinity,
 	}, nil
 }
 
-func (r nodeRecord) toNode() *tork.Node {
+func (r nodeRecord) toNode() (*tork.Node, error) {
+	var labels map[string]string
+	if r.Labels != nil {
+		if err := json.Unmarshal(r.Labels, &labels); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing node.labels")
+		}
+	}
 	n := tork.Node{
 		ID:              r.ID,
 		Name:            r.Name,
@@ -267,13 +372,19 @@ func (r nodeRecord) toNode() *tork.Node {
 		Port:            r.Port,
 		TaskCount:       r.TaskCount,
 		Version:         r.Version,
+		CPUs:            r.CPUs,
+		Memory:          r.Memory,
+		AllocatedCPUs:   r.AllocatedCPUs,
+		AllocatedMemory: r.AllocatedMemory,
+		Labels:          labels,
+		Queues:          r.Queues,
 	}
 	// if we hadn't seen an heartbeat for two or more
 	// consecutive periods we consider the node as offline
 	if n.LastHeartbeatAt.Before(time.Now().UTC().Add(-tork.HEARTBEAT_RATE * 2)) {
 		n.Status = tork.NodeStatusOffline
 	}
-	return &n
+	return &n, nil
 }
 
 func (r taskLogPartRecord) toTaskLogPart() *tork.TaskLogPart {
@@ -319,37 +430,160 @@ func (r jobRecord) toJob(tasks, execution []*tork.Task, createdBy *tork.User, pe
 			return nil, errors.Wrapf(err, "error deserializing job.secrets")
 		}
 	}
+	var schedule *tork.JobSchedule
+	if r.Schedule != nil {
+		schedule = &tork.JobSchedule{}
+		if err := json.Unmarshal(r.Schedule, schedule); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing job.schedule")
+		}
+	}
+	var concurrency *tork.JobConcurrency
+	if r.Concurrency != nil {
+		concurrency = &tork.JobConcurrency{}
+		if err := json.Unmarshal(r.Concurrency, concurrency); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing job.concurrency")
+		}
+	}
+	var idempotencyKey string
+	if r.IdempotencyKey != nil {
+		idempotencyKey = *r.IdempotencyKey
+	}
+	var rerun *tork.JobRerun
+	if r.Rerun != nil {
+		rerun = &tork.JobRerun{}
+		if err := json.Unmarshal(r.Rerun, rerun); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing job.rerun")
+		}
+	}
 	return &tork.Job{
+		ID:             r.ID,
+		Name:           r.Name,
+		Tags:           r.Tags,
+		State:          tork.JobState(r.State),
+		CreatedAt:      r.CreatedAt,
+		CreatedBy:      createdBy,
+		StartedAt:      r.StartedAt,
+		CompletedAt:    r.CompletedAt,
+		FailedAt:       r.FailedAt,
+		PausedAt:       r.PausedAt,
+		Tasks:          tasks,
+		Execution:      execution,
+		Position:       r.Position,
+		Context:        c,
+		Inputs:         inputs,
+		Description:    r.Description,
+		ParentID:       r.ParentID,
+		TaskCount:      r.TaskCount,
+		Output:         r.Output,
+		Result:         r.Result,
+		Error:          r.Error,
+		Defaults:       defaults,
+		Webhooks:       webhooks,
+		Permissions:    perms,
+		AutoDelete:     autoDelete,
+		DeleteAt:       r.DeleteAt,
+		Secrets:        secrets,
+		Progress:       r.Progress,
+		Schedule:       schedule,
+		Concurrency:    concurrency,
+		IdempotencyKey: idempotencyKey,
+		Rerun:          rerun,
+	}, nil
+}
+
+func (r scheduledJobRecord) toScheduledJob(createdBy *tork.User) (*tork.ScheduledJob, error) {
+	tasks := make([]*tork.Task, 0)
+	if err := json.Unmarshal(r.Tasks, &tasks); err != nil {
+		return nil, errors.Wrapf(err, "error deserializing scheduledJob.tasks")
+	}
+	var inputs map[string]string
+	if err := json.Unmarshal(r.Inputs, &inputs); err != nil {
+		return nil, errors.Wrapf(err, "error deserializing scheduledJob.inputs")
+	}
+	var secrets map[string]string
+	if r.Secrets != nil {
+		if err := json.Unmarshal(r.Secrets, &secrets); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing scheduledJob.secrets")
+		}
+	}
+	var defaults *tork.JobDefaults
+	if r.Defaults != nil {
+		defaults = &tork.JobDefaults{}
+		if err := json.Unmarshal(r.Defaults, defaults); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing scheduledJob.defaults")
+		}
+	}
+	var webhooks []*tork.Webhook
+	if r.Webhooks != nil {
+		if err := json.Unmarshal(r.Webhooks, &webhooks); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing scheduledJob.webhooks")
+		}
+	}
+	var perms []*tork.Permission
+	if r.Permissions != nil {
+		if err := json.Unmarshal(r.Permissions, &perms); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing scheduledJob.permissions")
+		}
+	}
+	var autoDelete *tork.AutoDelete
+	if r.AutoDelete != nil {
+		autoDelete = &tork.AutoDelete{}
+		if err := json.Unmarshal(r.AutoDelete, autoDelete); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing scheduledJob.autoDelete")
+		}
+	}
+	var concurrency *tork.JobConcurrency
+	if r.Concurrency != nil {
+		concurrency = &tork.JobConcurrency{}
+		if err := json.Unmarshal(r.Concurrency, concurrency); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing scheduledJob.concurrency")
+		}
+	}
+	var inputTypes map[string]tork.InputType
+	if r.InputTypes != nil {
+		if err := json.Unmarshal(r.InputTypes, &inputTypes); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing scheduledJob.inputTypes")
+		}
+	}
+	var inputSchema map[string]tork.InputSpec
+	if r.InputSchema != nil {
+		if err := json.Unmarshal(r.InputSchema, &inputSchema); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing scheduledJob.inputSchema")
+		}
+	}
+	return &tork.ScheduledJob{
 		ID:          r.ID,
 		Name:        r.Name,
+		Description: r.Description,
 		Tags:        r.Tags,
-		State:       tork.JobState(r.State),
+		Cron:        r.Cron,
+		State:       tork.ScheduledJobState(r.State),
 		CreatedAt:   r.CreatedAt,
 		CreatedBy:   createdBy,
-		StartedAt:   r.StartedAt,
-		CompletedAt: r.CompletedAt,
-		FailedAt:    r.FailedAt,
+		LastRunAt:   r.LastRunAt,
+		NextRunAt:   r.NextRunAt,
 		Tasks:       tasks,
-		Execution:   execution,
-		Position:    r.Position,
-		Context:     c,
 		Inputs:      inputs,
-		Description: r.Description,
-		ParentID:    r.ParentID,
-		TaskCount:   r.TaskCount,
+		InputTypes:  inputTypes,
+		InputSchema: inputSchema,
+		Secrets:     secrets,
 		Output:      r.Output,
-		Result:      r.Result,
-		Error:       r.Error,
 		Defaults:    defaults,
 		Webhooks:    webhooks,
 		Permissions: perms,
 		AutoDelete:  autoDelete,
-		DeleteAt:    r.DeleteAt,
-		Secrets:     secrets,
-		Progress:    r.Progress,
+		Concurrency: concurrency,
 	}, nil
 }
 
+func (r jobUserRecord) toCreatedBy() *tork.User {
+	return &tork.User{
+		ID:       r.CreatedBy,
+		Username: r.CreatedByUsername,
+		Name:     r.CreatedByName,
+	}
+}
+
 func (r userRecord) toUser() *tork.User {
 	n := tork.User{
 		ID:           r.ID,
@@ -371,3 +605,35 @@ func (r roleRecord) toRole() *tork.Role {
 	}
 	return &n
 }
+
+func (r jobTemplateRecord) toJobTemplate(createdBy *tork.User) (*tork.JobTemplate, error) {
+	var perms []*tork.Permission
+	if r.Permissions != nil {
+		if err := json.Unmarshal(r.Permissions, &perms); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing jobTemplate.permissions")
+		}
+	}
+	return &tork.JobTemplate{
+		ID:          r.ID,
+		Name:        r.Name,
+		Version:     r.Version,
+		Description: r.Description,
+		CreatedAt:   r.CreatedAt,
+		CreatedBy:   createdBy,
+		Permissions: perms,
+		Job:         r.Job,
+	}, nil
+}
+
+func (r libraryTaskRecord) toLibraryTask(createdBy *tork.User) *tork.LibraryTask {
+	return &tork.LibraryTask{
+		ID:          r.ID,
+		Name:        r.Name,
+		Version:     r.Version,
+		Description: r.Description,
+		CreatedAt:   r.CreatedAt,
+		CreatedBy:   createdBy,
+		Params:      r.Params,
+		Task:        r.Task,
+	}
+}
@@ -0,0 +1,139 @@
+package datastore
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+)
+
+// RetentionRule determines how long a finished job -- along
+// with its tasks and logs -- is kept before it is expunged.
+type RetentionRule struct {
+	// State restricts the rule to jobs in the given state.
+	// An empty value matches all finished jobs.
+	State tork.JobState `json:"state,omitempty"`
+	// Tag restricts the rule to jobs carrying the given tag.
+	Tag string `json:"tag,omitempty"`
+	// After is how long a job is kept once it finished.
+	// A nil value keeps matching jobs forever.
+	After *time.Duration `json:"after,omitempty"`
+}
+
+// RetentionPolicy is an ordered list of retention rules.
+// The first rule matching a job decides its fate. Jobs
+// which don't match any rule -- or which specify their
+// own autoDelete -- are not affected by the policy.
+type RetentionPolicy []RetentionRule
+
+// RetentionStates are the job states eligible for retention.
+var RetentionStates = []tork.JobState{
+	tork.JobStateCompleted,
+	tork.JobStateFailed,
+	tork.JobStateCancelled,
+}
+
+// NewRetentionRule creates a retention rule from its configuration
+// values. The after value accepts any Go duration as well as a
+// number of days (e.g. 30d). An empty after value or "forever"
+// keeps matching jobs indefinitely.
+func NewRetentionRule(state, tag, after string) (RetentionRule, error) {
+	r := RetentionRule{
+		State: tork.JobState(strings.ToUpper(state)),
+		Tag:   tag,
+	}
+	if r.State != "" && !isRetentionState(r.State) {
+		return RetentionRule{}, errors.Errorf("invalid retention rule state: %s", state)
+	}
+	after = strings.TrimSpace(after)
+	if after == "" || strings.EqualFold(after, "forever") {
+		return r, nil
+	}
+	dur, err := parseRetentionPeriod(after)
+	if err != nil {
+		return RetentionRule{}, errors.Wrapf(err, "invalid retention period: %s", after)
+	}
+	if dur <= 0 {
+		return RetentionRule{}, errors.Errorf("retention period must be positive: %s", after)
+	}
+	r.After = &dur
+	return r, nil
+}
+
+func parseRetentionPeriod(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * time.Hour * 24, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func isRetentionState(s tork.JobState) bool {
+	for _, rs := range RetentionStates {
+		if rs == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches returns true if the rule applies to a
+// job in the given state with the given tags.
+func (r RetentionRule) Matches(state tork.JobState, tags []string) bool {
+	if !isRetentionState(state) {
+		return false
+	}
+	if r.State != "" && r.State != state {
+		return false
+	}
+	if r.Tag != "" {
+		for _, t := range tags {
+			if t == r.Tag {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Match returns the first rule applying to the given job.
+func (p RetentionPolicy) Match(j *tork.JobSummary) (RetentionRule, bool) {
+	for _, r := range p {
+		if r.Matches(j.State, j.Tags) {
+			return r, true
+		}
+	}
+	return RetentionRule{}, false
+}
+
+// ExpiresAt returns the time at which the given job is to be
+// expunged according to the policy. Returns false when no rule
+// matches the job or when the matching rule keeps it forever.
+func (p RetentionPolicy) ExpiresAt(j *tork.JobSummary) (time.Time, bool) {
+	r, ok := p.Match(j)
+	if !ok || r.After == nil {
+		return time.Time{}, false
+	}
+	return FinishedAt(j).Add(*r.After), true
+}
+
+// FinishedAt returns the time from which the age
+// of a job is measured by the retention policy.
+func FinishedAt(j *tork.JobSummary) time.Time {
+	switch {
+	case j.CompletedAt != nil:
+		return *j.CompletedAt
+	case j.FailedAt != nil:
+		return *j.FailedAt
+	case j.StartedAt != nil:
+		return *j.StartedAt
+	default:
+		return j.CreatedAt
+	}
+}
@@ -0,0 +1,67 @@
+package datastore_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRetentionRule(t *testing.T) {
+	r, err := datastore.NewRetentionRule("failed", "", "30d")
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateFailed, r.State)
+	assert.Equal(t, time.Hour*24*30, *r.After)
+
+	r, err = datastore.NewRetentionRule("", "audit", "forever")
+	assert.NoError(t, err)
+	assert.Equal(t, "audit", r.Tag)
+	assert.Nil(t, r.After)
+
+	r, err = datastore.NewRetentionRule("COMPLETED", "", "12h")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour*12, *r.After)
+
+	_, err = datastore.NewRetentionRule("RUNNING", "", "1h")
+	assert.Error(t, err)
+
+	_, err = datastore.NewRetentionRule("", "", "xd")
+	assert.Error(t, err)
+
+	_, err = datastore.NewRetentionRule("", "", "-1h")
+	assert.Error(t, err)
+}
+
+func TestRetentionPolicyExpiresAt(t *testing.T) {
+	keep, err := datastore.NewRetentionRule("", "audit", "forever")
+	assert.NoError(t, err)
+	failed, err := datastore.NewRetentionRule("FAILED", "", "30d")
+	assert.NoError(t, err)
+	completed, err := datastore.NewRetentionRule("COMPLETED", "", "7d")
+	assert.NoError(t, err)
+	p := datastore.RetentionPolicy{keep, failed, completed}
+
+	now := time.Now().UTC()
+
+	exp, ok := p.ExpiresAt(&tork.JobSummary{State: tork.JobStateFailed, FailedAt: &now})
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(time.Hour*24*30), exp)
+
+	exp, ok = p.ExpiresAt(&tork.JobSummary{State: tork.JobStateCompleted, CompletedAt: &now})
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(time.Hour*24*7), exp)
+
+	// the first matching rule wins
+	_, ok = p.ExpiresAt(&tork.JobSummary{State: tork.JobStateFailed, FailedAt: &now, Tags: []string{"audit"}})
+	assert.False(t, ok)
+
+	// not finished
+	_, ok = p.ExpiresAt(&tork.JobSummary{State: tork.JobStateRunning, Tags: []string{"audit"}})
+	assert.False(t, ok)
+
+	// no matching rule
+	_, ok = p.ExpiresAt(&tork.JobSummary{State: tork.JobStateCancelled, CreatedAt: now})
+	assert.False(t, ok)
+}
@@ -0,0 +1,2226 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/archive"
+	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/internal/slices"
+	"github.com/runabol/tork/internal/uuid"
+	sqlite "modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteDatastore is an implementation of the Datastore interface
+// backed by a SQLite database file. It is meant for single-node
+// deployments which need their history to survive restarts.
+type SQLiteDatastore struct {
+	db                      *sqlx.DB
+	tx                      *sqlx.Tx
+	taskLogsRetentionPeriod *time.Duration
+	cleanupInterval         *time.Duration
+	rand                    *rand.Rand
+	disableCleanup          bool
+	retention               datastore.RetentionPolicy
+	archive                 archive.Sink
+}
+
+var (
+	initialCleanupInterval         = minCleanupInterval
+	minCleanupInterval             = time.Minute
+	maxCleanupInterval             = time.Hour
+	DefaultTaskLogsRetentionPeriod = time.Hour * 24 * 7
+	// how long a connection waits for the
+	// database to be unlocked by another writer
+	busyTimeout = time.Second * 30
+)
+
+type Option = func(ds *SQLiteDatastore)
+
+func WithTaskLogRetentionPeriod(dur time.Duration) Option {
+	return func(ds *SQLiteDatastore) {
+		ds.taskLogsRetentionPeriod = &dur
+	}
+}
+
+func WithDisableCleanup(val bool) Option {
+	return func(ds *SQLiteDatastore) {
+		ds.disableCleanup = val
+	}
+}
+
+func WithRetentionPolicy(p datastore.RetentionPolicy) Option {
+	return func(ds *SQLiteDatastore) {
+		ds.retention = p
+	}
+}
+
+// WithArchive sets the sink which expired jobs
+// are archived to before they are expunged.
+func WithArchive(s archive.Sink) Option {
+	return func(ds *SQLiteDatastore) {
+		ds.archive = s
+	}
+}
+
+// NewSQLiteDatastore opens the SQLite database stored at the given path.
+func NewSQLiteDatastore(path string, opts ...Option) (*SQLiteDatastore, error) {
+	if path == "" || path == ":memory:" {
+		return nil, errors.Errorf("a path to the database file is required")
+	}
+	// transactions take the write lock as soon as they begin
+	// since SQLite doesn't support row-level locking
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(%d)&_time_format=sqlite&_txlock=immediate",
+		path, busyTimeout.Milliseconds())
+	db, err := sqlx.Connect("sqlite", dsn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open sqlite database")
+	}
+	ds := &SQLiteDatastore{
+		db:   db,
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, opt := range opts {
+		opt(ds)
+	}
+	ds.cleanupInterval = &initialCleanupInterval
+	if ds.taskLogsRetentionPeriod == nil {
+		ds.taskLogsRetentionPeriod = &DefaultTaskLogsRetentionPeriod
+	}
+	if *ds.cleanupInterval < time.Minute {
+		return nil, errors.Errorf("cleanup interval can not be under 1 minute")
+	}
+	if *ds.taskLogsRetentionPeriod < time.Minute {
+		return nil, errors.Errorf("task logs retention period can not be under 1 minute")
+	}
+	if !ds.disableCleanup {
+		go ds.cleanupProcess()
+	}
+	return ds, nil
+}
+
+func (ds *SQLiteDatastore) cleanupProcess() {
+	for {
+		jitter := time.Second * (time.Duration(ds.rand.Intn(60) + 1))
+		time.Sleep(*ds.cleanupInterval + jitter)
+		if err := ds.cleanup(); err != nil {
+			log.Error().Err(err).Msg("error expunging task logs")
+		}
+	}
+}
+
+func (ds *SQLiteDatastore) cleanup() error {
+	n1, err := ds.expungeExpiredTaskLogPart()
+	if err != nil {
+		return err
+	}
+	if n1 > 0 {
+		log.Debug().Msgf("Expunged %d expired task log parts from the DB", n1)
+	}
+	n2, err := ds.expungeExpiredJobs()
+	if err != nil {
+		return err
+	}
+	if n2 > 0 {
+		log.Debug().Msgf("Expunged %d expired jobs from the DB", n2)
+	}
+	n := n1 + n2
+	if n > 0 {
+		newCleanupInterval := (*ds.cleanupInterval) / 2
+		if newCleanupInterval < minCleanupInterval {
+			newCleanupInterval = minCleanupInterval
+		}
+		ds.cleanupInterval = &newCleanupInterval
+	} else {
+		newCleanupInterval := (*ds.cleanupInterval) * 2
+		if newCleanupInterval > maxCleanupInterval {
+			newCleanupInterval = maxCleanupInterval
+		}
+		ds.cleanupInterval = &newCleanupInterval
+	}
+	return nil
+}
+
+func (ds *SQLiteDatastore) ExecScript(script string) error {
+	_, err := ds.exec(string(script))
+	return err
+}
+
+func (ds *SQLiteDatastore) CreateTask(ctx context.Context, t *tork.Task) error {
+	var env *string
+	if t.Env != nil {
+		b, err := json.Marshal(t.Env)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize task.env")
+		}
+		s := string(b)
+		env = &s
+	}
+	var files *string
+	if t.Files != nil {
+		b, err := json.Marshal(t.Files)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize task.files")
+		}
+		s := string(b)
+		files = &s
+	}
+	pre, err := json.Marshal(t.Pre)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize task.pre")
+	}
+	post, err := json.Marshal(t.Post)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize task.post")
+	}
+	var retry *string
+	if t.Retry != nil {
+		b, err := json.Marshal(t.Retry)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize task.retry")
+		}
+		s := string(b)
+		retry = &s
+	}
+	var limits *string
+	if t.Limits != nil {
+		b, err := json.Marshal(t.Limits)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize task.limits")
+		}
+		s := string(b)
+		limits = &s
+	}
+	var parallel *string
+	if t.Parallel != nil {
+		b, err := json.Marshal(t.Parallel)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize task.parallel")
+		}
+		s := string(b)
+		parallel = &s
+	}
+	var each *string
+	if t.Each != nil {
+		b, err := json.Marshal(t.Each)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize task.each")
+		}
+		s := string(b)
+		each = &s
+	}
+	var subjob *string
+	if t.SubJob != nil {
+		b, err := json.Marshal(t.SubJob)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize task.subjob")
+		}
+		s := string(b)
+		subjob = &s
+	}
+	var registry *string
+	if t.Registry != nil {
+		b, err := json.Marshal(t.Registry)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize task.registry")
+		}
+		s := string(b)
+		registry = &s
+	}
+	var mounts *string
+	if len(t.Mounts) > 0 {
+		b, err := json.Marshal(t.Mounts)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize task.mounts")
+		}
+		s := string(b)
+		mounts = &s
+	}
+	var ports *string
+	if t.Ports != nil {
+		b, err := json.Marshal(t.Ports)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize task.ports")
+		}
+		s := string(b)
+		ports = &s
+	}
+	var artifacts *string
+	if t.Artifacts != nil {
+		b, err := json.Marshal(t.Artifacts)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize task.artifacts")
+		}
+		s := string(b)
+		artifacts = &s
+	}
+	var nodeSelector *string
+	if len(t.NodeSelector) > 0 {
+		b, err := json.Marshal(t.NodeSelector)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize task.nodeSelector")
+		}
+		s := string(b)
+		nodeSelector = &s
+	}
+	var affinity *string
+	if len(t.Affinity) > 0 {
+		b, err := json.Marshal(t.Affinity)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize task.affinity")
+		}
+		s := string(b)
+		affinity = &s
+	}
+	q := `insert into tasks (
+		    id, -- $1
+			job_id, -- $2
+			position, -- $3
+			name, -- $4
+			state, -- $5
+			created_at, -- $6
+			scheduled_at, -- $7
+			started_at, -- $8
+			completed_at, -- $9
+			failed_at, -- $10
+			cmd, -- $11
+			entrypoint, -- $12
+			run_script, -- $13
+			image, -- $14
+			env, -- $15
+			queue, -- $16
+			error_, -- $17
+			pre_tasks, -- $18
+			post_tasks, -- $19
+			mounts, -- $20
+			node_id, -- $21
+			retry, -- $22
+			limits, -- $23
+			timeout, -- $24
+			var, -- $25
+			result, -- $26
+			parallel, -- $27
+			parent_id, -- $28
+			each_, -- $29
+			description, -- $30
+			subjob, -- $31
+			networks, -- $32
+			files_, -- $33
+			registry, -- $34
+			gpus, -- $35
+			if_, -- $36
+			tags, -- $37
+			priority, -- $38
+			workdir, -- $39
+			ports, -- $40
+			depends_on, -- $41
+			not_before, -- $42
+			artifacts, -- $43
+			node_selector, -- $44
+			affinity -- $45
+		  ) 
+	      values (
+			$1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,
+		    $15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26,
+			$27,$28,$29,$30,$31,$32,$33,$34,$35,$36,$37,$38,
+			$39,$40,$41,$42,$43,$44,$45)`
+	_, err = ds.exec(q,
+		t.ID,                      // $1
+		t.JobID,                   // $2
+		t.Position,                // $3
+		t.Name,                    // $4
+		t.State,                   // $5
+		t.CreatedAt,               // $6
+		t.ScheduledAt,             // $7
+		t.StartedAt,               // $8
+		t.CompletedAt,             // $9
+		t.FailedAt,                // $10
+		stringArray(t.CMD),        // $11
+		stringArray(t.Entrypoint), // $12
+		t.Run,                     // $13
+		t.Image,                   // $14
+		env,                       // $15
+		t.Queue,                   // $16
+		sanitizeString(t.Error),   // $17
+		pre,                       // $18
+		post,                      // $19
+		mounts,                    // $20
+		t.NodeID,                  // $21
+		retry,                     // $22
+		limits,                    // $23
+		t.Timeout,                 // $24
+		t.Var,                     // $25
+		sanitizeString(t.Result),  // $26
+		parallel,                  // $27
+		t.ParentID,                // $28
+		each,                      // $29
+		t.Description,             // $30
+		subjob,                    // $31
+		stringArray(t.Networks),   // $32
+		files,                     // $33
+		registry,                  // $34
+		t.GPUs,                    // $35
+		t.If,                      // $36
+		stringArray(t.Tags),       // $37
+		t.Priority,                // $38
+		t.Workdir,                 // $39
+		ports,                     // $40
+		stringArray(t.DependsOn),  // $41
+		t.NotBefore,               // $42
+		artifacts,                 // $43
+		nodeSelector,              // $44
+		affinity,                  // $45
+	)
+	if err != nil {
+		return errors.Wrapf(err, "error inserting task to the db")
+	}
+	return nil
+}
+
+func sanitizeString(s string) string {
+	return strings.ReplaceAll(s, "\u0000", "")
+}
+
+func (ds *SQLiteDatastore) GetTaskByID(ctx context.Context, id string) (*tork.Task, error) {
+	r := taskRecord{}
+	if err := ds.get(&r, `SELECT * FROM tasks where id = $1`, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrTaskNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching task from db")
+	}
+	return r.toTask()
+}
+
+func (ds *SQLiteDatastore) UpdateTask(ctx context.Context, id string, modify func(t *tork.Task) error) error {
+	return ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		ptx, ok := tx.(*SQLiteDatastore)
+		if !ok {
+			return errors.New("unable to cast to a sqlite datastore")
+		}
+		tr := taskRecord{}
+		if err := ptx.get(&tr, `SELECT * FROM tasks where id = $1`, id); err != nil {
+			return errors.Wrapf(err, "error fetching task %s from db", id)
+		}
+		t, err := tr.toTask()
+		if err != nil {
+			return err
+		}
+		if err := modify(t); err != nil {
+			return err
+		}
+		var each *string
+		if t.Each != nil {
+			b, err := json.Marshal(t.Each)
+			if err != nil {
+				return errors.Wrapf(err, "failed to serialize task.each")
+			}
+			s := string(b)
+			each = &s
+		}
+		var parallel *string
+		if t.Parallel != nil {
+			b, err := json.Marshal(t.Parallel)
+			if err != nil {
+				return errors.Wrapf(err, "failed to serialize task.parallel")
+			}
+			s := string(b)
+			parallel = &s
+		}
+		var subjob *string
+		if t.SubJob != nil {
+			b, err := json.Marshal(t.SubJob)
+			if err != nil {
+				return errors.Wrapf(err, "failed to serialize task.subjob")
+			}
+			s := string(b)
+			subjob = &s
+		}
+		var limits *string
+		if t.Limits != nil {
+			b, err := json.Marshal(t.Limits)
+			if err != nil {
+				return errors.Wrapf(err, "failed to serialize task.limits")
+			}
+			s := string(b)
+			limits = &s
+		}
+		var retry *string
+		if t.Retry != nil {
+			b, err := json.Marshal(t.Retry)
+			if err != nil {
+				return errors.Wrapf(err, "failed to serialize task.retry")
+			}
+			s := string(b)
+			retry = &s
+		}
+		q := `update tasks set 
+				position = $1,
+				state = $2,
+				scheduled_at = $3,
+				started_at = $4,
+				completed_at = $5,
+				failed_at = $6,
+				error_ = $7,
+				node_id = $8,
+				result = $9,
+				each_ = $10,
+				subjob = $11,
+				parallel = $12,
+				limits = $13,
+				timeout = $14,
+				retry = $15,
+				queue = $16,
+				progress = $17
+			  where id = $18`
+		_, err = ptx.exec(q,
+			t.Position,               // $1
+			t.State,                  // $2
+			t.ScheduledAt,            // $3
+			t.StartedAt,              // $4
+			t.CompletedAt,            // $5
+			t.FailedAt,               // $6
+			sanitizeString(t.Error),  // $7
+			t.NodeID,                 // $8
+			sanitizeString(t.Result), // $9
+			each,                     // $10
+			subjob,                   // $11
+			parallel,                 // $12
+			limits,                   // $13
+			t.Timeout,                // $14
+			retry,                    // $15
+			t.Queue,                  // $16
+			t.Progress,               // $17
+			t.ID,                     // $18
+		)
+		if err != nil {
+			return errors.Wrapf(err, "error updating task %s", t.ID)
+		}
+		return nil
+	})
+}
+
+func (ds *SQLiteDatastore) CreateNode(ctx context.Context, n *tork.Node) error {
+	var labels *string
+	if len(n.Labels) > 0 {
+		b, err := json.Marshal(n.Labels)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize node.labels")
+		}
+		s := string(b)
+		labels = &s
+	}
+	q := `insert into nodes 
+	       (id,name,started_at,last_heartbeat_at,cpu_percent,queue,status,hostname,task_count,version_,port,cpus,memory,allocated_cpus,allocated_memory,labels,queues)
+	      values
+	       ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17)`
+	_, err := ds.exec(q, n.ID, n.Name, n.StartedAt, n.LastHeartbeatAt, n.CPUPercent, n.Queue, n.Status, n.Hostname, n.TaskCount, n.Version, n.Port, n.CPUs, n.Memory, n.AllocatedCPUs, n.AllocatedMemory, labels, stringArray(n.Queues))
+	if err != nil {
+		return errors.Wrapf(err, "error inserting node to the db")
+	}
+	return nil
+}
+
+func (ds *SQLiteDatastore) UpdateNode(ctx context.Context, id string, modify func(u *tork.Node) error) error {
+	return ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		ptx, ok := tx.(*SQLiteDatastore)
+		if !ok {
+			return errors.New("unable to cast to a sqlite datastore")
+		}
+		nr := nodeRecord{}
+		if err := ptx.get(&nr, `SELECT * FROM nodes where id = $1`, id); err != nil {
+			return errors.Wrapf(err, "error fetching node from db")
+		}
+		n, err := nr.toNode()
+		if err != nil {
+			return err
+		}
+		if err := modify(n); err != nil {
+			return err
+		}
+		var labels *string
+		if len(n.Labels) > 0 {
+			b, err := json.Marshal(n.Labels)
+			if err != nil {
+				return errors.Wrapf(err, "failed to serialize node.labels")
+			}
+			s := string(b)
+			labels = &s
+		}
+		q := `update nodes set 
+	        last_heartbeat_at = $1,
+			cpu_percent = $2,
+			status = $3,
+			task_count = $4,
+			cpus = $5,
+			memory = $6,
+			allocated_cpus = $7,
+			allocated_memory = $8,
+			labels = $9,
+			queues = $10
+		  where id = $11`
+		_, err = ptx.exec(q, n.LastHeartbeatAt, n.CPUPercent, n.Status, n.TaskCount, n.CPUs, n.Memory, n.AllocatedCPUs, n.AllocatedMemory, labels, stringArray(n.Queues), id)
+		if err != nil {
+			return errors.Wrapf(err, "error update node in db")
+		}
+		return nil
+	})
+}
+
+func (ds *SQLiteDatastore) GetNodeByID(ctx context.Context, id string) (*tork.Node, error) {
+	nr := nodeRecord{}
+	if err := ds.get(&nr, `SELECT * FROM nodes where id = $1`, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrNodeNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching task from db")
+	}
+	return nr.toNode()
+}
+
+func (ds *SQLiteDatastore) GetActiveNodes(ctx context.Context) ([]*tork.Node, error) {
+	nrs := []nodeRecord{}
+	q := `SELECT * 
+	      FROM nodes 
+		  where last_heartbeat_at > $1 
+		  ORDER BY name ASC, last_heartbeat_at DESC`
+	timeout := time.Now().UTC().Add(-tork.LAST_HEARTBEAT_TIMEOUT)
+	if err := ds.select_(&nrs, q, timeout); err != nil {
+		return nil, errors.Wrapf(err, "error getting active nodes from db")
+	}
+	ns := make([]*tork.Node, len(nrs))
+	for i, nr := range nrs {
+		n, err := nr.toNode()
+		if err != nil {
+			return nil, err
+		}
+		ns[i] = n
+	}
+	return ns, nil
+}
+
+func (ds *SQLiteDatastore) CreateJob(ctx context.Context, j *tork.Job) error {
+	if j.ID == "" {
+		return errors.Errorf("job id must not be empty")
+	}
+	if j.CreatedBy == nil {
+		guest, err := ds.GetUser(ctx, tork.USER_GUEST)
+		if err != nil {
+			return err
+		}
+		j.CreatedBy = guest
+	}
+	tasks, err := json.Marshal(j.Tasks)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize job.tasks")
+	}
+	c, err := json.Marshal(j.Context)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize tork.Context")
+	}
+	inputs, err := json.Marshal(j.Inputs)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize job.inputs")
+	}
+	var defaults *string
+	if j.Defaults != nil {
+		b, err := json.Marshal(j.Defaults)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize job.defaults")
+		}
+		s := string(b)
+		defaults = &s
+	}
+	var autoDelete *string
+	if j.AutoDelete != nil {
+		b, err := json.Marshal(j.AutoDelete)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize job.autoDelete")
+		}
+		s := string(b)
+		autoDelete = &s
+	}
+	webhooks, err := json.Marshal(j.Webhooks)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize job.webhooks")
+	}
+	if j.Tags == nil {
+		j.Tags = make([]string, 0)
+	}
+	var secrets *string
+	if j.Secrets != nil {
+		b, err := json.Marshal(j.Secrets)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize job.secrets")
+		}
+		s := string(b)
+		secrets = &s
+	}
+	var schedule *string
+	if j.Schedule != nil {
+		b, err := json.Marshal(j.Schedule)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize job.schedule")
+		}
+		s := string(b)
+		schedule = &s
+	}
+	concurrency, err := serializeConcurrency(j.Concurrency)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize job.concurrency")
+	}
+	var idempotencyKey *string
+	if j.IdempotencyKey != "" {
+		idempotencyKey = &j.IdempotencyKey
+	}
+	return ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		ptx, ok := tx.(*SQLiteDatastore)
+		if !ok {
+			return errors.New("unable to cast to a sqlite datastore")
+		}
+		sql := `insert into jobs (id,name,description,state,created_at,started_at,tasks,position,
+					inputs,context,parent_id,task_count,output_,result,error_,defaults,webhooks,
+					created_by,tags,auto_delete,secrets,schedule,concurrency,idempotency_key) 
+				values
+					($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24)`
+		if _, err := ptx.exec(sql, j.ID, j.Name, j.Description, j.State, j.CreatedAt, j.StartedAt, tasks, j.Position,
+			inputs, c, j.ParentID, j.TaskCount, j.Output, j.Result, j.Error, defaults, webhooks, j.CreatedBy.ID,
+			stringArray(j.Tags), autoDelete, secrets, schedule, concurrency, idempotencyKey); err != nil {
+			if isUniqueViolation(err) {
+				return datastore.ErrDuplicateIdempotencyKey
+			}
+			return errors.Wrapf(err, "error inserting job to the db")
+		}
+		for _, perm := range j.Permissions {
+			var username *string
+			var roleSlug *string
+			if perm.Role != nil {
+				roleSlug = &perm.Role.Slug
+			} else {
+				username = &perm.User.Username
+			}
+			sql := `insert into jobs_perms 
+			          (id,job_id,user_id,role_id) 
+			        values 
+					  ($1,
+					   $2,
+					   case when $3 is not null then coalesce((select id from users where username_ = $3),'') end,
+					   case when $4 is not null then coalesce((select id from roles where slug = $4),'') end)`
+			if _, err := ptx.exec(sql, uuid.NewUUID(), j.ID, username, roleSlug); err != nil {
+				return errors.Wrapf(err, "error inserting job to the db")
+			}
+		}
+		return nil
+	})
+
+}
+func (ds *SQLiteDatastore) UpdateJob(ctx context.Context, id string, modify func(u *tork.Job) error) error {
+	return ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		ptx, ok := tx.(*SQLiteDatastore)
+		if !ok {
+			return errors.New("unable to cast to a sqlite datastore")
+		}
+		r := jobRecord{}
+		if err := ptx.get(&r, `SELECT * FROM jobs where id = $1`, id); err != nil {
+			return errors.Wrapf(err, "error fetching job from db")
+		}
+		tasks := make([]*tork.Task, 0)
+		if err := json.Unmarshal(r.Tasks, &tasks); err != nil {
+			return errors.Wrapf(err, "error desiralizing job.tasks")
+		}
+		createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+		if err != nil {
+			return err
+		}
+		j, err := r.toJob(tasks, []*tork.Task{}, createdBy, []*tork.Permission{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to convert jobRecord")
+		}
+		if err := modify(j); err != nil {
+			return err
+		}
+		c, err := json.Marshal(j.Context)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize tork.Context")
+		}
+		concurrency, err := serializeConcurrency(j.Concurrency)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize job.concurrency")
+		}
+		var idempotencyKey *string
+		if j.IdempotencyKey != "" {
+			idempotencyKey = &j.IdempotencyKey
+		}
+		var rerun *string
+		if j.Rerun != nil {
+			b, err := json.Marshal(j.Rerun)
+			if err != nil {
+				return errors.Wrapf(err, "failed to serialize job.rerun")
+			}
+			s := string(b)
+			rerun = &s
+		}
+		q := `update jobs set 
+				state = $1,
+				started_at = $2,
+				completed_at = $3,
+				failed_at = $4,
+				position = $5,
+				context = $6,
+				result = $7,
+				error_ = $8,
+				delete_at = $9,
+				progress = $10,
+				concurrency = $11,
+				idempotency_key = $12,
+				paused_at = $13,
+				rerun = $14
+			  where id = $15`
+		_, err = ptx.exec(q, j.State, j.StartedAt, j.CompletedAt, j.FailedAt, j.Position, c, j.Result, j.Error, j.DeleteAt, j.Progress, concurrency, idempotencyKey, j.PausedAt, rerun, j.ID)
+		if isUniqueViolation(err) {
+			return datastore.ErrDuplicateIdempotencyKey
+		}
+		return err
+	})
+}
+
+func (ds *SQLiteDatastore) GetJobByID(ctx context.Context, id string) (*tork.Job, error) {
+	r := jobRecord{}
+	if err := ds.get(&r, `SELECT * FROM jobs where id = $1`, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrJobNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching job from db")
+	}
+	tasks := make([]*tork.Task, 0)
+	if err := json.Unmarshal(r.Tasks, &tasks); err != nil {
+		return nil, errors.Wrapf(err, "error desiralizing job.tasks")
+	}
+	rse := make([]taskRecord, 0)
+	q := `SELECT * 
+	      FROM tasks 
+		  where job_id = $1 
+		  ORDER BY position asc,started_at is null,started_at asc`
+	if err := ds.select_(&rse, q, id); err != nil {
+		return nil, errors.Wrapf(err, "error getting job execution from db")
+	}
+	exec := make([]*tork.Task, len(rse))
+	for i, r := range rse {
+		t, err := r.toTask()
+		if err != nil {
+			return nil, err
+		}
+		exec[i] = t
+	}
+	u, err := ds.GetUser(ctx, r.CreatedBy)
+	if err != nil {
+		return nil, err
+	}
+	rsp := make([]jobPermRecord, 0)
+	q = `SELECT * 
+	      FROM jobs_perms
+		  where job_id = $1`
+	if err := ds.select_(&rsp, q, id); err != nil {
+		return nil, errors.Wrapf(err, "error getting job permissions from db")
+	}
+	perms := make([]*tork.Permission, len(rsp))
+	for i, rp := range rsp {
+		p := &tork.Permission{}
+		if rp.RoleID != nil {
+			role, err := ds.GetRole(ctx, *rp.RoleID)
+			if err != nil {
+				return nil, err
+			}
+			p.Role = role
+		} else {
+			user, err := ds.GetUser(ctx, *rp.UserID)
+			if err != nil {
+				return nil, err
+			}
+			p.User = user
+		}
+		perms[i] = p
+	}
+	return r.toJob(tasks, exec, u, perms)
+}
+
+func (ds *SQLiteDatastore) GetJobByIdempotencyKey(ctx context.Context, username, key string) (*tork.Job, error) {
+	var id string
+	q := `SELECT j.id 
+	      FROM jobs j 
+	      JOIN users u ON u.id = j.created_by 
+	      where u.username_ = $1 AND j.idempotency_key = $2`
+	if err := ds.get(&id, q, username, key); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrJobNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching job from db")
+	}
+	return ds.GetJobByID(ctx, id)
+}
+
+func (ds *SQLiteDatastore) GetActiveTasks(ctx context.Context, jobID string) ([]*tork.Task, error) {
+	rs := make([]taskRecord, 0)
+	q := `SELECT * 
+	      FROM tasks 
+		  where job_id = $1 
+		  AND state in (select value from json_each($2))
+		  ORDER BY position,created_at ASC`
+	activeStates := slices.Map(tork.TaskStateActive, func(state tork.TaskState) string { return string(state) })
+	if err := ds.select_(&rs, q, jobID, stringArray(activeStates)); err != nil {
+		return nil, errors.Wrapf(err, "error getting job execution from db")
+	}
+	actives := make([]*tork.Task, len(rs))
+	for i, r := range rs {
+		t, err := r.toTask()
+		if err != nil {
+			return nil, err
+		}
+		actives[i] = t
+	}
+
+	return actives, nil
+}
+
+func (ds *SQLiteDatastore) GetNextTask(ctx context.Context, parentTaskID string) (*tork.Task, error) {
+	r := taskRecord{}
+	if err := ds.get(&r, `SELECT * FROM tasks where parent_id = $1 and state = 'CREATED' and not_before is null limit 1`, parentTaskID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrTaskNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching task from db")
+	}
+	return r.toTask()
+}
+
+func (ds *SQLiteDatastore) GetDelayedTasks(ctx context.Context, before time.Time) ([]*tork.Task, error) {
+	rs := make([]taskRecord, 0)
+	q := `SELECT * 
+	      FROM tasks 
+	      where state = 'CREATED' 
+	      AND not_before <= $1
+	      ORDER BY not_before ASC`
+	if err := ds.select_(&rs, q, before); err != nil {
+		return nil, errors.Wrapf(err, "error getting delayed tasks from db")
+	}
+	result := make([]*tork.Task, len(rs))
+	for i, r := range rs {
+		t, err := r.toTask()
+		if err != nil {
+			return nil, err
+		}
+		result[i] = t
+	}
+	return result, nil
+}
+
+// GetOrphanedTasks returns the RUNNING or SCHEDULED tasks whose node
+// hasn't sent a heartbeat since heartbeatBefore, as well as the tasks
+// which were SCHEDULED before scheduledBefore but never started.
+func (ds *SQLiteDatastore) GetOrphanedTasks(ctx context.Context, heartbeatBefore, scheduledBefore time.Time) ([]*tork.Task, error) {
+	rs := make([]taskRecord, 0)
+	q := `SELECT * 
+	      FROM tasks t
+	      where (
+	        t.state in ('RUNNING','SCHEDULED')
+	        AND coalesce(t.node_id,'') != ''
+	        AND NOT EXISTS (SELECT 1 FROM nodes n WHERE n.id = t.node_id AND n.last_heartbeat_at >= $1)
+	      ) OR (
+	        t.state = 'SCHEDULED'
+	        AND t.scheduled_at < $2
+	      )
+	      ORDER BY t.created_at ASC`
+	if err := ds.select_(&rs, q, heartbeatBefore, scheduledBefore); err != nil {
+		return nil, errors.Wrapf(err, "error getting orphaned tasks from db")
+	}
+	result := make([]*tork.Task, len(rs))
+	for i, r := range rs {
+		t, err := r.toTask()
+		if err != nil {
+			return nil, err
+		}
+		result[i] = t
+	}
+	return result, nil
+}
+
+// GetReadyTasks returns the top-level tasks of the job which
+// were not created yet and whose dependencies have all completed.
+func (ds *SQLiteDatastore) GetReadyTasks(ctx context.Context, jobID string) ([]*tork.Task, error) {
+	rs := make([]readyTaskRecord, 0)
+	q := `with defs as (
+	        select d.key + 1 as position, d.value as task
+	        from jobs j, json_each(j.tasks) as d
+	        where j.id = $1
+	      )
+	      select defs.position, defs.task
+	      from defs
+	      where not exists (
+	        select 1 from tasks t
+	        where t.job_id = $1
+	        and coalesce(t.parent_id,'') = ''
+	        and t.position = defs.position
+	      )
+	      and not exists (
+	        select 1 from json_each(coalesce(json_extract(defs.task,'$.dependsOn'),'[]')) as dep
+	        where not exists (
+	          select 1 from tasks t
+	          where t.job_id = $1
+	          and coalesce(t.parent_id,'') = ''
+	          and t.var = dep.value
+	          and t.state in (select value from json_each($2))
+	        )
+	      )
+	      order by defs.position`
+	doneStates := []string{string(tork.TaskStateCompleted), string(tork.TaskStateSkipped)}
+	if err := ds.select_(&rs, q, jobID, stringArray(doneStates)); err != nil {
+		return nil, errors.Wrapf(err, "error getting ready tasks from db")
+	}
+	ready := make([]*tork.Task, len(rs))
+	for i, r := range rs {
+		t := &tork.Task{}
+		if err := json.Unmarshal(r.Task, t); err != nil {
+			return nil, errors.Wrapf(err, "error deserializing task")
+		}
+		t.Position = r.Position
+		ready[i] = t
+	}
+	return ready, nil
+}
+
+func (ds *SQLiteDatastore) CreateTaskLogPart(ctx context.Context, p *tork.TaskLogPart) error {
+	if p.TaskID == "" {
+		return errors.Errorf("must provide task id")
+	}
+	if p.Number < 1 {
+		return errors.Errorf("part number must be > 0")
+	}
+	q := `insert into tasks_log_parts 
+	       (id,number_,task_id,created_at,contents) 
+	      values
+	       ($1,$2,$3,$4,$5)`
+	_, err := ds.exec(q, uuid.NewUUID(), p.Number, p.TaskID, time.Now().UTC(), p.Contents)
+	if err != nil {
+		return errors.Wrapf(err, "error inserting task log part to the db")
+	}
+	return nil
+}
+
+func (ds *SQLiteDatastore) expungeExpiredTaskLogPart() (int, error) {
+	q := `delete from tasks_log_parts where id in ( 
+	        select id 
+		    from   tasks_log_parts 
+		    where  created_at < $1 
+		    limit  1000
+	      )`
+	res, err := ds.exec(q, time.Now().UTC().Add(-*ds.taskLogsRetentionPeriod))
+	if err != nil {
+		return 0, errors.Wrapf(err, "error deleting expired task log parts from the db")
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrapf(err, "error getting the number of deleted log parts")
+	}
+	return int(rows), nil
+}
+
+func (ds *SQLiteDatastore) expungeExpiredJobs() (int, error) {
+	ctx := context.Background()
+	ids := []string{}
+	clause, args := ds.expiredJobsClause(time.Now().UTC())
+	if err := ds.select_(&ids, fmt.Sprintf("select id from jobs where %s limit 1000", clause), args...); err != nil {
+		return 0, errors.Wrapf(err, "error getting list of expired job ids from the db")
+	}
+	if ds.archive != nil {
+		archived := make([]string, 0, len(ids))
+		for _, id := range ids {
+			// jobs which could not be archived are
+			// kept around until the next cleanup
+			if err := ds.archiveJob(ctx, id); err != nil {
+				log.Error().Err(err).Msgf("error archiving job %s", id)
+				continue
+			}
+			archived = append(archived, id)
+		}
+		ids = archived
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	var n int
+	if err := ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		ptx, ok := tx.(*SQLiteDatastore)
+		if !ok {
+			return errors.New("unable to cast to a sqlite datastore")
+		}
+		if _, err := ptx.exec(`delete from jobs_perms where job_id in (select value from json_each($1));`, stringArray(ids)); err != nil {
+			return errors.Wrapf(err, "error deleting expired job perms from the db")
+		}
+		if _, err := ptx.exec(`delete from tasks_log_parts where task_id in (select id from tasks where job_id in (select value from json_each($1)));`, stringArray(ids)); err != nil {
+			return errors.Wrapf(err, "error deleting expired task log parts from the db")
+		}
+		if _, err := ptx.exec(`delete from tasks where job_id in (select value from json_each($1));`, stringArray(ids)); err != nil {
+			return errors.Wrapf(err, "error deleting expired tasks from the db")
+		}
+		res, err := ptx.exec(`delete from jobs where id in (select value from json_each($1));`, stringArray(ids))
+		if err != nil {
+			return errors.Wrapf(err, "error deleting expired jobs from the db")
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrapf(err, "error getting the number of deleted jobs from the db")
+		}
+		n = int(rows)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// archiveJob writes the job -- along with its execution
+// and task logs -- to the archive sink.
+func (ds *SQLiteDatastore) archiveJob(ctx context.Context, id string) error {
+	j, err := ds.GetJobByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	rs := []taskLogPartRecord{}
+	q := `select tlp.* 
+	      from   tasks_log_parts tlp
+	      join   tasks t
+	      on     t.id = tlp.task_id
+	      where  t.job_id = $1
+	      order  by t.position, t.created_at, tlp.number_`
+	if err := ds.select_(&rs, q, id); err != nil {
+		return errors.Wrapf(err, "error getting the log parts of job %s", id)
+	}
+	logs := make([]*tork.TaskLogPart, len(rs))
+	for i, r := range rs {
+		logs[i] = r.toTaskLogPart()
+	}
+	return archive.Write(ctx, ds.archive, &archive.Bundle{
+		Job:        j,
+		Logs:       logs,
+		ArchivedAt: time.Now().UTC(),
+	})
+}
+
+// expiredJobsClause returns the condition matching the jobs which
+// are due to be expunged, either because their autoDelete time
+// passed or because a retention rule says so. The first rule
+// matching a job determines its expiration.
+func (ds *SQLiteDatastore) expiredJobsClause(now time.Time) (string, []any) {
+	args := []any{now}
+	states := make([]string, len(datastore.RetentionStates))
+	for i, s := range datastore.RetentionStates {
+		states[i] = fmt.Sprintf("'%s'", s)
+	}
+	cases := make([]string, 0, len(ds.retention))
+	for _, r := range ds.retention {
+		conds := []string{fmt.Sprintf("state in (%s)", strings.Join(states, ","))}
+		if r.State != "" {
+			args = append(args, r.State)
+			conds = append(conds, fmt.Sprintf("state = $%d", len(args)))
+		}
+		if r.Tag != "" {
+			args = append(args, r.Tag)
+			conds = append(conds, fmt.Sprintf("exists (select 1 from json_each(tags) where value = $%d)", len(args)))
+		}
+		expireBefore := "null"
+		if r.After != nil {
+			args = append(args, now.Add(-*r.After))
+			expireBefore = fmt.Sprintf("$%d", len(args))
+		}
+		cases = append(cases, fmt.Sprintf("when %s then %s", strings.Join(conds, " and "), expireBefore))
+	}
+	if len(cases) == 0 {
+		return "delete_at < $1", args
+	}
+	return fmt.Sprintf(`(delete_at < $1 or (delete_at is null and 
+	        coalesce(completed_at,failed_at,started_at,created_at) < case %s end))`, strings.Join(cases, " ")), args
+}
+
+func (ds *SQLiteDatastore) GetExpiredJobs(ctx context.Context, currentUser string, page, size int) (*datastore.Page[*tork.JobSummary], error) {
+	clause, args := ds.expiredJobsClause(time.Now().UTC())
+	args = append(args, currentUser)
+	with, where := jobPermsClause(len(args))
+	offset := (page - 1) * size
+	rs := make([]jobUserRecord, 0)
+	qry := fmt.Sprintf(`%s
+	      SELECT j.*, u.username_ AS created_by_username, u.name AS created_by_name
+	      FROM (SELECT * FROM jobs WHERE %s) j
+	      JOIN users u ON u.id = j.created_by
+	      WHERE %s
+	      ORDER BY j.created_at ASC
+	      LIMIT %d OFFSET %d`, with, clause, where, size, offset)
+	if err := ds.select_(&rs, qry, args...); err != nil {
+		return nil, errors.Wrapf(err, "error getting a page of expired jobs")
+	}
+	result := make([]*tork.JobSummary, len(rs))
+	for i, r := range rs {
+		j, err := r.toJob([]*tork.Task{}, []*tork.Task{}, r.toCreatedBy(), []*tork.Permission{})
+		if err != nil {
+			return nil, err
+		}
+		result[i] = tork.NewJobSummary(j)
+	}
+	var count *int
+	if err := ds.get(&count, fmt.Sprintf(`%s
+	      SELECT count(*)
+	      FROM (SELECT * FROM jobs WHERE %s) j
+	      WHERE %s`, with, clause, where), args...); err != nil {
+		return nil, errors.Wrapf(err, "error getting the expired jobs count")
+	}
+	totalPages := *count / size
+	if *count%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.JobSummary]{
+		Items:      result,
+		Number:     page,
+		Size:       len(result),
+		TotalPages: totalPages,
+		TotalItems: *count,
+	}, nil
+}
+
+// jobPermsClause returns the CTEs and the condition matching the
+// jobs (aliased j) which the user, whose username is the query
+// parameter at the given position, is permitted to see. An empty
+// username matches all jobs.
+func jobPermsClause(param int) (string, string) {
+	with := fmt.Sprintf(`
+	  WITH user_info AS (
+	    SELECT id AS user_id
+	    FROM users
+	    WHERE username_ = $%[1]d
+	  ),
+	  role_info AS (
+	    SELECT role_id
+	    FROM users_roles ur
+	    JOIN user_info ui ON ur.user_id = ui.user_id
+	  )`, param)
+	where := fmt.Sprintf(`
+	  ($%[1]d = '' OR NOT EXISTS (
+	     SELECT 1 FROM jobs_perms jp WHERE jp.job_id = j.id
+	  ) OR EXISTS (
+	     SELECT 1
+	     FROM jobs_perms jp
+	     WHERE jp.job_id = j.id
+	     AND (jp.user_id = (SELECT user_id FROM user_info) OR jp.role_id IN (SELECT role_id FROM role_info))
+	  ))`, param)
+	return with, where
+}
+
+func (ds *SQLiteDatastore) GetTaskLogParts(ctx context.Context, taskID, q string, page, size int) (*datastore.Page[*tork.TaskLogPart], error) {
+	searchTerm, _ := parseQuery(q)
+	offset := (page - 1) * size
+	rs := []taskLogPartRecord{}
+	qry := fmt.Sprintf(`select * 
+	      from tasks_log_parts 
+		  where task_id = $1 and ($2 = '' OR seq in (select rowid from tasks_log_parts_fts where tasks_log_parts_fts match $2))
+		  order by number_ DESC
+		  limit %d offset %d`, size, offset)
+
+	if err := ds.select_(&rs, qry, taskID, toMatchQuery(searchTerm)); err != nil {
+		return nil, errors.Wrapf(err, "error task log parts from db")
+	}
+	items := make([]*tork.TaskLogPart, len(rs))
+	for i, r := range rs {
+		items[i] = r.toTaskLogPart()
+	}
+	var count *int
+	if err := ds.get(&count, `select count(*) from tasks_log_parts where task_id = $1`, taskID); err != nil {
+		return nil, errors.Wrapf(err, "error getting the task log parts count")
+	}
+	totalPages := *count / size
+	if *count%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.TaskLogPart]{
+		Items:      items,
+		Number:     page,
+		Size:       len(items),
+		TotalPages: totalPages,
+		TotalItems: *count,
+	}, nil
+}
+
+func (ds *SQLiteDatastore) GetJobLogParts(ctx context.Context, jobID, q string, page, size int) (*datastore.Page[*tork.TaskLogPart], error) {
+	searchTerm, _ := parseQuery(q)
+	offset := (page - 1) * size
+	rs := []taskLogPartRecord{}
+	qry := fmt.Sprintf(`select tlp.* 
+	      from tasks_log_parts tlp
+		  join tasks t
+		  on t.id = tlp.task_id
+		  where t.job_id = $1 and ($2 = '' OR tlp.seq in (select rowid from tasks_log_parts_fts where tasks_log_parts_fts match $2))
+		  order by t.position desc, t.created_at desc, tlp.number_ desc, tlp.created_at DESC
+		  limit %d offset %d`, size, offset)
+
+	if err := ds.select_(&rs, qry, jobID, toMatchQuery(searchTerm)); err != nil {
+		return nil, errors.Wrapf(err, "error task log parts from db")
+	}
+	items := make([]*tork.TaskLogPart, len(rs))
+	for i, r := range rs {
+		items[i] = r.toTaskLogPart()
+	}
+	var count *int
+	if err := ds.get(&count, `select count(*) 
+	                          from   tasks_log_parts tlp
+							  join   tasks t
+		                      on     t.id = tlp.task_id
+							  where  t.job_id = $1`, jobID); err != nil {
+		return nil, errors.Wrapf(err, "error getting the task log parts count")
+	}
+	totalPages := *count / size
+	if *count%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.TaskLogPart]{
+		Items:      items,
+		Number:     page,
+		Size:       len(items),
+		TotalPages: totalPages,
+		TotalItems: *count,
+	}, nil
+}
+
+const jobsQuery = `
+      WITH user_info AS (
+        SELECT id AS user_id
+        FROM users
+        WHERE username_ = $3
+      ),
+      role_info AS (
+        SELECT role_id
+        FROM users_roles ur
+        JOIN user_info ui ON ur.user_id = ui.user_id
+      ),
+      job_perms_info AS (
+        SELECT job_id
+        FROM jobs_perms jp
+        WHERE jp.user_id = (SELECT user_id FROM user_info)
+        OR jp.role_id IN (SELECT role_id FROM role_info)
+      ),
+      no_job_perms AS (
+        SELECT j.id as job_id
+        FROM jobs j
+        where not exists (
+		  select 1 from jobs_perms jp where j.id = jp.job_id
+		)
+      )
+      SELECT %s
+      FROM jobs j
+      WHERE 
+        ($1 = '' OR j.seq in (select rowid from jobs_fts where jobs_fts match $1))
+      AND 
+        (json_array_length($2) = 0 OR exists (
+           select 1 from json_each(j.tags) jt where jt.value in (select value from json_each($2))
+        ))
+      AND
+        ($3 = '' OR EXISTS (select 1 from no_job_perms njp where njp.job_id=j.id) OR EXISTS (
+           SELECT 1
+           FROM job_perms_info jpi
+           WHERE jpi.job_id = j.id
+        ))`
+
+func (ds *SQLiteDatastore) GetJobs(ctx context.Context, currentUser, q string, page, size int) (*datastore.Page[*tork.JobSummary], error) {
+	searchTerm, tags := parseQuery(q)
+	matchQuery := toMatchQuery(searchTerm)
+
+	offset := (page - 1) * size
+	rs := make([]jobRecord, 0)
+	qry := fmt.Sprintf(jobsQuery, "j.*") + fmt.Sprintf(`
+	  ORDER BY created_at DESC 
+	  LIMIT %d OFFSET %d`, size, offset)
+	if err := ds.select_(&rs, qry, matchQuery, stringArray(tags), currentUser); err != nil {
+		return nil, errors.Wrapf(err, "error getting a page of jobs")
+	}
+	result := make([]*tork.JobSummary, len(rs))
+	for i, r := range rs {
+		createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+		j, err := r.toJob([]*tork.Task{}, []*tork.Task{}, createdBy, []*tork.Permission{})
+		if err != nil {
+			return nil, err
+		}
+		result[i] = tork.NewJobSummary(j)
+	}
+
+	var count *int
+	if err := ds.get(&count, fmt.Sprintf(jobsQuery, "count(*)"), matchQuery, stringArray(tags), currentUser); err != nil {
+		return nil, errors.Wrapf(err, "error getting the jobs count")
+	}
+
+	totalPages := *count / size
+	if *count%size != 0 {
+		totalPages = totalPages + 1
+	}
+
+	return &datastore.Page[*tork.JobSummary]{
+		Items:      result,
+		Number:     page,
+		Size:       len(result),
+		TotalPages: totalPages,
+		TotalItems: *count,
+	}, nil
+}
+
+// LockConcurrencyKey doesn't need to lock anything because
+// SQLite transactions hold the database's write lock.
+func (ds *SQLiteDatastore) LockConcurrencyKey(ctx context.Context, key string) error {
+	return nil
+}
+
+func (ds *SQLiteDatastore) GetJobsByConcurrencyKey(ctx context.Context, key string) ([]*tork.JobSummary, error) {
+	rs := make([]jobRecord, 0)
+	q := `SELECT * 
+	      FROM jobs 
+	      where json_extract(concurrency,'$.key') = $1 
+	      and state in ($2,$3,$4,$5)
+	      ORDER BY created_at ASC`
+	if err := ds.select_(&rs, q, key, tork.JobStatePending, tork.JobStateScheduled,
+		tork.JobStateRunning, tork.JobStatePaused); err != nil {
+		return nil, errors.Wrapf(err, "error getting jobs by concurrency key")
+	}
+	result := make([]*tork.JobSummary, len(rs))
+	for i, r := range rs {
+		createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+		j, err := r.toJob([]*tork.Task{}, []*tork.Task{}, createdBy, []*tork.Permission{})
+		if err != nil {
+			return nil, err
+		}
+		result[i] = tork.NewJobSummary(j)
+	}
+	return result, nil
+}
+
+func (ds *SQLiteDatastore) CreateScheduledJob(ctx context.Context, sj *tork.ScheduledJob) error {
+	if sj.ID == "" {
+		return errors.Errorf("scheduled job id must not be empty")
+	}
+	if sj.CreatedBy == nil {
+		guest, err := ds.GetUser(ctx, tork.USER_GUEST)
+		if err != nil {
+			return err
+		}
+		sj.CreatedBy = guest
+	}
+	tasks, err := json.Marshal(sj.Tasks)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize scheduledJob.tasks")
+	}
+	inputs, err := json.Marshal(sj.Inputs)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize scheduledJob.inputs")
+	}
+	var secrets *string
+	if sj.Secrets != nil {
+		b, err := json.Marshal(sj.Secrets)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize scheduledJob.secrets")
+		}
+		s := string(b)
+		secrets = &s
+	}
+	var defaults *string
+	if sj.Defaults != nil {
+		b, err := json.Marshal(sj.Defaults)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize scheduledJob.defaults")
+		}
+		s := string(b)
+		defaults = &s
+	}
+	webhooks, err := json.Marshal(sj.Webhooks)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize scheduledJob.webhooks")
+	}
+	perms, err := json.Marshal(sj.Permissions)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize scheduledJob.permissions")
+	}
+	var autoDelete *string
+	if sj.AutoDelete != nil {
+		b, err := json.Marshal(sj.AutoDelete)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize scheduledJob.autoDelete")
+		}
+		s := string(b)
+		autoDelete = &s
+	}
+	concurrency, err := serializeConcurrency(sj.Concurrency)
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize scheduledJob.concurrency")
+	}
+	var inputTypes *string
+	if sj.InputTypes != nil {
+		b, err := json.Marshal(sj.InputTypes)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize scheduledJob.inputTypes")
+		}
+		s := string(b)
+		inputTypes = &s
+	}
+	var inputSchema *string
+	if sj.InputSchema != nil {
+		b, err := json.Marshal(sj.InputSchema)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize scheduledJob.inputSchema")
+		}
+		s := string(b)
+		inputSchema = &s
+	}
+	if sj.Tags == nil {
+		sj.Tags = make([]string, 0)
+	}
+	q := `insert into scheduled_jobs (id,name,description,tags,cron_expr,state,created_at,created_by,
+	        last_run_at,next_run_at,tasks,inputs,secrets,output_,defaults,webhooks,permissions,auto_delete,concurrency,input_types,input_schema) 
+	      values
+	        ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21)`
+	if _, err := ds.exec(q, sj.ID, sj.Name, sj.Description, stringArray(sj.Tags), sj.Cron, sj.State,
+		sj.CreatedAt, sj.CreatedBy.ID, sj.LastRunAt, sj.NextRunAt, tasks, inputs, secrets, sj.Output,
+		defaults, webhooks, perms, autoDelete, concurrency, inputTypes, inputSchema); err != nil {
+		return errors.Wrapf(err, "error inserting scheduled job to the db")
+	}
+	return nil
+}
+
+func (ds *SQLiteDatastore) UpdateScheduledJob(ctx context.Context, id string, modify func(u *tork.ScheduledJob) error) error {
+	return ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		ptx, ok := tx.(*SQLiteDatastore)
+		if !ok {
+			return errors.New("unable to cast to a sqlite datastore")
+		}
+		r := scheduledJobRecord{}
+		if err := ptx.get(&r, `SELECT * FROM scheduled_jobs where id = $1`, id); err != nil {
+			if err == sql.ErrNoRows {
+				return datastore.ErrScheduledJobNotFound
+			}
+			return errors.Wrapf(err, "error fetching scheduled job from db")
+		}
+		createdBy, err := ptx.GetUser(ctx, r.CreatedBy)
+		if err != nil {
+			return err
+		}
+		sj, err := r.toScheduledJob(createdBy)
+		if err != nil {
+			return err
+		}
+		if err := modify(sj); err != nil {
+			return err
+		}
+		q := `update scheduled_jobs set 
+				state = $1,
+				last_run_at = $2,
+				next_run_at = $3
+			  where id = $4`
+		if _, err := ptx.exec(q, sj.State, sj.LastRunAt, sj.NextRunAt, sj.ID); err != nil {
+			return errors.Wrapf(err, "error updating scheduled job %s", sj.ID)
+		}
+		return nil
+	})
+}
+
+func (ds *SQLiteDatastore) GetScheduledJobByID(ctx context.Context, id string) (*tork.ScheduledJob, error) {
+	r := scheduledJobRecord{}
+	if err := ds.get(&r, `SELECT * FROM scheduled_jobs where id = $1`, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrScheduledJobNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching scheduled job from db")
+	}
+	createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+	if err != nil {
+		return nil, err
+	}
+	return r.toScheduledJob(createdBy)
+}
+
+func (ds *SQLiteDatastore) GetScheduledJobs(ctx context.Context, page, size int) (*datastore.Page[*tork.ScheduledJobSummary], error) {
+	offset := (page - 1) * size
+	rs := make([]scheduledJobRecord, 0)
+	qry := fmt.Sprintf(`SELECT * 
+	      FROM scheduled_jobs 
+	      ORDER BY created_at DESC 
+	      LIMIT %d OFFSET %d`, size, offset)
+	if err := ds.select_(&rs, qry); err != nil {
+		return nil, errors.Wrapf(err, "error getting a page of scheduled jobs")
+	}
+	result := make([]*tork.ScheduledJobSummary, len(rs))
+	for i, r := range rs {
+		createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+		sj, err := r.toScheduledJob(createdBy)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = tork.NewScheduledJobSummary(sj)
+	}
+	var count *int
+	if err := ds.get(&count, `select count(*) from scheduled_jobs`); err != nil {
+		return nil, errors.Wrapf(err, "error getting the scheduled jobs count")
+	}
+	totalPages := *count / size
+	if *count%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.ScheduledJobSummary]{
+		Items:      result,
+		Number:     page,
+		Size:       len(result),
+		TotalPages: totalPages,
+		TotalItems: *count,
+	}, nil
+}
+
+func (ds *SQLiteDatastore) GetActiveScheduledJobs(ctx context.Context) ([]*tork.ScheduledJob, error) {
+	rs := make([]scheduledJobRecord, 0)
+	q := `SELECT * 
+	      FROM scheduled_jobs 
+	      where state = $1 
+	      ORDER BY next_run_at ASC`
+	if err := ds.select_(&rs, q, tork.ScheduledJobStateActive); err != nil {
+		return nil, errors.Wrapf(err, "error getting active scheduled jobs from db")
+	}
+	result := make([]*tork.ScheduledJob, len(rs))
+	for i, r := range rs {
+		createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+		sj, err := r.toScheduledJob(createdBy)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = sj
+	}
+	return result, nil
+}
+
+func (ds *SQLiteDatastore) DeleteScheduledJob(ctx context.Context, id string) error {
+	res, err := ds.exec(`delete from scheduled_jobs where id = $1`, id)
+	if err != nil {
+		return errors.Wrapf(err, "error deleting scheduled job from db")
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "error getting the number of deleted scheduled jobs")
+	}
+	if rows == 0 {
+		return datastore.ErrScheduledJobNotFound
+	}
+	return nil
+}
+
+func (ds *SQLiteDatastore) CreateJobTemplate(ctx context.Context, t *tork.JobTemplate) error {
+	if t.ID == "" {
+		return errors.Errorf("job template id must not be empty")
+	}
+	if t.CreatedBy == nil {
+		guest, err := ds.GetUser(ctx, tork.USER_GUEST)
+		if err != nil {
+			return err
+		}
+		t.CreatedBy = guest
+	}
+	var perms *string
+	if len(t.Permissions) > 0 {
+		b, err := json.Marshal(t.Permissions)
+		if err != nil {
+			return errors.Wrapf(err, "failed to serialize jobTemplate.permissions")
+		}
+		s := string(b)
+		perms = &s
+	}
+	q := `insert into job_templates (id,name,version,description,created_at,created_by,permissions,job) 
+	      select $1,$2,coalesce(max(version),0)+1,$3,$4,$5,$6,$7
+	      from job_templates 
+	      where name = $2
+	      returning version`
+	if err := ds.get(&t.Version, q, t.ID, t.Name, t.Description, t.CreatedAt, t.CreatedBy.ID, perms, string(t.Job)); err != nil {
+		return errors.Wrapf(err, "error inserting job template to the db")
+	}
+	return nil
+}
+
+func (ds *SQLiteDatastore) GetJobTemplate(ctx context.Context, name string, version int) (*tork.JobTemplate, error) {
+	r := jobTemplateRecord{}
+	q := `SELECT * 
+	      FROM job_templates 
+	      where name = $1 and ($2 = 0 or version = $2)
+	      ORDER BY version DESC 
+	      LIMIT 1`
+	if err := ds.get(&r, q, name, version); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrJobTemplateNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching job template from db")
+	}
+	createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+	if err != nil {
+		return nil, err
+	}
+	return r.toJobTemplate(createdBy)
+}
+
+func (ds *SQLiteDatastore) GetJobTemplates(ctx context.Context, currentUser string, page, size int) (*datastore.Page[*tork.JobTemplate], error) {
+	offset := (page - 1) * size
+	with := `
+	  WITH role_info AS (
+	    SELECT r.slug
+	    FROM users u
+	    JOIN users_roles ur ON ur.user_id = u.id
+	    JOIN roles r ON r.id = ur.role_id
+	    WHERE u.username_ = $1
+	  )`
+	where := `
+	  WHERE t.version = (select max(version) from job_templates t2 where t2.name = t.name)
+	  AND ($1 = '' OR (t.permissions is null or exists (
+	        select 1 
+	        from json_each(t.permissions) p
+	        where json_extract(p.value,'$.user.username') = $1
+	        or json_extract(p.value,'$.role.slug') in (select slug from role_info)
+	      )))`
+	rs := make([]jobTemplateRecord, 0)
+	qry := fmt.Sprintf(`%s
+	  SELECT t.* 
+	  FROM job_templates t %s
+	  ORDER BY t.name ASC 
+	  LIMIT %d OFFSET %d`, with, where, size, offset)
+	if err := ds.select_(&rs, qry, currentUser); err != nil {
+		return nil, errors.Wrapf(err, "error getting a page of job templates")
+	}
+	result := make([]*tork.JobTemplate, len(rs))
+	for i, r := range rs {
+		createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+		t, err := r.toJobTemplate(createdBy)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = t
+	}
+	var count *int
+	if err := ds.get(&count, fmt.Sprintf(`%s SELECT count(*) FROM job_templates t %s`, with, where), currentUser); err != nil {
+		return nil, errors.Wrapf(err, "error getting the job templates count")
+	}
+	totalPages := *count / size
+	if *count%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.JobTemplate]{
+		Items:      result,
+		Number:     page,
+		Size:       len(result),
+		TotalPages: totalPages,
+		TotalItems: *count,
+	}, nil
+}
+
+func (ds *SQLiteDatastore) DeleteJobTemplate(ctx context.Context, name string, version int) error {
+	res, err := ds.exec(`delete from job_templates where name = $1 and ($2 = 0 or version = $2)`, name, version)
+	if err != nil {
+		return errors.Wrapf(err, "error deleting job template from db")
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "error getting the number of deleted job templates")
+	}
+	if rows == 0 {
+		return datastore.ErrJobTemplateNotFound
+	}
+	return nil
+}
+
+func (ds *SQLiteDatastore) CreateLibraryTask(ctx context.Context, t *tork.LibraryTask) error {
+	if t.ID == "" {
+		return errors.Errorf("library task id must not be empty")
+	}
+	if t.CreatedBy == nil {
+		guest, err := ds.GetUser(ctx, tork.USER_GUEST)
+		if err != nil {
+			return err
+		}
+		t.CreatedBy = guest
+	}
+	var params *string
+	if len(t.Params) > 0 {
+		s := string(t.Params)
+		params = &s
+	}
+	q := `insert into library_tasks (id,name,version,description,created_at,created_by,params,task) 
+	      select $1,$2,coalesce(max(version),0)+1,$3,$4,$5,$6,$7
+	      from library_tasks 
+	      where name = $2
+	      returning version`
+	if err := ds.get(&t.Version, q, t.ID, t.Name, t.Description, t.CreatedAt, t.CreatedBy.ID, params, string(t.Task)); err != nil {
+		return errors.Wrapf(err, "error inserting library task to the db")
+	}
+	return nil
+}
+
+func (ds *SQLiteDatastore) GetLibraryTask(ctx context.Context, name string, version int) (*tork.LibraryTask, error) {
+	r := libraryTaskRecord{}
+	q := `SELECT * 
+	      FROM library_tasks 
+	      where name = $1 and ($2 = 0 or version = $2)
+	      ORDER BY version DESC 
+	      LIMIT 1`
+	if err := ds.get(&r, q, name, version); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrLibraryTaskNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching library task from db")
+	}
+	createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+	if err != nil {
+		return nil, err
+	}
+	return r.toLibraryTask(createdBy), nil
+}
+
+func (ds *SQLiteDatastore) GetLibraryTasks(ctx context.Context, page, size int) (*datastore.Page[*tork.LibraryTask], error) {
+	offset := (page - 1) * size
+	where := `WHERE t.version = (select max(version) from library_tasks t2 where t2.name = t.name)`
+	rs := make([]libraryTaskRecord, 0)
+	qry := fmt.Sprintf(`
+	  SELECT t.* 
+	  FROM library_tasks t %s
+	  ORDER BY t.name ASC 
+	  LIMIT %d OFFSET %d`, where, size, offset)
+	if err := ds.select_(&rs, qry); err != nil {
+		return nil, errors.Wrapf(err, "error getting a page of library tasks")
+	}
+	result := make([]*tork.LibraryTask, len(rs))
+	for i, r := range rs {
+		createdBy, err := ds.GetUser(ctx, r.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = r.toLibraryTask(createdBy)
+	}
+	var count *int
+	if err := ds.get(&count, fmt.Sprintf(`SELECT count(*) FROM library_tasks t %s`, where)); err != nil {
+		return nil, errors.Wrapf(err, "error getting the library tasks count")
+	}
+	totalPages := *count / size
+	if *count%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.LibraryTask]{
+		Items:      result,
+		Number:     page,
+		Size:       len(result),
+		TotalPages: totalPages,
+		TotalItems: *count,
+	}, nil
+}
+
+func (ds *SQLiteDatastore) DeleteLibraryTask(ctx context.Context, name string, version int) error {
+	res, err := ds.exec(`delete from library_tasks where name = $1 and ($2 = 0 or version = $2)`, name, version)
+	if err != nil {
+		return errors.Wrapf(err, "error deleting library task from db")
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "error getting the number of deleted library tasks")
+	}
+	if rows == 0 {
+		return datastore.ErrLibraryTaskNotFound
+	}
+	return nil
+}
+
+func (ds *SQLiteDatastore) GetUser(ctx context.Context, uid string) (*tork.User, error) {
+	r := userRecord{}
+	if err := ds.get(&r, `SELECT * FROM users where (username_ = $1 or id = $1)`, uid); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrUserNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching user from db")
+	}
+	return r.toUser(), nil
+}
+
+func (ds *SQLiteDatastore) CreateUser(ctx context.Context, u *tork.User) error {
+	u.ID = uuid.NewUUID()
+	now := time.Now().UTC()
+	u.CreatedAt = &now
+	q := `insert into users 
+	       (id,name,username_,password_,created_at) 
+	      values
+	       ($1,$2,$3,$4,$5)`
+	_, err := ds.exec(q, u.ID, u.Name, u.Username, u.PasswordHash, u.CreatedAt)
+	if err != nil {
+		return errors.Wrapf(err, "error inserting user to the db")
+	}
+	return nil
+}
+
+func (ds *SQLiteDatastore) GetUsers(ctx context.Context, page, size int) (*datastore.Page[*tork.User], error) {
+	offset := (page - 1) * size
+	rs := make([]userRecord, 0)
+	qry := fmt.Sprintf(`SELECT * 
+	      FROM users 
+	      ORDER BY username_ ASC 
+	      LIMIT %d OFFSET %d`, size, offset)
+	if err := ds.select_(&rs, qry); err != nil {
+		return nil, errors.Wrapf(err, "error getting a page of users")
+	}
+	result := make([]*tork.User, len(rs))
+	for i, r := range rs {
+		result[i] = r.toUser()
+	}
+	var count *int
+	if err := ds.get(&count, `select count(*) from users`); err != nil {
+		return nil, errors.Wrapf(err, "error getting the users count")
+	}
+	totalPages := *count / size
+	if *count%size != 0 {
+		totalPages = totalPages + 1
+	}
+	return &datastore.Page[*tork.User]{
+		Items:      result,
+		Number:     page,
+		Size:       len(result),
+		TotalPages: totalPages,
+		TotalItems: *count,
+	}, nil
+}
+
+func (ds *SQLiteDatastore) UpdateUser(ctx context.Context, id string, modify func(u *tork.User) error) error {
+	return ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		ptx, ok := tx.(*SQLiteDatastore)
+		if !ok {
+			return errors.New("unable to cast to a sqlite datastore")
+		}
+		r := userRecord{}
+		if err := ptx.get(&r, `SELECT * FROM users where id = $1`, id); err != nil {
+			if err == sql.ErrNoRows {
+				return datastore.ErrUserNotFound
+			}
+			return errors.Wrapf(err, "error fetching user from db")
+		}
+		u := r.toUser()
+		if err := modify(u); err != nil {
+			return err
+		}
+		q := `update users set 
+				name = $1,
+				password_ = $2,
+				is_disabled = $3
+			  where id = $4`
+		if _, err := ptx.exec(q, u.Name, u.PasswordHash, u.Disabled, r.ID); err != nil {
+			return errors.Wrapf(err, "error updating user %s", r.ID)
+		}
+		return nil
+	})
+}
+
+func (ds *SQLiteDatastore) DeleteUser(ctx context.Context, id string) error {
+	return ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		ptx, ok := tx.(*SQLiteDatastore)
+		if !ok {
+			return errors.New("unable to cast to a sqlite datastore")
+		}
+		if _, err := ptx.exec(`delete from users_roles where user_id = $1`, id); err != nil {
+			return errors.Wrapf(err, "error deleting user roles from db")
+		}
+		res, err := ptx.exec(`delete from users where id = $1`, id)
+		if err != nil {
+			if isForeignKeyViolation(err) {
+				return datastore.ErrUserInUse
+			}
+			return errors.Wrapf(err, "error deleting user from db")
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrapf(err, "error getting the number of deleted users")
+		}
+		if rows == 0 {
+			return datastore.ErrUserNotFound
+		}
+		return nil
+	})
+}
+
+func (ds *SQLiteDatastore) CreateRole(ctx context.Context, r *tork.Role) error {
+	r.ID = uuid.NewUUID()
+	now := time.Now().UTC()
+	r.CreatedAt = &now
+	q := `insert into roles 
+	       (id,slug,name,created_at) 
+	      values
+	       ($1,$2,$3,$4)`
+	_, err := ds.exec(q, r.ID, r.Slug, r.Name, r.CreatedAt)
+	if err != nil {
+		return errors.Wrapf(err, "error inserting role to the db")
+	}
+	return nil
+}
+
+func (ds *SQLiteDatastore) GetRole(ctx context.Context, id string) (*tork.Role, error) {
+	r := roleRecord{}
+	if err := ds.get(&r, `SELECT * FROM roles where id = $1 or slug = $1`, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, datastore.ErrRoleNotFound
+		}
+		return nil, errors.Wrapf(err, "error fetching role from db")
+	}
+	return r.toRole(), nil
+}
+
+func (ds *SQLiteDatastore) GetRoles(ctx context.Context) ([]*tork.Role, error) {
+	rs := []roleRecord{}
+	if err := ds.select_(&rs, `SELECT * FROM roles order by name`); err != nil {
+		return nil, errors.Wrapf(err, "error fetching roles from db")
+	}
+	result := make([]*tork.Role, len(rs))
+	for i, r := range rs {
+		result[i] = r.toRole()
+	}
+	return result, nil
+}
+
+func (ds *SQLiteDatastore) DeleteRole(ctx context.Context, id string) error {
+	return ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		ptx, ok := tx.(*SQLiteDatastore)
+		if !ok {
+			return errors.New("unable to cast to a sqlite datastore")
+		}
+		if _, err := ptx.exec(`delete from users_roles where role_id = $1`, id); err != nil {
+			return errors.Wrapf(err, "error deleting user roles from db")
+		}
+		res, err := ptx.exec(`delete from roles where id = $1`, id)
+		if err != nil {
+			if isForeignKeyViolation(err) {
+				return datastore.ErrRoleInUse
+			}
+			return errors.Wrapf(err, "error deleting role from db")
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrapf(err, "error getting the number of deleted roles")
+		}
+		if rows == 0 {
+			return datastore.ErrRoleNotFound
+		}
+		return nil
+	})
+}
+
+func (ds *SQLiteDatastore) GetUserRoles(ctx context.Context, userID string) ([]*tork.Role, error) {
+	rs := []roleRecord{}
+	if err := ds.select_(&rs, `SELECT r.* FROM roles r inner join users_roles ur on ur.role_id=r.id where ur.user_id = $1`, userID); err != nil {
+		return nil, errors.Wrapf(err, "error fetching user roles from db")
+	}
+	result := make([]*tork.Role, len(rs))
+	for i, r := range rs {
+		result[i] = r.toRole()
+	}
+	return result, nil
+}
+
+func (ds *SQLiteDatastore) AssignRole(ctx context.Context, userID, roleID string) error {
+	q := `insert into users_roles 
+	       (id,user_id,role_id,created_at) 
+	      values
+	       ($1,$2,$3,$4)`
+	_, err := ds.exec(q, uuid.NewUUID(), userID, roleID, time.Now().UTC())
+	if err != nil {
+		return errors.Wrapf(err, "error inserting role to the db")
+	}
+	return nil
+}
+
+func (ds *SQLiteDatastore) UnassignRole(ctx context.Context, userID, roleID string) error {
+	sql := `delete from users_roles where user_id = $1 and role_id = $2`
+	if _, err := ds.exec(sql, userID, roleID); err != nil {
+		return errors.Wrapf(err, "error deleting user role from db")
+	}
+	return nil
+}
+
+func (ds *SQLiteDatastore) GetMetrics(ctx context.Context) (*tork.Metrics, error) {
+	s := &tork.Metrics{}
+
+	if err := ds.get(&s.Jobs.Running, "select count(*) from jobs where state = 'RUNNING'"); err != nil {
+		return nil, errors.Wrapf(err, "error getting the running jobs count")
+	}
+
+	if err := ds.get(&s.Tasks.Running, "select count(*) from tasks where state = 'RUNNING'"); err != nil {
+		return nil, errors.Wrapf(err, "error getting the running tasks count")
+	}
+
+	if err := ds.get(&s.Nodes.Running, "select count(*) from nodes where last_heartbeat_at > $1", time.Now().UTC().Add(-time.Minute*5)); err != nil {
+		return nil, errors.Wrapf(err, "error getting the running tasks count")
+	}
+
+	if err := ds.get(&s.Nodes.CPUPercent, "select coalesce(avg(cpu_percent),0) from nodes where last_heartbeat_at > $1", time.Now().UTC().Add(-time.Minute*5)); err != nil {
+		return nil, errors.Wrapf(err, "error getting the running tasks count")
+	}
+
+	return s, nil
+}
+
+// isForeignKeyViolation returns true if the error is the result
+// of deleting a row which is still referenced by another table.
+func isForeignKeyViolation(err error) bool {
+	var serr *sqlite.Error
+	return errors.As(err, &serr) && serr.Code() == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY
+}
+
+// isUniqueViolation returns true if the error is the
+// result of violating a unique constraint.
+func isUniqueViolation(err error) bool {
+	var serr *sqlite.Error
+	return errors.As(err, &serr) && serr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+// utcArgs converts the time arguments of a query to UTC
+// since times are stored as strings and compared as such.
+func utcArgs(args []any) []any {
+	result := make([]any, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case time.Time:
+			result[i] = v.UTC()
+		case *time.Time:
+			result[i] = utc(v)
+		default:
+			result[i] = arg
+		}
+	}
+	return result
+}
+
+func (ds *SQLiteDatastore) get(dest interface{}, query string, args ...interface{}) error {
+	if ds.tx != nil {
+		return ds.tx.Get(dest, query, utcArgs(args)...)
+	} else {
+		return ds.db.Get(dest, query, utcArgs(args)...)
+	}
+}
+
+func (ds *SQLiteDatastore) select_(dest interface{}, query string, args ...interface{}) error {
+	if ds.tx != nil {
+		return ds.tx.Select(dest, query, utcArgs(args)...)
+	} else {
+		return ds.db.Select(dest, query, utcArgs(args)...)
+	}
+}
+
+func (ds *SQLiteDatastore) exec(query string, args ...any) (sql.Result, error) {
+	if ds.tx != nil {
+		return ds.tx.Exec(query, utcArgs(args)...)
+	} else {
+		return ds.db.Exec(query, utcArgs(args)...)
+	}
+}
+
+func (ds *SQLiteDatastore) WithTx(ctx context.Context, f func(tx datastore.Datastore) error) error {
+	var tx *sqlx.Tx
+	var err error
+	var owner bool
+	if ds.tx != nil {
+		tx = ds.tx
+	} else {
+		owner = true
+		tx, err = ds.db.BeginTxx(ctx, &sql.TxOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "unable to begin tx")
+		}
+	}
+	dsx := &SQLiteDatastore{
+		tx: tx,
+	}
+	if err := f(dsx); err != nil {
+		if owner {
+			if err := tx.Rollback(); err != nil {
+				log.Error().
+					Err(err).
+					Msgf("error rolling back tx")
+			}
+		}
+		return err
+	}
+	if owner {
+		if err := tx.Commit(); err != nil {
+			return errors.Wrapf(err, "error committing transaction")
+		}
+	}
+	return nil
+}
+
+func (ds *SQLiteDatastore) HealthCheck(ctx context.Context) error {
+	if _, err := ds.db.ExecContext(ctx, "select 1 from jobs limit 1"); err != nil {
+		return errors.Wrapf(err, "error reading from jobs table")
+	}
+	if _, err := ds.db.ExecContext(ctx, "select 1 from tasks limit 1"); err != nil {
+		return errors.Wrapf(err, "error reading from tasks table")
+	}
+	if _, err := ds.db.ExecContext(ctx, "select 1 from nodes limit 1"); err != nil {
+		return errors.Wrapf(err, "error reading from nodes table")
+	}
+	return nil
+}
+
+func serializeConcurrency(c *tork.JobConcurrency) (*string, error) {
+	if c == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	return &s, nil
+}
+
+func parseQuery(query string) (string, []string) {
+	terms := []string{}
+	tags := []string{}
+	parts := strings.Fields(query)
+	for _, part := range parts {
+		if strings.HasPrefix(part, "tag:") {
+			tags = append(tags, strings.TrimPrefix(part, "tag:"))
+		} else if strings.HasPrefix(part, "tags:") {
+			tags = append(tags, strings.Split(strings.TrimPrefix(part, "tags:"), ",")...)
+		} else {
+			terms = append(terms, part)
+		}
+	}
+	return strings.Join(terms, " "), tags
+}
+
+// toMatchQuery converts a search term to an FTS5 query
+// which matches the rows containing all of its words.
+func toMatchQuery(searchTerm string) string {
+	words := strings.Fields(searchTerm)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,2250 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/archive"
+	"github.com/runabol/tork/archive/local"
+	"github.com/runabol/tork/datastore"
+	schema "github.com/runabol/tork/db/sqlite"
+
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDatastore(t *testing.T, opts ...Option) (*SQLiteDatastore, error) {
+	ds, err := NewSQLiteDatastore(filepath.Join(t.TempDir(), "tork.db"), opts...)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() {
+		assert.NoError(t, ds.db.Close())
+	})
+	if err := ds.ExecScript(schema.SCHEMA); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+func TestSQLiteCreateAndGetTask(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.USER_GUEST, j1.CreatedBy.Username)
+
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.USER_GUEST, j2.CreatedBy.Username)
+
+	t1 := tork.Task{
+		ID:          uuid.NewUUID(),
+		CreatedAt:   &now,
+		JobID:       j1.ID,
+		Description: "some description",
+		Networks:    []string{"some-network"},
+		Files:       map[string]string{"myfile": "hello world"},
+		Registry:    &tork.Registry{Username: "me", Password: "secret"},
+		GPUs:        "all",
+		If:          "true",
+		Tags:        []string{"tag1", "tag2"},
+		Workdir:     "/some/dir",
+		Priority:    2,
+		Ports: []*tork.Port{{
+			Port: "1234",
+		}},
+		Artifacts: &tork.TaskArtifacts{
+			Outputs: []tork.TaskArtifact{{Name: "report", Path: "report.txt"}},
+		},
+		NodeSelector: map[string]string{"zone": "a"},
+		Affinity:     map[string]string{"arch": "arm64"},
+	}
+	err = ds.CreateTask(ctx, &t1)
+	assert.NoError(t, err)
+	t2, err := ds.GetTaskByID(ctx, t1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, t1.ID, t2.ID)
+	assert.Equal(t, t1.Description, t2.Description)
+	assert.Equal(t, []string([]string{"some-network"}), t2.Networks)
+	assert.Equal(t, map[string]string{"myfile": "hello world"}, t2.Files)
+	assert.Equal(t, map[string]string{"zone": "a"}, t2.NodeSelector)
+	assert.Equal(t, map[string]string{"arch": "arm64"}, t2.Affinity)
+	assert.Equal(t, "me", t2.Registry.Username)
+	assert.Equal(t, "secret", t2.Registry.Password)
+	assert.Equal(t, "all", t2.GPUs)
+	assert.Equal(t, "true", t2.If)
+	assert.Nil(t, t2.Parallel)
+	assert.Equal(t, []string([]string{"tag1", "tag2"}), t2.Tags)
+	assert.Equal(t, "/some/dir", t2.Workdir)
+	assert.Equal(t, 2, t2.Priority)
+	assert.Equal(t, "1234", t2.Ports[0].Port)
+	assert.Equal(t, t1.Artifacts, t2.Artifacts)
+}
+
+func TestSQLiteCreateJob(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	u := &tork.User{
+		ID:        uuid.NewUUID(),
+		Username:  uuid.NewShortUUID(),
+		Name:      "Tester",
+		CreatedAt: &now,
+	}
+	err = ds.CreateUser(ctx, u)
+	assert.NoError(t, err)
+	j1 := tork.Job{
+		ID:        uuid.NewUUID(),
+		CreatedBy: u,
+		Tags:      []string{"tag-a", "tag-b"},
+		AutoDelete: &tork.AutoDelete{
+			After: "5h",
+		},
+		Secrets: map[string]string{
+			"password": "secret",
+		},
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	assert.Equal(t, u.Username, j1.CreatedBy.Username)
+
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, u.Username, j2.CreatedBy.Username)
+	assert.Equal(t, []string{"tag-a", "tag-b"}, j2.Tags)
+	assert.Equal(t, "5h", j2.AutoDelete.After)
+	assert.Equal(t, map[string]string{"password": "secret"}, j2.Secrets)
+}
+
+func TestSQLiteCreateAndGetParallelTask(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	t1 := tork.Task{
+		ID:        uuid.NewUUID(),
+		CreatedAt: &now,
+		JobID:     j1.ID,
+		Parallel: &tork.ParallelTask{
+			Tasks: []*tork.Task{{
+				Name: "parallel task1",
+			}, {
+				Name: "parallel task2",
+			}},
+		},
+	}
+	err = ds.CreateTask(ctx, &t1)
+	assert.NoError(t, err)
+	t2, err := ds.GetTaskByID(ctx, t1.ID)
+	assert.NoError(t, err)
+	assert.NotNil(t, t2.Parallel)
+}
+
+func TestSQLiteCreateTaskBadOutput(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	t1 := tork.Task{
+		ID:          uuid.NewUUID(),
+		CreatedAt:   &now,
+		JobID:       j1.ID,
+		Description: "some description",
+		Result:      string([]byte{0}),
+		Error:       string([]byte{0}),
+	}
+	err = ds.CreateTask(ctx, &t1)
+	assert.NoError(t, err)
+	t2, err := ds.GetTaskByID(ctx, t1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, t1.ID, t2.ID)
+	assert.Equal(t, t1.Description, t2.Description)
+}
+
+func TestSQLiteGetActiveTasks(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+
+	j1 := tork.Job{
+		ID:        uuid.NewUUID(),
+		CreatedAt: time.Now().UTC(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+
+	tasks := []*tork.Task{{
+		ID:        uuid.NewUUID(),
+		State:     tork.TaskStatePending,
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}, {
+		ID:        uuid.NewUUID(),
+		State:     tork.TaskStateScheduled,
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}, {
+		ID:        uuid.NewUUID(),
+		State:     tork.TaskStateRunning,
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}, {
+		ID:        uuid.NewUUID(),
+		State:     tork.TaskStateCancelled,
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}, {
+		ID:        uuid.NewUUID(),
+		State:     tork.TaskStateCompleted,
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}, {
+		ID:        uuid.NewUUID(),
+		State:     tork.TaskStateFailed,
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}}
+
+	for _, ta := range tasks {
+		err := ds.CreateTask(ctx, ta)
+		assert.NoError(t, err)
+	}
+	at, err := ds.GetActiveTasks(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(at))
+}
+
+func TestSQLiteUpdateTask(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	t1 := &tork.Task{
+		ID:        uuid.NewUUID(),
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}
+	err = ds.CreateTask(ctx, t1)
+	assert.NoError(t, err)
+
+	err = ds.UpdateTask(ctx, t1.ID, func(u *tork.Task) error {
+		u.State = tork.TaskStateScheduled
+		u.Result = "my result"
+		u.Queue = "somequeue"
+		u.Progress = 57.3
+		return nil
+	})
+	assert.NoError(t, err)
+
+	t2, err := ds.GetTaskByID(ctx, t1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.TaskStateScheduled, t2.State)
+	assert.Equal(t, "my result", t2.Result)
+	assert.Equal(t, "somequeue", t2.Queue)
+	assert.Equal(t, 57.3, t2.Progress)
+}
+
+func TestSQLiteUpdateTaskConcurrently(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	t1 := &tork.Task{
+		ID:        uuid.NewUUID(),
+		CreatedAt: &now,
+		JobID:     j1.ID,
+		Parallel:  &tork.ParallelTask{},
+	}
+	err = ds.CreateTask(ctx, t1)
+	assert.NoError(t, err)
+
+	wg := sync.WaitGroup{}
+	wg.Add(5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			defer wg.Done()
+			err := ds.UpdateTask(ctx, t1.ID, func(u *tork.Task) error {
+				u.State = tork.TaskStateScheduled
+				u.Result = "my result"
+				u.Parallel.Completions = u.Parallel.Completions + 1
+				return nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	t2, err := ds.GetTaskByID(ctx, t1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.TaskStateScheduled, t2.State)
+	assert.Equal(t, "my result", t2.Result)
+	assert.Equal(t, 5, t2.Parallel.Completions)
+}
+
+func TestSQLiteUpdateTaskBadStrings(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	t1 := &tork.Task{
+		ID:        uuid.NewUUID(),
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}
+	err = ds.CreateTask(ctx, t1)
+	assert.NoError(t, err)
+
+	err = ds.UpdateTask(ctx, t1.ID, func(u *tork.Task) error {
+		u.State = tork.TaskStateScheduled
+		u.Result = string([]byte{0})
+		u.Error = string([]byte{0})
+		return nil
+	})
+	assert.NoError(t, err)
+
+	t2, err := ds.GetTaskByID(ctx, t1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.TaskStateScheduled, t2.State)
+}
+
+func TestSQLiteCreateAndGetNode(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	n1 := &tork.Node{
+		ID:       uuid.NewUUID(),
+		Name:     "some node",
+		Hostname: "some-name",
+		Port:     1234,
+		Version:  "1.0.0",
+		CPUs:     4,
+		Memory:   1024,
+		Labels:   map[string]string{"zone": "a"},
+		Queues:   []string{"default", "gpu"},
+	}
+	err = ds.CreateNode(ctx, n1)
+	assert.NoError(t, err)
+	n2, err := ds.GetNodeByID(ctx, n1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, n1.ID, n2.ID)
+	assert.Equal(t, "some-name", n2.Hostname)
+	assert.Equal(t, 1234, n2.Port)
+	assert.Equal(t, "1.0.0", n2.Version)
+	assert.Equal(t, "some node", n2.Name)
+	assert.Equal(t, float64(4), n2.CPUs)
+	assert.Equal(t, int64(1024), n2.Memory)
+	assert.Equal(t, map[string]string{"zone": "a"}, n2.Labels)
+	assert.Equal(t, []string{"default", "gpu"}, n2.Queues)
+}
+
+func TestSQLiteUpdateNode(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+
+	n1 := &tork.Node{
+		ID:              uuid.NewUUID(),
+		LastHeartbeatAt: time.Now().UTC().Add(-time.Minute),
+	}
+	err = ds.CreateNode(ctx, n1)
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+
+	err = ds.UpdateNode(ctx, n1.ID, func(u *tork.Node) error {
+		u.LastHeartbeatAt = now
+		u.TaskCount = 2
+		return nil
+	})
+	assert.NoError(t, err)
+
+	n2, err := ds.GetNodeByID(ctx, n1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Hour(), n2.LastHeartbeatAt.Hour())
+	assert.Equal(t, now.Minute(), n2.LastHeartbeatAt.Minute())
+	assert.Equal(t, now.Second(), n2.LastHeartbeatAt.Second())
+	assert.Equal(t, 2, n2.TaskCount)
+}
+
+func TestSQLiteUpdateNodeConcurrently(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+
+	n1 := &tork.Node{
+		ID:              uuid.NewUUID(),
+		LastHeartbeatAt: time.Now().UTC().Add(-time.Minute),
+	}
+	err = ds.CreateNode(ctx, n1)
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+
+	wg := sync.WaitGroup{}
+	wg.Add(5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			defer wg.Done()
+			err := ds.UpdateNode(ctx, n1.ID, func(u *tork.Node) error {
+				u.LastHeartbeatAt = now
+				u.CPUPercent = u.CPUPercent + 1
+				return nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	n2, err := ds.GetNodeByID(ctx, n1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Hour(), n2.LastHeartbeatAt.Hour())
+	assert.Equal(t, now.Minute(), n2.LastHeartbeatAt.Minute())
+	assert.Equal(t, now.Second(), n2.LastHeartbeatAt.Second())
+	assert.Equal(t, float64(5), n2.CPUPercent)
+}
+
+func TestSQLiteGetActiveNodes(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	n1 := &tork.Node{
+		ID:              uuid.NewUUID(),
+		Status:          tork.NodeStatusUP,
+		LastHeartbeatAt: time.Now().UTC().Add(-time.Second * 20),
+	}
+	n2 := &tork.Node{
+		ID:              uuid.NewUUID(),
+		Status:          tork.NodeStatusUP,
+		LastHeartbeatAt: time.Now().UTC().Add(-time.Minute * 4),
+	}
+	n3 := &tork.Node{ // inactive
+		ID:              uuid.NewUUID(),
+		Status:          tork.NodeStatusUP,
+		LastHeartbeatAt: time.Now().UTC().Add(-time.Minute * 10),
+	}
+	err = ds.CreateNode(ctx, n1)
+	assert.NoError(t, err)
+
+	err = ds.CreateNode(ctx, n2)
+	assert.NoError(t, err)
+
+	err = ds.CreateNode(ctx, n3)
+	assert.NoError(t, err)
+
+	ns, err := ds.GetActiveNodes(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(ns))
+	assert.Equal(t, tork.NodeStatusUP, ns[0].Status)
+	assert.Equal(t, tork.NodeStatusOffline, ns[1].Status)
+}
+
+func TestSQLiteCreateAndGetJob(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+		Inputs: map[string]string{
+			"var1": "val1",
+		},
+		Defaults: &tork.JobDefaults{
+			Timeout: "5s",
+			Retry: &tork.TaskRetry{
+				Limit: 2,
+			},
+			Limits: &tork.TaskLimits{
+				CPUs:   ".5",
+				Memory: "10MB",
+			},
+		},
+		Webhooks: []*tork.Webhook{
+			{
+				URL: "http://example.com/1",
+				Headers: map[string]string{
+					"header1": "value1",
+				},
+			},
+			{
+				URL: "http://example.com/2",
+				Headers: map[string]string{
+					"header1": "value1",
+				},
+				Event: "job.StatusChange",
+			},
+		},
+		Permissions: []*tork.Permission{{
+			User: &tork.User{
+				Username: tork.USER_GUEST,
+			},
+		}},
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, j1.ID, j2.ID)
+	assert.Equal(t, "val1", j2.Inputs["var1"])
+	assert.Equal(t, "5s", j2.Defaults.Timeout)
+	assert.Equal(t, 2, j2.Defaults.Retry.Limit)
+	assert.Equal(t, ".5", j2.Defaults.Limits.CPUs)
+	assert.Equal(t, "10MB", j2.Defaults.Limits.Memory)
+	assert.Len(t, j2.Webhooks, 2)
+	assert.Equal(t, j1.Webhooks[0], j2.Webhooks[0])
+	assert.Equal(t, j1.Webhooks[1], j2.Webhooks[1])
+	assert.Equal(t, "guest", j2.Permissions[0].User.Username)
+
+	j3 := tork.Job{
+		ID: uuid.NewUUID(),
+		Permissions: []*tork.Permission{{
+			Role: &tork.Role{
+				Slug: tork.ROLE_PUBLIC,
+			},
+		}},
+	}
+	err = ds.CreateJob(ctx, &j3)
+	assert.NoError(t, err)
+	j4, err := ds.GetJobByID(ctx, j3.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "public", j4.Permissions[0].Role.Slug)
+}
+
+func TestSQLiteUpdateJob(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	j1 := tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStatePending,
+		Context: tork.JobContext{
+			Inputs: map[string]string{
+				"var1": "val1",
+			},
+		},
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	deleteAt := time.Now().UTC()
+	err = ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+		u.State = tork.JobStateCompleted
+		u.Context.Inputs["var2"] = "val2"
+		u.DeleteAt = &deleteAt
+		u.Progress = 56
+		return nil
+	})
+	assert.NoError(t, err)
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateCompleted, j2.State)
+	assert.Equal(t, "val1", j2.Context.Inputs["var1"])
+	assert.Equal(t, "val2", j2.Context.Inputs["var2"])
+	assert.Equal(t, deleteAt.Unix(), j2.DeleteAt.Unix())
+	assert.Equal(t, float64(56), j2.Progress)
+}
+
+func TestSQLitePauseJob(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	j1 := tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateRunning,
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	pausedAt := time.Now().UTC()
+	err = ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+		u.State = tork.JobStatePaused
+		u.PausedAt = &pausedAt
+		return nil
+	})
+	assert.NoError(t, err)
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStatePaused, j2.State)
+	assert.Equal(t, pausedAt.Unix(), j2.PausedAt.Unix())
+	err = ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+		u.State = tork.JobStateRunning
+		u.PausedAt = nil
+		return nil
+	})
+	assert.NoError(t, err)
+	j2, err = ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateRunning, j2.State)
+	assert.Nil(t, j2.PausedAt)
+}
+
+func TestSQLiteUpdateJobRerun(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	j1 := tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateFailed,
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	err = ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+		u.State = tork.JobStatePending
+		u.Rerun = &tork.JobRerun{FromTask: "some-task", OnlyFailed: true}
+		return nil
+	})
+	assert.NoError(t, err)
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, &tork.JobRerun{FromTask: "some-task", OnlyFailed: true}, j2.Rerun)
+	err = ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+		u.State = tork.JobStateScheduled
+		u.Rerun = nil
+		return nil
+	})
+	assert.NoError(t, err)
+	j2, err = ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, j2.Rerun)
+}
+
+func TestSQLiteUpdateJobConcurrently(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	j1 := tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStatePending,
+		Context: tork.JobContext{
+			Inputs: map[string]string{
+				"var1": "val1",
+			},
+		},
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+
+	wg := sync.WaitGroup{}
+	wg.Add(5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			defer wg.Done()
+			err := ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+				u.State = tork.JobStateCompleted
+				u.Context.Inputs["var2"] = "val2"
+				u.Position = u.Position + 1
+				return nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.NoError(t, err)
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateCompleted, j2.State)
+	assert.Equal(t, "val1", j2.Context.Inputs["var1"])
+	assert.Equal(t, "val2", j2.Context.Inputs["var2"])
+	assert.Equal(t, 5, j2.Position)
+}
+
+func TestSQLiteGetJobs(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	for i := 0; i < 101; i++ {
+		j1 := tork.Job{
+			ID:   uuid.NewUUID(),
+			Name: fmt.Sprintf("Job %d", (i + 1)),
+			Tasks: []*tork.Task{
+				{
+					Name: "some task",
+				},
+			},
+		}
+		err := ds.CreateJob(ctx, &j1)
+		assert.NoError(t, err)
+
+		now := time.Now().UTC()
+		err = ds.CreateTask(ctx, &tork.Task{
+			ID:        uuid.NewUUID(),
+			JobID:     j1.ID,
+			State:     tork.TaskStateRunning,
+			CreatedAt: &now,
+		})
+		assert.NoError(t, err)
+	}
+	p1, err := ds.GetJobs(ctx, "", "", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, p1.Size)
+	assert.Equal(t, 101, p1.TotalItems)
+
+	p2, err := ds.GetJobs(ctx, "", "", 2, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, p2.Size)
+
+	p10, err := ds.GetJobs(ctx, "", "", 10, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, p10.Size)
+
+	p11, err := ds.GetJobs(ctx, "", "", 11, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, p11.Size)
+
+	assert.NotEqual(t, p2.Items[0].ID, p1.Items[9].ID)
+	assert.NotEqual(t, p2.Items[0].ID, p1.Items[9].ID)
+}
+
+func TestSQLiteSearchJobs(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+
+	u1 := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: uuid.NewShortUUID(),
+		Name:     "Tester",
+	}
+	err = ds.CreateUser(ctx, u1)
+	assert.NoError(t, err)
+
+	u2 := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: uuid.NewShortUUID(),
+		Name:     "Tester",
+	}
+	err = ds.CreateUser(ctx, u2)
+	assert.NoError(t, err)
+
+	r := &tork.Role{
+		Slug: "test-role",
+		Name: "Test Role",
+	}
+	err = ds.CreateRole(ctx, r)
+	assert.NoError(t, err)
+
+	err = ds.AssignRole(ctx, u2.ID, r.ID)
+	assert.NoError(t, err)
+
+	u3 := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: uuid.NewShortUUID(),
+		Name:     "Tester",
+	}
+	err = ds.CreateUser(ctx, u3)
+	assert.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		j1 := tork.Job{
+			ID:    uuid.NewUUID(),
+			Name:  fmt.Sprintf("Job %d", (i + 1)),
+			State: tork.JobStateRunning,
+			Tasks: []*tork.Task{{
+				Name: "some task",
+			}},
+			Tags: []string{fmt.Sprintf("tag-%d", i)},
+			Permissions: []*tork.Permission{{
+				User: u1,
+			}, {
+				Role: r,
+			}},
+		}
+		err := ds.CreateJob(ctx, &j1)
+		assert.NoError(t, err)
+
+		now := time.Now().UTC()
+		err = ds.CreateTask(ctx, &tork.Task{
+			ID:        uuid.NewUUID(),
+			JobID:     j1.ID,
+			State:     tork.TaskStateRunning,
+			CreatedAt: &now,
+		})
+		assert.NoError(t, err)
+	}
+
+	for i := 100; i < 101; i++ {
+		j1 := tork.Job{
+			ID:    uuid.NewUUID(),
+			Name:  fmt.Sprintf("Job %d", (i + 1)),
+			State: tork.JobStateRunning,
+			Tasks: []*tork.Task{{
+				Name: "some task",
+			}},
+			Tags: []string{fmt.Sprintf("tag-%d", i)},
+		}
+		err := ds.CreateJob(ctx, &j1)
+		assert.NoError(t, err)
+
+		now := time.Now().UTC()
+		err = ds.CreateTask(ctx, &tork.Task{
+			ID:        uuid.NewUUID(),
+			JobID:     j1.ID,
+			State:     tork.TaskStateRunning,
+			CreatedAt: &now,
+		})
+		assert.NoError(t, err)
+	}
+
+	p1, err := ds.GetJobs(ctx, "", "", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, p1.Size)
+	assert.Equal(t, 101, p1.TotalItems)
+
+	p1, err = ds.GetJobs(ctx, "", "101", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, p1.Size)
+	assert.Equal(t, 1, p1.TotalItems)
+
+	p1, err = ds.GetJobs(ctx, "", "tag:tag-1", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, p1.Size)
+	assert.Equal(t, 1, p1.TotalItems)
+
+	p1, err = ds.GetJobs(ctx, "", "tag:not-a-tag", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, p1.Size)
+	assert.Equal(t, 0, p1.TotalItems)
+
+	p1, err = ds.GetJobs(ctx, "", "tags:not-a-tag,tag-1", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, p1.Size)
+	assert.Equal(t, 1, p1.TotalItems)
+
+	p1, err = ds.GetJobs(ctx, "", "Job", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, p1.Size)
+	assert.Equal(t, 101, p1.TotalItems)
+
+	p1, err = ds.GetJobs(ctx, "", "running", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, p1.Size)
+	assert.Equal(t, 101, p1.TotalItems)
+
+	p1, err = ds.GetJobs(ctx, u1.Username, "running", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, p1.Size)
+	assert.Equal(t, 101, p1.TotalItems)
+
+	p1, err = ds.GetJobs(ctx, u2.Username, "running", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, p1.Size)
+	assert.Equal(t, 101, p1.TotalItems)
+
+	p1, err = ds.GetJobs(ctx, u3.Username, "running", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, p1.Size)
+	assert.Equal(t, 1, p1.TotalItems)
+
+}
+
+func TestSQLiteGetMetrics(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	s, err := ds.GetMetrics(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, s.Jobs.Running)
+	assert.Equal(t, 0, s.Tasks.Running)
+	assert.Equal(t, float64(0), s.Nodes.CPUPercent)
+	assert.Equal(t, 0, s.Nodes.Running)
+
+	now := time.Now().UTC()
+
+	jobIDs := []string{}
+
+	for i := 0; i < 100; i++ {
+		var state tork.JobState
+		if i%2 == 0 {
+			state = tork.JobStateRunning
+		} else {
+			state = tork.JobStatePending
+		}
+		jid := uuid.NewUUID()
+		err := ds.CreateJob(ctx, &tork.Job{
+			ID:        jid,
+			State:     state,
+			CreatedAt: now,
+		})
+		assert.NoError(t, err)
+		jobIDs = append(jobIDs, jid)
+	}
+
+	for i := 0; i < 100; i++ {
+		var state tork.TaskState
+		if i%2 == 0 {
+			state = tork.TaskStateRunning
+		} else {
+			state = tork.TaskStatePending
+		}
+		err := ds.CreateTask(ctx, &tork.Task{
+			ID:        uuid.NewUUID(),
+			JobID:     jobIDs[i],
+			State:     state,
+			CreatedAt: &now,
+		})
+		assert.NoError(t, err)
+	}
+
+	for i := 0; i < 10; i++ {
+		err := ds.CreateNode(ctx, &tork.Node{
+			ID:              uuid.NewUUID(),
+			LastHeartbeatAt: time.Now().UTC().Add(-time.Minute * time.Duration(i)),
+			CPUPercent:      float64(i * 10),
+		})
+		assert.NoError(t, err)
+	}
+
+	s, err = ds.GetMetrics(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 50, s.Jobs.Running)
+	assert.Equal(t, 50, s.Tasks.Running)
+	assert.Equal(t, float64(20), s.Nodes.CPUPercent)
+	assert.Equal(t, 5, s.Nodes.Running)
+}
+
+func TestSQLiteWithTxCreateTask(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		err = tx.CreateJob(ctx, &j1)
+		assert.NoError(t, err)
+		t1 := tork.Task{}
+		err = tx.CreateTask(ctx, &t1)
+		return err
+	})
+	assert.Error(t, err)
+
+	// job was created in a bad tx. should not exist
+	_, err = ds.GetJobByID(ctx, j1.ID)
+	assert.Error(t, err)
+}
+
+func TestSQLiteWithTxUpdateTask(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	t1 := tork.Task{
+		ID:        uuid.NewUUID(),
+		CreatedAt: &now,
+		State:     tork.TaskStateRunning,
+		JobID:     j1.ID,
+	}
+	err = ds.CreateTask(ctx, &t1)
+	assert.NoError(t, err)
+	err = ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		if err := tx.UpdateTask(ctx, t1.ID, func(u *tork.Task) error {
+			u.State = tork.TaskStateFailed
+			return nil
+		}); err != nil {
+			return err
+		}
+		return errors.New("something bad happened")
+	})
+	assert.Error(t, err)
+	t11, err := ds.GetTaskByID(ctx, t1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.TaskStateRunning, t11.State)
+}
+
+func TestSQLiteHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+
+	err = ds.HealthCheck(ctx)
+	assert.NoError(t, err)
+
+	_, err = ds.db.Exec("drop table nodes")
+	assert.NoError(t, err)
+
+	err = ds.HealthCheck(ctx)
+	assert.Error(t, err)
+}
+
+func TestSQLiteCreateAndGetTaskLogs(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	t1 := tork.Task{
+		ID:        uuid.NewUUID(),
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}
+	err = ds.CreateTask(ctx, &t1)
+	assert.NoError(t, err)
+
+	err = ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+		Number:   1,
+		TaskID:   t1.ID,
+		Contents: "line 1",
+	})
+	assert.NoError(t, err)
+
+	logs, err := ds.GetTaskLogParts(ctx, t1.ID, "", 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, logs.Items, 1)
+	assert.Equal(t, "line 1", logs.Items[0].Contents)
+	assert.NotEmpty(t, logs.Items[0].ID)
+}
+
+func TestSQLiteCreateAndGetTaskLogsMultiParts(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	t1 := tork.Task{
+		ID:        uuid.NewUUID(),
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}
+	err = ds.CreateTask(ctx, &t1)
+	assert.NoError(t, err)
+
+	parts := 10
+
+	wg := sync.WaitGroup{}
+	wg.Add(parts)
+
+	for i := 1; i <= parts; i++ {
+		go func(n int) {
+			defer wg.Done()
+			err := ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+				Number:   n,
+				TaskID:   t1.ID,
+				Contents: fmt.Sprintf("line %d", n),
+			})
+			assert.NoError(t, err)
+		}(i)
+	}
+
+	wg.Wait()
+
+	logs, err := ds.GetTaskLogParts(ctx, t1.ID, "", 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, logs.Items, 10)
+	assert.Equal(t, "line 10", logs.Items[0].Contents)
+	assert.Equal(t, "line 1", logs.Items[9].Contents)
+}
+
+func TestSQLiteCreateAndGetTaskLogsLarge(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	t1 := tork.Task{
+		ID:        uuid.NewUUID(),
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}
+	err = ds.CreateTask(ctx, &t1)
+	assert.NoError(t, err)
+
+	for i := 1; i <= 100; i++ {
+		err := ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+			Number:   i,
+			TaskID:   t1.ID,
+			Contents: fmt.Sprintf("line %d", i),
+		})
+		assert.NoError(t, err)
+	}
+
+	logs, err := ds.GetTaskLogParts(ctx, t1.ID, "", 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, logs.Items, 10)
+	assert.Equal(t, "line 100", logs.Items[0].Contents)
+	assert.Equal(t, "line 91", logs.Items[9].Contents)
+	assert.Equal(t, 10, logs.Size)
+	assert.Equal(t, 10, logs.TotalPages)
+}
+
+func TestSQLiteQueryTaskLogs(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	t1 := tork.Task{
+		ID:        uuid.NewUUID(),
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}
+	err = ds.CreateTask(ctx, &t1)
+	assert.NoError(t, err)
+
+	for i := 1; i <= 100; i++ {
+		err := ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+			Number:   i,
+			TaskID:   t1.ID,
+			Contents: fmt.Sprintf("line %d", i),
+		})
+		assert.NoError(t, err)
+	}
+
+	logs, err := ds.GetTaskLogParts(ctx, t1.ID, "line 91", 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, logs.Items, 1)
+	assert.Equal(t, "line 91", logs.Items[0].Contents)
+}
+
+func TestSQLiteCreateAndExpungeTaskLogs(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	t1 := tork.Task{
+		ID:        uuid.NewUUID(),
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}
+	err = ds.CreateTask(ctx, &t1)
+	assert.NoError(t, err)
+
+	for i := 1; i <= 100; i++ {
+		err := ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+			Number:   i,
+			TaskID:   t1.ID,
+			Contents: fmt.Sprintf("line %d", i),
+		})
+		assert.NoError(t, err)
+	}
+
+	n, err := ds.expungeExpiredTaskLogPart()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	logs, err := ds.GetTaskLogParts(ctx, t1.ID, "", 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, logs.TotalItems)
+
+	retentionPeriod := time.Microsecond
+	ds.taskLogsRetentionPeriod = &retentionPeriod
+
+	n, err = ds.expungeExpiredTaskLogPart()
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, n, 100)
+
+	logs, err = ds.GetTaskLogParts(ctx, t1.ID, "", 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, logs.TotalItems)
+}
+
+func Test_cleanup(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t, WithDisableCleanup(true))
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+
+	j2 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j2)
+	assert.NoError(t, err)
+
+	past := time.Now().UTC().Add(-time.Minute)
+	err = ds.UpdateJob(ctx, j2.ID, func(u *tork.Job) error {
+		u.DeleteAt = &past
+		return nil
+	})
+	assert.NoError(t, err)
+
+	j3 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j3)
+	assert.NoError(t, err)
+
+	t1 := tork.Task{
+		ID:        uuid.NewUUID(),
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}
+	err = ds.CreateTask(ctx, &t1)
+	assert.NoError(t, err)
+
+	for i := 1; i <= 100; i++ {
+		err := ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+			Number:   i,
+			TaskID:   t1.ID,
+			Contents: fmt.Sprintf("line %d", i),
+		})
+		assert.NoError(t, err)
+	}
+
+	err = ds.cleanup()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, *ds.cleanupInterval)
+
+	logs, err := ds.GetTaskLogParts(ctx, t1.ID, "", 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, logs.TotalItems)
+
+	retentionPeriod := time.Microsecond
+	ds.taskLogsRetentionPeriod = &retentionPeriod
+
+	err = ds.cleanup()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, *ds.cleanupInterval)
+
+	logs, err = ds.GetTaskLogParts(ctx, t1.ID, "", 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, logs.TotalItems)
+
+	_, err = ds.GetJobByID(ctx, j2.ID)
+	assert.Error(t, err)
+
+	_, err = ds.GetJobByID(ctx, j3.ID)
+	assert.NoError(t, err)
+}
+
+func TestSQLiteGetJobLogParts(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	t1 := tork.Task{
+		ID:        uuid.NewUUID(),
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}
+	err = ds.CreateTask(ctx, &t1)
+	assert.NoError(t, err)
+
+	err = ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+		Number:   1,
+		TaskID:   t1.ID,
+		Contents: "line 1",
+	})
+	assert.NoError(t, err)
+
+	logs, err := ds.GetJobLogParts(ctx, j1.ID, "", 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, logs.Items, 1)
+	assert.Equal(t, "line 1", logs.Items[0].Contents)
+}
+
+func TestSQLiteQueryJobLogParts(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	t1 := tork.Task{
+		ID:        uuid.NewUUID(),
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}
+	err = ds.CreateTask(ctx, &t1)
+	assert.NoError(t, err)
+
+	for i := 1; i <= 100; i++ {
+		err := ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+			Number:   i,
+			TaskID:   t1.ID,
+			Contents: fmt.Sprintf("line %d", i),
+		})
+		assert.NoError(t, err)
+	}
+
+	logs, err := ds.GetJobLogParts(ctx, j1.ID, "line 91", 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, logs.Items, 1)
+	assert.Equal(t, "line 91", logs.Items[0].Contents)
+}
+
+func TestSQLiteCreateRole(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	uid := uuid.NewUUID()
+	r := &tork.Role{
+		ID:        uid,
+		Slug:      "test-role-" + uuid.NewUUID(),
+		Name:      "Test Role",
+		CreatedAt: &now,
+	}
+	err = ds.CreateRole(ctx, r)
+	assert.NoError(t, err)
+
+	role, err := ds.GetRole(ctx, r.Slug)
+	assert.NoError(t, err)
+	assert.Equal(t, r.Slug, role.Slug)
+
+	roles, err := ds.GetRoles(ctx)
+	assert.NoError(t, err)
+	assert.Greater(t, len(roles), 0)
+	assert.Equal(t, "Public", roles[0].Name)
+
+	u := &tork.User{
+		ID:        uuid.NewUUID(),
+		Username:  uuid.NewShortUUID(),
+		Name:      "Tester",
+		CreatedAt: &now,
+	}
+	err = ds.CreateUser(ctx, u)
+	assert.NoError(t, err)
+
+	err = ds.AssignRole(ctx, u.ID, r.ID)
+	assert.NoError(t, err)
+
+	uroles, err := ds.GetUserRoles(ctx, u.ID)
+	assert.NoError(t, err)
+	assert.Len(t, uroles, 1)
+	assert.Equal(t, r.ID, uroles[0].ID)
+
+	err = ds.UnassignRole(ctx, u.ID, r.ID)
+	assert.NoError(t, err)
+
+	uroles, err = ds.GetUserRoles(ctx, u.ID)
+	assert.NoError(t, err)
+	assert.Len(t, uroles, 0)
+}
+
+func TestSQLiteDeleteRole(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	r := &tork.Role{
+		Slug: "test-role-" + uuid.NewUUID(),
+		Name: "Test Role",
+	}
+	err = ds.CreateRole(ctx, r)
+	assert.NoError(t, err)
+
+	u := &tork.User{
+		Username: uuid.NewShortUUID(),
+		Name:     "Tester",
+	}
+	err = ds.CreateUser(ctx, u)
+	assert.NoError(t, err)
+
+	err = ds.AssignRole(ctx, u.ID, r.ID)
+	assert.NoError(t, err)
+
+	err = ds.DeleteRole(ctx, r.ID)
+	assert.NoError(t, err)
+
+	_, err = ds.GetRole(ctx, r.ID)
+	assert.ErrorIs(t, err, datastore.ErrRoleNotFound)
+
+	uroles, err := ds.GetUserRoles(ctx, u.ID)
+	assert.NoError(t, err)
+	assert.Len(t, uroles, 0)
+
+	err = ds.DeleteRole(ctx, r.ID)
+	assert.ErrorIs(t, err, datastore.ErrRoleNotFound)
+}
+
+func TestSQLiteGetUsers(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		err := ds.CreateUser(ctx, &tork.User{
+			Username: uuid.NewShortUUID(),
+		})
+		assert.NoError(t, err)
+	}
+	p1, err := ds.GetUsers(ctx, 1, 2)
+	assert.NoError(t, err)
+	assert.Len(t, p1.Items, 2)
+	assert.GreaterOrEqual(t, p1.TotalItems, 4)
+}
+
+func TestSQLiteUpdateUser(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	u := &tork.User{
+		Username: uuid.NewShortUUID(),
+		Name:     "Tester",
+	}
+	err = ds.CreateUser(ctx, u)
+	assert.NoError(t, err)
+
+	err = ds.UpdateUser(ctx, u.ID, func(u *tork.User) error {
+		u.Disabled = true
+		u.PasswordHash = "some-hash"
+		return nil
+	})
+	assert.NoError(t, err)
+
+	u2, err := ds.GetUser(ctx, u.Username)
+	assert.NoError(t, err)
+	assert.True(t, u2.Disabled)
+	assert.Equal(t, "some-hash", u2.PasswordHash)
+
+	err = ds.UpdateUser(ctx, uuid.NewUUID(), func(u *tork.User) error {
+		return nil
+	})
+	assert.ErrorIs(t, err, datastore.ErrUserNotFound)
+}
+
+func TestSQLiteDeleteUser(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	u := &tork.User{
+		Username: uuid.NewShortUUID(),
+	}
+	err = ds.CreateUser(ctx, u)
+	assert.NoError(t, err)
+
+	err = ds.DeleteUser(ctx, u.ID)
+	assert.NoError(t, err)
+
+	_, err = ds.GetUser(ctx, u.ID)
+	assert.ErrorIs(t, err, datastore.ErrUserNotFound)
+
+	err = ds.DeleteUser(ctx, u.ID)
+	assert.ErrorIs(t, err, datastore.ErrUserNotFound)
+
+	// users who created jobs can't be deleted
+	u2 := &tork.User{
+		Username: uuid.NewShortUUID(),
+	}
+	err = ds.CreateUser(ctx, u2)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	err = ds.CreateJob(ctx, &tork.Job{
+		ID:        uuid.NewUUID(),
+		CreatedAt: now,
+		CreatedBy: u2,
+		Context:   tork.JobContext{},
+	})
+	assert.NoError(t, err)
+	err = ds.DeleteUser(ctx, u2.ID)
+	assert.ErrorIs(t, err, datastore.ErrUserInUse)
+}
+
+func TestSQLiteGetNextTask(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+
+	j1 := tork.Job{
+		ID:        uuid.NewUUID(),
+		CreatedAt: time.Now().UTC(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+
+	parentTaskID := uuid.NewUUID()
+	childTaskID := uuid.NewUUID()
+
+	tasks := []*tork.Task{{
+		ID:        parentTaskID,
+		State:     tork.TaskStatePending,
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}, {
+		ID:        childTaskID,
+		ParentID:  parentTaskID,
+		State:     tork.TaskStateCreated,
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}, {
+		ID:        uuid.NewUUID(),
+		State:     tork.TaskStateCreated,
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}, {
+		ID:        uuid.NewUUID(),
+		State:     tork.TaskStateCreated,
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}}
+
+	for _, ta := range tasks {
+		err := ds.CreateTask(ctx, ta)
+		assert.NoError(t, err)
+	}
+	nt, err := ds.GetNextTask(ctx, parentTaskID)
+	assert.NoError(t, err)
+	assert.Equal(t, childTaskID, nt.ID)
+
+	_, err = ds.GetNextTask(ctx, childTaskID)
+	assert.Error(t, err)
+}
+
+func TestSQLiteGetJobsByConcurrencyKey(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	key := uuid.NewShortUUID()
+	states := []tork.JobState{
+		tork.JobStateRunning,
+		tork.JobStatePending,
+		tork.JobStateCompleted,
+	}
+	for i, state := range states {
+		err := ds.CreateJob(ctx, &tork.Job{
+			ID:          uuid.NewUUID(),
+			State:       state,
+			CreatedAt:   now.Add(time.Duration(i) * time.Second),
+			Concurrency: &tork.JobConcurrency{Key: key, Limit: 1},
+		})
+		assert.NoError(t, err)
+	}
+
+	err = ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		err := tx.LockConcurrencyKey(ctx, key)
+		assert.NoError(t, err)
+		jobs, err := tx.GetJobsByConcurrencyKey(ctx, key)
+		assert.NoError(t, err)
+		assert.Len(t, jobs, 2)
+		assert.Equal(t, tork.JobStateRunning, jobs[0].State)
+		assert.Equal(t, tork.JobStatePending, jobs[1].State)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	j, err := ds.GetJobsByConcurrencyKey(ctx, "no-such-key")
+	assert.NoError(t, err)
+	assert.Len(t, j, 0)
+}
+
+func TestSQLiteGetJobByIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	key := uuid.NewUUID()
+	j1 := &tork.Job{
+		ID:             uuid.NewUUID(),
+		State:          tork.JobStatePending,
+		CreatedAt:      time.Now().UTC(),
+		IdempotencyKey: key,
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	j2, err := ds.GetJobByIdempotencyKey(ctx, tork.USER_GUEST, key)
+	assert.NoError(t, err)
+	assert.Equal(t, j1.ID, j2.ID)
+	assert.Equal(t, key, j2.IdempotencyKey)
+
+	_, err = ds.GetJobByIdempotencyKey(ctx, tork.USER_GUEST, "no-such-key")
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+
+	// the key is taken
+	err = ds.CreateJob(ctx, &tork.Job{
+		ID:             uuid.NewUUID(),
+		State:          tork.JobStatePending,
+		CreatedAt:      time.Now().UTC(),
+		IdempotencyKey: key,
+	})
+	assert.ErrorIs(t, err, datastore.ErrDuplicateIdempotencyKey)
+
+	// release the key
+	err = ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+		u.IdempotencyKey = ""
+		return nil
+	})
+	assert.NoError(t, err)
+
+	j3 := &tork.Job{
+		ID:             uuid.NewUUID(),
+		State:          tork.JobStatePending,
+		CreatedAt:      time.Now().UTC(),
+		IdempotencyKey: key,
+	}
+	err = ds.CreateJob(ctx, j3)
+	assert.NoError(t, err)
+
+	j2, err = ds.GetJobByIdempotencyKey(ctx, tork.USER_GUEST, key)
+	assert.NoError(t, err)
+	assert.Equal(t, j3.ID, j2.ID)
+
+	// keys are scoped to the user who submitted the job
+	u1 := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: uuid.NewShortUUID(),
+		Name:     "Tester",
+	}
+	err = ds.CreateUser(ctx, u1)
+	assert.NoError(t, err)
+
+	_, err = ds.GetJobByIdempotencyKey(ctx, u1.Username, key)
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+
+	j4 := &tork.Job{
+		ID:             uuid.NewUUID(),
+		State:          tork.JobStatePending,
+		CreatedAt:      time.Now().UTC(),
+		CreatedBy:      u1,
+		IdempotencyKey: key,
+	}
+	err = ds.CreateJob(ctx, j4)
+	assert.NoError(t, err)
+
+	j2, err = ds.GetJobByIdempotencyKey(ctx, u1.Username, key)
+	assert.NoError(t, err)
+	assert.Equal(t, j4.ID, j2.ID)
+
+	j2, err = ds.GetJobByIdempotencyKey(ctx, tork.USER_GUEST, key)
+	assert.NoError(t, err)
+	assert.Equal(t, j3.ID, j2.ID)
+}
+
+func TestSQLiteScheduledJobs(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	next := now.Add(time.Minute)
+	sj := &tork.ScheduledJob{
+		ID:        uuid.NewUUID(),
+		Name:      "test scheduled job",
+		Cron:      "* * * * *",
+		State:     tork.ScheduledJobStateActive,
+		CreatedAt: now,
+		NextRunAt: &next,
+		Secrets:   map[string]string{"key": "secret"},
+		InputTypes: map[string]tork.InputType{
+			"replicas": tork.InputTypeInt,
+		},
+		Tasks: []*tork.Task{{
+			Name: "task-1",
+		}},
+	}
+	err = ds.CreateScheduledJob(ctx, sj)
+	assert.NoError(t, err)
+
+	sj2, err := ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, sj.Name, sj2.Name)
+	assert.Equal(t, "secret", sj2.Secrets["key"])
+	assert.Equal(t, tork.InputTypeInt, sj2.InputTypes["replicas"])
+	assert.Equal(t, tork.USER_GUEST, sj2.CreatedBy.Username)
+	assert.Len(t, sj2.Tasks, 1)
+
+	p, err := ds.GetScheduledJobs(ctx, 1, 10)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, p.TotalItems, 1)
+
+	err = ds.UpdateScheduledJob(ctx, sj.ID, func(u *tork.ScheduledJob) error {
+		u.State = tork.ScheduledJobStatePaused
+		u.LastRunAt = &now
+		return nil
+	})
+	assert.NoError(t, err)
+
+	active, err := ds.GetActiveScheduledJobs(ctx)
+	assert.NoError(t, err)
+	for _, a := range active {
+		assert.NotEqual(t, sj.ID, a.ID)
+	}
+
+	sj2, err = ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.ScheduledJobStatePaused, sj2.State)
+	assert.NotNil(t, sj2.LastRunAt)
+
+	err = ds.DeleteScheduledJob(ctx, sj.ID)
+	assert.NoError(t, err)
+
+	_, err = ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.ErrorIs(t, err, datastore.ErrScheduledJobNotFound)
+}
+
+func TestSQLiteGetReadyTasks(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	j1 := &tork.Job{
+		ID: uuid.NewUUID(),
+		Tasks: []*tork.Task{
+			{Name: "task-a", Var: "a"},
+			{Name: "task-b", Var: "b", DependsOn: []string{"a"}},
+			{Name: "task-c", Var: "c"},
+		},
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	ready, err := ds.GetReadyTasks(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Len(t, ready, 2)
+	assert.Equal(t, "task-a", ready[0].Name)
+	assert.Equal(t, 1, ready[0].Position)
+	assert.Equal(t, "task-c", ready[1].Name)
+	assert.Equal(t, 3, ready[1].Position)
+
+	now := time.Now().UTC()
+	err = ds.CreateTask(ctx, &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		Position:  1,
+		Var:       "a",
+		State:     tork.TaskStateCompleted,
+		CreatedAt: &now,
+	})
+	assert.NoError(t, err)
+
+	ready, err = ds.GetReadyTasks(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Len(t, ready, 2)
+	assert.Equal(t, "task-b", ready[0].Name)
+	assert.Equal(t, []string{"a"}, ready[0].DependsOn)
+	assert.Equal(t, "task-c", ready[1].Name)
+}
+
+func TestSQLiteGetDelayedTasks(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	notBefore := now.Add(time.Minute)
+	t1 := tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateCreated,
+		CreatedAt: &now,
+		NotBefore: &notBefore,
+		Retry: &tork.TaskRetry{
+			Limit:        2,
+			Attempts:     1,
+			InitialDelay: "1m",
+			RetryOn:      []string{"137"},
+		},
+	}
+	err = ds.CreateTask(ctx, &t1)
+	assert.NoError(t, err)
+
+	delayed, err := ds.GetDelayedTasks(ctx, now)
+	assert.NoError(t, err)
+	for _, d := range delayed {
+		assert.NotEqual(t, t1.ID, d.ID)
+	}
+
+	delayed, err = ds.GetDelayedTasks(ctx, notBefore)
+	assert.NoError(t, err)
+	found := false
+	for _, d := range delayed {
+		if d.ID == t1.ID {
+			found = true
+			assert.Equal(t, "1m", d.Retry.InitialDelay)
+			assert.Equal(t, []string{"137"}, d.Retry.RetryOn)
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestSQLiteRetentionPolicy(t *testing.T) {
+	ctx := context.Background()
+	keepTag := uuid.NewUUID()
+	expireTag := uuid.NewUUID()
+	keep, err := datastore.NewRetentionRule("", keepTag, "forever")
+	assert.NoError(t, err)
+	failed, err := datastore.NewRetentionRule("FAILED", expireTag, "30d")
+	assert.NoError(t, err)
+	completed, err := datastore.NewRetentionRule("COMPLETED", expireTag, "7d")
+	assert.NoError(t, err)
+	ds, err := newTestDatastore(t, WithDisableCleanup(true), WithRetentionPolicy(datastore.RetentionPolicy{keep, failed, completed}))
+	assert.NoError(t, err)
+
+	createJob := func(state tork.JobState, age time.Duration, tags ...string) *tork.Job {
+		j := &tork.Job{
+			ID:        uuid.NewUUID(),
+			CreatedAt: time.Now().UTC().Add(-age),
+			Tags:      tags,
+		}
+		err := ds.CreateJob(ctx, j)
+		assert.NoError(t, err)
+		finishedAt := time.Now().UTC().Add(-age)
+		err = ds.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
+			u.State = state
+			switch state {
+			case tork.JobStateCompleted:
+				u.CompletedAt = &finishedAt
+			case tork.JobStateFailed:
+				u.FailedAt = &finishedAt
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		return j
+	}
+
+	oldFailed := createJob(tork.JobStateFailed, time.Hour*24*40, expireTag)
+	newFailed := createJob(tork.JobStateFailed, time.Hour*24*10, expireTag)
+	oldCompleted := createJob(tork.JobStateCompleted, time.Hour*24*10, expireTag)
+	audited := createJob(tork.JobStateCompleted, time.Hour*24*10, expireTag, keepTag)
+	running := createJob(tork.JobStateRunning, time.Hour*24*40, expireTag)
+
+	p, err := ds.GetExpiredJobs(ctx, "", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, p.TotalItems)
+	assert.Equal(t, oldFailed.ID, p.Items[0].ID)
+	assert.Equal(t, oldCompleted.ID, p.Items[1].ID)
+
+	n, err := ds.expungeExpiredJobs()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	_, err = ds.GetJobByID(ctx, oldFailed.ID)
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+	_, err = ds.GetJobByID(ctx, oldCompleted.ID)
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+	for _, j := range []*tork.Job{newFailed, audited, running} {
+		_, err = ds.GetJobByID(ctx, j.ID)
+		assert.NoError(t, err)
+	}
+
+	p, err = ds.GetExpiredJobs(ctx, "", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, p.TotalItems)
+}
+
+func TestSQLiteArchiveExpiredJobs(t *testing.T) {
+	ctx := context.Background()
+	sink, err := local.NewLocalSink(t.TempDir())
+	assert.NoError(t, err)
+	ds, err := newTestDatastore(t, WithDisableCleanup(true), WithArchive(sink))
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+	j1 := tork.Job{
+		ID:    uuid.NewUUID(),
+		Name:  "test job",
+		State: tork.JobStateCompleted,
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+
+	t1 := tork.Task{
+		ID:        uuid.NewUUID(),
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}
+	err = ds.CreateTask(ctx, &t1)
+	assert.NoError(t, err)
+
+	err = ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+		Number:   1,
+		TaskID:   t1.ID,
+		Contents: "hello world",
+	})
+	assert.NoError(t, err)
+
+	past := now.Add(-time.Minute)
+	err = ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+		u.DeleteAt = &past
+		return nil
+	})
+	assert.NoError(t, err)
+
+	n, err := ds.expungeExpiredJobs()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = ds.GetJobByID(ctx, j1.ID)
+	assert.ErrorIs(t, err, datastore.ErrJobNotFound)
+
+	b, err := archive.Read(ctx, sink, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "test job", b.Job.Name)
+	assert.Len(t, b.Job.Execution, 1)
+	assert.Equal(t, t1.ID, b.Job.Execution[0].ID)
+	assert.Len(t, b.Logs, 1)
+	assert.Equal(t, "hello world", b.Logs[0].Contents)
+}
+
+func TestSQLiteJobTemplates(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+
+	u1 := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: uuid.NewShortUUID(),
+		Name:     "Tester",
+	}
+	err = ds.CreateUser(ctx, u1)
+	assert.NoError(t, err)
+
+	u2 := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: uuid.NewShortUUID(),
+		Name:     "Tester",
+	}
+	err = ds.CreateUser(ctx, u2)
+	assert.NoError(t, err)
+
+	r := &tork.Role{
+		Slug: uuid.NewShortUUID(),
+		Name: "Test Role",
+	}
+	err = ds.CreateRole(ctx, r)
+	assert.NoError(t, err)
+
+	err = ds.AssignRole(ctx, u2.ID, r.ID)
+	assert.NoError(t, err)
+
+	name := uuid.NewShortUUID()
+	for i := 0; i < 2; i++ {
+		tk := &tork.JobTemplate{
+			ID:          uuid.NewUUID(),
+			Name:        name,
+			Description: fmt.Sprintf("version %d", i+1),
+			CreatedAt:   time.Now().UTC(),
+			CreatedBy:   u1,
+			Job:         []byte(`{"name":"test job"}`),
+		}
+		err = ds.CreateJobTemplate(ctx, tk)
+		assert.NoError(t, err)
+		assert.Equal(t, i+1, tk.Version)
+	}
+
+	private := &tork.JobTemplate{
+		ID:          uuid.NewUUID(),
+		Name:        uuid.NewShortUUID(),
+		CreatedAt:   time.Now().UTC(),
+		CreatedBy:   u1,
+		Permissions: []*tork.Permission{{Role: r}},
+		Job:         []byte(`{"name":"private job"}`),
+	}
+	err = ds.CreateJobTemplate(ctx, private)
+	assert.NoError(t, err)
+
+	latest, err := ds.GetJobTemplate(ctx, name, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, latest.Version)
+	assert.Equal(t, "version 2", latest.Description)
+	assert.Equal(t, u1.Username, latest.CreatedBy.Username)
+	assert.JSONEq(t, `{"name":"test job"}`, string(latest.Job))
+
+	v1, err := ds.GetJobTemplate(ctx, name, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "version 1", v1.Description)
+
+	_, err = ds.GetJobTemplate(ctx, name, 3)
+	assert.ErrorIs(t, err, datastore.ErrJobTemplateNotFound)
+
+	p, err := ds.GetJobTemplates(ctx, u2.Username, 1, 100)
+	assert.NoError(t, err)
+	refs := make([]string, 0)
+	for _, item := range p.Items {
+		refs = append(refs, item.Ref())
+	}
+	assert.Contains(t, refs, name+"@2")
+	assert.NotContains(t, refs, name+"@1")
+	assert.Contains(t, refs, private.Ref())
+
+	p, err = ds.GetJobTemplates(ctx, u1.Username, 1, 100)
+	assert.NoError(t, err)
+	refs = make([]string, 0)
+	for _, item := range p.Items {
+		refs = append(refs, item.Ref())
+	}
+	assert.Contains(t, refs, name+"@2")
+	assert.NotContains(t, refs, private.Ref())
+
+	err = ds.DeleteJobTemplate(ctx, name, 2)
+	assert.NoError(t, err)
+
+	latest, err = ds.GetJobTemplate(ctx, name, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, latest.Version)
+
+	err = ds.DeleteJobTemplate(ctx, name, 0)
+	assert.NoError(t, err)
+
+	_, err = ds.GetJobTemplate(ctx, name, 0)
+	assert.ErrorIs(t, err, datastore.ErrJobTemplateNotFound)
+
+	err = ds.DeleteJobTemplate(ctx, name, 0)
+	assert.ErrorIs(t, err, datastore.ErrJobTemplateNotFound)
+}
+
+func TestSQLiteLibraryTasks(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+
+	name := uuid.NewShortUUID()
+	for i := 0; i < 2; i++ {
+		lt := &tork.LibraryTask{
+			ID:          uuid.NewUUID(),
+			Name:        name,
+			Description: fmt.Sprintf("version %d", i+1),
+			CreatedAt:   time.Now().UTC(),
+			Params:      []byte(`{"path":{"required":true}}`),
+			Task:        []byte(`{"name":"lint","image":"golangci/golangci-lint"}`),
+		}
+		err = ds.CreateLibraryTask(ctx, lt)
+		assert.NoError(t, err)
+		assert.Equal(t, i+1, lt.Version)
+	}
+
+	latest, err := ds.GetLibraryTask(ctx, name, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, latest.Version)
+	assert.Equal(t, "version 2", latest.Description)
+	assert.NotNil(t, latest.CreatedBy)
+	assert.JSONEq(t, `{"path":{"required":true}}`, string(latest.Params))
+	assert.JSONEq(t, `{"name":"lint","image":"golangci/golangci-lint"}`, string(latest.Task))
+
+	v1, err := ds.GetLibraryTask(ctx, name, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "version 1", v1.Description)
+
+	_, err = ds.GetLibraryTask(ctx, name, 3)
+	assert.ErrorIs(t, err, datastore.ErrLibraryTaskNotFound)
+
+	p, err := ds.GetLibraryTasks(ctx, 1, 100)
+	assert.NoError(t, err)
+	refs := make([]string, 0)
+	for _, item := range p.Items {
+		refs = append(refs, item.Ref())
+	}
+	assert.Contains(t, refs, name+"@2")
+	assert.NotContains(t, refs, name+"@1")
+
+	err = ds.DeleteLibraryTask(ctx, name, 2)
+	assert.NoError(t, err)
+
+	latest, err = ds.GetLibraryTask(ctx, name, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, latest.Version)
+
+	err = ds.DeleteLibraryTask(ctx, name, 0)
+	assert.NoError(t, err)
+
+	_, err = ds.GetLibraryTask(ctx, name, 0)
+	assert.ErrorIs(t, err, datastore.ErrLibraryTaskNotFound)
+
+	err = ds.DeleteLibraryTask(ctx, name, 0)
+	assert.ErrorIs(t, err, datastore.ErrLibraryTaskNotFound)
+}
+
+func TestSQLiteGetOrphanedTasks(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t)
+	assert.NoError(t, err)
+	now := time.Now().UTC()
+	up := &tork.Node{
+		ID:              uuid.NewUUID(),
+		LastHeartbeatAt: now,
+	}
+	err = ds.CreateNode(ctx, up)
+	assert.NoError(t, err)
+	down := &tork.Node{
+		ID:              uuid.NewUUID(),
+		LastHeartbeatAt: now.Add(-time.Minute * 10),
+	}
+	err = ds.CreateNode(ctx, down)
+	assert.NoError(t, err)
+	j1 := tork.Job{
+		ID: uuid.NewUUID(),
+	}
+	err = ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+	longAgo := now.Add(-time.Hour * 2)
+	running := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateRunning,
+		CreatedAt: &now,
+		NodeID:    up.ID,
+	}
+	orphaned := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateRunning,
+		CreatedAt: &now,
+		NodeID:    down.ID,
+	}
+	unknown := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateRunning,
+		CreatedAt: &now,
+		NodeID:    uuid.NewUUID(),
+	}
+	completed := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateCompleted,
+		CreatedAt: &now,
+		NodeID:    down.ID,
+	}
+	scheduled := &tork.Task{
+		ID:          uuid.NewUUID(),
+		JobID:       j1.ID,
+		State:       tork.TaskStateScheduled,
+		CreatedAt:   &longAgo,
+		ScheduledAt: &longAgo,
+	}
+	for _, tk := range []*tork.Task{running, orphaned, unknown, completed, scheduled} {
+		err = ds.CreateTask(ctx, tk)
+		assert.NoError(t, err)
+	}
+	ids := func(ts []*tork.Task) []string {
+		result := make([]string, 0)
+		for _, tk := range ts {
+			if tk.JobID == j1.ID {
+				result = append(result, tk.ID)
+			}
+		}
+		return result
+	}
+
+	ts, err := ds.GetOrphanedTasks(ctx, now.Add(-time.Minute), time.Time{})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{orphaned.ID, unknown.ID}, ids(ts))
+
+	ts, err = ds.GetOrphanedTasks(ctx, now.Add(-time.Minute), now.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{orphaned.ID, unknown.ID, scheduled.ID}, ids(ts))
+}
+
+func TestSQLiteGetExpiredJobsWithPerms(t *testing.T) {
+	ctx := context.Background()
+	ds, err := newTestDatastore(t, WithDisableCleanup(true))
+	assert.NoError(t, err)
+
+	owner := &tork.User{
+		Username: uuid.NewShortUUID(),
+		Name:     "Owner",
+	}
+	err = ds.CreateUser(ctx, owner)
+	assert.NoError(t, err)
+	other := &tork.User{
+		Username: uuid.NewShortUUID(),
+	}
+	err = ds.CreateUser(ctx, other)
+	assert.NoError(t, err)
+
+	past := time.Now().UTC().Add(-time.Hour)
+	private := &tork.Job{
+		ID:          uuid.NewUUID(),
+		State:       tork.JobStateCompleted,
+		CreatedBy:   owner,
+		Permissions: []*tork.Permission{{User: owner}},
+	}
+	err = ds.CreateJob(ctx, private)
+	assert.NoError(t, err)
+	public := &tork.Job{
+		ID:        uuid.NewUUID(),
+		State:     tork.JobStateCompleted,
+		CreatedBy: owner,
+	}
+	err = ds.CreateJob(ctx, public)
+	assert.NoError(t, err)
+	for _, j := range []*tork.Job{private, public} {
+		err = ds.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
+			u.DeleteAt = &past
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+
+	p, err := ds.GetExpiredJobs(ctx, owner.Username, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, p.TotalItems)
+	assert.Equal(t, owner.Username, p.Items[0].CreatedBy.Username)
+	assert.Equal(t, "Owner", p.Items[0].CreatedBy.Name)
+
+	p, err = ds.GetExpiredJobs(ctx, other.Username, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, p.TotalItems)
+	assert.Equal(t, public.ID, p.Items[0].ID)
+}
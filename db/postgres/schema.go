@@ -12,7 +12,13 @@ CREATE TABLE nodes (
     hostname           varchar(128) not null,
     port               int          not null,
     task_count         int          not null,
-    version_           varchar(32)  not null
+    version_           varchar(32)  not null,
+    cpus               float        not null default 0,
+    memory             bigint       not null default 0,
+    allocated_cpus     float        not null default 0,
+    allocated_memory   bigint       not null default 0,
+    labels             jsonb,
+    queues             text[]
 );
 
 CREATE INDEX idx_nodes_heartbeat ON nodes (last_heartbeat_at);
@@ -59,6 +65,7 @@ CREATE TABLE jobs (
     completed_at  timestamp,
     delete_at     timestamp,
     failed_at     timestamp,
+    paused_at     timestamp,
     tasks         jsonb       not null,
     position      int         not null,
     inputs        jsonb       not null,
@@ -73,11 +80,19 @@ CREATE TABLE jobs (
     webhooks      jsonb,
     auto_delete   jsonb,
     secrets       jsonb,
-    progress      numeric(5,2) default 0
+    progress      numeric(5,2) default 0,
+    schedule      jsonb,
+    concurrency   jsonb,
+    idempotency_key varchar(256),
+    rerun         jsonb
 );
 
 CREATE INDEX idx_jobs_state ON jobs (state);
 
+CREATE UNIQUE INDEX idx_jobs_idempotency_key ON jobs (created_by,idempotency_key) WHERE idempotency_key IS NOT NULL;
+
+CREATE INDEX idx_jobs_concurrency_key ON jobs ((concurrency->>'key'),state);
+
 
 CREATE INDEX idx_jobs_created_at ON jobs (created_at);
 
@@ -102,6 +117,59 @@ CREATE TABLE jobs_perms (
 CREATE INDEX jobs_perms_job_id_idx ON jobs_perms (job_id);
 CREATE INDEX jobs_perms_user_role_idx ON jobs_perms (user_id,role_id);
 
+CREATE TABLE scheduled_jobs (
+    id            varchar(32) not null primary key,
+    name          varchar(256),
+    description   text,
+    tags          text[]      not null default '{}',
+    cron_expr     varchar(64) not null,
+    state         varchar(10) not null,
+    created_at    timestamp   not null,
+    created_by    varchar(32) not null references users(id),
+    last_run_at   timestamp,
+    next_run_at   timestamp,
+    tasks         jsonb       not null,
+    inputs        jsonb       not null,
+    secrets       jsonb,
+    output_       text,
+    defaults      jsonb,
+    webhooks      jsonb,
+    permissions   jsonb,
+    auto_delete   jsonb,
+    concurrency   jsonb,
+    input_types   jsonb,
+    input_schema  jsonb
+);
+
+CREATE INDEX idx_scheduled_jobs_state_next_run_at ON scheduled_jobs (state,next_run_at);
+CREATE INDEX idx_scheduled_jobs_created_at ON scheduled_jobs (created_at);
+
+CREATE TABLE job_templates (
+    id          varchar(32)  not null primary key,
+    name        varchar(64)  not null,
+    version     int          not null,
+    description text,
+    created_at  timestamp    not null,
+    created_by  varchar(32)  not null references users(id),
+    permissions jsonb,
+    job         jsonb        not null
+);
+
+CREATE UNIQUE INDEX idx_job_templates_name_version ON job_templates (name,version);
+
+CREATE TABLE library_tasks (
+    id          varchar(32)  not null primary key,
+    name        varchar(64)  not null,
+    version     int          not null,
+    description text,
+    created_at  timestamp    not null,
+    created_by  varchar(32)  not null references users(id),
+    params      jsonb,
+    task        jsonb        not null
+);
+
+CREATE UNIQUE INDEX idx_library_tasks_name_version ON library_tasks (name,version);
+
 CREATE TABLE tasks (
     id            varchar(32) not null primary key,
     job_id        varchar(32) not null references jobs(id),
@@ -143,7 +211,12 @@ CREATE TABLE tasks (
     priority      int,
     workdir       varchar(256),
     progress      numeric(5,2) default 0,
-    ports         jsonb
+    ports         jsonb,
+    depends_on    text[],
+    not_before    timestamp,
+    artifacts     jsonb,
+    node_selector jsonb,
+    affinity      jsonb
 );
 
 CREATE INDEX idx_tasks_state ON tasks (state);
@@ -166,4 +239,45 @@ ALTER TABLE tasks_log_parts ADD COLUMN ts tsvector NOT NULL
 CREATE INDEX tasks_log_parts_ts_idx ON tasks_log_parts USING GIN (ts);
 CREATE INDEX idx_tasks_log_parts_task_id ON tasks_log_parts (task_id);
 CREATE INDEX idx_tasks_log_parts_created_at ON tasks_log_parts (created_at);
+
+CREATE TABLE mq_messages (
+    id           bigserial    not null primary key,
+    queue_name   varchar(256) not null,
+    priority     int          not null default 0,
+    msg_type     varchar(64)  not null,
+    body         bytea        not null,
+    created_at   timestamp    not null,
+    expires_at   timestamp,
+    leased_by    varchar(32),
+    leased_until timestamp
+);
+
+CREATE INDEX idx_mq_messages_queue ON mq_messages (queue_name, priority desc, id);
+CREATE INDEX idx_mq_messages_expires_at ON mq_messages (expires_at);
+
+CREATE TABLE mq_subscribers (
+    id                varchar(32)  not null primary key,
+    broker_id         varchar(32)  not null,
+    queue_name        varchar(256) not null,
+    last_heartbeat_at timestamp    not null
+);
+
+CREATE INDEX idx_mq_subscribers_queue ON mq_subscribers (queue_name);
+CREATE INDEX idx_mq_subscribers_heartbeat ON mq_subscribers (last_heartbeat_at);
+
+CREATE TABLE mq_events (
+    id         bigserial    not null primary key,
+    topic      varchar(256) not null,
+    msg_type   varchar(64)  not null,
+    body       bytea        not null,
+    created_at timestamp    not null
+);
+
+CREATE INDEX idx_mq_events_created_at ON mq_events (created_at);
+
+CREATE TABLE leaders (
+    name       varchar(64) not null primary key,
+    leader_id  varchar(32) not null,
+    renewed_at timestamp   not null
+);
 `
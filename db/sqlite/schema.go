@@ -0,0 +1,259 @@
+package sqlite
+
+const SCHEMA = `
+CREATE TABLE nodes (
+    id                 varchar(32)  not null primary key,
+    name               varchar(64)  not null,
+    queue              varchar(64)  not null,
+    started_at         timestamp    not null,
+    last_heartbeat_at  timestamp    not null,
+    cpu_percent        real         not null,
+    status             varchar(10)  not null,
+    hostname           varchar(128) not null,
+    port               integer      not null,
+    task_count         integer      not null,
+    version_           varchar(32)  not null,
+    cpus               real         not null default 0,
+    memory             integer      not null default 0,
+    allocated_cpus     real         not null default 0,
+    allocated_memory   integer      not null default 0,
+    labels             text,
+    queues             text
+);
+
+CREATE INDEX idx_nodes_heartbeat ON nodes (last_heartbeat_at);
+
+CREATE TABLE users (
+    id          varchar(32)  not null primary key,
+    name        varchar(64)  not null,
+    username_   varchar(64)  not null unique,
+    password_   varchar(256) not null,
+    created_at  timestamp    not null,
+    is_disabled boolean      not null default 0
+);
+
+insert into users (id,name,username_,password_,created_at,is_disabled) values (lower(hex(randomblob(16))),'Guest','guest','',strftime('%Y-%m-%d %H:%M:%f+00:00','now'),1);
+
+CREATE TABLE roles (
+    id          varchar(32)  not null primary key,
+    name        varchar(64)  not null,
+    slug        varchar(64)  not null unique,
+    created_at  timestamp    not null
+);
+
+insert into roles (id,name,slug,created_at) values (lower(hex(randomblob(16))),'Public','public',strftime('%Y-%m-%d %H:%M:%f+00:00','now'));
+
+CREATE TABLE users_roles (
+    id         varchar(32) not null primary key,
+    user_id    varchar(32) not null references users(id),
+    role_id    varchar(32) not null references roles(id),
+    created_at timestamp   not null
+);
+
+CREATE UNIQUE INDEX idx_users_roles_uniq ON users_roles (user_id,role_id);
+
+CREATE TABLE jobs (
+    seq           integer     primary key,
+    id            varchar(32) not null unique,
+    name          varchar(256),
+    tags          text        not null default '[]',
+    state         varchar(10) not null,
+    created_at    timestamp   not null,
+    created_by    varchar(32) not null references users(id),
+    started_at    timestamp,
+    completed_at  timestamp,
+    delete_at     timestamp,
+    failed_at     timestamp,
+    paused_at     timestamp,
+    tasks         text        not null,
+    position      integer     not null,
+    inputs        text        not null,
+    context       text        not null,
+    description   text,
+    parent_id     varchar(32),
+    task_count    integer     not null,
+    output_       text,
+    result        text,
+    error_        text,
+    defaults      text,
+    webhooks      text,
+    auto_delete   text,
+    secrets       text,
+    progress      real        default 0,
+    schedule      text,
+    concurrency   text,
+    idempotency_key varchar(256),
+    rerun         text
+);
+
+CREATE INDEX idx_jobs_state ON jobs (state);
+CREATE UNIQUE INDEX idx_jobs_idempotency_key ON jobs (created_by,idempotency_key) WHERE idempotency_key IS NOT NULL;
+CREATE INDEX idx_jobs_concurrency_key ON jobs (json_extract(concurrency,'$.key'),state);
+CREATE INDEX idx_jobs_created_at ON jobs (created_at);
+CREATE INDEX idx_jobs_delete_at ON jobs (delete_at);
+
+CREATE VIRTUAL TABLE jobs_fts USING fts5 (
+    description,
+    name,
+    state,
+    content='jobs',
+    content_rowid='seq',
+    tokenize='porter unicode61'
+);
+
+CREATE TRIGGER jobs_fts_insert AFTER INSERT ON jobs BEGIN
+    INSERT INTO jobs_fts (rowid,description,name,state) VALUES (new.seq,new.description,new.name,new.state);
+END;
+
+CREATE TRIGGER jobs_fts_delete AFTER DELETE ON jobs BEGIN
+    INSERT INTO jobs_fts (jobs_fts,rowid,description,name,state) VALUES ('delete',old.seq,old.description,old.name,old.state);
+END;
+
+CREATE TRIGGER jobs_fts_update AFTER UPDATE OF description,name,state ON jobs BEGIN
+    INSERT INTO jobs_fts (jobs_fts,rowid,description,name,state) VALUES ('delete',old.seq,old.description,old.name,old.state);
+    INSERT INTO jobs_fts (rowid,description,name,state) VALUES (new.seq,new.description,new.name,new.state);
+END;
+
+CREATE TABLE jobs_perms (
+    id      varchar(32) not null primary key,
+    job_id  varchar(32) not null references jobs(id),
+    user_id varchar(32)          references users(id),
+    role_id varchar(32)          references roles(id)
+);
+
+CREATE INDEX jobs_perms_job_id_idx ON jobs_perms (job_id);
+CREATE INDEX jobs_perms_user_role_idx ON jobs_perms (user_id,role_id);
+
+CREATE TABLE scheduled_jobs (
+    id            varchar(32) not null primary key,
+    name          varchar(256),
+    description   text,
+    tags          text        not null default '[]',
+    cron_expr     varchar(64) not null,
+    state         varchar(10) not null,
+    created_at    timestamp   not null,
+    created_by    varchar(32) not null references users(id),
+    last_run_at   timestamp,
+    next_run_at   timestamp,
+    tasks         text        not null,
+    inputs        text        not null,
+    secrets       text,
+    output_       text,
+    defaults      text,
+    webhooks      text,
+    permissions   text,
+    auto_delete   text,
+    concurrency   text,
+    input_types   text,
+    input_schema  text
+);
+
+CREATE INDEX idx_scheduled_jobs_state_next_run_at ON scheduled_jobs (state,next_run_at);
+CREATE INDEX idx_scheduled_jobs_created_at ON scheduled_jobs (created_at);
+
+CREATE TABLE job_templates (
+    id          varchar(32)  not null primary key,
+    name        varchar(64)  not null,
+    version     integer      not null,
+    description text,
+    created_at  timestamp    not null,
+    created_by  varchar(32)  not null references users(id),
+    permissions text,
+    job         text         not null
+);
+
+CREATE UNIQUE INDEX idx_job_templates_name_version ON job_templates (name,version);
+
+CREATE TABLE library_tasks (
+    id          varchar(32)  not null primary key,
+    name        varchar(64)  not null,
+    version     integer      not null,
+    description text,
+    created_at  timestamp    not null,
+    created_by  varchar(32)  not null references users(id),
+    params      text,
+    task        text         not null
+);
+
+CREATE UNIQUE INDEX idx_library_tasks_name_version ON library_tasks (name,version);
+
+CREATE TABLE tasks (
+    id            varchar(32) not null primary key,
+    job_id        varchar(32) not null references jobs(id),
+    position      integer     not null,
+    name          varchar(256),
+    state         varchar(10) not null,
+    created_at    timestamp   not null,
+    scheduled_at  timestamp,
+    started_at    timestamp,
+    completed_at  timestamp,
+    failed_at     timestamp,
+    cmd           text,
+    entrypoint    text,
+    run_script    text,
+    image         varchar(256),
+    registry      text,
+    env           text,
+    files_        text,
+    queue         varchar(256),
+    error_        text,
+    pre_tasks     text,
+    post_tasks    text,
+    mounts        text,
+    node_id       varchar(32),
+    retry         text,
+    limits        text,
+    timeout       varchar(8),
+    result        text,
+    var           varchar(64),
+    parallel      text,
+    parent_id     varchar(32),
+    each_         text,
+    description   text,
+    subjob        text,
+    networks      text,
+    gpus          text,
+    if_           text,
+    tags          text,
+    priority      integer,
+    workdir       varchar(256),
+    progress      real        default 0,
+    ports         text,
+    depends_on    text,
+    not_before    timestamp,
+    artifacts     text,
+    node_selector text,
+    affinity      text
+);
+
+CREATE INDEX idx_tasks_state ON tasks (state);
+CREATE INDEX idx_tasks_job_id ON tasks (job_id);
+CREATE INDEX idx_tasks_parent_id ON tasks (parent_id);
+
+CREATE TABLE tasks_log_parts (
+    seq        integer     primary key,
+    id         varchar(32) not null unique,
+    number_    integer     not null,
+    task_id    varchar(32) not null references tasks(id),
+    created_at timestamp   not null,
+    contents   text        not null
+);
+
+CREATE INDEX idx_tasks_log_parts_task_id ON tasks_log_parts (task_id);
+CREATE INDEX idx_tasks_log_parts_created_at ON tasks_log_parts (created_at);
+
+CREATE VIRTUAL TABLE tasks_log_parts_fts USING fts5 (
+    contents,
+    content='tasks_log_parts',
+    content_rowid='seq',
+    tokenize='porter unicode61'
+);
+
+CREATE TRIGGER tasks_log_parts_fts_insert AFTER INSERT ON tasks_log_parts BEGIN
+    INSERT INTO tasks_log_parts_fts (rowid,contents) VALUES (new.seq,new.contents);
+END;
+
+CREATE TRIGGER tasks_log_parts_fts_delete AFTER DELETE ON tasks_log_parts BEGIN
+    INSERT INTO tasks_log_parts_fts (tasks_log_parts_fts,rowid,contents) VALUES ('delete',old.seq,old.contents);
+END;
+`
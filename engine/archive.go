@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"github.com/pkg/errors"
+	"github.com/runabol/tork/archive"
+	"github.com/runabol/tork/archive/local"
+	"github.com/runabol/tork/archive/s3"
+	"github.com/runabol/tork/conf"
+)
+
+func (e *Engine) initArchive() error {
+	if e.archive != nil {
+		return nil
+	}
+	stype := conf.String("archive.type")
+	switch stype {
+	case "":
+		// archiving is disabled
+	case archive.SINK_LOCAL:
+		sink, err := local.NewLocalSink(conf.String("archive.local.dir"))
+		if err != nil {
+			return err
+		}
+		e.archive = sink
+	case archive.SINK_S3:
+		sink, err := s3.NewS3Sink(s3.Config{
+			Endpoint:  conf.String("archive.s3.endpoint"),
+			Region:    conf.String("archive.s3.region"),
+			Bucket:    conf.String("archive.s3.bucket"),
+			Prefix:    conf.String("archive.s3.prefix"),
+			AccessKey: conf.String("archive.s3.accesskey"),
+			SecretKey: conf.String("archive.s3.secretkey"),
+			Secure:    conf.BoolDefault("archive.s3.secure", true),
+		})
+		if err != nil {
+			return err
+		}
+		e.archive = sink
+	default:
+		return errors.Errorf("unknown archive sink type: %s", stype)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork/artifact"
+	"github.com/runabol/tork/artifact/local"
+	"github.com/runabol/tork/artifact/s3"
+	"github.com/runabol/tork/conf"
+)
+
+func (e *Engine) initArtifactStore() error {
+	if e.artifacts != nil {
+		return nil
+	}
+	stype := conf.StringDefault("artifacts.type", artifact.STORE_LOCAL)
+	switch stype {
+	case artifact.STORE_LOCAL:
+		store, err := local.NewLocalStore(conf.StringDefault(
+			"artifacts.local.dir",
+			filepath.Join(os.TempDir(), "tork", "artifacts"),
+		))
+		if err != nil {
+			return err
+		}
+		e.artifacts = store
+	case artifact.STORE_S3:
+		store, err := s3.NewS3Store(s3.Config{
+			Endpoint:  conf.String("artifacts.s3.endpoint"),
+			Region:    conf.String("artifacts.s3.region"),
+			Bucket:    conf.String("artifacts.s3.bucket"),
+			Prefix:    conf.String("artifacts.s3.prefix"),
+			AccessKey: conf.String("artifacts.s3.accesskey"),
+			SecretKey: conf.String("artifacts.s3.secretkey"),
+			Secure:    conf.BoolDefault("artifacts.s3.secure", true),
+		})
+		if err != nil {
+			return err
+		}
+		e.artifacts = store
+	default:
+		return errors.Errorf("unknown artifact store type: %s", stype)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/runabol/tork/artifact/local"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_initArtifactStore(t *testing.T) {
+	eng := New(Config{Mode: ModeStandalone})
+	err := eng.initArtifactStore()
+	assert.NoError(t, err)
+	assert.IsType(t, &local.LocalStore{}, eng.artifacts)
+}
+
+func Test_registerArtifactStore(t *testing.T) {
+	eng := New(Config{Mode: ModeStandalone})
+	store, err := local.NewLocalStore(t.TempDir())
+	assert.NoError(t, err)
+	eng.RegisterArtifactStore(store)
+	assert.NoError(t, eng.initArtifactStore())
+	assert.Equal(t, store, eng.artifacts)
+}
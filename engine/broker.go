@@ -40,6 +40,33 @@ func (e *Engine) createBroker(btype string) (mq.Broker, error) {
 			return nil, errors.Wrapf(err, "unable to connect to RabbitMQ")
 		}
 		return rb, nil
+	case "nats":
+		nb, err := mq.NewNATSBroker(
+			conf.StringDefault("broker.nats.url", "nats://localhost:4222"),
+			mq.WithNATSAckWait(conf.DurationDefault("broker.nats.ack.wait", mq.NATS_DEFAULT_ACK_WAIT)),
+			mq.WithNATSHeartbeatTTL(conf.DurationDefault("broker.nats.heartbeat.ttl", mq.NATS_DEFAULT_HEARTBEAT_TTL)),
+			mq.WithNATSDurableQueues(conf.Bool("broker.nats.durable.queues")),
+		)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to connect to NATS")
+		}
+		return nb, nil
+	case "postgres":
+		pb, err := mq.NewPostgresBroker(
+			conf.StringDefault(
+				"broker.postgres.dsn",
+				conf.StringDefault(
+					"datastore.postgres.dsn",
+					"host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable",
+				),
+			),
+			mq.WithPostgresLeaseTTL(conf.DurationDefault("broker.postgres.lease.ttl", mq.POSTGRES_DEFAULT_LEASE_TTL)),
+			mq.WithPostgresHeartbeatTTL(conf.DurationDefault("broker.postgres.heartbeat.ttl", mq.POSTGRES_DEFAULT_HEARTBEAT_TTL)),
+		)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to connect to Postgres")
+		}
+		return pb, nil
 	default:
 		return nil, errors.Errorf("invalid broker type: %s", btype)
 	}
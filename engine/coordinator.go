@@ -15,6 +15,7 @@ import (
 	"github.com/runabol/tork/conf"
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/internal/coordinator"
+	"github.com/runabol/tork/internal/coordinator/api"
 	"github.com/runabol/tork/internal/hash"
 	"github.com/runabol/tork/internal/redact"
 	"github.com/runabol/tork/internal/uuid"
@@ -28,11 +29,15 @@ func (e *Engine) initCoordinator() error {
 	queues := conf.IntMap("coordinator.queues")
 
 	cfg := coordinator.Config{
-		Name:      conf.StringDefault("coordinator.name", "Coordinator"),
-		Broker:    e.broker,
-		DataStore: e.ds,
-		Queues:    queues,
-		Address:   conf.String("coordinator.address"),
+		Name:              conf.StringDefault("coordinator.name", "Coordinator"),
+		Broker:            e.broker,
+		DataStore:         e.ds,
+		Artifacts:         e.artifacts,
+		Archive:           e.archive,
+		Queues:            queues,
+		Address:           conf.String("coordinator.address"),
+		IdempotencyWindow: conf.DurationDefault("coordinator.idempotency.window", api.DEFAULT_IDEMPOTENCY_WINDOW),
+		ScheduledTimeout:  conf.DurationDefault("coordinator.reaper.scheduled", 0),
 		Middleware: coordinator.Middleware{
 			Web:  e.cfg.Middleware.Web,
 			Task: e.cfg.Middleware.Task,
@@ -42,6 +47,7 @@ func (e *Engine) initCoordinator() error {
 		},
 		Endpoints: e.cfg.Endpoints,
 		Enabled:   conf.BoolMap("coordinator.api.endpoints"),
+		Elector:   e.elector,
 	}
 
 	// redact
@@ -61,6 +67,10 @@ func (e *Engine) initCoordinator() error {
 	cfg.Middleware.Job = append(cfg.Middleware.Job, job.Webhook)
 	cfg.Middleware.Task = append(cfg.Middleware.Task, task.Webhook(e.ds))
 
+	// live updates middleware
+	cfg.Middleware.Job = append(cfg.Middleware.Job, job.Stream(e.broker))
+	cfg.Middleware.Task = append(cfg.Middleware.Task, task.Stream(e.broker))
+
 	c, err := coordinator.NewCoordinator(cfg)
 	if err != nil {
 		return errors.Wrap(err, "error creating the coordinator")
@@ -120,6 +130,9 @@ func basicAuth(ds datastore.Datastore) echo.MiddlewareFunc {
 		if err != nil {
 			return false, nil
 		}
+		if u.Disabled {
+			return false, nil
+		}
 		if subtle.ConstantTimeCompare([]byte(user), []byte(u.Username)) == 1 &&
 			hash.CheckPasswordHash(pass, u.PasswordHash) {
 			ctx.SetRequest(ctx.Request().WithContext(context.WithValue(ctx.Request().Context(), tork.USERNAME, user)))
@@ -6,6 +6,7 @@ import (
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/datastore/inmemory"
 	"github.com/runabol/tork/datastore/postgres"
+	"github.com/runabol/tork/datastore/sqlite"
 )
 
 func (e *Engine) initDatastore() error {
@@ -28,9 +29,16 @@ func (e *Engine) createDatastore(dstype string) (datastore.Datastore, error) {
 	if ok {
 		return dsp()
 	}
+	retention, err := retentionPolicy()
+	if err != nil {
+		return nil, err
+	}
 	switch dstype {
 	case datastore.DATASTORE_INMEMORY:
-		return inmemory.NewInMemoryDatastore(), nil
+		return inmemory.NewInMemoryDatastore(
+			inmemory.WithRetentionPolicy(retention),
+			inmemory.WithArchive(e.archive),
+		), nil
 	case datastore.DATASTORE_POSTGRES:
 		dsn := conf.StringDefault(
 			"datastore.postgres.dsn",
@@ -38,8 +46,38 @@ func (e *Engine) createDatastore(dstype string) (datastore.Datastore, error) {
 		)
 		return postgres.NewPostgresDataStore(dsn,
 			postgres.WithTaskLogRetentionPeriod(conf.DurationDefault("datastore.postgres.task.logs.interval", postgres.DefaultTaskLogsRetentionPeriod)),
+			postgres.WithRetentionPolicy(retention),
+			postgres.WithArchive(e.archive),
+			postgres.WithLeaderElector(e.elector),
+		)
+	case datastore.DATASTORE_SQLITE:
+		return sqlite.NewSQLiteDatastore(
+			conf.StringDefault("datastore.sqlite.path", "tork.db"),
+			sqlite.WithTaskLogRetentionPeriod(conf.DurationDefault("datastore.sqlite.task.logs.interval", sqlite.DefaultTaskLogsRetentionPeriod)),
+			sqlite.WithRetentionPolicy(retention),
+			sqlite.WithArchive(e.archive),
 		)
 	default:
 		return nil, errors.Errorf("unknown datastore type: %s", dstype)
 	}
 }
+
+func retentionPolicy() (datastore.RetentionPolicy, error) {
+	rules := []struct {
+		State string `koanf:"state"`
+		Tag   string `koanf:"tag"`
+		After string `koanf:"after"`
+	}{}
+	if err := conf.Unmarshal("datastore.retention.rules", &rules); err != nil {
+		return nil, errors.Wrapf(err, "error parsing retention rules")
+	}
+	policy := make(datastore.RetentionPolicy, len(rules))
+	for i, r := range rules {
+		rule, err := datastore.NewRetentionRule(r.State, r.Tag, r.After)
+		if err != nil {
+			return nil, err
+		}
+		policy[i] = rule
+	}
+	return policy, nil
+}
@@ -1,8 +1,13 @@
 package engine
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/conf"
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/datastore/inmemory"
 	"github.com/stretchr/testify/assert"
@@ -28,3 +33,29 @@ func Test_createDatastoreProvider(t *testing.T) {
 	assert.NoError(t, err)
 	assert.IsType(t, &inmemory.InMemoryDatastore{}, ds)
 }
+
+func Test_retentionPolicy(t *testing.T) {
+	konf := `
+	[[datastore.retention.rules]]
+	tag = "audit"
+	after = "forever"
+
+	[[datastore.retention.rules]]
+	state = "FAILED"
+	after = "30d"
+	`
+	cfg := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(cfg, []byte(konf), os.ModePerm)
+	assert.NoError(t, err)
+	t.Setenv("TORK_CONFIG", cfg)
+	err = conf.LoadConfig()
+	assert.NoError(t, err)
+
+	p, err := retentionPolicy()
+	assert.NoError(t, err)
+	assert.Len(t, p, 2)
+	assert.Equal(t, "audit", p[0].Tag)
+	assert.Nil(t, p[0].After)
+	assert.Equal(t, tork.JobStateFailed, p[1].State)
+	assert.Equal(t, time.Hour*24*30, *p[1].After)
+}
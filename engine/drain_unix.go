@@ -0,0 +1,23 @@
+//go:build !windows
+
+package engine
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/runabol/tork/internal/worker"
+)
+
+// notifyDrain drains the worker upon receiving a SIGUSR1.
+func notifyDrain(w *worker.Worker, timeout time.Duration) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	go func() {
+		<-ch
+		signal.Stop(ch)
+		w.Drain(timeout)
+	}()
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package engine
+
+import (
+	"time"
+
+	"github.com/runabol/tork/internal/worker"
+)
+
+// notifyDrain is a no-op as there's no
+// SIGUSR1 on Windows. Use the worker's
+// API to drain it instead.
+func notifyDrain(w *worker.Worker, timeout time.Duration) {}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 
@@ -12,10 +13,13 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/archive"
+	"github.com/runabol/tork/artifact"
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/input"
 	"github.com/runabol/tork/internal/coordinator"
 	"github.com/runabol/tork/internal/worker"
+	"github.com/runabol/tork/leader"
 	"github.com/runabol/tork/middleware/job"
 	"github.com/runabol/tork/middleware/node"
 	"github.com/runabol/tork/middleware/task"
@@ -49,8 +53,11 @@ type Engine struct {
 	mu           sync.Mutex
 	broker       mq.Broker
 	ds           datastore.Datastore
+	elector      leader.Elector
 	mounters     map[string]*runtime.MultiMounter
 	runtime      runtime.Runtime
+	artifacts    artifact.Store
+	archive      archive.Sink
 	coordinator  *coordinator.Coordinator
 	worker       *worker.Worker
 	dsProviders  map[string]datastore.Provider
@@ -131,7 +138,12 @@ func (e *Engine) Terminate() error {
 	e.mustState(StateRunning)
 	e.state = StateTerminating
 	log.Debug().Msg("Terminating engine")
-	e.terminate <- 1
+	// the engine may have terminated on its
+	// own already (e.g. a drained worker)
+	select {
+	case e.terminate <- 1:
+	case <-e.terminated:
+	}
 	<-e.terminated
 	e.state = StateTerminated
 	return nil
@@ -149,6 +161,18 @@ func (e *Engine) runCoordinator() error {
 		return err
 	}
 
+	if err := e.initArtifactStore(); err != nil {
+		return err
+	}
+
+	if err := e.initArchive(); err != nil {
+		return err
+	}
+
+	if err := e.initElector(); err != nil {
+		return err
+	}
+
 	if err := e.initDatastore(); err != nil {
 		return err
 	}
@@ -177,6 +201,10 @@ func (e *Engine) runWorker() error {
 		return err
 	}
 
+	if err := e.initArtifactStore(); err != nil {
+		return err
+	}
+
 	if err := e.initWorker(); err != nil {
 		return err
 	}
@@ -201,6 +229,18 @@ func (e *Engine) runStandalone() error {
 		return err
 	}
 
+	if err := e.initArtifactStore(); err != nil {
+		return err
+	}
+
+	if err := e.initArchive(); err != nil {
+		return err
+	}
+
+	if err := e.initElector(); err != nil {
+		return err
+	}
+
 	if err := e.initDatastore(); err != nil {
 		return err
 	}
@@ -296,6 +336,26 @@ func (e *Engine) RegisterRuntime(rt runtime.Runtime) {
 	e.runtime = rt
 }
 
+func (e *Engine) RegisterArtifactStore(store artifact.Store) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.mustState(StateIdle)
+	if e.artifacts != nil {
+		panic("engine: RegisterArtifactStore called twice")
+	}
+	e.artifacts = store
+}
+
+func (e *Engine) RegisterArchiveSink(sink archive.Sink) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.mustState(StateIdle)
+	if e.archive != nil {
+		panic("engine: RegisterArchiveSink called twice")
+	}
+	e.archive = sink
+}
+
 func (e *Engine) RegisterDatastoreProvider(name string, provider datastore.Provider) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -321,12 +381,19 @@ func (e *Engine) SubmitJob(ctx context.Context, ij *input.Job, listeners ...JobL
 	if e.cfg.Mode != ModeStandalone && e.cfg.Mode != ModeCoordinator {
 		panic(errors.Errorf("engine not in coordinator/standalone mode"))
 	}
+	username := tork.USER_GUEST
+	if cu, ok := ctx.Value(tork.USERNAME).(string); ok {
+		username = cu
+	}
 	if err := e.broker.SubscribeForEvents(ctx, mq.TOPIC_JOB, func(ev any) {
 		j, ok := ev.(*tork.Job)
 		if !ok {
 			log.Error().Msg("unable to cast event to *tork.Job")
 		}
-		if ij.ID() == j.ID {
+		// a re-submission of an idempotent job follows
+		// the job previously submitted by the same user
+		if ij.ID() == j.ID || (ij.IdempotencyKey != "" && ij.IdempotencyKey == j.IdempotencyKey &&
+			j.CreatedBy != nil && strings.EqualFold(j.CreatedBy.Username, username)) {
 			for _, listener := range listeners {
 				listener(j)
 			}
@@ -338,9 +405,22 @@ func (e *Engine) SubmitJob(ctx context.Context, ij *input.Job, listeners ...JobL
 	if err != nil {
 		return nil, err
 	}
+	if job.ID != ij.ID() && isFinished(job.State) {
+		// the previously submitted job is
+		// not going to emit any more events
+		for _, listener := range listeners {
+			listener(job.Clone())
+		}
+	}
 	return job.Clone(), nil
 }
 
+func isFinished(s tork.JobState) bool {
+	return s == tork.JobStateCompleted ||
+		s == tork.JobStateFailed ||
+		s == tork.JobStateCancelled
+}
+
 func (e *Engine) OnBrokerInit(fn func(b mq.Broker) error) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -357,8 +437,14 @@ func (e *Engine) OnDatastoreInit(fn func(ds datastore.Datastore) error) {
 
 func (e *Engine) awaitTerm() {
 	signal.Notify(e.quit, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	// a drained worker shuts down on its own
+	var drained <-chan any
+	if e.worker != nil {
+		drained = e.worker.Drained()
+	}
 	select {
 	case <-e.quit:
 	case <-e.terminate:
+	case <-drained:
 	}
 }
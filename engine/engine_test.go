@@ -135,6 +135,34 @@ func Test_basicCorrectPassword(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func Test_basicAuthDisabledUser(t *testing.T) {
+	ds := inmemory.NewInMemoryDatastore()
+	password := uuid.NewShortUUID()
+	hashedPassword, err := hash.Password(password)
+	assert.NoError(t, err)
+	u := &tork.User{
+		ID:           uuid.NewUUID(),
+		Username:     uuid.NewShortUUID(),
+		Name:         "Tester",
+		PasswordHash: hashedPassword,
+		Disabled:     true,
+	}
+	err = ds.CreateUser(context.Background(), u)
+	assert.NoError(t, err)
+	mw := basicAuth(ds)
+	req, err := http.NewRequest("GET", "/health", nil)
+	req.SetBasicAuth(u.Username, password)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	ctx := echo.New().NewContext(req, w)
+	h := func(c echo.Context) error {
+		return nil
+	}
+	x := mw(h)
+	err = x(ctx)
+	assert.Error(t, err)
+}
+
 func Test_rateLimit(t *testing.T) {
 	mw := rateLimit(20)
 	req, err := http.NewRequest("GET", "/health", nil)
@@ -217,6 +245,79 @@ func TestSubmitJob(t *testing.T) {
 	<-c
 }
 
+func TestSubmitJobIdempotent(t *testing.T) {
+	eng := New(Config{Mode: ModeCoordinator})
+
+	err := eng.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, StateRunning, eng.state)
+
+	ctx := context.Background()
+
+	ij := func() *input.Job {
+		return &input.Job{
+			Name:           "test job",
+			IdempotencyKey: "some-key",
+			Tasks: []input.Task{
+				{
+					Name:  "first task",
+					Image: "some:image",
+				},
+			},
+		}
+	}
+
+	j1, err := eng.SubmitJob(ctx, ij())
+	assert.NoError(t, err)
+
+	c := make(chan any)
+	listener := func(j *tork.Job) {
+		if j.State == tork.JobStateCompleted {
+			close(c)
+		}
+	}
+
+	j2, err := eng.SubmitJob(ctx, ij(), listener)
+	assert.NoError(t, err)
+	assert.Equal(t, j1.ID, j2.ID)
+
+	j1.State = tork.JobStateCompleted
+	err = eng.broker.PublishEvent(context.Background(), mq.TOPIC_JOB_COMPLETED, j1)
+	assert.NoError(t, err)
+	<-c
+}
+
+func TestSubmitJobUsesLibraryTask(t *testing.T) {
+	eng := New(Config{Mode: ModeCoordinator})
+
+	err := eng.Start()
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+
+	err = eng.ds.CreateLibraryTask(ctx, &tork.LibraryTask{
+		ID:     uuid.NewUUID(),
+		Name:   "lint",
+		Params: []byte(`{"path":{"required":true}}`),
+		Task:   []byte(`{"name":"lint","image":"golangci/golangci-lint","run":"golangci-lint run {{ with.path }}"}`),
+	})
+	assert.NoError(t, err)
+
+	j, err := eng.SubmitJob(ctx, &input.Job{
+		Name: "test job",
+		Tasks: []input.Task{
+			{
+				Name: "lint code",
+				Uses: "lint",
+				With: map[string]string{"path": "./..."},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "golangci/golangci-lint", j.Tasks[0].Image)
+	assert.Equal(t, "golangci-lint run ./...", j.Tasks[0].Run)
+}
+
 func TestSubmitJobPanics(t *testing.T) {
 	eng := New(Config{Mode: ModeStandalone})
 	assert.Panics(t, func() {
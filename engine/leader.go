@@ -0,0 +1,39 @@
+package engine
+
+import (
+	"github.com/pkg/errors"
+	"github.com/runabol/tork/conf"
+	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/leader"
+)
+
+func (e *Engine) initElector() error {
+	// coordinators sharing a postgres datastore elect their
+	// leader through it unless told otherwise
+	dflt := leader.ELECTOR_INMEMORY
+	if conf.StringDefault("datastore.type", datastore.DATASTORE_INMEMORY) == datastore.DATASTORE_POSTGRES {
+		dflt = leader.ELECTOR_POSTGRES
+	}
+	etype := conf.StringDefault("coordinator.leader.type", dflt)
+	id := uuid.NewShortUUID()
+	switch etype {
+	case leader.ELECTOR_INMEMORY:
+		e.elector = leader.NewInMemoryElector(id)
+	case leader.ELECTOR_POSTGRES:
+		dsn := conf.StringDefault(
+			"datastore.postgres.dsn",
+			"host=localhost user=tork password=tork dbname=tork port=5432 sslmode=disable",
+		)
+		el, err := leader.NewPostgresElector(dsn, id,
+			leader.WithPostgresElectionInterval(conf.DurationDefault("coordinator.leader.interval", leader.POSTGRES_DEFAULT_ELECTION_INTERVAL)),
+		)
+		if err != nil {
+			return errors.Wrapf(err, "error creating the leader elector")
+		}
+		e.elector = el
+	default:
+		return errors.Errorf("unknown leader elector type: %s", etype)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/runabol/tork/conf"
+	"github.com/runabol/tork/leader"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_initElector(t *testing.T) {
+	eng := New(Config{Mode: ModeStandalone})
+	assert.NoError(t, eng.initElector())
+	assert.IsType(t, &leader.InMemoryElector{}, eng.elector)
+	assert.True(t, eng.elector.IsLeader())
+}
+
+func Test_initElectorUnknown(t *testing.T) {
+	t.Cleanup(func() {
+		assert.NoError(t, conf.LoadConfig())
+	})
+	t.Setenv("TORK_COORDINATOR_LEADER_TYPE", "bad")
+	assert.NoError(t, conf.LoadConfig())
+	eng := New(Config{Mode: ModeStandalone})
+	assert.Error(t, eng.initElector())
+}
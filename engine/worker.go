@@ -8,6 +8,8 @@ import (
 
 	"github.com/runabol/tork/runtime"
 	"github.com/runabol/tork/runtime/docker"
+	"github.com/runabol/tork/runtime/kubernetes"
+	"github.com/runabol/tork/runtime/podman"
 	"github.com/runabol/tork/runtime/shell"
 )
 
@@ -33,6 +35,11 @@ func (e *Engine) initWorker() error {
 			DefaultMemoryLimit: conf.String("worker.limits.memory"),
 			DefaultTimeout:     conf.String("worker.limits.timeout"),
 		},
+		Resources: worker.Resources{
+			CPUs:   conf.String("worker.resources.cpus"),
+			Memory: conf.String("worker.resources.memory"),
+		},
+		Labels:     conf.StringMap("worker.labels"),
 		Address:    conf.String("worker.address"),
 		Middleware: e.cfg.Middleware.Task,
 	})
@@ -42,6 +49,7 @@ func (e *Engine) initWorker() error {
 	if err := w.Start(); err != nil {
 		return err
 	}
+	notifyDrain(w, conf.DurationDefault("worker.drain.timeout", 0))
 	e.worker = w
 	return nil
 }
@@ -76,14 +84,74 @@ func (e *Engine) initRuntime() (runtime.Runtime, error) {
 			docker.WithConfig(conf.String("runtime.docker.config")),
 			docker.WithBroker(e.broker),
 			docker.WithSandbox(conf.BoolDefault("runtime.docker.sandbox", false)),
+			docker.WithArtifactStore(e.artifacts),
 		)
 	case runtime.Shell:
 		return shell.NewShellRuntime(shell.Config{
-			CMD:    conf.Strings("runtime.shell.cmd"),
-			UID:    conf.StringDefault("runtime.shell.uid", shell.DEFAULT_UID),
-			GID:    conf.StringDefault("runtime.shell.gid", shell.DEFAULT_GID),
-			Broker: e.broker,
+			CMD:       conf.Strings("runtime.shell.cmd"),
+			UID:       conf.StringDefault("runtime.shell.uid", shell.DEFAULT_UID),
+			GID:       conf.StringDefault("runtime.shell.gid", shell.DEFAULT_GID),
+			Broker:    e.broker,
+			Artifacts: e.artifacts,
 		}), nil
+	case runtime.Kubernetes:
+		namespace := conf.StringDefault("runtime.kubernetes.namespace", "default")
+		client, config, err := kubernetes.NewClient(conf.String("runtime.kubernetes.kubeconfig"))
+		if err != nil {
+			return nil, err
+		}
+		mounter, ok := e.mounters[runtime.Kubernetes]
+		if !ok {
+			mounter = runtime.NewMultiMounter()
+		}
+		// register bind mounter
+		mounter.RegisterMounter("bind", kubernetes.NewBindMounter(kubernetes.BindConfig{
+			Allowed: conf.Bool("mounts.bind.allowed"),
+			Sources: conf.Strings("mounts.bind.sources"),
+		}))
+		// register volume mounter
+		mounter.RegisterMounter("volume", kubernetes.NewVolumeMounter(client, kubernetes.VolumeConfig{
+			Namespace:    namespace,
+			StorageClass: conf.String("runtime.kubernetes.volumes.storageclass"),
+			Size:         conf.String("runtime.kubernetes.volumes.size"),
+		}))
+		// register tmpfs mounter
+		mounter.RegisterMounter("tmpfs", kubernetes.NewTmpfsMounter())
+		return kubernetes.NewKubernetesRuntime(
+			kubernetes.WithClient(client),
+			kubernetes.WithRestConfig(config),
+			kubernetes.WithMounter(mounter),
+			kubernetes.WithNamespace(namespace),
+			kubernetes.WithSidecarImage(conf.StringDefault("runtime.kubernetes.sidecar.image", "busybox:stable")),
+			kubernetes.WithBroker(e.broker),
+		)
+	case runtime.Podman:
+		mounter, ok := e.mounters[runtime.Podman]
+		if !ok {
+			mounter = runtime.NewMultiMounter()
+		}
+		// register bind mounter
+		mounter.RegisterMounter("bind", docker.NewBindMounter(docker.BindConfig{
+			Allowed: conf.Bool("mounts.bind.allowed"),
+			Sources: conf.Strings("mounts.bind.sources"),
+		}))
+		// register volume mounter
+		vm, err := podman.NewVolumeMounter(
+			podman.WithVolumeHost(conf.String("runtime.podman.host")),
+		)
+		if err != nil {
+			return nil, err
+		}
+		mounter.RegisterMounter("volume", vm)
+		// register tmpfs mounter
+		mounter.RegisterMounter("tmpfs", docker.NewTmpfsMounter())
+		return podman.NewPodmanRuntime(
+			podman.WithHost(conf.String("runtime.podman.host")),
+			podman.WithMounter(mounter),
+			podman.WithConfig(conf.String("runtime.podman.config")),
+			podman.WithBroker(e.broker),
+			podman.WithSandbox(conf.BoolDefault("runtime.podman.sandbox", false)),
+		)
 	default:
 		return nil, errors.Errorf("unknown runtime type: %s", runtimeType)
 	}
@@ -23,6 +23,9 @@ type HealthIndicator func(ctx context.Context) error
 type HealthCheckResult struct {
 	Status  string `json:"status"`
 	Version string `json:"version"`
+	// Leader is the ID of the coordinator
+	// currently performing the singleton duties.
+	Leader string `json:"leader,omitempty"`
 }
 
 type HealthCheck struct {
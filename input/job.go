@@ -9,18 +9,33 @@ import (
 )
 
 type Job struct {
-	id          string
-	Name        string            `json:"name,omitempty" yaml:"name,omitempty" validate:"required"`
-	Description string            `json:"description,omitempty" yaml:"description,omitempty"`
-	Tags        []string          `json:"tags,omitempty" yaml:"tags,omitempty"`
-	Tasks       []Task            `json:"tasks,omitempty" yaml:"tasks,omitempty" validate:"required,min=1,dive"`
-	Inputs      map[string]string `json:"inputs,omitempty" yaml:"inputs,omitempty"`
-	Secrets     map[string]string `json:"secrets,omitempty" yaml:"secrets,omitempty"`
-	Output      string            `json:"output,omitempty" yaml:"output,omitempty" validate:"expr"`
-	Defaults    *Defaults         `json:"defaults,omitempty" yaml:"defaults,omitempty"`
-	Webhooks    []Webhook         `json:"webhooks,omitempty" yaml:"webhooks,omitempty" validate:"dive"`
-	Permissions []Permission      `json:"permissions,omitempty" yaml:"permissions,omitempty" validate:"dive"`
-	AutoDelete  *AutoDelete       `json:"autoDelete,omitempty" yaml:"autoDelete,omitempty"`
+	id             string
+	Name           string               `json:"name,omitempty" yaml:"name,omitempty" validate:"required"`
+	Description    string               `json:"description,omitempty" yaml:"description,omitempty"`
+	Tags           []string             `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Tasks          []Task               `json:"tasks,omitempty" yaml:"tasks,omitempty" validate:"required,min=1,dive"`
+	Inputs         map[string]string    `json:"inputs,omitempty" yaml:"inputs,omitempty"`
+	InputSchema    map[string]InputSpec `json:"inputSchema,omitempty" yaml:"inputSchema,omitempty" validate:"dive"`
+	Secrets        map[string]string    `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+	Output         string               `json:"output,omitempty" yaml:"output,omitempty" validate:"expr"`
+	Defaults       *Defaults            `json:"defaults,omitempty" yaml:"defaults,omitempty"`
+	Webhooks       []Webhook            `json:"webhooks,omitempty" yaml:"webhooks,omitempty" validate:"dive"`
+	Permissions    []Permission         `json:"permissions,omitempty" yaml:"permissions,omitempty" validate:"dive"`
+	AutoDelete     *AutoDelete          `json:"autoDelete,omitempty" yaml:"autoDelete,omitempty"`
+	Concurrency    *Concurrency         `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+	IdempotencyKey string               `json:"idempotencyKey,omitempty" yaml:"idempotencyKey,omitempty" validate:"max=256"`
+	Template       string               `json:"template,omitempty" yaml:"template,omitempty"`
+}
+
+// InputSpec declares the type and the constraints
+// of one of the inputs of a job.
+type InputSpec struct {
+	Type        string   `json:"type,omitempty" yaml:"type,omitempty" validate:"omitempty,oneof=string int bool list json"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Required    bool     `json:"required,omitempty" yaml:"required,omitempty"`
+	Default     string   `json:"default,omitempty" yaml:"default,omitempty"`
+	Enum        []string `json:"enum,omitempty" yaml:"enum,omitempty"`
+	Pattern     string   `json:"pattern,omitempty" yaml:"pattern,omitempty"`
 }
 
 type Defaults struct {
@@ -35,6 +50,12 @@ type AutoDelete struct {
 	After string `json:"after,omitempty" yaml:"after,omitempty" validate:"duration"`
 }
 
+type Concurrency struct {
+	Key        string `json:"key,omitempty" yaml:"key,omitempty" validate:"required"`
+	Limit      int    `json:"limit,omitempty" yaml:"limit,omitempty" validate:"min=0"`
+	OnConflict string `json:"onConflict,omitempty" yaml:"onConflict,omitempty" validate:"omitempty,oneof=queue cancel-previous reject"`
+}
+
 type Webhook struct {
 	URL     string            `json:"url,omitempty" yaml:"url,omitempty" validate:"required"`
 	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
@@ -58,7 +79,7 @@ func (ji *Job) ToJob() *tork.Job {
 	j := &tork.Job{}
 	j.ID = ji.ID()
 	j.Description = ji.Description
-	j.Inputs = ji.Inputs
+	j.Inputs = ji.inputs()
 	j.Secrets = ji.Secrets
 	j.Tags = ji.Tags
 	j.Name = ji.Name
@@ -70,7 +91,9 @@ func (ji *Job) ToJob() *tork.Job {
 	j.State = tork.JobStatePending
 	j.CreatedAt = n
 	j.Context = tork.JobContext{}
-	j.Context.Inputs = ji.Inputs
+	j.Context.Inputs = j.Inputs
+	j.Context.InputTypes = ji.inputTypes()
+	j.Context.InputSchema = ji.inputSchema()
 	j.Context.Secrets = ji.Secrets
 	j.Context.Job = map[string]string{
 		"id":   j.ID,
@@ -96,9 +119,77 @@ func (ji *Job) ToJob() *tork.Job {
 			After: ji.AutoDelete.After,
 		}
 	}
+	if ji.Concurrency != nil {
+		j.Concurrency = ji.Concurrency.toJobConcurrency()
+	}
+	j.IdempotencyKey = ji.IdempotencyKey
 	return j
 }
 
+// inputs returns the job's inputs along with
+// the defaults of the inputs which are missing.
+func (ji *Job) inputs() map[string]string {
+	if len(ji.InputSchema) == 0 {
+		return ji.Inputs
+	}
+	inputs := maps.Clone(ji.Inputs)
+	if inputs == nil {
+		inputs = make(map[string]string)
+	}
+	for name, spec := range ji.InputSchema {
+		if _, ok := inputs[name]; !ok && spec.Default != "" {
+			inputs[name] = spec.Default
+		}
+	}
+	return inputs
+}
+
+func (ji *Job) inputTypes() map[string]tork.InputType {
+	if len(ji.InputSchema) == 0 {
+		return nil
+	}
+	types := make(map[string]tork.InputType, len(ji.InputSchema))
+	for name, spec := range ji.InputSchema {
+		if spec.Type != "" {
+			types[name] = tork.InputType(spec.Type)
+		}
+	}
+	return types
+}
+
+// inputSchema returns the input constraints which are
+// kept with the job to validate inputs provided later on.
+func (ji *Job) inputSchema() map[string]tork.InputSpec {
+	if len(ji.InputSchema) == 0 {
+		return nil
+	}
+	schema := make(map[string]tork.InputSpec, len(ji.InputSchema))
+	for name, spec := range ji.InputSchema {
+		schema[name] = tork.InputSpec{
+			Type:     tork.InputType(spec.Type),
+			Required: spec.Required,
+			Enum:     spec.Enum,
+			Pattern:  spec.Pattern,
+		}
+	}
+	return schema
+}
+
+func (c Concurrency) toJobConcurrency() *tork.JobConcurrency {
+	jc := &tork.JobConcurrency{
+		Key:        c.Key,
+		Limit:      c.Limit,
+		OnConflict: c.OnConflict,
+	}
+	if jc.Limit == 0 {
+		jc.Limit = 1
+	}
+	if jc.OnConflict == "" {
+		jc.OnConflict = tork.ConcurrencyQueue
+	}
+	return jc
+}
+
 func (d Defaults) ToJobDefaults() *tork.JobDefaults {
 	jd := tork.JobDefaults{}
 	if d.Retry != nil {
@@ -134,3 +225,34 @@ func (p Permission) toPermission() *tork.Permission {
 	}
 	return tp
 }
+
+// ScheduledJob is a job definition which is
+// submitted on every tick of its Cron expression.
+type ScheduledJob struct {
+	Job  `yaml:",inline"`
+	Cron string `json:"cron,omitempty" yaml:"cron,omitempty" validate:"required,cron"`
+}
+
+func (sji *ScheduledJob) ToScheduledJob() *tork.ScheduledJob {
+	j := sji.Job.ToJob()
+	return &tork.ScheduledJob{
+		ID:          j.ID,
+		Name:        j.Name,
+		Description: j.Description,
+		Tags:        j.Tags,
+		Cron:        sji.Cron,
+		State:       tork.ScheduledJobStateActive,
+		CreatedAt:   j.CreatedAt,
+		Tasks:       j.Tasks,
+		Inputs:      j.Inputs,
+		InputTypes:  j.Context.InputTypes,
+		InputSchema: j.Context.InputSchema,
+		Secrets:     j.Secrets,
+		Output:      j.Output,
+		Defaults:    j.Defaults,
+		Webhooks:    j.Webhooks,
+		Permissions: j.Permissions,
+		AutoDelete:  j.AutoDelete,
+		Concurrency: j.Concurrency,
+	}
+}
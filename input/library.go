@@ -0,0 +1,281 @@
+package input
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/internal/eval"
+	"github.com/runabol/tork/internal/uuid"
+	"golang.org/x/exp/maps"
+)
+
+// LibraryTask is a reusable task definition which can be
+// referenced from the tasks of any job (uses: name@version).
+// The task definition refers to its parameters using
+// {{ with.<param> }} expressions.
+type LibraryTask struct {
+	Name        string               `json:"name,omitempty" yaml:"name,omitempty" validate:"required,max=64,refname"`
+	Description string               `json:"description,omitempty" yaml:"description,omitempty"`
+	Params      map[string]InputSpec `json:"params,omitempty" yaml:"params,omitempty" validate:"dive"`
+	Task        Task                 `json:"task" yaml:"task"`
+}
+
+func (lt *LibraryTask) ToLibraryTask() (*tork.LibraryTask, error) {
+	var params []byte
+	if len(lt.Params) > 0 {
+		b, err := json.Marshal(lt.Params)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error serializing library task params")
+		}
+		params = b
+	}
+	def, err := json.Marshal(lt.Task)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error serializing library task")
+	}
+	return &tork.LibraryTask{
+		ID:          uuid.NewUUID(),
+		Name:        lt.Name,
+		Description: lt.Description,
+		CreatedAt:   time.Now().UTC(),
+		Params:      params,
+		Task:        def,
+	}, nil
+}
+
+// ExpandUses replaces every task of the job which references a
+// library task with the definition of that task, in which the
+// parameters are substituted by the values of the task's with:
+// block. Any other property set on the referencing task overrides
+// or complements the one of the library task.
+func (ji *Job) ExpandUses(ctx context.Context, ds datastore.Datastore) error {
+	tasks, err := expandTasks(ctx, ds, ji.Tasks)
+	if err != nil {
+		return err
+	}
+	ji.Tasks = tasks
+	return nil
+}
+
+func expandTasks(ctx context.Context, ds datastore.Datastore, tasks []Task) ([]Task, error) {
+	if tasks == nil {
+		return nil, nil
+	}
+	result := make([]Task, len(tasks))
+	for i, t := range tasks {
+		et, err := expandTask(ctx, ds, t)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = et
+	}
+	return result, nil
+}
+
+func expandTask(ctx context.Context, ds datastore.Datastore, t Task) (Task, error) {
+	if t.Parallel != nil {
+		tasks, err := expandTasks(ctx, ds, t.Parallel.Tasks)
+		if err != nil {
+			return Task{}, err
+		}
+		t.Parallel = &Parallel{Tasks: tasks}
+	}
+	if t.Each != nil {
+		et, err := expandTask(ctx, ds, t.Each.Task)
+		if err != nil {
+			return Task{}, err
+		}
+		each := *t.Each
+		each.Task = et
+		t.Each = &each
+	}
+	if t.SubJob != nil {
+		tasks, err := expandTasks(ctx, ds, t.SubJob.Tasks)
+		if err != nil {
+			return Task{}, err
+		}
+		sj := *t.SubJob
+		sj.Tasks = tasks
+		t.SubJob = &sj
+	}
+	if t.Uses == "" {
+		return t, nil
+	}
+	if t.Image != "" || t.Run != "" || len(t.CMD) > 0 || len(t.Entrypoint) > 0 ||
+		t.Parallel != nil || t.Each != nil || t.SubJob != nil {
+		return Task{}, errors.Errorf("task %s uses %s and can't specify an image, run, cmd, entrypoint or sub-tasks", t.Name, t.Uses)
+	}
+	name, version, err := tork.ParseRef(t.Uses)
+	if err != nil {
+		return Task{}, err
+	}
+	lt, err := ds.GetLibraryTask(ctx, name, version)
+	if err != nil {
+		return Task{}, errors.Wrapf(err, "error resolving %s for task %s", t.Uses, t.Name)
+	}
+	def, err := resolveLibraryTask(lt, t.With)
+	if err != nil {
+		return Task{}, errors.Wrapf(err, "error expanding %s for task %s", t.Uses, t.Name)
+	}
+	if t.Name != "" {
+		def.Name = t.Name
+	}
+	if t.Description != "" {
+		def.Description = t.Description
+	}
+	if t.Var != "" {
+		def.Var = t.Var
+	}
+	if t.If != "" {
+		def.If = t.If
+	}
+	if len(t.DependsOn) > 0 {
+		def.DependsOn = t.DependsOn
+	}
+	if t.Queue != "" {
+		def.Queue = t.Queue
+	}
+	if t.Timeout != "" {
+		def.Timeout = t.Timeout
+	}
+	if t.Retry != nil {
+		def.Retry = t.Retry
+	}
+	if t.Limits != nil {
+		def.Limits = t.Limits
+	}
+	if t.Priority != 0 {
+		def.Priority = t.Priority
+	}
+	def.Tags = append(def.Tags, t.Tags...)
+	if len(t.NodeSelector) > 0 {
+		def.NodeSelector = t.NodeSelector
+	}
+	if len(t.Affinity) > 0 {
+		def.Affinity = t.Affinity
+	}
+	if len(t.Env) > 0 {
+		if def.Env == nil {
+			def.Env = make(map[string]string)
+		}
+		maps.Copy(def.Env, t.Env)
+	}
+	return def, nil
+}
+
+// resolveLibraryTask returns the definition of the given
+// library task with its parameters substituted by their
+// values.
+func resolveLibraryTask(lt *tork.LibraryTask, with map[string]string) (Task, error) {
+	specs := make(map[string]InputSpec)
+	if len(lt.Params) > 0 {
+		if err := json.Unmarshal(lt.Params, &specs); err != nil {
+			return Task{}, errors.Wrapf(err, "error deserializing params")
+		}
+	}
+	for name := range with {
+		if _, ok := specs[name]; !ok {
+			return Task{}, errors.Errorf("unknown parameter: %s", name)
+		}
+	}
+	params := make(map[string]any, len(specs))
+	for name, spec := range specs {
+		v, ok := with[name]
+		if !ok {
+			if spec.Required && spec.Default == "" {
+				return Task{}, errors.Errorf("missing required parameter: %s", name)
+			}
+			if spec.Default == "" {
+				params[name] = zeroParam(tork.InputType(spec.Type))
+				continue
+			}
+			v = spec.Default
+		}
+		var pattern *regexp.Regexp
+		if spec.Pattern != "" {
+			p, err := regexp.Compile(spec.Pattern)
+			if err != nil {
+				return Task{}, errors.Wrapf(err, "invalid pattern for parameter %s", name)
+			}
+			pattern = p
+		}
+		if ok {
+			if tag := validateInput(spec, pattern, v); tag != "" {
+				return Task{}, errors.Errorf("invalid value for parameter %s: %s", name, v)
+			}
+		}
+		pv, err := tork.ParseInput(tork.InputType(spec.Type), v)
+		if err != nil {
+			return Task{}, errors.Wrapf(err, "invalid value for parameter %s", name)
+		}
+		params[name] = pv
+	}
+	var raw any
+	if err := json.Unmarshal(lt.Task, &raw); err != nil {
+		return Task{}, errors.Wrapf(err, "error deserializing task")
+	}
+	raw, err := substituteParams(raw, map[string]any{"with": params})
+	if err != nil {
+		return Task{}, err
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return Task{}, errors.Wrapf(err, "error serializing task")
+	}
+	var def Task
+	if err := json.Unmarshal(b, &def); err != nil {
+		return Task{}, errors.Wrapf(err, "error deserializing task")
+	}
+	return def, nil
+}
+
+// zeroParam returns the value of an optional
+// parameter of the given type which was not set.
+func zeroParam(t tork.InputType) any {
+	switch t {
+	case tork.InputTypeInt:
+		return 0
+	case tork.InputTypeBool:
+		return false
+	case tork.InputTypeList:
+		return []any{}
+	case tork.InputTypeJSON:
+		return nil
+	default:
+		return ""
+	}
+}
+
+// substituteParams evaluates the parameter expressions
+// in every string value of the given task definition.
+func substituteParams(v any, c map[string]any) (any, error) {
+	switch tv := v.(type) {
+	case string:
+		return eval.EvaluatePartial(tv, c)
+	case []any:
+		for i, item := range tv {
+			sv, err := substituteParams(item, c)
+			if err != nil {
+				return nil, err
+			}
+			tv[i] = sv
+		}
+		return tv, nil
+	case map[string]any:
+		for k, item := range tv {
+			sv, err := substituteParams(item, c)
+			if err != nil {
+				return nil, err
+			}
+			tv[k] = sv
+		}
+		return tv, nil
+	default:
+		return v, nil
+	}
+}
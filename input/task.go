@@ -6,33 +6,49 @@ import (
 )
 
 type Task struct {
-	Name        string            `json:"name,omitempty" yaml:"name,omitempty" validate:"required"`
-	Description string            `json:"description,omitempty" yaml:"description,omitempty"`
-	CMD         []string          `json:"cmd,omitempty" yaml:"cmd,omitempty"`
-	Entrypoint  []string          `json:"entrypoint,omitempty" yaml:"entrypoint,omitempty"`
-	Run         string            `json:"run,omitempty" yaml:"run,omitempty"`
-	Image       string            `json:"image,omitempty" yaml:"image,omitempty"`
-	Registry    *Registry         `json:"registry,omitempty" yaml:"registry,omitempty"`
-	Env         map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
-	Files       map[string]string `json:"files,omitempty" yaml:"files,omitempty"`
-	Queue       string            `json:"queue,omitempty" yaml:"queue,omitempty" validate:"queue"`
-	Pre         []AuxTask         `json:"pre,omitempty" yaml:"pre,omitempty" validate:"dive"`
-	Post        []AuxTask         `json:"post,omitempty" yaml:"post,omitempty" validate:"dive"`
-	Mounts      []Mount           `json:"mounts,omitempty" yaml:"mounts,omitempty" validate:"dive"`
-	Networks    []string          `json:"networks,omitempty" yaml:"networks,omitempty"`
-	Retry       *Retry            `json:"retry,omitempty" yaml:"retry,omitempty"`
-	Limits      *Limits           `json:"limits,omitempty" yaml:"limits,omitempty"`
-	Timeout     string            `json:"timeout,omitempty" yaml:"timeout,omitempty" validate:"duration"`
-	Var         string            `json:"var,omitempty" yaml:"var,omitempty" validate:"max=64"`
-	If          string            `json:"if,omitempty" yaml:"if,omitempty" validate:"expr"`
-	Parallel    *Parallel         `json:"parallel,omitempty" yaml:"parallel,omitempty"`
-	Each        *Each             `json:"each,omitempty" yaml:"each,omitempty"`
-	SubJob      *SubJob           `json:"subjob,omitempty" yaml:"subjob,omitempty"`
-	GPUs        string            `json:"gpus,omitempty" yaml:"gpus,omitempty"`
-	Tags        []string          `json:"tags,omitempty" yaml:"tags,omitempty"`
-	Workdir     string            `json:"workdir,omitempty" yaml:"workdir,omitempty" validate:"max=256"`
-	Priority    int               `json:"priority,omitempty" yaml:"priority,omitempty" validate:"min=0,max=9"`
-	Ports       []Port            `json:"ports,omitempty" yaml:"ports,omitempty" validate:"dive"`
+	Name         string            `json:"name,omitempty" yaml:"name,omitempty" validate:"required"`
+	Description  string            `json:"description,omitempty" yaml:"description,omitempty"`
+	CMD          []string          `json:"cmd,omitempty" yaml:"cmd,omitempty"`
+	Entrypoint   []string          `json:"entrypoint,omitempty" yaml:"entrypoint,omitempty"`
+	Run          string            `json:"run,omitempty" yaml:"run,omitempty"`
+	Image        string            `json:"image,omitempty" yaml:"image,omitempty"`
+	Registry     *Registry         `json:"registry,omitempty" yaml:"registry,omitempty"`
+	Env          map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	Files        map[string]string `json:"files,omitempty" yaml:"files,omitempty"`
+	Queue        string            `json:"queue,omitempty" yaml:"queue,omitempty" validate:"queue"`
+	Pre          []AuxTask         `json:"pre,omitempty" yaml:"pre,omitempty" validate:"dive"`
+	Post         []AuxTask         `json:"post,omitempty" yaml:"post,omitempty" validate:"dive"`
+	Mounts       []Mount           `json:"mounts,omitempty" yaml:"mounts,omitempty" validate:"dive"`
+	Networks     []string          `json:"networks,omitempty" yaml:"networks,omitempty"`
+	Retry        *Retry            `json:"retry,omitempty" yaml:"retry,omitempty"`
+	Limits       *Limits           `json:"limits,omitempty" yaml:"limits,omitempty"`
+	Timeout      string            `json:"timeout,omitempty" yaml:"timeout,omitempty" validate:"duration"`
+	Var          string            `json:"var,omitempty" yaml:"var,omitempty" validate:"max=64"`
+	If           string            `json:"if,omitempty" yaml:"if,omitempty" validate:"expr"`
+	DependsOn    []string          `json:"dependsOn,omitempty" yaml:"dependsOn,omitempty"`
+	Parallel     *Parallel         `json:"parallel,omitempty" yaml:"parallel,omitempty"`
+	Each         *Each             `json:"each,omitempty" yaml:"each,omitempty"`
+	SubJob       *SubJob           `json:"subjob,omitempty" yaml:"subjob,omitempty"`
+	GPUs         string            `json:"gpus,omitempty" yaml:"gpus,omitempty"`
+	Tags         []string          `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Workdir      string            `json:"workdir,omitempty" yaml:"workdir,omitempty" validate:"max=256"`
+	Priority     int               `json:"priority,omitempty" yaml:"priority,omitempty" validate:"min=0,max=9"`
+	Ports        []Port            `json:"ports,omitempty" yaml:"ports,omitempty" validate:"dive"`
+	Artifacts    *Artifacts        `json:"artifacts,omitempty" yaml:"artifacts,omitempty"`
+	Uses         string            `json:"uses,omitempty" yaml:"uses,omitempty"`
+	With         map[string]string `json:"with,omitempty" yaml:"with,omitempty"`
+	NodeSelector map[string]string `json:"nodeSelector,omitempty" yaml:"nodeSelector,omitempty" validate:"dive,keys,required,max=64,endkeys,max=256"`
+	Affinity     map[string]string `json:"affinity,omitempty" yaml:"affinity,omitempty" validate:"dive,keys,required,max=64,endkeys,max=256"`
+}
+
+type Artifacts struct {
+	Outputs []Artifact `json:"outputs,omitempty" yaml:"outputs,omitempty" validate:"dive"`
+	Inputs  []Artifact `json:"inputs,omitempty" yaml:"inputs,omitempty" validate:"dive"`
+}
+
+type Artifact struct {
+	Name string `json:"name,omitempty" yaml:"name,omitempty" validate:"required,max=256"`
+	Path string `json:"path,omitempty" yaml:"path,omitempty" validate:"required,max=256"`
 }
 
 type SubJob struct {
@@ -60,7 +76,11 @@ type Parallel struct {
 }
 
 type Retry struct {
-	Limit int `json:"limit,omitempty" yaml:"limit,omitempty" validate:"required,min=1,max=10"`
+	Limit         int      `json:"limit,omitempty" yaml:"limit,omitempty" validate:"required,min=1,max=10"`
+	InitialDelay  string   `json:"initialDelay,omitempty" yaml:"initialDelay,omitempty" validate:"duration"`
+	MaxDelay      string   `json:"maxDelay,omitempty" yaml:"maxDelay,omitempty" validate:"duration"`
+	ScalingFactor float64  `json:"scalingFactor,omitempty" yaml:"scalingFactor,omitempty" validate:"omitempty,min=1,max=10"`
+	RetryOn       []string `json:"retryOn,omitempty" yaml:"retryOn,omitempty"`
 }
 
 type Limits struct {
@@ -179,6 +199,10 @@ func (i Task) toTask() *tork.Task {
 			Password: i.Registry.Password,
 		}
 	}
+	var artifacts *tork.TaskArtifacts
+	if i.Artifacts != nil {
+		artifacts = i.Artifacts.toTaskArtifacts()
+	}
 	ports := make([]*tork.Port, len(i.Ports))
 	for ix, p := range i.Ports {
 		ports[ix] = &tork.Port{
@@ -186,33 +210,37 @@ func (i Task) toTask() *tork.Task {
 		}
 	}
 	return &tork.Task{
-		Name:        i.Name,
-		Description: i.Description,
-		CMD:         i.CMD,
-		Entrypoint:  i.Entrypoint,
-		Run:         i.Run,
-		Image:       i.Image,
-		Registry:    registry,
-		Env:         i.Env,
-		Files:       i.Files,
-		Queue:       i.Queue,
-		Pre:         pre,
-		Post:        post,
-		Mounts:      toMounts(i.Mounts),
-		Networks:    i.Networks,
-		Retry:       retry,
-		Limits:      limits,
-		Timeout:     i.Timeout,
-		Var:         i.Var,
-		If:          i.If,
-		Parallel:    parallel,
-		Each:        each,
-		SubJob:      subjob,
-		GPUs:        i.GPUs,
-		Tags:        i.Tags,
-		Workdir:     i.Workdir,
-		Priority:    i.Priority,
-		Ports:       ports,
+		Name:         i.Name,
+		Description:  i.Description,
+		CMD:          i.CMD,
+		Entrypoint:   i.Entrypoint,
+		Run:          i.Run,
+		Image:        i.Image,
+		Registry:     registry,
+		Env:          i.Env,
+		Files:        i.Files,
+		Queue:        i.Queue,
+		Pre:          pre,
+		Post:         post,
+		Mounts:       toMounts(i.Mounts),
+		Networks:     i.Networks,
+		Retry:        retry,
+		Limits:       limits,
+		Timeout:      i.Timeout,
+		Var:          i.Var,
+		If:           i.If,
+		DependsOn:    i.DependsOn,
+		Parallel:     parallel,
+		Each:         each,
+		SubJob:       subjob,
+		GPUs:         i.GPUs,
+		Tags:         i.Tags,
+		Workdir:      i.Workdir,
+		Priority:     i.Priority,
+		Ports:        ports,
+		Artifacts:    artifacts,
+		NodeSelector: maps.Clone(i.NodeSelector),
+		Affinity:     maps.Clone(i.Affinity),
 	}
 }
 
@@ -249,6 +277,28 @@ func (l *Limits) toTaskLimits() *tork.TaskLimits {
 
 func (r *Retry) toTaskRetry() *tork.TaskRetry {
 	return &tork.TaskRetry{
-		Limit: r.Limit,
+		Limit:         r.Limit,
+		InitialDelay:  r.InitialDelay,
+		MaxDelay:      r.MaxDelay,
+		ScalingFactor: r.ScalingFactor,
+		RetryOn:       r.RetryOn,
 	}
 }
+
+func (a *Artifacts) toTaskArtifacts() *tork.TaskArtifacts {
+	return &tork.TaskArtifacts{
+		Outputs: toTaskArtifacts(a.Outputs),
+		Inputs:  toTaskArtifacts(a.Inputs),
+	}
+}
+
+func toTaskArtifacts(as []Artifact) []tork.TaskArtifact {
+	result := make([]tork.TaskArtifact, len(as))
+	for i, a := range as {
+		result[i] = tork.TaskArtifact{
+			Name: a.Name,
+			Path: a.Path,
+		}
+	}
+	return result
+}
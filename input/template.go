@@ -0,0 +1,85 @@
+package input
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+	"golang.org/x/exp/maps"
+)
+
+// JobTemplate is a reusable job definition from which
+// jobs can be submitted by reference (name@version).
+type JobTemplate struct {
+	Name        string       `json:"name,omitempty" yaml:"name,omitempty" validate:"required,max=64,refname"`
+	Description string       `json:"description,omitempty" yaml:"description,omitempty"`
+	Permissions []Permission `json:"permissions,omitempty" yaml:"permissions,omitempty" validate:"dive"`
+	Job         Job          `json:"job" yaml:"job"`
+}
+
+func (t *JobTemplate) ToJobTemplate() (*tork.JobTemplate, error) {
+	def, err := json.Marshal(t.Job)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error serializing template job")
+	}
+	perms := make([]*tork.Permission, len(t.Permissions))
+	for i, p := range t.Permissions {
+		perms[i] = p.toPermission()
+	}
+	return &tork.JobTemplate{
+		ID:          uuid.NewUUID(),
+		Name:        t.Name,
+		Description: t.Description,
+		CreatedAt:   time.Now().UTC(),
+		Permissions: perms,
+		Job:         def,
+	}, nil
+}
+
+// ExpandTemplate replaces the definition of the job with the one
+// of the given template. The inputs and secrets of the job are
+// merged into the template's, and any other property set on the
+// job overrides or complements the one of the template.
+func (ji *Job) ExpandTemplate(t *tork.JobTemplate) error {
+	if len(ji.Tasks) > 0 || ji.Output != "" || ji.Defaults != nil || len(ji.InputSchema) > 0 {
+		return errors.Errorf("a job created from a template can't specify tasks, output, defaults or an input schema")
+	}
+	var def Job
+	if err := json.Unmarshal(t.Job, &def); err != nil {
+		return errors.Wrapf(err, "error deserializing template %s", t.Ref())
+	}
+	def.id = ji.ID()
+	def.Template = ""
+	if ji.Name != "" {
+		def.Name = ji.Name
+	}
+	if ji.Description != "" {
+		def.Description = ji.Description
+	}
+	if len(ji.Inputs) > 0 {
+		if def.Inputs == nil {
+			def.Inputs = make(map[string]string)
+		}
+		maps.Copy(def.Inputs, ji.Inputs)
+	}
+	if len(ji.Secrets) > 0 {
+		if def.Secrets == nil {
+			def.Secrets = make(map[string]string)
+		}
+		maps.Copy(def.Secrets, ji.Secrets)
+	}
+	def.Tags = append(def.Tags, ji.Tags...)
+	def.Webhooks = append(def.Webhooks, ji.Webhooks...)
+	def.Permissions = append(def.Permissions, ji.Permissions...)
+	if ji.AutoDelete != nil {
+		def.AutoDelete = ji.AutoDelete
+	}
+	if ji.Concurrency != nil {
+		def.Concurrency = ji.Concurrency
+	}
+	def.IdempotencyKey = ji.IdempotencyKey
+	*ji = def
+	return nil
+}
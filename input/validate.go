@@ -2,36 +2,98 @@ package input
 
 import (
 	"context"
+	"fmt"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/runabol/tork"
 	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/internal/cron"
 	"github.com/runabol/tork/internal/eval"
 	"github.com/runabol/tork/mq"
 )
 
 var (
-	mountPattern = regexp.MustCompile(`^[-/\.0-9a-zA-Z_/= ]+$`)
+	mountPattern   = regexp.MustCompile(`^[-/\.0-9a-zA-Z_/= ]+$`)
+	refNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][-a-zA-Z0-9_.]*$`)
 )
 
 func (ji Job) Validate(ds datastore.Datastore) error {
+	validate, err := newValidator(ds)
+	if err != nil {
+		return err
+	}
+	return validate.Struct(ji)
+}
+
+func (sj ScheduledJob) Validate(ds datastore.Datastore) error {
+	validate, err := newValidator(ds)
+	if err != nil {
+		return err
+	}
+	return validate.Struct(sj)
+}
+
+func (t JobTemplate) Validate(ds datastore.Datastore) error {
+	validate, err := newValidator(ds)
+	if err != nil {
+		return err
+	}
+	return validate.Struct(t)
+}
+
+func (lt LibraryTask) Validate(ds datastore.Datastore) error {
+	validate, err := newValidator(ds)
+	if err != nil {
+		return err
+	}
+	return validate.Struct(lt)
+}
+
+func newValidator(ds datastore.Datastore) (*validator.Validate, error) {
 	validate := validator.New()
 	if err := validate.RegisterValidation("duration", validateDuration); err != nil {
-		return err
+		return nil, err
 	}
 	if err := validate.RegisterValidation("queue", validateQueue); err != nil {
-		return err
+		return nil, err
 	}
 	if err := validate.RegisterValidation("expr", validateExpr); err != nil {
-		return err
+		return nil, err
+	}
+	if err := validate.RegisterValidation("cron", validateCron); err != nil {
+		return nil, err
+	}
+	if err := validate.RegisterValidation("refname", validateRefName); err != nil {
+		return nil, err
 	}
 	validate.RegisterStructValidation(validateMount, Mount{})
 	validate.RegisterStructValidation(taskInputValidation, Task{})
+	validate.RegisterStructValidation(jobInputValidation, Job{})
+	validate.RegisterStructValidation(subJobInputValidation, SubJob{})
 	validate.RegisterStructValidation(validatePermission(ds), Permission{})
-	return validate.Struct(ji)
+	validate.RegisterStructValidation(templateValidation, JobTemplate{})
+	validate.RegisterStructValidation(libraryTaskValidation, LibraryTask{})
+	return validate, nil
+}
+
+func validateCron(fl validator.FieldLevel) bool {
+	v := fl.Field().String()
+	if v == "" {
+		return true
+	}
+	return cron.Valid(v)
+}
+
+func validateRefName(fl validator.FieldLevel) bool {
+	v := fl.Field().String()
+	if v == "" {
+		return true
+	}
+	return refNamePattern.MatchString(v)
 }
 
 func validateExpr(fl validator.FieldLevel) bool {
@@ -109,6 +171,14 @@ func validateQueue(fl validator.FieldLevel) bool {
 func taskInputValidation(sl validator.StructLevel) {
 	taskTypeValidation(sl)
 	compositeTaskValidation(sl)
+	usesValidation(sl)
+}
+
+func usesValidation(sl validator.StructLevel) {
+	t := sl.Current().Interface().(Task)
+	if len(t.With) > 0 && t.Uses == "" {
+		sl.ReportError(t.With, "with", "With", "usesrequired", "")
+	}
 }
 
 func taskTypeValidation(sl validator.StructLevel) {
@@ -172,4 +242,182 @@ func compositeTaskValidation(sl validator.StructLevel) {
 	if t.Timeout != "" {
 		sl.ReportError(t.Timeout, "timeout", "Timeout", "invalidcompositetask", "")
 	}
+	if t.Artifacts != nil {
+		sl.ReportError(t.Artifacts, "artifacts", "Artifacts", "invalidcompositetask", "")
+	}
+}
+
+func jobInputValidation(sl validator.StructLevel) {
+	ji := sl.Current().Interface().(Job)
+	taskDependencyValidation(sl, ji.Tasks)
+	inputSchemaValidation(sl, ji)
+}
+
+// inputSchemaValidation ensures that the inputs of a job
+// conform to its input schema, if it declares one.
+func inputSchemaValidation(sl validator.StructLevel, ji Job) {
+	if len(ji.InputSchema) == 0 {
+		return
+	}
+	// required inputs are only provided when
+	// a job is submitted from a template
+	_, isTemplate := sl.Parent().Interface().(JobTemplate)
+	for name := range ji.Inputs {
+		if _, ok := ji.InputSchema[name]; !ok {
+			sl.ReportError(ji.Inputs, fmt.Sprintf("inputs[%s]", name), fmt.Sprintf("Inputs[%s]", name), "unknowninput", "")
+		}
+	}
+	for name, spec := range ji.InputSchema {
+		var pattern *regexp.Regexp
+		if spec.Pattern != "" {
+			p, err := regexp.Compile(spec.Pattern)
+			if err != nil {
+				sl.ReportError(spec.Pattern, fmt.Sprintf("inputSchema[%s].pattern", name), fmt.Sprintf("InputSchema[%s].Pattern", name), "regexp", "")
+				continue
+			}
+			pattern = p
+		}
+		if spec.Default != "" {
+			if tag := validateInput(spec, pattern, spec.Default); tag != "" {
+				sl.ReportError(spec.Default, fmt.Sprintf("inputSchema[%s].default", name), fmt.Sprintf("InputSchema[%s].Default", name), tag, "")
+			}
+		}
+		v, ok := ji.Inputs[name]
+		if !ok {
+			if spec.Required && spec.Default == "" && !isTemplate {
+				sl.ReportError(ji.Inputs, fmt.Sprintf("inputs[%s]", name), fmt.Sprintf("Inputs[%s]", name), "required", "")
+			}
+			continue
+		}
+		if tag := validateInput(spec, pattern, v); tag != "" {
+			sl.ReportError(v, fmt.Sprintf("inputs[%s]", name), fmt.Sprintf("Inputs[%s]", name), tag, "")
+		}
+	}
+}
+
+// validateInput returns the tag of the first constraint
+// which the given input value violates, if any.
+func validateInput(spec InputSpec, pattern *regexp.Regexp, v string) string {
+	if _, err := tork.ParseInput(tork.InputType(spec.Type), v); err != nil {
+		return spec.Type
+	}
+	if len(spec.Enum) > 0 && !slices.Contains(spec.Enum, v) {
+		return "oneof"
+	}
+	if pattern != nil && !pattern.MatchString(v) {
+		return "pattern"
+	}
+	return ""
+}
+
+func templateValidation(sl validator.StructLevel) {
+	t := sl.Current().Interface().(JobTemplate)
+	if t.Job.Template != "" {
+		sl.ReportError(t.Job.Template, "job.template", "Job.Template", "notemplate", "")
+	}
+}
+
+func libraryTaskValidation(sl validator.StructLevel) {
+	lt := sl.Current().Interface().(LibraryTask)
+	if lt.Task.Uses != "" {
+		sl.ReportError(lt.Task.Uses, "task.uses", "Task.Uses", "nouses", "")
+	}
+	for name, spec := range lt.Params {
+		var pattern *regexp.Regexp
+		if spec.Pattern != "" {
+			p, err := regexp.Compile(spec.Pattern)
+			if err != nil {
+				sl.ReportError(spec.Pattern, fmt.Sprintf("params[%s].pattern", name), fmt.Sprintf("Params[%s].Pattern", name), "regexp", "")
+				continue
+			}
+			pattern = p
+		}
+		if spec.Default != "" {
+			if tag := validateInput(spec, pattern, spec.Default); tag != "" {
+				sl.ReportError(spec.Default, fmt.Sprintf("params[%s].default", name), fmt.Sprintf("Params[%s].Default", name), tag, "")
+			}
+		}
+	}
+}
+
+func subJobInputValidation(sl validator.StructLevel) {
+	sj := sl.Current().Interface().(SubJob)
+	taskDependencyValidation(sl, sj.Tasks)
+}
+
+// taskDependencyValidation ensures that the dependencies declared
+// by a list of sibling tasks form a valid DAG: every dependency
+// must reference the var of another top-level task and the
+// graph must not contain any cycles.
+func taskDependencyValidation(sl validator.StructLevel, tasks []Task) {
+	deps := make(map[string][]string)
+	isDAG := false
+	for _, t := range tasks {
+		if len(t.DependsOn) > 0 {
+			isDAG = true
+		}
+		if t.Parallel != nil {
+			for _, pt := range t.Parallel.Tasks {
+				if len(pt.DependsOn) > 0 {
+					sl.ReportError(pt.DependsOn, "dependsOn", "DependsOn", "nesteddependency", "")
+				}
+			}
+		}
+		if t.Each != nil && len(t.Each.Task.DependsOn) > 0 {
+			sl.ReportError(t.Each.Task.DependsOn, "dependsOn", "DependsOn", "nesteddependency", "")
+		}
+	}
+	if !isDAG {
+		return
+	}
+	for _, t := range tasks {
+		if t.Var == "" {
+			continue
+		}
+		if _, ok := deps[t.Var]; ok {
+			sl.ReportError(t.Var, "var", "Var", "duplicatevar", t.Var)
+		}
+		deps[t.Var] = t.DependsOn
+	}
+	for _, t := range tasks {
+		for _, dep := range t.DependsOn {
+			if _, ok := deps[dep]; !ok {
+				sl.ReportError(t.DependsOn, "dependsOn", "DependsOn", "unknowndependency", dep)
+			}
+		}
+	}
+	if hasDependencyCycle(deps) {
+		sl.ReportError(tasks, "tasks", "Tasks", "dependencycycle", "")
+	}
+}
+
+func hasDependencyCycle(deps map[string][]string) bool {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var visit func(v string) bool
+	visit = func(v string) bool {
+		switch state[v] {
+		case visiting:
+			return true
+		case visited:
+			return false
+		}
+		state[v] = visiting
+		for _, dep := range deps[v] {
+			if visit(dep) {
+				return true
+			}
+		}
+		state[v] = visited
+		return false
+	}
+	for v := range deps {
+		if visit(v) {
+			return true
+		}
+	}
+	return false
 }
@@ -1,6 +1,7 @@
 package input
 
 import (
+	"context"
 	"strings"
 	"testing"
 
@@ -25,6 +26,340 @@ func TestValidateMinJob(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestValidateInputSchema(t *testing.T) {
+	newJob := func(inputs map[string]string) Job {
+		return Job{
+			Name: "test job",
+			Tasks: []Task{
+				{
+					Name:  "test task",
+					Image: "some:image",
+				},
+			},
+			Inputs: inputs,
+			InputSchema: map[string]InputSpec{
+				"replicas": {Type: "int", Default: "1"},
+				"env":      {Required: true, Enum: []string{"dev", "prod"}},
+				"branch":   {Pattern: "^[a-z0-9-]+$"},
+				"targets":  {Type: "list"},
+				"config":   {Type: "json"},
+				"debug":    {Type: "bool"},
+			},
+		}
+	}
+	ds := inmemory.NewInMemoryDatastore()
+
+	j := newJob(map[string]string{"env": "prod"})
+	assert.NoError(t, j.Validate(ds))
+
+	j = newJob(map[string]string{
+		"env":      "dev",
+		"replicas": "3",
+		"branch":   "main",
+		"targets":  "a,b",
+		"config":   `{"a":1}`,
+		"debug":    "true",
+	})
+	assert.NoError(t, j.Validate(ds))
+
+	// missing required input
+	j = newJob(map[string]string{})
+	err := j.Validate(ds)
+	assert.ErrorContains(t, err, "inputs[env]")
+
+	// not one of the enum values
+	j = newJob(map[string]string{"env": "staging"})
+	err = j.Validate(ds)
+	assert.ErrorContains(t, err, "'oneof' tag")
+
+	// bad type
+	j = newJob(map[string]string{"env": "dev", "replicas": "three"})
+	err = j.Validate(ds)
+	assert.ErrorContains(t, err, "'int' tag")
+
+	j = newJob(map[string]string{"env": "dev", "config": "{"})
+	err = j.Validate(ds)
+	assert.ErrorContains(t, err, "'json' tag")
+
+	// pattern mismatch
+	j = newJob(map[string]string{"env": "dev", "branch": "Feature/X"})
+	err = j.Validate(ds)
+	assert.ErrorContains(t, err, "'pattern' tag")
+
+	// undeclared input
+	j = newJob(map[string]string{"env": "dev", "other": "x"})
+	err = j.Validate(ds)
+	assert.ErrorContains(t, err, "'unknowninput' tag")
+
+	// bad schema
+	j = newJob(map[string]string{"env": "dev"})
+	j.InputSchema["replicas"] = InputSpec{Type: "int", Default: "many"}
+	err = j.Validate(ds)
+	assert.ErrorContains(t, err, "inputSchema[replicas].default")
+
+	j = newJob(map[string]string{"env": "dev"})
+	j.InputSchema["branch"] = InputSpec{Pattern: "("}
+	err = j.Validate(ds)
+	assert.ErrorContains(t, err, "'regexp' tag")
+
+	j = newJob(map[string]string{"env": "dev"})
+	j.InputSchema["ratio"] = InputSpec{Type: "float"}
+	err = j.Validate(ds)
+	assert.Error(t, err)
+}
+
+func TestInputSchemaToJob(t *testing.T) {
+	ji := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:  "test task",
+				Image: "some:image",
+			},
+		},
+		Inputs: map[string]string{"env": "prod"},
+		InputSchema: map[string]InputSpec{
+			"env":      {Required: true},
+			"replicas": {Type: "int", Default: "2"},
+			"debug":    {Type: "bool"},
+		},
+	}
+	j := ji.ToJob()
+	assert.Equal(t, map[string]string{"env": "prod", "replicas": "2"}, j.Inputs)
+	assert.Equal(t, map[string]string{"env": "prod", "replicas": "2"}, j.Context.Inputs)
+	assert.Equal(t, map[string]tork.InputType{
+		"replicas": tork.InputTypeInt,
+		"debug":    tork.InputTypeBool,
+	}, j.Context.InputTypes)
+	assert.Equal(t, map[string]tork.InputSpec{
+		"env":      {Required: true},
+		"replicas": {Type: tork.InputTypeInt},
+		"debug":    {Type: tork.InputTypeBool},
+	}, j.Context.InputSchema)
+	inputs := j.Context.AsMap()["inputs"].(map[string]any)
+	assert.Equal(t, 2, inputs["replicas"])
+	// the submitted inputs are left untouched
+	assert.Equal(t, map[string]string{"env": "prod"}, ji.Inputs)
+}
+
+func TestValidateJobTemplate(t *testing.T) {
+	ds := inmemory.NewInMemoryDatastore()
+	tmpl := JobTemplate{
+		Name: "deploy",
+		Job: Job{
+			Name: "deploy job",
+			Tasks: []Task{
+				{
+					Name:  "test task",
+					Image: "some:image",
+				},
+			},
+			InputSchema: map[string]InputSpec{
+				"env": {Required: true},
+			},
+		},
+	}
+	// required inputs are only checked on submission
+	assert.NoError(t, tmpl.Validate(ds))
+
+	tmpl.Name = "deploy@1"
+	assert.Error(t, tmpl.Validate(ds))
+
+	tmpl.Name = ""
+	assert.Error(t, tmpl.Validate(ds))
+
+	tmpl.Name = "deploy"
+	tmpl.Job.Template = "other"
+	assert.Error(t, tmpl.Validate(ds))
+
+	tmpl.Job.Template = ""
+	tmpl.Job.Tasks = nil
+	assert.Error(t, tmpl.Validate(ds))
+}
+
+func TestExpandTemplate(t *testing.T) {
+	ds := inmemory.NewInMemoryDatastore()
+	ti := JobTemplate{
+		Name: "deploy",
+		Job: Job{
+			Name: "deploy job",
+			Tags: []string{"deploy"},
+			Tasks: []Task{
+				{
+					Name:  "test task",
+					Image: "some:image",
+				},
+			},
+			Inputs: map[string]string{"region": "us-east-1"},
+			InputSchema: map[string]InputSpec{
+				"env":    {Required: true},
+				"region": {},
+			},
+		},
+	}
+	tmpl, err := ti.ToJobTemplate()
+	assert.NoError(t, err)
+	tmpl.Version = 2
+
+	ji := Job{
+		Template: tmpl.Ref(),
+		Tags:     []string{"prod"},
+		Inputs:   map[string]string{"env": "prod"},
+	}
+	id := ji.ID()
+	assert.NoError(t, ji.ExpandTemplate(tmpl))
+	assert.NoError(t, ji.Validate(ds))
+	assert.Equal(t, id, ji.ID())
+	assert.Equal(t, "", ji.Template)
+
+	j := ji.ToJob()
+	assert.Equal(t, "deploy job", j.Name)
+	assert.Equal(t, []string{"deploy", "prod"}, j.Tags)
+	assert.Equal(t, map[string]string{"env": "prod", "region": "us-east-1"}, j.Inputs)
+	assert.Len(t, j.Tasks, 1)
+
+	// required inputs are enforced on the expanded job
+	ji = Job{Template: tmpl.Ref()}
+	assert.NoError(t, ji.ExpandTemplate(tmpl))
+	assert.Error(t, ji.Validate(ds))
+
+	ji = Job{
+		Template: tmpl.Ref(),
+		Tasks: []Task{
+			{
+				Name:  "other task",
+				Image: "some:image",
+			},
+		},
+	}
+	assert.Error(t, ji.ExpandTemplate(tmpl))
+}
+
+func TestValidateLibraryTask(t *testing.T) {
+	ds := inmemory.NewInMemoryDatastore()
+	lt := LibraryTask{
+		Name: "lint",
+		Params: map[string]InputSpec{
+			"path":    {Required: true},
+			"timeout": {Type: "int", Default: "5"},
+		},
+		Task: Task{
+			Name:  "lint",
+			Image: "golangci/golangci-lint",
+			Run:   "golangci-lint run --timeout {{ with.timeout }}m {{ with.path }}",
+		},
+	}
+	assert.NoError(t, lt.Validate(ds))
+
+	lt.Params["timeout"] = InputSpec{Type: "int", Default: "five"}
+	assert.Error(t, lt.Validate(ds))
+
+	lt.Params["timeout"] = InputSpec{Type: "int"}
+	lt.Task.Uses = "other"
+	assert.Error(t, lt.Validate(ds))
+
+	lt.Task.Uses = ""
+	lt.Name = "lint@2"
+	assert.Error(t, lt.Validate(ds))
+
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:  "test task",
+				Image: "some:image",
+				With:  map[string]string{"path": "./..."},
+			},
+		},
+	}
+	assert.Error(t, j.Validate(ds))
+}
+
+func TestExpandUses(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	li := LibraryTask{
+		Name: "lint",
+		Params: map[string]InputSpec{
+			"path":    {Required: true},
+			"timeout": {Type: "int", Default: "5"},
+			"fix":     {Type: "bool"},
+			"args":    {Type: "list"},
+		},
+		Task: Task{
+			Name:  "lint",
+			Image: "golangci/golangci-lint",
+			Run:   "golangci-lint run --timeout {{ with.timeout }}m {{ with.fix ? '--fix' : '' }} {{ with.path }}",
+			Env: map[string]string{
+				"BRANCH": "{{ inputs.branch }}",
+				"TARGET": "{{ with.path + inputs.suffix }}",
+				"ARGS":   "{{ with.args }}",
+			},
+			Retry: &Retry{Limit: 2},
+		},
+	}
+	for i := 0; i < 2; i++ {
+		lt, err := li.ToLibraryTask()
+		assert.NoError(t, err)
+		assert.NoError(t, ds.CreateLibraryTask(ctx, lt))
+	}
+
+	ji := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name: "lint code",
+				Uses: "lint@v2",
+				With: map[string]string{"path": "./...", "fix": "true", "args": "-v, --fast"},
+				Env:  map[string]string{"GOFLAGS": "-mod=mod"},
+			},
+			{
+				Name: "parallel",
+				Parallel: &Parallel{
+					Tasks: []Task{{
+						Name: "lint other",
+						Uses: "lint",
+						With: map[string]string{"path": "./other/..."},
+					}},
+				},
+			},
+		},
+	}
+	assert.NoError(t, ji.ExpandUses(ctx, ds))
+	assert.NoError(t, ji.Validate(ds))
+
+	t1 := ji.Tasks[0]
+	assert.Equal(t, "lint code", t1.Name)
+	assert.Equal(t, "golangci/golangci-lint", t1.Image)
+	assert.Equal(t, "golangci-lint run --timeout 5m --fix ./...", t1.Run)
+	assert.Equal(t, map[string]string{
+		"BRANCH":  "{{ inputs.branch }}",
+		"TARGET":  `{{ "./..." + inputs.suffix }}`,
+		"ARGS":    `["-v","--fast"]`,
+		"GOFLAGS": "-mod=mod",
+	}, t1.Env)
+	assert.Equal(t, 2, t1.Retry.Limit)
+	assert.Empty(t, t1.Uses)
+	assert.Empty(t, t1.With)
+
+	t2 := ji.Tasks[1].Parallel.Tasks[0]
+	assert.Equal(t, "lint other", t2.Name)
+	assert.Equal(t, "golangci-lint run --timeout 5m  ./other/...", t2.Run)
+	assert.Equal(t, "[]", t2.Env["ARGS"])
+
+	bad := []Task{
+		{Name: "missing param", Uses: "lint"},
+		{Name: "unknown param", Uses: "lint", With: map[string]string{"path": ".", "other": "x"}},
+		{Name: "invalid param", Uses: "lint", With: map[string]string{"path": ".", "timeout": "x"}},
+		{Name: "unknown version", Uses: "lint@v3", With: map[string]string{"path": "."}},
+		{Name: "image", Uses: "lint", Image: "some:image", With: map[string]string{"path": "."}},
+	}
+	for _, bt := range bad {
+		ji := Job{Name: "test job", Tasks: []Task{bt}}
+		assert.Error(t, ji.ExpandUses(ctx, ds), bt.Name)
+	}
+}
+
 func TestValidateJobNoTasks(t *testing.T) {
 	j := Job{
 		Name:  "test job",
@@ -61,6 +396,19 @@ func TestValidateQueue(t *testing.T) {
 	err = j.Validate(inmemory.NewInMemoryDatastore())
 	assert.Error(t, err)
 
+	j = Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:  "test task",
+				Image: "some:image",
+				Queue: mq.NodeQueue("788222"),
+			},
+		},
+	}
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+
 	j = Job{
 		Name: "test job",
 		Tasks: []Task{
@@ -203,6 +551,74 @@ func TestValidateJobTaskRetry(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestValidateJobTaskRetryBackoff(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:  "test task",
+				Image: "some:image",
+				Retry: &Retry{
+					Limit:         5,
+					InitialDelay:  "1s",
+					MaxDelay:      "1m",
+					ScalingFactor: 2,
+					RetryOn:       []string{"137", "connection refused"},
+				},
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	j.Tasks[0].Retry.InitialDelay = "1 second"
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+
+	j.Tasks[0].Retry.InitialDelay = "1s"
+	j.Tasks[0].Retry.ScalingFactor = 0.5
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+}
+
+func TestValidateJobConcurrency(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Concurrency: &Concurrency{
+			Key:        "deploy-{{ inputs.env }}",
+			Limit:      2,
+			OnConflict: "cancel-previous",
+		},
+		Tasks: []Task{
+			{
+				Name:  "test task",
+				Image: "some:image",
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	j.Concurrency.OnConflict = "ignore"
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+
+	j.Concurrency.OnConflict = ""
+	j.Concurrency.Key = ""
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+
+	j.Concurrency.Key = "deploy-prod"
+	j.Concurrency.Limit = 0
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	tj := j.ToJob()
+	assert.Equal(t, "deploy-prod", tj.Concurrency.Key)
+	assert.Equal(t, 1, tj.Concurrency.Limit)
+	assert.Equal(t, tork.ConcurrencyQueue, tj.Concurrency.OnConflict)
+}
+
 func TestValidateJobTaskTimeout(t *testing.T) {
 	j := Job{
 		Name: "test job",
@@ -645,3 +1061,186 @@ func TestValidateWebhook(t *testing.T) {
 	err = j.Validate(inmemory.NewInMemoryDatastore())
 	assert.Error(t, err)
 }
+
+func TestValidateScheduledJob(t *testing.T) {
+	j := ScheduledJob{
+		Job: Job{
+			Name: "test job",
+			Tasks: []Task{
+				{
+					Name:  "test task",
+					Image: "some:image",
+				},
+			},
+		},
+		Cron: "0 * * * *",
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	j.Cron = "not a cron"
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+
+	j.Cron = ""
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+}
+
+func TestValidateTaskArtifacts(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:  "task a",
+				Image: "some:image",
+				Artifacts: &Artifacts{
+					Outputs: []Artifact{{Name: "report", Path: "report.txt"}},
+				},
+			},
+			{
+				Name:  "task b",
+				Image: "some:image",
+				Artifacts: &Artifacts{
+					Inputs: []Artifact{{Name: "report", Path: "/data/report.txt"}},
+				},
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	j.Tasks[1].Artifacts.Inputs[0].Path = ""
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+
+	j.Tasks[1].Artifacts.Inputs[0].Path = "/data/report.txt"
+	j.Tasks[1].Artifacts.Inputs[0].Name = ""
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+
+	j.Tasks[1] = Task{
+		Name: "task b",
+		Parallel: &Parallel{
+			Tasks: []Task{{Name: "task c", Image: "some:image"}},
+		},
+		Artifacts: &Artifacts{
+			Outputs: []Artifact{{Name: "report", Path: "report.txt"}},
+		},
+	}
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalidcompositetask")
+}
+
+func TestValidateJobDependencies(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:  "task a",
+				Image: "some:image",
+				Var:   "a",
+			},
+			{
+				Name:  "task b",
+				Image: "some:image",
+				Var:   "b",
+			},
+			{
+				Name:      "task c",
+				Image:     "some:image",
+				DependsOn: []string{"a", "b"},
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	j.Tasks[2].DependsOn = []string{"a", "nosuch"}
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknowndependency")
+}
+
+func TestValidateJobDependencyCycle(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:      "task a",
+				Image:     "some:image",
+				Var:       "a",
+				DependsOn: []string{"c"},
+			},
+			{
+				Name:      "task b",
+				Image:     "some:image",
+				Var:       "b",
+				DependsOn: []string{"a"},
+			},
+			{
+				Name:      "task c",
+				Image:     "some:image",
+				Var:       "c",
+				DependsOn: []string{"b"},
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "dependencycycle")
+
+	j.Tasks[0].DependsOn = []string{"a"}
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "dependencycycle")
+}
+
+func TestValidateNestedDependency(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:  "task a",
+				Image: "some:image",
+				Var:   "a",
+			},
+			{
+				Name: "parallel task",
+				Parallel: &Parallel{
+					Tasks: []Task{
+						{
+							Name:      "task b",
+							Image:     "some:image",
+							DependsOn: []string{"a"},
+						},
+					},
+				},
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "nesteddependency")
+}
+
+func TestValidateNodeSelector(t *testing.T) {
+	j := Job{
+		Name: "test job",
+		Tasks: []Task{
+			{
+				Name:         "test task",
+				Image:        "some:image",
+				NodeSelector: map[string]string{"zone": "a"},
+				Affinity:     map[string]string{"arch": "arm64"},
+			},
+		},
+	}
+	err := j.Validate(inmemory.NewInMemoryDatastore())
+	assert.NoError(t, err)
+
+	j.Tasks[0].NodeSelector = map[string]string{"": "a"}
+	err = j.Validate(inmemory.NewInMemoryDatastore())
+	assert.Error(t, err)
+}
@@ -0,0 +1,105 @@
+package tork
+
+import (
+	"encoding/json"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// InputType is the declared type of a job input. Inputs
+// are always submitted as strings and are converted to
+// their declared type when evaluating expressions.
+type InputType string
+
+const (
+	InputTypeString InputType = "string"
+	InputTypeInt    InputType = "int"
+	InputTypeBool   InputType = "bool"
+	InputTypeList   InputType = "list"
+	InputTypeJSON   InputType = "json"
+)
+
+// InputSpec holds the declared type and constraints of
+// a job input, so inputs which are provided after the job
+// was submitted -- e.g. when re-running it -- can be
+// validated the same way as the original ones.
+type InputSpec struct {
+	Type     InputType `json:"type,omitempty"`
+	Required bool      `json:"required,omitempty"`
+	Enum     []string  `json:"enum,omitempty"`
+	Pattern  string    `json:"pattern,omitempty"`
+}
+
+// Validate checks the value of an input against its spec.
+func (s InputSpec) Validate(v string) error {
+	if s.Required && v == "" {
+		return errors.New("value is required")
+	}
+	if _, err := ParseInput(s.Type, v); err != nil {
+		return err
+	}
+	if len(s.Enum) > 0 && !slices.Contains(s.Enum, v) {
+		return errors.Errorf("value must be one of: %s", strings.Join(s.Enum, ", "))
+	}
+	if s.Pattern != "" {
+		p, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return errors.Wrapf(err, "invalid pattern: %s", s.Pattern)
+		}
+		if !p.MatchString(v) {
+			return errors.Errorf("value does not match pattern: %s", s.Pattern)
+		}
+	}
+	return nil
+}
+
+// ParseInput converts the raw value of an input to the given type.
+// Lists are expressed either as a JSON array or as a comma-separated
+// list of values.
+func ParseInput(t InputType, v string) (any, error) {
+	switch t {
+	case "", InputTypeString:
+		return v, nil
+	case InputTypeInt:
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return nil, errors.Errorf("invalid int value: %s", v)
+		}
+		return n, nil
+	case InputTypeBool:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return nil, errors.Errorf("invalid bool value: %s", v)
+		}
+		return b, nil
+	case InputTypeList:
+		v = strings.TrimSpace(v)
+		if strings.HasPrefix(v, "[") {
+			var l []any
+			if err := json.Unmarshal([]byte(v), &l); err != nil {
+				return nil, errors.Errorf("invalid list value: %s", v)
+			}
+			return l, nil
+		}
+		l := make([]any, 0)
+		if v == "" {
+			return l, nil
+		}
+		for _, item := range strings.Split(v, ",") {
+			l = append(l, strings.TrimSpace(item))
+		}
+		return l, nil
+	case InputTypeJSON:
+		var o any
+		if err := json.Unmarshal([]byte(v), &o); err != nil {
+			return nil, errors.Errorf("invalid json value: %s", v)
+		}
+		return o, nil
+	default:
+		return nil, errors.Errorf("unknown input type: %s", t)
+	}
+}
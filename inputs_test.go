@@ -0,0 +1,96 @@
+package tork_test
+
+import (
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseInput(t *testing.T) {
+	tests := []struct {
+		typ     tork.InputType
+		value   string
+		want    any
+		wantErr bool
+	}{
+		{typ: "", value: "hello", want: "hello"},
+		{typ: tork.InputTypeString, value: "hello", want: "hello"},
+		{typ: tork.InputTypeInt, value: "42", want: 42},
+		{typ: tork.InputTypeInt, value: " 42 ", want: 42},
+		{typ: tork.InputTypeInt, value: "4.2", wantErr: true},
+		{typ: tork.InputTypeBool, value: "true", want: true},
+		{typ: tork.InputTypeBool, value: "0", want: false},
+		{typ: tork.InputTypeBool, value: "nope", wantErr: true},
+		{typ: tork.InputTypeList, value: "a, b,c", want: []any{"a", "b", "c"}},
+		{typ: tork.InputTypeList, value: `["a",1]`, want: []any{"a", float64(1)}},
+		{typ: tork.InputTypeList, value: "", want: []any{}},
+		{typ: tork.InputTypeList, value: "[a", wantErr: true},
+		{typ: tork.InputTypeJSON, value: `{"a":1}`, want: map[string]any{"a": float64(1)}},
+		{typ: tork.InputTypeJSON, value: `{"a":`, wantErr: true},
+		{typ: "float", value: "1.2", wantErr: true},
+	}
+	for _, test := range tests {
+		v, err := tork.ParseInput(test.typ, test.value)
+		if test.wantErr {
+			assert.Error(t, err, "%s %s", test.typ, test.value)
+		} else {
+			assert.NoError(t, err, "%s %s", test.typ, test.value)
+			assert.Equal(t, test.want, v)
+		}
+	}
+}
+
+func TestInputSpecValidate(t *testing.T) {
+	tests := []struct {
+		spec    tork.InputSpec
+		value   string
+		wantErr bool
+	}{
+		{spec: tork.InputSpec{}, value: ""},
+		{spec: tork.InputSpec{Required: true}, value: "", wantErr: true},
+		{spec: tork.InputSpec{Type: tork.InputTypeInt}, value: "42"},
+		{spec: tork.InputSpec{Type: tork.InputTypeInt}, value: "nope", wantErr: true},
+		{spec: tork.InputSpec{Enum: []string{"dev", "prod"}}, value: "prod"},
+		{spec: tork.InputSpec{Enum: []string{"dev", "prod"}}, value: "staging", wantErr: true},
+		{spec: tork.InputSpec{Pattern: "^v[0-9]+$"}, value: "v2"},
+		{spec: tork.InputSpec{Pattern: "^v[0-9]+$"}, value: "latest", wantErr: true},
+		{spec: tork.InputSpec{Pattern: "["}, value: "v2", wantErr: true},
+	}
+	for _, test := range tests {
+		err := test.spec.Validate(test.value)
+		if test.wantErr {
+			assert.Error(t, err, "%+v %s", test.spec, test.value)
+		} else {
+			assert.NoError(t, err, "%+v %s", test.spec, test.value)
+		}
+	}
+}
+
+func TestJobContextTypedInputs(t *testing.T) {
+	c := tork.JobContext{
+		Inputs: map[string]string{
+			"count":   "3",
+			"debug":   "true",
+			"name":    "hello",
+			"targets": "a,b",
+			"broken":  "not-a-number",
+		},
+		InputTypes: map[string]tork.InputType{
+			"count":   tork.InputTypeInt,
+			"debug":   tork.InputTypeBool,
+			"targets": tork.InputTypeList,
+			"broken":  tork.InputTypeInt,
+		},
+	}
+	inputs, ok := c.AsMap()["inputs"].(map[string]any)
+	assert.True(t, ok)
+	assert.Equal(t, 3, inputs["count"])
+	assert.Equal(t, true, inputs["debug"])
+	assert.Equal(t, "hello", inputs["name"])
+	assert.Equal(t, []any{"a", "b"}, inputs["targets"])
+	assert.Equal(t, "not-a-number", inputs["broken"])
+
+	untyped := tork.JobContext{Inputs: map[string]string{"count": "3"}}
+	assert.Equal(t, map[string]string{"count": "3"}, untyped.AsMap()["inputs"])
+}
@@ -12,18 +12,24 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 
+	"github.com/runabol/tork/archive"
+	"github.com/runabol/tork/artifact"
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/health"
 
 	"github.com/runabol/tork/input"
+	"github.com/runabol/tork/internal/cron"
 	"github.com/runabol/tork/internal/hash"
 	"github.com/runabol/tork/internal/httpx"
+	"github.com/runabol/tork/leader"
 	"github.com/runabol/tork/middleware/job"
 	"github.com/runabol/tork/middleware/task"
 	"github.com/runabol/tork/middleware/web"
@@ -37,28 +43,46 @@ const (
 	MIN_PORT          = 8000
 	MAX_PORT          = 8100
 	MAX_LOG_PAGE_SIZE = 100
+
+	DEFAULT_IDEMPOTENCY_WINDOW = time.Hour * 24
 )
 
+var roleSlugPattern = regexp.MustCompile(`^[a-z0-9][-a-z0-9]*$`)
+
 type HealthResponse struct {
 	Status string `json:"status"`
+	Leader string `json:"leader,omitempty"`
 }
 
 type API struct {
-	server     *http.Server
-	broker     mq.Broker
-	ds         datastore.Datastore
-	terminate  chan any
-	onReadJob  job.HandlerFunc
-	onReadTask task.HandlerFunc
+	server            *http.Server
+	broker            mq.Broker
+	ds                datastore.Datastore
+	artifacts         artifact.Store
+	archive           archive.Sink
+	idempotencyWindow time.Duration
+	elector           leader.Elector
+	hub               *hub
+	terminate         chan any
+	onReadJob         job.HandlerFunc
+	onReadTask        task.HandlerFunc
 }
 
 type Config struct {
-	Broker     mq.Broker
-	DataStore  datastore.Datastore
-	Address    string
-	Middleware Middleware
-	Endpoints  map[string]web.HandlerFunc
-	Enabled    map[string]bool
+	Broker    mq.Broker
+	DataStore datastore.Datastore
+	Artifacts artifact.Store
+	Archive   archive.Sink
+	Address   string
+	// IdempotencyWindow is how long a job submitted with an
+	// idempotency key is returned to re-submissions of the key.
+	IdempotencyWindow time.Duration
+	Middleware        Middleware
+	Endpoints         map[string]web.HandlerFunc
+	Enabled           map[string]bool
+	// Elector is used to report the
+	// current leader coordinator.
+	Elector leader.Elector
 }
 
 type Middleware struct {
@@ -90,8 +114,13 @@ func NewAPI(cfg Config) (*API, error) {
 			Addr:    cfg.Address,
 			Handler: r,
 		},
-		ds:        cfg.DataStore,
-		terminate: make(chan any),
+		ds:                cfg.DataStore,
+		artifacts:         cfg.Artifacts,
+		archive:           cfg.Archive,
+		idempotencyWindow: cfg.IdempotencyWindow,
+		elector:           cfg.Elector,
+		hub:               newHub(),
+		terminate:         make(chan any),
 		onReadJob: job.ApplyMiddleware(
 			job.NoOpHandlerFunc,
 			cfg.Middleware.Job,
@@ -102,6 +131,10 @@ func NewAPI(cfg Config) (*API, error) {
 		),
 	}
 
+	if s.idempotencyWindow <= 0 {
+		s.idempotencyWindow = DEFAULT_IDEMPOTENCY_WINDOW
+	}
+
 	// registering custom middleware
 	for _, m := range cfg.Middleware.Web {
 		r.Use(s.middlewareAdapter(m))
@@ -112,6 +145,17 @@ func NewAPI(cfg Config) (*API, error) {
 		r.Use(m)
 	}
 
+	// live updates
+	jobsEnabled, ok := cfg.Enabled["jobs"]
+	jobsEnabled = !ok || jobsEnabled
+	tasksEnabled, ok := cfg.Enabled["tasks"]
+	tasksEnabled = !ok || tasksEnabled
+	if jobsEnabled || tasksEnabled {
+		if err := s.broker.SubscribeForEvents(context.Background(), mq.TOPIC_UPDATES, s.hub.publish); err != nil {
+			return nil, errors.Wrapf(err, "error subscribing for live updates")
+		}
+	}
+
 	// built-in endpoints
 	if v, ok := cfg.Enabled["health"]; !ok || v {
 		r.GET("/health", s.health)
@@ -119,6 +163,7 @@ func NewAPI(cfg Config) (*API, error) {
 	if v, ok := cfg.Enabled["tasks"]; !ok || v {
 		r.GET("/tasks/:id", s.getTask)
 		r.GET("/tasks/:id/log", s.getTaskLog)
+		r.GET("/tasks/:id/log/stream", s.streamTaskLog)
 		r.Any("/tasks/:id/proxy/:port", s.proxy)
 		r.Any("/tasks/:id/proxy/:port/*", s.proxy)
 		r.PUT("/tasks/:id/complete", s.completeTask)
@@ -128,20 +173,60 @@ func NewAPI(cfg Config) (*API, error) {
 	}
 	if v, ok := cfg.Enabled["nodes"]; !ok || v {
 		r.GET("/nodes", s.listActiveNodes)
+		r.PUT("/nodes/:id/drain", s.drainNode)
 	}
 	if v, ok := cfg.Enabled["jobs"]; !ok || v {
 		r.POST("/jobs", s.createJob)
 		r.GET("/jobs/:id", s.getJob)
 		r.GET("/jobs/:id/log", s.getJobLog)
+		r.GET("/jobs/:id/events", s.streamJobEvents)
+		r.GET("/jobs/:id/artifacts", s.listJobArtifacts)
 		r.GET("/jobs", s.listJobs)
 		r.PUT("/jobs/:id/cancel", s.cancelJob)
 		r.PUT("/jobs/:id/restart", s.restartJob)
+		r.PUT("/jobs/:id/rerun", s.rerunJob)
+		r.PUT("/jobs/:id/pause", s.pauseJob)
+		r.PUT("/jobs/:id/resume", s.resumeJob)
+		r.POST("/scheduled-jobs", s.createScheduledJob)
+		r.GET("/scheduled-jobs", s.listScheduledJobs)
+		r.GET("/scheduled-jobs/:id", s.getScheduledJob)
+		r.PUT("/scheduled-jobs/:id/pause", s.pauseScheduledJob)
+		r.PUT("/scheduled-jobs/:id/resume", s.resumeScheduledJob)
+		r.DELETE("/scheduled-jobs/:id", s.deleteScheduledJob)
+	}
+	if v, ok := cfg.Enabled["templates"]; !ok || v {
+		r.POST("/templates", s.createJobTemplate)
+		r.GET("/templates", s.listJobTemplates)
+		r.GET("/templates/:ref", s.getJobTemplate)
+		r.DELETE("/templates/:ref", s.deleteJobTemplate)
+	}
+	if v, ok := cfg.Enabled["library"]; !ok || v {
+		r.POST("/library", s.createLibraryTask)
+		r.GET("/library", s.listLibraryTasks)
+		r.GET("/library/:ref", s.getLibraryTask)
+		r.DELETE("/library/:ref", s.deleteLibraryTask)
 	}
 	if v, ok := cfg.Enabled["metrics"]; !ok || v {
 		r.GET("/metrics", s.getMetrics)
 	}
+	if v, ok := cfg.Enabled["retention"]; !ok || v {
+		r.GET("/retention/dry-run", s.retentionDryRun)
+	}
 	if v, ok := cfg.Enabled["users"]; !ok || v {
 		r.POST("/users", s.createUser)
+		r.GET("/users", s.listUsers)
+		r.GET("/users/:id", s.getUser)
+		r.DELETE("/users/:id", s.deleteUser)
+		r.PUT("/users/:id/disable", s.disableUser)
+		r.PUT("/users/:id/enable", s.enableUser)
+		r.PUT("/users/:id/password", s.changeUserPassword)
+		r.GET("/users/:id/roles", s.listUserRoles)
+		r.PUT("/users/:id/roles/:role", s.assignRole)
+		r.DELETE("/users/:id/roles/:role", s.unassignRole)
+		r.POST("/roles", s.createRole)
+		r.GET("/roles", s.listRoles)
+		r.GET("/roles/:id", s.getRole)
+		r.DELETE("/roles/:id", s.deleteRole)
 	}
 
 	// register additional custom endpoints
@@ -206,6 +291,13 @@ func (s *API) health(c echo.Context) error {
 		WithIndicator(health.ServiceDatastore, s.ds.HealthCheck).
 		WithIndicator(health.ServiceBroker, s.broker.HealthCheck).
 		Do(c.Request().Context())
+	if s.elector != nil {
+		id, err := s.elector.Leader(c.Request().Context())
+		if err != nil {
+			log.Error().Err(err).Msg("error getting the current leader")
+		}
+		result.Leader = id
+	}
 	if result.Status == health.StatusDown {
 		return c.JSON(http.StatusServiceUnavailable, result)
 	} else {
@@ -241,6 +333,63 @@ func (s *API) listActiveNodes(c echo.Context) error {
 	return c.JSON(http.StatusOK, nodes)
 }
 
+// drainNode
+// @Summary Drain a worker node: the node stops accepting new tasks,
+// finishes the ones in flight and shuts down
+// @Tags nodes
+// @Produce application/json
+// @Success 200 {string} string "OK"
+// @Router /nodes/{id}/drain [put]
+// @Param id path string true "Node ID"
+// @Param timeout query string false "Duration after which the remaining tasks are cancelled"
+// @Failure 404 {object} echo.HTTPError
+// @Failure 400 {object} echo.HTTPError
+func (s *API) drainNode(c echo.Context) error {
+	id := c.Param("id")
+	ctx := c.Request().Context()
+	node, err := s.ds.GetNodeByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, datastore.ErrNodeNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if node.Status == tork.NodeStatusOffline {
+		return echo.NewHTTPError(http.StatusBadRequest, "node is offline")
+	}
+	u := url.URL{
+		Scheme: "http",
+		Host:   fmt.Sprintf("%s:%d", node.Hostname, node.Port),
+		Path:   "/drain",
+	}
+	if timeout := c.QueryParam("timeout"); timeout != "" {
+		if _, err := time.ParseDuration(timeout); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid timeout: %s", timeout))
+		}
+		u.RawQuery = url.Values{"timeout": []string{timeout}}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Error().Err(err).Msgf("error draining node %s", node.ID)
+		return echo.NewHTTPError(http.StatusBadGateway, "error reaching node")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return echo.NewHTTPError(http.StatusBadGateway, fmt.Sprintf("error draining node: %s", resp.Status))
+	}
+	if err := s.ds.UpdateNode(ctx, node.ID, func(u *tork.Node) error {
+		u.Status = tork.NodeStatusDraining
+		return nil
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
 // createJob
 // @Summary Create a new job
 // @Tags jobs
@@ -255,30 +404,65 @@ func (s *API) createJob(c echo.Context) error {
 	contentType := c.Request().Header.Get("content-type")
 	switch contentType {
 	case "application/json":
-		ji, err = bindJobInputJSON(c.Request().Body)
+		ji, err = bindInputJSON[input.Job](c.Request().Body)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 	case "text/yaml":
-		ji, err = bindJobInputYAML(c.Request().Body)
+		ji, err = bindInputYAML[input.Job](c.Request().Body)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 	default:
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown content type: %s", contentType))
 	}
+	if key := c.Request().Header.Get("Idempotency-Key"); key != "" {
+		if ji.IdempotencyKey != "" && ji.IdempotencyKey != key {
+			return echo.NewHTTPError(http.StatusBadRequest, "Idempotency-Key header does not match the job's idempotencyKey")
+		}
+		ji.IdempotencyKey = key
+	}
 	if j, err := s.SubmitJob(c.Request().Context(), ji); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	} else {
+		if j.ID != ji.ID() {
+			c.Response().Header().Set("Idempotent-Replayed", "true")
+		}
 		return c.JSON(http.StatusOK, tork.NewJobSummary(j))
 	}
 }
 
+// expandJob expands the template and the library tasks which the
+// job references. Every path through which a job is submitted --
+// the API, the engine and scheduled jobs -- goes through it, since
+// a task which still references a library task can't be run.
+func (s *API) expandJob(ctx context.Context, ji *input.Job) error {
+	if ji.Template != "" {
+		t, err := s.resolveJobTemplate(ctx, ji.Template)
+		if err != nil {
+			return err
+		}
+		if err := ji.ExpandTemplate(t); err != nil {
+			return err
+		}
+	}
+	return ji.ExpandUses(ctx, s.ds)
+}
+
+// SubmitJob creates a new job and schedules it for execution. When the
+// job carries an idempotency key which the current user already used
+// for a job within the idempotency window, that job is returned instead.
 func (s *API) SubmitJob(ctx context.Context, ji *input.Job) (*tork.Job, error) {
+	if err := s.expandJob(ctx, ji); err != nil {
+		return nil, err
+	}
 	if err := ji.Validate(s.ds); err != nil {
 		return nil, err
 	}
-	j := ji.ToJob()
+	// idempotency keys are scoped to the user
+	// who submitted the job
+	username := tork.USER_GUEST
+	var createdBy *tork.User
 	currentUser := ctx.Value(tork.USERNAME)
 	if currentUser != nil {
 		cu, ok := currentUser.(string)
@@ -289,9 +473,26 @@ func (s *API) SubmitJob(ctx context.Context, ji *input.Job) (*tork.Job, error) {
 		if err != nil {
 			return nil, err
 		}
-		j.CreatedBy = u
+		username = u.Username
+		createdBy = u
+	}
+	if ji.IdempotencyKey != "" {
+		j, err := s.getJobByIdempotencyKey(ctx, username, ji.IdempotencyKey)
+		if err != nil {
+			return nil, err
+		}
+		if j != nil {
+			log.Debug().Str("job-id", j.ID).Msg("job already submitted")
+			return j, nil
+		}
 	}
+	j := ji.ToJob()
+	j.CreatedBy = createdBy
 	if err := s.ds.CreateJob(ctx, j); err != nil {
+		if errors.Is(err, datastore.ErrDuplicateIdempotencyKey) {
+			// a concurrent submission got there first
+			return s.ds.GetJobByIdempotencyKey(ctx, username, j.IdempotencyKey)
+		}
 		return nil, err
 	}
 	log.Info().Str("job-id", j.ID).Msg("created job")
@@ -301,32 +502,56 @@ func (s *API) SubmitJob(ctx context.Context, ji *input.Job) (*tork.Job, error) {
 	return j, nil
 }
 
-func bindJobInputJSON(r io.ReadCloser) (*input.Job, error) {
-	ji := input.Job{}
+// getJobByIdempotencyKey returns the job which the user submitted with
+// the given key within the idempotency window, or nil if there isn't one.
+// A key which outlived the window is released so it can be reused.
+func (s *API) getJobByIdempotencyKey(ctx context.Context, username, key string) (*tork.Job, error) {
+	j, err := s.ds.GetJobByIdempotencyKey(ctx, username, key)
+	if errors.Is(err, datastore.ErrJobNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if time.Since(j.CreatedAt) < s.idempotencyWindow {
+		return j, nil
+	}
+	if err := s.ds.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
+		if u.IdempotencyKey == key {
+			u.IdempotencyKey = ""
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrapf(err, "error releasing idempotency key")
+	}
+	return nil, nil
+}
+
+func bindInputJSON[T any](r io.ReadCloser) (*T, error) {
+	var v T
 	body, err := io.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
 	dec := json.NewDecoder(strings.NewReader(string(body)))
 	dec.DisallowUnknownFields()
-	if err := dec.Decode(&ji); err != nil {
+	if err := dec.Decode(&v); err != nil {
 		return nil, err
 	}
-	return &ji, nil
+	return &v, nil
 }
 
-func bindJobInputYAML(r io.ReadCloser) (*input.Job, error) {
-	ji := input.Job{}
+func bindInputYAML[T any](r io.ReadCloser) (*T, error) {
+	var v T
 	body, err := io.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
 	dec := yaml.NewDecoder(strings.NewReader(string(body)))
 	dec.KnownFields(true)
-	if err := dec.Decode(&ji); err != nil {
+	if err := dec.Decode(&v); err != nil {
 		return nil, err
 	}
-	return &ji, nil
+	return &v, nil
 }
 
 // getJob
@@ -341,6 +566,17 @@ func (s *API) getJob(c echo.Context) error {
 	ctx := c.Request().Context()
 	id := c.Param("id")
 	j, err := s.ds.GetJobByID(ctx, id)
+	if errors.Is(err, datastore.ErrJobNotFound) && s.archive != nil {
+		// the job may have been expunged
+		// after it was archived
+		b, aerr := archive.Read(ctx, s.archive, id)
+		if aerr != nil && !errors.Is(aerr, archive.ErrBundleNotFound) {
+			return echo.NewHTTPError(http.StatusInternalServerError, aerr.Error())
+		}
+		if aerr == nil {
+			j, err = b.Job, nil
+		}
+	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, err)
 	}
@@ -398,6 +634,28 @@ func (s *API) getJobLog(c echo.Context) error {
 	return c.JSON(http.StatusOK, l)
 }
 
+// listJobArtifacts
+// @Summary Show a list of the artifacts produced by a job's tasks
+// @Tags jobs
+// @Produce application/json
+// @Success 200 {object} []artifact.Artifact
+// @Router /jobs/{id}/artifacts [get]
+// @Param id path string true "Job ID"
+func (s *API) listJobArtifacts(c echo.Context) error {
+	id := c.Param("id")
+	if _, err := s.ds.GetJobByID(c.Request().Context(), id); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if s.artifacts == nil {
+		return c.JSON(http.StatusOK, []*artifact.Artifact{})
+	}
+	l, err := s.artifacts.List(c.Request().Context(), id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, l)
+}
+
 // listJobs
 // @Summary Show a list of jobs
 // @Tags jobs
@@ -455,6 +713,55 @@ func (s *API) listJobs(c echo.Context) error {
 	})
 }
 
+// retentionDryRun
+// @Summary Show the list of jobs which are due to be expunged by the retention policy or their autoDelete
+// @Tags jobs
+// @Produce application/json
+// @Success 200 {object} []tork.JobSummary
+// @Router /retention/dry-run [get]
+// @Param page query int false "page number"
+// @Param size query int false "page size"
+func (s *API) retentionDryRun(c echo.Context) error {
+	ps := c.QueryParam("page")
+	if ps == "" {
+		ps = "1"
+	}
+	page, err := strconv.Atoi(ps)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid page number: %s", ps))
+	}
+	if page < 1 {
+		page = 1
+	}
+	si := c.QueryParam("size")
+	if si == "" {
+		si = "10"
+	}
+	size, err := strconv.Atoi(si)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid size: %s", si))
+	}
+	if size < 1 {
+		size = 1
+	} else if size > 20 {
+		size = 20
+	}
+	currentUser := c.Request().Context().Value(tork.USERNAME)
+	var username string
+	if currentUser != nil {
+		cu, ok := currentUser.(string)
+		if !ok {
+			return errors.Errorf("error casting current user")
+		}
+		username = cu
+	}
+	res, err := s.ds.GetExpiredJobs(c.Request().Context(), username, page, size)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
 // getTask
 // @Summary Get a task by id
 // @Tags tasks
@@ -560,6 +867,253 @@ func (s *API) getTaskLog(c echo.Context) error {
 	return c.JSON(http.StatusOK, l)
 }
 
+// streamTaskLog
+// @Summary Stream a task's log
+// @Description Streams the task's log parts as server-sent events. Clients
+// @Description may resume from the last part they received using the
+// @Description Last-Event-ID header or the cursor query param.
+// @Tags tasks
+// @Produce text/event-stream
+// @Success 200
+// @Router /tasks/{id}/log/stream [get]
+// @Param id path string true "Task ID"
+// @Param cursor query int false "the number of the last log part received"
+func (s *API) streamTaskLog(c echo.Context) error {
+	ctx := c.Request().Context()
+	id := c.Param("id")
+	t, err := s.ds.GetTaskByID(ctx, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	cursor, err := streamCursor(c)
+	if err != nil {
+		return err
+	}
+	if err := s.onReadTask(ctx, task.Read, t); err != nil {
+		return err
+	}
+	// subscribe before reading the stored log so
+	// no parts are lost in between.
+	st, _, _ := s.hub.subscribe(t.JobID, t.ID, 0)
+	defer s.hub.unsubscribe(st)
+	parts, cursor, err := s.getTaskLogPartsAfter(ctx, t.ID, cursor)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	w := startStream(c)
+	last := cursor
+	for _, p := range parts {
+		if err := writeEvent(w, int64(p.Number), streamEventLog, p); err != nil {
+			return nil
+		}
+		last = int64(p.Number)
+	}
+	if !t.State.IsActive() {
+		_ = writeEvent(w, 0, streamEventEnd, tork.NewTaskSummary(t))
+		return nil
+	}
+	w.Flush()
+	heartbeat := time.NewTicker(STREAM_HEARTBEAT_INTERVAL)
+	defer heartbeat.Stop()
+	// trailing log parts may arrive after the
+	// task is done so we hold on a bit longer
+	var done <-chan time.Time
+	var summary any
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.terminate:
+			return nil
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return nil
+			}
+		case <-done:
+			_ = writeEvent(w, 0, streamEventEnd, summary)
+			w.Flush()
+			return nil
+		case u, ok := <-st.ch:
+			if !ok {
+				return nil
+			}
+			switch u.event {
+			case streamEventLog:
+				if u.id <= last {
+					continue
+				}
+				last = u.id
+				if err := writeEvent(w, u.id, u.event, u.data); err != nil {
+					return nil
+				}
+			case streamEventTask:
+				if err := writeEvent(w, 0, u.event, u.data); err != nil {
+					return nil
+				}
+				ts := u.data.(*tork.TaskSummary)
+				if !ts.State.IsActive() && done == nil {
+					summary = ts
+					done = time.After(STREAM_LOG_GRACE_PERIOD)
+				}
+			}
+		}
+		w.Flush()
+	}
+}
+
+// getTaskLogPartsAfter returns the task's stored log
+// parts which came after the given part number in
+// ascending order, along with the cursor they were
+// resolved from. The stored part numbers are the only
+// source of truth: a cursor beyond the last stored part
+// doesn't belong to this task's log and is reset.
+func (s *API) getTaskLogPartsAfter(ctx context.Context, taskID string, cursor int64) ([]*tork.TaskLogPart, int64, error) {
+	result := make([]*tork.TaskLogPart, 0)
+	for page := 1; ; page++ {
+		p, err := s.ds.GetTaskLogParts(ctx, taskID, "", page, MAX_LOG_PAGE_SIZE)
+		if err != nil {
+			return nil, 0, err
+		}
+		// parts are sorted by their number in descending order
+		if page == 1 && (len(p.Items) == 0 || int64(p.Items[0].Number) < cursor) {
+			cursor = 0
+		}
+		more := page < p.TotalPages
+		for _, part := range p.Items {
+			if int64(part.Number) <= cursor {
+				more = false
+				break
+			}
+			result = append(result, part)
+		}
+		if !more {
+			break
+		}
+	}
+	slices.SortFunc(result, func(a, b *tork.TaskLogPart) int {
+		return a.Number - b.Number
+	})
+	return slices.CompactFunc(result, func(a, b *tork.TaskLogPart) bool {
+		return a.Number == b.Number
+	}), cursor, nil
+}
+
+// streamJobEvents
+// @Summary Stream a job's events
+// @Description Streams the job's and its tasks' state changes and progress
+// @Description updates as server-sent events. Clients may resume from the
+// @Description last event they received using the Last-Event-ID header or
+// @Description the cursor query param.
+// @Tags jobs
+// @Produce text/event-stream
+// @Success 200
+// @Router /jobs/{id}/events [get]
+// @Param id path string true "Job ID"
+// @Param cursor query int false "the id of the last event received"
+func (s *API) streamJobEvents(c echo.Context) error {
+	ctx := c.Request().Context()
+	id := c.Param("id")
+	j, err := s.ds.GetJobByID(ctx, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	cursor, err := streamCursor(c)
+	if err != nil {
+		return err
+	}
+	if err := s.onReadJob(ctx, job.Read, j); err != nil {
+		return err
+	}
+	st, replay, ok := s.hub.subscribe(j.ID, "", cursor)
+	defer s.hub.unsubscribe(st)
+	w := startStream(c)
+	if ok {
+		for _, u := range replay {
+			if err := writeEvent(w, u.id, u.event, u.data); err != nil {
+				return nil
+			}
+		}
+	} else {
+		// the client is new or missed too many
+		// updates so we start it off with a snapshot
+		// of the job.
+		if err := writeEvent(w, 0, streamEventJob, tork.NewJobSummary(j)); err != nil {
+			return nil
+		}
+		for _, t := range j.Execution {
+			if err := writeEvent(w, 0, streamEventTask, tork.NewTaskSummary(t)); err != nil {
+				return nil
+			}
+		}
+	}
+	if isJobDone(j.State) {
+		_ = writeEvent(w, 0, streamEventEnd, tork.NewJobSummary(j))
+		return nil
+	}
+	w.Flush()
+	heartbeat := time.NewTicker(STREAM_HEARTBEAT_INTERVAL)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.terminate:
+			return nil
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return nil
+			}
+		case u, ok := <-st.ch:
+			if !ok {
+				return nil
+			}
+			if err := writeEvent(w, u.id, u.event, u.data); err != nil {
+				return nil
+			}
+			if u.event == streamEventJob {
+				if js := u.data.(*tork.JobSummary); isJobDone(js.State) {
+					_ = writeEvent(w, 0, streamEventEnd, js)
+					w.Flush()
+					return nil
+				}
+			}
+		}
+		w.Flush()
+	}
+}
+
+func isJobDone(state tork.JobState) bool {
+	return state == tork.JobStateCompleted ||
+		state == tork.JobStateFailed ||
+		state == tork.JobStateCancelled
+}
+
+func streamCursor(c echo.Context) (int64, error) {
+	v := c.Request().Header.Get("Last-Event-ID")
+	if v == "" {
+		v = c.QueryParam("cursor")
+	}
+	if v == "" {
+		return 0, nil
+	}
+	cursor, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || cursor < 0 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid cursor: %s", v))
+	}
+	return cursor, nil
+}
+
+func startStream(c echo.Context) *echo.Response {
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+	return w
+}
+
 func (s *API) getMetrics(c echo.Context) error {
 	metrics, err := s.ds.GetMetrics(c.Request().Context())
 	if err != nil {
@@ -597,6 +1151,73 @@ func (s *API) restartJob(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
 }
 
+// Job
+// @Summary Re-run a finished job from one of its tasks
+// @Tags jobs
+// @Accept json
+// @Produce application/json
+// @Success 200 {string} string "OK"
+// @Failure 404 {object} echo.HTTPError
+// @Failure 400 {object} echo.HTTPError
+// @Router /jobs/{id}/rerun [put]
+// @Param id path string true "Job ID"
+// @Param request body tork.JobRerun false "body"
+func (s *API) rerunJob(c echo.Context) error {
+	id := c.Param("id")
+	opts := tork.JobRerun{}
+	if err := c.Bind(&opts); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	j, err := s.ds.GetJobByID(c.Request().Context(), id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if j.State != tork.JobStateFailed &&
+		j.State != tork.JobStateCancelled &&
+		j.State != tork.JobStateCompleted {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("job is %s and can not be re-run", j.State))
+	}
+	dag := slices.ContainsFunc(j.Tasks, func(t *tork.Task) bool {
+		return len(t.DependsOn) > 0
+	})
+	if dag {
+		if opts.FromTask != "" || opts.OnlyFailed {
+			return echo.NewHTTPError(http.StatusBadRequest, "fromTask and onlyFailed are not supported for jobs with dependencies")
+		}
+		if j.State == tork.JobStateCompleted {
+			return echo.NewHTTPError(http.StatusBadRequest, "job has no failed tasks to re-run")
+		}
+	}
+	if opts.FromTask != "" {
+		if !slices.ContainsFunc(j.Execution, func(t *tork.Task) bool {
+			return t.ID == opts.FromTask
+		}) {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("task %s is not part of the job", opts.FromTask))
+		}
+	} else if !dag && j.Position > len(j.Tasks) {
+		return echo.NewHTTPError(http.StatusBadRequest, "job has no more tasks to run. use fromTask to re-run it from one of its tasks")
+	}
+	for k, v := range opts.WithInputs {
+		spec, ok := j.Context.InputSchema[k]
+		if !ok && len(j.Context.InputSchema) > 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("input %s is not declared by the job's input schema", k))
+		} else if !ok {
+			// jobs submitted before the schema was kept
+			// with the job only have the input types
+			spec = tork.InputSpec{Type: j.Context.InputTypes[k]}
+		}
+		if err := spec.Validate(v); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("input %s: %s", k, err.Error()))
+		}
+	}
+	j.State = tork.JobStateRerun
+	j.Rerun = &opts
+	if err := s.broker.PublishJob(c.Request().Context(), j); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
 // Job
 // @Summary Cancel a running job
 // @Tags jobs
@@ -612,7 +1233,11 @@ func (s *API) cancelJob(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, err.Error())
 	}
-	if j.State != tork.JobStateRunning && j.State != tork.JobStateScheduled {
+	held := j.State == tork.JobStatePending && j.Concurrency != nil
+	if j.State != tork.JobStateRunning &&
+		j.State != tork.JobStateScheduled &&
+		j.State != tork.JobStatePaused &&
+		!held {
 		return echo.NewHTTPError(http.StatusBadRequest, "job is not running")
 	}
 	j.State = tork.JobStateCancelled
@@ -623,16 +1248,664 @@ func (s *API) cancelJob(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
 }
 
-// createUser
-// @Summary Create a new user
-// @Tags users
-// @Accept json
-// @Produce json
-// @Success 200 {object} tork.User
-// @Router /users [post]
-// @Param request body tork.User true "body"
-func (s *API) createUser(c echo.Context) error {
-	var u tork.User
+// Job
+// @Summary Pause a running job
+// @Tags jobs
+// @Produce application/json
+// @Success 200 {string} string "OK"
+// @Router /jobs/{id}/pause [put]
+// @Param id path string true "Job ID"
+// @Failure 404 {object} echo.HTTPError
+// @Failure 400 {object} echo.HTTPError
+func (s *API) pauseJob(c echo.Context) error {
+	id := c.Param("id")
+	j, err := s.ds.GetJobByID(c.Request().Context(), id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if j.State != tork.JobStateRunning && j.State != tork.JobStateScheduled {
+		return echo.NewHTTPError(http.StatusBadRequest, "job is not running")
+	}
+	j.State = tork.JobStatePaused
+	if err := s.broker.PublishJob(c.Request().Context(), j); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// Job
+// @Summary Resume a paused job
+// @Tags jobs
+// @Produce application/json
+// @Success 200 {string} string "OK"
+// @Router /jobs/{id}/resume [put]
+// @Param id path string true "Job ID"
+// @Failure 404 {object} echo.HTTPError
+// @Failure 400 {object} echo.HTTPError
+func (s *API) resumeJob(c echo.Context) error {
+	id := c.Param("id")
+	j, err := s.ds.GetJobByID(c.Request().Context(), id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if j.State != tork.JobStatePaused {
+		return echo.NewHTTPError(http.StatusBadRequest, "job is not paused")
+	}
+	j.State = tork.JobStateResume
+	if err := s.broker.PublishJob(c.Request().Context(), j); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// createScheduledJob
+// @Summary Create a new scheduled job
+// @Tags jobs
+// @Accept json
+// @Produce json
+// @Success 200 {object} tork.ScheduledJobSummary
+// @Router /scheduled-jobs [post]
+// @Param request body input.ScheduledJob true "body"
+func (s *API) createScheduledJob(c echo.Context) error {
+	var ji *input.ScheduledJob
+	var err error
+	contentType := c.Request().Header.Get("content-type")
+	switch contentType {
+	case "application/json":
+		ji, err = bindInputJSON[input.ScheduledJob](c.Request().Body)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	case "text/yaml":
+		ji, err = bindInputYAML[input.ScheduledJob](c.Request().Body)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown content type: %s", contentType))
+	}
+	if sj, err := s.SubmitScheduledJob(c.Request().Context(), ji); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	} else {
+		return c.JSON(http.StatusOK, tork.NewScheduledJobSummary(sj))
+	}
+}
+
+func (s *API) SubmitScheduledJob(ctx context.Context, ji *input.ScheduledJob) (*tork.ScheduledJob, error) {
+	// the tasks of each run are copied from the scheduled
+	// job, so they're expanded once, when it's submitted
+	if err := s.expandJob(ctx, &ji.Job); err != nil {
+		return nil, err
+	}
+	if err := ji.Validate(s.ds); err != nil {
+		return nil, err
+	}
+	sj := ji.ToScheduledJob()
+	next, err := cron.Next(sj.Cron, sj.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	sj.NextRunAt = &next
+	currentUser := ctx.Value(tork.USERNAME)
+	if currentUser != nil {
+		cu, ok := currentUser.(string)
+		if !ok {
+			return nil, errors.Errorf("error casting current user")
+		}
+		u, err := s.ds.GetUser(ctx, cu)
+		if err != nil {
+			return nil, err
+		}
+		sj.CreatedBy = u
+	}
+	if err := s.ds.CreateScheduledJob(ctx, sj); err != nil {
+		return nil, err
+	}
+	log.Info().Str("scheduled-job-id", sj.ID).Msg("created scheduled job")
+	return sj, nil
+}
+
+// listScheduledJobs
+// @Summary Show a list of scheduled jobs
+// @Tags jobs
+// @Produce application/json
+// @Success 200 {object} []tork.ScheduledJobSummary
+// @Router /scheduled-jobs [get]
+// @Param page query int false "page number"
+// @Param size query int false "page size"
+func (s *API) listScheduledJobs(c echo.Context) error {
+	ps := c.QueryParam("page")
+	if ps == "" {
+		ps = "1"
+	}
+	page, err := strconv.Atoi(ps)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("invalid page number: %s", ps))
+	}
+	if page < 1 {
+		page = 1
+	}
+	si := c.QueryParam("size")
+	if si == "" {
+		si = "10"
+	}
+	size, err := strconv.Atoi(si)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("invalid size: %s", ps))
+	}
+	if size < 1 {
+		size = 1
+	} else if size > 20 {
+		size = 20
+	}
+	res, err := s.ds.GetScheduledJobs(c.Request().Context(), page, size)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// getScheduledJob
+// @Summary Get a scheduled job by id
+// @Tags jobs
+// @Produce application/json
+// @Success 200 {object} tork.ScheduledJob
+// @Failure 404 {object} echo.HTTPError
+// @Router /scheduled-jobs/{id} [get]
+// @Param id path string true "Scheduled Job ID"
+func (s *API) getScheduledJob(c echo.Context) error {
+	sj, err := s.ds.GetScheduledJobByID(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	for k := range sj.Secrets {
+		sj.Secrets[k] = "[REDACTED]"
+	}
+	return c.JSON(http.StatusOK, sj)
+}
+
+// pauseScheduledJob
+// @Summary Pause an active scheduled job
+// @Tags jobs
+// @Produce application/json
+// @Success 200 {string} string "OK"
+// @Failure 404 {object} echo.HTTPError
+// @Failure 400 {object} echo.HTTPError
+// @Router /scheduled-jobs/{id}/pause [put]
+// @Param id path string true "Scheduled Job ID"
+func (s *API) pauseScheduledJob(c echo.Context) error {
+	id := c.Param("id")
+	sj, err := s.ds.GetScheduledJobByID(c.Request().Context(), id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if sj.State != tork.ScheduledJobStateActive {
+		return echo.NewHTTPError(http.StatusBadRequest, "scheduled job is not active")
+	}
+	if err := s.ds.UpdateScheduledJob(c.Request().Context(), id, func(u *tork.ScheduledJob) error {
+		u.State = tork.ScheduledJobStatePaused
+		u.NextRunAt = nil
+		return nil
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// resumeScheduledJob
+// @Summary Resume a paused scheduled job
+// @Tags jobs
+// @Produce application/json
+// @Success 200 {string} string "OK"
+// @Failure 404 {object} echo.HTTPError
+// @Failure 400 {object} echo.HTTPError
+// @Router /scheduled-jobs/{id}/resume [put]
+// @Param id path string true "Scheduled Job ID"
+func (s *API) resumeScheduledJob(c echo.Context) error {
+	id := c.Param("id")
+	sj, err := s.ds.GetScheduledJobByID(c.Request().Context(), id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if sj.State != tork.ScheduledJobStatePaused {
+		return echo.NewHTTPError(http.StatusBadRequest, "scheduled job is not paused")
+	}
+	next, err := cron.Next(sj.Cron, time.Now().UTC())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := s.ds.UpdateScheduledJob(c.Request().Context(), id, func(u *tork.ScheduledJob) error {
+		u.State = tork.ScheduledJobStateActive
+		u.NextRunAt = &next
+		return nil
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// deleteScheduledJob
+// @Summary Delete a scheduled job
+// @Tags jobs
+// @Produce application/json
+// @Success 200 {string} string "OK"
+// @Failure 404 {object} echo.HTTPError
+// @Router /scheduled-jobs/{id} [delete]
+// @Param id path string true "Scheduled Job ID"
+func (s *API) deleteScheduledJob(c echo.Context) error {
+	if err := s.ds.DeleteScheduledJob(c.Request().Context(), c.Param("id")); err != nil {
+		if errors.Is(err, datastore.ErrScheduledJobNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// createJobTemplate
+// @Summary Create a new version of a job template
+// @Tags templates
+// @Accept json
+// @Produce json
+// @Success 200 {object} tork.JobTemplate
+// @Failure 403 {object} echo.HTTPError
+// @Router /templates [post]
+// @Param request body input.JobTemplate true "body"
+func (s *API) createJobTemplate(c echo.Context) error {
+	var ti *input.JobTemplate
+	var err error
+	contentType := c.Request().Header.Get("content-type")
+	switch contentType {
+	case "application/json":
+		ti, err = bindInputJSON[input.JobTemplate](c.Request().Body)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	case "text/yaml":
+		ti, err = bindInputYAML[input.JobTemplate](c.Request().Body)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown content type: %s", contentType))
+	}
+	if err := ti.Validate(s.ds); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	t, err := ti.ToJobTemplate()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	ctx := c.Request().Context()
+	currentUser := ctx.Value(tork.USERNAME)
+	if currentUser != nil {
+		cu, ok := currentUser.(string)
+		if !ok {
+			return errors.Errorf("error casting current user")
+		}
+		u, err := s.ds.GetUser(ctx, cu)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		t.CreatedBy = u
+	}
+	if err := s.checkJobTemplateOwner(ctx, t.Name); err != nil {
+		return err
+	}
+	if err := s.ds.CreateJobTemplate(ctx, t); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	log.Info().Str("template", t.Ref()).Msg("created job template")
+	return c.JSON(http.StatusOK, t)
+}
+
+// listJobTemplates
+// @Summary Show a list of the latest version of each job template
+// @Tags templates
+// @Produce application/json
+// @Success 200 {object} []tork.JobTemplate
+// @Router /templates [get]
+// @Param page query int false "page number"
+// @Param size query int false "page size"
+func (s *API) listJobTemplates(c echo.Context) error {
+	ps := c.QueryParam("page")
+	if ps == "" {
+		ps = "1"
+	}
+	page, err := strconv.Atoi(ps)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("invalid page number: %s", ps))
+	}
+	if page < 1 {
+		page = 1
+	}
+	si := c.QueryParam("size")
+	if si == "" {
+		si = "10"
+	}
+	size, err := strconv.Atoi(si)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("invalid size: %s", ps))
+	}
+	if size < 1 {
+		size = 1
+	} else if size > 20 {
+		size = 20
+	}
+	currentUser := c.Request().Context().Value(tork.USERNAME)
+	var username string
+	if currentUser != nil {
+		cu, ok := currentUser.(string)
+		if !ok {
+			return errors.Errorf("error casting current user")
+		}
+		username = cu
+	}
+	res, err := s.ds.GetJobTemplates(c.Request().Context(), username, page, size)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// getJobTemplate
+// @Summary Get a job template by reference (name or name@version)
+// @Tags templates
+// @Produce application/json
+// @Success 200 {object} tork.JobTemplate
+// @Failure 404 {object} echo.HTTPError
+// @Router /templates/{ref} [get]
+// @Param ref path string true "Template reference"
+func (s *API) getJobTemplate(c echo.Context) error {
+	t, err := s.resolveJobTemplate(c.Request().Context(), c.Param("ref"))
+	if err != nil {
+		if errors.Is(err, datastore.ErrJobTemplateNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, t)
+}
+
+// deleteJobTemplate
+// @Summary Delete a job template version, or all its versions
+// @Tags templates
+// @Produce application/json
+// @Success 200 {string} string "OK"
+// @Failure 403 {object} echo.HTTPError
+// @Failure 404 {object} echo.HTTPError
+// @Router /templates/{ref} [delete]
+// @Param ref path string true "Template reference"
+func (s *API) deleteJobTemplate(c echo.Context) error {
+	ctx := c.Request().Context()
+	ref := c.Param("ref")
+	if _, err := s.resolveJobTemplate(ctx, ref); err != nil {
+		if errors.Is(err, datastore.ErrJobTemplateNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	name, version, err := tork.ParseRef(ref)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := s.checkJobTemplateOwner(ctx, name); err != nil {
+		return err
+	}
+	if err := s.ds.DeleteJobTemplate(ctx, name, version); err != nil {
+		if errors.Is(err, datastore.ErrJobTemplateNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// checkJobTemplateOwner ensures that the current user may publish
+// or delete versions of the named job template, which only the user
+// who created the template may do. A name which isn't taken yet is
+// free for anyone to claim.
+func (s *API) checkJobTemplateOwner(ctx context.Context, name string) error {
+	currentUser, _ := ctx.Value(tork.USERNAME).(string)
+	if currentUser == "" {
+		return nil
+	}
+	t, err := s.ds.GetJobTemplate(ctx, name, 0)
+	if errors.Is(err, datastore.ErrJobTemplateNotFound) {
+		return nil
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	u, err := s.ds.GetUser(ctx, currentUser)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if t.CreatedBy == nil || t.CreatedBy.ID != u.ID {
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("template %s is owned by another user", name))
+	}
+	return nil
+}
+
+// resolveJobTemplate returns the job template referenced by the given
+// name@version reference, provided that the current user has access
+// to it. Templates which the user can't access are reported as not
+// found.
+func (s *API) resolveJobTemplate(ctx context.Context, ref string) (*tork.JobTemplate, error) {
+	name, version, err := tork.ParseRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.ds.GetJobTemplate(ctx, name, version)
+	if err != nil {
+		return nil, err
+	}
+	currentUser, _ := ctx.Value(tork.USERNAME).(string)
+	if currentUser == "" || len(t.Permissions) == 0 {
+		return t, nil
+	}
+	u, err := s.ds.GetUser(ctx, currentUser)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := s.ds.GetUserRoles(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range t.Permissions {
+		if p.User != nil && p.User.Username == u.Username {
+			return t, nil
+		}
+		if p.Role != nil && slices.ContainsFunc(roles, func(r *tork.Role) bool { return r.Slug == p.Role.Slug }) {
+			return t, nil
+		}
+	}
+	return nil, datastore.ErrJobTemplateNotFound
+}
+
+// createLibraryTask
+// @Summary Create a new version of a library task
+// @Tags library
+// @Accept json
+// @Produce json
+// @Success 200 {object} tork.LibraryTask
+// @Failure 403 {object} echo.HTTPError
+// @Router /library [post]
+// @Param request body input.LibraryTask true "body"
+func (s *API) createLibraryTask(c echo.Context) error {
+	var li *input.LibraryTask
+	var err error
+	contentType := c.Request().Header.Get("content-type")
+	switch contentType {
+	case "application/json":
+		li, err = bindInputJSON[input.LibraryTask](c.Request().Body)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	case "text/yaml":
+		li, err = bindInputYAML[input.LibraryTask](c.Request().Body)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown content type: %s", contentType))
+	}
+	if err := li.Validate(s.ds); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	lt, err := li.ToLibraryTask()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	ctx := c.Request().Context()
+	currentUser := ctx.Value(tork.USERNAME)
+	if currentUser != nil {
+		cu, ok := currentUser.(string)
+		if !ok {
+			return errors.Errorf("error casting current user")
+		}
+		u, err := s.ds.GetUser(ctx, cu)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		lt.CreatedBy = u
+	}
+	if err := s.checkLibraryTaskOwner(ctx, lt.Name); err != nil {
+		return err
+	}
+	if err := s.ds.CreateLibraryTask(ctx, lt); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	log.Info().Str("library-task", lt.Ref()).Msg("created library task")
+	return c.JSON(http.StatusOK, lt)
+}
+
+// listLibraryTasks
+// @Summary Show a list of the latest version of each library task
+// @Tags library
+// @Produce application/json
+// @Success 200 {object} []tork.LibraryTask
+// @Router /library [get]
+// @Param page query int false "page number"
+// @Param size query int false "page size"
+func (s *API) listLibraryTasks(c echo.Context) error {
+	ps := c.QueryParam("page")
+	if ps == "" {
+		ps = "1"
+	}
+	page, err := strconv.Atoi(ps)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("invalid page number: %s", ps))
+	}
+	if page < 1 {
+		page = 1
+	}
+	si := c.QueryParam("size")
+	if si == "" {
+		si = "10"
+	}
+	size, err := strconv.Atoi(si)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("invalid size: %s", ps))
+	}
+	if size < 1 {
+		size = 1
+	} else if size > 20 {
+		size = 20
+	}
+	res, err := s.ds.GetLibraryTasks(c.Request().Context(), page, size)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// getLibraryTask
+// @Summary Get a library task by reference (name or name@version)
+// @Tags library
+// @Produce application/json
+// @Success 200 {object} tork.LibraryTask
+// @Failure 404 {object} echo.HTTPError
+// @Router /library/{ref} [get]
+// @Param ref path string true "Library task reference"
+func (s *API) getLibraryTask(c echo.Context) error {
+	name, version, err := tork.ParseRef(c.Param("ref"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	lt, err := s.ds.GetLibraryTask(c.Request().Context(), name, version)
+	if err != nil {
+		if errors.Is(err, datastore.ErrLibraryTaskNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, lt)
+}
+
+// deleteLibraryTask
+// @Summary Delete a library task version, or all its versions
+// @Tags library
+// @Produce application/json
+// @Success 200 {string} string "OK"
+// @Failure 403 {object} echo.HTTPError
+// @Failure 404 {object} echo.HTTPError
+// @Router /library/{ref} [delete]
+// @Param ref path string true "Library task reference"
+func (s *API) deleteLibraryTask(c echo.Context) error {
+	name, version, err := tork.ParseRef(c.Param("ref"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	ctx := c.Request().Context()
+	if err := s.checkLibraryTaskOwner(ctx, name); err != nil {
+		return err
+	}
+	if err := s.ds.DeleteLibraryTask(ctx, name, version); err != nil {
+		if errors.Is(err, datastore.ErrLibraryTaskNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// checkLibraryTaskOwner ensures that the current user may publish
+// or delete versions of the named library task, which only the user
+// who created the task may do. A name which isn't taken yet is free
+// for anyone to claim.
+func (s *API) checkLibraryTaskOwner(ctx context.Context, name string) error {
+	currentUser, _ := ctx.Value(tork.USERNAME).(string)
+	if currentUser == "" {
+		return nil
+	}
+	lt, err := s.ds.GetLibraryTask(ctx, name, 0)
+	if errors.Is(err, datastore.ErrLibraryTaskNotFound) {
+		return nil
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	u, err := s.ds.GetUser(ctx, currentUser)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if lt.CreatedBy == nil || lt.CreatedBy.ID != u.ID {
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("library task %s is owned by another user", name))
+	}
+	return nil
+}
+
+// createUser
+// @Summary Create a new user
+// @Tags users
+// @Accept json
+// @Produce json
+// @Success 200 {object} tork.User
+// @Router /users [post]
+// @Param request body tork.User true "body"
+func (s *API) createUser(c echo.Context) error {
+	var u tork.User
 	body, err := io.ReadAll(c.Request().Body)
 	if err != nil {
 		return err
@@ -667,6 +1940,370 @@ func (s *API) createUser(c echo.Context) error {
 	}
 }
 
+// listUsers
+// @Summary Show a list of users
+// @Tags users
+// @Produce application/json
+// @Success 200 {object} []tork.User
+// @Router /users [get]
+// @Param page query int false "page number"
+// @Param size query int false "page size"
+func (s *API) listUsers(c echo.Context) error {
+	ps := c.QueryParam("page")
+	if ps == "" {
+		ps = "1"
+	}
+	page, err := strconv.Atoi(ps)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid page number: %s", ps))
+	}
+	if page < 1 {
+		page = 1
+	}
+	si := c.QueryParam("size")
+	if si == "" {
+		si = "10"
+	}
+	size, err := strconv.Atoi(si)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid size: %s", si))
+	}
+	if size < 1 {
+		size = 1
+	} else if size > 20 {
+		size = 20
+	}
+	res, err := s.ds.GetUsers(c.Request().Context(), page, size)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// getUser
+// @Summary Get a user by id or username
+// @Tags users
+// @Produce application/json
+// @Success 200 {object} tork.User
+// @Failure 404 {object} echo.HTTPError
+// @Router /users/{id} [get]
+// @Param id path string true "User ID or username"
+func (s *API) getUser(c echo.Context) error {
+	u, err := s.lookupUser(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, u)
+}
+
+// deleteUser
+// @Summary Delete a user
+// @Tags users
+// @Produce application/json
+// @Success 200
+// @Failure 404 {object} echo.HTTPError
+// @Failure 409 {object} echo.HTTPError
+// @Router /users/{id} [delete]
+// @Param id path string true "User ID or username"
+func (s *API) deleteUser(c echo.Context) error {
+	u, err := s.lookupUser(c)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(u.Username, tork.USER_GUEST) {
+		return echo.NewHTTPError(http.StatusBadRequest, "the guest user can't be deleted")
+	}
+	if err := s.ds.DeleteUser(c.Request().Context(), u.ID); err != nil {
+		if errors.Is(err, datastore.ErrUserNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		if errors.Is(err, datastore.ErrUserInUse) {
+			return echo.NewHTTPError(http.StatusConflict, "user has jobs and can't be deleted. disable the user instead")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// disableUser
+// @Summary Disable a user
+// @Tags users
+// @Produce application/json
+// @Success 200
+// @Failure 404 {object} echo.HTTPError
+// @Router /users/{id}/disable [put]
+// @Param id path string true "User ID or username"
+func (s *API) disableUser(c echo.Context) error {
+	return s.setUserDisabled(c, true)
+}
+
+// enableUser
+// @Summary Enable a previously disabled user
+// @Tags users
+// @Produce application/json
+// @Success 200
+// @Failure 404 {object} echo.HTTPError
+// @Router /users/{id}/enable [put]
+// @Param id path string true "User ID or username"
+func (s *API) enableUser(c echo.Context) error {
+	return s.setUserDisabled(c, false)
+}
+
+func (s *API) setUserDisabled(c echo.Context, disabled bool) error {
+	u, err := s.lookupUser(c)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(u.Username, tork.USER_GUEST) {
+		return echo.NewHTTPError(http.StatusBadRequest, "the guest user can't be modified")
+	}
+	if err := s.ds.UpdateUser(c.Request().Context(), u.ID, func(u *tork.User) error {
+		u.Disabled = disabled
+		return nil
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+type changePasswordRequest struct {
+	Password string `json:"password"`
+}
+
+// changeUserPassword
+// @Summary Change a user's password
+// @Tags users
+// @Accept json
+// @Produce application/json
+// @Success 200
+// @Failure 400 {object} echo.HTTPError
+// @Failure 404 {object} echo.HTTPError
+// @Router /users/{id}/password [put]
+// @Param id path string true "User ID or username"
+// @Param request body changePasswordRequest true "body"
+func (s *API) changeUserPassword(c echo.Context) error {
+	req := changePasswordRequest{}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	password := strings.TrimSpace(req.Password)
+	if password == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "must provide password")
+	}
+	passwordHash, err := hash.Password(password)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid password")
+	}
+	u, err := s.lookupUser(c)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(u.Username, tork.USER_GUEST) {
+		return echo.NewHTTPError(http.StatusBadRequest, "the guest user can't be modified")
+	}
+	if err := s.ds.UpdateUser(c.Request().Context(), u.ID, func(u *tork.User) error {
+		u.PasswordHash = passwordHash
+		return nil
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// listUserRoles
+// @Summary Show the roles assigned to a user
+// @Tags users
+// @Produce application/json
+// @Success 200 {object} []tork.Role
+// @Failure 404 {object} echo.HTTPError
+// @Router /users/{id}/roles [get]
+// @Param id path string true "User ID or username"
+func (s *API) listUserRoles(c echo.Context) error {
+	u, err := s.lookupUser(c)
+	if err != nil {
+		return err
+	}
+	roles, err := s.ds.GetUserRoles(c.Request().Context(), u.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, roles)
+}
+
+// assignRole
+// @Summary Assign a role to a user
+// @Tags users
+// @Produce application/json
+// @Success 200
+// @Failure 404 {object} echo.HTTPError
+// @Router /users/{id}/roles/{role} [put]
+// @Param id path string true "User ID or username"
+// @Param role path string true "Role ID or slug"
+func (s *API) assignRole(c echo.Context) error {
+	ctx := c.Request().Context()
+	u, err := s.lookupUser(c)
+	if err != nil {
+		return err
+	}
+	r, err := s.lookupRole(c, c.Param("role"))
+	if err != nil {
+		return err
+	}
+	uroles, err := s.ds.GetUserRoles(ctx, u.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	for _, ur := range uroles {
+		if ur.ID == r.ID {
+			return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+		}
+	}
+	if err := s.ds.AssignRole(ctx, u.ID, r.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// unassignRole
+// @Summary Unassign a role from a user
+// @Tags users
+// @Produce application/json
+// @Success 200
+// @Failure 404 {object} echo.HTTPError
+// @Router /users/{id}/roles/{role} [delete]
+// @Param id path string true "User ID or username"
+// @Param role path string true "Role ID or slug"
+func (s *API) unassignRole(c echo.Context) error {
+	u, err := s.lookupUser(c)
+	if err != nil {
+		return err
+	}
+	r, err := s.lookupRole(c, c.Param("role"))
+	if err != nil {
+		return err
+	}
+	if err := s.ds.UnassignRole(c.Request().Context(), u.ID, r.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// createRole
+// @Summary Create a new role
+// @Tags users
+// @Accept json
+// @Produce json
+// @Success 200 {object} tork.Role
+// @Failure 400 {object} echo.HTTPError
+// @Router /roles [post]
+// @Param request body tork.Role true "body"
+func (s *API) createRole(c echo.Context) error {
+	var r tork.Role
+	if err := c.Bind(&r); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	r.Slug = strings.TrimSpace(r.Slug)
+	if !roleSlugPattern.MatchString(r.Slug) {
+		return echo.NewHTTPError(http.StatusBadRequest, "slug must consist of lowercase letters, digits and dashes")
+	}
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "must provide name")
+	}
+	ctx := c.Request().Context()
+	_, err := s.ds.GetRole(ctx, r.Slug)
+	if err == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "role already exists")
+	} else if !errors.Is(err, datastore.ErrRoleNotFound) {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if err := s.ds.CreateRole(ctx, &r); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, r)
+}
+
+// listRoles
+// @Summary Show a list of roles
+// @Tags users
+// @Produce application/json
+// @Success 200 {object} []tork.Role
+// @Router /roles [get]
+func (s *API) listRoles(c echo.Context) error {
+	roles, err := s.ds.GetRoles(c.Request().Context())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, roles)
+}
+
+// getRole
+// @Summary Get a role by id or slug
+// @Tags users
+// @Produce application/json
+// @Success 200 {object} tork.Role
+// @Failure 404 {object} echo.HTTPError
+// @Router /roles/{id} [get]
+// @Param id path string true "Role ID or slug"
+func (s *API) getRole(c echo.Context) error {
+	r, err := s.lookupRole(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, r)
+}
+
+// deleteRole
+// @Summary Delete a role
+// @Tags users
+// @Produce application/json
+// @Success 200
+// @Failure 404 {object} echo.HTTPError
+// @Failure 409 {object} echo.HTTPError
+// @Router /roles/{id} [delete]
+// @Param id path string true "Role ID or slug"
+func (s *API) deleteRole(c echo.Context) error {
+	r, err := s.lookupRole(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	if r.Slug == tork.ROLE_PUBLIC {
+		return echo.NewHTTPError(http.StatusBadRequest, "the public role can't be deleted")
+	}
+	if err := s.ds.DeleteRole(c.Request().Context(), r.ID); err != nil {
+		if errors.Is(err, datastore.ErrRoleNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		if errors.Is(err, datastore.ErrRoleInUse) {
+			return echo.NewHTTPError(http.StatusConflict, "role is used by job permissions and can't be deleted")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+func (s *API) lookupUser(c echo.Context) (*tork.User, error) {
+	u, err := s.ds.GetUser(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, datastore.ErrUserNotFound) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return u, nil
+}
+
+func (s *API) lookupRole(c echo.Context, id string) (*tork.Role, error) {
+	r, err := s.ds.GetRole(c.Request().Context(), id)
+	if err != nil {
+		if errors.Is(err, datastore.ErrRoleNotFound) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return r, nil
+}
+
 func (a *API) proxy(c echo.Context) error {
 	id := c.Param("id")
 	port := c.Param("port")
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -17,13 +18,21 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/archive"
+	archivelocal "github.com/runabol/tork/archive/local"
+	"github.com/runabol/tork/artifact"
+	"github.com/runabol/tork/artifact/local"
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/datastore/inmemory"
 	"github.com/runabol/tork/datastore/postgres"
+	"github.com/runabol/tork/internal/hash"
+	"github.com/runabol/tork/leader"
+	"github.com/runabol/tork/middleware/task"
 	"github.com/runabol/tork/middleware/web"
 
 	"github.com/runabol/tork/mq"
 
+	"github.com/runabol/tork/input"
 	"github.com/runabol/tork/internal/uuid"
 	"github.com/stretchr/testify/assert"
 )
@@ -123,6 +132,97 @@ func Test_listJobs(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 }
 
+func Test_retentionDryRun(t *testing.T) {
+	b := mq.NewInMemoryBroker()
+	failed, err := datastore.NewRetentionRule("FAILED", "", "30d")
+	assert.NoError(t, err)
+	ds := inmemory.NewInMemoryDatastore(inmemory.WithRetentionPolicy(datastore.RetentionPolicy{failed}))
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    b,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, api)
+
+	longAgo := time.Now().UTC().Add(-time.Hour * 24 * 40)
+	j1 := &tork.Job{
+		ID:       uuid.NewUUID(),
+		State:    tork.JobStateFailed,
+		FailedAt: &longAgo,
+	}
+	err = ds.CreateJob(context.Background(), j1)
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+	err = ds.CreateJob(context.Background(), &tork.Job{
+		ID:       uuid.NewUUID(),
+		State:    tork.JobStateFailed,
+		FailedAt: &now,
+	})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("GET", "/retention/dry-run", nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	body, err := io.ReadAll(w.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	js := datastore.Page[*tork.JobSummary]{}
+	err = json.Unmarshal(body, &js)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, js.TotalItems)
+	assert.Equal(t, j1.ID, js.Items[0].ID)
+
+	// jobs were not deleted
+	_, err = ds.GetJobByID(context.Background(), j1.ID)
+	assert.NoError(t, err)
+}
+
+func Test_getArchivedJob(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	ds := inmemory.NewInMemoryDatastore()
+	sink, err := archivelocal.NewLocalSink(t.TempDir())
+	assert.NoError(t, err)
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    b,
+		Archive:   sink,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, api)
+
+	j1 := &tork.Job{
+		ID:    uuid.NewUUID(),
+		Name:  "archived job",
+		State: tork.JobStateCompleted,
+	}
+	err = archive.Write(ctx, sink, &archive.Bundle{Job: j1})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("GET", "/jobs/"+j1.ID, nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	body, err := io.ReadAll(w.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	j := tork.Job{}
+	err = json.Unmarshal(body, &j)
+	assert.NoError(t, err)
+	assert.Equal(t, j1.ID, j.ID)
+	assert.Equal(t, "archived job", j.Name)
+
+	req, err = http.NewRequest("GET", "/jobs/"+uuid.NewUUID(), nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
 func Test_getActiveNodes(t *testing.T) {
 	ds := inmemory.NewInMemoryDatastore()
 	active := &tork.Node{
@@ -159,6 +259,60 @@ func Test_getActiveNodes(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 }
 
+func Test_drainNode(t *testing.T) {
+	drained := make(chan string, 1)
+	worker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "/drain", r.URL.Path)
+		drained <- r.URL.Query().Get("timeout")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer worker.Close()
+	wu, err := url.Parse(worker.URL)
+	assert.NoError(t, err)
+	port, err := strconv.Atoi(wu.Port())
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+	n := &tork.Node{
+		ID:              uuid.NewUUID(),
+		Status:          tork.NodeStatusUP,
+		Hostname:        wu.Hostname(),
+		Port:            port,
+		LastHeartbeatAt: time.Now().UTC(),
+	}
+	err = ds.CreateNode(context.Background(), n)
+	assert.NoError(t, err)
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("/nodes/%s/drain?timeout=5m", n.ID), nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "5m", <-drained)
+
+	n2, err := ds.GetNodeByID(context.Background(), n.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.NodeStatusDraining, n2.Status)
+
+	req, err = http.NewRequest("PUT", fmt.Sprintf("/nodes/%s/drain?timeout=xyz", n.ID), nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req, err = http.NewRequest("PUT", "/nodes/no-such-node/drain", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
 func Test_healthOK(t *testing.T) {
 	api, err := NewAPI(Config{
 		DataStore: inmemory.NewInMemoryDatastore(),
@@ -177,6 +331,25 @@ func Test_healthOK(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 }
 
+func Test_healthLeader(t *testing.T) {
+	api, err := NewAPI(Config{
+		DataStore: inmemory.NewInMemoryDatastore(),
+		Broker:    mq.NewInMemoryBroker(),
+		Elector:   leader.NewInMemoryElector("some-leader"),
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, api)
+	req, err := http.NewRequest("GET", "/health", nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	body, err := io.ReadAll(w.Body)
+
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "\"leader\":\"some-leader\"")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
 func Test_healthNotOK(t *testing.T) {
 	schemaName := fmt.Sprintf("tork%d", rand.Int())
 	dsn := `host=localhost user=tork password=tork dbname=tork search_path=%s sslmode=disable`
@@ -270,6 +443,166 @@ func Test_createJob(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 }
 
+func Test_createJobIdempotencyKey(t *testing.T) {
+	api, err := NewAPI(Config{
+		DataStore: inmemory.NewInMemoryDatastore(),
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, api)
+
+	submit := func(key, body string) (*httptest.ResponseRecorder, tork.JobSummary) {
+		req, err := http.NewRequest("POST", "/jobs", strings.NewReader(body))
+		assert.NoError(t, err)
+		req.Header.Add("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Add("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, req)
+		js := tork.JobSummary{}
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &js))
+		}
+		return w, js
+	}
+
+	body := `{"name":"test job","tasks":[{"name":"test task","image":"some:image"}]}`
+
+	w1, j1 := submit("build-1234", body)
+	assert.Equal(t, http.StatusOK, w1.Code)
+	assert.Empty(t, w1.Header().Get("Idempotent-Replayed"))
+
+	w2, j2 := submit("build-1234", body)
+	assert.Equal(t, http.StatusOK, w2.Code)
+	assert.Equal(t, "true", w2.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, j1.ID, j2.ID)
+
+	// the key can also be specified on the job itself
+	w3, j3 := submit("", `{"name":"test job","idempotencyKey":"build-1234","tasks":[{"name":"test task","image":"some:image"}]}`)
+	assert.Equal(t, http.StatusOK, w3.Code)
+	assert.Equal(t, j1.ID, j3.ID)
+
+	w4, j4 := submit("build-5678", body)
+	assert.Equal(t, http.StatusOK, w4.Code)
+	assert.NotEqual(t, j1.ID, j4.ID)
+
+	// conflicting keys
+	w5, _ := submit("build-5678", `{"name":"test job","idempotencyKey":"build-1234","tasks":[{"name":"test task","image":"some:image"}]}`)
+	assert.Equal(t, http.StatusBadRequest, w5.Code)
+}
+
+func Test_submitJobIdempotencyKeyPerUser(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	u1 := &tork.User{ID: uuid.NewUUID(), Username: "user1", Name: "User 1"}
+	assert.NoError(t, ds.CreateUser(ctx, u1))
+	u2 := &tork.User{ID: uuid.NewUUID(), Username: "user2", Name: "User 2"}
+	assert.NoError(t, ds.CreateUser(ctx, u2))
+
+	ji := func() *input.Job {
+		return &input.Job{
+			Name:           "test job",
+			IdempotencyKey: "build-1234",
+			Tasks: []input.Task{{
+				Name:  "test task",
+				Image: "some:image",
+			}},
+		}
+	}
+
+	j1, err := api.SubmitJob(context.WithValue(ctx, tork.USERNAME, u1.Username), ji())
+	assert.NoError(t, err)
+
+	// another user's key doesn't reveal the job
+	j2, err := api.SubmitJob(context.WithValue(ctx, tork.USERNAME, u2.Username), ji())
+	assert.NoError(t, err)
+	assert.NotEqual(t, j1.ID, j2.ID)
+	assert.Equal(t, u2.Username, j2.CreatedBy.Username)
+
+	j3, err := api.SubmitJob(context.WithValue(ctx, tork.USERNAME, u1.Username), ji())
+	assert.NoError(t, err)
+	assert.Equal(t, j1.ID, j3.ID)
+}
+
+func Test_submitJobIdempotencyWindow(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	api, err := NewAPI(Config{
+		DataStore:         ds,
+		Broker:            mq.NewInMemoryBroker(),
+		IdempotencyWindow: time.Hour,
+	})
+	assert.NoError(t, err)
+
+	old := &tork.Job{
+		ID:             uuid.NewUUID(),
+		State:          tork.JobStateCompleted,
+		CreatedAt:      time.Now().UTC().Add(-time.Hour * 2),
+		IdempotencyKey: "nightly",
+	}
+	err = ds.CreateJob(ctx, old)
+	assert.NoError(t, err)
+
+	ji := &input.Job{
+		Name:           "test job",
+		IdempotencyKey: "nightly",
+		Tasks: []input.Task{{
+			Name:  "test task",
+			Image: "some:image",
+		}},
+	}
+
+	// the previous job is outside of the window
+	j1, err := api.SubmitJob(ctx, ji)
+	assert.NoError(t, err)
+	assert.NotEqual(t, old.ID, j1.ID)
+
+	old2, err := ds.GetJobByID(ctx, old.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, old2.IdempotencyKey)
+
+	j2, err := api.SubmitJob(ctx, &input.Job{
+		Name:           "test job",
+		IdempotencyKey: "nightly",
+		Tasks: []input.Task{{
+			Name:  "test task",
+			Image: "some:image",
+		}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, j1.ID, j2.ID)
+}
+
+func Test_createJobInvalidInput(t *testing.T) {
+	api, err := NewAPI(Config{
+		DataStore: inmemory.NewInMemoryDatastore(),
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, api)
+	req, err := http.NewRequest("POST", "/jobs", strings.NewReader(`{
+		"name":"test job",
+		"inputs":{"replicas":"many"},
+		"inputSchema":{"replicas":{"type":"int"}},
+		"tasks":[{
+			"name":"test task",
+			"image":"some:image"
+		}]
+	}`))
+	req.Header.Add("Content-Type", "application/json")
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
 func Test_createJobInvalidProperty(t *testing.T) {
 	api, err := NewAPI(Config{
 		DataStore: inmemory.NewInMemoryDatastore(),
@@ -318,6 +651,44 @@ func Test_getJob(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 }
 
+func Test_listJobArtifacts(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	err := ds.CreateJob(ctx, &tork.Job{
+		ID:    "1234",
+		State: tork.JobStateCompleted,
+	})
+	assert.NoError(t, err)
+	store, err := local.NewLocalStore(t.TempDir())
+	assert.NoError(t, err)
+	err = store.Put(ctx, "1234", "report.txt", strings.NewReader("hello world"), 11)
+	assert.NoError(t, err)
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+		Artifacts: store,
+	})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("GET", "/jobs/1234/artifacts", nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	l := []*artifact.Artifact{}
+	err = json.Unmarshal(w.Body.Bytes(), &l)
+	assert.NoError(t, err)
+	assert.Len(t, l, 1)
+	assert.Equal(t, "report.txt", l[0].Name)
+	assert.Equal(t, int64(11), l[0].Size)
+
+	req, err = http.NewRequest("GET", "/jobs/nosuch/artifacts", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
 func Test_cancelRunningJob(t *testing.T) {
 	ctx := context.Background()
 	ds := inmemory.NewInMemoryDatastore()
@@ -454,36 +825,24 @@ func Test_cancelScheduledJob(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 }
 
-func Test_restartJob(t *testing.T) {
+func Test_cancelHeldJob(t *testing.T) {
 	ctx := context.Background()
 	ds := inmemory.NewInMemoryDatastore()
 	j1 := tork.Job{
-		ID:        uuid.NewUUID(),
-		State:     tork.JobStateCancelled,
-		CreatedAt: time.Now().UTC(),
-		Position:  1,
-		Tasks: []*tork.Task{
-			{
-				Name: "some fake task",
-			},
-		},
+		ID:          uuid.NewUUID(),
+		State:       tork.JobStatePending,
+		CreatedAt:   time.Now().UTC(),
+		Concurrency: &tork.JobConcurrency{Key: "deploy-prod", Limit: 1},
 	}
 	err := ds.CreateJob(ctx, &j1)
 	assert.NoError(t, err)
-
-	now := time.Now().UTC()
-
-	tasks := []tork.Task{{
+	j2 := tork.Job{
 		ID:        uuid.NewUUID(),
-		State:     tork.TaskStatePending,
-		CreatedAt: &now,
-		JobID:     j1.ID,
-	}}
-
-	for _, ta := range tasks {
-		err := ds.CreateTask(ctx, &ta)
-		assert.NoError(t, err)
+		State:     tork.JobStatePending,
+		CreatedAt: time.Now().UTC(),
 	}
+	err = ds.CreateJob(ctx, &j2)
+	assert.NoError(t, err)
 
 	api, err := NewAPI(Config{
 		DataStore: ds,
@@ -491,24 +850,26 @@ func Test_restartJob(t *testing.T) {
 	})
 	assert.NoError(t, err)
 	assert.NotNil(t, api)
-	req, err := http.NewRequest("PUT", fmt.Sprintf("/jobs/%s/restart", j1.ID), nil)
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("/jobs/%s/cancel", j1.ID), nil)
 	assert.NoError(t, err)
 	w := httptest.NewRecorder()
 	api.server.Handler.ServeHTTP(w, req)
-	body, err := io.ReadAll(w.Body)
-	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, w.Code)
 
+	req, err = http.NewRequest("PUT", fmt.Sprintf("/jobs/%s/cancel", j2.ID), nil)
 	assert.NoError(t, err)
-	assert.Equal(t, "{\"status\":\"OK\"}\n", string(body))
-	assert.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
 
-func Test_restartRunningJob(t *testing.T) {
+func Test_restartJob(t *testing.T) {
 	ctx := context.Background()
 	ds := inmemory.NewInMemoryDatastore()
 	j1 := tork.Job{
 		ID:        uuid.NewUUID(),
-		State:     tork.JobStateRunning,
+		State:     tork.JobStateCancelled,
 		CreatedAt: time.Now().UTC(),
 		Position:  1,
 		Tasks: []*tork.Task{
@@ -544,9 +905,169 @@ func Test_restartRunningJob(t *testing.T) {
 	assert.NoError(t, err)
 	w := httptest.NewRecorder()
 	api.server.Handler.ServeHTTP(w, req)
+	body, err := io.ReadAll(w.Body)
+	assert.NoError(t, err)
 
 	assert.NoError(t, err)
-	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "{\"status\":\"OK\"}\n", string(body))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func Test_rerunJob(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	now := time.Now().UTC()
+	j1 := tork.Job{
+		ID:        uuid.NewUUID(),
+		State:     tork.JobStateCompleted,
+		CreatedAt: now,
+		Position:  2,
+		Tasks: []*tork.Task{
+			{
+				Name: "some fake task",
+			},
+		},
+	}
+	err := ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+
+	t1 := tork.Task{
+		ID:        uuid.NewUUID(),
+		State:     tork.TaskStateCompleted,
+		CreatedAt: &now,
+		JobID:     j1.ID,
+		Position:  1,
+	}
+	err = ds.CreateTask(ctx, &t1)
+	assert.NoError(t, err)
+
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	// the job has no more tasks to run
+	req, err := http.NewRequest("PUT", fmt.Sprintf("/jobs/%s/rerun", j1.ID), nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// unknown task
+	req, err = http.NewRequest("PUT", fmt.Sprintf("/jobs/%s/rerun", j1.ID), strings.NewReader(`{"fromTask":"no-such-task"}`))
+	assert.NoError(t, err)
+	req.Header.Add("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req, err = http.NewRequest("PUT", fmt.Sprintf("/jobs/%s/rerun", j1.ID), strings.NewReader(fmt.Sprintf(`{"fromTask":"%s","withInputs":{"env":"prod"}}`, t1.ID)))
+	assert.NoError(t, err)
+	req.Header.Add("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	body, err := io.ReadAll(w.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"status\":\"OK\"}\n", string(body))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func Test_rerunJobInputSchema(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	now := time.Now().UTC()
+	j1 := tork.Job{
+		ID:        uuid.NewUUID(),
+		State:     tork.JobStateFailed,
+		CreatedAt: now,
+		Position:  1,
+		Tasks: []*tork.Task{
+			{
+				Name: "some fake task",
+			},
+		},
+		Context: tork.JobContext{
+			Inputs: map[string]string{"env": "dev", "version": "v1"},
+			InputSchema: map[string]tork.InputSpec{
+				"env":     {Type: tork.InputTypeString, Required: true, Enum: []string{"dev", "prod"}},
+				"version": {Type: tork.InputTypeString, Pattern: "^v[0-9]+$"},
+			},
+		},
+	}
+	err := ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		body string
+		code int
+	}{
+		{`{"withInputs":{"env":"staging"}}`, http.StatusBadRequest},
+		{`{"withInputs":{"env":""}}`, http.StatusBadRequest},
+		{`{"withInputs":{"version":"latest"}}`, http.StatusBadRequest},
+		{`{"withInputs":{"region":"us"}}`, http.StatusBadRequest},
+		{`{"withInputs":{"env":"prod","version":"v2"}}`, http.StatusOK},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("PUT", fmt.Sprintf("/jobs/%s/rerun", j1.ID), strings.NewReader(test.body))
+		assert.NoError(t, err)
+		req.Header.Add("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, req)
+		assert.Equal(t, test.code, w.Code, test.body)
+	}
+}
+
+func Test_restartRunningJob(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	j1 := tork.Job{
+		ID:        uuid.NewUUID(),
+		State:     tork.JobStateRunning,
+		CreatedAt: time.Now().UTC(),
+		Position:  1,
+		Tasks: []*tork.Task{
+			{
+				Name: "some fake task",
+			},
+		},
+	}
+	err := ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+
+	tasks := []tork.Task{{
+		ID:        uuid.NewUUID(),
+		State:     tork.TaskStatePending,
+		CreatedAt: &now,
+		JobID:     j1.ID,
+	}}
+
+	for _, ta := range tasks {
+		err := ds.CreateTask(ctx, &ta)
+		assert.NoError(t, err)
+	}
+
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, api)
+	req, err := http.NewRequest("PUT", fmt.Sprintf("/jobs/%s/restart", j1.ID), nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
 
 func Test_restartRunningNoMoreTasksJob(t *testing.T) {
@@ -595,6 +1116,59 @@ func Test_restartRunningNoMoreTasksJob(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
 
+func Test_pauseAndResumeJob(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	j1 := tork.Job{
+		ID:        uuid.NewUUID(),
+		State:     tork.JobStateRunning,
+		CreatedAt: time.Now().UTC(),
+	}
+	err := ds.CreateJob(ctx, &j1)
+	assert.NoError(t, err)
+
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, api)
+
+	// can't resume a running job
+	req, err := http.NewRequest("PUT", fmt.Sprintf("/jobs/%s/resume", j1.ID), nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req, err = http.NewRequest("PUT", fmt.Sprintf("/jobs/%s/pause", j1.ID), nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, "{\"status\":\"OK\"}\n", w.Body.String())
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	err = ds.UpdateJob(ctx, j1.ID, func(u *tork.Job) error {
+		u.State = tork.JobStatePaused
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// can't pause a paused job
+	req, err = http.NewRequest("PUT", fmt.Sprintf("/jobs/%s/pause", j1.ID), nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req, err = http.NewRequest("PUT", fmt.Sprintf("/jobs/%s/resume", j1.ID), nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, "{\"status\":\"OK\"}\n", w.Body.String())
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
 func Test_middleware(t *testing.T) {
 	mw := func(next web.HandlerFunc) web.HandlerFunc {
 		return func(c web.Context) error {
@@ -1054,3 +1628,995 @@ func Test_proxyTaskSomePath(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, http.StatusOK, w.Code)
 }
+
+func Test_scheduledJobs(t *testing.T) {
+	ds := inmemory.NewInMemoryDatastore()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, api)
+
+	// create
+	req, err := http.NewRequest("POST", "/scheduled-jobs", strings.NewReader(`{
+		"name":"test scheduled job",
+		"cron":"0 * * * *",
+		"secrets":{"key":"secret"},
+		"tasks":[{
+			"name":"test task",
+			"image":"some:image"
+		}]
+	}`))
+	req.Header.Add("Content-Type", "application/json")
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	sj := tork.ScheduledJobSummary{}
+	err = json.Unmarshal(w.Body.Bytes(), &sj)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.ScheduledJobStateActive, sj.State)
+	assert.NotNil(t, sj.NextRunAt)
+
+	// list
+	req, err = http.NewRequest("GET", "/scheduled-jobs", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	p := datastore.Page[*tork.ScheduledJobSummary]{}
+	err = json.Unmarshal(w.Body.Bytes(), &p)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, p.TotalItems)
+
+	// get
+	req, err = http.NewRequest("GET", "/scheduled-jobs/"+sj.ID, nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	full := tork.ScheduledJob{}
+	err = json.Unmarshal(w.Body.Bytes(), &full)
+	assert.NoError(t, err)
+	assert.Equal(t, "[REDACTED]", full.Secrets["key"])
+	assert.Len(t, full.Tasks, 1)
+
+	// resume an active job
+	req, err = http.NewRequest("PUT", "/scheduled-jobs/"+sj.ID+"/resume", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// pause
+	req, err = http.NewRequest("PUT", "/scheduled-jobs/"+sj.ID+"/pause", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	sj2, err := ds.GetScheduledJobByID(context.Background(), sj.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.ScheduledJobStatePaused, sj2.State)
+	assert.Equal(t, "secret", sj2.Secrets["key"])
+
+	// resume
+	req, err = http.NewRequest("PUT", "/scheduled-jobs/"+sj.ID+"/resume", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	sj2, err = ds.GetScheduledJobByID(context.Background(), sj.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.ScheduledJobStateActive, sj2.State)
+	assert.NotNil(t, sj2.NextRunAt)
+
+	// delete
+	req, err = http.NewRequest("DELETE", "/scheduled-jobs/"+sj.ID, nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, err = http.NewRequest("GET", "/scheduled-jobs/"+sj.ID, nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_createScheduledJobInvalidCron(t *testing.T) {
+	api, err := NewAPI(Config{
+		DataStore: inmemory.NewInMemoryDatastore(),
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+	req, err := http.NewRequest("POST", "/scheduled-jobs", strings.NewReader(`{
+		"name":"test scheduled job",
+		"cron":"bad cron",
+		"tasks":[{
+			"name":"test task",
+			"image":"some:image"
+		}]
+	}`))
+	req.Header.Add("Content-Type", "application/json")
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+type sse struct {
+	id    string
+	event string
+	data  string
+}
+
+func readSSE(t *testing.T, r *bufio.Reader) sse {
+	ev := sse{}
+	for {
+		line, err := r.ReadString('\n')
+		assert.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if ev.event != "" {
+				return ev
+			}
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			ev.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func Test_streamJobEvents(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	b := mq.NewInMemoryBroker()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    b,
+	})
+	assert.NoError(t, err)
+
+	j := &tork.Job{
+		ID:    uuid.NewUUID(),
+		Name:  "test job",
+		State: tork.JobStateRunning,
+	}
+	assert.NoError(t, ds.CreateJob(ctx, j))
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: j.ID,
+		State: tork.TaskStateRunning,
+	}
+	assert.NoError(t, ds.CreateTask(ctx, tk))
+
+	svr := httptest.NewServer(api.server.Handler)
+	defer svr.Close()
+
+	resp, err := http.Get(fmt.Sprintf("%s/jobs/%s/events", svr.URL, j.ID))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	r := bufio.NewReader(resp.Body)
+
+	// snapshot
+	ev := readSSE(t, r)
+	assert.Equal(t, "job", ev.event)
+	assert.Equal(t, "", ev.id)
+	js := tork.JobSummary{}
+	assert.NoError(t, json.Unmarshal([]byte(ev.data), &js))
+	assert.Equal(t, j.ID, js.ID)
+	assert.Equal(t, tork.JobStateRunning, js.State)
+	ev = readSSE(t, r)
+	assert.Equal(t, "task", ev.event)
+
+	// live updates
+	assert.NoError(t, b.PublishEvent(ctx, mq.TOPIC_UPDATES_TASK, &tork.Task{
+		ID:    tk.ID,
+		JobID: j.ID,
+		State: tork.TaskStateCompleted,
+	}))
+	assert.NoError(t, b.PublishEvent(ctx, mq.TOPIC_UPDATES_JOB, &tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateCompleted,
+	}))
+	assert.NoError(t, b.PublishEvent(ctx, mq.TOPIC_UPDATES_JOB, &tork.Job{
+		ID:    j.ID,
+		State: tork.JobStateCompleted,
+	}))
+
+	ev = readSSE(t, r)
+	assert.Equal(t, "task", ev.event)
+	assert.Equal(t, "1", ev.id)
+	ts := tork.TaskSummary{}
+	assert.NoError(t, json.Unmarshal([]byte(ev.data), &ts))
+	assert.Equal(t, tk.ID, ts.ID)
+	assert.Equal(t, tork.TaskStateCompleted, ts.State)
+
+	ev = readSSE(t, r)
+	assert.Equal(t, "job", ev.event)
+	assert.Equal(t, "3", ev.id)
+
+	ev = readSSE(t, r)
+	assert.Equal(t, "end", ev.event)
+
+	_, err = r.ReadString('\n')
+	assert.ErrorIs(t, err, io.EOF)
+
+	// resume from cursor
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/jobs/%s/events", svr.URL, j.ID), nil)
+	assert.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "1")
+	resp2, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp2.Body.Close()
+	r = bufio.NewReader(resp2.Body)
+	ev = readSSE(t, r)
+	assert.Equal(t, "job", ev.event)
+	assert.Equal(t, "3", ev.id)
+}
+
+func Test_streamJobEventsBadRequest(t *testing.T) {
+	ds := inmemory.NewInMemoryDatastore()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("GET", "/jobs/no-such-job/events", nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	j := &tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateRunning,
+	}
+	assert.NoError(t, ds.CreateJob(context.Background(), j))
+	req, err = http.NewRequest("GET", fmt.Sprintf("/jobs/%s/events?cursor=abc", j.ID), nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func Test_streamTaskLog(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	b := mq.NewInMemoryBroker()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    b,
+	})
+	assert.NoError(t, err)
+
+	j := &tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateRunning,
+	}
+	assert.NoError(t, ds.CreateJob(ctx, j))
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: j.ID,
+		State: tork.TaskStateRunning,
+	}
+	assert.NoError(t, ds.CreateTask(ctx, tk))
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+			TaskID:   tk.ID,
+			Number:   i,
+			Contents: fmt.Sprintf("line %d", i),
+		}))
+	}
+
+	svr := httptest.NewServer(api.server.Handler)
+	defer svr.Close()
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/tasks/%s/log/stream", svr.URL, tk.ID), nil)
+	assert.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	r := bufio.NewReader(resp.Body)
+
+	// stored parts
+	ev := readSSE(t, r)
+	assert.Equal(t, "log", ev.event)
+	assert.Equal(t, "2", ev.id)
+	p := tork.TaskLogPart{}
+	assert.NoError(t, json.Unmarshal([]byte(ev.data), &p))
+	assert.Equal(t, "line 2", p.Contents)
+	ev = readSSE(t, r)
+	assert.Equal(t, "3", ev.id)
+
+	// live parts
+	assert.NoError(t, b.PublishEvent(ctx, mq.TOPIC_UPDATES_LOG_PART, &tork.TaskLogPart{
+		TaskID:   tk.ID,
+		Number:   3,
+		Contents: "line 3",
+	}))
+	assert.NoError(t, b.PublishEvent(ctx, mq.TOPIC_UPDATES_LOG_PART, &tork.TaskLogPart{
+		TaskID:   uuid.NewUUID(),
+		Number:   4,
+		Contents: "other task",
+	}))
+	assert.NoError(t, b.PublishEvent(ctx, mq.TOPIC_UPDATES_LOG_PART, &tork.TaskLogPart{
+		TaskID:   tk.ID,
+		Number:   4,
+		Contents: "line 4",
+	}))
+	assert.NoError(t, b.PublishEvent(ctx, mq.TOPIC_UPDATES_TASK, &tork.Task{
+		ID:    tk.ID,
+		JobID: j.ID,
+		State: tork.TaskStateCompleted,
+	}))
+	assert.NoError(t, b.PublishEvent(ctx, mq.TOPIC_UPDATES_LOG_PART, &tork.TaskLogPart{
+		TaskID:   tk.ID,
+		Number:   5,
+		Contents: "line 5",
+	}))
+
+	ev = readSSE(t, r)
+	assert.Equal(t, "log", ev.event)
+	assert.Equal(t, "4", ev.id)
+	assert.NoError(t, json.Unmarshal([]byte(ev.data), &p))
+	assert.Equal(t, "line 4", p.Contents)
+
+	ev = readSSE(t, r)
+	assert.Equal(t, "task", ev.event)
+
+	// trailing part
+	ev = readSSE(t, r)
+	assert.Equal(t, "log", ev.event)
+	assert.Equal(t, "5", ev.id)
+
+	ev = readSSE(t, r)
+	assert.Equal(t, "end", ev.event)
+}
+
+func Test_streamTaskLogCompleted(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	j := &tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateRunning,
+	}
+	assert.NoError(t, ds.CreateJob(ctx, j))
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: j.ID,
+		State: tork.TaskStateCompleted,
+	}
+	assert.NoError(t, ds.CreateTask(ctx, tk))
+	for i := 1; i <= 150; i++ {
+		assert.NoError(t, ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+			TaskID:   tk.ID,
+			Number:   i,
+			Contents: fmt.Sprintf("line %d", i),
+		}))
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/tasks/%s/log/stream", tk.ID), nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	r := bufio.NewReader(w.Body)
+	for i := 1; i <= 150; i++ {
+		ev := readSSE(t, r)
+		assert.Equal(t, "log", ev.event)
+		assert.Equal(t, strconv.Itoa(i), ev.id)
+	}
+	ev := readSSE(t, r)
+	assert.Equal(t, "end", ev.event)
+}
+
+func doRequest(t *testing.T, api *API, method, path, body string) (int, []byte) {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, path, r)
+	assert.NoError(t, err)
+	req.Header.Add("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	b, err := io.ReadAll(w.Body)
+	assert.NoError(t, err)
+	return w.Code, b
+}
+
+func Test_manageUsers(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	code, body := doRequest(t, api, "POST", "/users", `{"username":"tester","password":"secret"}`)
+	assert.Equal(t, http.StatusOK, code)
+	u := tork.User{}
+	assert.NoError(t, json.Unmarshal(body, &u))
+	assert.Equal(t, "tester", u.Username)
+	assert.Empty(t, u.Password)
+
+	code, body = doRequest(t, api, "GET", "/users", "")
+	assert.Equal(t, http.StatusOK, code)
+	page := datastore.Page[*tork.User]{}
+	assert.NoError(t, json.Unmarshal(body, &page))
+	assert.Equal(t, 1, page.TotalItems)
+	assert.Equal(t, "tester", page.Items[0].Username)
+
+	code, body = doRequest(t, api, "GET", "/users/tester", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.NoError(t, json.Unmarshal(body, &u))
+	assert.Equal(t, "tester", u.Username)
+
+	code, _ = doRequest(t, api, "GET", "/users/no-such-user", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// disable / enable
+	code, _ = doRequest(t, api, "PUT", "/users/tester/disable", "")
+	assert.Equal(t, http.StatusOK, code)
+	u2, err := ds.GetUser(ctx, "tester")
+	assert.NoError(t, err)
+	assert.True(t, u2.Disabled)
+
+	code, _ = doRequest(t, api, "PUT", "/users/tester/enable", "")
+	assert.Equal(t, http.StatusOK, code)
+	u2, err = ds.GetUser(ctx, "tester")
+	assert.NoError(t, err)
+	assert.False(t, u2.Disabled)
+
+	code, _ = doRequest(t, api, "PUT", "/users/guest/disable", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// change password
+	code, _ = doRequest(t, api, "PUT", "/users/tester/password", `{"password":""}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = doRequest(t, api, "PUT", "/users/tester/password", `{"password":"new-secret"}`)
+	assert.Equal(t, http.StatusOK, code)
+	u2, err = ds.GetUser(ctx, "tester")
+	assert.NoError(t, err)
+	assert.True(t, hash.CheckPasswordHash("new-secret", u2.PasswordHash))
+	assert.False(t, hash.CheckPasswordHash("secret", u2.PasswordHash))
+
+	// delete
+	code, _ = doRequest(t, api, "DELETE", "/users/guest", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = doRequest(t, api, "DELETE", "/users/tester", "")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = doRequest(t, api, "DELETE", "/users/tester", "")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func Test_manageRoles(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	u := &tork.User{
+		ID:       uuid.NewUUID(),
+		Username: "tester",
+	}
+	assert.NoError(t, ds.CreateUser(ctx, u))
+
+	code, _ := doRequest(t, api, "POST", "/roles", `{"slug":"Bad Slug","name":"Bad"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = doRequest(t, api, "POST", "/roles", `{"slug":"devs"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, body := doRequest(t, api, "POST", "/roles", `{"slug":"devs","name":"Developers"}`)
+	assert.Equal(t, http.StatusOK, code)
+	r := tork.Role{}
+	assert.NoError(t, json.Unmarshal(body, &r))
+	assert.Equal(t, "devs", r.Slug)
+	assert.NotEmpty(t, r.ID)
+
+	code, _ = doRequest(t, api, "POST", "/roles", `{"slug":"devs","name":"Developers"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, body = doRequest(t, api, "GET", "/roles", "")
+	assert.Equal(t, http.StatusOK, code)
+	roles := []*tork.Role{}
+	assert.NoError(t, json.Unmarshal(body, &roles))
+	assert.Len(t, roles, 1)
+
+	code, _ = doRequest(t, api, "GET", "/roles/devs", "")
+	assert.Equal(t, http.StatusOK, code)
+
+	// assign
+	code, _ = doRequest(t, api, "PUT", "/users/tester/roles/devs", "")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = doRequest(t, api, "PUT", "/users/tester/roles/devs", "")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = doRequest(t, api, "PUT", "/users/tester/roles/no-such-role", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, body = doRequest(t, api, "GET", "/users/tester/roles", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.NoError(t, json.Unmarshal(body, &roles))
+	assert.Len(t, roles, 1)
+	assert.Equal(t, "devs", roles[0].Slug)
+
+	// unassign
+	code, _ = doRequest(t, api, "DELETE", "/users/tester/roles/devs", "")
+	assert.Equal(t, http.StatusOK, code)
+	uroles, err := ds.GetUserRoles(ctx, u.ID)
+	assert.NoError(t, err)
+	assert.Len(t, uroles, 0)
+
+	// delete
+	code, _ = doRequest(t, api, "DELETE", "/roles/devs", "")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = doRequest(t, api, "DELETE", "/roles/devs", "")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func Test_usersDisabled(t *testing.T) {
+	api, err := NewAPI(Config{
+		DataStore: inmemory.NewInMemoryDatastore(),
+		Broker:    mq.NewInMemoryBroker(),
+		Enabled:   map[string]bool{"users": false},
+	})
+	assert.NoError(t, err)
+	code, _ := doRequest(t, api, "GET", "/users", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = doRequest(t, api, "GET", "/roles", "")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func Test_jobTemplates(t *testing.T) {
+	api, err := NewAPI(Config{
+		DataStore: inmemory.NewInMemoryDatastore(),
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, api)
+
+	do := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		assert.NoError(t, err)
+		if contentType != "" {
+			req.Header.Add("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/templates", "application/json", `{"name":"deploy","job":{"name":"deploy job","tasks":[{"name":"test task","image":"some:image"}],"inputSchema":{"env":{"required":true}}}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	t1 := tork.JobTemplate{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &t1))
+	assert.Equal(t, 1, t1.Version)
+
+	w = do("POST", "/templates", "text/yaml", `
+name: deploy
+description: second version
+job:
+  name: deploy job v2
+  tasks:
+    - name: test task
+      image: some:image
+`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	t2 := tork.JobTemplate{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &t2))
+	assert.Equal(t, 2, t2.Version)
+
+	w = do("POST", "/templates", "application/json", `{"name":"deploy@3","job":{"name":"deploy job","tasks":[{"name":"test task","image":"some:image"}]}}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do("GET", "/templates/deploy", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	latest := tork.JobTemplate{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &latest))
+	assert.Equal(t, 2, latest.Version)
+
+	w = do("GET", "/templates/deploy@7", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = do("GET", "/templates", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	p := datastore.Page[*tork.JobTemplate]{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+	assert.Equal(t, 1, p.TotalItems)
+	assert.Equal(t, "deploy@2", p.Items[0].Ref())
+
+	// a job from the first version of the template
+	w = do("POST", "/jobs", "application/json", `{"template":"deploy@1","inputs":{"env":"prod"}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	js := tork.JobSummary{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &js))
+	assert.Equal(t, "deploy job", js.Name)
+	assert.Equal(t, map[string]string{"env": "prod"}, js.Inputs)
+
+	// missing required input
+	w = do("POST", "/jobs", "application/json", `{"template":"deploy@1"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do("POST", "/jobs", "application/json", `{"template":"no-such-template"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do("DELETE", "/templates/deploy@2", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = do("GET", "/templates/deploy", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &latest))
+	assert.Equal(t, 1, latest.Version)
+
+	w = do("DELETE", "/templates/deploy", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = do("DELETE", "/templates/deploy", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_jobTemplateOwner(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	u1 := &tork.User{ID: uuid.NewUUID(), Username: "user1", Name: "User 1"}
+	assert.NoError(t, ds.CreateUser(ctx, u1))
+	u2 := &tork.User{ID: uuid.NewUUID(), Username: "user2", Name: "User 2"}
+	assert.NoError(t, ds.CreateUser(ctx, u2))
+
+	do := func(username, method, path, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequestWithContext(context.WithValue(ctx, tork.USERNAME, username), method, path, strings.NewReader(body))
+		assert.NoError(t, err)
+		req.Header.Add("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	body := `{"name":"deploy","job":{"name":"deploy job","tasks":[{"name":"test task","image":"some:image"}]}}`
+
+	w := do(u1.Username, "POST", "/templates", body)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// only the owner can publish new versions
+	w = do(u2.Username, "POST", "/templates", body)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = do(u1.Username, "POST", "/templates", body)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// or delete them
+	w = do(u2.Username, "DELETE", "/templates/deploy@1", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = do(u2.Username, "DELETE", "/templates/deploy", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	tk, err := ds.GetJobTemplate(ctx, "deploy", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, tk.Version)
+
+	w = do(u1.Username, "DELETE", "/templates/deploy", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the name is free again
+	w = do(u2.Username, "POST", "/templates", body)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func Test_resolveJobTemplatePermissions(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	u1 := &tork.User{ID: uuid.NewUUID(), Username: "user1", Name: "User 1"}
+	assert.NoError(t, ds.CreateUser(ctx, u1))
+	u2 := &tork.User{ID: uuid.NewUUID(), Username: "user2", Name: "User 2"}
+	assert.NoError(t, ds.CreateUser(ctx, u2))
+
+	assert.NoError(t, ds.CreateJobTemplate(ctx, &tork.JobTemplate{
+		ID:          uuid.NewUUID(),
+		Name:        "private",
+		Permissions: []*tork.Permission{{User: &tork.User{Username: u1.Username}}},
+		Job:         []byte(`{"name":"private job","tasks":[{"name":"test task","image":"some:image"}]}`),
+	}))
+
+	tk, err := api.resolveJobTemplate(context.WithValue(ctx, tork.USERNAME, u1.Username), "private")
+	assert.NoError(t, err)
+	assert.Equal(t, "private@1", tk.Ref())
+
+	_, err = api.resolveJobTemplate(context.WithValue(ctx, tork.USERNAME, u2.Username), "private")
+	assert.ErrorIs(t, err, datastore.ErrJobTemplateNotFound)
+
+	_, err = api.SubmitJob(context.WithValue(ctx, tork.USERNAME, u2.Username), &input.Job{Template: "private"})
+	assert.ErrorIs(t, err, datastore.ErrJobTemplateNotFound)
+
+	j, err := api.SubmitJob(context.WithValue(ctx, tork.USERNAME, u1.Username), &input.Job{Template: "private"})
+	assert.NoError(t, err)
+	assert.Equal(t, "private job", j.Name)
+	assert.Equal(t, u1.Username, j.CreatedBy.Username)
+}
+
+func Test_libraryTasks(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, api)
+
+	do := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		assert.NoError(t, err)
+		if contentType != "" {
+			req.Header.Add("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/library", "text/yaml", `
+name: lint
+params:
+  path:
+    required: true
+task:
+  name: lint
+  image: golangci/golangci-lint
+  run: golangci-lint run {{ with.path }}
+`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	lt := tork.LibraryTask{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &lt))
+	assert.Equal(t, 1, lt.Version)
+
+	w = do("GET", "/library/lint@v1", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = do("GET", "/library/lint@v2", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = do("GET", "/library", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	p := datastore.Page[*tork.LibraryTask]{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+	assert.Equal(t, 1, p.TotalItems)
+
+	w = do("POST", "/jobs", "text/yaml", `
+name: test job
+tasks:
+  - name: lint code
+    uses: lint@v1
+    with:
+      path: ./...
+`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	js := tork.JobSummary{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &js))
+	j, err := ds.GetJobByID(ctx, js.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "lint code", j.Tasks[0].Name)
+	assert.Equal(t, "golangci/golangci-lint", j.Tasks[0].Image)
+	assert.Equal(t, "golangci-lint run ./...", j.Tasks[0].Run)
+
+	// missing required parameter
+	w = do("POST", "/jobs", "application/json", `{"name":"test job","tasks":[{"name":"lint code","uses":"lint"}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do("DELETE", "/library/lint", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = do("GET", "/library/lint", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_libraryTaskOwner(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	u1 := &tork.User{ID: uuid.NewUUID(), Username: "user1", Name: "User 1"}
+	assert.NoError(t, ds.CreateUser(ctx, u1))
+	u2 := &tork.User{ID: uuid.NewUUID(), Username: "user2", Name: "User 2"}
+	assert.NoError(t, ds.CreateUser(ctx, u2))
+
+	do := func(username, method, path, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequestWithContext(context.WithValue(ctx, tork.USERNAME, username), method, path, strings.NewReader(body))
+		assert.NoError(t, err)
+		req.Header.Add("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	body := `{"name":"lint","task":{"name":"lint","image":"golangci/golangci-lint"}}`
+
+	w := do(u1.Username, "POST", "/library", body)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// only the owner can publish new versions
+	w = do(u2.Username, "POST", "/library", `{"name":"lint","task":{"name":"lint","image":"evil:image"}}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = do(u1.Username, "POST", "/library", body)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// or delete them
+	w = do(u2.Username, "DELETE", "/library/lint@v1", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = do(u2.Username, "DELETE", "/library/lint", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	lt, err := ds.GetLibraryTask(ctx, "lint", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, lt.Version)
+
+	w = do(u1.Username, "DELETE", "/library/lint", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the name is free again
+	w = do(u2.Username, "POST", "/library", body)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func Test_scheduledJobUsesLibraryTask(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	err = ds.CreateLibraryTask(ctx, &tork.LibraryTask{
+		ID:     uuid.NewUUID(),
+		Name:   "lint",
+		Params: []byte(`{"path":{"required":true}}`),
+		Task:   []byte(`{"name":"lint","image":"golangci/golangci-lint","run":"golangci-lint run {{ with.path }}"}`),
+	})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/scheduled-jobs", strings.NewReader(`{
+		"name":"nightly lint",
+		"cron":"0 * * * *",
+		"tasks":[{
+			"name":"lint code",
+			"uses":"lint",
+			"with":{"path":"./..."}
+		}]
+	}`))
+	assert.NoError(t, err)
+	req.Header.Add("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	summary := tork.ScheduledJobSummary{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &summary))
+
+	// every run copies the expanded tasks of the scheduled job
+	sj, err := ds.GetScheduledJobByID(ctx, summary.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "lint code", sj.Tasks[0].Name)
+	assert.Equal(t, "golangci/golangci-lint", sj.Tasks[0].Image)
+	assert.Equal(t, "golangci-lint run ./...", sj.Tasks[0].Run)
+}
+
+func Test_streamTaskLogStaleCursor(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+	})
+	assert.NoError(t, err)
+
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: uuid.NewUUID(),
+		State: tork.TaskStateCompleted,
+	}
+	assert.NoError(t, ds.CreateTask(ctx, tk))
+	for i := 1; i <= 2; i++ {
+		assert.NoError(t, ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+			TaskID:   tk.ID,
+			Number:   i,
+			Contents: fmt.Sprintf("line %d", i),
+		}))
+	}
+
+	// the cursor is beyond the task's stored log
+	req, err := http.NewRequest("GET", fmt.Sprintf("/tasks/%s/log/stream?cursor=7", tk.ID), nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	r := bufio.NewReader(w.Body)
+	for i := 1; i <= 2; i++ {
+		ev := readSSE(t, r)
+		assert.Equal(t, "log", ev.event)
+		assert.Equal(t, strconv.Itoa(i), ev.id)
+	}
+	ev := readSSE(t, r)
+	assert.Equal(t, "end", ev.event)
+}
+
+func Test_streamTaskLogForbidden(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	api, err := NewAPI(Config{
+		DataStore: ds,
+		Broker:    mq.NewInMemoryBroker(),
+		Middleware: Middleware{
+			Task: []task.MiddlewareFunc{
+				func(next task.HandlerFunc) task.HandlerFunc {
+					return func(ctx context.Context, et task.EventType, t *tork.Task) error {
+						if et == task.Read {
+							return echo.NewHTTPError(http.StatusForbidden)
+						}
+						return next(ctx, et, t)
+					}
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: uuid.NewUUID(),
+		State: tork.TaskStateCompleted,
+	}
+	assert.NoError(t, ds.CreateTask(ctx, tk))
+	assert.NoError(t, ds.CreateTaskLogPart(ctx, &tork.TaskLogPart{
+		TaskID:   tk.ID,
+		Number:   1,
+		Contents: "secret",
+	}))
+
+	code, body := doRequest(t, api, http.MethodGet, fmt.Sprintf("/tasks/%s/log/stream", tk.ID), "")
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.NotContains(t, string(body), "secret")
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/runabol/tork"
+)
+
+const (
+	// the number of updates a stream can fall behind
+	// before it gets disconnected.
+	STREAM_BUFFER_SIZE = 256
+	// the number of recent job and task updates kept around
+	// for clients that reconnect with a cursor.
+	STREAM_HISTORY_SIZE = 1024
+)
+
+var (
+	// how often an idle stream sends a comment to
+	// keep the connection alive.
+	STREAM_HEARTBEAT_INTERVAL = time.Second * 15
+	// how long a task's log stream stays open after
+	// the task is done to deliver trailing log parts.
+	STREAM_LOG_GRACE_PERIOD = time.Second
+)
+
+const (
+	streamEventJob  = "job"
+	streamEventTask = "task"
+	streamEventLog  = "log"
+	streamEventEnd  = "end"
+)
+
+// update is a single live update received from the broker
+type update struct {
+	id     int64
+	event  string
+	jobID  string
+	taskID string
+	data   any
+}
+
+// stream is a single client's subscription to the hub.
+// A job stream receives the job's updates and the updates of
+// its tasks while a task stream receives the task's updates and
+// its log parts.
+type stream struct {
+	jobID  string
+	taskID string
+	ch     chan *update
+}
+
+func (s *stream) accepts(u *update) bool {
+	if s.taskID != "" {
+		return u.taskID == s.taskID
+	}
+	return u.event != streamEventLog && u.jobID == s.jobID
+}
+
+// hub receives the live updates from the broker and fans
+// them out to the streams of the connected clients.
+type hub struct {
+	mu      sync.Mutex
+	seq     int64
+	history []*update
+	streams map[*stream]struct{}
+}
+
+func newHub() *hub {
+	return &hub{
+		history: make([]*update, 0, STREAM_HISTORY_SIZE),
+		streams: make(map[*stream]struct{}),
+	}
+}
+
+func (h *hub) publish(ev any) {
+	u := &update{}
+	switch v := ev.(type) {
+	case *tork.Job:
+		u.event = streamEventJob
+		u.jobID = v.ID
+		u.data = tork.NewJobSummary(v)
+	case *tork.Task:
+		u.event = streamEventTask
+		u.jobID = v.JobID
+		u.taskID = v.ID
+		u.data = tork.NewTaskSummary(v)
+	case *tork.TaskLogPart:
+		u.event = streamEventLog
+		u.taskID = v.TaskID
+		u.id = int64(v.Number)
+		u.data = v
+	default:
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// log parts are identified by their number
+	// and are replayed from the datastore
+	if u.event != streamEventLog {
+		h.seq = h.seq + 1
+		u.id = h.seq
+		if len(h.history) == STREAM_HISTORY_SIZE {
+			h.history = append(h.history[:0], h.history[1:]...)
+		}
+		h.history = append(h.history, u)
+	}
+	for s := range h.streams {
+		if !s.accepts(u) {
+			continue
+		}
+		select {
+		case s.ch <- u:
+		default:
+			// the client is too slow to keep up with the
+			// updates. disconnect it so it can resume from
+			// its last cursor.
+			delete(h.streams, s)
+			close(s.ch)
+		}
+	}
+}
+
+// subscribe registers a new stream. If a cursor is provided, the job
+// and task updates that came after it are returned for replay. ok is
+// false when the hub can't tell which updates the client missed.
+func (h *hub) subscribe(jobID, taskID string, cursor int64) (s *stream, replay []*update, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s = &stream{
+		jobID:  jobID,
+		taskID: taskID,
+		ch:     make(chan *update, STREAM_BUFFER_SIZE),
+	}
+	h.streams[s] = struct{}{}
+	if cursor <= 0 || cursor > h.seq {
+		return s, nil, false
+	}
+	if len(h.history) > 0 && cursor < h.history[0].id-1 {
+		return s, nil, false
+	}
+	for _, u := range h.history {
+		if u.id > cursor && s.accepts(u) {
+			replay = append(replay, u)
+		}
+	}
+	return s, replay, true
+}
+
+func (h *hub) unsubscribe(s *stream) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.streams[s]; ok {
+		delete(h.streams, s)
+		close(s.ch)
+	}
+}
+
+// writeEvent writes a single server-sent event.
+func writeEvent(w io.Writer, id int64, event string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+	return err
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_hubPublish(t *testing.T) {
+	h := newHub()
+
+	js, _, ok := h.subscribe("j1", "", 0)
+	assert.False(t, ok)
+	ts, _, _ := h.subscribe("j1", "t1", 0)
+
+	h.publish(&tork.Job{ID: "j1", State: tork.JobStateRunning})
+	h.publish(&tork.Job{ID: "j2", State: tork.JobStateRunning})
+	h.publish(&tork.Task{ID: "t1", JobID: "j1", State: tork.TaskStateRunning})
+	h.publish(&tork.Task{ID: "t2", JobID: "j1", State: tork.TaskStateRunning})
+	h.publish(&tork.TaskLogPart{TaskID: "t1", Number: 7, Contents: "hello"})
+	h.publish("bad event")
+
+	assert.Len(t, js.ch, 3)
+	u := <-js.ch
+	assert.Equal(t, streamEventJob, u.event)
+	assert.Equal(t, int64(1), u.id)
+	u = <-js.ch
+	assert.Equal(t, streamEventTask, u.event)
+	assert.Equal(t, "t1", u.taskID)
+	u = <-js.ch
+	assert.Equal(t, "t2", u.taskID)
+
+	assert.Len(t, ts.ch, 2)
+	u = <-ts.ch
+	assert.Equal(t, streamEventTask, u.event)
+	u = <-ts.ch
+	assert.Equal(t, streamEventLog, u.event)
+	assert.Equal(t, int64(7), u.id)
+
+	h.unsubscribe(js)
+	h.unsubscribe(js)
+	_, ok = <-js.ch
+	assert.False(t, ok)
+}
+
+func Test_hubReplay(t *testing.T) {
+	h := newHub()
+
+	for i := 0; i < 5; i++ {
+		h.publish(&tork.Job{ID: "j1", State: tork.JobStateRunning})
+		h.publish(&tork.Job{ID: "j2", State: tork.JobStateRunning})
+	}
+
+	_, replay, ok := h.subscribe("j1", "", 6)
+	assert.True(t, ok)
+	assert.Len(t, replay, 2)
+	assert.Equal(t, int64(7), replay[0].id)
+	assert.Equal(t, int64(9), replay[1].id)
+
+	// cursor from the future
+	_, _, ok = h.subscribe("j1", "", 100)
+	assert.False(t, ok)
+
+	// cursor which is no longer in the history
+	for i := 0; i < STREAM_HISTORY_SIZE; i++ {
+		h.publish(&tork.Job{ID: "j2", State: tork.JobStateRunning})
+	}
+	_, _, ok = h.subscribe("j1", "", 6)
+	assert.False(t, ok)
+}
+
+func Test_hubSlowStream(t *testing.T) {
+	h := newHub()
+	s, _, _ := h.subscribe("j1", "", 0)
+	for i := 0; i < STREAM_BUFFER_SIZE+1; i++ {
+		h.publish(&tork.Job{ID: "j1", State: tork.JobStateRunning})
+	}
+	n := 0
+	for range s.ch {
+		n = n + 1
+	}
+	assert.Equal(t, STREAM_BUFFER_SIZE, n)
+	assert.Len(t, h.streams, 0)
+}
+
+func Test_writeEvent(t *testing.T) {
+	var b bytes.Buffer
+	err := writeEvent(&b, 5, streamEventLog, &tork.TaskLogPart{Number: 5, Contents: "hello"})
+	assert.NoError(t, err)
+	assert.Equal(t, "id: 5\nevent: log\ndata: {\"number\":5,\"contents\":\"hello\"}\n\n", b.String())
+
+	b.Reset()
+	err = writeEvent(&b, 0, streamEventEnd, map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, "event: end\ndata: {}\n\n", b.String())
+}
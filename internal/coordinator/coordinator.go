@@ -10,12 +10,16 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/archive"
+	"github.com/runabol/tork/artifact"
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/internal/coordinator/api"
 	"github.com/runabol/tork/internal/coordinator/handlers"
+	"github.com/runabol/tork/internal/coordinator/scheduler"
 	"github.com/runabol/tork/internal/host"
 
 	"github.com/runabol/tork/input"
+	"github.com/runabol/tork/leader"
 	"github.com/runabol/tork/middleware/job"
 	"github.com/runabol/tork/middleware/node"
 	"github.com/runabol/tork/middleware/task"
@@ -45,18 +49,30 @@ type Coordinator struct {
 	onCompleted task.HandlerFunc
 	onLogPart   func(*tork.TaskLogPart)
 	onProgress  task.HandlerFunc
+	cron        *scheduler.CronScheduler
+	retry       *scheduler.RetryScheduler
+	reaper      *scheduler.Reaper
+	elector     leader.Elector
 	stop        chan any
 }
 
 type Config struct {
-	Name       string
-	Broker     mq.Broker
-	DataStore  datastore.Datastore
-	Address    string
-	Queues     map[string]int
-	Endpoints  map[string]web.HandlerFunc
-	Enabled    map[string]bool
-	Middleware Middleware
+	Name              string
+	Broker            mq.Broker
+	DataStore         datastore.Datastore
+	Artifacts         artifact.Store
+	Archive           archive.Sink
+	Address           string
+	IdempotencyWindow time.Duration
+	ScheduledTimeout  time.Duration
+	Queues            map[string]int
+	Endpoints         map[string]web.HandlerFunc
+	Enabled           map[string]bool
+	Middleware        Middleware
+	// Elector elects the coordinator which performs the
+	// singleton duties of the cluster. The coordinator
+	// takes on the ID of its candidate.
+	Elector leader.Elector
 }
 
 type Middleware struct {
@@ -74,6 +90,9 @@ func NewCoordinator(cfg Config) (*Coordinator, error) {
 	if cfg.DataStore == nil {
 		return nil, errors.New("most provide a datastore")
 	}
+	if cfg.Elector == nil {
+		cfg.Elector = leader.NewInMemoryElector(uuid.NewShortUUID())
+	}
 	if cfg.Queues == nil {
 		cfg.Queues = make(map[string]int)
 	}
@@ -108,9 +127,13 @@ func NewCoordinator(cfg Config) (*Coordinator, error) {
 		cfg.Queues[mq.QUEUE_PROGRESS] = 1
 	}
 	api, err := api.NewAPI(api.Config{
-		Broker:    cfg.Broker,
-		DataStore: cfg.DataStore,
-		Address:   cfg.Address,
+		Broker:            cfg.Broker,
+		DataStore:         cfg.DataStore,
+		Artifacts:         cfg.Artifacts,
+		Archive:           cfg.Archive,
+		Address:           cfg.Address,
+		IdempotencyWindow: cfg.IdempotencyWindow,
+		Elector:           cfg.Elector,
 		Middleware: api.Middleware{
 			Web:  cfg.Middleware.Web,
 			Echo: cfg.Middleware.Echo,
@@ -170,7 +193,7 @@ func NewCoordinator(cfg Config) (*Coordinator, error) {
 		cfg.Middleware.Node,
 	)
 
-	onLogPart := handlers.NewLogHandler(cfg.DataStore)
+	onLogPart := handlers.NewLogHandler(cfg.DataStore, cfg.Broker)
 
 	onProgress := task.ApplyMiddleware(
 		handlers.NewProgressHandler(
@@ -181,7 +204,7 @@ func NewCoordinator(cfg Config) (*Coordinator, error) {
 	)
 
 	return &Coordinator{
-		id:          uuid.NewShortUUID(),
+		id:          cfg.Elector.ID(),
 		startTime:   time.Now(),
 		Name:        cfg.Name,
 		api:         api,
@@ -196,6 +219,10 @@ func NewCoordinator(cfg Config) (*Coordinator, error) {
 		onCompleted: onCompleted,
 		onLogPart:   onLogPart,
 		onProgress:  onProgress,
+		cron:        scheduler.NewCronScheduler(cfg.DataStore, cfg.Broker),
+		retry:       scheduler.NewRetryScheduler(cfg.DataStore, cfg.Broker),
+		reaper:      scheduler.NewReaper(cfg.DataStore, cfg.Broker, cfg.ScheduledTimeout),
+		elector:     cfg.Elector,
 		stop:        make(chan any),
 	}, nil
 }
@@ -263,6 +290,15 @@ func (c *Coordinator) Start() error {
 		}
 	}
 	go c.sendHeartbeats()
+	// start publishing delayed retries
+	c.retry.Start()
+	// triggering scheduled jobs and recovering orphaned
+	// tasks is left to the leader coordinator
+	c.cron.Register(c.elector)
+	c.reaper.Register(c.elector)
+	if err := c.elector.Start(); err != nil {
+		return errors.Wrapf(err, "error starting leader election")
+	}
 	return nil
 }
 
@@ -298,6 +334,10 @@ func (c *Coordinator) jobHandler(handler job.HandlerFunc) job.HandlerFunc {
 func (c *Coordinator) Stop() error {
 	log.Debug().Msgf("shutting down %s", c.Name)
 	close(c.stop)
+	c.retry.Stop()
+	if err := c.elector.Stop(); err != nil {
+		return errors.Wrapf(err, "error stopping leader election")
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 	if err := c.broker.Shutdown(ctx); err != nil {
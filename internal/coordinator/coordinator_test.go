@@ -383,6 +383,12 @@ func TestRunJobDefaultsJob(t *testing.T) {
 	assert.Equal(t, 2, len(j1.Execution))
 }
 
+func TestRunDAGJob(t *testing.T) {
+	j1 := doRunJob(t, "../../examples/dag.yaml")
+	assert.Equal(t, tork.JobStateCompleted, j1.State)
+	assert.Equal(t, 4, len(j1.Execution))
+}
+
 func doRunJob(t *testing.T, filename string) *tork.Job {
 	ctx := context.Background()
 
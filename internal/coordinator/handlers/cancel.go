@@ -27,7 +27,13 @@ func NewCancelHandler(ds datastore.Datastore, b mq.Broker) job.HandlerFunc {
 func (h *cancelHandler) handle(ctx context.Context, _ job.EventType, j *tork.Job) error {
 	// mark the job as cancelled
 	if err := h.ds.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
-		if u.State != tork.JobStateRunning && u.State != tork.JobStateScheduled {
+		// jobs held by their concurrency key are
+		// still PENDING but can be cancelled as well
+		held := u.State == tork.JobStatePending && u.Concurrency != nil
+		if u.State != tork.JobStateRunning &&
+			u.State != tork.JobStateScheduled &&
+			u.State != tork.JobStatePaused &&
+			!held {
 			// job is not running -- nothing to cancel
 			return nil
 		}
@@ -36,6 +42,11 @@ func (h *cancelHandler) handle(ctx context.Context, _ job.EventType, j *tork.Job
 	}); err != nil {
 		return err
 	}
+	if j.Concurrency != nil {
+		if err := releaseConcurrencySlot(ctx, h.ds, h.broker, j.ID); err != nil {
+			return err
+		}
+	}
 	// if there's a parent task notify the parent job to cancel as well
 	if j.ParentID != "" {
 		pt, err := h.ds.GetTaskByID(ctx, j.ParentID)
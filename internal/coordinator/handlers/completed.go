@@ -61,6 +61,7 @@ func (h *completedHandler) completeSubTask(ctx context.Context, t *tork.Task) er
 
 func (h *completedHandler) completeEachTask(ctx context.Context, t *tork.Task) error {
 	var isLast bool
+	var next *tork.Task
 	err := h.ds.WithTx(ctx, func(tx datastore.Datastore) error {
 		// update actual task
 		if err := tx.UpdateTask(ctx, t.ID, func(u *tork.Task) error {
@@ -81,20 +82,18 @@ func (h *completedHandler) completeEachTask(ctx context.Context, t *tork.Task) e
 			u.Each.Completions = u.Each.Completions + 1
 			isLast = u.Each.Completions >= u.Each.Size
 			if !isLast && u.Each.Concurrency > 0 && u.Each.Index < u.Each.Size {
-				next, err := h.ds.GetNextTask(ctx, u.ID)
+				n, err := h.ds.GetNextTask(ctx, u.ID)
 				if err != nil {
 					return err
 				}
-				next.State = tork.TaskStatePending
-				if err := h.ds.UpdateTask(ctx, next.ID, func(nu *tork.Task) error {
+				n.State = tork.TaskStatePending
+				if err := h.ds.UpdateTask(ctx, n.ID, func(nu *tork.Task) error {
 					nu.State = tork.TaskStatePending
 					return nil
 				}); err != nil {
 					return err
 				}
-				if err := h.broker.PublishTask(ctx, mq.QUEUE_PENDING, next); err != nil {
-					return err
-				}
+				next = n
 			}
 			u.Each.Index = u.Each.Index + 1
 			return nil
@@ -118,6 +117,19 @@ func (h *completedHandler) completeEachTask(ctx context.Context, t *tork.Task) e
 	if err != nil {
 		return errors.Wrapf(err, "error complating each task: %s", t.ID)
 	}
+	// fire the next task, unless the job is paused in which
+	// case it's held in the PENDING state until it's resumed
+	if next != nil {
+		paused, err := h.isJobPaused(ctx, t.JobID)
+		if err != nil {
+			return err
+		}
+		if paused {
+			log.Debug().Str("job-id", t.JobID).Msg("job is paused. holding next task")
+		} else if err := h.broker.PublishTask(ctx, mq.QUEUE_PENDING, next); err != nil {
+			return err
+		}
+	}
 	// complete the parent task
 	if isLast {
 		parent, err := h.ds.GetTaskByID(ctx, t.ParentID)
@@ -190,6 +202,7 @@ func (h *completedHandler) completeParallelTask(ctx context.Context, t *tork.Tas
 
 func (c *completedHandler) completeTopLevelTask(ctx context.Context, t *tork.Task) error {
 	log.Debug().Str("task-id", t.ID).Msg("received task completion")
+	var paused, last bool
 	err := c.ds.WithTx(ctx, func(tx datastore.Datastore) error {
 		// update task in DB
 		if err := tx.UpdateTask(ctx, t.ID, func(u *tork.Task) error {
@@ -212,6 +225,8 @@ func (c *completedHandler) completeTopLevelTask(ctx context.Context, t *tork.Tas
 			progress = math.Round(progress*100) / 100
 			u.Progress = progress
 			u.Position = u.Position + 1
+			paused = u.State == tork.JobStatePaused
+			last = u.Position > len(u.Tasks)
 			if t.Result != "" && t.Var != "" {
 				if u.Context.Tasks == nil {
 					u.Context.Tasks = make(map[string]string)
@@ -235,6 +250,14 @@ func (c *completedHandler) completeTopLevelTask(ctx context.Context, t *tork.Tas
 	if err := c.onJob(ctx, job.Progress, j); err != nil {
 		return err
 	}
+	// hold the next task until the job is resumed
+	if paused {
+		log.Debug().Str("job-id", j.ID).Msg("job is paused. holding next task")
+		return nil
+	}
+	if hasDependencies(j.Tasks) {
+		return c.completeDAGTask(ctx, j, last)
+	}
 	now := time.Now().UTC()
 	if j.Position <= len(j.Tasks) {
 		next := j.Tasks[j.Position-1]
@@ -259,3 +282,31 @@ func (c *completedHandler) completeTopLevelTask(ctx context.Context, t *tork.Tas
 	}
 
 }
+
+func (c *completedHandler) completeDAGTask(ctx context.Context, j *tork.Job, last bool) error {
+	if last {
+		now := time.Now().UTC()
+		j.State = tork.JobStateCompleted
+		j.CompletedAt = &now
+		return c.onJob(ctx, job.StateChange, j)
+	}
+	// fire every task whose dependencies are now met
+	ready, err := createReadyTasks(ctx, c.ds, j.ID)
+	if err != nil {
+		return err
+	}
+	for _, t := range ready {
+		if err := c.broker.PublishTask(ctx, mq.QUEUE_PENDING, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *completedHandler) isJobPaused(ctx context.Context, jobID string) (bool, error) {
+	j, err := h.ds.GetJobByID(ctx, jobID)
+	if err != nil {
+		return false, errors.Wrapf(err, "error getting job: %s", jobID)
+	}
+	return j.State == tork.JobStatePaused, nil
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/runabol/tork/datastore/inmemory"
 	"github.com/runabol/tork/datastore/postgres"
 	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/middleware/job"
 	"github.com/runabol/tork/middleware/task"
 	"github.com/runabol/tork/mq"
 	"github.com/stretchr/testify/assert"
@@ -851,3 +852,214 @@ func Test_handleCompletedEachTaskWithNextTask(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, tork.TaskStateRunning, pt1.State)
 }
+
+func Test_handleCompletedTaskPausedJob(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewCompletedHandler(ds, b)
+
+	now := time.Now().UTC()
+
+	j1 := &tork.Job{
+		ID:        uuid.NewUUID(),
+		State:     tork.JobStatePaused,
+		Position:  1,
+		TaskCount: 2,
+		Tasks: []*tork.Task{
+			{
+				Name: "task-1",
+			},
+			{
+				Name: "task-2",
+			},
+		},
+	}
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	t1 := &tork.Task{
+		ID:          uuid.NewUUID(),
+		State:       tork.TaskStateRunning,
+		StartedAt:   &now,
+		CompletedAt: &now,
+		NodeID:      uuid.NewUUID(),
+		JobID:       j1.ID,
+		Position:    1,
+	}
+
+	err = ds.CreateTask(ctx, t1)
+	assert.NoError(t, err)
+
+	t1.State = tork.TaskStateCompleted
+
+	err = handler(ctx, task.StateChange, t1)
+	assert.NoError(t, err)
+
+	j2, err := ds.GetJobByID(ctx, t1.JobID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStatePaused, j2.State)
+	assert.Equal(t, 2, j2.Position)
+	// the next task should be held
+	assert.Len(t, j2.Execution, 1)
+}
+
+func Test_handleCompletedEachTaskPausedJob(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	published := make(chan *tork.Task, 1)
+	err := b.SubscribeForTasks(mq.QUEUE_PENDING, func(t *tork.Task) error {
+		published <- t
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewCompletedHandler(ds, b)
+
+	now := time.Now().UTC()
+
+	j1 := &tork.Job{
+		ID:       uuid.NewUUID(),
+		State:    tork.JobStatePaused,
+		Position: 1,
+		Tasks: []*tork.Task{
+			{
+				Name: "task-1",
+			},
+		},
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	pt := &tork.Task{
+		ID:       uuid.NewUUID(),
+		JobID:    j1.ID,
+		Position: 1,
+		Each: &tork.EachTask{
+			Size:        2,
+			Concurrency: 1,
+			Index:       1,
+			Task: &tork.Task{
+				Name: "some task",
+			},
+		},
+		State: tork.TaskStateRunning,
+	}
+	err = ds.CreateTask(ctx, pt)
+	assert.NoError(t, err)
+
+	t1 := &tork.Task{
+		ID:          uuid.NewUUID(),
+		State:       tork.TaskStateRunning,
+		CompletedAt: &now,
+		JobID:       j1.ID,
+		Position:    1,
+		ParentID:    pt.ID,
+	}
+	err = ds.CreateTask(ctx, t1)
+	assert.NoError(t, err)
+
+	t2 := &tork.Task{
+		ID:       uuid.NewUUID(),
+		State:    tork.TaskStateCreated,
+		JobID:    j1.ID,
+		Position: 1,
+		ParentID: pt.ID,
+	}
+	err = ds.CreateTask(ctx, t2)
+	assert.NoError(t, err)
+
+	t1.State = tork.TaskStateCompleted
+	err = handler(ctx, task.StateChange, t1)
+	assert.NoError(t, err)
+
+	// the next child is held in the PENDING state
+	t3, err := ds.GetTaskByID(ctx, t2.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.TaskStatePending, t3.State)
+
+	select {
+	case <-published:
+		t.Fatal("expected the next task to be held")
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func Test_handleCompletedDAGTask(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	ds := inmemory.NewInMemoryDatastore()
+	jobHandler := NewJobHandler(ds, b)
+	handler := NewCompletedHandler(ds, b)
+
+	j1 := &tork.Job{
+		ID:        uuid.NewUUID(),
+		State:     tork.JobStatePending,
+		TaskCount: 3,
+		Tasks: []*tork.Task{
+			{
+				Name: "task-a",
+				Var:  "a",
+			},
+			{
+				Name: "task-b",
+				Var:  "b",
+			},
+			{
+				Name:      "task-c",
+				Var:       "c",
+				DependsOn: []string{"a", "b"},
+			},
+		},
+	}
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	err = jobHandler(ctx, job.StateChange, j1)
+	assert.NoError(t, err)
+
+	// a and b have no dependencies
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Len(t, j2.Execution, 2)
+
+	complete := func(tk *tork.Task) {
+		now := time.Now().UTC()
+		err := ds.UpdateTask(ctx, tk.ID, func(u *tork.Task) error {
+			u.State = tork.TaskStateRunning
+			return nil
+		})
+		assert.NoError(t, err)
+		tk.State = tork.TaskStateCompleted
+		tk.CompletedAt = &now
+		err = handler(ctx, task.StateChange, tk)
+		assert.NoError(t, err)
+	}
+
+	complete(j2.Execution[0])
+
+	// c still waits on b
+	j3, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Len(t, j3.Execution, 2)
+
+	complete(j2.Execution[1])
+
+	j3, err = ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Len(t, j3.Execution, 3)
+	assert.Equal(t, "task-c", j3.Execution[2].Name)
+	assert.Equal(t, 3, j3.Execution[2].Position)
+	assert.Equal(t, tork.TaskStatePending, j3.Execution[2].State)
+
+	complete(j3.Execution[2])
+
+	j3, err = ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateCompleted, j3.State)
+	assert.Equal(t, float64(100), j3.Progress)
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/internal/eval"
+	"github.com/runabol/tork/mq"
+)
+
+// concurrencyMu serializes concurrency checks within a single
+// coordinator. Datastores which support it additionally lock
+// the concurrency key for the duration of the transaction.
+var concurrencyMu sync.Mutex
+
+// acquireConcurrencySlot checks the job against the other jobs
+// sharing its concurrency key and, if the job is allowed to
+// run, marks it as SCHEDULED. It returns false when the job
+// must not be started: either it is held in the PENDING state
+// until a slot frees up or it was rejected.
+func acquireConcurrencySlot(ctx context.Context, ds datastore.Datastore, b mq.Broker, j *tork.Job) (bool, error) {
+	key, err := eval.EvaluateTemplate(j.Concurrency.Key, j.Context.AsMap())
+	if err != nil {
+		return false, rejectJob(ctx, b, j, fmt.Sprintf("error evaluating concurrency key: %s", err.Error()))
+	}
+	limit := j.Concurrency.Limit
+	if limit <= 0 {
+		limit = 1
+	}
+	concurrencyMu.Lock()
+	defer concurrencyMu.Unlock()
+	var start, reject bool
+	previous := make([]*tork.JobSummary, 0)
+	if err := ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		pending := true
+		if err := tx.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
+			if u.State != tork.JobStatePending {
+				pending = false
+				return nil
+			}
+			u.Concurrency.Key = key
+			return nil
+		}); err != nil {
+			return err
+		}
+		if !pending {
+			// the job was already started or cancelled
+			return nil
+		}
+		if err := tx.LockConcurrencyKey(ctx, key); err != nil {
+			return err
+		}
+		jobs, err := tx.GetJobsByConcurrencyKey(ctx, key)
+		if err != nil {
+			return err
+		}
+		var running, ahead int
+		for _, cj := range jobs {
+			if cj.ID == j.ID {
+				continue
+			}
+			if cj.State != tork.JobStatePending {
+				running = running + 1
+				previous = append(previous, cj)
+			} else if cj.CreatedAt.Before(j.CreatedAt) {
+				// held jobs are released in the order
+				// in which they were submitted
+				ahead = ahead + 1
+				previous = append(previous, cj)
+			}
+		}
+		switch j.Concurrency.OnConflict {
+		case tork.ConcurrencyCancelPrevious:
+			start = true
+		case tork.ConcurrencyReject:
+			reject = running >= limit
+			start = !reject
+		default:
+			start = running+ahead < limit
+		}
+		if !start {
+			return nil
+		}
+		return tx.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
+			n := time.Now().UTC()
+			u.State = tork.JobStateScheduled
+			u.StartedAt = &n
+			u.Rerun = nil
+			return nil
+		})
+	}); err != nil {
+		return false, errors.Wrapf(err, "error acquiring concurrency slot for job %s", j.ID)
+	}
+	if reject {
+		return false, rejectJob(ctx, b, j, fmt.Sprintf("concurrency limit reached for key: %s", key))
+	}
+	if !start {
+		log.Debug().Msgf("job %s is held by concurrency key %s", j.ID, key)
+		return false, nil
+	}
+	if j.Concurrency.OnConflict == tork.ConcurrencyCancelPrevious {
+		for _, pj := range previous {
+			log.Debug().Msgf("cancelling job %s in favor of job %s", pj.ID, j.ID)
+			cj, err := ds.GetJobByID(ctx, pj.ID)
+			if err != nil {
+				return false, errors.Wrapf(err, "error getting job %s", pj.ID)
+			}
+			cj.State = tork.JobStateCancelled
+			if err := b.PublishJob(ctx, cj); err != nil {
+				return false, errors.Wrapf(err, "error cancelling job %s", pj.ID)
+			}
+		}
+	}
+	return true, nil
+}
+
+// releaseConcurrencySlot re-submits the jobs which are held
+// by the concurrency key of the given job, now that it is
+// no longer running.
+func releaseConcurrencySlot(ctx context.Context, ds datastore.Datastore, b mq.Broker, jobID string) error {
+	j, err := ds.GetJobByID(ctx, jobID)
+	if err != nil {
+		return errors.Wrapf(err, "error getting job %s", jobID)
+	}
+	if j.Concurrency == nil {
+		return nil
+	}
+	jobs, err := ds.GetJobsByConcurrencyKey(ctx, j.Concurrency.Key)
+	if err != nil {
+		return err
+	}
+	for _, hj := range jobs {
+		if hj.State != tork.JobStatePending {
+			continue
+		}
+		held, err := ds.GetJobByID(ctx, hj.ID)
+		if err != nil {
+			return errors.Wrapf(err, "error getting job %s", hj.ID)
+		}
+		if held.Rerun != nil {
+			// resume the held re-run rather than
+			// starting the job from scratch
+			held.State = tork.JobStateRerun
+		}
+		if err := b.PublishJob(ctx, held); err != nil {
+			return errors.Wrapf(err, "error releasing job %s", hj.ID)
+		}
+	}
+	return nil
+}
+
+// rejectJob fails the job by publishing it in the FAILED state
+// so it goes through the job handler and its middleware like
+// any other job failure.
+func rejectJob(ctx context.Context, b mq.Broker, j *tork.Job, reason string) error {
+	now := time.Now().UTC()
+	j.State = tork.JobStateFailed
+	j.FailedAt = &now
+	j.Error = reason
+	return b.PublishJob(ctx, j)
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore/inmemory"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/middleware/job"
+	"github.com/runabol/tork/mq"
+	"github.com/stretchr/testify/assert"
+)
+
+func newConcurrentJob(state tork.JobState, createdAt time.Time, c *tork.JobConcurrency) *tork.Job {
+	return &tork.Job{
+		ID:          uuid.NewUUID(),
+		State:       state,
+		CreatedAt:   createdAt,
+		Concurrency: c,
+		Context: tork.JobContext{
+			Inputs: map[string]string{"env": "prod"},
+		},
+		Tasks: []*tork.Task{
+			{
+				Name: "task-1",
+			},
+		},
+	}
+}
+
+func Test_handleJobConcurrencyQueue(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewJobHandler(ds, b)
+
+	now := time.Now().UTC()
+
+	j1 := newConcurrentJob(tork.JobStateRunning, now.Add(-time.Minute), &tork.JobConcurrency{
+		Key:   "deploy-prod",
+		Limit: 1,
+	})
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	j2 := newConcurrentJob(tork.JobStatePending, now, &tork.JobConcurrency{
+		Key:        "deploy-{{ inputs.env }}",
+		Limit:      1,
+		OnConflict: tork.ConcurrencyQueue,
+	})
+	err = ds.CreateJob(ctx, j2)
+	assert.NoError(t, err)
+
+	err = handler(ctx, job.StateChange, j2)
+	assert.NoError(t, err)
+
+	// held until j1 is done
+	j22, err := ds.GetJobByID(ctx, j2.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStatePending, j22.State)
+	assert.Equal(t, "deploy-prod", j22.Concurrency.Key)
+
+	released := make(chan *tork.Job, 1)
+	err = b.SubscribeForJobs(func(j *tork.Job) error {
+		released <- j
+		return nil
+	})
+	assert.NoError(t, err)
+
+	j1.State = tork.JobStateCompleted
+	err = handler(ctx, job.StateChange, j1)
+	assert.NoError(t, err)
+
+	select {
+	case j := <-released:
+		assert.Equal(t, j2.ID, j.ID)
+		assert.Equal(t, tork.JobStatePending, j.State)
+		err = handler(ctx, job.StateChange, j)
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("expected the held job to be released")
+	}
+
+	j22, err = ds.GetJobByID(ctx, j2.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateScheduled, j22.State)
+}
+
+func Test_handleJobConcurrencyLimit(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewJobHandler(ds, b)
+
+	now := time.Now().UTC()
+
+	j1 := newConcurrentJob(tork.JobStateRunning, now.Add(-time.Minute), &tork.JobConcurrency{
+		Key:   "deploy-prod",
+		Limit: 2,
+	})
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	j2 := newConcurrentJob(tork.JobStatePending, now, &tork.JobConcurrency{
+		Key:   "deploy-prod",
+		Limit: 2,
+	})
+	err = ds.CreateJob(ctx, j2)
+	assert.NoError(t, err)
+
+	err = handler(ctx, job.StateChange, j2)
+	assert.NoError(t, err)
+
+	j22, err := ds.GetJobByID(ctx, j2.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateScheduled, j22.State)
+
+	j3 := newConcurrentJob(tork.JobStatePending, now.Add(time.Second), &tork.JobConcurrency{
+		Key:   "deploy-prod",
+		Limit: 2,
+	})
+	err = ds.CreateJob(ctx, j3)
+	assert.NoError(t, err)
+
+	err = handler(ctx, job.StateChange, j3)
+	assert.NoError(t, err)
+
+	j33, err := ds.GetJobByID(ctx, j3.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStatePending, j33.State)
+}
+
+func Test_handleJobConcurrencyReject(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewJobHandler(ds, b)
+
+	now := time.Now().UTC()
+
+	j1 := newConcurrentJob(tork.JobStateRunning, now.Add(-time.Minute), &tork.JobConcurrency{
+		Key: "deploy-prod",
+	})
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	// the rejection goes through the job handler
+	rejected := make(chan error, 1)
+	err = b.SubscribeForJobs(func(j *tork.Job) error {
+		assert.Equal(t, tork.JobStateFailed, j.State)
+		rejected <- handler(ctx, job.StateChange, j)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	j2 := newConcurrentJob(tork.JobStatePending, now, &tork.JobConcurrency{
+		Key:        "deploy-prod",
+		OnConflict: tork.ConcurrencyReject,
+	})
+	err = ds.CreateJob(ctx, j2)
+	assert.NoError(t, err)
+
+	err = handler(ctx, job.StateChange, j2)
+	assert.NoError(t, err)
+	assert.NoError(t, <-rejected)
+
+	j22, err := ds.GetJobByID(ctx, j2.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateFailed, j22.State)
+	assert.NotNil(t, j22.FailedAt)
+	assert.Contains(t, j22.Error, "concurrency limit reached")
+
+	tasks, err := ds.GetActiveTasks(ctx, j2.ID)
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 0)
+}
+
+func Test_handleJobConcurrencyCancelPrevious(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewJobHandler(ds, b)
+
+	now := time.Now().UTC()
+
+	j1 := newConcurrentJob(tork.JobStateRunning, now.Add(-time.Minute), &tork.JobConcurrency{
+		Key: "deploy-prod",
+	})
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	cancelled := make(chan *tork.Job, 1)
+	err = b.SubscribeForJobs(func(j *tork.Job) error {
+		cancelled <- j
+		return nil
+	})
+	assert.NoError(t, err)
+
+	j2 := newConcurrentJob(tork.JobStatePending, now, &tork.JobConcurrency{
+		Key:        "deploy-prod",
+		OnConflict: tork.ConcurrencyCancelPrevious,
+	})
+	err = ds.CreateJob(ctx, j2)
+	assert.NoError(t, err)
+
+	err = handler(ctx, job.StateChange, j2)
+	assert.NoError(t, err)
+
+	j22, err := ds.GetJobByID(ctx, j2.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateScheduled, j22.State)
+
+	select {
+	case j := <-cancelled:
+		assert.Equal(t, j1.ID, j.ID)
+		assert.Equal(t, tork.JobStateCancelled, j.State)
+	case <-time.After(time.Second):
+		t.Fatal("expected the previous job to be cancelled")
+	}
+}
+
+func Test_handleCancelHeldJob(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewJobHandler(ds, b)
+
+	j1 := newConcurrentJob(tork.JobStatePending, time.Now().UTC(), &tork.JobConcurrency{
+		Key: "deploy-prod",
+	})
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	j1.State = tork.JobStateCancelled
+	err = handler(ctx, job.StateChange, j1)
+	assert.NoError(t, err)
+
+	j11, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateCancelled, j11.State)
+}
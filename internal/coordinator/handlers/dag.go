@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/internal/eval"
+	"github.com/runabol/tork/internal/uuid"
+)
+
+// hasDependencies returns true if any of the top-level
+// tasks declares a dependency on another task, in which
+// case the tasks are executed as a DAG rather than in
+// sequential order.
+func hasDependencies(tasks []*tork.Task) bool {
+	for _, t := range tasks {
+		if len(t.DependsOn) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// createReadyTasks creates every top-level task of the job
+// whose dependencies have all completed. The job is locked
+// for the duration of the transaction so concurrently
+// completing tasks can't create the same task twice.
+func createReadyTasks(ctx context.Context, ds datastore.Datastore, jobID string) ([]*tork.Task, error) {
+	var ready []*tork.Task
+	err := ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		var j *tork.Job
+		if err := tx.UpdateJob(ctx, jobID, func(u *tork.Job) error {
+			j = u
+			return nil
+		}); err != nil {
+			return err
+		}
+		if j.State != tork.JobStateRunning && j.State != tork.JobStateScheduled {
+			// e.g. paused, cancelled or failed
+			return nil
+		}
+		ts, err := tx.GetReadyTasks(ctx, jobID)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		for _, t := range ts {
+			t.ID = uuid.NewUUID()
+			t.JobID = jobID
+			t.State = tork.TaskStatePending
+			t.CreatedAt = &now
+			if err := eval.EvaluateTask(t, j.Context.AsMap()); err != nil {
+				t.Error = err.Error()
+				t.State = tork.TaskStateFailed
+				t.FailedAt = &now
+			}
+			if err := tx.CreateTask(ctx, t); err != nil {
+				return err
+			}
+		}
+		ready = ts
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating ready tasks for job %s", jobID)
+	}
+	return ready, nil
+}
+
+// createRestartTasks re-creates the top-level tasks of a DAG
+// job which have failed or were cancelled and have not been
+// retried since.
+func createRestartTasks(ctx context.Context, ds datastore.Datastore, j *tork.Job) ([]*tork.Task, error) {
+	retry := make(map[int]bool)
+	for _, t := range j.Execution {
+		if t.ParentID != "" {
+			continue
+		}
+		if t.State == tork.TaskStateFailed || t.State == tork.TaskStateCancelled {
+			if _, ok := retry[t.Position]; !ok {
+				retry[t.Position] = true
+			}
+		} else {
+			retry[t.Position] = false
+		}
+	}
+	now := time.Now().UTC()
+	result := make([]*tork.Task, 0)
+	for i, def := range j.Tasks {
+		if !retry[i+1] {
+			continue
+		}
+		t := def.Clone()
+		t.ID = uuid.NewUUID()
+		t.JobID = j.ID
+		t.State = tork.TaskStatePending
+		t.Position = i + 1
+		t.CreatedAt = &now
+		if err := eval.EvaluateTask(t, j.Context.AsMap()); err != nil {
+			t.Error = err.Error()
+			t.State = tork.TaskStateFailed
+			t.FailedAt = &now
+		}
+		if err := ds.CreateTask(ctx, t); err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	return result, nil
+}
@@ -2,6 +2,10 @@ package handlers
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -58,7 +62,8 @@ func (h *errorHandler) handle(ctx context.Context, et task.EventType, t *tork.Ta
 	// eligible for retry?
 	if (j.State == tork.JobStateRunning || j.State == tork.JobStateScheduled) &&
 		t.Retry != nil &&
-		t.Retry.Attempts < t.Retry.Limit {
+		t.Retry.Attempts < t.Retry.Limit &&
+		isRetryable(t) {
 		// create a new retry task
 		now := time.Now().UTC()
 		rt := t.Clone()
@@ -71,6 +76,21 @@ func (h *errorHandler) handle(ctx context.Context, et task.EventType, t *tork.Ta
 		if err := eval.EvaluateTask(rt, j.Context.AsMap()); err != nil {
 			return errors.Wrapf(err, "error evaluating task")
 		}
+		if delay := retryDelay(rt.Retry); delay > 0 {
+			// the retry task is picked up by the
+			// retry scheduler once it is due
+			notBefore := now.Add(delay)
+			rt.State = tork.TaskStateCreated
+			rt.NotBefore = &notBefore
+			if err := h.ds.CreateTask(ctx, rt); err != nil {
+				return errors.Wrapf(err, "error creating a retry task")
+			}
+			log.Debug().
+				Str("task-id", rt.ID).
+				Dur("delay", delay).
+				Msg("delaying task retry")
+			return nil
+		}
 		if err := h.ds.CreateTask(ctx, rt); err != nil {
 			return errors.Wrapf(err, "error creating a retry task")
 		}
@@ -84,3 +104,60 @@ func (h *errorHandler) handle(ctx context.Context, et task.EventType, t *tork.Ta
 	}
 	return nil
 }
+
+// isRetryable returns true if the task's error matches any of
+// the retry policy's retryOn conditions. A numeric condition
+// matches the exit code the task failed with, any other
+// condition matches if it's a substring of the task's error.
+// Tasks without any conditions are always retryable.
+func isRetryable(t *tork.Task) bool {
+	if len(t.Retry.RetryOn) == 0 {
+		return true
+	}
+	for _, cond := range t.Retry.RetryOn {
+		cond = strings.TrimSpace(cond)
+		if cond == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(cond); err == nil {
+			exitCode := fmt.Sprintf("exit code %s", cond)
+			if t.Error == exitCode || strings.HasPrefix(t.Error, exitCode+":") {
+				return true
+			}
+		} else if strings.Contains(t.Error, cond) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryDelay calculates how long to wait before the given
+// retry attempt: initialDelay * scalingFactor^(attempt-1),
+// capped at maxDelay.
+func retryDelay(r *tork.TaskRetry) time.Duration {
+	if r.InitialDelay == "" {
+		return 0
+	}
+	initial, err := time.ParseDuration(r.InitialDelay)
+	if err != nil {
+		log.Error().Err(err).Msgf("invalid retry initial delay: %s", r.InitialDelay)
+		return 0
+	}
+	factor := r.ScalingFactor
+	if factor == 0 {
+		factor = 2
+	}
+	delay := float64(initial) * math.Pow(factor, float64(r.Attempts-1))
+	if r.MaxDelay != "" {
+		max, err := time.ParseDuration(r.MaxDelay)
+		if err != nil {
+			log.Error().Err(err).Msgf("invalid retry max delay: %s", r.MaxDelay)
+		} else if delay > float64(max) {
+			delay = float64(max)
+		}
+	}
+	if delay > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(delay)
+}
@@ -172,3 +172,147 @@ func Test_handleFailedTaskRetry(t *testing.T) {
 	assert.Equal(t, j1.ID, j2.ID)
 	assert.Equal(t, tork.JobStateRunning, j2.State)
 }
+
+func Test_handleFailedTaskDelayedRetry(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	ds := inmemory.NewInMemoryDatastore()
+
+	handler := NewErrorHandler(ds, b)
+	assert.NotNil(t, handler)
+
+	now := time.Now().UTC()
+
+	j1 := &tork.Job{
+		ID:       uuid.NewUUID(),
+		State:    tork.JobStateRunning,
+		Position: 1,
+		Tasks: []*tork.Task{
+			{
+				Name: "task-1",
+			},
+		},
+	}
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	t1 := &tork.Task{
+		ID:        uuid.NewUUID(),
+		State:     tork.TaskStateRunning,
+		StartedAt: &now,
+		NodeID:    uuid.NewUUID(),
+		JobID:     j1.ID,
+		Position:  1,
+		Error:     "exit code 1",
+		Retry: &tork.TaskRetry{
+			Limit:        2,
+			InitialDelay: "10s",
+			RetryOn:      []string{"1"},
+		},
+	}
+
+	err = ds.CreateTask(ctx, t1)
+	assert.NoError(t, err)
+
+	err = handler(ctx, task.StateChange, t1)
+	assert.NoError(t, err)
+
+	delayed, err := ds.GetDelayedTasks(ctx, now.Add(time.Second*11))
+	assert.NoError(t, err)
+	assert.Len(t, delayed, 1)
+	assert.Equal(t, tork.TaskStateCreated, delayed[0].State)
+	assert.Equal(t, 1, delayed[0].Retry.Attempts)
+	assert.True(t, delayed[0].NotBefore.After(now.Add(time.Second*9)))
+
+	// not due yet
+	delayed, err = ds.GetDelayedTasks(ctx, now)
+	assert.NoError(t, err)
+	assert.Len(t, delayed, 0)
+
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateRunning, j2.State)
+}
+
+func Test_handleFailedTaskNotRetryable(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	ds := inmemory.NewInMemoryDatastore()
+
+	handler := NewErrorHandler(ds, b)
+	assert.NotNil(t, handler)
+
+	now := time.Now().UTC()
+
+	j1 := &tork.Job{
+		ID:       uuid.NewUUID(),
+		State:    tork.JobStateRunning,
+		Position: 1,
+		Tasks: []*tork.Task{
+			{
+				Name: "task-1",
+			},
+		},
+	}
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	t1 := &tork.Task{
+		ID:        uuid.NewUUID(),
+		State:     tork.TaskStateRunning,
+		StartedAt: &now,
+		NodeID:    uuid.NewUUID(),
+		JobID:     j1.ID,
+		Position:  1,
+		Error:     "exit code 2: bad input",
+		Retry: &tork.TaskRetry{
+			Limit:   2,
+			RetryOn: []string{"1", "timeout"},
+		},
+	}
+
+	err = ds.CreateTask(ctx, t1)
+	assert.NoError(t, err)
+
+	err = handler(ctx, task.StateChange, t1)
+	assert.NoError(t, err)
+
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateFailed, j2.State)
+}
+
+func Test_retryDelay(t *testing.T) {
+	r := &tork.TaskRetry{
+		InitialDelay: "1s",
+		MaxDelay:     "5s",
+		Attempts:     1,
+	}
+	assert.Equal(t, time.Second, retryDelay(r))
+	r.Attempts = 2
+	assert.Equal(t, time.Second*2, retryDelay(r))
+	r.Attempts = 3
+	assert.Equal(t, time.Second*4, retryDelay(r))
+	r.Attempts = 4
+	assert.Equal(t, time.Second*5, retryDelay(r))
+	r.ScalingFactor = 1.5
+	r.Attempts = 3
+	assert.Equal(t, time.Millisecond*2250, retryDelay(r))
+	assert.Equal(t, time.Duration(0), retryDelay(&tork.TaskRetry{Attempts: 1}))
+}
+
+func Test_isRetryable(t *testing.T) {
+	tk := &tork.Task{
+		Error: "exit code 137: killed",
+		Retry: &tork.TaskRetry{},
+	}
+	assert.True(t, isRetryable(tk))
+	tk.Retry.RetryOn = []string{"137"}
+	assert.True(t, isRetryable(tk))
+	tk.Retry.RetryOn = []string{"13"}
+	assert.False(t, isRetryable(tk))
+	tk.Retry.RetryOn = []string{"killed"}
+	assert.True(t, isRetryable(tk))
+	tk.Retry.RetryOn = []string{"connection refused"}
+	assert.False(t, isRetryable(tk))
+}
@@ -38,6 +38,12 @@ func (h *heartbeatHandler) handle(ctx context.Context, n *tork.Node) error {
 		u.CPUPercent = n.CPUPercent
 		u.Status = n.Status
 		u.TaskCount = n.TaskCount
+		u.CPUs = n.CPUs
+		u.Memory = n.Memory
+		u.AllocatedCPUs = n.AllocatedCPUs
+		u.AllocatedMemory = n.AllocatedMemory
+		u.Labels = n.Labels
+		u.Queues = n.Queues
 		return nil
 	})
 }
@@ -42,6 +42,12 @@ func Test_handleHeartbeat(t *testing.T) {
 		CPUPercent:      75,
 		Status:          tork.NodeStatusDown,
 		TaskCount:       3,
+		CPUs:            8,
+		Memory:          16 * 1024 * 1024 * 1024,
+		AllocatedCPUs:   2.5,
+		AllocatedMemory: 1024 * 1024 * 1024,
+		Labels:          map[string]string{"zone": "a"},
+		Queues:          []string{"default"},
 	}
 
 	err = handler(ctx, &n2)
@@ -52,6 +58,12 @@ func Test_handleHeartbeat(t *testing.T) {
 	assert.Equal(t, n2.LastHeartbeatAt, n22.LastHeartbeatAt)
 	assert.Equal(t, n2.CPUPercent, n22.CPUPercent)
 	assert.Equal(t, n2.Status, n22.Status)
+	assert.Equal(t, n2.CPUs, n22.CPUs)
+	assert.Equal(t, n2.Memory, n22.Memory)
+	assert.Equal(t, n2.AllocatedCPUs, n22.AllocatedCPUs)
+	assert.Equal(t, n2.AllocatedMemory, n22.AllocatedMemory)
+	assert.Equal(t, n2.Labels, n22.Labels)
+	assert.Equal(t, n2.Queues, n22.Queues)
 	assert.Equal(t, n2.TaskCount, n22.TaskCount)
 
 	n3 := tork.Node{
@@ -43,6 +43,12 @@ func (h *jobHandler) handle(ctx context.Context, et job.EventType, j *tork.Job)
 		return h.onCancel(ctx, et, j)
 	case tork.JobStateRestart:
 		return h.restartJob(ctx, j)
+	case tork.JobStateRerun:
+		return h.rerunJob(ctx, j)
+	case tork.JobStatePaused:
+		return h.pauseJob(ctx, j)
+	case tork.JobStateResume:
+		return h.resumeJob(ctx, j)
 	case tork.JobStateCompleted:
 		return h.completeJob(ctx, j)
 	case tork.JobStateFailed:
@@ -56,6 +62,15 @@ func (h *jobHandler) handle(ctx context.Context, et job.EventType, j *tork.Job)
 
 func (h *jobHandler) startJob(ctx context.Context, j *tork.Job) error {
 	log.Debug().Msgf("starting job %s", j.ID)
+	if j.Concurrency != nil {
+		ok, err := acquireConcurrencySlot(ctx, h.ds, h.broker, j)
+		if err != nil || !ok {
+			return err
+		}
+	}
+	if hasDependencies(j.Tasks) {
+		return h.startDAGJob(ctx, j)
+	}
 	now := time.Now().UTC()
 	t := j.Tasks[0]
 	t.ID = uuid.NewUUID()
@@ -89,6 +104,36 @@ func (h *jobHandler) startJob(ctx context.Context, j *tork.Job) error {
 	return h.onPending(ctx, task.StateChange, t)
 }
 
+func (h *jobHandler) startDAGJob(ctx context.Context, j *tork.Job) error {
+	if err := h.ds.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
+		n := time.Now().UTC()
+		u.State = tork.JobStateScheduled
+		u.StartedAt = &n
+		u.Position = 1
+		return nil
+	}); err != nil {
+		return err
+	}
+	// start every task which has no dependencies
+	ready, err := createReadyTasks(ctx, h.ds, j.ID)
+	if err != nil {
+		return err
+	}
+	for _, t := range ready {
+		if t.State == tork.TaskStateFailed {
+			j.FailedAt = t.FailedAt
+			j.State = tork.JobStateFailed
+			return h.handle(ctx, job.StateChange, j)
+		}
+	}
+	for _, t := range ready {
+		if err := h.onPending(ctx, task.StateChange, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (h *jobHandler) completeJob(ctx context.Context, j *tork.Job) error {
 	// mark the job as completed
 	if err := h.ds.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
@@ -126,6 +171,11 @@ func (h *jobHandler) completeJob(ctx context.Context, j *tork.Job) error {
 	}); err != nil {
 		return errors.Wrapf(err, "error updating job in datastore")
 	}
+	if j.Concurrency != nil {
+		if err := releaseConcurrencySlot(ctx, h.ds, h.broker, j.ID); err != nil {
+			return err
+		}
+	}
 	// if this is a sub-job -- complete/fail the parent task
 	if j.ParentID != "" {
 		parent, err := h.ds.GetTaskByID(ctx, j.ParentID)
@@ -175,6 +225,9 @@ func (h *jobHandler) restartJob(ctx context.Context, j *tork.Job) error {
 	}); err != nil {
 		return err
 	}
+	if hasDependencies(j.Tasks) {
+		return h.restartDAGJob(ctx, j)
+	}
 	// retry the current top level task
 	now := time.Now().UTC()
 	t := j.Tasks[j.Position-1]
@@ -194,20 +247,164 @@ func (h *jobHandler) restartJob(ctx context.Context, j *tork.Job) error {
 	return h.broker.PublishTask(ctx, mq.QUEUE_PENDING, t)
 }
 
+func (h *jobHandler) restartDAGJob(ctx context.Context, j *tork.Job) error {
+	j, err := h.ds.GetJobByID(ctx, j.ID)
+	if err != nil {
+		return errors.Wrapf(err, "error getting job from datatstore")
+	}
+	// retry the failed/cancelled tasks
+	ts, err := createRestartTasks(ctx, h.ds, j)
+	if err != nil {
+		return err
+	}
+	// along with any task that became ready in the meantime
+	ready, err := createReadyTasks(ctx, h.ds, j.ID)
+	if err != nil {
+		return err
+	}
+	for _, t := range append(ts, ready...) {
+		if err := h.broker.PublishTask(ctx, mq.QUEUE_PENDING, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *jobHandler) pauseJob(ctx context.Context, j *tork.Job) error {
+	// tasks which are already in-flight are allowed to
+	// finish, but the next task is held until the job
+	// is resumed
+	return h.ds.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
+		if u.State != tork.JobStateRunning && u.State != tork.JobStateScheduled {
+			return errors.Errorf("job %s is in %s state and can't be paused", j.ID, u.State)
+		}
+		now := time.Now().UTC()
+		u.State = tork.JobStatePaused
+		u.PausedAt = &now
+		return nil
+	})
+}
+
+func (h *jobHandler) resumeJob(ctx context.Context, j *tork.Job) error {
+	var held bool
+	var pending []*tork.Task
+	if err := h.ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		return tx.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
+			if u.State != tork.JobStatePaused {
+				return errors.Errorf("job %s is in %s state and can't be resumed", j.ID, u.State)
+			}
+			u.State = tork.JobStateRunning
+			u.PausedAt = nil
+			// if nothing is in-flight then the completion
+			// handler held the job's next task while paused
+			active, err := tx.GetActiveTasks(ctx, j.ID)
+			if err != nil {
+				return err
+			}
+			held = len(active) == 0
+			// pending tasks (e.g. the children of an each or
+			// parallel task) were held while paused
+			for _, t := range active {
+				if t.State == tork.TaskStatePending {
+					pending = append(pending, t)
+				}
+			}
+			return nil
+		})
+	}); err != nil {
+		return err
+	}
+	for _, t := range pending {
+		if err := h.broker.PublishTask(ctx, mq.QUEUE_PENDING, t); err != nil {
+			return err
+		}
+	}
+	if hasDependencies(j.Tasks) {
+		return h.resumeDAGJob(ctx, j, held)
+	}
+	if !held {
+		return nil
+	}
+	j, err := h.ds.GetJobByID(ctx, j.ID)
+	if err != nil {
+		return errors.Wrapf(err, "error getting job from datatstore")
+	}
+	now := time.Now().UTC()
+	if j.Position > len(j.Tasks) {
+		j.State = tork.JobStateCompleted
+		j.CompletedAt = &now
+		return h.handle(ctx, job.StateChange, j)
+	}
+	t := j.Tasks[j.Position-1]
+	t.ID = uuid.NewUUID()
+	t.JobID = j.ID
+	t.State = tork.TaskStatePending
+	t.Position = j.Position
+	t.CreatedAt = &now
+	if err := eval.EvaluateTask(t, j.Context.AsMap()); err != nil {
+		t.Error = err.Error()
+		t.State = tork.TaskStateFailed
+		t.FailedAt = &now
+	}
+	if err := h.ds.CreateTask(ctx, t); err != nil {
+		return err
+	}
+	return h.broker.PublishTask(ctx, mq.QUEUE_PENDING, t)
+}
+
+func (h *jobHandler) resumeDAGJob(ctx context.Context, j *tork.Job, held bool) error {
+	// release the tasks which became ready while paused
+	ready, err := createReadyTasks(ctx, h.ds, j.ID)
+	if err != nil {
+		return err
+	}
+	for _, t := range ready {
+		if err := h.broker.PublishTask(ctx, mq.QUEUE_PENDING, t); err != nil {
+			return err
+		}
+	}
+	if len(ready) > 0 || !held {
+		return nil
+	}
+	j, err = h.ds.GetJobByID(ctx, j.ID)
+	if err != nil {
+		return errors.Wrapf(err, "error getting job from datatstore")
+	}
+	if j.Position > len(j.Tasks) {
+		now := time.Now().UTC()
+		j.State = tork.JobStateCompleted
+		j.CompletedAt = &now
+		return h.handle(ctx, job.StateChange, j)
+	}
+	return nil
+}
+
 func (h *jobHandler) failJob(ctx context.Context, j *tork.Job) error {
 	// mark the job as FAILED
 	if err := h.ds.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
 		// we only want to make the job as FAILED
-		// if it's actually running as opposed to
-		// possibly being CANCELLED
-		if u.State == tork.JobStateRunning || u.State == tork.JobStateScheduled {
+		// if it's actually running (or held by its
+		// concurrency key) as opposed to possibly
+		// being CANCELLED
+		if u.State == tork.JobStateRunning ||
+			u.State == tork.JobStateScheduled ||
+			u.State == tork.JobStatePaused ||
+			u.State == tork.JobStatePending {
 			u.State = tork.JobStateFailed
 			u.FailedAt = j.FailedAt
+			if j.Error != "" {
+				u.Error = j.Error
+			}
 		}
 		return nil
 	}); err != nil {
 		return errors.Wrapf(err, "error marking the job as failed in the datastore")
 	}
+	if j.Concurrency != nil {
+		if err := releaseConcurrencySlot(ctx, h.ds, h.broker, j.ID); err != nil {
+			return err
+		}
+	}
 	// if this is a sub-job -- FAIL the parent task
 	if j.ParentID != "" {
 		parent, err := h.ds.GetTaskByID(ctx, j.ParentID)
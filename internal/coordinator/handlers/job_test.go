@@ -383,3 +383,170 @@ func Test_handleJobProgress(t *testing.T) {
 	assert.Empty(t, j1.Result)
 	assert.Nil(t, j1.DeleteAt)
 }
+
+func Test_handlePauseAndResumeJob(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewJobHandler(ds, b)
+	assert.NotNil(t, handler)
+
+	now := time.Now().UTC()
+
+	j1 := &tork.Job{
+		ID:        uuid.NewUUID(),
+		State:     tork.JobStateRunning,
+		CreatedAt: now,
+		Position:  2,
+		TaskCount: 2,
+		Tasks: []*tork.Task{
+			{
+				Name: "task-1",
+			},
+			{
+				Name: "task-2",
+			},
+		},
+	}
+
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	j1.State = tork.JobStatePaused
+	err = handler(ctx, job.StateChange, j1)
+	assert.NoError(t, err)
+
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStatePaused, j2.State)
+	assert.NotNil(t, j2.PausedAt)
+
+	// can't pause twice
+	err = handler(ctx, job.StateChange, j1)
+	assert.Error(t, err)
+
+	j1.State = tork.JobStateResume
+	err = handler(ctx, job.StateChange, j1)
+	assert.NoError(t, err)
+
+	j2, err = ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateRunning, j2.State)
+	assert.Nil(t, j2.PausedAt)
+
+	// the held task should have been created
+	// at the job's current position
+	assert.Len(t, j2.Execution, 1)
+	assert.Equal(t, "task-2", j2.Execution[0].Name)
+	assert.Equal(t, 2, j2.Execution[0].Position)
+
+	// can't resume a running job
+	err = handler(ctx, job.StateChange, j1)
+	assert.Error(t, err)
+}
+
+func Test_handleResumeJobWithActiveTask(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewJobHandler(ds, b)
+	assert.NotNil(t, handler)
+
+	now := time.Now().UTC()
+
+	j1 := &tork.Job{
+		ID:        uuid.NewUUID(),
+		State:     tork.JobStatePaused,
+		CreatedAt: now,
+		Position:  1,
+		Tasks: []*tork.Task{
+			{
+				Name: "task-1",
+			},
+		},
+	}
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	err = ds.CreateTask(ctx, &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateRunning,
+		Position:  1,
+		CreatedAt: &now,
+	})
+	assert.NoError(t, err)
+
+	j1.State = tork.JobStateResume
+	err = handler(ctx, job.StateChange, j1)
+	assert.NoError(t, err)
+
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateRunning, j2.State)
+	// the in-flight task will drive the job forward
+	assert.Len(t, j2.Execution, 1)
+}
+
+func Test_handleResumeJobWithHeldTask(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	released := make(chan *tork.Task, 1)
+	err := b.SubscribeForTasks(mq.QUEUE_PENDING, func(t *tork.Task) error {
+		released <- t
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewJobHandler(ds, b)
+	assert.NotNil(t, handler)
+
+	now := time.Now().UTC()
+
+	j1 := &tork.Job{
+		ID:        uuid.NewUUID(),
+		State:     tork.JobStatePaused,
+		CreatedAt: now,
+		PausedAt:  &now,
+		Position:  1,
+		Tasks: []*tork.Task{
+			{
+				Name: "task-1",
+			},
+		},
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	// the parent each task and its held child
+	pt := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		State:     tork.TaskStateRunning,
+		Position:  1,
+		CreatedAt: &now,
+	}
+	err = ds.CreateTask(ctx, pt)
+	assert.NoError(t, err)
+	held := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		ParentID:  pt.ID,
+		State:     tork.TaskStatePending,
+		Position:  1,
+		CreatedAt: &now,
+	}
+	err = ds.CreateTask(ctx, held)
+	assert.NoError(t, err)
+
+	j1.State = tork.JobStateResume
+	err = handler(ctx, job.StateChange, j1)
+	assert.NoError(t, err)
+
+	tk := <-released
+	assert.Equal(t, held.ID, tk.ID)
+}
@@ -6,15 +6,18 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/runabol/tork"
 	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/mq"
 )
 
 type logHandler struct {
-	ds datastore.Datastore
+	ds     datastore.Datastore
+	broker mq.Broker
 }
 
-func NewLogHandler(ds datastore.Datastore) func(p *tork.TaskLogPart) {
+func NewLogHandler(ds datastore.Datastore, b mq.Broker) func(p *tork.TaskLogPart) {
 	h := &logHandler{
-		ds: ds,
+		ds:     ds,
+		broker: b,
 	}
 	return h.handle
 }
@@ -24,5 +27,10 @@ func (h *logHandler) handle(p *tork.TaskLogPart) {
 	log.Debug().Msgf("[Task][%s] %s", p.TaskID, p.Contents)
 	if err := h.ds.CreateTaskLogPart(ctx, p); err != nil {
 		log.Error().Err(err).Msgf("error writing task log: %s", err.Error())
+		return
+	}
+	// notify any clients streaming the task's log
+	if err := h.broker.PublishEvent(ctx, mq.TOPIC_UPDATES_LOG_PART, p); err != nil {
+		log.Error().Err(err).Msgf("error publishing task log part")
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/runabol/tork"
 	"github.com/runabol/tork/datastore/inmemory"
 	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/mq"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -14,7 +15,8 @@ func Test_handleLog(t *testing.T) {
 	ctx := context.Background()
 
 	ds := inmemory.NewInMemoryDatastore()
-	handler := NewLogHandler(ds)
+	b := mq.NewInMemoryBroker()
+	handler := NewLogHandler(ds, b)
 	assert.NotNil(t, handler)
 
 	j1 := &tork.Job{
@@ -39,8 +41,19 @@ func Test_handleLog(t *testing.T) {
 		Contents: "line 1",
 	}
 
+	received := make(chan *tork.TaskLogPart, 1)
+	err = b.SubscribeForEvents(ctx, mq.TOPIC_UPDATES_LOG_PART, func(ev any) {
+		p, ok := ev.(*tork.TaskLogPart)
+		assert.True(t, ok)
+		received <- p
+	})
+	assert.NoError(t, err)
+
 	handler(&p1)
 
+	p2 := <-received
+	assert.Equal(t, "line 1", p2.Contents)
+
 	n11, err := ds.GetTaskLogParts(ctx, p1.TaskID, "", 1, 10)
 	assert.NoError(t, err)
 	assert.Equal(t, 1, n11.TotalItems)
@@ -35,9 +35,20 @@ func (h *pendingHandler) handle(ctx context.Context, et task.EventType, t *tork.
 		Msg("handling pending task")
 	if strings.TrimSpace(t.If) == "false" {
 		return h.skipTask(ctx, t)
-	} else {
-		return h.sched.ScheduleTask(ctx, t)
 	}
+	// hold the task until the job is resumed
+	j, err := h.ds.GetJobByID(ctx, t.JobID)
+	if err != nil {
+		return errors.Wrapf(err, "error getting job: %s", t.JobID)
+	}
+	if j.State == tork.JobStatePaused {
+		log.Debug().
+			Str("task-id", t.ID).
+			Str("job-id", t.JobID).
+			Msg("job is paused. holding pending task")
+		return nil
+	}
+	return h.sched.ScheduleTask(ctx, t)
 }
 
 func (h *pendingHandler) skipTask(ctx context.Context, t *tork.Task) error {
@@ -88,3 +88,38 @@ func Test_handleConditionalTask(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, tork.TaskStateSkipped, tk.State)
 }
+
+func Test_handlePendingTaskPausedJob(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewPendingHandler(ds, b)
+	assert.NotNil(t, handler)
+
+	j1 := &tork.Job{
+		ID:    uuid.NewUUID(),
+		Name:  "test job",
+		State: tork.JobStatePaused,
+	}
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Queue: "test-queue",
+		JobID: j1.ID,
+		State: tork.TaskStatePending,
+	}
+
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+
+	err = handler(ctx, task.StateChange, tk)
+	assert.NoError(t, err)
+
+	// the task is held until the job is resumed
+	tk, err = ds.GetTaskByID(ctx, tk.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.TaskStatePending, tk.State)
+}
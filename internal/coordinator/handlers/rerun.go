@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/eval"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/mq"
+)
+
+// rerunJob runs a finished job again from one of its top-level
+// tasks. The tasks of the previous attempt are left untouched
+// and the new attempt is appended to the job's execution.
+// Jobs with a concurrency key are held PENDING, along with
+// the re-run options, until a slot frees up.
+func (h *jobHandler) rerunJob(ctx context.Context, j *tork.Job) error {
+	opts := j.Rerun
+	if opts == nil {
+		opts = &tork.JobRerun{}
+	}
+	j, err := h.ds.GetJobByID(ctx, j.ID)
+	if err != nil {
+		return errors.Wrapf(err, "error getting job from datatstore")
+	}
+	position := j.Position
+	if opts.FromTask != "" {
+		position = 0
+		for _, t := range j.Execution {
+			if t.ID == opts.FromTask {
+				position = t.Position
+				break
+			}
+		}
+		if position == 0 {
+			return errors.Errorf("task %s is not part of job %s", opts.FromTask, j.ID)
+		}
+	}
+	dag := hasDependencies(j.Tasks)
+	if !dag && (position < 1 || position > len(j.Tasks)) {
+		return errors.Errorf("job %s has no task at position %d", j.ID, position)
+	}
+	if err := h.ds.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
+		held := u.State == tork.JobStatePending && u.Rerun != nil
+		if !held &&
+			u.State != tork.JobStateFailed &&
+			u.State != tork.JobStateCancelled &&
+			u.State != tork.JobStateCompleted {
+			return errors.Errorf("job %s is in %s state and can't be re-run", j.ID, u.State)
+		}
+		if u.Concurrency != nil {
+			u.State = tork.JobStatePending
+			u.Rerun = opts
+		} else {
+			u.State = tork.JobStateRunning
+			u.Rerun = nil
+		}
+		u.FailedAt = nil
+		u.CompletedAt = nil
+		u.DeleteAt = nil
+		u.Error = ""
+		u.Result = ""
+		if !dag {
+			u.Position = position
+			progress := float64(position-1) / float64(u.TaskCount) * 100
+			u.Progress = math.Round(progress*100) / 100
+		}
+		if len(opts.WithInputs) > 0 {
+			if u.Context.Inputs == nil {
+				u.Context.Inputs = make(map[string]string)
+			}
+			for k, v := range opts.WithInputs {
+				u.Context.Inputs[k] = v
+			}
+		}
+		j.Context = u.Context.Clone()
+		return nil
+	}); err != nil {
+		return err
+	}
+	if j.Concurrency != nil {
+		ok, err := acquireConcurrencySlot(ctx, h.ds, h.broker, j)
+		if err != nil || !ok {
+			return err
+		}
+	}
+	if dag {
+		return h.restartDAGJob(ctx, j)
+	}
+	if opts.OnlyFailed {
+		prev := lastTopLevelTask(j.Execution, position)
+		if prev != nil && prev.State != tork.TaskStateCompleted && (prev.Parallel != nil || prev.Each != nil) {
+			ok, err := h.rerunFailedChildren(ctx, j, prev)
+			if err != nil || ok {
+				return err
+			}
+		}
+	}
+	now := time.Now().UTC()
+	t := j.Tasks[position-1]
+	t.ID = uuid.NewUUID()
+	t.JobID = j.ID
+	t.State = tork.TaskStatePending
+	t.Position = position
+	t.CreatedAt = &now
+	if err := eval.EvaluateTask(t, j.Context.AsMap()); err != nil {
+		t.Error = err.Error()
+		t.State = tork.TaskStateFailed
+		t.FailedAt = &now
+	}
+	if err := h.ds.CreateTask(ctx, t); err != nil {
+		return err
+	}
+	return h.broker.PublishTask(ctx, mq.QUEUE_PENDING, t)
+}
+
+// rerunFailedChildren creates a new attempt of a parallel or each
+// task which re-runs only the children which did not complete in
+// the previous attempt. Returns false when the previous attempt
+// can't be resumed -- e.g. some of its children were never created
+// -- in which case the whole task should be re-run instead.
+func (h *jobHandler) rerunFailedChildren(ctx context.Context, j *tork.Job, prev *tork.Task) (bool, error) {
+	var done int
+	rerun := make([]*tork.Task, 0)
+	for _, t := range j.Execution {
+		if t.ParentID != prev.ID {
+			continue
+		}
+		if t.State == tork.TaskStateCompleted || t.State == tork.TaskStateSkipped {
+			done = done + 1
+		} else {
+			rerun = append(rerun, t)
+		}
+	}
+	total := done + len(rerun)
+	if len(rerun) == 0 ||
+		(prev.Parallel != nil && total != len(prev.Parallel.Tasks)) ||
+		(prev.Each != nil && total != prev.Each.Size) {
+		return false, nil
+	}
+	now := time.Now().UTC()
+	parent := prev.Clone()
+	parent.ID = uuid.NewUUID()
+	parent.State = tork.TaskStateRunning
+	parent.CreatedAt = &now
+	parent.ScheduledAt = &now
+	parent.StartedAt = &now
+	parent.CompletedAt = nil
+	parent.FailedAt = nil
+	parent.Error = ""
+	parent.Result = ""
+	fire := len(rerun)
+	if parent.Parallel != nil {
+		parent.Parallel.Completions = done
+	} else {
+		if parent.Each.Concurrency > 0 && parent.Each.Concurrency < fire {
+			fire = parent.Each.Concurrency
+		}
+		parent.Each.Completions = done
+		parent.Each.Index = done + fire
+	}
+	if err := h.ds.CreateTask(ctx, parent); err != nil {
+		return false, err
+	}
+	pending := make([]*tork.Task, 0, fire)
+	for i, c := range rerun {
+		t := c.Clone()
+		t.ID = uuid.NewUUID()
+		t.ParentID = parent.ID
+		t.CreatedAt = &now
+		t.ScheduledAt = nil
+		t.StartedAt = nil
+		t.CompletedAt = nil
+		t.FailedAt = nil
+		t.Error = ""
+		t.Result = ""
+		t.NodeID = ""
+		t.Progress = 0
+		if t.Retry != nil {
+			t.Retry.Attempts = 0
+		}
+		if i < fire {
+			t.State = tork.TaskStatePending
+			pending = append(pending, t)
+		} else {
+			t.State = tork.TaskStateCreated
+		}
+		if err := h.ds.CreateTask(ctx, t); err != nil {
+			return false, err
+		}
+	}
+	for _, t := range pending {
+		if err := h.broker.PublishTask(ctx, mq.QUEUE_PENDING, t); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// lastTopLevelTask returns the most recently created
+// top-level task at the given position of the execution.
+func lastTopLevelTask(execution []*tork.Task, position int) *tork.Task {
+	var last *tork.Task
+	for _, t := range execution {
+		if t.ParentID != "" || t.Position != position {
+			continue
+		}
+		if last == nil || (t.CreatedAt != nil && last.CreatedAt != nil && t.CreatedAt.After(*last.CreatedAt)) {
+			last = t
+		}
+	}
+	return last
+}
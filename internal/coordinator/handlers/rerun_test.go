@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore/inmemory"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/middleware/job"
+	"github.com/runabol/tork/mq"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_handleRerunJobFromTask(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewJobHandler(ds, b)
+
+	now := time.Now().UTC()
+
+	j1 := &tork.Job{
+		ID:        uuid.NewUUID(),
+		State:     tork.JobStateFailed,
+		CreatedAt: now,
+		FailedAt:  &now,
+		Position:  2,
+		TaskCount: 2,
+		Error:     "bad things happened",
+		Tasks: []*tork.Task{
+			{Name: "task-1"},
+			{Name: "task-2"},
+		},
+	}
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	t1 := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		Position:  1,
+		State:     tork.TaskStateCompleted,
+		CreatedAt: &now,
+	}
+	err = ds.CreateTask(ctx, t1)
+	assert.NoError(t, err)
+
+	t2 := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		Position:  2,
+		State:     tork.TaskStateFailed,
+		CreatedAt: &now,
+	}
+	err = ds.CreateTask(ctx, t2)
+	assert.NoError(t, err)
+
+	j1.State = tork.JobStateRerun
+	j1.Rerun = &tork.JobRerun{FromTask: t1.ID}
+	err = handler(ctx, job.StateChange, j1)
+	assert.NoError(t, err)
+
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateRunning, j2.State)
+	assert.Equal(t, 1, j2.Position)
+	assert.Equal(t, float64(0), j2.Progress)
+	assert.Nil(t, j2.FailedAt)
+	assert.Empty(t, j2.Error)
+	assert.Len(t, j2.Execution, 3)
+	var rerun *tork.Task
+	for _, tk := range j2.Execution {
+		if tk.State == tork.TaskStatePending {
+			rerun = tk
+		}
+	}
+	assert.NotNil(t, rerun)
+	assert.Equal(t, "task-1", rerun.Name)
+	assert.Equal(t, 1, rerun.Position)
+
+	// can't re-run a running job
+	j1.State = tork.JobStateRerun
+	err = handler(ctx, job.StateChange, j1)
+	assert.Error(t, err)
+}
+
+func Test_handleRerunJobUnknownTask(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewJobHandler(ds, mq.NewInMemoryBroker())
+
+	now := time.Now().UTC()
+
+	j1 := &tork.Job{
+		ID:        uuid.NewUUID(),
+		State:     tork.JobStateFailed,
+		CreatedAt: now,
+		Position:  1,
+		TaskCount: 1,
+		Tasks: []*tork.Task{
+			{Name: "task-1"},
+		},
+	}
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	j1.State = tork.JobStateRerun
+	j1.Rerun = &tork.JobRerun{FromTask: "no-such-task"}
+	err = handler(ctx, job.StateChange, j1)
+	assert.Error(t, err)
+
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateFailed, j2.State)
+}
+
+func Test_handleRerunJobWithInputs(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewJobHandler(ds, mq.NewInMemoryBroker())
+
+	now := time.Now().UTC()
+
+	j1 := &tork.Job{
+		ID:        uuid.NewUUID(),
+		State:     tork.JobStateFailed,
+		CreatedAt: now,
+		Position:  1,
+		TaskCount: 1,
+		Inputs:    map[string]string{"env": "staging", "debug": "false"},
+		Context: tork.JobContext{
+			Inputs: map[string]string{"env": "staging", "debug": "false"},
+		},
+		Tasks: []*tork.Task{
+			{
+				Name: "task-1",
+				Env:  map[string]string{"ENV": "{{ inputs.env }}"},
+			},
+		},
+	}
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	j1.State = tork.JobStateRerun
+	j1.Rerun = &tork.JobRerun{WithInputs: map[string]string{"env": "prod"}}
+	err = handler(ctx, job.StateChange, j1)
+	assert.NoError(t, err)
+
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateRunning, j2.State)
+	assert.Equal(t, "prod", j2.Context.Inputs["env"])
+	assert.Equal(t, "false", j2.Context.Inputs["debug"])
+	assert.Len(t, j2.Execution, 1)
+	assert.Equal(t, "prod", j2.Execution[0].Env["ENV"])
+}
+
+func Test_handleRerunJobOnlyFailed(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewJobHandler(ds, b)
+
+	now := time.Now().UTC()
+
+	j1 := &tork.Job{
+		ID:        uuid.NewUUID(),
+		State:     tork.JobStateFailed,
+		CreatedAt: now,
+		Position:  1,
+		TaskCount: 1,
+		Tasks: []*tork.Task{
+			{
+				Name: "parallel",
+				Parallel: &tork.ParallelTask{
+					Tasks: []*tork.Task{
+						{Name: "child-1"},
+						{Name: "child-2"},
+						{Name: "child-3"},
+					},
+				},
+			},
+		},
+	}
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	parent := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		Position:  1,
+		State:     tork.TaskStateFailed,
+		CreatedAt: &now,
+		Parallel: &tork.ParallelTask{
+			Tasks: []*tork.Task{
+				{Name: "child-1"},
+				{Name: "child-2"},
+				{Name: "child-3"},
+			},
+			Completions: 1,
+		},
+	}
+	err = ds.CreateTask(ctx, parent)
+	assert.NoError(t, err)
+
+	states := []tork.TaskState{
+		tork.TaskStateCompleted,
+		tork.TaskStateFailed,
+		tork.TaskStateCancelled,
+	}
+	for i, state := range states {
+		err := ds.CreateTask(ctx, &tork.Task{
+			ID:        uuid.NewUUID(),
+			JobID:     j1.ID,
+			ParentID:  parent.ID,
+			Position:  1,
+			Name:      j1.Tasks[0].Parallel.Tasks[i].Name,
+			State:     state,
+			CreatedAt: &now,
+		})
+		assert.NoError(t, err)
+	}
+
+	published := make(chan *tork.Task, 10)
+	err = b.SubscribeForTasks(mq.QUEUE_PENDING, func(t *tork.Task) error {
+		published <- t
+		return nil
+	})
+	assert.NoError(t, err)
+
+	j1.State = tork.JobStateRerun
+	j1.Rerun = &tork.JobRerun{OnlyFailed: true}
+	err = handler(ctx, job.StateChange, j1)
+	assert.NoError(t, err)
+
+	j2, err := ds.GetJobByID(ctx, j1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateRunning, j2.State)
+	// the previous attempt plus the new parent and its two children
+	assert.Len(t, j2.Execution, 7)
+
+	var newParent *tork.Task
+	for _, tk := range j2.Execution {
+		if tk.ParentID == "" && tk.ID != parent.ID {
+			newParent = tk
+		}
+	}
+	assert.NotNil(t, newParent)
+	assert.Equal(t, tork.TaskStateRunning, newParent.State)
+	assert.Equal(t, 1, newParent.Parallel.Completions)
+
+	names := make([]string, 0)
+	for i := 0; i < 2; i++ {
+		select {
+		case tk := <-published:
+			assert.Equal(t, newParent.ID, tk.ParentID)
+			names = append(names, tk.Name)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for task")
+		}
+	}
+	assert.ElementsMatch(t, []string{"child-2", "child-3"}, names)
+}
+
+func Test_handleRerunJobConcurrency(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewJobHandler(ds, b)
+
+	now := time.Now().UTC()
+
+	j1 := newConcurrentJob(tork.JobStateRunning, now.Add(-time.Minute), &tork.JobConcurrency{
+		Key:   "deploy-prod",
+		Limit: 1,
+	})
+	err := ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	j2 := newConcurrentJob(tork.JobStateFailed, now, &tork.JobConcurrency{
+		Key:   "deploy-prod",
+		Limit: 1,
+	})
+	j2.FailedAt = &now
+	j2.Position = 2
+	j2.TaskCount = 2
+	j2.Tasks = []*tork.Task{
+		{Name: "task-1"},
+		{Name: "task-2"},
+	}
+	err = ds.CreateJob(ctx, j2)
+	assert.NoError(t, err)
+
+	t2 := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j2.ID,
+		Position:  2,
+		State:     tork.TaskStateFailed,
+		CreatedAt: &now,
+	}
+	err = ds.CreateTask(ctx, t2)
+	assert.NoError(t, err)
+
+	j2.State = tork.JobStateRerun
+	j2.Rerun = &tork.JobRerun{FromTask: t2.ID}
+	err = handler(ctx, job.StateChange, j2)
+	assert.NoError(t, err)
+
+	// held until j1 is done
+	j22, err := ds.GetJobByID(ctx, j2.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStatePending, j22.State)
+	assert.Equal(t, t2.ID, j22.Rerun.FromTask)
+	assert.Len(t, j22.Execution, 1)
+
+	released := make(chan *tork.Job, 1)
+	err = b.SubscribeForJobs(func(j *tork.Job) error {
+		released <- j
+		return nil
+	})
+	assert.NoError(t, err)
+
+	j1.State = tork.JobStateCompleted
+	err = handler(ctx, job.StateChange, j1)
+	assert.NoError(t, err)
+
+	select {
+	case j := <-released:
+		assert.Equal(t, j2.ID, j.ID)
+		assert.Equal(t, tork.JobStateRerun, j.State)
+		err = handler(ctx, job.StateChange, j)
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("expected the held re-run to be released")
+	}
+
+	j22, err = ds.GetJobByID(ctx, j2.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.JobStateScheduled, j22.State)
+	assert.Equal(t, 2, j22.Position)
+	assert.Nil(t, j22.Rerun)
+	assert.Len(t, j22.Execution, 2)
+	for _, tk := range j22.Execution {
+		if tk.ID != t2.ID {
+			assert.Equal(t, "task-2", tk.Name)
+			assert.Equal(t, tork.TaskStatePending, tk.State)
+		}
+	}
+}
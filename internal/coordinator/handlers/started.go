@@ -55,7 +55,17 @@ func (h *startedHandler) handle(ctx context.Context, et task.EventType, t *tork.
 			return err
 		}
 	}
-	return h.ds.UpdateTask(ctx, t.ID, func(u *tork.Task) error {
+	var discarded bool
+	if err := h.ds.UpdateTask(ctx, t.ID, func(u *tork.Task) error {
+		// a task which was already failed -- e.g. by the
+		// reaper, after waiting on its queue for too long
+		// -- and possibly retried, mustn't run. this is
+		// also the case of an out-of-order completion or
+		// failure, for which the cancellation is a no-op
+		if !u.State.IsActive() {
+			discarded = true
+			return nil
+		}
 		// we don't want to mark the task as RUNNING
 		// if an out-of-order task completion/failure
 		// arrived earlier
@@ -73,5 +83,19 @@ func (h *startedHandler) handle(ctx context.Context, et task.EventType, t *tork.
 		// node that picked up the task.
 		u.NodeID = t.NodeID
 		return nil
-	})
+	}); err != nil {
+		return err
+	}
+	if !discarded {
+		return nil
+	}
+	log.Debug().
+		Str("task-id", t.ID).
+		Msg("task is no longer active. cancelling")
+	t.State = tork.TaskStateCancelled
+	node, err := h.ds.GetNodeByID(ctx, t.NodeID)
+	if err != nil {
+		return err
+	}
+	return h.broker.PublishTask(ctx, node.Queue, t)
 }
@@ -111,3 +111,61 @@ func Test_handleStartedTaskOfFailedJob(t *testing.T) {
 	assert.Equal(t, t1.StartedAt, t2.StartedAt)
 	assert.Equal(t, t1.NodeID, t2.NodeID)
 }
+
+func Test_handleStartedTaskReaped(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	qname := uuid.NewUUID()
+
+	cancellations := make(chan *tork.Task)
+	err := b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		cancellations <- t
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+	handler := NewStartedHandler(ds, b)
+	assert.NotNil(t, handler)
+
+	now := time.Now().UTC()
+
+	j1 := &tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateRunning,
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	n1 := &tork.Node{
+		ID:    uuid.NewUUID(),
+		Queue: qname,
+	}
+	err = ds.CreateNode(ctx, n1)
+	assert.NoError(t, err)
+
+	// a task which was failed while waiting on its queue
+	t1 := &tork.Task{
+		ID:       uuid.NewUUID(),
+		State:    tork.TaskStateFailed,
+		FailedAt: &now,
+		JobID:    j1.ID,
+	}
+	err = ds.CreateTask(ctx, t1)
+	assert.NoError(t, err)
+
+	t1.State = tork.TaskStateRunning
+	t1.NodeID = n1.ID
+	err = handler(ctx, task.StateChange, t1)
+	assert.NoError(t, err)
+
+	ct := <-cancellations
+	assert.Equal(t, t1.ID, ct.ID)
+	assert.Equal(t, tork.TaskStateCancelled, ct.State)
+
+	t2, err := ds.GetTaskByID(ctx, t1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.TaskStateFailed, t2.State)
+	assert.Empty(t, t2.NodeID)
+}
@@ -0,0 +1,173 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/internal/cron"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/leader"
+	"github.com/runabol/tork/mq"
+	"golang.org/x/exp/maps"
+)
+
+const defaultCronInterval = time.Second
+
+// CronScheduler periodically materializes a new job
+// out of every scheduled job which is due to run.
+type CronScheduler struct {
+	ds       datastore.Datastore
+	broker   mq.Broker
+	interval time.Duration
+}
+
+func NewCronScheduler(ds datastore.Datastore, b mq.Broker) *CronScheduler {
+	return &CronScheduler{
+		ds:       ds,
+		broker:   b,
+		interval: defaultCronInterval,
+	}
+}
+
+// Register registers the scheduler as a duty of the
+// elector so scheduled jobs are only triggered by the
+// leader coordinator.
+func (s *CronScheduler) Register(e leader.Elector) {
+	e.Register("cron", s.interval, func(ctx context.Context) error {
+		if err := s.tick(ctx, time.Now().UTC()); err != nil {
+			return errors.Wrapf(err, "error triggering scheduled jobs")
+		}
+		return nil
+	})
+}
+
+func (s *CronScheduler) tick(ctx context.Context, now time.Time) error {
+	sjs, err := s.ds.GetActiveScheduledJobs(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "error getting active scheduled jobs")
+	}
+	for _, sj := range sjs {
+		if sj.NextRunAt == nil || sj.NextRunAt.After(now) {
+			continue
+		}
+		if err := s.trigger(ctx, sj.ID, now); err != nil {
+			log.Error().
+				Err(err).
+				Str("scheduled-job-id", sj.ID).
+				Msg("error triggering scheduled job")
+		}
+	}
+	return nil
+}
+
+func (s *CronScheduler) trigger(ctx context.Context, id string, now time.Time) error {
+	var j *tork.Job
+	var lastRunAt, nextRunAt *time.Time
+	if err := s.ds.WithTx(ctx, func(tx datastore.Datastore) error {
+		return tx.UpdateScheduledJob(ctx, id, func(u *tork.ScheduledJob) error {
+			// the scheduled job is locked for the duration of the
+			// transaction, so if another coordinator has already
+			// fired this tick we'll see the advanced next run time
+			if u.State != tork.ScheduledJobStateActive || u.NextRunAt == nil || u.NextRunAt.After(now) {
+				return nil
+			}
+			next, err := cron.Next(u.Cron, now)
+			if err != nil {
+				return err
+			}
+			lastRunAt, nextRunAt = u.LastRunAt, u.NextRunAt
+			u.LastRunAt = &now
+			u.NextRunAt = &next
+			j = newScheduledJobInstance(u, now)
+			return tx.CreateJob(ctx, j)
+		})
+	}); err != nil {
+		return err
+	}
+	if j == nil {
+		return nil
+	}
+	log.Info().
+		Str("scheduled-job-id", id).
+		Str("job-id", j.ID).
+		Msg("created scheduled job instance")
+	// the job is published only once it's committed so that its
+	// handler can't race the transaction. a failed publish rolls
+	// the tick back so that the run is retried on the next poll.
+	if err := s.broker.PublishJob(ctx, j); err != nil {
+		if rerr := s.ds.UpdateScheduledJob(ctx, id, func(u *tork.ScheduledJob) error {
+			if u.LastRunAt != nil && u.LastRunAt.Equal(now) {
+				u.LastRunAt = lastRunAt
+				u.NextRunAt = nextRunAt
+			}
+			return nil
+		}); rerr != nil {
+			log.Error().
+				Err(rerr).
+				Str("scheduled-job-id", id).
+				Msg("error rolling back scheduled job tick")
+		}
+		// the job instance never started so it's failed
+		// rather than left pending forever
+		if rerr := s.ds.UpdateJob(ctx, j.ID, func(u *tork.Job) error {
+			u.State = tork.JobStateFailed
+			u.FailedAt = &now
+			u.Error = err.Error()
+			return nil
+		}); rerr != nil {
+			log.Error().
+				Err(rerr).
+				Str("job-id", j.ID).
+				Msg("error failing unpublished scheduled job instance")
+		}
+		return errors.Wrapf(err, "error publishing scheduled job instance")
+	}
+	return nil
+}
+
+func newScheduledJobInstance(sj *tork.ScheduledJob, now time.Time) *tork.Job {
+	j := &tork.Job{
+		ID:          uuid.NewUUID(),
+		Name:        sj.Name,
+		Description: sj.Description,
+		Tags:        sj.Tags,
+		State:       tork.JobStatePending,
+		CreatedAt:   now,
+		CreatedBy:   sj.CreatedBy,
+		Tasks:       tork.CloneTasks(sj.Tasks),
+		Inputs:      maps.Clone(sj.Inputs),
+		Secrets:     maps.Clone(sj.Secrets),
+		TaskCount:   len(sj.Tasks),
+		Output:      sj.Output,
+		Webhooks:    tork.CloneWebhooks(sj.Webhooks),
+		Permissions: tork.ClonePermissions(sj.Permissions),
+		Schedule: &tork.JobSchedule{
+			ID:   sj.ID,
+			Cron: sj.Cron,
+		},
+	}
+	if sj.Defaults != nil {
+		j.Defaults = sj.Defaults.Clone()
+	}
+	if sj.AutoDelete != nil {
+		j.AutoDelete = sj.AutoDelete.Clone()
+	}
+	if sj.Concurrency != nil {
+		j.Concurrency = sj.Concurrency.Clone()
+	}
+	j.Context = tork.JobContext{
+		Inputs:      maps.Clone(sj.Inputs),
+		InputTypes:  maps.Clone(sj.InputTypes),
+		InputSchema: maps.Clone(sj.InputSchema),
+		Secrets:     maps.Clone(sj.Secrets),
+		Job: map[string]string{
+			"id":   j.ID,
+			"name": j.Name,
+		},
+	}
+	return j
+}
@@ -0,0 +1,218 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore/inmemory"
+	"github.com/runabol/tork/input"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/mq"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_cronTick(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	published := make(chan *tork.Job, 10)
+	err := b.SubscribeForJobs(func(j *tork.Job) error {
+		published <- j
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+	s := NewCronScheduler(ds, b)
+
+	now := time.Now().UTC()
+	next := now.Add(-time.Second)
+	sj := &tork.ScheduledJob{
+		ID:        uuid.NewUUID(),
+		Name:      "test scheduled job",
+		Cron:      "* * * * *",
+		State:     tork.ScheduledJobStateActive,
+		CreatedAt: now,
+		NextRunAt: &next,
+		Inputs:    map[string]string{"var1": "val1"},
+		Tasks: []*tork.Task{
+			{
+				Name:  "task-1",
+				Image: "some:image",
+			},
+		},
+	}
+	err = ds.CreateScheduledJob(ctx, sj)
+	assert.NoError(t, err)
+
+	err = s.tick(ctx, now)
+	assert.NoError(t, err)
+
+	j := <-published
+	assert.Equal(t, tork.JobStatePending, j.State)
+	assert.Equal(t, sj.ID, j.Schedule.ID)
+	assert.Equal(t, "val1", j.Context.Inputs["var1"])
+
+	j2, err := ds.GetJobByID(ctx, j.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "test scheduled job", j2.Name)
+
+	sj2, err := ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	assert.NotNil(t, sj2.LastRunAt)
+	assert.True(t, sj2.NextRunAt.After(now))
+
+	// same tick should not fire again
+	err = s.tick(ctx, now)
+	assert.NoError(t, err)
+
+	select {
+	case <-published:
+		t.Fatal("scheduled job should not have fired twice")
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+// failingJobBroker is a broker which fails to publish jobs.
+type failingJobBroker struct {
+	mq.Broker
+}
+
+func (b *failingJobBroker) PublishJob(ctx context.Context, j *tork.Job) error {
+	return errors.New("broker unavailable")
+}
+
+func Test_cronTickPublishFailure(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	s := NewCronScheduler(ds, &failingJobBroker{mq.NewInMemoryBroker()})
+
+	now := time.Now().UTC()
+	next := now.Add(-time.Second)
+	sj := &tork.ScheduledJob{
+		ID:        uuid.NewUUID(),
+		Name:      "test scheduled job",
+		Cron:      "* * * * *",
+		State:     tork.ScheduledJobStateActive,
+		CreatedAt: now,
+		NextRunAt: &next,
+		Tasks: []*tork.Task{
+			{
+				Name:  "task-1",
+				Image: "some:image",
+			},
+		},
+	}
+	err := ds.CreateScheduledJob(ctx, sj)
+	assert.NoError(t, err)
+
+	err = s.trigger(ctx, sj.ID, now)
+	assert.Error(t, err)
+
+	// the tick is rolled back so the run isn't lost
+	sj2, err := ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, sj2.LastRunAt)
+	assert.True(t, sj2.NextRunAt.Equal(next))
+
+	// and the unpublished job instance isn't left pending
+	jobs, err := ds.GetJobs(ctx, "", "", 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, jobs.Items, 1)
+	assert.Equal(t, tork.JobStateFailed, jobs.Items[0].State)
+
+	// the next poll fires the run once the broker recovers
+	b := mq.NewInMemoryBroker()
+	published := make(chan *tork.Job, 10)
+	err = b.SubscribeForJobs(func(j *tork.Job) error {
+		published <- j
+		return nil
+	})
+	assert.NoError(t, err)
+	s.broker = b
+
+	err = s.trigger(ctx, sj.ID, now)
+	assert.NoError(t, err)
+
+	j := <-published
+	assert.Equal(t, sj.ID, j.Schedule.ID)
+}
+
+func Test_cronTickUsesLibraryTask(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	published := make(chan *tork.Job, 10)
+	err := b.SubscribeForJobs(func(j *tork.Job) error {
+		published <- j
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+	s := NewCronScheduler(ds, b)
+
+	err = ds.CreateLibraryTask(ctx, &tork.LibraryTask{
+		ID:     uuid.NewUUID(),
+		Name:   "lint",
+		Params: []byte(`{"path":{"required":true}}`),
+		Task:   []byte(`{"name":"lint","image":"golangci/golangci-lint","run":"golangci-lint run {{ with.path }}"}`),
+	})
+	assert.NoError(t, err)
+
+	ji := &input.ScheduledJob{
+		Cron: "* * * * *",
+		Job: input.Job{
+			Name: "nightly lint",
+			Tasks: []input.Task{{
+				Name: "lint code",
+				Uses: "lint",
+				With: map[string]string{"path": "./..."},
+			}},
+		},
+	}
+	assert.NoError(t, ji.ExpandUses(ctx, ds))
+	sj := ji.ToScheduledJob()
+	now := time.Now().UTC()
+	next := now.Add(-time.Second)
+	sj.NextRunAt = &next
+	err = ds.CreateScheduledJob(ctx, sj)
+	assert.NoError(t, err)
+
+	err = s.tick(ctx, now)
+	assert.NoError(t, err)
+
+	j := <-published
+	assert.Equal(t, "lint code", j.Tasks[0].Name)
+	assert.Equal(t, "golangci/golangci-lint", j.Tasks[0].Image)
+	assert.Equal(t, "golangci-lint run ./...", j.Tasks[0].Run)
+}
+
+func Test_cronTickPaused(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	ds := inmemory.NewInMemoryDatastore()
+	s := NewCronScheduler(ds, b)
+
+	now := time.Now().UTC()
+	next := now.Add(-time.Second)
+	sj := &tork.ScheduledJob{
+		ID:        uuid.NewUUID(),
+		Cron:      "* * * * *",
+		State:     tork.ScheduledJobStatePaused,
+		CreatedAt: now,
+		NextRunAt: &next,
+	}
+	err := ds.CreateScheduledJob(ctx, sj)
+	assert.NoError(t, err)
+
+	err = s.tick(ctx, now)
+	assert.NoError(t, err)
+
+	sj2, err := ds.GetScheduledJobByID(ctx, sj.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, sj2.LastRunAt)
+}
@@ -0,0 +1,192 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/leader"
+	"github.com/runabol/tork/mq"
+)
+
+const defaultReaperInterval = time.Second * 30
+
+// Reaper periodically recovers the tasks orphaned by dead
+// workers: the RUNNING or SCHEDULED tasks whose node went
+// OFFLINE and -- if a timeout is set -- the SCHEDULED tasks
+// which didn't start within that timeout. Orphaned tasks are
+// failed, leaving it to the error handler to retry them
+// according to their retry policy. SCHEDULED tasks which were
+// dispatched to an OFFLINE node are dispatched again instead:
+// node queues aren't durable, so the reaper is what recovers
+// the tasks which were still waiting on the queue of a dead
+// node. A task which timed out may still be on its queue, but
+// should a worker pick it up later, it's cancelled once it's
+// reported as started. The reaper only runs on the leader
+// coordinator, but since every task is claimed within a
+// transaction before it's failed, it remains safe should the
+// leadership briefly overlap.
+type Reaper struct {
+	ds               datastore.Datastore
+	broker           mq.Broker
+	sched            *Scheduler
+	interval         time.Duration
+	scheduledTimeout time.Duration
+}
+
+func NewReaper(ds datastore.Datastore, b mq.Broker, scheduledTimeout time.Duration) *Reaper {
+	return &Reaper{
+		ds:               ds,
+		broker:           b,
+		sched:            NewScheduler(ds, b),
+		interval:         defaultReaperInterval,
+		scheduledTimeout: scheduledTimeout,
+	}
+}
+
+// Register registers the reaper as a duty of the
+// elector so orphaned tasks are only reaped by the
+// leader coordinator.
+func (r *Reaper) Register(e leader.Elector) {
+	e.Register("reaper", r.interval, func(ctx context.Context) error {
+		if err := r.tick(ctx, time.Now().UTC()); err != nil {
+			return errors.Wrapf(err, "error reaping orphaned tasks")
+		}
+		return nil
+	})
+}
+
+func (r *Reaper) tick(ctx context.Context, now time.Time) error {
+	// same as the threshold after which
+	// a node is considered OFFLINE
+	heartbeatBefore := now.Add(-tork.HEARTBEAT_RATE * 2)
+	var scheduledBefore time.Time
+	if r.scheduledTimeout > 0 {
+		scheduledBefore = now.Add(-r.scheduledTimeout)
+	}
+	ts, err := r.ds.GetOrphanedTasks(ctx, heartbeatBefore, scheduledBefore)
+	if err != nil {
+		return errors.Wrapf(err, "error getting orphaned tasks")
+	}
+	for _, t := range ts {
+		var reason string
+		if t.State == tork.TaskStateScheduled && t.ScheduledAt != nil && t.ScheduledAt.Before(scheduledBefore) {
+			reason = fmt.Sprintf("task orphaned: not started within %s of being scheduled", r.scheduledTimeout)
+		} else if t.State == tork.TaskStateScheduled {
+			if err := r.redispatch(ctx, t, now); err != nil {
+				log.Error().
+					Err(err).
+					Str("task-id", t.ID).
+					Msg("error re-dispatching orphaned task")
+			}
+			continue
+		} else {
+			reason = fmt.Sprintf("task orphaned: node %s is offline", t.NodeID)
+		}
+		if err := r.reap(ctx, t, reason, now); err != nil {
+			log.Error().
+				Err(err).
+				Str("task-id", t.ID).
+				Msg("error reaping orphaned task")
+		}
+	}
+	return nil
+}
+
+func (r *Reaper) reap(ctx context.Context, t *tork.Task, reason string, now time.Time) error {
+	var claimed bool
+	if err := r.ds.UpdateTask(ctx, t.ID, func(u *tork.Task) error {
+		// the task may have moved on since it was
+		// fetched, or another coordinator may have
+		// already reaped it
+		if u.State != t.State || u.NodeID != t.NodeID {
+			return nil
+		}
+		u.State = tork.TaskStateFailed
+		u.FailedAt = &now
+		u.Error = reason
+		claimed = true
+		return nil
+	}); err != nil {
+		return err
+	}
+	if !claimed {
+		return nil
+	}
+	log.Warn().
+		Str("task-id", t.ID).
+		Str("node-id", t.NodeID).
+		Msg(reason)
+	ft := t.Clone()
+	ft.State = tork.TaskStateFailed
+	ft.FailedAt = &now
+	ft.Error = reason
+	if err := r.broker.PublishTask(ctx, mq.QUEUE_ERROR, ft); err != nil {
+		// give the claim back so that the
+		// task is reaped on the next tick
+		if rerr := r.ds.UpdateTask(ctx, t.ID, func(u *tork.Task) error {
+			if u.State == tork.TaskStateFailed && u.Error == reason {
+				u.State = t.State
+				u.FailedAt = t.FailedAt
+				u.Error = t.Error
+			}
+			return nil
+		}); rerr != nil {
+			log.Error().
+				Err(rerr).
+				Str("task-id", t.ID).
+				Msg("error rolling back the reaping of task")
+		}
+		return errors.Wrapf(err, "error publishing task failure")
+	}
+	return nil
+}
+
+// redispatch dispatches again a task which was waiting on the queue
+// of a node that went offline. Should the dispatch fail, the task is
+// assigned back to the offline node to be retried on the next tick.
+func (r *Reaper) redispatch(ctx context.Context, t *tork.Task, now time.Time) error {
+	nodeID := t.NodeID
+	scheduledAt := t.ScheduledAt
+	var claimed bool
+	if err := r.ds.UpdateTask(ctx, t.ID, func(u *tork.Task) error {
+		if u.State != tork.TaskStateScheduled || u.NodeID != nodeID {
+			return nil
+		}
+		u.NodeID = ""
+		u.ScheduledAt = &now
+		claimed = true
+		return nil
+	}); err != nil {
+		return err
+	}
+	if !claimed {
+		return nil
+	}
+	log.Warn().
+		Str("task-id", t.ID).
+		Str("node-id", nodeID).
+		Msg("node is offline. re-dispatching task")
+	t.NodeID = ""
+	t.ScheduledAt = &now
+	if err := r.sched.dispatchTask(ctx, t); err != nil {
+		if rerr := r.ds.UpdateTask(ctx, t.ID, func(u *tork.Task) error {
+			if u.State == tork.TaskStateScheduled {
+				u.NodeID = nodeID
+				u.ScheduledAt = scheduledAt
+			}
+			return nil
+		}); rerr != nil {
+			log.Error().
+				Err(rerr).
+				Str("task-id", t.ID).
+				Msg("error rolling back the re-dispatch of task")
+		}
+		return errors.Wrapf(err, "error dispatching task")
+	}
+	return nil
+}
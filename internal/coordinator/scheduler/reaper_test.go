@@ -0,0 +1,214 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore/inmemory"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/mq"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_reaperTick(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	failed := make(chan *tork.Task, 10)
+	err := b.SubscribeForTasks(mq.QUEUE_ERROR, func(tk *tork.Task) error {
+		failed <- tk
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+	r := NewReaper(ds, b, time.Hour)
+
+	now := time.Now().UTC()
+	node := &tork.Node{
+		ID:              uuid.NewUUID(),
+		LastHeartbeatAt: now.Add(-time.Minute * 10),
+	}
+	err = ds.CreateNode(ctx, node)
+	assert.NoError(t, err)
+
+	t1 := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     uuid.NewUUID(),
+		State:     tork.TaskStateRunning,
+		CreatedAt: &now,
+		NodeID:    node.ID,
+	}
+	err = ds.CreateTask(ctx, t1)
+	assert.NoError(t, err)
+
+	err = r.tick(ctx, now)
+	assert.NoError(t, err)
+
+	tk := <-failed
+	assert.Equal(t, t1.ID, tk.ID)
+	assert.Equal(t, tork.TaskStateFailed, tk.State)
+	assert.Contains(t, tk.Error, "is offline")
+
+	t2, err := ds.GetTaskByID(ctx, t1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.TaskStateFailed, t2.State)
+	assert.Equal(t, tk.Error, t2.Error)
+
+	// should not be reaped twice
+	err = r.tick(ctx, now)
+	assert.NoError(t, err)
+	select {
+	case <-failed:
+		t.Fatal("task reaped twice")
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func Test_reaperTickScheduledTimeout(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	failed := make(chan *tork.Task, 10)
+	err := b.SubscribeForTasks(mq.QUEUE_ERROR, func(tk *tork.Task) error {
+		failed <- tk
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+	r := NewReaper(ds, b, time.Hour)
+
+	now := time.Now().UTC()
+	scheduledAt := now.Add(-time.Minute * 30)
+	t1 := &tork.Task{
+		ID:          uuid.NewUUID(),
+		JobID:       uuid.NewUUID(),
+		State:       tork.TaskStateScheduled,
+		CreatedAt:   &scheduledAt,
+		ScheduledAt: &scheduledAt,
+	}
+	err = ds.CreateTask(ctx, t1)
+	assert.NoError(t, err)
+
+	// within the timeout
+	err = r.tick(ctx, now)
+	assert.NoError(t, err)
+
+	t2, err := ds.GetTaskByID(ctx, t1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.TaskStateScheduled, t2.State)
+
+	err = r.tick(ctx, now.Add(time.Hour))
+	assert.NoError(t, err)
+
+	tk := <-failed
+	assert.Equal(t, t1.ID, tk.ID)
+	assert.Contains(t, tk.Error, "not started within 1h0m0s")
+}
+
+func Test_reaperTickRedispatch(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	ds := inmemory.NewInMemoryDatastore()
+	r := NewReaper(ds, b, time.Hour)
+
+	now := time.Now().UTC()
+	offline := &tork.Node{
+		ID:              uuid.NewUUID(),
+		LastHeartbeatAt: now.Add(-time.Minute * 10),
+		Labels:          map[string]string{"zone": "a"},
+		Queues:          []string{mq.QUEUE_DEFAULT},
+	}
+	err := ds.CreateNode(ctx, offline)
+	assert.NoError(t, err)
+	up := &tork.Node{
+		ID:              uuid.NewUUID(),
+		Status:          tork.NodeStatusUP,
+		LastHeartbeatAt: now,
+		Labels:          map[string]string{"zone": "a"},
+		Queues:          []string{mq.QUEUE_DEFAULT},
+	}
+	err = ds.CreateNode(ctx, up)
+	assert.NoError(t, err)
+
+	dispatched := make(chan *tork.Task, 10)
+	err = b.SubscribeForTasks(mq.NodeQueue(up.ID), func(tk *tork.Task) error {
+		dispatched <- tk
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// a task which was waiting on the queue of the offline node
+	t1 := &tork.Task{
+		ID:           uuid.NewUUID(),
+		JobID:        uuid.NewUUID(),
+		State:        tork.TaskStateScheduled,
+		CreatedAt:    &now,
+		ScheduledAt:  &now,
+		Queue:        mq.QUEUE_DEFAULT,
+		NodeID:       offline.ID,
+		NodeSelector: map[string]string{"zone": "a"},
+	}
+	err = ds.CreateTask(ctx, t1)
+	assert.NoError(t, err)
+
+	err = r.tick(ctx, now)
+	assert.NoError(t, err)
+
+	tk := <-dispatched
+	assert.Equal(t, t1.ID, tk.ID)
+	assert.Equal(t, tork.TaskStateScheduled, tk.State)
+
+	t2, err := ds.GetTaskByID(ctx, t1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.TaskStateScheduled, t2.State)
+	assert.Equal(t, up.ID, t2.NodeID)
+}
+
+// failingBroker is a broker which fails to publish tasks.
+type failingBroker struct {
+	mq.Broker
+}
+
+func (b *failingBroker) PublishTask(ctx context.Context, qname string, t *tork.Task) error {
+	return errors.New("broker unavailable")
+}
+
+func Test_reaperTickPublishFailure(t *testing.T) {
+	ctx := context.Background()
+	ds := inmemory.NewInMemoryDatastore()
+	r := NewReaper(ds, &failingBroker{Broker: mq.NewInMemoryBroker()}, time.Hour)
+
+	now := time.Now().UTC()
+	node := &tork.Node{
+		ID:              uuid.NewUUID(),
+		LastHeartbeatAt: now.Add(-time.Minute * 10),
+	}
+	err := ds.CreateNode(ctx, node)
+	assert.NoError(t, err)
+
+	t1 := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     uuid.NewUUID(),
+		State:     tork.TaskStateRunning,
+		CreatedAt: &now,
+		NodeID:    node.ID,
+	}
+	err = ds.CreateTask(ctx, t1)
+	assert.NoError(t, err)
+
+	err = r.tick(ctx, now)
+	assert.NoError(t, err)
+
+	// the claim is rolled back so the
+	// task is reaped on the next tick
+	t2, err := ds.GetTaskByID(ctx, t1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.TaskStateRunning, t2.State)
+	assert.Empty(t, t2.Error)
+	assert.Nil(t, t2.FailedAt)
+}
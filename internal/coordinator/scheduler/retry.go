@@ -0,0 +1,91 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore"
+	"github.com/runabol/tork/mq"
+)
+
+const defaultRetryInterval = time.Second
+
+// RetryScheduler periodically publishes delayed
+// retry tasks once their "not before" time is due.
+// Since the delay is persisted alongside the task,
+// pending retries survive a coordinator restart.
+type RetryScheduler struct {
+	ds       datastore.Datastore
+	broker   mq.Broker
+	interval time.Duration
+	stop     chan any
+}
+
+func NewRetryScheduler(ds datastore.Datastore, b mq.Broker) *RetryScheduler {
+	return &RetryScheduler{
+		ds:       ds,
+		broker:   b,
+		interval: defaultRetryInterval,
+		stop:     make(chan any),
+	}
+}
+
+func (s *RetryScheduler) Start() {
+	go func() {
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(s.interval):
+				if err := s.tick(context.Background(), time.Now().UTC()); err != nil {
+					log.Error().Err(err).Msg("error publishing delayed tasks")
+				}
+			}
+		}
+	}()
+}
+
+func (s *RetryScheduler) Stop() {
+	close(s.stop)
+}
+
+func (s *RetryScheduler) tick(ctx context.Context, now time.Time) error {
+	ts, err := s.ds.GetDelayedTasks(ctx, now)
+	if err != nil {
+		return errors.Wrapf(err, "error getting delayed tasks")
+	}
+	for _, t := range ts {
+		if err := s.release(ctx, t); err != nil {
+			log.Error().
+				Err(err).
+				Str("task-id", t.ID).
+				Msg("error publishing delayed task")
+		}
+	}
+	return nil
+}
+
+func (s *RetryScheduler) release(ctx context.Context, t *tork.Task) error {
+	var claimed bool
+	if err := s.ds.UpdateTask(ctx, t.ID, func(u *tork.Task) error {
+		// another coordinator may have already
+		// published the task, or it may have been
+		// cancelled in the meantime
+		if u.State != tork.TaskStateCreated {
+			return nil
+		}
+		u.State = tork.TaskStatePending
+		claimed = true
+		return nil
+	}); err != nil {
+		return err
+	}
+	if !claimed {
+		return nil
+	}
+	t.State = tork.TaskStatePending
+	return s.broker.PublishTask(ctx, mq.QUEUE_PENDING, t)
+}
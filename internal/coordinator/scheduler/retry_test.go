@@ -0,0 +1,68 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/datastore/inmemory"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/mq"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_retryTick(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	published := make(chan *tork.Task, 10)
+	err := b.SubscribeForTasks(mq.QUEUE_PENDING, func(tk *tork.Task) error {
+		published <- tk
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+	s := NewRetryScheduler(ds, b)
+
+	now := time.Now().UTC()
+	notBefore := now.Add(time.Second)
+	t1 := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     uuid.NewUUID(),
+		State:     tork.TaskStateCreated,
+		CreatedAt: &now,
+		NotBefore: &notBefore,
+	}
+	err = ds.CreateTask(ctx, t1)
+	assert.NoError(t, err)
+
+	// not due yet
+	err = s.tick(ctx, now)
+	assert.NoError(t, err)
+
+	t2, err := ds.GetTaskByID(ctx, t1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.TaskStateCreated, t2.State)
+
+	err = s.tick(ctx, notBefore)
+	assert.NoError(t, err)
+
+	tk := <-published
+	assert.Equal(t, t1.ID, tk.ID)
+
+	t2, err = ds.GetTaskByID(ctx, t1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.TaskStatePending, t2.State)
+
+	// should not be published twice
+	err = s.tick(ctx, notBefore)
+	assert.NoError(t, err)
+
+	select {
+	case <-published:
+		t.Fatal("delayed task should not have been published twice")
+	case <-time.After(time.Millisecond * 100):
+	}
+}
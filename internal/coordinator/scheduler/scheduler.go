@@ -4,14 +4,18 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"strconv"
 	"time"
 
+	"github.com/docker/go-units"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 	"github.com/runabol/tork"
 	"github.com/runabol/tork/datastore"
 	"github.com/runabol/tork/internal/eval"
 	"github.com/runabol/tork/internal/uuid"
 	"github.com/runabol/tork/mq"
+	"golang.org/x/exp/slices"
 )
 
 type Scheduler struct {
@@ -66,6 +70,18 @@ func (s *Scheduler) scheduleRegularTask(ctx context.Context, t *tork.Task) error
 			if t.Retry.Limit == 0 {
 				t.Retry.Limit = job.Defaults.Retry.Limit
 			}
+			if t.Retry.InitialDelay == "" {
+				t.Retry.InitialDelay = job.Defaults.Retry.InitialDelay
+			}
+			if t.Retry.MaxDelay == "" {
+				t.Retry.MaxDelay = job.Defaults.Retry.MaxDelay
+			}
+			if t.Retry.ScalingFactor == 0 {
+				t.Retry.ScalingFactor = job.Defaults.Retry.ScalingFactor
+			}
+			if len(t.Retry.RetryOn) == 0 {
+				t.Retry.RetryOn = job.Defaults.Retry.RetryOn
+			}
 		}
 		if t.Priority == 0 {
 			t.Priority = job.Defaults.Priority
@@ -75,9 +91,18 @@ func (s *Scheduler) scheduleRegularTask(ctx context.Context, t *tork.Task) error
 		t.Queue = mq.QUEUE_DEFAULT
 	}
 	// mark task state as scheduled
+	state := t.State
 	t.State = tork.TaskStateScheduled
 	t.ScheduledAt = &now
+	skip := false
 	if err := s.ds.UpdateTask(ctx, t.ID, func(u *tork.Task) error {
+		// a duplicate message (e.g. one republished when resuming
+		// a job) for a task which was already scheduled or since
+		// cancelled must not schedule it again
+		if u.State != tork.TaskStatePending && u.State != state {
+			skip = true
+			return nil
+		}
 		u.State = t.State
 		u.ScheduledAt = t.ScheduledAt
 		u.Queue = t.Queue
@@ -89,9 +114,141 @@ func (s *Scheduler) scheduleRegularTask(ctx context.Context, t *tork.Task) error
 	}); err != nil {
 		return errors.Wrapf(err, "error updating task in datastore")
 	}
+	if skip {
+		log.Debug().
+			Str("task-id", t.ID).
+			Msg("task is no longer pending. skipping")
+		return nil
+	}
+	// a task which can't fit on any of the nodes serving its
+	// queue is failed rather than endlessly requeued by them
+	if t.Limits != nil {
+		ok, err := s.hasCapacity(ctx, t)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			t.State = tork.TaskStateFailed
+			t.FailedAt = &now
+			t.Error = fmt.Sprintf("no active node serving queue %s has the resources (cpus: %s, memory: %s) required by task %s", t.Queue, t.Limits.CPUs, t.Limits.Memory, t.ID)
+			return s.broker.PublishTask(ctx, mq.QUEUE_ERROR, t)
+		}
+	}
+	if len(t.NodeSelector) > 0 || len(t.Affinity) > 0 {
+		return s.dispatchTask(ctx, t)
+	}
 	return s.broker.PublishTask(ctx, t.Queue, t)
 }
 
+// hasCapacity returns false if there are active nodes serving the
+// task's queue but none of them has the total resources required by
+// the task. Without any such node the task is left to wait for one.
+func (s *Scheduler) hasCapacity(ctx context.Context, t *tork.Task) (bool, error) {
+	nodes, err := s.ds.GetActiveNodes(ctx)
+	if err != nil {
+		return false, errors.Wrapf(err, "error getting active nodes")
+	}
+	serving := false
+	for _, n := range nodes {
+		if n.Status != tork.NodeStatusUP || !slices.Contains(n.Queues, t.Queue) {
+			continue
+		}
+		if fits(n, t.Limits) {
+			return true, nil
+		}
+		serving = true
+	}
+	return !serving, nil
+}
+
+// fits returns true if the given limits don't exceed the total
+// resources of the node. Resources which the node doesn't
+// report are assumed to be sufficient.
+func fits(n *tork.Node, l *tork.TaskLimits) bool {
+	if l == nil {
+		return true
+	}
+	if cpus, err := strconv.ParseFloat(l.CPUs, 64); err == nil && n.CPUs > 0 && cpus > n.CPUs {
+		return false
+	}
+	if mem, err := units.RAMInBytes(l.Memory); err == nil && n.Memory > 0 && mem > n.Memory {
+		return false
+	}
+	return true
+}
+
+// dispatchTask publishes a task which has placement constraints
+// directly to the queue of the node best matching them: among
+// the nodes serving the task's queue with the resources it requires
+// and whose labels match all of the task's node selector, the one matching most of its affinity
+// labels and, from those, the least busy one. A task with affinity
+// labels only falls back to its regular queue if no node matches
+// any of them. The task is assigned to the selected node up front
+// so that it's reaped should the node go offline before running it.
+func (s *Scheduler) dispatchTask(ctx context.Context, t *tork.Task) error {
+	nodes, err := s.ds.GetActiveNodes(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "error getting active nodes")
+	}
+	var selected *tork.Node
+	bestScore := 0
+	for _, n := range nodes {
+		if n.Status != tork.NodeStatusUP ||
+			!slices.Contains(n.Queues, t.Queue) ||
+			!matchLabels(n.Labels, t.NodeSelector) ||
+			!fits(n, t.Limits) {
+			continue
+		}
+		score := affinityScore(n.Labels, t.Affinity)
+		if selected == nil || score > bestScore ||
+			(score == bestScore && n.TaskCount < selected.TaskCount) {
+			selected = n
+			bestScore = score
+		}
+	}
+	if selected == nil && len(t.NodeSelector) > 0 {
+		now := time.Now().UTC()
+		t.State = tork.TaskStateFailed
+		t.FailedAt = &now
+		t.Error = fmt.Sprintf("no active node serving queue %s matches the node selector of task %s", t.Queue, t.ID)
+		return s.broker.PublishTask(ctx, mq.QUEUE_ERROR, t)
+	}
+	if selected == nil || (len(t.NodeSelector) == 0 && bestScore == 0) {
+		return s.broker.PublishTask(ctx, t.Queue, t)
+	}
+	t.NodeID = selected.ID
+	if err := s.ds.UpdateTask(ctx, t.ID, func(u *tork.Task) error {
+		u.NodeID = t.NodeID
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "error updating task in datastore")
+	}
+	return s.broker.PublishTask(ctx, mq.NodeQueue(selected.ID), t)
+}
+
+// matchLabels returns true if the given labels
+// contain all of the selector's key/value pairs.
+func matchLabels(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if lv, ok := labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// affinityScore returns the number of the affinity's
+// key/value pairs which the given labels contain.
+func affinityScore(labels, affinity map[string]string) int {
+	score := 0
+	for k, v := range affinity {
+		if lv, ok := labels[k]; ok && lv == v {
+			score++
+		}
+	}
+	return score
+}
+
 func (s *Scheduler) scheduleSubJob(ctx context.Context, t *tork.Task) error {
 	if t.SubJob.Detached {
 		return s.scheduleDetachedSubJob(ctx, t)
@@ -56,6 +56,53 @@ func Test_scheduleRegularTask(t *testing.T) {
 	assert.Equal(t, tork.TaskStateScheduled, tk.State)
 }
 
+func Test_scheduleRegularTaskAlreadyScheduled(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+
+	published := int32(0)
+	err := b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		atomic.AddInt32(&published, 1)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+	s := NewScheduler(ds, b)
+
+	j1 := &tork.Job{
+		ID:   uuid.NewUUID(),
+		Name: "test job",
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Queue: "test-queue",
+		JobID: j1.ID,
+		State: tork.TaskStatePending,
+	}
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+
+	// a duplicate message for the same pending task
+	dup := tk.Clone()
+
+	err = s.scheduleRegularTask(ctx, tk)
+	assert.NoError(t, err)
+
+	err = s.scheduleRegularTask(ctx, dup)
+	assert.NoError(t, err)
+
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&published))
+
+	tk, err = ds.GetTaskByID(ctx, tk.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.TaskStateScheduled, tk.State)
+}
+
 func Test_scheduleRegularTaskOverrideDefaultQueue(t *testing.T) {
 	ctx := context.Background()
 	b := mq.NewInMemoryBroker()
@@ -150,6 +197,281 @@ func Test_scheduleRegularTaskJobDefaults(t *testing.T) {
 	assert.Equal(t, 3, tk.Priority)
 }
 
+func Test_scheduleRegularTaskNodeSelector(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	ds := inmemory.NewInMemoryDatastore()
+	s := NewScheduler(ds, b)
+
+	now := time.Now().UTC()
+	nodes := []*tork.Node{{
+		ID:              uuid.NewUUID(),
+		Status:          tork.NodeStatusUP,
+		LastHeartbeatAt: now,
+		Labels:          map[string]string{"zone": "a", "arch": "amd64"},
+		Queues:          []string{mq.QUEUE_DEFAULT},
+	}, {
+		ID:              uuid.NewUUID(),
+		Status:          tork.NodeStatusUP,
+		LastHeartbeatAt: now,
+		Labels:          map[string]string{"zone": "a", "arch": "arm64"},
+		Queues:          []string{mq.QUEUE_DEFAULT},
+	}, {
+		ID:              uuid.NewUUID(),
+		Status:          tork.NodeStatusUP,
+		LastHeartbeatAt: now,
+		Labels:          map[string]string{"zone": "b", "arch": "arm64"},
+		Queues:          []string{mq.QUEUE_DEFAULT},
+	}}
+	for _, n := range nodes {
+		assert.NoError(t, ds.CreateNode(ctx, n))
+	}
+
+	processed := make(chan any)
+	err := b.SubscribeForTasks(mq.NodeQueue(nodes[1].ID), func(t *tork.Task) error {
+		close(processed)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	j1 := &tork.Job{
+		ID:   uuid.NewUUID(),
+		Name: "test job",
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	tk := &tork.Task{
+		ID:           uuid.NewUUID(),
+		JobID:        j1.ID,
+		NodeSelector: map[string]string{"zone": "a"},
+		Affinity:     map[string]string{"arch": "arm64"},
+	}
+
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+
+	err = s.scheduleRegularTask(ctx, tk)
+	assert.NoError(t, err)
+
+	<-processed
+
+	tk, err = ds.GetTaskByID(ctx, tk.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tork.TaskStateScheduled, tk.State)
+	assert.Equal(t, nodes[1].ID, tk.NodeID)
+}
+
+func Test_scheduleRegularTaskNoMatchingNode(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	ds := inmemory.NewInMemoryDatastore()
+	s := NewScheduler(ds, b)
+
+	err := ds.CreateNode(ctx, &tork.Node{
+		ID:              uuid.NewUUID(),
+		Status:          tork.NodeStatusUP,
+		LastHeartbeatAt: time.Now().UTC(),
+		Labels:          map[string]string{"zone": "b"},
+	})
+	assert.NoError(t, err)
+
+	failed := make(chan *tork.Task)
+	err = b.SubscribeForTasks(mq.QUEUE_ERROR, func(t *tork.Task) error {
+		failed <- t
+		return nil
+	})
+	assert.NoError(t, err)
+
+	j1 := &tork.Job{
+		ID:   uuid.NewUUID(),
+		Name: "test job",
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	tk := &tork.Task{
+		ID:           uuid.NewUUID(),
+		JobID:        j1.ID,
+		NodeSelector: map[string]string{"zone": "a"},
+	}
+
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+
+	err = s.scheduleRegularTask(ctx, tk)
+	assert.NoError(t, err)
+
+	ft := <-failed
+	assert.Equal(t, tork.TaskStateFailed, ft.State)
+	assert.Contains(t, ft.Error, "no active node serving queue default matches")
+}
+
+func Test_scheduleRegularTaskExceedsCapacity(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	ds := inmemory.NewInMemoryDatastore()
+	s := NewScheduler(ds, b)
+
+	now := time.Now().UTC()
+	nodes := []*tork.Node{{
+		ID:              uuid.NewUUID(),
+		Status:          tork.NodeStatusUP,
+		LastHeartbeatAt: now,
+		CPUs:            2,
+		Queues:          []string{mq.QUEUE_DEFAULT},
+	}, {
+		// a larger node serving another queue
+		ID:              uuid.NewUUID(),
+		Status:          tork.NodeStatusUP,
+		LastHeartbeatAt: now,
+		CPUs:            16,
+		Queues:          []string{"other"},
+	}}
+	for _, n := range nodes {
+		assert.NoError(t, ds.CreateNode(ctx, n))
+	}
+
+	failed := make(chan *tork.Task)
+	err := b.SubscribeForTasks(mq.QUEUE_ERROR, func(t *tork.Task) error {
+		failed <- t
+		return nil
+	})
+	assert.NoError(t, err)
+
+	j1 := &tork.Job{
+		ID:   uuid.NewUUID(),
+		Name: "test job",
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	tk := &tork.Task{
+		ID:     uuid.NewUUID(),
+		JobID:  j1.ID,
+		Limits: &tork.TaskLimits{CPUs: "8"},
+	}
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+
+	err = s.scheduleRegularTask(ctx, tk)
+	assert.NoError(t, err)
+
+	ft := <-failed
+	assert.Equal(t, tork.TaskStateFailed, ft.State)
+	assert.Contains(t, ft.Error, "no active node serving queue default has the resources")
+
+	// a task which fits one of the nodes is published as usual
+	processed := make(chan any)
+	err = b.SubscribeForTasks(mq.QUEUE_DEFAULT, func(t *tork.Task) error {
+		close(processed)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	tk2 := &tork.Task{
+		ID:     uuid.NewUUID(),
+		JobID:  j1.ID,
+		Limits: &tork.TaskLimits{CPUs: "1"},
+	}
+	err = ds.CreateTask(ctx, tk2)
+	assert.NoError(t, err)
+
+	err = s.scheduleRegularTask(ctx, tk2)
+	assert.NoError(t, err)
+	<-processed
+}
+
+func Test_scheduleRegularTaskNodeSelectorOtherQueue(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	ds := inmemory.NewInMemoryDatastore()
+	s := NewScheduler(ds, b)
+
+	now := time.Now().UTC()
+	nodes := []*tork.Node{{
+		ID:              uuid.NewUUID(),
+		Status:          tork.NodeStatusUP,
+		LastHeartbeatAt: now,
+		Labels:          map[string]string{"zone": "a"},
+		Queues:          []string{mq.QUEUE_DEFAULT},
+	}, {
+		ID:              uuid.NewUUID(),
+		Status:          tork.NodeStatusUP,
+		LastHeartbeatAt: now,
+		Labels:          map[string]string{"zone": "a"},
+		Queues:          []string{"gpu"},
+		TaskCount:       5,
+	}}
+	for _, n := range nodes {
+		assert.NoError(t, ds.CreateNode(ctx, n))
+	}
+
+	processed := make(chan any)
+	err := b.SubscribeForTasks(mq.NodeQueue(nodes[1].ID), func(t *tork.Task) error {
+		close(processed)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	j1 := &tork.Job{
+		ID:   uuid.NewUUID(),
+		Name: "test job",
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	tk := &tork.Task{
+		ID:           uuid.NewUUID(),
+		JobID:        j1.ID,
+		Queue:        "gpu",
+		NodeSelector: map[string]string{"zone": "a"},
+	}
+
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+
+	err = s.scheduleRegularTask(ctx, tk)
+	assert.NoError(t, err)
+
+	<-processed
+}
+
+func Test_scheduleRegularTaskAffinityFallback(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	ds := inmemory.NewInMemoryDatastore()
+	s := NewScheduler(ds, b)
+
+	processed := make(chan any)
+	err := b.SubscribeForTasks(mq.QUEUE_DEFAULT, func(t *tork.Task) error {
+		close(processed)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	j1 := &tork.Job{
+		ID:   uuid.NewUUID(),
+		Name: "test job",
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	tk := &tork.Task{
+		ID:       uuid.NewUUID(),
+		JobID:    j1.ID,
+		Affinity: map[string]string{"zone": "a"},
+	}
+
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+
+	err = s.scheduleRegularTask(ctx, tk)
+	assert.NoError(t, err)
+
+	<-processed
+}
+
 func Test_scheduleParallelTask(t *testing.T) {
 	ctx := context.Background()
 	b := mq.NewInMemoryBroker()
@@ -0,0 +1,45 @@
+package cron
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	rcron "github.com/robfig/cron/v3"
+)
+
+// Schedule describes a job's duty cycle.
+type Schedule interface {
+	// Next returns the next activation time, later than the given time.
+	Next(time.Time) time.Time
+}
+
+// standard 5-field cron expressions as well as
+// descriptors such as @daily or @every 1h
+var parser = rcron.NewParser(
+	rcron.Minute | rcron.Hour | rcron.Dom | rcron.Month | rcron.Dow | rcron.Descriptor,
+)
+
+// Parse parses a cron expression into a Schedule.
+func Parse(spec string) (Schedule, error) {
+	s, err := parser.Parse(spec)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid cron expression: %s", spec)
+	}
+	return s, nil
+}
+
+// Valid returns true if the given spec is a valid cron expression.
+func Valid(spec string) bool {
+	_, err := Parse(spec)
+	return err == nil
+}
+
+// Next returns the next activation time of the
+// given cron expression which is after from.
+func Next(spec string, from time.Time) (time.Time, error) {
+	s, err := Parse(spec)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return s.Next(from), nil
+}
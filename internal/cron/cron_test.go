@@ -0,0 +1,34 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValid(t *testing.T) {
+	assert.True(t, Valid("* * * * *"))
+	assert.True(t, Valid("0 3 * * 1-5"))
+	assert.True(t, Valid("@daily"))
+	assert.True(t, Valid("@every 1h"))
+	assert.False(t, Valid(""))
+	assert.False(t, Valid("* * *"))
+	assert.False(t, Valid("not a cron"))
+	assert.False(t, Valid("61 * * * *"))
+}
+
+func TestNext(t *testing.T) {
+	from := time.Date(2024, 1, 1, 10, 15, 30, 0, time.UTC)
+
+	next, err := Next("* * * * *", from)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 1, 10, 16, 0, 0, time.UTC), next)
+
+	next, err = Next("0 3 * * *", from)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC), next)
+
+	_, err = Next("bad", from)
+	assert.Error(t, err)
+}
@@ -2,16 +2,28 @@ package eval
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"reflect"
 	"regexp"
+	"sort"
 
 	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/parser"
+	"github.com/expr-lang/expr/parser/utils"
 	"github.com/pkg/errors"
 	"github.com/runabol/tork"
 )
 
 var exprMatcher = regexp.MustCompile(`{{\s*(.+?)\s*}}`)
 
+// funcs are the functions available to expressions.
+var funcs = map[string]any{
+	"randomInt": randomInt,
+	"sequence":  sequence,
+}
+
 func EvaluateTask(t *tork.Task, c map[string]any) error {
 	// evaluate name
 	name, err := EvaluateTemplate(t.Name, c)
@@ -47,6 +59,18 @@ func EvaluateTask(t *tork.Task, c map[string]any) error {
 		env[k] = result
 	}
 	t.Env = env
+	// evaluate the artifact names
+	if t.Artifacts != nil {
+		for _, arts := range [][]tork.TaskArtifact{t.Artifacts.Outputs, t.Artifacts.Inputs} {
+			for i, a := range arts {
+				name, err := EvaluateTemplate(a.Name, c)
+				if err != nil {
+					return err
+				}
+				arts[i].Name = name
+			}
+		}
+	}
 	// evaluate if expr
 	ifExpr, err := EvaluateTemplate(t.If, c)
 	if err != nil {
@@ -159,6 +183,214 @@ func EvaluateTemplate(ex string, c map[string]any) (string, error) {
 	return buf.String(), nil
 }
 
+// EvaluatePartial evaluates only the expressions of the given template
+// which refer to one of the variables of the given context. All other
+// expressions are left as-is, to be evaluated at a later stage. So are
+// expressions which also refer to variables that are unknown at this
+// stage -- e.g. {{ with.x + inputs.y }} -- but with the variables of
+// the given context bound to their values. Lists and maps are rendered
+// as JSON.
+func EvaluatePartial(ex string, c map[string]any) (string, error) {
+	if ex == "" {
+		return "", nil
+	}
+	loc := 0
+	var buf bytes.Buffer
+	for _, match := range exprMatcher.FindAllStringSubmatchIndex(ex, -1) {
+		startTag := match[0]
+		endTag := match[1]
+		startExpr := match[2]
+		endExpr := match[3]
+		buf.WriteString(ex[loc:startTag])
+		loc = endTag
+		tree, err := parser.Parse(ex[startExpr:endExpr])
+		if err != nil {
+			buf.WriteString(ex[startTag:endTag])
+			continue
+		}
+		names := identifiers(tree)
+		if !refersTo(names, c) {
+			buf.WriteString(ex[startTag:endTag])
+			continue
+		}
+		if refersToUnknown(names, c) {
+			bound, err := bindVars(tree, c)
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(fmt.Sprintf("{{ %s }}", bound))
+			continue
+		}
+		ev, err := EvaluateExpr(ex[startExpr:endExpr], c)
+		if err != nil {
+			return "", err
+		}
+		fv, err := formatValue(ev)
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(fv)
+	}
+	buf.WriteString(ex[loc:])
+	return buf.String(), nil
+}
+
+type identCollector struct {
+	names map[string]bool
+}
+
+func (v *identCollector) Visit(node *ast.Node) {
+	if n, ok := (*node).(*ast.IdentifierNode); ok {
+		v.names[n.Value] = true
+	}
+}
+
+// identifiers returns the names of the
+// identifiers used by the given expression.
+func identifiers(tree *parser.Tree) map[string]bool {
+	v := &identCollector{names: make(map[string]bool)}
+	ast.Walk(&tree.Node, v)
+	return v.names
+}
+
+// refersTo returns true if the given identifiers
+// refer to any of the variables of the context.
+func refersTo(names map[string]bool, c map[string]any) bool {
+	for name := range c {
+		if names[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// refersToUnknown returns true if any of the given identifiers
+// is neither a variable of the context nor a function.
+func refersToUnknown(names map[string]bool, c map[string]any) bool {
+	for name := range names {
+		if _, ok := c[name]; ok {
+			continue
+		}
+		if _, ok := funcs[name]; ok {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// binder collects the variables of the context, along with
+// any member access on them, so they can be bound to their values.
+type binder struct {
+	c     map[string]any
+	slots map[ast.Node]*ast.Node
+}
+
+func (b *binder) Visit(node *ast.Node) {
+	switch n := (*node).(type) {
+	case *ast.IdentifierNode:
+		if _, ok := b.c[n.Value]; ok {
+			b.slots[n] = node
+		}
+	case *ast.MemberNode:
+		if _, ok := b.slots[n.Node]; !ok {
+			return
+		}
+		switch n.Property.(type) {
+		case *ast.StringNode, *ast.IntegerNode:
+			// bind the whole member access
+			// rather than the variable alone
+			delete(b.slots, n.Node)
+			b.slots[n] = node
+		}
+	}
+}
+
+// bindVars returns the given expression with the
+// variables of the context bound to their values.
+func bindVars(tree *parser.Tree, c map[string]any) (string, error) {
+	b := &binder{c: c, slots: make(map[ast.Node]*ast.Node)}
+	ast.Walk(&tree.Node, b)
+	for n, slot := range b.slots {
+		v, err := EvaluateExpr(n.String(), c)
+		if err != nil {
+			return "", err
+		}
+		lit, err := literal(v)
+		if err != nil {
+			return "", err
+		}
+		ast.Patch(slot, lit)
+	}
+	return tree.Node.String(), nil
+}
+
+// literal returns the expression literal of the given value.
+func literal(v any) (ast.Node, error) {
+	switch tv := v.(type) {
+	case nil:
+		return &ast.NilNode{}, nil
+	case bool:
+		return &ast.BoolNode{Value: tv}, nil
+	case int:
+		return &ast.IntegerNode{Value: tv}, nil
+	case float64:
+		return &ast.FloatNode{Value: tv}, nil
+	case string:
+		return &ast.StringNode{Value: tv}, nil
+	case []any:
+		nodes := make([]ast.Node, len(tv))
+		for i, item := range tv {
+			n, err := literal(item)
+			if err != nil {
+				return nil, err
+			}
+			nodes[i] = n
+		}
+		return &ast.ArrayNode{Nodes: nodes}, nil
+	case map[string]any:
+		keys := make([]string, 0, len(tv))
+		for k := range tv {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pairs := make([]ast.Node, len(keys))
+		for i, k := range keys {
+			n, err := literal(tv[k])
+			if err != nil {
+				return nil, err
+			}
+			var key ast.Node = &ast.StringNode{Value: k}
+			if !utils.IsValidIdentifier(k) {
+				// printed as a parenthesized key
+				key = &ast.ConstantNode{Value: k}
+			}
+			pairs[i] = &ast.PairNode{Key: key, Value: n}
+		}
+		return &ast.MapNode{Pairs: pairs}, nil
+	default:
+		return nil, errors.Errorf("can't bind a value of type %T", v)
+	}
+}
+
+// formatValue renders the value of an expression as text.
+// Lists and maps are rendered as JSON.
+func formatValue(v any) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	switch reflect.TypeOf(v).Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", errors.Wrapf(err, "error serializing value")
+		}
+		return string(b), nil
+	default:
+		return fmt.Sprintf("%v", v), nil
+	}
+}
+
 func ValidExpr(ex string) bool {
 	ex = sanitizeExpr(ex)
 	_, err := expr.Compile(ex)
@@ -174,9 +406,9 @@ func sanitizeExpr(ex string) string {
 
 func EvaluateExpr(ex string, c map[string]any) (any, error) {
 	ex = sanitizeExpr(ex)
-	env := map[string]any{
-		"randomInt": randomInt,
-		"sequence":  sequence,
+	env := make(map[string]any, len(funcs)+len(c))
+	for k, v := range funcs {
+		env[k] = v
 	}
 	for k, v := range c {
 		env[k] = v
@@ -59,6 +59,37 @@ func TestEvalMapVal(t *testing.T) {
 	assert.Equal(t, "someval", t1.Env["HELLO"])
 }
 
+func TestEvalTypedInputs(t *testing.T) {
+	c := tork.JobContext{
+		Inputs: map[string]string{
+			"replicas": "3",
+			"debug":    "true",
+			"targets":  "a,b,c",
+			"config":   `{"region":"us-east-1"}`,
+		},
+		InputTypes: map[string]tork.InputType{
+			"replicas": tork.InputTypeInt,
+			"debug":    tork.InputTypeBool,
+			"targets":  tork.InputTypeList,
+			"config":   tork.InputTypeJSON,
+		},
+	}
+	t1 := &tork.Task{
+		Env: map[string]string{
+			"REPLICAS": "{{ inputs.replicas * 2 }}",
+			"LEVEL":    "{{ inputs.debug ? 'debug' : 'info' }}",
+			"TARGETS":  "{{ len(inputs.targets) }}",
+			"REGION":   "{{ inputs.config.region }}",
+		},
+	}
+	err := eval.EvaluateTask(t1, c.AsMap())
+	assert.NoError(t, err)
+	assert.Equal(t, "6", t1.Env["REPLICAS"])
+	assert.Equal(t, "debug", t1.Env["LEVEL"])
+	assert.Equal(t, "3", t1.Env["TARGETS"])
+	assert.Equal(t, "us-east-1", t1.Env["REGION"])
+}
+
 func TestEvalName(t *testing.T) {
 	t1 := &tork.Task{
 		Name: "{{ inputs.SOMENAME }}y",
@@ -98,6 +129,26 @@ func TestEvalQueue(t *testing.T) {
 	assert.Equal(t, "default", t1.Queue)
 }
 
+func TestEvalArtifacts(t *testing.T) {
+	t1 := &tork.Task{
+		Artifacts: &tork.TaskArtifacts{
+			Outputs: []tork.TaskArtifact{{Name: "report-{{ item.index }}.txt", Path: "report.txt"}},
+			Inputs:  []tork.TaskArtifact{{Name: "{{ inputs.NAME }}", Path: "data.csv"}},
+		},
+	}
+	err := eval.EvaluateTask(t1, map[string]any{
+		"item": map[string]any{
+			"index": 3,
+		},
+		"inputs": map[string]string{
+			"NAME": "data.csv",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "report-3.txt", t1.Artifacts.Outputs[0].Name)
+	assert.Equal(t, "data.csv", t1.Artifacts.Inputs[0].Name)
+}
+
 func TestEvalFunc(t *testing.T) {
 	t1 := &tork.Task{
 		Env: map[string]string{
@@ -295,6 +346,72 @@ func TestEvalExpr(t *testing.T) {
 	assert.Equal(t, []string{"a", "b", "c"}, v)
 }
 
+func TestEvaluatePartial(t *testing.T) {
+	c := map[string]any{"with": map[string]any{"path": "./...", "strict": true}}
+	v, err := eval.EvaluatePartial("golangci-lint run {{ with.path }}", c)
+	assert.NoError(t, err)
+	assert.Equal(t, "golangci-lint run ./...", v)
+
+	v, err = eval.EvaluatePartial("{{ with.strict ? '--strict' : '' }} {{ inputs.branch }}", c)
+	assert.NoError(t, err)
+	assert.Equal(t, "--strict {{ inputs.branch }}", v)
+
+	v, err = eval.EvaluatePartial("{{ inputs.with }}", c)
+	assert.NoError(t, err)
+	assert.Equal(t, "{{ inputs.with }}", v)
+
+	_, err = eval.EvaluatePartial("{{ with.path + 1 }}", c)
+	assert.Error(t, err)
+
+	// expressions which also refer to unknown variables
+	// are left for later, with the known ones bound
+	v, err = eval.EvaluatePartial("{{ with.path + inputs.suffix }}", c)
+	assert.NoError(t, err)
+	assert.Equal(t, `{{ "./..." + inputs.suffix }}`, v)
+
+	v, err = eval.EvaluatePartial("{{ with.strict && inputs.strict }}", c)
+	assert.NoError(t, err)
+	assert.Equal(t, "{{ true && inputs.strict }}", v)
+}
+
+func TestEvaluatePartialNonScalar(t *testing.T) {
+	c := map[string]any{"with": map[string]any{
+		"args": []any{"-v", "./..."},
+		"opts": map[string]any{"race": true},
+		"none": nil,
+	}}
+	v, err := eval.EvaluatePartial("{{ with.args }}", c)
+	assert.NoError(t, err)
+	assert.Equal(t, `["-v","./..."]`, v)
+
+	v, err = eval.EvaluatePartial("{{ with.opts }}", c)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"race":true}`, v)
+
+	v, err = eval.EvaluatePartial("{{ with.none }}", c)
+	assert.NoError(t, err)
+	assert.Equal(t, "", v)
+
+	v, err = eval.EvaluatePartial("{{ with.args[0] }}", c)
+	assert.NoError(t, err)
+	assert.Equal(t, "-v", v)
+
+	v, err = eval.EvaluatePartial("{{ join(with.args, inputs.sep) }}", c)
+	assert.NoError(t, err)
+	assert.Equal(t, `{{ join(["-v", "./..."], inputs.sep) }}`, v)
+
+	// the bound expression can be evaluated later on
+	v, err = eval.EvaluateTemplate(v, map[string]any{"inputs": map[string]any{"sep": " "}})
+	assert.NoError(t, err)
+	assert.Equal(t, "-v ./...", v)
+
+	v, err = eval.EvaluatePartial("{{ with.opts[inputs.key] }}", map[string]any{"with": map[string]any{
+		"opts": map[string]any{"dry-run": nil, "race": true},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, `{{ {("dry-run"): nil, race: true}[inputs.key] }}`, v)
+}
+
 func TestValidExpr(t *testing.T) {
 	assert.True(t, eval.ValidExpr("{{1+1}}"))
 	assert.False(t, eval.ValidExpr("{1+1}}"))
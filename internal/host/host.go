@@ -3,6 +3,7 @@ package host
 import (
 	"github.com/rs/zerolog/log"
 	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/mem"
 )
 
 func GetCPUPercent() float64 {
@@ -15,3 +16,28 @@ func GetCPUPercent() float64 {
 	}
 	return perc[0]
 }
+
+// GetCPUCount returns the number of logical CPUs of the host.
+func GetCPUCount() int {
+	n, err := cpu.Counts(true)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msgf("error getting CPU count")
+		return 0
+	}
+	return n
+}
+
+// GetTotalMemory returns the total amount
+// of memory of the host, in bytes.
+func GetTotalMemory() int64 {
+	vm, err := mem.VirtualMemory()
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msgf("error getting total memory")
+		return 0
+	}
+	return int64(vm.Total)
+}
@@ -10,3 +10,8 @@ func TestGetStats(t *testing.T) {
 	cpuPercent := GetCPUPercent()
 	assert.GreaterOrEqual(t, cpuPercent, float64(0))
 }
+
+func TestGetResources(t *testing.T) {
+	assert.Greater(t, GetCPUCount(), 0)
+	assert.Greater(t, GetTotalMemory(), int64(0))
+}
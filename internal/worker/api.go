@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strings"
 	"syscall"
+	"time"
 
 	"net/http"
 	"net/http/httputil"
@@ -31,6 +32,7 @@ type api struct {
 	runtime runtime.Runtime
 	tasks   *syncx.Map[string, runningTask]
 	port    int
+	drain   func(timeout time.Duration)
 }
 
 func newAPI(cfg Config, tasks *syncx.Map[string, runningTask]) *api {
@@ -45,6 +47,7 @@ func newAPI(cfg Config, tasks *syncx.Map[string, runningTask]) *api {
 		},
 	}
 	r.GET("/health", s.health)
+	r.PUT("/drain", s.drainNode)
 	r.Any("/tasks/:id/:port", s.proxy)
 	r.Any("/tasks/:id/:port/*", s.proxy)
 	return s
@@ -62,6 +65,23 @@ func (s *api) health(c echo.Context) error {
 	}
 }
 
+// drainNode stops the worker from accepting new tasks and lets it
+// shut down once the tasks in flight are done. The optional timeout
+// query parameter is the duration after which the remaining tasks
+// are cancelled.
+func (s *api) drainNode(c echo.Context) error {
+	var timeout time.Duration
+	if v := c.QueryParam("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid timeout: %s", v))
+		}
+		timeout = d
+	}
+	s.drain(timeout)
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
 func (s *api) proxy(c echo.Context) error {
 	taskID := c.Param("id")
 	port := c.Param("port")
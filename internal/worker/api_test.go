@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/runabol/tork"
 	"github.com/runabol/tork/internal/syncx"
@@ -109,3 +110,28 @@ func Test_proxyTaskSomePath(t *testing.T) {
 	api.server.Handler.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusOK, w.Code)
 }
+
+func Test_drainNode(t *testing.T) {
+	rt, err := docker.NewDockerRuntime()
+	assert.NoError(t, err)
+	api := newAPI(Config{
+		Broker:  mq.NewInMemoryBroker(),
+		Runtime: rt,
+	}, &syncx.Map[string, runningTask]{})
+	var timeout time.Duration
+	api.drain = func(d time.Duration) {
+		timeout = d
+	}
+	req, err := http.NewRequest("PUT", "/drain?timeout=1m", nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, time.Minute, timeout)
+
+	req, err = http.NewRequest("PUT", "/drain?timeout=abc", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
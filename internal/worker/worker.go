@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"sort"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/docker/go-units"
 	"github.com/rs/zerolog/log"
 
 	"github.com/pkg/errors"
@@ -38,6 +41,14 @@ type Worker struct {
 	middleware []task.MiddlewareFunc
 	usedPorts  map[int]struct{}
 	mu         sync.Mutex
+	capacity   resources
+	allocated  resources
+	resMu      sync.Mutex
+	labels     map[string]string
+	draining   bool
+	drainMu    sync.Mutex
+	inflight   sync.WaitGroup
+	drained    chan any
 }
 
 type Config struct {
@@ -47,15 +58,35 @@ type Config struct {
 	Runtime    runtime.Runtime
 	Queues     map[string]int
 	Limits     Limits
+	Resources  Resources
+	Labels     map[string]string
 	Middleware []task.MiddlewareFunc
 }
 
+// Resources are the total resources which the worker makes
+// available to its tasks. Unspecified resources default to
+// the ones of the host.
+type Resources struct {
+	CPUs   string
+	Memory string
+}
+
 type Limits struct {
 	DefaultCPUsLimit   string
 	DefaultMemoryLimit string
 	DefaultTimeout     string
 }
 
+type resources struct {
+	cpus   float64
+	memory int64
+}
+
+// requeueDelay is how long the broker holds off the redelivery
+// of a task which the worker put back on its queue, to avoid
+// picking it up again right away.
+var requeueDelay = time.Second
+
 type runningTask struct {
 	cancel context.CancelFunc
 	task   *tork.Task
@@ -71,6 +102,10 @@ func NewWorker(cfg Config) (*Worker, error) {
 	if cfg.Runtime == nil {
 		return nil, errors.New("must provide runtime")
 	}
+	capacity, err := parseResources(cfg.Resources)
+	if err != nil {
+		return nil, err
+	}
 	tasks := new(syncx.Map[string, runningTask])
 	w := &Worker{
 		id:         uuid.NewShortUUID(),
@@ -85,10 +120,76 @@ func NewWorker(cfg Config) (*Worker, error) {
 		stop:       make(chan any),
 		middleware: cfg.Middleware,
 		usedPorts:  make(map[int]struct{}),
+		capacity:   capacity,
+		labels:     cfg.Labels,
+		drained:    make(chan any),
 	}
+	w.api.drain = w.Drain
 	return w, nil
 }
 
+func parseResources(r Resources) (resources, error) {
+	res := resources{
+		cpus:   float64(host.GetCPUCount()),
+		memory: host.GetTotalMemory(),
+	}
+	if r.CPUs != "" {
+		cpus, err := strconv.ParseFloat(r.CPUs, 64)
+		if err != nil || cpus <= 0 {
+			return resources{}, errors.Errorf("invalid cpus resource: %s", r.CPUs)
+		}
+		res.cpus = cpus
+	}
+	if r.Memory != "" {
+		mem, err := units.RAMInBytes(r.Memory)
+		if err != nil || mem <= 0 {
+			return resources{}, errors.Errorf("invalid memory resource: %s", r.Memory)
+		}
+		res.memory = mem
+	}
+	return res, nil
+}
+
+// taskResources returns the resources required by a task
+// with the given limits. Limits which can't be parsed are
+// left for the runtime to report.
+func taskResources(l *tork.TaskLimits) resources {
+	var res resources
+	if l == nil {
+		return res
+	}
+	if cpus, err := strconv.ParseFloat(l.CPUs, 64); err == nil {
+		res.cpus = cpus
+	}
+	if mem, err := units.RAMInBytes(l.Memory); err == nil {
+		res.memory = mem
+	}
+	return res
+}
+
+// reserve allocates the given resources if they
+// fit the remaining capacity of the worker.
+func (w *Worker) reserve(r resources) bool {
+	w.resMu.Lock()
+	defer w.resMu.Unlock()
+	if w.capacity.cpus > 0 && r.cpus > 0 && w.allocated.cpus+r.cpus > w.capacity.cpus {
+		return false
+	}
+	if w.capacity.memory > 0 && r.memory > 0 && w.allocated.memory+r.memory > w.capacity.memory {
+		return false
+	}
+	w.allocated.cpus += r.cpus
+	w.allocated.memory += r.memory
+	return true
+}
+
+func (w *Worker) release(r resources) {
+	w.resMu.Lock()
+	defer w.resMu.Unlock()
+	w.allocated.cpus -= r.cpus
+	w.allocated.memory -= r.memory
+}
+
 func (w *Worker) cancelTask(t *tork.Task) error {
 	rt, ok := w.tasks.Get(t.ID)
 	if !ok {
@@ -101,25 +202,62 @@ func (w *Worker) cancelTask(t *tork.Task) error {
 	return nil
 }
 
+// handleNodeTask handles the tasks dispatched to the node's own
+// queue. While draining, these are handed back to their regular
+// queue since no other node consumes from the node's queue.
+func (w *Worker) handleNodeTask(t *tork.Task) error {
+	if w.isDraining() {
+		log.Debug().
+			Str("task-id", t.ID).
+			Msgf("worker is draining. returning task to the %s queue", t.Queue)
+		t.NodeID = ""
+		return w.broker.PublishTask(context.Background(), t.Queue, t)
+	}
+	return w.handleTask(t)
+}
+
 func (w *Worker) handleTask(t *tork.Task) error {
+	// leave the task on the queue for other nodes should
+	// it arrive while the worker is unsubscribing to drain
+	if !w.acquire() {
+		return mq.ErrRequeue
+	}
+	defer w.inflight.Done()
 	ctx := context.Background()
-	started := time.Now().UTC()
-	t.StartedAt = &started
-	t.NodeID = w.id
-	t.State = tork.TaskStateRunning
-	// prepare limits
-	if t.Limits == nil && (w.limits.DefaultCPUsLimit != "" || w.limits.DefaultMemoryLimit != "") {
-		t.Limits = &tork.TaskLimits{}
+	// prepare limits. the defaults are applied to a copy so
+	// that a requeued task doesn't carry this worker's
+	// defaults over to the next worker
+	var limits *tork.TaskLimits
+	if t.Limits != nil {
+		limits = t.Limits.Clone()
+	} else if w.limits.DefaultCPUsLimit != "" || w.limits.DefaultMemoryLimit != "" {
+		limits = &tork.TaskLimits{}
 	}
-	if t.Limits != nil && t.Limits.CPUs == "" {
-		t.Limits.CPUs = w.limits.DefaultCPUsLimit
+	if limits != nil && limits.CPUs == "" {
+		limits.CPUs = w.limits.DefaultCPUsLimit
 	}
-	if t.Limits != nil && t.Limits.Memory == "" {
-		t.Limits.Memory = w.limits.DefaultMemoryLimit
+	if limits != nil && limits.Memory == "" {
+		limits.Memory = w.limits.DefaultMemoryLimit
 	}
+	required := taskResources(limits)
+	// leave the task on the queue if it doesn't fit the
+	// remaining resources. tasks which can't fit on any
+	// node are failed by the coordinator when scheduled
+	if !w.reserve(required) {
+		log.Debug().
+			Str("task-id", t.ID).
+			Msg("insufficient resources to run task. requeueing")
+		return mq.RequeueAfter(requeueDelay)
+	}
+	defer w.release(required)
+	t.Limits = limits
 	if t.Timeout == "" {
 		t.Timeout = w.limits.DefaultTimeout
 	}
+	started := time.Now().UTC()
+	t.StartedAt = &started
+	t.NodeID = w.id
+	t.State = tork.TaskStateRunning
 	// assign host ports
 	for _, p := range t.Ports {
 		hostPort, err := w.reservePort()
@@ -223,45 +361,148 @@ func (w *Worker) doRunTask(ctx context.Context, t *tork.Task) error {
 
 func (w *Worker) sendHeartbeats() {
 	for {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-		defer cancel()
-		status := tork.NodeStatusUP
-		if err := w.runtime.HealthCheck(ctx); err != nil {
-			log.Error().Err(err).Msgf("node %s failed health check", w.id)
-			status = tork.NodeStatusDown
+		w.sendHeartbeat()
+		select {
+		case <-w.stop:
+			return
+		case <-time.After(tork.HEARTBEAT_RATE):
 		}
-		hostname, err := os.Hostname()
-		if err != nil {
-			log.Error().Err(err).Msgf("failed to get hostname for worker %s", w.id)
+	}
+}
+
+func (w *Worker) sendHeartbeat() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	status := tork.NodeStatusUP
+	if err := w.runtime.HealthCheck(ctx); err != nil {
+		log.Error().Err(err).Msgf("node %s failed health check", w.id)
+		status = tork.NodeStatusDown
+	}
+	if w.isDraining() {
+		status = tork.NodeStatusDraining
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to get hostname for worker %s", w.id)
+	}
+	cpuPercent := host.GetCPUPercent()
+	w.resMu.Lock()
+	allocated := w.allocated
+	w.resMu.Unlock()
+	err = w.broker.PublishHeartbeat(
+		ctx,
+		&tork.Node{
+			ID:              w.id,
+			Name:            w.name,
+			StartedAt:       w.startTime,
+			CPUPercent:      cpuPercent,
+			Queue:           fmt.Sprintf("%s%s", mq.QUEUE_EXCLUSIVE_PREFIX, w.id),
+			Status:          status,
+			LastHeartbeatAt: time.Now().UTC(),
+			Hostname:        hostname,
+			Port:            w.api.port,
+			TaskCount:       int(atomic.LoadInt32(&w.taskCount)),
+			Version:         tork.Version,
+			CPUs:            w.capacity.cpus,
+			Memory:          w.capacity.memory,
+			AllocatedCPUs:   allocated.cpus,
+			AllocatedMemory: allocated.memory,
+			Labels:          w.labels,
+			Queues:          w.taskQueues(),
+		},
+	)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("error publishing heartbeat for %s", w.id)
+	}
+}
+
+// taskQueues returns the names of the
+// task queues the worker consumes from.
+func (w *Worker) taskQueues() []string {
+	qnames := make([]string, 0, len(w.queues))
+	for qname := range w.queues {
+		if mq.IsWorkerQueue(qname) {
+			qnames = append(qnames, qname)
 		}
-		cpuPercent := host.GetCPUPercent()
-		err = w.broker.PublishHeartbeat(
-			context.Background(),
-			&tork.Node{
-				ID:              w.id,
-				Name:            w.name,
-				StartedAt:       w.startTime,
-				CPUPercent:      cpuPercent,
-				Queue:           fmt.Sprintf("%s%s", mq.QUEUE_EXCLUSIVE_PREFIX, w.id),
-				Status:          status,
-				LastHeartbeatAt: time.Now().UTC(),
-				Hostname:        hostname,
-				Port:            w.api.port,
-				TaskCount:       int(atomic.LoadInt32(&w.taskCount)),
-				Version:         tork.Version,
-			},
-		)
-		if err != nil {
+	}
+	sort.Strings(qnames)
+	return qnames
+}
+
+// acquire registers a task as in flight unless
+// the worker is draining, in which case it
+// returns false.
+func (w *Worker) acquire() bool {
+	w.drainMu.Lock()
+	defer w.drainMu.Unlock()
+	if w.draining {
+		return false
+	}
+	w.inflight.Add(1)
+	return true
+}
+
+func (w *Worker) isDraining() bool {
+	w.drainMu.Lock()
+	defer w.drainMu.Unlock()
+	return w.draining
+}
+
+// Drain stops the worker from consuming new tasks and waits,
+// in the background, for the tasks in flight to finish. If the
+// timeout is positive, the tasks still running once it elapses
+// are cancelled. The channel returned by Drained is closed once
+// the worker is drained.
+func (w *Worker) Drain(timeout time.Duration) {
+	w.drainMu.Lock()
+	if w.draining {
+		w.drainMu.Unlock()
+		return
+	}
+	w.draining = true
+	w.drainMu.Unlock()
+	log.Info().Msgf("draining worker %s", w.id)
+	// stop consuming from the shared queues. the node's own
+	// queue is still consumed, to hand its tasks back
+	for _, qname := range w.taskQueues() {
+		if err := w.broker.UnsubscribeForTasks(context.Background(), qname); err != nil {
 			log.Error().
 				Err(err).
-				Msgf("error publishing heartbeat for %s", w.id)
+				Msgf("error unsubscribing from queue %s", qname)
+		}
+	}
+	// let the coordinator know right away
+	go w.sendHeartbeat()
+	go func() {
+		done := make(chan any)
+		go func() {
+			w.inflight.Wait()
+			close(done)
+		}()
+		var deadline <-chan time.Time
+		if timeout > 0 {
+			deadline = time.After(timeout)
 		}
 		select {
-		case <-w.stop:
-			return
-		case <-time.After(tork.HEARTBEAT_RATE):
+		case <-done:
+		case <-deadline:
+			log.Warn().Msgf("drain timeout of %s exceeded for worker %s. cancelling tasks", timeout, w.id)
+			w.tasks.Iterate(func(_ string, rt runningTask) {
+				rt.cancel()
+			})
+			<-done
 		}
-	}
+		log.Info().Msgf("worker %s drained", w.id)
+		close(w.drained)
+	}()
+}
+
+// Drained returns a channel which is
+// closed once the worker is drained.
+func (w *Worker) Drained() <-chan any {
+	return w.drained
 }
 
 func (w *Worker) Start() error {
@@ -274,16 +515,25 @@ func (w *Worker) Start() error {
 		return errors.Wrapf(err, "error subscribing for queue: %s", w.id)
 	}
 	// subscribe to shared work queues
-	for qname, concurrency := range w.queues {
+	concurrency := 0
+	for qname, c := range w.queues {
 		if !mq.IsWorkerQueue(qname) {
 			continue
 		}
-		for i := 0; i < concurrency; i++ {
+		for i := 0; i < c; i++ {
 			err := w.broker.SubscribeForTasks(qname, w.handleTask)
 			if err != nil {
 				return errors.Wrapf(err, "error subscribing for queue: %s", qname)
 			}
 		}
+		concurrency = concurrency + c
+	}
+	// subscribe for the queue through which the coordinator
+	// dispatches tasks with placement constraints to the node
+	for i := 0; i < max(concurrency, 1); i++ {
+		if err := w.broker.SubscribeForTasks(mq.NodeQueue(w.id), w.handleNodeTask); err != nil {
+			return errors.Wrapf(err, "error subscribing for queue: %s", mq.NodeQueue(w.id))
+		}
 	}
 	go w.sendHeartbeats()
 	return nil
@@ -414,6 +414,8 @@ func Test_sendHeartbeat(t *testing.T) {
 	heartbeats := make(chan any)
 	err = b.SubscribeForHeartbeats(func(n *tork.Node) error {
 		assert.Contains(t, n.Version, tork.Version)
+		assert.Equal(t, map[string]string{"zone": "a"}, n.Labels)
+		assert.Equal(t, []string{"default", "gpu"}, n.Queues)
 		heartbeats <- 1
 		return nil
 	})
@@ -422,6 +424,8 @@ func Test_sendHeartbeat(t *testing.T) {
 	w, err := NewWorker(Config{
 		Broker:  b,
 		Runtime: rt,
+		Labels:  map[string]string{"zone": "a"},
+		Queues:  map[string]int{"gpu": 1, mq.QUEUE_DEFAULT: 2},
 	})
 	assert.NoError(t, err)
 	assert.NotNil(t, w)
@@ -565,3 +569,204 @@ func Test_reservePort(t *testing.T) {
 	w.releasePort(port)
 	assert.NotContains(t, w.usedPorts, port)
 }
+
+func Test_reserveResources(t *testing.T) {
+	rt, err := docker.NewDockerRuntime()
+	assert.NoError(t, err)
+	w, err := NewWorker(Config{
+		Broker:  mq.NewInMemoryBroker(),
+		Runtime: rt,
+		Resources: Resources{
+			CPUs:   "4",
+			Memory: "1g",
+		},
+	})
+	assert.NoError(t, err)
+
+	r1 := taskResources(&tork.TaskLimits{CPUs: "3", Memory: "512m"})
+	assert.True(t, w.reserve(r1))
+	// not enough CPUs left
+	assert.False(t, w.reserve(taskResources(&tork.TaskLimits{CPUs: "1.5"})))
+	// not enough memory left
+	assert.False(t, w.reserve(taskResources(&tork.TaskLimits{Memory: "600m"})))
+	// tasks without limits always fit
+	assert.True(t, w.reserve(taskResources(nil)))
+	r2 := taskResources(&tork.TaskLimits{CPUs: "1", Memory: "512m"})
+	assert.True(t, w.reserve(r2))
+
+	w.release(r1)
+	assert.True(t, w.reserve(taskResources(&tork.TaskLimits{CPUs: "1.5"})))
+
+	_, err = NewWorker(Config{
+		Broker:  mq.NewInMemoryBroker(),
+		Runtime: rt,
+		Resources: Resources{
+			CPUs: "many",
+		},
+	})
+	assert.Error(t, err)
+}
+
+func Test_handleTaskExceedsCapacity(t *testing.T) {
+	rt, err := docker.NewDockerRuntime()
+	assert.NoError(t, err)
+	w, err := NewWorker(Config{
+		Broker:  mq.NewInMemoryBroker(),
+		Runtime: rt,
+		Resources: Resources{
+			CPUs: "2",
+		},
+	})
+	assert.NoError(t, err)
+
+	// a task which doesn't fit this worker is
+	// left on the queue for a larger one
+	t1 := &tork.Task{
+		ID:     uuid.NewUUID(),
+		State:  tork.TaskStateScheduled,
+		Image:  "ubuntu:mantic",
+		Limits: &tork.TaskLimits{CPUs: "8"},
+	}
+	err = w.handleTask(t1)
+	assert.ErrorIs(t, err, mq.ErrRequeue)
+	assert.Equal(t, tork.TaskStateScheduled, t1.State)
+	assert.Empty(t, t1.Error)
+}
+
+func Test_handleTaskInsufficientResources(t *testing.T) {
+	rt, err := docker.NewDockerRuntime()
+	assert.NoError(t, err)
+	w, err := NewWorker(Config{
+		Broker:  mq.NewInMemoryBroker(),
+		Runtime: rt,
+		Resources: Resources{
+			CPUs: "2",
+		},
+		Limits: Limits{
+			DefaultMemoryLimit: "10m",
+		},
+	})
+	assert.NoError(t, err)
+
+	// simulate a task which is already running
+	assert.True(t, w.reserve(resources{cpus: 1.5}))
+
+	t1 := &tork.Task{
+		ID:     uuid.NewUUID(),
+		State:  tork.TaskStateScheduled,
+		Image:  "ubuntu:mantic",
+		Limits: &tork.TaskLimits{CPUs: "1"},
+	}
+	err = w.handleTask(t1)
+	assert.ErrorIs(t, err, mq.ErrRequeue)
+	assert.Equal(t, tork.TaskStateScheduled, t1.State)
+	assert.Empty(t, t1.NodeID)
+	// the worker's defaults aren't applied to the requeued task
+	assert.Empty(t, t1.Limits.Memory)
+}
+
+func Test_drain(t *testing.T) {
+	rt, err := docker.NewDockerRuntime()
+	assert.NoError(t, err)
+	w, err := NewWorker(Config{
+		Broker:  mq.NewInMemoryBroker(),
+		Runtime: rt,
+	})
+	assert.NoError(t, err)
+
+	// simulate a task in flight
+	assert.True(t, w.acquire())
+
+	w.Drain(0)
+	assert.True(t, w.isDraining())
+
+	t1 := &tork.Task{
+		ID:    uuid.NewUUID(),
+		State: tork.TaskStateScheduled,
+		Image: "ubuntu:mantic",
+	}
+	err = w.handleTask(t1)
+	assert.ErrorIs(t, err, mq.ErrRequeue)
+	assert.Equal(t, tork.TaskStateScheduled, t1.State)
+
+	select {
+	case <-w.Drained():
+		t.Fatal("worker drained while a task is in flight")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	w.inflight.Done()
+	<-w.Drained()
+}
+
+func Test_drainQueues(t *testing.T) {
+	ctx := context.Background()
+	rt, err := docker.NewDockerRuntime()
+	assert.NoError(t, err)
+	b := mq.NewInMemoryBroker()
+	w, err := NewWorker(Config{
+		Broker:  b,
+		Runtime: rt,
+	})
+	assert.NoError(t, err)
+
+	err = b.SubscribeForTasks(mq.QUEUE_DEFAULT, w.handleTask)
+	assert.NoError(t, err)
+	err = b.SubscribeForTasks(mq.NodeQueue(w.id), w.handleNodeTask)
+	assert.NoError(t, err)
+
+	w.Drain(0)
+	<-w.Drained()
+
+	// the worker stopped consuming from its shared queues
+	qis, err := b.Queues(ctx)
+	assert.NoError(t, err)
+	for _, qi := range qis {
+		if qi.Name == mq.QUEUE_DEFAULT {
+			assert.Equal(t, 0, qi.Subscribers)
+		}
+	}
+
+	returned := make(chan *tork.Task)
+	err = b.SubscribeForTasks(mq.QUEUE_DEFAULT, func(t *tork.Task) error {
+		returned <- t
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// a task dispatched to the node is handed back to its queue
+	t1 := &tork.Task{
+		ID:     uuid.NewUUID(),
+		State:  tork.TaskStateScheduled,
+		Image:  "ubuntu:mantic",
+		Queue:  mq.QUEUE_DEFAULT,
+		NodeID: w.id,
+	}
+	err = b.PublishTask(ctx, mq.NodeQueue(w.id), t1)
+	assert.NoError(t, err)
+
+	t2 := <-returned
+	assert.Equal(t, t1.ID, t2.ID)
+	assert.Equal(t, tork.TaskStateScheduled, t2.State)
+	assert.Empty(t, t2.NodeID)
+}
+
+func Test_drainTimeout(t *testing.T) {
+	rt, err := docker.NewDockerRuntime()
+	assert.NoError(t, err)
+	w, err := NewWorker(Config{
+		Broker:  mq.NewInMemoryBroker(),
+		Runtime: rt,
+	})
+	assert.NoError(t, err)
+
+	// simulate a task in flight which
+	// completes once cancelled
+	assert.True(t, w.acquire())
+	w.tasks.Set("1234", runningTask{
+		cancel: func() { w.inflight.Done() },
+	})
+
+	w.Drain(time.Millisecond * 10)
+	<-w.Drained()
+}
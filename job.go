@@ -16,56 +16,135 @@ const (
 	JobStateCompleted JobState = "COMPLETED"
 	JobStateFailed    JobState = "FAILED"
 	JobStateRestart   JobState = "RESTART"
+	JobStatePaused    JobState = "PAUSED"
+	JobStateResume    JobState = "RESUME"
+	JobStateRerun     JobState = "RERUN"
+)
+
+type ScheduledJobState string
+
+const (
+	ScheduledJobStateActive ScheduledJobState = "ACTIVE"
+	ScheduledJobStatePaused ScheduledJobState = "PAUSED"
 )
 
 type Job struct {
+	ID             string            `json:"id,omitempty"`
+	ParentID       string            `json:"parentId,omitempty"`
+	Name           string            `json:"name,omitempty"`
+	Description    string            `json:"description,omitempty"`
+	Tags           []string          `json:"tags,omitempty"`
+	State          JobState          `json:"state,omitempty"`
+	CreatedAt      time.Time         `json:"createdAt,omitempty"`
+	CreatedBy      *User             `json:"createdBy,omitempty"`
+	StartedAt      *time.Time        `json:"startedAt,omitempty"`
+	CompletedAt    *time.Time        `json:"completedAt,omitempty"`
+	FailedAt       *time.Time        `json:"failedAt,omitempty"`
+	PausedAt       *time.Time        `json:"pausedAt,omitempty"`
+	Tasks          []*Task           `json:"tasks"`
+	Execution      []*Task           `json:"execution"`
+	Position       int               `json:"position"`
+	Inputs         map[string]string `json:"inputs,omitempty"`
+	Context        JobContext        `json:"context,omitempty"`
+	TaskCount      int               `json:"taskCount,omitempty"`
+	Output         string            `json:"output,omitempty"`
+	Result         string            `json:"result,omitempty"`
+	Error          string            `json:"error,omitempty"`
+	Defaults       *JobDefaults      `json:"defaults,omitempty"`
+	Webhooks       []*Webhook        `json:"webhooks,omitempty"`
+	Permissions    []*Permission     `json:"permissions,omitempty"`
+	AutoDelete     *AutoDelete       `json:"autoDelete,omitempty"`
+	DeleteAt       *time.Time        `json:"deleteAt,omitempty"`
+	Secrets        map[string]string `json:"secrets,omitempty"`
+	Progress       float64           `json:"progress,omitempty"`
+	Schedule       *JobSchedule      `json:"schedule,omitempty"`
+	Concurrency    *JobConcurrency   `json:"concurrency,omitempty"`
+	Rerun          *JobRerun         `json:"rerun,omitempty"`
+	IdempotencyKey string            `json:"idempotencyKey,omitempty"`
+}
+
+type JobSummary struct {
 	ID          string            `json:"id,omitempty"`
+	CreatedBy   *User             `json:"createdBy,omitempty"`
 	ParentID    string            `json:"parentId,omitempty"`
+	Inputs      map[string]string `json:"inputs,omitempty"`
 	Name        string            `json:"name,omitempty"`
 	Description string            `json:"description,omitempty"`
 	Tags        []string          `json:"tags,omitempty"`
 	State       JobState          `json:"state,omitempty"`
 	CreatedAt   time.Time         `json:"createdAt,omitempty"`
-	CreatedBy   *User             `json:"createdBy,omitempty"`
 	StartedAt   *time.Time        `json:"startedAt,omitempty"`
 	CompletedAt *time.Time        `json:"completedAt,omitempty"`
 	FailedAt    *time.Time        `json:"failedAt,omitempty"`
-	Tasks       []*Task           `json:"tasks"`
-	Execution   []*Task           `json:"execution"`
+	PausedAt    *time.Time        `json:"pausedAt,omitempty"`
 	Position    int               `json:"position"`
-	Inputs      map[string]string `json:"inputs,omitempty"`
-	Context     JobContext        `json:"context,omitempty"`
 	TaskCount   int               `json:"taskCount,omitempty"`
-	Output      string            `json:"output,omitempty"`
 	Result      string            `json:"result,omitempty"`
 	Error       string            `json:"error,omitempty"`
-	Defaults    *JobDefaults      `json:"defaults,omitempty"`
-	Webhooks    []*Webhook        `json:"webhooks,omitempty"`
-	Permissions []*Permission     `json:"permissions,omitempty"`
-	AutoDelete  *AutoDelete       `json:"autoDelete,omitempty"`
-	DeleteAt    *time.Time        `json:"deleteAt,omitempty"`
-	Secrets     map[string]string `json:"secrets,omitempty"`
 	Progress    float64           `json:"progress,omitempty"`
+	Schedule    *JobSchedule      `json:"schedule,omitempty"`
 }
 
-type JobSummary struct {
+// JobSchedule points a job back to the
+// scheduled job which it was created from.
+type JobSchedule struct {
+	ID   string `json:"id,omitempty"`
+	Cron string `json:"cron,omitempty"`
+}
+
+const (
+	ConcurrencyQueue          = "queue"
+	ConcurrencyCancelPrevious = "cancel-previous"
+	ConcurrencyReject         = "reject"
+)
+
+// JobConcurrency caps the number of jobs sharing
+// the same concurrency key which may run at once.
+type JobConcurrency struct {
+	Key        string `json:"key,omitempty"`
+	Limit      int    `json:"limit,omitempty"`
+	OnConflict string `json:"onConflict,omitempty"`
+}
+
+// ScheduledJob is a job template which the coordinator
+// materializes into a new Job on every tick of its cron
+// expression.
+type ScheduledJob struct {
+	ID          string               `json:"id,omitempty"`
+	Name        string               `json:"name,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Cron        string               `json:"cron,omitempty"`
+	State       ScheduledJobState    `json:"state,omitempty"`
+	CreatedAt   time.Time            `json:"createdAt,omitempty"`
+	CreatedBy   *User                `json:"createdBy,omitempty"`
+	LastRunAt   *time.Time           `json:"lastRunAt,omitempty"`
+	NextRunAt   *time.Time           `json:"nextRunAt,omitempty"`
+	Tasks       []*Task              `json:"tasks"`
+	Inputs      map[string]string    `json:"inputs,omitempty"`
+	InputTypes  map[string]InputType `json:"inputTypes,omitempty"`
+	InputSchema map[string]InputSpec `json:"inputSchema,omitempty"`
+	Secrets     map[string]string    `json:"secrets,omitempty"`
+	Output      string               `json:"output,omitempty"`
+	Defaults    *JobDefaults         `json:"defaults,omitempty"`
+	Webhooks    []*Webhook           `json:"webhooks,omitempty"`
+	Permissions []*Permission        `json:"permissions,omitempty"`
+	AutoDelete  *AutoDelete          `json:"autoDelete,omitempty"`
+	Concurrency *JobConcurrency      `json:"concurrency,omitempty"`
+}
+
+type ScheduledJobSummary struct {
 	ID          string            `json:"id,omitempty"`
-	CreatedBy   *User             `json:"createdBy,omitempty"`
-	ParentID    string            `json:"parentId,omitempty"`
-	Inputs      map[string]string `json:"inputs,omitempty"`
 	Name        string            `json:"name,omitempty"`
 	Description string            `json:"description,omitempty"`
 	Tags        []string          `json:"tags,omitempty"`
-	State       JobState          `json:"state,omitempty"`
+	Cron        string            `json:"cron,omitempty"`
+	State       ScheduledJobState `json:"state,omitempty"`
 	CreatedAt   time.Time         `json:"createdAt,omitempty"`
-	StartedAt   *time.Time        `json:"startedAt,omitempty"`
-	CompletedAt *time.Time        `json:"completedAt,omitempty"`
-	FailedAt    *time.Time        `json:"failedAt,omitempty"`
-	Position    int               `json:"position"`
-	TaskCount   int               `json:"taskCount,omitempty"`
-	Result      string            `json:"result,omitempty"`
-	Error       string            `json:"error,omitempty"`
-	Progress    float64           `json:"progress,omitempty"`
+	CreatedBy   *User             `json:"createdBy,omitempty"`
+	LastRunAt   *time.Time        `json:"lastRunAt,omitempty"`
+	NextRunAt   *time.Time        `json:"nextRunAt,omitempty"`
+	Inputs      map[string]string `json:"inputs,omitempty"`
 }
 
 type Permission struct {
@@ -78,10 +157,27 @@ type AutoDelete struct {
 }
 
 type JobContext struct {
-	Job     map[string]string `json:"job,omitempty"`
-	Inputs  map[string]string `json:"inputs,omitempty"`
-	Secrets map[string]string `json:"secrets,omitempty"`
-	Tasks   map[string]string `json:"tasks,omitempty"`
+	Job         map[string]string    `json:"job,omitempty"`
+	Inputs      map[string]string    `json:"inputs,omitempty"`
+	InputTypes  map[string]InputType `json:"inputTypes,omitempty"`
+	InputSchema map[string]InputSpec `json:"inputSchema,omitempty"`
+	Secrets     map[string]string    `json:"secrets,omitempty"`
+	Tasks       map[string]string    `json:"tasks,omitempty"`
+}
+
+// JobRerun holds the options of a request to run
+// a finished job again. It accompanies the job while
+// it's in the RERUN state, and while the re-run is held
+// PENDING by the job's concurrency key.
+type JobRerun struct {
+	// FromTask is the id of the task to re-run the job
+	// from. Defaults to the task at the job's position.
+	FromTask string `json:"fromTask,omitempty"`
+	// OnlyFailed re-runs only the failed children of
+	// a parallel or each task.
+	OnlyFailed bool `json:"onlyFailed,omitempty"`
+	// WithInputs overrides the inputs of the job.
+	WithInputs map[string]string `json:"withInputs,omitempty"`
 }
 
 type JobDefaults struct {
@@ -111,54 +207,91 @@ func (j *Job) Clone() *Job {
 	if j.AutoDelete != nil {
 		autoDelete = j.AutoDelete.Clone()
 	}
+	var schedule *JobSchedule
+	if j.Schedule != nil {
+		schedule = j.Schedule.Clone()
+	}
+	var concurrency *JobConcurrency
+	if j.Concurrency != nil {
+		concurrency = j.Concurrency.Clone()
+	}
+	var rerun *JobRerun
+	if j.Rerun != nil {
+		rerun = j.Rerun.Clone()
+	}
 	return &Job{
-		ID:          j.ID,
-		Name:        j.Name,
-		Description: j.Description,
-		Tags:        j.Tags,
-		State:       j.State,
-		CreatedAt:   j.CreatedAt,
-		CreatedBy:   createdBy,
-		StartedAt:   j.StartedAt,
-		CompletedAt: j.CompletedAt,
-		FailedAt:    j.FailedAt,
-		Tasks:       CloneTasks(j.Tasks),
-		Execution:   CloneTasks(j.Execution),
-		Position:    j.Position,
-		Inputs:      maps.Clone(j.Inputs),
-		Secrets:     maps.Clone(j.Secrets),
-		Context:     j.Context.Clone(),
-		ParentID:    j.ParentID,
-		TaskCount:   j.TaskCount,
-		Output:      j.Output,
-		Result:      j.Result,
-		Error:       j.Error,
-		Defaults:    defaults,
-		Webhooks:    CloneWebhooks(j.Webhooks),
-		Permissions: ClonePermissions(j.Permissions),
-		AutoDelete:  autoDelete,
-		Progress:    j.Progress,
+		ID:             j.ID,
+		Name:           j.Name,
+		Description:    j.Description,
+		Tags:           j.Tags,
+		State:          j.State,
+		CreatedAt:      j.CreatedAt,
+		CreatedBy:      createdBy,
+		StartedAt:      j.StartedAt,
+		CompletedAt:    j.CompletedAt,
+		FailedAt:       j.FailedAt,
+		PausedAt:       j.PausedAt,
+		Tasks:          CloneTasks(j.Tasks),
+		Execution:      CloneTasks(j.Execution),
+		Position:       j.Position,
+		Inputs:         maps.Clone(j.Inputs),
+		Secrets:        maps.Clone(j.Secrets),
+		Context:        j.Context.Clone(),
+		ParentID:       j.ParentID,
+		TaskCount:      j.TaskCount,
+		Output:         j.Output,
+		Result:         j.Result,
+		Error:          j.Error,
+		Defaults:       defaults,
+		Webhooks:       CloneWebhooks(j.Webhooks),
+		Permissions:    ClonePermissions(j.Permissions),
+		AutoDelete:     autoDelete,
+		Progress:       j.Progress,
+		Schedule:       schedule,
+		Concurrency:    concurrency,
+		Rerun:          rerun,
+		IdempotencyKey: j.IdempotencyKey,
 	}
 }
 
 func (c JobContext) Clone() JobContext {
 	return JobContext{
-		Inputs:  maps.Clone(c.Inputs),
-		Secrets: maps.Clone(c.Secrets),
-		Tasks:   maps.Clone(c.Tasks),
-		Job:     maps.Clone(c.Job),
+		Inputs:      maps.Clone(c.Inputs),
+		InputTypes:  maps.Clone(c.InputTypes),
+		InputSchema: maps.Clone(c.InputSchema),
+		Secrets:     maps.Clone(c.Secrets),
+		Tasks:       maps.Clone(c.Tasks),
+		Job:         maps.Clone(c.Job),
 	}
 }
 
 func (c JobContext) AsMap() map[string]any {
 	return map[string]any{
-		"inputs":  c.Inputs,
+		"inputs":  c.typedInputs(),
 		"secrets": c.Secrets,
 		"tasks":   c.Tasks,
 		"job":     c.Job,
 	}
 }
 
+// typedInputs converts the inputs to their declared types.
+// Values which can't be converted are kept as strings.
+func (c JobContext) typedInputs() any {
+	if len(c.InputTypes) == 0 {
+		return c.Inputs
+	}
+	inputs := make(map[string]any, len(c.Inputs))
+	for k, v := range c.Inputs {
+		tv, err := ParseInput(c.InputTypes[k], v)
+		if err != nil {
+			inputs[k] = v
+		} else {
+			inputs[k] = tv
+		}
+	}
+	return inputs
+}
+
 func (d *JobDefaults) Clone() *JobDefaults {
 	clone := JobDefaults{}
 	if d.Limits != nil {
@@ -187,11 +320,71 @@ func NewJobSummary(j *Job) *JobSummary {
 		StartedAt:   j.StartedAt,
 		CompletedAt: j.CompletedAt,
 		FailedAt:    j.FailedAt,
+		PausedAt:    j.PausedAt,
 		Position:    j.Position,
 		TaskCount:   j.TaskCount,
 		Result:      j.Result,
 		Error:       j.Error,
 		Progress:    j.Progress,
+		Schedule:    j.Schedule,
+	}
+}
+
+func (sj *ScheduledJob) Clone() *ScheduledJob {
+	var defaults *JobDefaults
+	if sj.Defaults != nil {
+		defaults = sj.Defaults.Clone()
+	}
+	var createdBy *User
+	if sj.CreatedBy != nil {
+		createdBy = sj.CreatedBy.Clone()
+	}
+	var autoDelete *AutoDelete
+	if sj.AutoDelete != nil {
+		autoDelete = sj.AutoDelete.Clone()
+	}
+	var concurrency *JobConcurrency
+	if sj.Concurrency != nil {
+		concurrency = sj.Concurrency.Clone()
+	}
+	return &ScheduledJob{
+		ID:          sj.ID,
+		Name:        sj.Name,
+		Description: sj.Description,
+		Tags:        sj.Tags,
+		Cron:        sj.Cron,
+		State:       sj.State,
+		CreatedAt:   sj.CreatedAt,
+		CreatedBy:   createdBy,
+		LastRunAt:   sj.LastRunAt,
+		NextRunAt:   sj.NextRunAt,
+		Tasks:       CloneTasks(sj.Tasks),
+		Inputs:      maps.Clone(sj.Inputs),
+		InputTypes:  maps.Clone(sj.InputTypes),
+		InputSchema: maps.Clone(sj.InputSchema),
+		Secrets:     maps.Clone(sj.Secrets),
+		Output:      sj.Output,
+		Defaults:    defaults,
+		Webhooks:    CloneWebhooks(sj.Webhooks),
+		Permissions: ClonePermissions(sj.Permissions),
+		AutoDelete:  autoDelete,
+		Concurrency: concurrency,
+	}
+}
+
+func NewScheduledJobSummary(sj *ScheduledJob) *ScheduledJobSummary {
+	return &ScheduledJobSummary{
+		ID:          sj.ID,
+		Name:        sj.Name,
+		Description: sj.Description,
+		Tags:        sj.Tags,
+		Cron:        sj.Cron,
+		State:       sj.State,
+		CreatedAt:   sj.CreatedAt,
+		CreatedBy:   sj.CreatedBy,
+		LastRunAt:   sj.LastRunAt,
+		NextRunAt:   sj.NextRunAt,
+		Inputs:      maps.Clone(sj.Inputs),
 	}
 }
 
@@ -234,3 +427,26 @@ func (a *AutoDelete) Clone() *AutoDelete {
 		After: a.After,
 	}
 }
+
+func (s *JobSchedule) Clone() *JobSchedule {
+	return &JobSchedule{
+		ID:   s.ID,
+		Cron: s.Cron,
+	}
+}
+
+func (r *JobRerun) Clone() *JobRerun {
+	return &JobRerun{
+		FromTask:   r.FromTask,
+		OnlyFailed: r.OnlyFailed,
+		WithInputs: maps.Clone(r.WithInputs),
+	}
+}
+
+func (c *JobConcurrency) Clone() *JobConcurrency {
+	return &JobConcurrency{
+		Key:        c.Key,
+		Limit:      c.Limit,
+		OnConflict: c.OnConflict,
+	}
+}
@@ -0,0 +1,45 @@
+package leader
+
+import (
+	"context"
+	"time"
+)
+
+// InMemoryElector is the Elector of a single
+// coordinator, which is always the leader.
+type InMemoryElector struct {
+	id     string
+	duties *duties
+}
+
+func NewInMemoryElector(id string) *InMemoryElector {
+	return &InMemoryElector{
+		id:     id,
+		duties: newDuties(func() bool { return true }),
+	}
+}
+
+func (e *InMemoryElector) ID() string {
+	return e.id
+}
+
+func (e *InMemoryElector) IsLeader() bool {
+	return true
+}
+
+func (e *InMemoryElector) Leader(ctx context.Context) (string, error) {
+	return e.id, nil
+}
+
+func (e *InMemoryElector) Register(name string, interval time.Duration, d Duty) {
+	e.duties.register(name, interval, d)
+}
+
+func (e *InMemoryElector) Start() error {
+	return nil
+}
+
+func (e *InMemoryElector) Stop() error {
+	e.duties.stop()
+	return nil
+}
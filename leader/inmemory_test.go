@@ -0,0 +1,39 @@
+package leader
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInMemoryElector(t *testing.T) {
+	ctx := context.Background()
+	e := NewInMemoryElector("some-id")
+	assert.NoError(t, e.Start())
+	assert.Equal(t, "some-id", e.ID())
+	assert.True(t, e.IsLeader())
+	id, err := e.Leader(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "some-id", id)
+	assert.NoError(t, e.Stop())
+}
+
+func TestInMemoryElectorRegister(t *testing.T) {
+	e := NewInMemoryElector("some-id")
+	assert.NoError(t, e.Start())
+	runs := atomic.Int32{}
+	e.Register("some-duty", time.Millisecond*10, func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	})
+	assert.Eventually(t, func() bool {
+		return runs.Load() >= 2
+	}, time.Second, time.Millisecond*10)
+	assert.NoError(t, e.Stop())
+	n := runs.Load()
+	time.Sleep(time.Millisecond * 50)
+	assert.LessOrEqual(t, runs.Load(), n+1)
+}
@@ -0,0 +1,81 @@
+package leader
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	ELECTOR_INMEMORY = "inmemory"
+	ELECTOR_POSTGRES = "postgres"
+)
+
+// Duty is a unit of background work -- e.g. expunging
+// expired data or triggering scheduled jobs -- which must
+// only be performed by a single coordinator at a time.
+type Duty func(ctx context.Context) error
+
+// Elector elects a single leader out of the coordinators
+// of a cluster and performs the registered duties on the
+// leader only.
+type Elector interface {
+	// ID returns the ID of the candidate.
+	ID() string
+	// IsLeader returns true if the candidate
+	// currently holds the leadership.
+	IsLeader() bool
+	// Leader returns the ID of the current leader or
+	// an empty string if there's no leader at the moment.
+	Leader(ctx context.Context) (string, error)
+	// Register periodically performs the duty for as
+	// long as the candidate holds the leadership.
+	Register(name string, interval time.Duration, d Duty)
+	Start() error
+	Stop() error
+}
+
+// duties performs the duties registered with an
+// elector whenever the elector is the leader.
+type duties struct {
+	isLeader func() bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+	once     sync.Once
+}
+
+func newDuties(isLeader func() bool) *duties {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &duties{
+		isLeader: isLeader,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+func (d *duties) register(name string, interval time.Duration, duty Duty) {
+	go func() {
+		for {
+			select {
+			case <-d.ctx.Done():
+				return
+			case <-time.After(interval):
+				if !d.isLeader() {
+					continue
+				}
+				if err := duty(d.ctx); err != nil {
+					log.Error().
+						Err(err).
+						Str("duty", name).
+						Msg("error performing leader duty")
+				}
+			}
+		}
+	}()
+}
+
+func (d *duties) stop() {
+	d.once.Do(d.cancel)
+}
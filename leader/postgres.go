@@ -0,0 +1,224 @@
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	POSTGRES_DEFAULT_ELECTION_INTERVAL = time.Second * 5
+)
+
+const (
+	pgLeaderName = "coordinator"
+	pgLockPrefix = "tork_leader"
+)
+
+// PostgresElector is an implementation of the Elector interface
+// backed by a Postgres session-level advisory lock. The lock is
+// held for as long as the session of the leader is alive, so the
+// leadership is released as soon as the leader's connection is
+// gone. Since a leader only notices that its session is gone on
+// its next renewal, duties may overlap for up to one election
+// interval and should remain safe to run concurrently.
+type PostgresElector struct {
+	id       string
+	db       *sqlx.DB
+	conn     *sql.Conn
+	key      int64
+	leader   atomic.Bool
+	interval time.Duration
+	duties   *duties
+	stop     chan any
+	done     chan any
+}
+
+type PostgresOption = func(e *PostgresElector)
+
+// WithPostgresElectionInterval sets how often a candidate
+// attempts to acquire the leadership and how often the
+// leader renews it.
+func WithPostgresElectionInterval(dur time.Duration) PostgresOption {
+	return func(e *PostgresElector) {
+		e.interval = dur
+	}
+}
+
+func NewPostgresElector(dsn, id string, opts ...PostgresOption) (*PostgresElector, error) {
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to connect to postgres")
+	}
+	e := &PostgresElector{
+		id:       id,
+		db:       db,
+		interval: POSTGRES_DEFAULT_ELECTION_INTERVAL,
+		stop:     make(chan any),
+		done:     make(chan any),
+	}
+	e.duties = newDuties(e.IsLeader)
+	for _, o := range opts {
+		o(e)
+	}
+	if e.interval < time.Second {
+		db.Close()
+		return nil, errors.Errorf("election interval can not be under 1 second")
+	}
+	// advisory locks are shared by the whole database
+	// so the lock is scoped to the schema in use
+	var schema string
+	if err := db.Get(&schema, "SELECT current_schema()"); err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "unable to get the current schema")
+	}
+	h := fnv.New64a()
+	h.Write([]byte(fmt.Sprintf("%s_%s", pgLockPrefix, schema)))
+	e.key = int64(h.Sum64())
+	return e, nil
+}
+
+func (e *PostgresElector) ID() string {
+	return e.id
+}
+
+func (e *PostgresElector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+func (e *PostgresElector) Leader(ctx context.Context) (string, error) {
+	if e.IsLeader() {
+		return e.id, nil
+	}
+	var id string
+	q := `SELECT leader_id FROM leaders WHERE name = $1 AND renewed_at > $2`
+	// a leader which didn't renew its leadership
+	// in a while is considered gone
+	renewedAfter := time.Now().UTC().Add(-e.interval * 3)
+	if err := e.db.GetContext(ctx, &id, q, pgLeaderName, renewedAfter); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", errors.Wrapf(err, "error getting the current leader")
+	}
+	return id, nil
+}
+
+func (e *PostgresElector) Register(name string, interval time.Duration, d Duty) {
+	e.duties.register(name, interval, d)
+}
+
+func (e *PostgresElector) Start() error {
+	go e.campaign()
+	return nil
+}
+
+func (e *PostgresElector) campaign() {
+	defer close(e.done)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+		e.elect(ctx)
+		cancel()
+		select {
+		case <-e.stop:
+			return
+		case <-time.After(e.interval):
+		}
+	}
+}
+
+// elect attempts to acquire the leadership or --
+// if the candidate is already the leader -- renew it.
+func (e *PostgresElector) elect(ctx context.Context) {
+	if e.conn == nil {
+		conn, err := e.db.Conn(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("error connecting to postgres for leader election")
+			return
+		}
+		e.conn = conn
+	}
+	if e.IsLeader() {
+		if err := e.renew(ctx); err != nil {
+			log.Error().Err(err).Msgf("coordinator %s lost the leadership", e.id)
+			e.resign()
+		}
+		return
+	}
+	var acquired bool
+	if err := e.conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", e.key).Scan(&acquired); err != nil {
+		log.Error().Err(err).Msg("error acquiring the leader lock")
+		e.resign()
+		return
+	}
+	if !acquired {
+		return
+	}
+	if err := e.renew(ctx); err != nil {
+		log.Error().Err(err).Msg("error recording the leader")
+		e.resign()
+		return
+	}
+	e.leader.Store(true)
+	log.Info().Msgf("coordinator %s was elected leader", e.id)
+}
+
+// renew records the candidate as the current leader. Since
+// it's done using the session which holds the advisory lock,
+// it fails if the lock was lost along with the session.
+func (e *PostgresElector) renew(ctx context.Context) error {
+	q := `INSERT INTO leaders (name, leader_id, renewed_at) VALUES ($1, $2, $3)
+	      ON CONFLICT (name) DO UPDATE SET leader_id = $2, renewed_at = $3`
+	if _, err := e.conn.ExecContext(ctx, q, pgLeaderName, e.id, time.Now().UTC()); err != nil {
+		return errors.Wrapf(err, "error renewing the leadership")
+	}
+	return nil
+}
+
+// resign gives up the leadership by closing the
+// session, which releases the advisory lock.
+func (e *PostgresElector) resign() {
+	e.leader.Store(false)
+	if e.conn == nil {
+		return
+	}
+	// returning the connection to the pool would keep
+	// the session -- and the lock -- alive so it's
+	// marked as bad in order for it to be discarded
+	_ = e.conn.Raw(func(any) error {
+		return driver.ErrBadConn
+	})
+	if err := e.conn.Close(); err != nil {
+		log.Debug().Err(err).Msg("error closing the leader election connection")
+	}
+	e.conn = nil
+}
+
+func (e *PostgresElector) Stop() error {
+	e.duties.stop()
+	close(e.stop)
+	<-e.done
+	if e.IsLeader() {
+		ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+		defer cancel()
+		// let the other candidates know right away
+		// that the leadership is up for grabs
+		if _, err := e.conn.ExecContext(ctx, `DELETE FROM leaders WHERE name = $1 AND leader_id = $2`, pgLeaderName, e.id); err != nil {
+			log.Error().Err(err).Msg("error removing the leader record")
+		}
+		if _, err := e.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", e.key); err != nil {
+			log.Error().Err(err).Msg("error releasing the leader lock")
+		}
+	}
+	e.resign()
+	return e.db.Close()
+}
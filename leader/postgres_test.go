@@ -0,0 +1,94 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	schema "github.com/runabol/tork/db/postgres"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPostgresTestDSN(t *testing.T) string {
+	schemaName := fmt.Sprintf("tork%d", rand.Int())
+	dsn := `host=localhost user=tork password=tork dbname=tork search_path=%s sslmode=disable`
+	db, err := sqlx.Connect("postgres", fmt.Sprintf(dsn, schemaName))
+	assert.NoError(t, err)
+	_, err = db.Exec(fmt.Sprintf("create schema %s", schemaName))
+	assert.NoError(t, err)
+	_, err = db.Exec(schema.SCHEMA)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_, err := db.Exec(fmt.Sprintf("drop schema %s cascade", schemaName))
+		assert.NoError(t, err)
+		assert.NoError(t, db.Close())
+	})
+	return fmt.Sprintf(dsn, schemaName)
+}
+
+func TestPostgresElector(t *testing.T) {
+	ctx := context.Background()
+	dsn := newPostgresTestDSN(t)
+
+	e1, err := NewPostgresElector(dsn, uuid.NewShortUUID(), WithPostgresElectionInterval(time.Second))
+	assert.NoError(t, err)
+	assert.NoError(t, e1.Start())
+
+	assert.Eventually(t, e1.IsLeader, time.Second*5, time.Millisecond*100)
+
+	e2, err := NewPostgresElector(dsn, uuid.NewShortUUID(), WithPostgresElectionInterval(time.Second))
+	assert.NoError(t, err)
+	assert.NoError(t, e2.Start())
+
+	time.Sleep(time.Second * 2)
+	assert.False(t, e2.IsLeader())
+
+	id, err := e2.Leader(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, e1.ID(), id)
+
+	// the leadership is handed over once the leader is gone
+	assert.NoError(t, e1.Stop())
+	assert.Eventually(t, e2.IsLeader, time.Second*5, time.Millisecond*100)
+
+	id, err = e2.Leader(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, e2.ID(), id)
+
+	assert.NoError(t, e2.Stop())
+}
+
+func TestPostgresElectorRegister(t *testing.T) {
+	dsn := newPostgresTestDSN(t)
+
+	runs1 := atomic.Int32{}
+	e1, err := NewPostgresElector(dsn, uuid.NewShortUUID(), WithPostgresElectionInterval(time.Second))
+	assert.NoError(t, err)
+	e1.Register("some-duty", time.Millisecond*50, func(ctx context.Context) error {
+		runs1.Add(1)
+		return nil
+	})
+	assert.NoError(t, e1.Start())
+	assert.Eventually(t, e1.IsLeader, time.Second*5, time.Millisecond*100)
+
+	runs2 := atomic.Int32{}
+	e2, err := NewPostgresElector(dsn, uuid.NewShortUUID(), WithPostgresElectionInterval(time.Second))
+	assert.NoError(t, err)
+	e2.Register("some-duty", time.Millisecond*50, func(ctx context.Context) error {
+		runs2.Add(1)
+		return nil
+	})
+	assert.NoError(t, e2.Start())
+
+	time.Sleep(time.Second * 2)
+	assert.Greater(t, runs1.Load(), int32(0))
+	assert.Equal(t, int32(0), runs2.Load())
+
+	assert.NoError(t, e1.Stop())
+	assert.NoError(t, e2.Stop())
+}
@@ -0,0 +1,44 @@
+package tork
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// LibraryTask is a named, versioned task definition which
+// can be referenced from the tasks of any job using
+// uses: name@version along with its with: parameters.
+type LibraryTask struct {
+	ID          string          `json:"id,omitempty"`
+	Name        string          `json:"name,omitempty"`
+	Version     int             `json:"version,omitempty"`
+	Description string          `json:"description,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt,omitempty"`
+	CreatedBy   *User           `json:"createdBy,omitempty"`
+	Params      json.RawMessage `json:"params,omitempty"`
+	Task        json.RawMessage `json:"task,omitempty"`
+}
+
+func (t *LibraryTask) Clone() *LibraryTask {
+	var createdBy *User
+	if t.CreatedBy != nil {
+		createdBy = t.CreatedBy.Clone()
+	}
+	return &LibraryTask{
+		ID:          t.ID,
+		Name:        t.Name,
+		Version:     t.Version,
+		Description: t.Description,
+		CreatedAt:   t.CreatedAt,
+		CreatedBy:   createdBy,
+		Params:      slices.Clone(t.Params),
+		Task:        slices.Clone(t.Task),
+	}
+}
+
+// Ref returns the reference to this version of the library task.
+func (t *LibraryTask) Ref() string {
+	return fmt.Sprintf("%s@%d", t.Name, t.Version)
+}
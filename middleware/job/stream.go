@@ -0,0 +1,57 @@
+package job
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/mq"
+)
+
+// Stream publishes the job's state changes and progress updates
+// to the broker so they can be streamed to the API's clients.
+func Stream(b mq.Broker) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, et EventType, j *tork.Job) error {
+			if err := next(ctx, et, j); err != nil {
+				return err
+			}
+			if et != StateChange && et != Progress {
+				return nil
+			}
+			// restart, rerun and resume are commands which are never
+			// persisted as the job's state so there's nothing to stream
+			if isCommandState(j.State) {
+				return nil
+			}
+			summary := tork.NewJobSummary(j)
+			update := &tork.Job{
+				ID:          summary.ID,
+				ParentID:    summary.ParentID,
+				Name:        summary.Name,
+				Description: summary.Description,
+				Tags:        summary.Tags,
+				State:       summary.State,
+				CreatedAt:   summary.CreatedAt,
+				StartedAt:   summary.StartedAt,
+				CompletedAt: summary.CompletedAt,
+				FailedAt:    summary.FailedAt,
+				Position:    summary.Position,
+				TaskCount:   summary.TaskCount,
+				Result:      summary.Result,
+				Error:       summary.Error,
+				Progress:    summary.Progress,
+			}
+			if err := b.PublishEvent(ctx, mq.TOPIC_UPDATES_JOB, update); err != nil {
+				log.Error().Err(err).Msgf("error publishing update for job %s", j.ID)
+			}
+			return nil
+		}
+	}
+}
+
+func isCommandState(s tork.JobState) bool {
+	return s == tork.JobStateRestart ||
+		s == tork.JobStateRerun ||
+		s == tork.JobStateResume
+}
@@ -0,0 +1,79 @@
+package job
+
+import (
+	"context"
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/mq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStream(t *testing.T) {
+	b := mq.NewInMemoryBroker()
+	received := make(chan *tork.Job, 1)
+	err := b.SubscribeForEvents(context.Background(), mq.TOPIC_UPDATES_JOB, func(ev any) {
+		j, ok := ev.(*tork.Job)
+		assert.True(t, ok)
+		received <- j
+	})
+	assert.NoError(t, err)
+
+	hm := ApplyMiddleware(NoOpHandlerFunc, []MiddlewareFunc{Stream(b)})
+
+	j := &tork.Job{
+		ID:      "1234",
+		State:   tork.JobStateRunning,
+		Secrets: map[string]string{"password": "secret"},
+	}
+	assert.NoError(t, hm(context.Background(), StateChange, j))
+
+	update := <-received
+	assert.Equal(t, "1234", update.ID)
+	assert.Equal(t, tork.JobStateRunning, update.State)
+	assert.Empty(t, update.Secrets)
+}
+
+func TestStreamIgnoreRead(t *testing.T) {
+	b := mq.NewInMemoryBroker()
+	received := make(chan *tork.Job, 1)
+	err := b.SubscribeForEvents(context.Background(), mq.TOPIC_UPDATES, func(ev any) {
+		received <- ev.(*tork.Job)
+	})
+	assert.NoError(t, err)
+
+	hm := ApplyMiddleware(NoOpHandlerFunc, []MiddlewareFunc{Stream(b)})
+
+	assert.NoError(t, hm(context.Background(), Read, &tork.Job{ID: "1234"}))
+	assert.Len(t, received, 0)
+}
+
+func TestStreamIgnoreCommandStates(t *testing.T) {
+	b := mq.NewInMemoryBroker()
+	received := make(chan *tork.Job, 3)
+	err := b.SubscribeForEvents(context.Background(), mq.TOPIC_UPDATES_JOB, func(ev any) {
+		j, ok := ev.(*tork.Job)
+		assert.True(t, ok)
+		received <- j
+	})
+	assert.NoError(t, err)
+
+	hm := ApplyMiddleware(NoOpHandlerFunc, []MiddlewareFunc{Stream(b)})
+
+	for _, state := range []tork.JobState{tork.JobStateRestart, tork.JobStateRerun, tork.JobStateResume} {
+		j := &tork.Job{
+			ID:    "1234",
+			State: state,
+		}
+		assert.NoError(t, hm(context.Background(), StateChange, j))
+	}
+
+	j := &tork.Job{
+		ID:    "1234",
+		State: tork.JobStateRunning,
+	}
+	assert.NoError(t, hm(context.Background(), StateChange, j))
+
+	update := <-received
+	assert.Equal(t, tork.JobStateRunning, update.State)
+}
@@ -0,0 +1,46 @@
+package task
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/mq"
+)
+
+// Stream publishes the task's state changes and progress updates
+// to the broker so they can be streamed to the API's clients.
+func Stream(b mq.Broker) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, et EventType, t *tork.Task) error {
+			if err := next(ctx, et, t); err != nil {
+				return err
+			}
+			if et != StateChange && et != Progress {
+				return nil
+			}
+			summary := tork.NewTaskSummary(t)
+			update := &tork.Task{
+				ID:          summary.ID,
+				JobID:       summary.JobID,
+				Position:    summary.Position,
+				Progress:    summary.Progress,
+				Name:        summary.Name,
+				Description: summary.Description,
+				State:       summary.State,
+				CreatedAt:   summary.CreatedAt,
+				ScheduledAt: summary.ScheduledAt,
+				StartedAt:   summary.StartedAt,
+				CompletedAt: summary.CompletedAt,
+				Error:       summary.Error,
+				Result:      summary.Result,
+				Var:         summary.Var,
+				Tags:        summary.Tags,
+			}
+			if err := b.PublishEvent(ctx, mq.TOPIC_UPDATES_TASK, update); err != nil {
+				log.Error().Err(err).Msgf("error publishing update for task %s", t.ID)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package task
+
+import (
+	"context"
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/mq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStream(t *testing.T) {
+	b := mq.NewInMemoryBroker()
+	received := make(chan *tork.Task, 1)
+	err := b.SubscribeForEvents(context.Background(), mq.TOPIC_UPDATES_TASK, func(ev any) {
+		t, ok := ev.(*tork.Task)
+		if ok {
+			received <- t
+		}
+	})
+	assert.NoError(t, err)
+
+	hm := ApplyMiddleware(NoOpHandlerFunc, []MiddlewareFunc{Stream(b)})
+
+	tk := &tork.Task{
+		ID:    "1234",
+		JobID: "5678",
+		State: tork.TaskStateRunning,
+		Env:   map[string]string{"PASSWORD": "secret"},
+		Run:   "echo hello",
+	}
+	assert.NoError(t, hm(context.Background(), StateChange, tk))
+
+	update := <-received
+	assert.Equal(t, "1234", update.ID)
+	assert.Equal(t, "5678", update.JobID)
+	assert.Equal(t, tork.TaskStateRunning, update.State)
+	assert.Empty(t, update.Env)
+	assert.Empty(t, update.Run)
+}
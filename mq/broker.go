@@ -2,7 +2,9 @@ package mq
 
 import (
 	"context"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/runabol/tork"
 )
 
@@ -11,15 +13,61 @@ type Provider func() (Broker, error)
 const (
 	BROKER_INMEMORY     = "inmemory"
 	BROKER_RABBITMQ     = "rabbitmq"
+	BROKER_NATS         = "nats"
+	BROKER_POSTGRES     = "postgres"
 	TOPIC_JOB           = "job.*"
 	TOPIC_JOB_COMPLETED = "job.completed"
 	TOPIC_JOB_FAILED    = "job.failed"
+	// topics used for streaming live updates
+	// to the API's clients
+	TOPIC_UPDATES          = "updates.*"
+	TOPIC_UPDATES_JOB      = "updates.job"
+	TOPIC_UPDATES_TASK     = "updates.task"
+	TOPIC_UPDATES_LOG_PART = "updates.logpart"
 )
 
+// ErrRequeue is returned by a message handler to leave the message
+// on its queue -- rather than dropping it -- so that it's delivered
+// again, possibly to another subscriber.
+var ErrRequeue = errors.New("requeue message")
+
+// RequeueAfter returns an error which, like ErrRequeue, leaves the
+// message on its queue but has the broker hold off its redelivery
+// for the given delay rather than delivering it again right away.
+func RequeueAfter(delay time.Duration) error {
+	return &requeueError{delay: delay}
+}
+
+type requeueError struct {
+	delay time.Duration
+}
+
+func (e *requeueError) Error() string {
+	return ErrRequeue.Error()
+}
+
+func (e *requeueError) Is(target error) bool {
+	return target == ErrRequeue
+}
+
+// requeueDelay returns how long the redelivery
+// of a requeued message should be held off.
+func requeueDelay(err error) time.Duration {
+	var re *requeueError
+	if errors.As(err, &re) {
+		return re.delay
+	}
+	return 0
+}
+
 // Broker is the message-queue, pub/sub mechanism used for delivering tasks.
 type Broker interface {
 	PublishTask(ctx context.Context, qname string, t *tork.Task) error
 	SubscribeForTasks(qname string, handler func(t *tork.Task) error) error
+	// UnsubscribeForTasks cancels the subscriptions made through the
+	// broker to the given queue. The messages still on the queue are
+	// left for other subscribers.
+	UnsubscribeForTasks(ctx context.Context, qname string) error
 
 	PublishTaskProgress(ctx context.Context, t *tork.Task) error
 	SubscribeForTaskProgress(handler func(t *tork.Task) error) error
@@ -3,6 +3,7 @@ package mq
 import (
 	"context"
 	"sync/atomic"
+	"time"
 
 	"sync"
 
@@ -115,6 +116,15 @@ func (q *queue) close() {
 	}
 }
 
+func (q *queue) unsubscribe() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, sub := range q.subs {
+		close(sub.terminate)
+	}
+	q.subs = make([]*qsub, 0)
+}
+
 func (q *queue) subscribe(sub func(m any) error) {
 	terminate := make(chan any)
 	terminated := make(chan any)
@@ -132,7 +142,13 @@ func (q *queue) subscribe(sub func(m any) error) {
 				return
 			case m := <-q.ch:
 				atomic.AddInt32(&q.unacked, 1)
-				if err := sub(m); err != nil {
+				if err := sub(m); errors.Is(err, ErrRequeue) {
+					if delay := requeueDelay(err); delay > 0 {
+						time.AfterFunc(delay, func() { q.send(m) })
+					} else {
+						go q.send(m)
+					}
+				} else if err != nil {
 					log.Error().
 						Err(err).
 						Msg("unexpcted error occurred while processing task")
@@ -167,6 +183,14 @@ func (b *InMemoryBroker) SubscribeForTasks(qname string, handler func(t *tork.Ta
 	})
 }
 
+func (b *InMemoryBroker) UnsubscribeForTasks(ctx context.Context, qname string) error {
+	log.Debug().Msgf("unsubscribing for tasks on %s", qname)
+	if q, ok := b.queues.Get(qname); ok {
+		q.unsubscribe()
+	}
+	return nil
+}
+
 func (b *InMemoryBroker) subscribe(qname string, handler func(m any) error) error {
 	log.Debug().Msgf("subscribing for tasks on %s", qname)
 	q, ok := b.queues.Get(qname)
@@ -38,6 +38,71 @@ func TestInMemoryPublishAndSubsribeForTask(t *testing.T) {
 	assert.Equal(t, "/somevolume", t1.Mounts[0].Target)
 }
 
+func TestInMemoryRequeueTask(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	processed := make(chan any)
+	attempts := 0
+	err := b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		attempts = attempts + 1
+		if attempts < 3 {
+			return mq.ErrRequeue
+		}
+		close(processed)
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishTask(ctx, "test-queue", &tork.Task{ID: uuid.NewUUID()})
+	assert.NoError(t, err)
+	<-processed
+	assert.Equal(t, 3, attempts)
+}
+
+func TestInMemoryRequeueTaskAfter(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	processed := make(chan time.Time)
+	var requeued time.Time
+	err := b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		if requeued.IsZero() {
+			requeued = time.Now()
+			return mq.RequeueAfter(time.Millisecond * 100)
+		}
+		processed <- time.Now()
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishTask(ctx, "test-queue", &tork.Task{ID: uuid.NewUUID()})
+	assert.NoError(t, err)
+	redelivered := <-processed
+	assert.GreaterOrEqual(t, redelivered.Sub(requeued), time.Millisecond*100)
+}
+
+func TestInMemoryUnsubscribeForTasks(t *testing.T) {
+	ctx := context.Background()
+	b := mq.NewInMemoryBroker()
+	processed := make(chan any, 10)
+	err := b.SubscribeForTasks("test-queue", func(t *tork.Task) error {
+		processed <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.UnsubscribeForTasks(ctx, "test-queue")
+	assert.NoError(t, err)
+	err = b.PublishTask(ctx, "test-queue", &tork.Task{ID: uuid.NewUUID()})
+	assert.NoError(t, err)
+	select {
+	case <-processed:
+		t.Fatal("task delivered after unsubscribing")
+	case <-time.After(time.Millisecond * 100):
+	}
+	qis, err := b.Queues(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(qis))
+	assert.Equal(t, 1, qis[0].Size)
+	assert.Equal(t, 0, qis[0].Subscribers)
+}
+
 func TestInMemoryGetQueues(t *testing.T) {
 	ctx := context.Background()
 	b := mq.NewInMemoryBroker()
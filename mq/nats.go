@@ -0,0 +1,639 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/syncx"
+)
+
+const (
+	NATS_DEFAULT_ACK_WAIT      = time.Minute * 30
+	NATS_DEFAULT_HEARTBEAT_TTL = time.Minute
+)
+
+const (
+	natsSubjectPrefix      = "tork"
+	natsStreamPrefix       = "TORK_"
+	natsSubscribersSubject = "tork.subscribers"
+	natsHeaderType         = "Tork-Type"
+	natsMetadataQueue      = "tork.queue"
+	// how long an idle subscriber waits before
+	// checking its queue for redelivered messages
+	natsPollInterval = time.Second
+	// how long to wait for subscribers to
+	// report themselves when listing queues
+	natsSubscribersTimeout = time.Millisecond * 250
+)
+
+var natsInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// NATSBroker is an implementation of the Broker interface
+// backed by NATS JetStream. Every queue is a JetStream work-queue
+// stream and events are published using core NATS subjects.
+type NATSBroker struct {
+	nc            *nats.Conn
+	js            jetstream.JetStream
+	queues        *syncx.Map[string, jetstream.Stream]
+	subscriptions []*natsSubscription
+	mu            sync.RWMutex
+	shuttingDown  bool
+	heartbeatTTL  time.Duration
+	ackWait       time.Duration
+	durable       bool
+}
+
+type natsSubscription struct {
+	qname string
+	subs  []*nats.Subscription
+	stop  chan any
+	done  chan any
+}
+
+type NATSOption = func(b *NATSBroker)
+
+// WithNATSHeartbeatTTL sets the maximum amount of time
+// a heartbeat is kept pending in the queue.
+func WithNATSHeartbeatTTL(ttl time.Duration) NATSOption {
+	return func(b *NATSBroker) {
+		b.heartbeatTTL = ttl
+	}
+}
+
+// WithNATSAckWait sets the maximum amount of time JetStream
+// will wait for a message ack from a subscriber before
+// redelivering the message. Default: 30 minutes
+func WithNATSAckWait(ackWait time.Duration) NATSOption {
+	return func(b *NATSBroker) {
+		b.ackWait = ackWait
+	}
+}
+
+// WithNATSDurableQueues stores the queues on disk
+// instead of in memory so they survive server restarts.
+func WithNATSDurableQueues(val bool) NATSOption {
+	return func(b *NATSBroker) {
+		b.durable = val
+	}
+}
+
+func NewNATSBroker(url string, opts ...NATSOption) (*NATSBroker, error) {
+	nc, err := nats.Connect(url,
+		nats.Name("tork"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Warn().Err(err).Msg("disconnected from NATS")
+			}
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			log.Info().Msgf("reconnected to NATS at %s", nc.ConnectedUrlRedacted())
+		}),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			log.Error().Err(err).Msg("NATS error")
+		}),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error connecting to NATS")
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, errors.Wrapf(err, "error creating JetStream context")
+	}
+	b := &NATSBroker{
+		nc:            nc,
+		js:            js,
+		queues:        new(syncx.Map[string, jetstream.Stream]),
+		subscriptions: make([]*natsSubscription, 0),
+		heartbeatTTL:  NATS_DEFAULT_HEARTBEAT_TTL,
+		ackWait:       NATS_DEFAULT_ACK_WAIT,
+	}
+	for _, o := range opts {
+		o(b)
+	}
+	return b, nil
+}
+
+// natsQueueID converts a queue name to a string which
+// is safe to use as a stream name and as a subject token.
+func natsQueueID(qname string) string {
+	id := natsInvalidChars.ReplaceAllString(qname, "_")
+	if id != qname {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(qname))
+		id = fmt.Sprintf("%s_%x", id, h.Sum32())
+	}
+	return id
+}
+
+func natsQueueSubject(qname string, priority int) string {
+	return fmt.Sprintf("%s.queue.%s.%d", natsSubjectPrefix, natsQueueID(qname), priority)
+}
+
+// natsPriorities returns the priority levels
+// supported by the queue, highest first.
+func natsPriorities(qname string) []int {
+	if !IsTaskQueue(qname) {
+		return []int{int(defaultPriority)}
+	}
+	levels := make([]int, 0, maxPriority+1)
+	for p := int(maxPriority); p >= 0; p-- {
+		levels = append(levels, p)
+	}
+	return levels
+}
+
+func (b *NATSBroker) declareQueue(ctx context.Context, qname string) (jetstream.Stream, error) {
+	if s, ok := b.queues.Get(qname); ok {
+		return s, nil
+	}
+	log.Debug().Msgf("declaring queue: %s", qname)
+	storage := jetstream.MemoryStorage
+	if b.durable && !strings.HasPrefix(qname, QUEUE_EXCLUSIVE_PREFIX) {
+		storage = jetstream.FileStorage
+	}
+	cfg := jetstream.StreamConfig{
+		Name:      natsStreamPrefix + natsQueueID(qname),
+		Subjects:  []string{fmt.Sprintf("%s.queue.%s.*", natsSubjectPrefix, natsQueueID(qname))},
+		Retention: jetstream.WorkQueuePolicy,
+		Storage:   storage,
+		Metadata:  map[string]string{natsMetadataQueue: qname},
+	}
+	if qname == QUEUE_HEARTBEAT {
+		cfg.MaxAge = b.heartbeatTTL
+	}
+	s, err := b.js.CreateOrUpdateStream(ctx, cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error declaring stream for queue %s", qname)
+	}
+	b.queues.Set(qname, s)
+	return s, nil
+}
+
+func (b *NATSBroker) publish(ctx context.Context, qname string, msg any) error {
+	if _, err := b.declareQueue(ctx, qname); err != nil {
+		return err
+	}
+	priority := int(defaultPriority)
+	if t, ok := msg.(*tork.Task); ok && IsTaskQueue(qname) {
+		priority = t.Priority
+		if priority < 0 {
+			priority = 0
+		} else if priority > int(maxPriority) {
+			priority = int(maxPriority)
+		}
+	}
+	body, err := serialize(msg)
+	if err != nil {
+		return err
+	}
+	m := nats.NewMsg(natsQueueSubject(qname, priority))
+	m.Header.Set(natsHeaderType, fmt.Sprintf("%T", msg))
+	m.Data = body
+	if _, err := b.js.PublishMsg(ctx, m); err != nil {
+		return errors.Wrapf(err, "unable to publish message to %s", qname)
+	}
+	return nil
+}
+
+func (b *NATSBroker) subscribe(qname string, handler func(msg any) error) error {
+	ctx := context.Background()
+	s, err := b.declareQueue(ctx, qname)
+	if err != nil {
+		return err
+	}
+	levels := natsPriorities(qname)
+	consumers := make([]jetstream.Consumer, len(levels))
+	for i, p := range levels {
+		c, err := s.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+			Durable:       fmt.Sprintf("p%d", p),
+			FilterSubject: natsQueueSubject(qname, p),
+			AckPolicy:     jetstream.AckExplicitPolicy,
+			AckWait:       b.ackWait,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "error creating consumer for queue %s", qname)
+		}
+		consumers[i] = c
+	}
+	// get notified when new messages are published
+	// to the queue instead of constantly polling it
+	wake := make(chan any, 1)
+	wsub, err := b.nc.Subscribe(fmt.Sprintf("%s.queue.%s.*", natsSubjectPrefix, natsQueueID(qname)), func(_ *nats.Msg) {
+		select {
+		case wake <- 1:
+		default:
+		}
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to subscribe on q: %s", qname)
+	}
+	// report this subscriber when listing queues
+	psub, err := b.nc.Subscribe(natsSubscribersSubject, func(m *nats.Msg) {
+		if err := m.Respond([]byte(qname)); err != nil {
+			log.Error().Err(err).Msgf("error reporting subscriber for %s", qname)
+		}
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to subscribe on q: %s", qname)
+	}
+	sub := &natsSubscription{
+		qname: qname,
+		subs:  []*nats.Subscription{wsub, psub},
+		stop:  make(chan any),
+		done:  make(chan any),
+	}
+	b.mu.Lock()
+	b.subscriptions = append(b.subscriptions, sub)
+	b.mu.Unlock()
+	go func() {
+		defer close(sub.done)
+		for {
+			select {
+			case <-sub.stop:
+				return
+			default:
+			}
+			msg, err := b.next(consumers)
+			if err != nil {
+				log.Error().
+					Err(err).
+					Str("queue", qname).
+					Msg("error fetching message")
+			}
+			if msg == nil {
+				select {
+				case <-sub.stop:
+					return
+				case <-wake:
+				case <-time.After(natsPollInterval):
+				}
+				continue
+			}
+			b.handle(qname, msg, handler)
+		}
+	}()
+	return nil
+}
+
+// next fetches the next available message
+// from the highest priority consumer.
+func (b *NATSBroker) next(consumers []jetstream.Consumer) (jetstream.Msg, error) {
+	for _, c := range consumers {
+		batch, err := c.FetchNoWait(1)
+		if err != nil {
+			return nil, err
+		}
+		for m := range batch.Messages() {
+			return m, nil
+		}
+		if err := batch.Error(); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (b *NATSBroker) handle(qname string, m jetstream.Msg, handler func(msg any) error) {
+	mtype := m.Headers().Get(natsHeaderType)
+	msg, err := deserialize(mtype, m.Data())
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("queue", qname).
+			Str("body", string(m.Data())).
+			Str("type", mtype).
+			Msg("failed to deserialize message")
+		if err := m.Term(); err != nil {
+			log.Error().
+				Err(err).
+				Msg("failed to terminate message")
+		}
+		return
+	}
+	if err := handler(msg); errors.Is(err, ErrRequeue) {
+		if err := m.NakWithDelay(requeueDelay(err)); err != nil {
+			log.Error().
+				Err(err).
+				Msg("failed to requeue message")
+		}
+		return
+	} else if err != nil {
+		log.Error().
+			Err(err).
+			Str("queue", qname).
+			Str("body", string(m.Data())).
+			Msg("failed to handle message")
+		if err := m.Term(); err != nil {
+			log.Error().
+				Err(err).
+				Msg("failed to terminate message")
+		}
+		return
+	}
+	if err := m.Ack(); err != nil {
+		log.Error().
+			Err(err).
+			Msg("failed to ack message")
+	}
+}
+
+func (b *NATSBroker) Queues(ctx context.Context) ([]QueueInfo, error) {
+	subscribers, err := b.subscribers()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]QueueInfo, 0)
+	streams := b.js.ListStreams(ctx)
+	for si := range streams.Info() {
+		qname, ok := si.Config.Metadata[natsMetadataQueue]
+		if !ok {
+			continue
+		}
+		s, err := b.js.Stream(ctx, si.Config.Name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting stream for queue %s", qname)
+		}
+		unacked := 0
+		consumers := s.ListConsumers(ctx)
+		for ci := range consumers.Info() {
+			unacked = unacked + ci.NumAckPending
+		}
+		if err := consumers.Err(); err != nil {
+			return nil, errors.Wrapf(err, "error listing consumers for queue %s", qname)
+		}
+		result = append(result, QueueInfo{
+			Name:        qname,
+			Size:        int(si.State.Msgs),
+			Subscribers: subscribers[qname],
+			Unacked:     unacked,
+		})
+	}
+	if err := streams.Err(); err != nil {
+		return nil, errors.Wrapf(err, "error listing streams")
+	}
+	return result, nil
+}
+
+// subscribers asks all the active subscribers
+// to report themselves and counts them by queue.
+func (b *NATSBroker) subscribers() (map[string]int, error) {
+	inbox := b.nc.NewInbox()
+	sub, err := b.nc.SubscribeSync(inbox)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error subscribing to inbox")
+	}
+	defer func() {
+		if err := sub.Unsubscribe(); err != nil {
+			log.Error().Err(err).Msg("error unsubscribing from inbox")
+		}
+	}()
+	if err := b.nc.PublishRequest(natsSubscribersSubject, inbox, nil); err != nil {
+		return nil, errors.Wrapf(err, "error requesting subscribers")
+	}
+	result := make(map[string]int)
+	for {
+		m, err := sub.NextMsg(natsSubscribersTimeout)
+		if err != nil {
+			if errors.Is(err, nats.ErrTimeout) {
+				break
+			}
+			return nil, errors.Wrapf(err, "error reading subscribers")
+		}
+		result[string(m.Data)] = result[string(m.Data)] + 1
+	}
+	return result, nil
+}
+
+func (b *NATSBroker) PublishTask(ctx context.Context, qname string, t *tork.Task) error {
+	return b.publish(ctx, qname, t)
+}
+
+func (b *NATSBroker) SubscribeForTasks(qname string, handler func(t *tork.Task) error) error {
+	return b.subscribe(qname, func(msg any) error {
+		t, ok := msg.(*tork.Task)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Task but got %T", msg)
+		}
+		return handler(t)
+	})
+}
+
+func (b *NATSBroker) UnsubscribeForTasks(ctx context.Context, qname string) error {
+	b.mu.Lock()
+	var cancelled []*natsSubscription
+	subs := make([]*natsSubscription, 0, len(b.subscriptions))
+	for _, sub := range b.subscriptions {
+		if sub.qname == qname {
+			cancelled = append(cancelled, sub)
+		} else {
+			subs = append(subs, sub)
+		}
+	}
+	b.subscriptions = subs
+	b.mu.Unlock()
+	for _, sub := range cancelled {
+		log.Debug().
+			Msgf("cancelling subscription on %s", sub.qname)
+		for _, s := range sub.subs {
+			if err := s.Unsubscribe(); err != nil {
+				return errors.Wrapf(err, "error unsubscribing from %s", qname)
+			}
+		}
+		close(sub.stop)
+	}
+	return nil
+}
+
+func (b *NATSBroker) PublishTaskProgress(ctx context.Context, t *tork.Task) error {
+	return b.publish(ctx, QUEUE_PROGRESS, t)
+}
+
+func (b *NATSBroker) SubscribeForTaskProgress(handler func(t *tork.Task) error) error {
+	return b.subscribe(QUEUE_PROGRESS, func(msg any) error {
+		t, ok := msg.(*tork.Task)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Task but got %T", msg)
+		}
+		return handler(t)
+	})
+}
+
+func (b *NATSBroker) PublishHeartbeat(ctx context.Context, n *tork.Node) error {
+	return b.publish(ctx, QUEUE_HEARTBEAT, n)
+}
+
+func (b *NATSBroker) SubscribeForHeartbeats(handler func(n *tork.Node) error) error {
+	return b.subscribe(QUEUE_HEARTBEAT, func(msg any) error {
+		n, ok := msg.(*tork.Node)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Node but got %T", msg)
+		}
+		return handler(n)
+	})
+}
+
+func (b *NATSBroker) PublishJob(ctx context.Context, j *tork.Job) error {
+	return b.publish(ctx, QUEUE_JOBS, j)
+}
+
+func (b *NATSBroker) SubscribeForJobs(handler func(j *tork.Job) error) error {
+	return b.subscribe(QUEUE_JOBS, func(msg any) error {
+		j, ok := msg.(*tork.Job)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Job but got %T", msg)
+		}
+		return handler(j)
+	})
+}
+
+func (b *NATSBroker) PublishTaskLogPart(ctx context.Context, p *tork.TaskLogPart) error {
+	return b.publish(ctx, QUEUE_LOGS, p)
+}
+
+func (b *NATSBroker) SubscribeForTaskLogPart(handler func(p *tork.TaskLogPart)) error {
+	return b.subscribe(QUEUE_LOGS, func(msg any) error {
+		p, ok := msg.(*tork.TaskLogPart)
+		if !ok {
+			return errors.Errorf("expecting a *tork.TaskLogPart but got %T", msg)
+		}
+		handler(p)
+		return nil
+	})
+}
+
+// natsEventSubject converts an event topic or pattern to a NATS subject.
+// A trailing wildcard matches any number of tokens, same as the other
+// broker implementations.
+func natsEventSubject(pattern string) string {
+	if strings.HasSuffix(pattern, "*") {
+		pattern = strings.TrimSuffix(pattern, "*") + ">"
+	}
+	return fmt.Sprintf("%s.events.%s", natsSubjectPrefix, pattern)
+}
+
+func (b *NATSBroker) PublishEvent(ctx context.Context, topic string, event any) error {
+	body, err := serialize(event)
+	if err != nil {
+		return err
+	}
+	m := nats.NewMsg(natsEventSubject(topic))
+	m.Header.Set(natsHeaderType, fmt.Sprintf("%T", event))
+	m.Data = body
+	if err := b.nc.PublishMsg(m); err != nil {
+		return errors.Wrapf(err, "unable to publish event to %s", topic)
+	}
+	return nil
+}
+
+func (b *NATSBroker) SubscribeForEvents(ctx context.Context, pattern string, handler func(event any)) error {
+	sub, err := b.nc.Subscribe(natsEventSubject(pattern), func(m *nats.Msg) {
+		mtype := m.Header.Get(natsHeaderType)
+		ev, err := deserialize(mtype, m.Data)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("subject", m.Subject).
+				Str("type", mtype).
+				Msg("failed to deserialize event")
+			return
+		}
+		handler(ev)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to subscribe for events: %s", pattern)
+	}
+	if ctx.Done() != nil {
+		go func() {
+			<-ctx.Done()
+			if err := sub.Unsubscribe(); err != nil && !errors.Is(err, nats.ErrConnectionClosed) {
+				log.Error().Err(err).Msgf("error unsubscribing from %s", pattern)
+			}
+		}()
+	}
+	return nil
+}
+
+func (b *NATSBroker) HealthCheck(ctx context.Context) error {
+	if !b.nc.IsConnected() {
+		return errors.Errorf("NATS connection is %s", b.nc.Status())
+	}
+	if _, err := b.js.AccountInfo(ctx); err != nil {
+		return errors.Wrapf(err, "error getting JetStream account info")
+	}
+	return nil
+}
+
+func (b *NATSBroker) isShuttingDown() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.shuttingDown
+}
+
+func (b *NATSBroker) Shutdown(ctx context.Context) error {
+	// when running in standalone mode both the coordinator
+	// and the worker will attempt to shutdown the broker
+	if b.isShuttingDown() {
+		return nil
+	}
+	b.mu.Lock()
+	b.shuttingDown = true
+	subscriptions := b.subscriptions
+	b.subscriptions = []*natsSubscription{}
+	b.mu.Unlock()
+	for _, sub := range subscriptions {
+		log.Debug().
+			Msgf("shutting down subscription on %s", sub.qname)
+		for _, s := range sub.subs {
+			if err := s.Unsubscribe(); err != nil {
+				log.Error().
+					Err(err).
+					Msgf("error unsubscribing from %s", sub.qname)
+			}
+		}
+		close(sub.stop)
+	}
+	// let's give the subscribers a grace
+	// period to allow them to cleanly exit
+	for _, sub := range subscriptions {
+		// skip non-coordinator queue
+		if !IsCoordinatorQueue(sub.qname) {
+			continue
+		}
+		log.Debug().
+			Msgf("waiting for subscription %s to terminate", sub.qname)
+		select {
+		case <-ctx.Done():
+		case <-sub.done:
+		}
+	}
+	// exclusive queues don't outlive their subscriber
+	for _, sub := range subscriptions {
+		if !strings.HasPrefix(sub.qname, QUEUE_EXCLUSIVE_PREFIX) {
+			continue
+		}
+		if err := b.js.DeleteStream(ctx, natsStreamPrefix+natsQueueID(sub.qname)); err != nil &&
+			!errors.Is(err, jetstream.ErrStreamNotFound) {
+			log.Error().
+				Err(err).
+				Msgf("error deleting exclusive queue %s", sub.qname)
+		}
+	}
+	if err := b.nc.Drain(); err != nil {
+		log.Error().
+			Err(err).
+			Msg("error draining NATS connection")
+	}
+	return nil
+}
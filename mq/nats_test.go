@@ -0,0 +1,472 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func runNATSServer(t *testing.T) string {
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	assert.NoError(t, err)
+	go s.Start()
+	if !s.ReadyForConnections(time.Second * 10) {
+		t.Fatal("NATS server is not ready for connections")
+	}
+	t.Cleanup(s.Shutdown)
+	return s.ClientURL()
+}
+
+func TestNATSPublishAndSubsribeForTask(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(runNATSServer(t))
+	assert.NoError(t, err)
+	processed := make(chan any)
+	qname := fmt.Sprintf("%stest-%s", QUEUE_EXCLUSIVE_PREFIX, uuid.NewUUID())
+	err = b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		processed <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishTask(ctx, qname, &tork.Task{})
+	<-processed
+	assert.NoError(t, err)
+}
+
+func TestNATSRequeueTaskAfter(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(runNATSServer(t))
+	assert.NoError(t, err)
+	processed := make(chan time.Time)
+	var requeued time.Time
+	qname := fmt.Sprintf("test.%s", uuid.NewUUID())
+	err = b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		if requeued.IsZero() {
+			requeued = time.Now()
+			return RequeueAfter(time.Millisecond * 500)
+		}
+		processed <- time.Now()
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishTask(ctx, qname, &tork.Task{ID: uuid.NewUUID()})
+	assert.NoError(t, err)
+	redelivered := <-processed
+	assert.GreaterOrEqual(t, redelivered.Sub(requeued), time.Millisecond*500)
+}
+
+func TestNATSUnsubscribeForTasks(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(runNATSServer(t))
+	assert.NoError(t, err)
+	processed := make(chan any, 10)
+	qname := fmt.Sprintf("test.%s", uuid.NewUUID())
+	err = b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		processed <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.UnsubscribeForTasks(ctx, qname)
+	assert.NoError(t, err)
+	err = b.PublishTask(ctx, qname, &tork.Task{ID: uuid.NewUUID()})
+	assert.NoError(t, err)
+	select {
+	case <-processed:
+		t.Fatal("task delivered after unsubscribing")
+	case <-time.After(time.Millisecond * 500):
+	}
+	// the task is left for other subscribers
+	err = b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		processed <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	<-processed
+}
+
+func TestNATSPublishBeforeSubscribe(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(runNATSServer(t))
+	assert.NoError(t, err)
+	qname := fmt.Sprintf("test.%s", uuid.NewUUID())
+	err = b.PublishTask(ctx, qname, &tork.Task{ID: "1234"})
+	assert.NoError(t, err)
+	processed := make(chan *tork.Task)
+	err = b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		processed <- t
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", (<-processed).ID)
+}
+
+func TestNATSGetQueues(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(runNATSServer(t))
+	assert.NoError(t, err)
+	qname := fmt.Sprintf("%stest-%s", QUEUE_EXCLUSIVE_PREFIX, uuid.NewUUID())
+	err = b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishTask(ctx, "some-queue", &tork.Task{})
+	assert.NoError(t, err)
+	qis, err := b.Queues(ctx)
+	assert.NoError(t, err)
+	found := map[string]QueueInfo{}
+	for _, qi := range qis {
+		found[qi.Name] = qi
+	}
+	assert.Contains(t, found, qname)
+	assert.Equal(t, 1, found[qname].Subscribers)
+	assert.Contains(t, found, "some-queue")
+	assert.Equal(t, 1, found["some-queue"].Size)
+	assert.Equal(t, 0, found["some-queue"].Subscribers)
+}
+
+func TestNATSGetQueuesUnacked(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(runNATSServer(t))
+	assert.NoError(t, err)
+	qname := fmt.Sprintf("test-%s", uuid.NewUUID())
+	processing := make(chan any)
+	release := make(chan any)
+	err = b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		close(processing)
+		<-release
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishTask(ctx, qname, &tork.Task{})
+	assert.NoError(t, err)
+	<-processing
+	qis, err := b.Queues(ctx)
+	assert.NoError(t, err)
+	for _, qi := range qis {
+		if qi.Name == qname {
+			assert.Equal(t, 1, qi.Unacked)
+		}
+	}
+	close(release)
+}
+
+func TestNATSPublishAndSubsribeForHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(runNATSServer(t))
+	assert.NoError(t, err)
+	processed := make(chan any)
+	err = b.SubscribeForHeartbeats(func(n *tork.Node) error {
+		processed <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishHeartbeat(ctx, &tork.Node{})
+	<-processed
+	assert.NoError(t, err)
+}
+
+func TestNATSHeartbeatTTL(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(runNATSServer(t), WithNATSHeartbeatTTL(time.Millisecond*100))
+	assert.NoError(t, err)
+	err = b.PublishHeartbeat(ctx, &tork.Node{})
+	assert.NoError(t, err)
+	time.Sleep(time.Millisecond * 500)
+	processed := make(chan any, 1)
+	err = b.SubscribeForHeartbeats(func(n *tork.Node) error {
+		processed <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	time.Sleep(time.Millisecond * 500)
+	assert.Len(t, processed, 0)
+}
+
+func TestNATSPublishAndSubsribeForJob(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(runNATSServer(t))
+	assert.NoError(t, err)
+	processed := make(chan any)
+	err = b.SubscribeForJobs(func(j *tork.Job) error {
+		processed <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishJob(ctx, &tork.Job{})
+	<-processed
+	assert.NoError(t, err)
+}
+
+func TestNATSMultipleSubscribers(t *testing.T) {
+	ctx := context.Background()
+	url := runNATSServer(t)
+	b1, err := NewNATSBroker(url)
+	assert.NoError(t, err)
+	b2, err := NewNATSBroker(url)
+	assert.NoError(t, err)
+	qname := fmt.Sprintf("test-%s", uuid.NewUUID())
+	processed := make(chan string, 20)
+	err = b1.SubscribeForTasks(qname, func(t *tork.Task) error {
+		processed <- t.ID
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b2.SubscribeForTasks(qname, func(t *tork.Task) error {
+		processed <- t.ID
+		return nil
+	})
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		err = b1.PublishTask(ctx, qname, &tork.Task{ID: fmt.Sprintf("%d", i)})
+		assert.NoError(t, err)
+	}
+	// every task is delivered exactly once
+	ids := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		ids[<-processed] = true
+	}
+	assert.Len(t, ids, 10)
+	time.Sleep(time.Millisecond * 100)
+	assert.Len(t, processed, 0)
+}
+
+func TestNATSPublishConcurrent(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(runNATSServer(t))
+	assert.NoError(t, err)
+	testq := fmt.Sprintf("%s%s", QUEUE_EXCLUSIVE_PREFIX, "test")
+	processed := make(chan any, 300)
+	err = b.SubscribeForTasks(testq, func(t *tork.Task) error {
+		processed <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	wg := sync.WaitGroup{}
+	wg.Add(3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				err := b.PublishTask(ctx, testq, &tork.Task{})
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 300; i++ {
+		<-processed
+	}
+	close(processed)
+}
+
+func TestNATSShutdown(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(runNATSServer(t))
+	assert.NoError(t, err)
+	mu := sync.Mutex{}
+	qname := fmt.Sprintf("%stest-%s", QUEUE_EXCLUSIVE_PREFIX, uuid.NewUUID())
+	err = b.SubscribeForTasks(qname, func(t1 *tork.Task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		// should not be able to block
+		// the termination process
+		time.Sleep(time.Hour)
+		return nil
+	})
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		err = b.PublishTask(ctx, qname, &tork.Task{})
+		assert.NoError(t, err)
+	}
+	// cleanly shutdown
+	err = b.Shutdown(ctx)
+	assert.NoError(t, err)
+	// there should be no more processing past the shutdown
+	err = b.PublishTask(ctx, qname, &tork.Task{})
+	assert.Error(t, err)
+}
+
+func TestNATSSubsribeForEvent(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(runNATSServer(t))
+	assert.NoError(t, err)
+	defer func() {
+		// cleanly shutdown
+		err = b.Shutdown(ctx)
+		assert.NoError(t, err)
+	}()
+	j1 := &tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateCompleted,
+	}
+	processed1 := make(chan any, 30)
+	processed2 := make(chan any, 10)
+	err = b.SubscribeForEvents(ctx, TOPIC_JOB, func(event any) {
+		j2 := event.(*tork.Job)
+		assert.Equal(t, j1.ID, j2.ID)
+		processed1 <- 1
+	})
+	assert.NoError(t, err)
+	err = b.SubscribeForEvents(ctx, TOPIC_JOB_COMPLETED, func(event any) {
+		j2 := event.(*tork.Job)
+		assert.Equal(t, j1.ID, j2.ID)
+		processed2 <- 1
+	})
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		err = b.PublishEvent(ctx, TOPIC_JOB_COMPLETED, j1)
+		assert.NoError(t, err)
+		err = b.PublishEvent(ctx, TOPIC_JOB_FAILED, j1)
+		assert.NoError(t, err)
+		err = b.PublishEvent(ctx, "job.x.y.z", j1)
+		assert.NoError(t, err)
+	}
+
+	for i := 0; i < 30; i++ {
+		<-processed1
+	}
+	close(processed1)
+	for i := 0; i < 10; i++ {
+		<-processed2
+	}
+	close(processed2)
+}
+
+func TestNATSUnsubsribeForEvent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b, err := NewNATSBroker(runNATSServer(t))
+	assert.NoError(t, err)
+	processed := make(chan any, 10)
+	err = b.SubscribeForEvents(ctx, TOPIC_JOB, func(event any) {
+		processed <- 1
+	})
+	assert.NoError(t, err)
+	err = b.PublishEvent(context.Background(), TOPIC_JOB_COMPLETED, &tork.Job{})
+	assert.NoError(t, err)
+	<-processed
+	cancel()
+	time.Sleep(time.Millisecond * 100)
+	err = b.PublishEvent(context.Background(), TOPIC_JOB_COMPLETED, &tork.Job{})
+	assert.NoError(t, err)
+	time.Sleep(time.Millisecond * 100)
+	assert.Len(t, processed, 0)
+}
+
+func TestNATSPublishUnknownEvent(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(runNATSServer(t))
+	assert.NoError(t, err)
+	defer func() {
+		// cleanly shutdown
+		err = b.Shutdown(ctx)
+		assert.NoError(t, err)
+	}()
+	err = b.SubscribeForEvents(ctx, TOPIC_JOB, func(event any) {})
+	assert.NoError(t, err)
+
+	err = b.PublishEvent(ctx, TOPIC_JOB_COMPLETED, "not a thing")
+	assert.Error(t, err)
+}
+
+func TestNATSHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(runNATSServer(t))
+	assert.NoError(t, err)
+	assert.NoError(t, b.HealthCheck(ctx))
+	assert.NoError(t, b.Shutdown(ctx))
+	assert.Eventually(t, func() bool {
+		return b.HealthCheck(ctx) != nil
+	}, time.Second*5, time.Millisecond*100)
+}
+
+func TestNATSPublishAndSubsribeTaskLogPart(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(runNATSServer(t))
+	assert.NoError(t, err)
+	processed := make(chan any)
+	err = b.SubscribeForTaskLogPart(func(p *tork.TaskLogPart) {
+		processed <- 1
+	})
+	assert.NoError(t, err)
+	err = b.PublishTaskLogPart(ctx, &tork.TaskLogPart{})
+	assert.NoError(t, err)
+	<-processed
+}
+
+func TestNATSTaskPriority(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(runNATSServer(t))
+	assert.NoError(t, err)
+	qname := fmt.Sprintf("test-%s", uuid.NewUUID())
+	for _, p := range []int{0, 1, 3, 2} {
+		err = b.PublishTask(ctx, qname, &tork.Task{
+			Priority: p,
+		})
+		assert.NoError(t, err)
+	}
+	tasks := make(chan *tork.Task, 4)
+	err = b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		tasks <- t
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, (<-tasks).Priority)
+	assert.Equal(t, 2, (<-tasks).Priority)
+	assert.Equal(t, 1, (<-tasks).Priority)
+	assert.Equal(t, 0, (<-tasks).Priority)
+}
+
+func TestNATSTaskProgress(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(runNATSServer(t))
+	assert.NoError(t, err)
+	processed := make(chan any)
+	err = b.SubscribeForTaskProgress(func(p *tork.Task) error {
+		close(processed)
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishTaskProgress(ctx, &tork.Task{})
+	<-processed
+	assert.NoError(t, err)
+}
+
+func TestNATSDurableQueues(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewNATSBroker(runNATSServer(t), WithNATSDurableQueues(true))
+	assert.NoError(t, err)
+	qname := fmt.Sprintf("test-%s", uuid.NewUUID())
+	err = b.PublishTask(ctx, qname, &tork.Task{})
+	assert.NoError(t, err)
+	s, err := b.js.Stream(ctx, natsStreamPrefix+natsQueueID(qname))
+	assert.NoError(t, err)
+	assert.Equal(t, jetstream.FileStorage, s.CachedInfo().Config.Storage)
+}
+
+func Test_natsQueueID(t *testing.T) {
+	assert.Equal(t, "default", natsQueueID("default"))
+	assert.Equal(t, "x-1234", natsQueueID("x-1234"))
+	assert.NotEqual(t, natsQueueID("a.b"), natsQueueID("a_b"))
+	assert.Regexp(t, "^a_b_[0-9a-f]+$", natsQueueID("a.b"))
+}
+
+func Test_natsEventSubject(t *testing.T) {
+	assert.Equal(t, "tork.events.job.>", natsEventSubject(TOPIC_JOB))
+	assert.Equal(t, "tork.events.job.completed", natsEventSubject(TOPIC_JOB_COMPLETED))
+}
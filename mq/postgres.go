@@ -0,0 +1,763 @@
+package mq
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/internal/wildcard"
+)
+
+const (
+	POSTGRES_DEFAULT_LEASE_TTL     = time.Minute
+	POSTGRES_DEFAULT_HEARTBEAT_TTL = time.Minute
+)
+
+const (
+	pgChannelQueues = "tork_queues"
+	pgChannelEvents = "tork_events"
+	// NOTIFY payloads are limited to 8000 bytes. Larger
+	// events are stored in a table and only their ID is sent.
+	pgMaxNotifyPayload = 7900
+	// how long an idle subscriber waits before
+	// checking its queue for expired leases
+	pgPollInterval = time.Second
+	// how long an event is kept around for
+	// subscribers to fetch it
+	pgEventRetention = time.Minute
+	// the number of events an event subscriber can
+	// fall behind before it blocks the dispatcher
+	pgEventBufferSize = 1000
+)
+
+// PostgresBroker is an implementation of the Broker interface
+// backed by a Postgres database. Queues are tables consumed using
+// SELECT ... FOR UPDATE SKIP LOCKED and events are delivered
+// using LISTEN/NOTIFY.
+type PostgresBroker struct {
+	id            string
+	db            *sqlx.DB
+	listener      *pq.Listener
+	channelQueues string
+	channelEvents string
+	subscriptions []*pgSubscription
+	events        []*pgEventSubscription
+	mu            sync.RWMutex
+	shuttingDown  bool
+	stop          chan any
+	done          chan any
+	leaseTTL      time.Duration
+	heartbeatTTL  time.Duration
+}
+
+type pgSubscription struct {
+	id    string
+	qname string
+	wake  chan any
+	stop  chan any
+	done  chan any
+}
+
+type pgEventSubscription struct {
+	pattern string
+	ch      chan *pgEvent
+	stop    chan any
+}
+
+// pgEvent is the NOTIFY payload of a published event.
+// Body is empty when the event was too large to be
+// sent inline.
+type pgEvent struct {
+	ID    int64           `json:"id,omitempty"`
+	Topic string          `json:"topic"`
+	Type  string          `json:"type"`
+	Body  json.RawMessage `json:"body,omitempty"`
+}
+
+type pgMessage struct {
+	ID      int64  `db:"id"`
+	MsgType string `db:"msg_type"`
+	Body    []byte `db:"body"`
+}
+
+type PostgresOption = func(b *PostgresBroker)
+
+// WithPostgresLeaseTTL sets the amount of time a message is
+// reserved for its subscriber. Leases are renewed for as long as
+// the broker is running so a message is only redelivered when its
+// subscriber is gone.
+func WithPostgresLeaseTTL(ttl time.Duration) PostgresOption {
+	return func(b *PostgresBroker) {
+		b.leaseTTL = ttl
+	}
+}
+
+// WithPostgresHeartbeatTTL sets the maximum amount of time
+// a heartbeat is kept pending in the queue.
+func WithPostgresHeartbeatTTL(ttl time.Duration) PostgresOption {
+	return func(b *PostgresBroker) {
+		b.heartbeatTTL = ttl
+	}
+}
+
+func NewPostgresBroker(dsn string, opts ...PostgresOption) (*PostgresBroker, error) {
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to connect to postgres")
+	}
+	b := &PostgresBroker{
+		id:            uuid.NewUUID(),
+		db:            db,
+		subscriptions: make([]*pgSubscription, 0),
+		events:        make([]*pgEventSubscription, 0),
+		stop:          make(chan any),
+		done:          make(chan any),
+		leaseTTL:      POSTGRES_DEFAULT_LEASE_TTL,
+		heartbeatTTL:  POSTGRES_DEFAULT_HEARTBEAT_TTL,
+	}
+	for _, o := range opts {
+		o(b)
+	}
+	if b.leaseTTL < time.Second*3 {
+		db.Close()
+		return nil, errors.Errorf("lease TTL can not be under 3 seconds")
+	}
+	// notification channels are shared by the whole database
+	// so they are scoped to the schema the broker is using
+	var schema string
+	if err := db.Get(&schema, "SELECT current_schema()"); err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "unable to get the current schema")
+	}
+	b.channelQueues = fmt.Sprintf("%s_%s", pgChannelQueues, schema)
+	b.channelEvents = fmt.Sprintf("%s_%s", pgChannelEvents, schema)
+	b.listener = pq.NewListener(dsn, time.Millisecond*10, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Error().Err(err).Msg("postgres listener error")
+		}
+	})
+	for _, ch := range []string{b.channelQueues, b.channelEvents} {
+		if err := b.listener.Listen(ch); err != nil {
+			b.listener.Close()
+			db.Close()
+			return nil, errors.Wrapf(err, "unable to listen on %s", ch)
+		}
+	}
+	go b.dispatch()
+	go b.housekeeping()
+	return b, nil
+}
+
+// dispatch routes the notifications received from
+// the database to the subscribers.
+func (b *PostgresBroker) dispatch() {
+	for n := range b.listener.Notify {
+		// a nil notification means that the connection was
+		// re-established and notifications may have been lost
+		if n == nil {
+			b.wake("")
+			continue
+		}
+		switch n.Channel {
+		case b.channelQueues:
+			b.wake(n.Extra)
+		case b.channelEvents:
+			ev := pgEvent{}
+			if err := json.Unmarshal([]byte(n.Extra), &ev); err != nil {
+				log.Error().
+					Err(err).
+					Str("payload", n.Extra).
+					Msg("failed to deserialize event notification")
+				continue
+			}
+			b.dispatchEvent(&ev)
+		}
+	}
+}
+
+// wake notifies the subscribers of a queue that new messages
+// are available. An empty queue name wakes up all subscribers.
+func (b *PostgresBroker) wake(qname string) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, sub := range b.subscriptions {
+		if qname != "" && sub.qname != qname {
+			continue
+		}
+		select {
+		case sub.wake <- 1:
+		default:
+		}
+	}
+}
+
+func (b *PostgresBroker) dispatchEvent(ev *pgEvent) {
+	b.mu.RLock()
+	subs := make([]*pgEventSubscription, 0)
+	for _, sub := range b.events {
+		if wildcard.Match(sub.pattern, ev.Topic) {
+			subs = append(subs, sub)
+		}
+	}
+	b.mu.RUnlock()
+	if len(subs) == 0 {
+		return
+	}
+	if len(ev.Body) == 0 {
+		m := pgMessage{}
+		if err := b.db.Get(&m, "SELECT id, msg_type, body FROM mq_events WHERE id = $1", ev.ID); err != nil {
+			log.Error().
+				Err(err).
+				Int64("id", ev.ID).
+				Str("topic", ev.Topic).
+				Msg("failed to fetch event")
+			return
+		}
+		ev.Body = m.Body
+	}
+	for _, sub := range subs {
+		select {
+		case sub.ch <- ev:
+		case <-sub.stop:
+		}
+	}
+}
+
+// housekeeping renews the leases and the subscribers of this
+// broker and expunges expired messages, events and subscribers.
+func (b *PostgresBroker) housekeeping() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.leaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			if err := b.renew(); err != nil {
+				log.Error().Err(err).Msg("error renewing leases")
+			}
+			if err := b.cleanup(); err != nil {
+				log.Error().Err(err).Msg("error expunging expired messages")
+			}
+		}
+	}
+}
+
+func (b *PostgresBroker) renew() error {
+	now := time.Now().UTC()
+	if _, err := b.db.Exec(
+		"UPDATE mq_messages SET leased_until = $1 WHERE leased_by = $2",
+		now.Add(b.leaseTTL), b.id,
+	); err != nil {
+		return errors.Wrapf(err, "error renewing message leases")
+	}
+	b.mu.RLock()
+	subs := make([]*pgSubscription, len(b.subscriptions))
+	copy(subs, b.subscriptions)
+	b.mu.RUnlock()
+	for _, sub := range subs {
+		if err := b.registerSubscriber(sub, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *PostgresBroker) registerSubscriber(sub *pgSubscription, now time.Time) error {
+	q := `INSERT INTO mq_subscribers (id, broker_id, queue_name, last_heartbeat_at)
+	      VALUES ($1, $2, $3, $4)
+	      ON CONFLICT (id) DO UPDATE SET last_heartbeat_at = $4`
+	if _, err := b.db.Exec(q, sub.id, b.id, sub.qname, now); err != nil {
+		return errors.Wrapf(err, "error registering subscriber for %s", sub.qname)
+	}
+	return nil
+}
+
+func (b *PostgresBroker) cleanup() error {
+	now := time.Now().UTC()
+	if _, err := b.db.Exec("DELETE FROM mq_messages WHERE expires_at < $1", now); err != nil {
+		return errors.Wrapf(err, "error expunging expired messages")
+	}
+	if _, err := b.db.Exec("DELETE FROM mq_events WHERE created_at < $1", now.Add(-pgEventRetention)); err != nil {
+		return errors.Wrapf(err, "error expunging expired events")
+	}
+	if _, err := b.db.Exec("DELETE FROM mq_subscribers WHERE last_heartbeat_at < $1", now.Add(-b.leaseTTL)); err != nil {
+		return errors.Wrapf(err, "error expunging stale subscribers")
+	}
+	return nil
+}
+
+func (b *PostgresBroker) publish(ctx context.Context, qname string, msg any) error {
+	priority := int(defaultPriority)
+	if t, ok := msg.(*tork.Task); ok && IsTaskQueue(qname) {
+		priority = t.Priority
+		if priority < 0 {
+			priority = 0
+		} else if priority > int(maxPriority) {
+			priority = int(maxPriority)
+		}
+	}
+	body, err := serialize(msg)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	var expiresAt *time.Time
+	if qname == QUEUE_HEARTBEAT {
+		exp := now.Add(b.heartbeatTTL)
+		expiresAt = &exp
+	}
+	q := `WITH m AS (
+	        INSERT INTO mq_messages (queue_name, priority, msg_type, body, created_at, expires_at)
+	        VALUES ($1, $2, $3, $4, $5, $6)
+	        RETURNING queue_name
+	      )
+	      SELECT pg_notify($7, queue_name) FROM m`
+	if _, err := b.db.ExecContext(ctx, q, qname, priority, fmt.Sprintf("%T", msg), body, now, expiresAt, b.channelQueues); err != nil {
+		return errors.Wrapf(err, "unable to publish message to %s", qname)
+	}
+	return nil
+}
+
+func (b *PostgresBroker) subscribe(qname string, handler func(msg any) error) error {
+	sub := &pgSubscription{
+		id:    uuid.NewUUID(),
+		qname: qname,
+		wake:  make(chan any, 1),
+		stop:  make(chan any),
+		done:  make(chan any),
+	}
+	// report this subscriber when listing queues
+	if err := b.registerSubscriber(sub, time.Now().UTC()); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.subscriptions = append(b.subscriptions, sub)
+	b.mu.Unlock()
+	go func() {
+		defer close(sub.done)
+		for {
+			select {
+			case <-sub.stop:
+				return
+			default:
+			}
+			m, err := b.next(qname)
+			if err != nil {
+				log.Error().
+					Err(err).
+					Str("queue", qname).
+					Msg("error fetching message")
+			}
+			if m == nil {
+				select {
+				case <-sub.stop:
+					return
+				case <-sub.wake:
+				case <-time.After(pgPollInterval):
+				}
+				continue
+			}
+			b.handle(qname, m, handler)
+		}
+	}()
+	return nil
+}
+
+// next leases the next available message
+// with the highest priority on the queue.
+func (b *PostgresBroker) next(qname string) (*pgMessage, error) {
+	now := time.Now().UTC()
+	q := `UPDATE mq_messages SET leased_by = $2, leased_until = $3
+	      WHERE id = (
+	        SELECT id FROM mq_messages
+	        WHERE queue_name = $1
+	          AND (leased_until IS NULL OR leased_until < $4)
+	          AND (expires_at IS NULL OR expires_at > $4)
+	        ORDER BY priority DESC, id
+	        FOR UPDATE SKIP LOCKED
+	        LIMIT 1
+	      )
+	      RETURNING id, msg_type, body`
+	m := pgMessage{}
+	if err := b.db.Get(&m, q, qname, b.id, now.Add(b.leaseTTL), now); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "error leasing message")
+	}
+	return &m, nil
+}
+
+func (b *PostgresBroker) handle(qname string, m *pgMessage, handler func(msg any) error) {
+	msg, err := deserialize(m.MsgType, m.Body)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("queue", qname).
+			Str("body", string(m.Body)).
+			Str("type", m.MsgType).
+			Msg("failed to deserialize message")
+	} else if err := handler(msg); errors.Is(err, ErrRequeue) {
+		// release the lease so the message can be picked up
+		// again, once its requeue delay (if any) has passed
+		var until *time.Time
+		if delay := requeueDelay(err); delay > 0 {
+			t := time.Now().UTC().Add(delay)
+			until = &t
+		}
+		if _, err := b.db.Exec("UPDATE mq_messages SET leased_by = NULL, leased_until = $3 WHERE id = $1 AND leased_by = $2", m.ID, b.id, until); err != nil {
+			log.Error().
+				Err(err).
+				Msg("failed to requeue message")
+		}
+		return
+	} else if err != nil {
+		log.Error().
+			Err(err).
+			Str("queue", qname).
+			Str("body", string(m.Body)).
+			Msg("failed to handle message")
+	}
+	// messages which failed to be handled
+	// are dropped, same as acked ones
+	if _, err := b.db.Exec("DELETE FROM mq_messages WHERE id = $1 AND leased_by = $2", m.ID, b.id); err != nil {
+		log.Error().
+			Err(err).
+			Msg("failed to ack message")
+	}
+}
+
+func (b *PostgresBroker) Queues(ctx context.Context) ([]QueueInfo, error) {
+	now := time.Now().UTC()
+	infos := make(map[string]*QueueInfo)
+	info := func(qname string) *QueueInfo {
+		qi, ok := infos[qname]
+		if !ok {
+			qi = &QueueInfo{Name: qname}
+			infos[qname] = qi
+		}
+		return qi
+	}
+	sizes := []struct {
+		Name    string `db:"queue_name"`
+		Size    int    `db:"size"`
+		Unacked int    `db:"unacked"`
+	}{}
+	q := `SELECT queue_name, count(*) as size, count(*) FILTER (WHERE leased_until >= $1) as unacked
+	      FROM mq_messages
+	      WHERE expires_at IS NULL OR expires_at > $1
+	      GROUP BY queue_name`
+	if err := b.db.SelectContext(ctx, &sizes, q, now); err != nil {
+		return nil, errors.Wrapf(err, "error getting queue sizes")
+	}
+	for _, s := range sizes {
+		qi := info(s.Name)
+		qi.Size = s.Size
+		qi.Unacked = s.Unacked
+	}
+	subscribers := []struct {
+		Name  string `db:"queue_name"`
+		Count int    `db:"subscribers"`
+	}{}
+	q = `SELECT queue_name, count(*) as subscribers
+	     FROM mq_subscribers
+	     WHERE last_heartbeat_at >= $1
+	     GROUP BY queue_name`
+	if err := b.db.SelectContext(ctx, &subscribers, q, now.Add(-b.leaseTTL)); err != nil {
+		return nil, errors.Wrapf(err, "error getting queue subscribers")
+	}
+	for _, s := range subscribers {
+		info(s.Name).Subscribers = s.Count
+	}
+	result := make([]QueueInfo, 0, len(infos))
+	for _, qi := range infos {
+		result = append(result, *qi)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+func (b *PostgresBroker) PublishTask(ctx context.Context, qname string, t *tork.Task) error {
+	return b.publish(ctx, qname, t)
+}
+
+func (b *PostgresBroker) SubscribeForTasks(qname string, handler func(t *tork.Task) error) error {
+	return b.subscribe(qname, func(msg any) error {
+		t, ok := msg.(*tork.Task)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Task but got %T", msg)
+		}
+		return handler(t)
+	})
+}
+
+func (b *PostgresBroker) UnsubscribeForTasks(ctx context.Context, qname string) error {
+	b.mu.Lock()
+	var cancelled []*pgSubscription
+	subs := make([]*pgSubscription, 0, len(b.subscriptions))
+	for _, sub := range b.subscriptions {
+		if sub.qname == qname {
+			cancelled = append(cancelled, sub)
+		} else {
+			subs = append(subs, sub)
+		}
+	}
+	b.subscriptions = subs
+	b.mu.Unlock()
+	for _, sub := range cancelled {
+		log.Debug().
+			Msgf("cancelling subscription on %s", sub.qname)
+		close(sub.stop)
+		if _, err := b.db.ExecContext(ctx, "DELETE FROM mq_subscribers WHERE id = $1", sub.id); err != nil {
+			return errors.Wrapf(err, "error removing subscriber for %s", qname)
+		}
+	}
+	return nil
+}
+
+func (b *PostgresBroker) PublishTaskProgress(ctx context.Context, t *tork.Task) error {
+	return b.publish(ctx, QUEUE_PROGRESS, t)
+}
+
+func (b *PostgresBroker) SubscribeForTaskProgress(handler func(t *tork.Task) error) error {
+	return b.subscribe(QUEUE_PROGRESS, func(msg any) error {
+		t, ok := msg.(*tork.Task)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Task but got %T", msg)
+		}
+		return handler(t)
+	})
+}
+
+func (b *PostgresBroker) PublishHeartbeat(ctx context.Context, n *tork.Node) error {
+	return b.publish(ctx, QUEUE_HEARTBEAT, n)
+}
+
+func (b *PostgresBroker) SubscribeForHeartbeats(handler func(n *tork.Node) error) error {
+	return b.subscribe(QUEUE_HEARTBEAT, func(msg any) error {
+		n, ok := msg.(*tork.Node)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Node but got %T", msg)
+		}
+		return handler(n)
+	})
+}
+
+func (b *PostgresBroker) PublishJob(ctx context.Context, j *tork.Job) error {
+	return b.publish(ctx, QUEUE_JOBS, j)
+}
+
+func (b *PostgresBroker) SubscribeForJobs(handler func(j *tork.Job) error) error {
+	return b.subscribe(QUEUE_JOBS, func(msg any) error {
+		j, ok := msg.(*tork.Job)
+		if !ok {
+			return errors.Errorf("expecting a *tork.Job but got %T", msg)
+		}
+		return handler(j)
+	})
+}
+
+func (b *PostgresBroker) PublishTaskLogPart(ctx context.Context, p *tork.TaskLogPart) error {
+	return b.publish(ctx, QUEUE_LOGS, p)
+}
+
+func (b *PostgresBroker) SubscribeForTaskLogPart(handler func(p *tork.TaskLogPart)) error {
+	return b.subscribe(QUEUE_LOGS, func(msg any) error {
+		p, ok := msg.(*tork.TaskLogPart)
+		if !ok {
+			return errors.Errorf("expecting a *tork.TaskLogPart but got %T", msg)
+		}
+		handler(p)
+		return nil
+	})
+}
+
+func (b *PostgresBroker) PublishEvent(ctx context.Context, topic string, event any) error {
+	body, err := serialize(event)
+	if err != nil {
+		return err
+	}
+	ev := pgEvent{
+		Topic: topic,
+		Type:  fmt.Sprintf("%T", event),
+		Body:  body,
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return errors.Wrapf(err, "unable to serialize event")
+	}
+	if len(payload) <= pgMaxNotifyPayload {
+		if _, err := b.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", b.channelEvents, string(payload)); err != nil {
+			return errors.Wrapf(err, "unable to publish event to %s", topic)
+		}
+		return nil
+	}
+	// the event is too large to be sent inline
+	// so the subscribers fetch it by its ID
+	q := `WITH e AS (
+	        INSERT INTO mq_events (topic, msg_type, body, created_at)
+	        VALUES ($2, $3, $4, $5)
+	        RETURNING id, topic, msg_type
+	      )
+	      SELECT pg_notify($1, json_build_object('id', id, 'topic', topic, 'type', msg_type)::text) FROM e`
+	if _, err := b.db.ExecContext(ctx, q, b.channelEvents, topic, ev.Type, body, time.Now().UTC()); err != nil {
+		return errors.Wrapf(err, "unable to publish event to %s", topic)
+	}
+	return nil
+}
+
+func (b *PostgresBroker) SubscribeForEvents(ctx context.Context, pattern string, handler func(event any)) error {
+	sub := &pgEventSubscription{
+		pattern: pattern,
+		ch:      make(chan *pgEvent, pgEventBufferSize),
+		stop:    make(chan any),
+	}
+	b.mu.Lock()
+	b.events = append(b.events, sub)
+	b.mu.Unlock()
+	go func() {
+		for {
+			select {
+			case <-sub.stop:
+				return
+			case ev := <-sub.ch:
+				msg, err := deserialize(ev.Type, ev.Body)
+				if err != nil {
+					log.Error().
+						Err(err).
+						Str("topic", ev.Topic).
+						Str("type", ev.Type).
+						Msg("failed to deserialize event")
+					continue
+				}
+				handler(msg)
+			}
+		}
+	}()
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				b.unsubscribeForEvents(sub)
+			case <-sub.stop:
+			}
+		}()
+	}
+	return nil
+}
+
+func (b *PostgresBroker) unsubscribeForEvents(sub *pgEventSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, s := range b.events {
+		if s == sub {
+			b.events = append(b.events[:i], b.events[i+1:]...)
+			close(sub.stop)
+			return
+		}
+	}
+}
+
+func (b *PostgresBroker) HealthCheck(ctx context.Context) error {
+	if b.isShuttingDown() {
+		return errors.New("broker is shutting down")
+	}
+	if err := b.db.PingContext(ctx); err != nil {
+		return errors.Wrapf(err, "error pinging postgres")
+	}
+	return nil
+}
+
+func (b *PostgresBroker) isShuttingDown() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.shuttingDown
+}
+
+func (b *PostgresBroker) Shutdown(ctx context.Context) error {
+	// when running in standalone mode both the coordinator
+	// and the worker will attempt to shutdown the broker
+	if b.isShuttingDown() {
+		return nil
+	}
+	b.mu.Lock()
+	b.shuttingDown = true
+	subscriptions := b.subscriptions
+	b.subscriptions = []*pgSubscription{}
+	events := b.events
+	b.events = []*pgEventSubscription{}
+	b.mu.Unlock()
+	for _, sub := range events {
+		close(sub.stop)
+	}
+	for _, sub := range subscriptions {
+		log.Debug().
+			Msgf("shutting down subscription on %s", sub.qname)
+		close(sub.stop)
+	}
+	// let's give the subscribers a grace
+	// period to allow them to cleanly exit
+	for _, sub := range subscriptions {
+		// skip non-coordinator queue
+		if !IsCoordinatorQueue(sub.qname) {
+			continue
+		}
+		log.Debug().
+			Msgf("waiting for subscription %s to terminate", sub.qname)
+		select {
+		case <-ctx.Done():
+		case <-sub.done:
+		}
+	}
+	close(b.stop)
+	<-b.done
+	if _, err := b.db.ExecContext(ctx, "DELETE FROM mq_subscribers WHERE broker_id = $1", b.id); err != nil {
+		log.Error().
+			Err(err).
+			Msg("error removing subscribers")
+	}
+	// exclusive queues don't outlive their subscriber
+	for _, sub := range subscriptions {
+		if !strings.HasPrefix(sub.qname, QUEUE_EXCLUSIVE_PREFIX) {
+			continue
+		}
+		if _, err := b.db.ExecContext(ctx, "DELETE FROM mq_messages WHERE queue_name = $1", sub.qname); err != nil {
+			log.Error().
+				Err(err).
+				Msgf("error deleting exclusive queue %s", sub.qname)
+		}
+	}
+	// messages which are still being handled
+	// are returned to their queue
+	if _, err := b.db.ExecContext(ctx, "UPDATE mq_messages SET leased_by = NULL, leased_until = NULL WHERE leased_by = $1", b.id); err != nil {
+		log.Error().
+			Err(err).
+			Msg("error releasing message leases")
+	}
+	if err := b.listener.Close(); err != nil {
+		log.Error().
+			Err(err).
+			Msg("error closing postgres listener")
+	}
+	if err := b.db.Close(); err != nil {
+		log.Error().
+			Err(err).
+			Msg("error closing postgres connection")
+	}
+	return nil
+}
@@ -0,0 +1,381 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/runabol/tork"
+	schema "github.com/runabol/tork/db/postgres"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPostgresTestBroker(t *testing.T, opts ...PostgresOption) *PostgresBroker {
+	schemaName := fmt.Sprintf("tork%d", rand.Int())
+	dsn := `host=localhost user=tork password=tork dbname=tork search_path=%s sslmode=disable`
+	db, err := sqlx.Connect("postgres", fmt.Sprintf(dsn, schemaName))
+	assert.NoError(t, err)
+	_, err = db.Exec(fmt.Sprintf("create schema %s", schemaName))
+	assert.NoError(t, err)
+	_, err = db.Exec(schema.SCHEMA)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_, err := db.Exec(fmt.Sprintf("drop schema %s cascade", schemaName))
+		assert.NoError(t, err)
+		assert.NoError(t, db.Close())
+	})
+	b, err := NewPostgresBroker(fmt.Sprintf(dsn, schemaName), opts...)
+	assert.NoError(t, err)
+	return b
+}
+
+func TestPostgresPublishAndSubsribeForTask(t *testing.T) {
+	ctx := context.Background()
+	b := newPostgresTestBroker(t)
+	processed := make(chan any)
+	qname := fmt.Sprintf("%stest-%s", QUEUE_EXCLUSIVE_PREFIX, uuid.NewUUID())
+	err := b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		processed <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishTask(ctx, qname, &tork.Task{})
+	<-processed
+	assert.NoError(t, err)
+}
+
+func TestPostgresPublishBeforeSubscribe(t *testing.T) {
+	ctx := context.Background()
+	b := newPostgresTestBroker(t)
+	qname := fmt.Sprintf("test.%s", uuid.NewUUID())
+	err := b.PublishTask(ctx, qname, &tork.Task{ID: "1234"})
+	assert.NoError(t, err)
+	processed := make(chan *tork.Task)
+	err = b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		processed <- t
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", (<-processed).ID)
+}
+
+func TestPostgresGetQueues(t *testing.T) {
+	ctx := context.Background()
+	b := newPostgresTestBroker(t)
+	qname := fmt.Sprintf("%stest-%s", QUEUE_EXCLUSIVE_PREFIX, uuid.NewUUID())
+	err := b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishTask(ctx, "some-queue", &tork.Task{})
+	assert.NoError(t, err)
+	qis, err := b.Queues(ctx)
+	assert.NoError(t, err)
+	found := map[string]QueueInfo{}
+	for _, qi := range qis {
+		found[qi.Name] = qi
+	}
+	assert.Contains(t, found, qname)
+	assert.Equal(t, 1, found[qname].Subscribers)
+	assert.Contains(t, found, "some-queue")
+	assert.Equal(t, 1, found["some-queue"].Size)
+	assert.Equal(t, 0, found["some-queue"].Subscribers)
+}
+
+func TestPostgresGetQueuesUnacked(t *testing.T) {
+	ctx := context.Background()
+	b := newPostgresTestBroker(t)
+	qname := fmt.Sprintf("test-%s", uuid.NewUUID())
+	processing := make(chan any)
+	release := make(chan any)
+	err := b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		close(processing)
+		<-release
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishTask(ctx, qname, &tork.Task{})
+	assert.NoError(t, err)
+	<-processing
+	qis, err := b.Queues(ctx)
+	assert.NoError(t, err)
+	found := false
+	for _, qi := range qis {
+		if qi.Name == qname {
+			found = true
+			assert.Equal(t, 1, qi.Size)
+			assert.Equal(t, 1, qi.Unacked)
+		}
+	}
+	assert.True(t, found)
+	close(release)
+}
+
+func TestPostgresPublishAndSubsribeForHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	b := newPostgresTestBroker(t)
+	processed := make(chan any)
+	err := b.SubscribeForHeartbeats(func(n *tork.Node) error {
+		processed <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishHeartbeat(ctx, &tork.Node{})
+	<-processed
+	assert.NoError(t, err)
+}
+
+func TestPostgresHeartbeatTTL(t *testing.T) {
+	ctx := context.Background()
+	b := newPostgresTestBroker(t, WithPostgresHeartbeatTTL(time.Millisecond*100))
+	err := b.PublishHeartbeat(ctx, &tork.Node{})
+	assert.NoError(t, err)
+	time.Sleep(time.Millisecond * 200)
+	processed := make(chan any, 1)
+	err = b.SubscribeForHeartbeats(func(n *tork.Node) error {
+		processed <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	time.Sleep(time.Millisecond * 500)
+	assert.Len(t, processed, 0)
+}
+
+func TestPostgresPublishAndSubsribeForJob(t *testing.T) {
+	ctx := context.Background()
+	b := newPostgresTestBroker(t)
+	processed := make(chan any)
+	err := b.SubscribeForJobs(func(j *tork.Job) error {
+		processed <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	err = b.PublishJob(ctx, &tork.Job{})
+	<-processed
+	assert.NoError(t, err)
+}
+
+func TestPostgresMultipleSubscribers(t *testing.T) {
+	ctx := context.Background()
+	b := newPostgresTestBroker(t)
+	qname := fmt.Sprintf("test-%s", uuid.NewUUID())
+	mu := sync.Mutex{}
+	seen := map[string]int{}
+	wg := sync.WaitGroup{}
+	wg.Add(10)
+	for i := 0; i < 2; i++ {
+		err := b.SubscribeForTasks(qname, func(t *tork.Task) error {
+			mu.Lock()
+			defer mu.Unlock()
+			seen[t.ID] = seen[t.ID] + 1
+			wg.Done()
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+	for i := 0; i < 10; i++ {
+		err := b.PublishTask(ctx, qname, &tork.Task{ID: uuid.NewUUID()})
+		assert.NoError(t, err)
+	}
+	wg.Wait()
+	assert.Len(t, seen, 10)
+	for _, n := range seen {
+		assert.Equal(t, 1, n)
+	}
+}
+
+func TestPostgresRedeliverExpiredLease(t *testing.T) {
+	ctx := context.Background()
+	b1 := newPostgresTestBroker(t, WithPostgresLeaseTTL(time.Second*3))
+	qname := fmt.Sprintf("test-%s", uuid.NewUUID())
+	err := b1.PublishTask(ctx, qname, &tork.Task{ID: "1234"})
+	assert.NoError(t, err)
+	// simulate a subscriber which went away
+	// without acking the message
+	m, err := b1.next(qname)
+	assert.NoError(t, err)
+	assert.NotNil(t, m)
+	_, err = b1.db.Exec("UPDATE mq_messages SET leased_by = 'gone' WHERE id = $1", m.ID)
+	assert.NoError(t, err)
+	m, err = b1.next(qname)
+	assert.NoError(t, err)
+	assert.Nil(t, m)
+	processed := make(chan *tork.Task)
+	err = b1.SubscribeForTasks(qname, func(t *tork.Task) error {
+		processed <- t
+		return nil
+	})
+	assert.NoError(t, err)
+	select {
+	case tk := <-processed:
+		assert.Equal(t, "1234", tk.ID)
+	case <-time.After(time.Second * 10):
+		t.Fatal("message was not redelivered")
+	}
+}
+
+func TestPostgresShutdown(t *testing.T) {
+	ctx := context.Background()
+	b := newPostgresTestBroker(t)
+	mu := sync.Mutex{}
+	qname := fmt.Sprintf("%stest-%s", QUEUE_EXCLUSIVE_PREFIX, uuid.NewUUID())
+	err := b.SubscribeForTasks(qname, func(t1 *tork.Task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		// should not be able to block
+		// the termination process
+		time.Sleep(time.Hour)
+		return nil
+	})
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		err = b.PublishTask(ctx, qname, &tork.Task{})
+		assert.NoError(t, err)
+	}
+	// cleanly shutdown
+	err = b.Shutdown(ctx)
+	assert.NoError(t, err)
+	// there should be no more processing past the shutdown
+	err = b.PublishTask(ctx, qname, &tork.Task{})
+	assert.Error(t, err)
+}
+
+func TestPostgresSubsribeForEvent(t *testing.T) {
+	ctx := context.Background()
+	b := newPostgresTestBroker(t)
+	defer func() {
+		// cleanly shutdown
+		err := b.Shutdown(ctx)
+		assert.NoError(t, err)
+	}()
+	j1 := &tork.Job{
+		ID:    uuid.NewUUID(),
+		State: tork.JobStateCompleted,
+	}
+	processed1 := make(chan any, 30)
+	processed2 := make(chan any, 10)
+	err := b.SubscribeForEvents(ctx, TOPIC_JOB, func(event any) {
+		j2 := event.(*tork.Job)
+		assert.Equal(t, j1.ID, j2.ID)
+		processed1 <- 1
+	})
+	assert.NoError(t, err)
+	err = b.SubscribeForEvents(ctx, TOPIC_JOB_COMPLETED, func(event any) {
+		j2 := event.(*tork.Job)
+		assert.Equal(t, j1.ID, j2.ID)
+		processed2 <- 1
+	})
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		err = b.PublishEvent(ctx, TOPIC_JOB_COMPLETED, j1)
+		assert.NoError(t, err)
+		err = b.PublishEvent(ctx, TOPIC_JOB_FAILED, j1)
+		assert.NoError(t, err)
+		err = b.PublishEvent(ctx, "job.x.y.z", j1)
+		assert.NoError(t, err)
+	}
+
+	for i := 0; i < 30; i++ {
+		<-processed1
+	}
+	close(processed1)
+	for i := 0; i < 10; i++ {
+		<-processed2
+	}
+	close(processed2)
+}
+
+func TestPostgresSubsribeForLargeEvent(t *testing.T) {
+	ctx := context.Background()
+	b := newPostgresTestBroker(t)
+	j1 := &tork.Job{
+		ID:          uuid.NewUUID(),
+		Description: strings.Repeat("x", pgMaxNotifyPayload*2),
+	}
+	processed := make(chan *tork.Job)
+	err := b.SubscribeForEvents(ctx, TOPIC_JOB, func(event any) {
+		processed <- event.(*tork.Job)
+	})
+	assert.NoError(t, err)
+	err = b.PublishEvent(ctx, TOPIC_JOB_COMPLETED, j1)
+	assert.NoError(t, err)
+	j2 := <-processed
+	assert.Equal(t, j1.ID, j2.ID)
+	assert.Equal(t, j1.Description, j2.Description)
+}
+
+func TestPostgresUnsubsribeForEvent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := newPostgresTestBroker(t)
+	processed := make(chan any, 10)
+	err := b.SubscribeForEvents(ctx, TOPIC_JOB, func(event any) {
+		processed <- 1
+	})
+	assert.NoError(t, err)
+	err = b.PublishEvent(context.Background(), TOPIC_JOB_COMPLETED, &tork.Job{})
+	assert.NoError(t, err)
+	<-processed
+	cancel()
+	time.Sleep(time.Millisecond * 100)
+	err = b.PublishEvent(context.Background(), TOPIC_JOB_COMPLETED, &tork.Job{})
+	assert.NoError(t, err)
+	time.Sleep(time.Millisecond * 100)
+	assert.Len(t, processed, 0)
+}
+
+func TestPostgresPublishUnknownEvent(t *testing.T) {
+	ctx := context.Background()
+	b := newPostgresTestBroker(t)
+	err := b.PublishEvent(ctx, TOPIC_JOB_COMPLETED, "not a thing")
+	assert.Error(t, err)
+}
+
+func TestPostgresHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	b := newPostgresTestBroker(t)
+	assert.NoError(t, b.HealthCheck(ctx))
+	assert.NoError(t, b.Shutdown(ctx))
+	assert.Error(t, b.HealthCheck(ctx))
+}
+
+func TestPostgresPublishAndSubsribeTaskLogPart(t *testing.T) {
+	ctx := context.Background()
+	b := newPostgresTestBroker(t)
+	processed := make(chan any)
+	err := b.SubscribeForTaskLogPart(func(p *tork.TaskLogPart) {
+		processed <- 1
+	})
+	assert.NoError(t, err)
+	err = b.PublishTaskLogPart(ctx, &tork.TaskLogPart{})
+	assert.NoError(t, err)
+	<-processed
+}
+
+func TestPostgresTaskPriority(t *testing.T) {
+	ctx := context.Background()
+	b := newPostgresTestBroker(t)
+	qname := fmt.Sprintf("test-%s", uuid.NewUUID())
+	for _, p := range []int{0, 1, 3, 2} {
+		err := b.PublishTask(ctx, qname, &tork.Task{
+			Priority: p,
+		})
+		assert.NoError(t, err)
+	}
+	tasks := make(chan *tork.Task, 4)
+	err := b.SubscribeForTasks(qname, func(t *tork.Task) error {
+		tasks <- t
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, (<-tasks).Priority)
+	assert.Equal(t, 2, (<-tasks).Priority)
+	assert.Equal(t, 1, (<-tasks).Priority)
+	assert.Equal(t, 0, (<-tasks).Priority)
+}
@@ -35,6 +35,9 @@ const (
 	// The prefix used for queues that
 	// are exclusive
 	QUEUE_EXCLUSIVE_PREFIX = "x-"
+	// The prefix used for the exclusive queues through which
+	// the coordinator dispatches tasks to a specific node
+	QUEUE_NODE_PREFIX = QUEUE_EXCLUSIVE_PREFIX + "node-"
 )
 
 type QueueInfo struct {
@@ -58,10 +61,23 @@ func IsCoordinatorQueue(qname string) bool {
 	return slices.Contains(coordQueues, qname)
 }
 
+// NodeQueue returns the name of the queue through which
+// tasks are dispatched to the node with the given ID.
+func NodeQueue(nodeID string) string {
+	return QUEUE_NODE_PREFIX + nodeID
+}
+
+func IsNodeQueue(qname string) bool {
+	return strings.HasPrefix(qname, QUEUE_NODE_PREFIX)
+}
+
 func IsWorkerQueue(qname string) bool {
 	return !IsCoordinatorQueue(qname)
 }
 
 func IsTaskQueue(qname string) bool {
+	if IsNodeQueue(qname) {
+		return true
+	}
 	return !IsCoordinatorQueue(qname) && !strings.HasPrefix(qname, QUEUE_EXCLUSIVE_PREFIX)
 }
@@ -24,3 +24,10 @@ func TestIsCoordinatorQueue(t *testing.T) {
 	assert.Equal(t, true, mq.IsCoordinatorQueue(mq.QUEUE_STARTED))
 	assert.Equal(t, true, mq.IsCoordinatorQueue(mq.QUEUE_PENDING))
 }
+
+func TestNodeQueue(t *testing.T) {
+	assert.Equal(t, "x-node-1234", mq.NodeQueue("1234"))
+	assert.True(t, mq.IsNodeQueue(mq.NodeQueue("1234")))
+	assert.True(t, mq.IsTaskQueue(mq.NodeQueue("1234")))
+	assert.False(t, mq.IsNodeQueue("x-1234"))
+}
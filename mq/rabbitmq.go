@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -29,6 +31,9 @@ const (
 	defaultHeartbeatTTL = 60000
 	defaultPriority     = uint8(0)
 	maxPriority         = uint8(9)
+	// the prefix of the queues on which requeued
+	// messages wait out their redelivery delay
+	delayQueuePrefix = QUEUE_EXCLUSIVE_PREFIX + "delay-"
 )
 
 type RabbitMQBroker struct {
@@ -47,10 +52,11 @@ type RabbitMQBroker struct {
 }
 
 type subscription struct {
-	qname string
-	ch    *amqp.Channel
-	name  string
-	done  chan int
+	qname     string
+	ch        *amqp.Channel
+	name      string
+	done      chan int
+	cancelled atomic.Bool
 }
 
 type rabbitq struct {
@@ -193,6 +199,30 @@ func (b *RabbitMQBroker) SubscribeForTasks(qname string, handler func(t *tork.Ta
 	})
 }
 
+func (b *RabbitMQBroker) UnsubscribeForTasks(ctx context.Context, qname string) error {
+	b.mu.Lock()
+	var cancelled []*subscription
+	subs := make([]*subscription, 0, len(b.subscriptions))
+	for _, sub := range b.subscriptions {
+		if sub.qname == qname {
+			cancelled = append(cancelled, sub)
+		} else {
+			subs = append(subs, sub)
+		}
+	}
+	b.subscriptions = subs
+	b.mu.Unlock()
+	for _, sub := range cancelled {
+		log.Debug().
+			Msgf("cancelling subscription on %s", sub.qname)
+		sub.cancelled.Store(true)
+		if err := sub.ch.Cancel(sub.name, false); err != nil {
+			return errors.Wrapf(err, "error cancelling subscription on %s", qname)
+		}
+	}
+	return nil
+}
+
 func (b *RabbitMQBroker) subscribe(exchange, key, qname string, handler func(msg any) error) error {
 	conn, err := b.getConnection()
 	if err != nil {
@@ -241,7 +271,13 @@ func (b *RabbitMQBroker) subscribe(exchange, key, qname string, handler func(msg
 					Str("type", (string(d.Type))).
 					Msg("failed to deserialized message")
 			} else {
-				if err := handler(msg); err != nil {
+				if err := handler(msg); errors.Is(err, ErrRequeue) {
+					if err := b.requeue(ch, qname, d, requeueDelay(err)); err != nil {
+						log.Error().
+							Err(err).
+							Msg("failed to requeue message")
+					}
+				} else if err != nil {
 					log.Error().
 						Err(err).
 						Str("queue", qname).
@@ -261,6 +297,15 @@ func (b *RabbitMQBroker) subscribe(exchange, key, qname string, handler func(msg
 				}
 			}
 		}
+		// the subscription was cancelled rather than lost
+		if sub.cancelled.Load() {
+			if err := ch.Close(); err != nil {
+				log.Error().
+					Err(err).
+					Msgf("error closing channel for %s", qname)
+			}
+			return
+		}
 		maxAttempts := 20
 		for attempt := 1; !b.shuttingDown && attempt <= maxAttempts; attempt++ {
 			log.Info().Msgf("%s channel closed. reconnecting", qname)
@@ -334,13 +379,26 @@ func (b *RabbitMQBroker) declareQueue(exchange, key, qname string, ch *amqp.Chan
 		args["x-max-priority"] = maxPriority
 	}
 	args["x-consumer-timeout"] = b.consumerTimeout
+	durable := b.durable
+	autoDelete := false
+	exclusive := strings.HasPrefix(qname, QUEUE_EXCLUSIVE_PREFIX)
+	if IsNodeQueue(qname) {
+		// a node queue is consumed through several of the
+		// node's connections, so rather than being exclusive
+		// to one of them it's deleted with its last consumer.
+		// the tasks still on it are then dispatched again by
+		// the coordinator's reaper once the node is offline
+		durable = false
+		autoDelete = true
+		exclusive = false
+	}
 	_, err := ch.QueueDeclare(
 		qname,
-		b.durable,
-		false, // delete when unused
-		strings.HasPrefix(qname, QUEUE_EXCLUSIVE_PREFIX), // exclusive
-		false, // no-wait
-		args,  // arguments
+		durable,
+		autoDelete, // delete when unused
+		exclusive,  // exclusive
+		false,      // no-wait
+		args,       // arguments
 	)
 	if err != nil {
 		return err
@@ -355,6 +413,66 @@ func (b *RabbitMQBroker) declareQueue(exchange, key, qname string, ch *amqp.Chan
 	return nil
 }
 
+// requeue puts a delivery back on its queue. A delivery which is requeued
+// with a delay is moved to the queue's delay queue, from which it's
+// dead-lettered back to its queue once its expiration passes.
+func (b *RabbitMQBroker) requeue(ch *amqp.Channel, qname string, d amqp.Delivery, delay time.Duration) error {
+	if delay <= 0 {
+		return d.Nack(false, true)
+	}
+	dname := delayQueuePrefix + qname
+	if err := b.declareDelayQueue(dname, qname, ch); err != nil {
+		log.Error().
+			Err(err).
+			Str("queue", qname).
+			Msg("failed to declare delay queue. requeueing immediately")
+		return d.Nack(false, true)
+	}
+	err := ch.PublishWithContext(context.Background(),
+		exchangeDefault, // exchange
+		dname,           // routing key
+		false,           // mandatory
+		false,           // immediate
+		amqp.Publishing{
+			Type:        d.Type,
+			ContentType: d.ContentType,
+			Body:        d.Body,
+			Priority:    d.Priority,
+			Expiration:  strconv.FormatInt(delay.Milliseconds(), 10),
+		})
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("queue", qname).
+			Msg("failed to delay message. requeueing immediately")
+		return d.Nack(false, true)
+	}
+	return d.Ack(false)
+}
+
+func (b *RabbitMQBroker) declareDelayQueue(dname, qname string, ch *amqp.Channel) error {
+	if _, ok := b.queues.Get(dname); ok {
+		return nil
+	}
+	log.Debug().Msgf("declaring delay queue: %s", dname)
+	_, err := ch.QueueDeclare(
+		dname,
+		b.durable && !IsNodeQueue(qname),
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		amqp.Table{
+			"x-dead-letter-exchange":    exchangeDefault,
+			"x-dead-letter-routing-key": qname,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	b.queues.Set(dname, dname)
+	return nil
+}
+
 func (b *RabbitMQBroker) PublishHeartbeat(ctx context.Context, n *tork.Node) error {
 	return b.publish(ctx, exchangeDefault, QUEUE_HEARTBEAT, n)
 }
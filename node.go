@@ -2,6 +2,9 @@ package tork
 
 import (
 	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 )
 
 var LAST_HEARTBEAT_TIMEOUT = time.Minute * 5
@@ -13,20 +16,29 @@ const (
 	NodeStatusUP      NodeStatus = "UP"
 	NodeStatusDown    NodeStatus = "DOWN"
 	NodeStatusOffline NodeStatus = "OFFLINE"
+	// NodeStatusDraining is the status of a node which
+	// finishes the tasks it runs before shutting down.
+	NodeStatusDraining NodeStatus = "DRAINING"
 )
 
 type Node struct {
-	ID              string     `json:"id,omitempty"`
-	Name            string     `json:"name,omitempty"`
-	StartedAt       time.Time  `json:"startedAt,omitempty"`
-	CPUPercent      float64    `json:"cpuPercent,omitempty"`
-	LastHeartbeatAt time.Time  `json:"lastHeartbeatAt,omitempty"`
-	Queue           string     `json:"queue,omitempty"`
-	Status          NodeStatus `json:"status,omitempty"`
-	Hostname        string     `json:"hostname,omitempty"`
-	Port            int        `json:"port,omitempty"`
-	TaskCount       int        `json:"taskCount,omitempty"`
-	Version         string     `json:"version"`
+	ID              string            `json:"id,omitempty"`
+	Name            string            `json:"name,omitempty"`
+	StartedAt       time.Time         `json:"startedAt,omitempty"`
+	CPUPercent      float64           `json:"cpuPercent,omitempty"`
+	LastHeartbeatAt time.Time         `json:"lastHeartbeatAt,omitempty"`
+	Queue           string            `json:"queue,omitempty"`
+	Status          NodeStatus        `json:"status,omitempty"`
+	Hostname        string            `json:"hostname,omitempty"`
+	Port            int               `json:"port,omitempty"`
+	TaskCount       int               `json:"taskCount,omitempty"`
+	Version         string            `json:"version"`
+	CPUs            float64           `json:"cpus,omitempty"`
+	Memory          int64             `json:"memory,omitempty"`
+	AllocatedCPUs   float64           `json:"allocatedCpus,omitempty"`
+	AllocatedMemory int64             `json:"allocatedMemory,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Queues          []string          `json:"queues,omitempty"`
 }
 
 func (n *Node) Clone() *Node {
@@ -42,5 +54,11 @@ func (n *Node) Clone() *Node {
 		Port:            n.Port,
 		TaskCount:       n.TaskCount,
 		Version:         n.Version,
+		CPUs:            n.CPUs,
+		Memory:          n.Memory,
+		AllocatedCPUs:   n.AllocatedCPUs,
+		AllocatedMemory: n.AllocatedMemory,
+		Labels:          maps.Clone(n.Labels),
+		Queues:          slices.Clone(n.Queues),
 	}
 }
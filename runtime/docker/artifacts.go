@@ -0,0 +1,93 @@
+package docker
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	dockerarchive "github.com/docker/docker/pkg/archive"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/artifact"
+)
+
+// artifactPath resolves the path of an artifact
+// relative to the task's working directory.
+func artifactPath(t *tork.Task, p string) string {
+	if path.IsAbs(p) {
+		return path.Clean(p)
+	}
+	return path.Join(t.Workdir, p)
+}
+
+// downloadArtifacts fetches the task's input artifacts from the
+// store and copies them into the (not yet started) container.
+func (d *DockerRuntime) downloadArtifacts(ctx context.Context, containerID string, t *tork.Task) error {
+	if t.Artifacts == nil || len(t.Artifacts.Inputs) == 0 {
+		return nil
+	}
+	dir, err := os.MkdirTemp("", "tork-artifacts-*")
+	if err != nil {
+		return errors.Wrapf(err, "error creating temp artifacts dir")
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Error().Err(err).Msgf("error removing temp artifacts dir: %s", dir)
+		}
+	}()
+	files := make([]string, len(t.Artifacts.Inputs))
+	for i, a := range t.Artifacts.Inputs {
+		rel := strings.TrimPrefix(artifactPath(t, a.Path), "/")
+		if err := artifact.Download(ctx, d.artifacts, t.JobID, a.Name, filepath.Join(dir, rel)); err != nil {
+			return err
+		}
+		files[i] = rel
+	}
+	ar, err := dockerarchive.TarWithOptions(dir, &dockerarchive.TarOptions{IncludeFiles: files})
+	if err != nil {
+		return errors.Wrapf(err, "error archiving artifacts")
+	}
+	defer ar.Close()
+	return d.client.CopyToContainer(ctx, containerID, "/", ar, types.CopyToContainerOptions{})
+}
+
+// uploadArtifacts copies the task's output artifacts
+// out of the container and puts them in the store.
+func (d *DockerRuntime) uploadArtifacts(ctx context.Context, containerID string, t *tork.Task) error {
+	if t.Artifacts == nil {
+		return nil
+	}
+	for _, a := range t.Artifacts.Outputs {
+		if err := d.uploadArtifact(ctx, containerID, t, a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DockerRuntime) uploadArtifact(ctx context.Context, containerID string, t *tork.Task, a tork.TaskArtifact) error {
+	p := artifactPath(t, a.Path)
+	r, _, err := d.client.CopyFromContainer(ctx, containerID, p)
+	if err != nil {
+		return errors.Wrapf(err, "error reading artifact %s", a.Name)
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			log.Error().Err(err).Msgf("error closing %s reader", p)
+		}
+	}()
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return errors.Wrapf(err, "error reading artifact %s", a.Name)
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return errors.Errorf("artifact %s: %s is not a regular file", a.Name, p)
+	}
+	return d.artifacts.Put(ctx, t.JobID, a.Name, tr, hdr.Size)
+}
@@ -41,6 +41,34 @@ func getRegistryCredentials(configFile string, hostname string) (string, string,
 	return cfg.getRegistryCredentials(hostname)
 }
 
+// RegistryCredentials gets the credentials, if any, for the
+// registry hosting the passed in image. Images without an
+// explicit registry domain have no credentials.
+func RegistryCredentials(configFile string, image string) (string, string, error) {
+	ref, err := parseRef(image)
+	if err != nil {
+		return "", "", err
+	}
+	if ref.domain == "" {
+		return "", "", nil
+	}
+	return getRegistryCredentials(configFile, ref.domain)
+}
+
+// RegistryDomain returns the domain of the registry hosting
+// the passed in image, defaulting to docker.io for images
+// without an explicit registry domain.
+func RegistryDomain(image string) (string, error) {
+	ref, err := parseRef(image)
+	if err != nil {
+		return "", err
+	}
+	if ref.domain == "" {
+		return "docker.io", nil
+	}
+	return ref.domain, nil
+}
+
 // GetRegistryCredentials gets credentials, if any, for the provided hostname
 //
 // Hostnames should already be resolved using `ResolveRegistryAuth`
@@ -2,7 +2,11 @@ package docker
 
 import (
 	"encoding/base64"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestDecodeBase64Auth(t *testing.T) {
@@ -30,6 +34,37 @@ func TestGetRegistryCredentials(t *testing.T) {
 	})
 }
 
+func TestRegistryCredentials(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	auth := base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	err := os.WriteFile(configFile, []byte(`{"auths":{"registry.example.com":{"auth":"`+auth+`"}}}`), 0600)
+	assert.NoError(t, err)
+
+	u, p, err := RegistryCredentials(configFile, "registry.example.com/some/image:latest")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", u)
+	assert.Equal(t, "pass", p)
+
+	u, p, err = RegistryCredentials(configFile, "ubuntu:mantic")
+	assert.NoError(t, err)
+	assert.Empty(t, u)
+	assert.Empty(t, p)
+}
+
+func TestRegistryDomain(t *testing.T) {
+	d, err := RegistryDomain("registry.example.com/some/image:latest")
+	assert.NoError(t, err)
+	assert.Equal(t, "registry.example.com", d)
+
+	d, err = RegistryDomain("localhost:5000/image")
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:5000", d)
+
+	d, err = RegistryDomain("ubuntu:mantic")
+	assert.NoError(t, err)
+	assert.Equal(t, "docker.io", d)
+}
+
 type base64TestCase struct {
 	name    string
 	config  authConfig
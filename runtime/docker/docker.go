@@ -30,6 +30,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/artifact"
 	"github.com/runabol/tork/internal/syncx"
 	"github.com/runabol/tork/internal/uuid"
 	"github.com/runabol/tork/mq"
@@ -46,14 +47,15 @@ const (
 var rootUserPattern = regexp.MustCompile(`^(|root|0|root(:root)?|root:0|0:root|0:0)$`)
 
 type DockerRuntime struct {
-	client  *client.Client
-	tasks   *syncx.Map[string, string]
-	images  *syncx.Map[string, bool]
-	pullq   chan *pullRequest
-	mounter runtime.Mounter
-	broker  mq.Broker
-	config  string
-	sandbox bool
+	client    *client.Client
+	tasks     *syncx.Map[string, string]
+	images    *syncx.Map[string, bool]
+	pullq     chan *pullRequest
+	mounter   runtime.Mounter
+	broker    mq.Broker
+	config    string
+	sandbox   bool
+	artifacts artifact.Store
 }
 
 type dockerLogsReader struct {
@@ -99,6 +101,12 @@ func WithSandbox(val bool) Option {
 	}
 }
 
+func WithArtifactStore(store artifact.Store) Option {
+	return func(rt *DockerRuntime) {
+		rt.artifacts = store
+	}
+}
+
 func NewDockerRuntime(opts ...Option) (*DockerRuntime, error) {
 	dc, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
@@ -191,6 +199,9 @@ func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Write
 	if t.ID == "" {
 		return errors.New("task id is required")
 	}
+	if t.Artifacts != nil && d.artifacts == nil {
+		return errors.New("artifact store is not configured")
+	}
 	if err := d.imagePull(ctx, t, logger); err != nil {
 		return errors.Wrapf(err, "error pulling image: %s", t.Image)
 	}
@@ -324,11 +335,11 @@ func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Write
 	}
 	// we want to override the default
 	// image WORKDIR only if the task
-	// introduces work files _or_ if the
-	// user specifies a WORKDIR
+	// introduces work files or artifacts
+	// _or_ if the user specifies a WORKDIR
 	if t.Workdir != "" {
 		containerConf.WorkingDir = t.Workdir
-	} else if len(t.Files) > 0 {
+	} else if len(t.Files) > 0 || t.Artifacts != nil {
 		t.Workdir = defaultWorkdir
 		containerConf.WorkingDir = t.Workdir
 	}
@@ -382,6 +393,9 @@ func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Write
 	if err := d.initWorkDir(ctx, resp.ID, t); err != nil {
 		return errors.Wrapf(err, "error initializing workdir")
 	}
+	if err := d.downloadArtifacts(ctx, resp.ID, t); err != nil {
+		return errors.Wrapf(err, "error downloading artifacts")
+	}
 
 	// start the container
 	log.Debug().Msgf("Starting container %s", resp.ID)
@@ -452,6 +466,9 @@ func (d *DockerRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Write
 				return err
 			}
 			t.Result = stdout
+			if err := d.uploadArtifacts(ctx, resp.ID, t); err != nil {
+				return errors.Wrapf(err, "error uploading artifacts")
+			}
 		}
 		log.Debug().
 			Int64("status-code", status.StatusCode).
@@ -717,19 +734,13 @@ func (d *DockerRuntime) doPullRequest(pr *pullRequest) error {
 				Password: pr.registry.password,
 			}
 		} else {
-			ref, err := parseRef(pr.image)
+			username, password, err := RegistryCredentials(d.config, pr.image)
 			if err != nil {
 				return err
 			}
-			if ref.domain != "" {
-				username, password, err := getRegistryCredentials(d.config, ref.domain)
-				if err != nil {
-					return err
-				}
-				authConfig = regtypes.AuthConfig{
-					Username: username,
-					Password: password,
-				}
+			authConfig = regtypes.AuthConfig{
+				Username: username,
+				Password: password,
 			}
 		}
 
@@ -21,6 +21,7 @@ import (
 
 	mobyarchive "github.com/moby/moby/pkg/archive"
 
+	"github.com/runabol/tork/artifact/local"
 	"github.com/runabol/tork/internal/uuid"
 	"github.com/runabol/tork/mq"
 	"github.com/runabol/tork/runtime"
@@ -473,6 +474,44 @@ func TestRunTaskInitWorkdirLs(t *testing.T) {
 	assert.Equal(t, "hello.txt\nlarge.txt\n", t1.Result)
 }
 
+func TestRunTaskWithArtifacts(t *testing.T) {
+	store, err := local.NewLocalStore(t.TempDir())
+	assert.NoError(t, err)
+	rt, err := NewDockerRuntime(WithArtifactStore(store))
+	assert.NoError(t, err)
+	jobID := uuid.NewUUID()
+	t1 := &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: jobID,
+		Image: "ubuntu:mantic",
+		Run:   "echo -n hello world > report.txt",
+		Artifacts: &tork.TaskArtifacts{
+			Outputs: []tork.TaskArtifact{{Name: "report", Path: "report.txt"}},
+		},
+	}
+	ctx := context.Background()
+	err = rt.Run(ctx, t1)
+	assert.NoError(t, err)
+	t2 := &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: jobID,
+		Image: "ubuntu:mantic",
+		Run:   "cat /data/in/report.txt > $TORK_OUTPUT",
+		Artifacts: &tork.TaskArtifacts{
+			Inputs: []tork.TaskArtifact{{Name: "report", Path: "/data/in/report.txt"}},
+		},
+	}
+	err = rt.Run(ctx, t2)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", t2.Result)
+}
+
+func TestArtifactPath(t *testing.T) {
+	tk := &tork.Task{Workdir: "/tork/workdir"}
+	assert.Equal(t, "/tork/workdir/out/report.txt", artifactPath(tk, "out/report.txt"))
+	assert.Equal(t, "/data/report.txt", artifactPath(tk, "/data/../data/report.txt"))
+}
+
 func TestRunTaskWithCustomMounter(t *testing.T) {
 	mounter := runtime.NewMultiMounter()
 	vmounter, err := NewVolumeMounter()
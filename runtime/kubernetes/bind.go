@@ -0,0 +1,53 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/runabol/tork"
+)
+
+// BindMounter mounts a directory of the node
+// which the task's Pod is scheduled on.
+type BindMounter struct {
+	cfg BindConfig
+}
+
+type BindConfig struct {
+	Allowed bool
+	Sources []string
+}
+
+func NewBindMounter(cfg BindConfig) *BindMounter {
+	return &BindMounter{
+		cfg: cfg,
+	}
+}
+
+func (m *BindMounter) Mount(ctx context.Context, mnt *tork.Mount) error {
+	if !m.cfg.Allowed {
+		return errors.New("bind mounts are not allowed")
+	}
+	if !m.isSourceAllowed(mnt.Source) {
+		return errors.New(fmt.Sprintf("src bind mount is not allowed: %s", mnt.Source))
+	}
+	return nil
+}
+
+func (m *BindMounter) isSourceAllowed(src string) bool {
+	if len(m.cfg.Sources) == 0 {
+		return true
+	}
+	for _, allow := range m.cfg.Sources {
+		if strings.EqualFold(allow, src) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *BindMounter) Unmount(ctx context.Context, mnt *tork.Mount) error {
+	return nil
+}
@@ -0,0 +1,38 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMountBindNotAllowed(t *testing.T) {
+	m := NewBindMounter(BindConfig{})
+	err := m.Mount(context.Background(), &tork.Mount{
+		Type:   tork.MountTypeBind,
+		Source: "/data",
+		Target: "/data",
+	})
+	assert.Error(t, err)
+}
+
+func TestMountBindSources(t *testing.T) {
+	m := NewBindMounter(BindConfig{
+		Allowed: true,
+		Sources: []string{"/data"},
+	})
+	err := m.Mount(context.Background(), &tork.Mount{
+		Type:   tork.MountTypeBind,
+		Source: "/data",
+		Target: "/data",
+	})
+	assert.NoError(t, err)
+	err = m.Mount(context.Background(), &tork.Mount{
+		Type:   tork.MountTypeBind,
+		Source: "/etc",
+		Target: "/etc",
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,51 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// fileReader reads the contents of a file
+// from a running container of a Pod.
+type fileReader interface {
+	ReadFile(ctx context.Context, namespace, pod, container, path string) ([]byte, error)
+}
+
+type execFileReader struct {
+	client k8s.Interface
+	config *rest.Config
+}
+
+func (r *execFileReader) ReadFile(ctx context.Context, namespace, pod, container, path string) ([]byte, error) {
+	req := r.client.CoreV1().RESTClient().
+		Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   []string{"cat", path},
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	exec, err := remotecommand.NewSPDYExecutor(r.config, "POST", req.URL())
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating executor")
+	}
+	var stdout, stderr bytes.Buffer
+	if err := exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}); err != nil {
+		return nil, errors.Wrapf(err, "error reading %s: %s", path, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
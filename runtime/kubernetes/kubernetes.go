@@ -0,0 +1,716 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/syncx"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/mq"
+	"github.com/runabol/tork/runtime"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// defaultWorkdir is the directory where `Task.File`s are
+	// written to by default, should `Task.Workdir` not be set
+	defaultWorkdir      = "/tork/workdir"
+	defaultNamespace    = "default"
+	defaultSidecarImage = "busybox:stable"
+	defaultPollInterval = time.Second
+	taskContainer       = "task"
+	sidecarContainer    = "tork"
+	torkVolume          = "tork"
+	configVolume        = "tork-config"
+	networksAnnotation  = "k8s.v1.cni.cncf.io/networks"
+)
+
+// sidecarScript keeps the sidecar container alive so that the
+// task's output and progress files can be read from the shared
+// /tork volume even after the task container has terminated.
+const sidecarScript = `touch /tork/stdout /tork/progress
+chmod 666 /tork/stdout /tork/progress
+trap 'exit 0' TERM
+while true; do sleep 1; done`
+
+// KubernetesRuntime executes each task as a Pod.
+type KubernetesRuntime struct {
+	client       k8s.Interface
+	config       *rest.Config
+	reader       fileReader
+	tasks        *syncx.Map[string, string]
+	mounter      runtime.Mounter
+	broker       mq.Broker
+	namespace    string
+	sidecarImage string
+	pollInterval time.Duration
+}
+
+type Option = func(rt *KubernetesRuntime)
+
+func WithClient(client k8s.Interface) Option {
+	return func(rt *KubernetesRuntime) {
+		rt.client = client
+	}
+}
+
+// WithRestConfig sets the config used to exec into
+// the task's Pod in order to read its output.
+func WithRestConfig(config *rest.Config) Option {
+	return func(rt *KubernetesRuntime) {
+		rt.config = config
+	}
+}
+
+func WithMounter(mounter runtime.Mounter) Option {
+	return func(rt *KubernetesRuntime) {
+		rt.mounter = mounter
+	}
+}
+
+func WithBroker(broker mq.Broker) Option {
+	return func(rt *KubernetesRuntime) {
+		rt.broker = broker
+	}
+}
+
+func WithNamespace(namespace string) Option {
+	return func(rt *KubernetesRuntime) {
+		rt.namespace = namespace
+	}
+}
+
+func WithSidecarImage(image string) Option {
+	return func(rt *KubernetesRuntime) {
+		rt.sidecarImage = image
+	}
+}
+
+// NewClient creates a Kubernetes client from the given kubeconfig
+// file. When no file is provided, the in-cluster config is used
+// with a fallback to the default kubeconfig loading rules.
+func NewClient(kubeconfig string) (k8s.Interface, *rest.Config, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+		if err != nil {
+			config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+				clientcmd.NewDefaultClientConfigLoadingRules(),
+				&clientcmd.ConfigOverrides{},
+			).ClientConfig()
+		}
+	}
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error loading kubernetes config")
+	}
+	client, err := k8s.NewForConfig(config)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error creating kubernetes client")
+	}
+	return client, config, nil
+}
+
+func NewKubernetesRuntime(opts ...Option) (*KubernetesRuntime, error) {
+	rt := &KubernetesRuntime{
+		tasks:        new(syncx.Map[string, string]),
+		namespace:    defaultNamespace,
+		sidecarImage: defaultSidecarImage,
+		pollInterval: defaultPollInterval,
+	}
+	for _, o := range opts {
+		o(rt)
+	}
+	if rt.client == nil {
+		client, config, err := NewClient("")
+		if err != nil {
+			return nil, err
+		}
+		rt.client = client
+		rt.config = config
+	}
+	if rt.config != nil {
+		rt.reader = &execFileReader{client: rt.client, config: rt.config}
+	}
+	// setup a default mounter
+	if rt.mounter == nil {
+		rt.mounter = NewVolumeMounter(rt.client, VolumeConfig{Namespace: rt.namespace})
+	}
+	return rt, nil
+}
+
+func (d *KubernetesRuntime) Run(ctx context.Context, t *tork.Task) error {
+	// prepare mounts
+	for i, mnt := range t.Mounts {
+		mnt.ID = uuid.NewUUID()
+		err := d.mounter.Mount(ctx, &mnt)
+		if err != nil {
+			return err
+		}
+		defer func(m tork.Mount) {
+			uctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+			if err := d.mounter.Unmount(uctx, &m); err != nil {
+				log.Error().
+					Err(err).
+					Msgf("error deleting mount: %s", m)
+			}
+		}(mnt)
+		t.Mounts[i] = mnt
+	}
+	var logger io.Writer
+	if d.broker != nil {
+		logger = mq.NewLogShipper(d.broker, t.ID)
+	} else {
+		logger = os.Stdout
+	}
+	// excute pre-tasks
+	for _, pre := range t.Pre {
+		pre.ID = uuid.NewUUID()
+		pre.Mounts = t.Mounts
+		pre.Networks = t.Networks
+		pre.Limits = t.Limits
+		if err := d.doRun(ctx, pre, logger); err != nil {
+			return err
+		}
+	}
+	// run the actual task
+	if err := d.doRun(ctx, t, logger); err != nil {
+		return err
+	}
+	// execute post tasks
+	for _, post := range t.Post {
+		post.ID = uuid.NewUUID()
+		post.Mounts = t.Mounts
+		post.Networks = t.Networks
+		post.Limits = t.Limits
+		if err := d.doRun(ctx, post, logger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *KubernetesRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Writer) error {
+	if t.ID == "" {
+		return errors.New("task id is required")
+	}
+	if t.Artifacts != nil {
+		return errors.New("artifacts are not supported on kubernetes runtime")
+	}
+	name := podName(t)
+
+	// we want to create the resources using a background context
+	// in case the task is being cancelled while they are being
+	// created, which would leave them behind.
+	createCtx, createCancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer createCancel()
+
+	cm := configMap(d.namespace, name, t)
+	if _, err := d.client.CoreV1().ConfigMaps(d.namespace).Create(createCtx, cm, metav1.CreateOptions{}); err != nil {
+		return errors.Wrapf(err, "error creating config map %s", name)
+	}
+
+	// create a mapping between task id and pod name
+	d.tasks.Set(t.ID, name)
+
+	// remove the pod and its resources
+	defer func() {
+		stopContext, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		if err := d.Stop(stopContext, t); err != nil {
+			log.Error().
+				Err(err).
+				Str("pod", name).
+				Msg("error removing pod upon completion")
+		}
+	}()
+
+	pod, err := d.podSpec(name, t)
+	if err != nil {
+		return err
+	}
+
+	if t.Registry != nil {
+		secret, err := registrySecret(d.namespace, name, t)
+		if err != nil {
+			return err
+		}
+		if _, err := d.client.CoreV1().Secrets(d.namespace).Create(createCtx, secret, metav1.CreateOptions{}); err != nil {
+			return errors.Wrapf(err, "error creating registry secret %s", name)
+		}
+		pod.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: name}}
+	}
+
+	if _, err := d.client.CoreV1().Pods(d.namespace).Create(createCtx, pod, metav1.CreateOptions{}); err != nil {
+		log.Error().Msgf(
+			"Error creating pod using image %s: %v\n",
+			t.Image, err,
+		)
+		return err
+	}
+
+	log.Debug().Msgf("created pod %s", name)
+
+	// wait for the task container to start
+	if _, err := d.waitForContainer(ctx, name, func(s corev1.ContainerState) bool {
+		return s.Running != nil || s.Terminated != nil
+	}); err != nil {
+		return err
+	}
+
+	// report task progress
+	pctx, pcancel := context.WithCancel(ctx)
+	defer pcancel()
+	go d.reportProgress(pctx, name, t)
+
+	// read the task's output
+	out, err := d.client.CoreV1().Pods(d.namespace).GetLogs(name, &corev1.PodLogOptions{
+		Container: taskContainer,
+		Follow:    true,
+	}).Stream(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "error getting logs for pod %s", name)
+	}
+	defer func() {
+		if err := out.Close(); err != nil {
+			log.Error().Err(err).Msgf("error closing logs stream on pod %s", name)
+		}
+	}()
+	if _, err := io.Copy(logger, out); err != nil {
+		return errors.Wrapf(err, "error reading the std out")
+	}
+
+	// wait for the task to finish execution
+	state, err := d.waitForContainer(ctx, name, func(s corev1.ContainerState) bool {
+		return s.Terminated != nil
+	})
+	if err != nil {
+		return err
+	}
+	exitCode := state.Terminated.ExitCode
+	if exitCode != 0 {
+		tail := int64(10)
+		out, err := d.client.CoreV1().Pods(d.namespace).GetLogs(name, &corev1.PodLogOptions{
+			Container: taskContainer,
+			TailLines: &tail,
+		}).DoRaw(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("error tailing the log")
+			return errors.Errorf("exit code %d", exitCode)
+		}
+		return errors.Errorf("exit code %d: %s", exitCode, string(out))
+	}
+	stdout, err := d.readOutput(ctx, name)
+	if err != nil {
+		return err
+	}
+	t.Result = stdout
+	log.Debug().
+		Int32("status-code", exitCode).
+		Str("task-id", t.ID).
+		Msg("task completed")
+	return nil
+}
+
+// waitForContainer polls the task's Pod until the task
+// container reaches the desired state.
+func (d *KubernetesRuntime) waitForContainer(ctx context.Context, name string, cond func(s corev1.ContainerState) bool) (corev1.ContainerState, error) {
+	for {
+		pod, err := d.client.CoreV1().Pods(d.namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return corev1.ContainerState{}, errors.Wrapf(err, "error getting pod %s", name)
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.Name != taskContainer {
+				continue
+			}
+			if cond(cs.State) {
+				return cs.State, nil
+			}
+			if w := cs.State.Waiting; w != nil {
+				switch w.Reason {
+				case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "CreateContainerConfigError", "CreateContainerError":
+					return corev1.ContainerState{}, errors.Errorf("error starting pod %s: %s: %s", name, w.Reason, w.Message)
+				}
+			}
+		}
+		if pod.Status.Phase == corev1.PodFailed {
+			if pod.Status.Reason == "DeadlineExceeded" {
+				return corev1.ContainerState{}, errors.Errorf("task timed out")
+			}
+			return corev1.ContainerState{}, errors.Errorf("pod %s failed: %s %s", name, pod.Status.Reason, pod.Status.Message)
+		}
+		select {
+		case <-time.After(d.pollInterval):
+		case <-ctx.Done():
+			return corev1.ContainerState{}, ctx.Err()
+		}
+	}
+}
+
+func (d *KubernetesRuntime) podSpec(name string, t *tork.Task) (*corev1.Pod, error) {
+	env := []corev1.EnvVar{}
+	for name, value := range t.Env {
+		env = append(env, corev1.EnvVar{Name: name, Value: value})
+	}
+	sort.Slice(env, func(i, j int) bool {
+		return env[i].Name < env[j].Name
+	})
+	env = append(env, corev1.EnvVar{Name: "TORK_OUTPUT", Value: "/tork/stdout"})
+	env = append(env, corev1.EnvVar{Name: "TORK_PROGRESS", Value: "/tork/progress"})
+
+	// the entrypoint is executed directly so the
+	// config files must be mounted as executable
+	configMode := int32(0555)
+	volumes := []corev1.Volume{{
+		Name: torkVolume,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	}, {
+		Name: configVolume,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: name},
+				DefaultMode:          &configMode,
+			},
+		},
+	}}
+	mounts := []corev1.VolumeMount{{
+		Name:      torkVolume,
+		MountPath: "/tork",
+	}}
+
+	for i, m := range t.Mounts {
+		vname := fmt.Sprintf("mount-%d", i)
+		var src corev1.VolumeSource
+		switch m.Type {
+		case tork.MountTypeVolume:
+			if m.Target == "" {
+				return nil, errors.Errorf("volume target is required")
+			}
+			src.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{ClaimName: m.Source}
+		case tork.MountTypeBind:
+			if m.Target == "" {
+				return nil, errors.Errorf("bind target is required")
+			}
+			if m.Source == "" {
+				return nil, errors.Errorf("bind source is required")
+			}
+			hpt := corev1.HostPathDirectoryOrCreate
+			src.HostPath = &corev1.HostPathVolumeSource{Path: m.Source, Type: &hpt}
+		case tork.MountTypeTmpfs:
+			src.EmptyDir = &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory}
+		default:
+			return nil, errors.Errorf("unknown mount type: %s", m.Type)
+		}
+		log.Debug().Msgf("Mounting %s -> %s", m.Source, m.Target)
+		volumes = append(volumes, corev1.Volume{Name: vname, VolumeSource: src})
+		mounts = append(mounts, corev1.VolumeMount{Name: vname, MountPath: m.Target})
+	}
+
+	if t.Run != "" {
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      configVolume,
+			MountPath: "/tork/entrypoint",
+			SubPath:   "entrypoint",
+		})
+	}
+
+	// we want to override the default
+	// image WORKDIR only if the task
+	// introduces work files _or_ if the
+	// user specifies a WORKDIR
+	workdir := t.Workdir
+	if workdir == "" && len(t.Files) > 0 {
+		t.Workdir = defaultWorkdir
+		workdir = defaultWorkdir
+	}
+	for i, filename := range sortedKeys(t.Files) {
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      configVolume,
+			MountPath: fmt.Sprintf("%s/%s", strings.TrimSuffix(workdir, "/"), filename),
+			SubPath:   fileKey(i),
+		})
+	}
+
+	resources, err := parseResources(t.Limits)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := []corev1.ContainerPort{}
+	for _, p := range t.Ports {
+		port, err := strconv.Atoi(strings.Split(p.Port, "/")[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid port: %s", p.Port)
+		}
+		ports = append(ports, corev1.ContainerPort{ContainerPort: int32(port)})
+	}
+
+	cmd := t.CMD
+	if len(cmd) == 0 {
+		cmd = []string{"/tork/entrypoint"}
+	}
+	entrypoint := t.Entrypoint
+	if len(entrypoint) == 0 && t.Run != "" {
+		entrypoint = []string{"sh", "-c"}
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: d.namespace,
+			Labels:    labels(t),
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Volumes:       volumes,
+			Containers: []corev1.Container{{
+				Name:         taskContainer,
+				Image:        t.Image,
+				Command:      entrypoint,
+				Args:         cmd,
+				Env:          env,
+				WorkingDir:   workdir,
+				VolumeMounts: mounts,
+				Resources:    resources,
+				Ports:        ports,
+			}, {
+				Name:    sidecarContainer,
+				Image:   d.sidecarImage,
+				Command: []string{"sh", "-c", sidecarScript},
+				VolumeMounts: []corev1.VolumeMount{{
+					Name:      torkVolume,
+					MountPath: "/tork",
+				}},
+			}},
+		},
+	}
+
+	if len(t.Networks) > 0 {
+		pod.Annotations = map[string]string{
+			networksAnnotation: strings.Join(t.Networks, ","),
+		}
+	}
+
+	if t.Timeout != "" {
+		timeout, err := time.ParseDuration(t.Timeout)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid timeout: %s", t.Timeout)
+		}
+		deadline := int64(timeout.Seconds())
+		if deadline < 1 {
+			deadline = 1
+		}
+		pod.Spec.ActiveDeadlineSeconds = &deadline
+	}
+
+	return pod, nil
+}
+
+func (d *KubernetesRuntime) reportProgress(ctx context.Context, name string, t *tork.Task) {
+	if d.broker == nil {
+		return
+	}
+	for {
+		progress, err := d.readProgress(ctx, name)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Debug().Err(err).Msgf("error reading progress value")
+			}
+		} else {
+			if progress != t.Progress {
+				t.Progress = progress
+				if err := d.broker.PublishTaskProgress(ctx, t); err != nil {
+					log.Error().Err(err).Msgf("error publishing task progress")
+				}
+			}
+		}
+		select {
+		case <-time.After(time.Second * 5):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *KubernetesRuntime) readOutput(ctx context.Context, name string) (string, error) {
+	if d.reader == nil {
+		return "", errors.New("unable to read the task output: no rest config")
+	}
+	b, err := d.reader.ReadFile(ctx, d.namespace, name, sidecarContainer, "/tork/stdout")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (d *KubernetesRuntime) readProgress(ctx context.Context, name string) (float64, error) {
+	if d.reader == nil {
+		return 0, errors.New("unable to read the task progress: no rest config")
+	}
+	b, err := d.reader.ReadFile(ctx, d.namespace, name, sidecarContainer, "/tork/progress")
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(s, 32)
+}
+
+func (d *KubernetesRuntime) Stop(ctx context.Context, t *tork.Task) error {
+	name, ok := d.tasks.Get(t.ID)
+	if !ok {
+		return nil
+	}
+	d.tasks.Delete(t.ID)
+	log.Debug().Msgf("Attempting to delete pod %s", name)
+	grace := int64(0)
+	if err := d.client.CoreV1().Pods(d.namespace).Delete(ctx, name, metav1.DeleteOptions{
+		GracePeriodSeconds: &grace,
+	}); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err := d.client.CoreV1().ConfigMaps(d.namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err := d.client.CoreV1().Secrets(d.namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (d *KubernetesRuntime) HealthCheck(ctx context.Context) error {
+	_, err := d.client.CoreV1().Pods(d.namespace).List(ctx, metav1.ListOptions{Limit: 1})
+	return err
+}
+
+func podName(t *tork.Task) string {
+	return fmt.Sprintf("tork-%s", strings.ToLower(t.ID))
+}
+
+func labels(t *tork.Task) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/managed-by": "tork",
+		"tork/task-id":                 t.ID,
+	}
+}
+
+func fileKey(i int) string {
+	return fmt.Sprintf("file-%d", i)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// configMap holds the task's run script and work files
+// which are mounted into the task container.
+func configMap(namespace, name string, t *tork.Task) *corev1.ConfigMap {
+	data := make(map[string]string)
+	if t.Run != "" {
+		data["entrypoint"] = t.Run
+	}
+	for i, filename := range sortedKeys(t.Files) {
+		data[fileKey(i)] = t.Files[filename]
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels(t),
+		},
+		Data: data,
+	}
+}
+
+func registrySecret(namespace, name string, t *tork.Task) (*corev1.Secret, error) {
+	auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", t.Registry.Username, t.Registry.Password)))
+	cfg := map[string]any{
+		"auths": map[string]any{
+			registryDomain(t.Image): map[string]string{
+				"username": t.Registry.Username,
+				"password": t.Registry.Password,
+				"auth":     auth,
+			},
+		},
+	}
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error serializing registry credentials")
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels(t),
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: b,
+		},
+	}, nil
+}
+
+func registryDomain(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[0]
+	}
+	return "https://index.docker.io/v1/"
+}
+
+func parseResources(limits *tork.TaskLimits) (corev1.ResourceRequirements, error) {
+	resources := corev1.ResourceRequirements{}
+	if limits == nil {
+		return resources, nil
+	}
+	rl := corev1.ResourceList{}
+	if limits.CPUs != "" {
+		cpus, err := resource.ParseQuantity(limits.CPUs)
+		if err != nil {
+			return resources, errors.Wrapf(err, "invalid CPUs value")
+		}
+		rl[corev1.ResourceCPU] = cpus
+	}
+	if limits.Memory != "" {
+		mem, err := units.RAMInBytes(limits.Memory)
+		if err != nil {
+			return resources, errors.Wrapf(err, "invalid memory value")
+		}
+		rl[corev1.ResourceMemory] = *resource.NewQuantity(mem, resource.BinarySI)
+	}
+	if len(rl) > 0 {
+		resources.Limits = rl
+	}
+	return resources, nil
+}
@@ -0,0 +1,381 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/mq"
+	"github.com/runabol/tork/runtime"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type fakeFileReader struct {
+	files map[string]string
+}
+
+func (r *fakeFileReader) ReadFile(ctx context.Context, namespace, pod, container, path string) ([]byte, error) {
+	contents, ok := r.files[path]
+	if !ok {
+		return nil, fmt.Errorf("no such file: %s", path)
+	}
+	return []byte(contents), nil
+}
+
+func newTestRuntime(t *testing.T, files map[string]string, opts ...Option) (*KubernetesRuntime, *fake.Clientset) {
+	client := fake.NewSimpleClientset()
+	opts = append([]Option{WithClient(client)}, opts...)
+	rt, err := NewKubernetesRuntime(opts...)
+	assert.NoError(t, err)
+	rt.reader = &fakeFileReader{files: files}
+	rt.pollInterval = time.Millisecond * 10
+	return rt, client
+}
+
+// completePod waits for the task's Pod to be created and then
+// marks its task container as terminated with the given exit code.
+func completePod(t *testing.T, client *fake.Clientset, name string, exitCode int32) chan *corev1.Pod {
+	created := make(chan *corev1.Pod, 1)
+	go func() {
+		ctx := context.Background()
+		for {
+			pod, err := client.CoreV1().Pods(defaultNamespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				time.Sleep(time.Millisecond * 10)
+				continue
+			}
+			created <- pod.DeepCopy()
+			pod.Status.Phase = corev1.PodRunning
+			pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+				Name: taskContainer,
+				State: corev1.ContainerState{
+					Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode},
+				},
+			}, {
+				Name: sidecarContainer,
+				State: corev1.ContainerState{
+					Running: &corev1.ContainerStateRunning{},
+				},
+			}}
+			_, err = client.CoreV1().Pods(defaultNamespace).UpdateStatus(ctx, pod, metav1.UpdateOptions{})
+			assert.NoError(t, err)
+			return
+		}
+	}()
+	return created
+}
+
+func TestKubernetesRunTask(t *testing.T) {
+	rt, client := newTestRuntime(t, map[string]string{
+		"/tork/stdout": "hello world",
+	})
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "ubuntu:mantic",
+		Run:   "echo -n hello world > $TORK_OUTPUT",
+		Env: map[string]string{
+			"SOME_VAR": "some value",
+		},
+	}
+	created := completePod(t, client, podName(tk), 0)
+	err := rt.Run(context.Background(), tk)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", tk.Result)
+
+	pod := <-created
+	assert.Len(t, pod.Spec.Containers, 2)
+	c := pod.Spec.Containers[0]
+	assert.Equal(t, "ubuntu:mantic", c.Image)
+	assert.Equal(t, []string{"sh", "-c"}, c.Command)
+	assert.Equal(t, []string{"/tork/entrypoint"}, c.Args)
+	assert.Contains(t, c.Env, corev1.EnvVar{Name: "SOME_VAR", Value: "some value"})
+	assert.Contains(t, c.Env, corev1.EnvVar{Name: "TORK_OUTPUT", Value: "/tork/stdout"})
+	assert.Equal(t, corev1.RestartPolicyNever, pod.Spec.RestartPolicy)
+
+	// the pod and its config map are removed once the task is done
+	_, err = client.CoreV1().Pods(defaultNamespace).Get(context.Background(), podName(tk), metav1.GetOptions{})
+	assert.Error(t, err)
+	_, err = client.CoreV1().ConfigMaps(defaultNamespace).Get(context.Background(), podName(tk), metav1.GetOptions{})
+	assert.Error(t, err)
+}
+
+func TestKubernetesRunTaskFailed(t *testing.T) {
+	rt, client := newTestRuntime(t, map[string]string{})
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "ubuntu:mantic",
+		Run:   "exit 2",
+	}
+	completePod(t, client, podName(tk), 2)
+	err := rt.Run(context.Background(), tk)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "exit code 2")
+}
+
+func TestKubernetesRunTaskImagePullError(t *testing.T) {
+	rt, client := newTestRuntime(t, map[string]string{})
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "no-such-image",
+	}
+	go func() {
+		ctx := context.Background()
+		for {
+			pod, err := client.CoreV1().Pods(defaultNamespace).Get(ctx, podName(tk), metav1.GetOptions{})
+			if err != nil {
+				time.Sleep(time.Millisecond * 10)
+				continue
+			}
+			pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+				Name: taskContainer,
+				State: corev1.ContainerState{
+					Waiting: &corev1.ContainerStateWaiting{Reason: "ErrImagePull"},
+				},
+			}}
+			_, err = client.CoreV1().Pods(defaultNamespace).UpdateStatus(ctx, pod, metav1.UpdateOptions{})
+			assert.NoError(t, err)
+			return
+		}
+	}()
+	err := rt.Run(context.Background(), tk)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ErrImagePull")
+}
+
+func TestKubernetesRunTaskTimeout(t *testing.T) {
+	rt, client := newTestRuntime(t, map[string]string{})
+	tk := &tork.Task{
+		ID:      uuid.NewUUID(),
+		Image:   "ubuntu:mantic",
+		Run:     "sleep 30",
+		Timeout: "5s",
+	}
+	created := make(chan *corev1.Pod, 1)
+	go func() {
+		ctx := context.Background()
+		for {
+			pod, err := client.CoreV1().Pods(defaultNamespace).Get(ctx, podName(tk), metav1.GetOptions{})
+			if err != nil {
+				time.Sleep(time.Millisecond * 10)
+				continue
+			}
+			created <- pod.DeepCopy()
+			pod.Status.Phase = corev1.PodFailed
+			pod.Status.Reason = "DeadlineExceeded"
+			_, err = client.CoreV1().Pods(defaultNamespace).UpdateStatus(ctx, pod, metav1.UpdateOptions{})
+			assert.NoError(t, err)
+			return
+		}
+	}()
+	err := rt.Run(context.Background(), tk)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "timed out")
+	pod := <-created
+	assert.Equal(t, int64(5), *pod.Spec.ActiveDeadlineSeconds)
+}
+
+func TestKubernetesRunTaskWithLimits(t *testing.T) {
+	rt, client := newTestRuntime(t, map[string]string{"/tork/stdout": ""})
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "ubuntu:mantic",
+		CMD:   []string{"ls"},
+		Limits: &tork.TaskLimits{
+			CPUs:   ".5",
+			Memory: "10m",
+		},
+		Networks: []string{"net-a", "net-b"},
+	}
+	created := completePod(t, client, podName(tk), 0)
+	err := rt.Run(context.Background(), tk)
+	assert.NoError(t, err)
+	pod := <-created
+	c := pod.Spec.Containers[0]
+	assert.Nil(t, c.Command)
+	assert.Equal(t, []string{"ls"}, c.Args)
+	assert.Equal(t, int64(500), c.Resources.Limits.Cpu().MilliValue())
+	assert.Equal(t, int64(10*1024*1024), c.Resources.Limits.Memory().Value())
+	assert.Equal(t, "net-a,net-b", pod.Annotations[networksAnnotation])
+}
+
+func TestKubernetesRunTaskWithFiles(t *testing.T) {
+	rt, client := newTestRuntime(t, map[string]string{"/tork/stdout": ""})
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "ubuntu:mantic",
+		Run:   "cat hello.txt",
+		Files: map[string]string{
+			"hello.txt": "hello world",
+		},
+	}
+	cms := make(chan *corev1.ConfigMap, 1)
+	go func() {
+		for {
+			cm, err := client.CoreV1().ConfigMaps(defaultNamespace).Get(context.Background(), podName(tk), metav1.GetOptions{})
+			if err == nil {
+				cms <- cm
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	created := completePod(t, client, podName(tk), 0)
+	err := rt.Run(context.Background(), tk)
+	assert.NoError(t, err)
+	pod := <-created
+	c := pod.Spec.Containers[0]
+	assert.Equal(t, defaultWorkdir, c.WorkingDir)
+	assert.Contains(t, c.VolumeMounts, corev1.VolumeMount{
+		Name:      configVolume,
+		MountPath: defaultWorkdir + "/hello.txt",
+		SubPath:   "file-0",
+	})
+	assert.Contains(t, c.VolumeMounts, corev1.VolumeMount{
+		Name:      configVolume,
+		MountPath: "/tork/entrypoint",
+		SubPath:   "entrypoint",
+	})
+	cm := <-cms
+	assert.Equal(t, "hello world", cm.Data["file-0"])
+	assert.Equal(t, "cat hello.txt", cm.Data["entrypoint"])
+}
+
+func TestKubernetesRunTaskWithMounts(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	mounter := runtime.NewMultiMounter()
+	mounter.RegisterMounter(tork.MountTypeVolume, NewVolumeMounter(client, VolumeConfig{}))
+	mounter.RegisterMounter(tork.MountTypeTmpfs, NewTmpfsMounter())
+	rt, err := NewKubernetesRuntime(WithClient(client), WithMounter(mounter))
+	assert.NoError(t, err)
+	rt.reader = &fakeFileReader{files: map[string]string{"/tork/stdout": ""}}
+	rt.pollInterval = time.Millisecond * 10
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "ubuntu:mantic",
+		Run:   "ls /data",
+		Mounts: []tork.Mount{{
+			Type:   tork.MountTypeVolume,
+			Target: "/data",
+		}, {
+			Type:   tork.MountTypeTmpfs,
+			Target: "/scratch",
+		}},
+	}
+	created := completePod(t, client, podName(tk), 0)
+	err = rt.Run(context.Background(), tk)
+	assert.NoError(t, err)
+	pod := <-created
+	assert.Len(t, pod.Spec.Volumes, 4)
+	assert.Equal(t, tk.Mounts[0].Source, pod.Spec.Volumes[2].PersistentVolumeClaim.ClaimName)
+	assert.Equal(t, corev1.StorageMediumMemory, pod.Spec.Volumes[3].EmptyDir.Medium)
+	// the volume's claim is removed once the task is done
+	pvcs, err := client.CoreV1().PersistentVolumeClaims(defaultNamespace).List(context.Background(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, pvcs.Items, 0)
+}
+
+func TestKubernetesRunTaskProgress(t *testing.T) {
+	b := mq.NewInMemoryBroker()
+	processed := make(chan any, 1)
+	err := b.SubscribeForTaskProgress(func(tk *tork.Task) error {
+		processed <- 1
+		return nil
+	})
+	assert.NoError(t, err)
+	rt, client := newTestRuntime(t, map[string]string{
+		"/tork/stdout":   "",
+		"/tork/progress": "0.5",
+	}, WithBroker(b))
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "ubuntu:mantic",
+		Run:   "echo 0.5 > $TORK_PROGRESS",
+	}
+	completePod(t, client, podName(tk), 0)
+	err = rt.Run(context.Background(), tk)
+	assert.NoError(t, err)
+	select {
+	case <-processed:
+	case <-time.After(time.Second):
+	}
+	assert.Equal(t, float64(0.5), tk.Progress)
+}
+
+func TestKubernetesRunTaskWithRegistry(t *testing.T) {
+	rt, client := newTestRuntime(t, map[string]string{"/tork/stdout": ""})
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "registry.example.com/some/image:latest",
+		Registry: &tork.Registry{
+			Username: "user",
+			Password: "pass",
+		},
+	}
+	created := completePod(t, client, podName(tk), 0)
+	err := rt.Run(context.Background(), tk)
+	assert.NoError(t, err)
+	pod := <-created
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: podName(tk)}}, pod.Spec.ImagePullSecrets)
+	_, err = client.CoreV1().Secrets(defaultNamespace).Get(context.Background(), podName(tk), metav1.GetOptions{})
+	assert.Error(t, err)
+}
+
+func TestKubernetesStop(t *testing.T) {
+	rt, client := newTestRuntime(t, map[string]string{})
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "ubuntu:mantic",
+		Run:   "sleep 10",
+	}
+	go func() {
+		for {
+			_, err := client.CoreV1().Pods(defaultNamespace).Get(context.Background(), podName(tk), metav1.GetOptions{})
+			if err == nil {
+				break
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+		err := rt.Stop(context.Background(), tk)
+		assert.NoError(t, err)
+	}()
+	err := rt.Run(context.Background(), tk)
+	assert.Error(t, err)
+}
+
+func TestKubernetesHealthCheck(t *testing.T) {
+	rt, _ := newTestRuntime(t, map[string]string{})
+	assert.NoError(t, rt.HealthCheck(context.Background()))
+}
+
+func Test_registryDomain(t *testing.T) {
+	assert.Equal(t, "https://index.docker.io/v1/", registryDomain("ubuntu:mantic"))
+	assert.Equal(t, "https://index.docker.io/v1/", registryDomain("library/ubuntu:mantic"))
+	assert.Equal(t, "registry.example.com", registryDomain("registry.example.com/some/image"))
+	assert.Equal(t, "localhost:5000", registryDomain("localhost:5000/image"))
+}
+
+func Test_podSpecEntrypointMode(t *testing.T) {
+	rt, _ := newTestRuntime(t, nil)
+	pod, err := rt.podSpec("some-pod", &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "busybox:stable",
+		Run:   "echo hello",
+	})
+	assert.NoError(t, err)
+	var found bool
+	for _, v := range pod.Spec.Volumes {
+		if v.Name != configVolume {
+			continue
+		}
+		found = true
+		assert.NotNil(t, v.ConfigMap.DefaultMode)
+		assert.Equal(t, int32(0555), *v.ConfigMap.DefaultMode)
+	}
+	assert.True(t, found)
+}
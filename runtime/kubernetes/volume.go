@@ -0,0 +1,86 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+const defaultVolumeSize = "1Gi"
+
+// VolumeMounter backs each volume mount with a PersistentVolumeClaim
+// so that it can be shared by the task's pre, main and post Pods.
+type VolumeMounter struct {
+	client k8s.Interface
+	cfg    VolumeConfig
+}
+
+type VolumeConfig struct {
+	Namespace    string
+	StorageClass string
+	Size         string
+}
+
+func NewVolumeMounter(client k8s.Interface, cfg VolumeConfig) *VolumeMounter {
+	if cfg.Namespace == "" {
+		cfg.Namespace = defaultNamespace
+	}
+	if cfg.Size == "" {
+		cfg.Size = defaultVolumeSize
+	}
+	return &VolumeMounter{client: client, cfg: cfg}
+}
+
+func (m *VolumeMounter) Mount(ctx context.Context, mn *tork.Mount) error {
+	size, err := resource.ParseQuantity(m.cfg.Size)
+	if err != nil {
+		return errors.Wrapf(err, "invalid volume size: %s", m.cfg.Size)
+	}
+	name := fmt.Sprintf("tork-%s", uuid.NewUUID())
+	mn.Source = name
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: m.cfg.Namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "tork",
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: size,
+				},
+			},
+		},
+	}
+	if m.cfg.StorageClass != "" {
+		pvc.Spec.StorageClassName = &m.cfg.StorageClass
+	}
+	if _, err := m.client.CoreV1().PersistentVolumeClaims(m.cfg.Namespace).Create(ctx, pvc, metav1.CreateOptions{}); err != nil {
+		return errors.Wrapf(err, "error creating volume %s", name)
+	}
+	log.Debug().Msgf("created volume %s", name)
+	return nil
+}
+
+func (m *VolumeMounter) Unmount(ctx context.Context, mn *tork.Mount) error {
+	if err := m.client.CoreV1().PersistentVolumeClaims(m.cfg.Namespace).Delete(ctx, mn.Source, metav1.DeleteOptions{}); err != nil {
+		if apierrors.IsNotFound(err) {
+			return errors.Errorf("unknown volume: %s", mn.Source)
+		}
+		return err
+	}
+	log.Debug().Msgf("removed volume %s", mn.Source)
+	return nil
+}
@@ -0,0 +1,54 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMountVolume(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	mounter := NewVolumeMounter(client, VolumeConfig{
+		StorageClass: "standard",
+		Size:         "5Gi",
+	})
+	mnt := &tork.Mount{
+		Type:   tork.MountTypeVolume,
+		Target: "/data",
+	}
+	err := mounter.Mount(ctx, mnt)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, mnt.Source)
+
+	pvc, err := client.CoreV1().PersistentVolumeClaims(defaultNamespace).Get(ctx, mnt.Source, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "standard", *pvc.Spec.StorageClassName)
+	assert.Equal(t, "5Gi", pvc.Spec.Resources.Requests.Storage().String())
+	assert.Equal(t, []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}, pvc.Spec.AccessModes)
+
+	err = mounter.Unmount(ctx, mnt)
+	assert.NoError(t, err)
+
+	_, err = client.CoreV1().PersistentVolumeClaims(defaultNamespace).Get(ctx, mnt.Source, metav1.GetOptions{})
+	assert.Error(t, err)
+
+	err = mounter.Unmount(ctx, mnt)
+	assert.Error(t, err)
+}
+
+func TestMountVolumeBadSize(t *testing.T) {
+	mounter := NewVolumeMounter(fake.NewSimpleClientset(), VolumeConfig{
+		Size: "lots",
+	})
+	err := mounter.Mount(context.Background(), &tork.Mount{
+		Type:   tork.MountTypeVolume,
+		Target: "/data",
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,357 @@
+package podman
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultHost is the socket of a rootful `podman system service`.
+	// Rootless services listen on $XDG_RUNTIME_DIR/podman/podman.sock
+	defaultHost = "unix:///run/podman/podman.sock"
+	apiVersion  = "v4.0.0"
+)
+
+// client is a minimal client of podman's libpod REST API,
+// covering the endpoints needed to run tasks.
+type client struct {
+	http *http.Client
+	base string
+}
+
+// spec is the subset of podman's SpecGenerator
+// used to create task containers.
+type spec struct {
+	Image          string              `json:"image"`
+	Command        []string            `json:"command,omitempty"`
+	Entrypoint     []string            `json:"entrypoint,omitempty"`
+	Env            map[string]string   `json:"env,omitempty"`
+	WorkDir        string              `json:"work_dir,omitempty"`
+	User           string              `json:"user,omitempty"`
+	Mounts         []specMount         `json:"mounts,omitempty"`
+	Volumes        []specVolume        `json:"volumes,omitempty"`
+	PortMappings   []specPortMapping   `json:"portmappings,omitempty"`
+	Networks       map[string]struct{} `json:"Networks,omitempty"`
+	Devices        []specDevice        `json:"devices,omitempty"`
+	ResourceLimits *specResources      `json:"resource_limits,omitempty"`
+}
+
+type specMount struct {
+	Destination string `json:"destination"`
+	Type        string `json:"type"`
+	Source      string `json:"source,omitempty"`
+}
+
+type specVolume struct {
+	Name string `json:"Name"`
+	Dest string `json:"Dest"`
+}
+
+type specPortMapping struct {
+	HostIP        string `json:"host_ip,omitempty"`
+	ContainerPort uint16 `json:"container_port"`
+	HostPort      uint16 `json:"host_port"`
+	Protocol      string `json:"protocol,omitempty"`
+}
+
+type specDevice struct {
+	Path string `json:"path"`
+}
+
+type specResources struct {
+	CPU    *specCPU    `json:"cpu,omitempty"`
+	Memory *specMemory `json:"memory,omitempty"`
+}
+
+type specCPU struct {
+	Quota  int64  `json:"quota,omitempty"`
+	Period uint64 `json:"period,omitempty"`
+}
+
+type specMemory struct {
+	Limit int64 `json:"limit,omitempty"`
+}
+
+// apiError is the error body returned by the libpod API.
+type apiError struct {
+	Cause    string `json:"cause"`
+	Message  string `json:"message"`
+	Response int    `json:"response"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// newClient creates a client of the podman service listening on
+// the given host, e.g. unix:///run/podman/podman.sock or
+// tcp://localhost:8080. When no host is given, $CONTAINER_HOST
+// is used, falling back to the rootful service socket.
+func newClient(host string) (*client, error) {
+	if host == "" {
+		host = os.Getenv("CONTAINER_HOST")
+	}
+	if host == "" {
+		host = defaultHost
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid podman host: %s", host)
+	}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &client{
+			http: &http.Client{Transport: transport},
+			base: fmt.Sprintf("http://d/%s/libpod", apiVersion),
+		}, nil
+	case "tcp", "http":
+		return &client{
+			http: &http.Client{},
+			base: fmt.Sprintf("http://%s/%s/libpod", u.Host, apiVersion),
+		}, nil
+	default:
+		return nil, errors.Errorf("unsupported podman host: %s", host)
+	}
+}
+
+// do sends the request and returns the response if
+// it was successful or the API's error otherwise.
+func (c *client) do(ctx context.Context, method, path string, query url.Values, body io.Reader, header http.Header) (*http.Response, error) {
+	u := c.base + path
+	if len(query) > 0 {
+		u = u + "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating podman request")
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error calling podman %s %s", method, path)
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading podman response")
+		}
+		apiErr := &apiError{}
+		if err := json.Unmarshal(b, apiErr); err != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(b))
+		}
+		apiErr.Response = resp.StatusCode
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// call sends the request and decodes the JSON
+// response body into v, unless v is nil.
+func (c *client) call(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	var body io.Reader
+	header := http.Header{}
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return errors.Wrapf(err, "error serializing podman request")
+		}
+		body = bytes.NewReader(b)
+		header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.do(ctx, method, path, query, body, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err := io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return errors.Wrapf(err, "error decoding podman response")
+	}
+	return nil
+}
+
+// exists returns true if the resource at the
+// given path exists, i.e. it responds with a 204.
+func (c *client) exists(ctx context.Context, path string) (bool, error) {
+	err := c.call(ctx, http.MethodGet, path, nil, nil, nil)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.Response == http.StatusNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (c *client) ping(ctx context.Context) error {
+	return c.call(ctx, http.MethodGet, "/_ping", nil, nil, nil)
+}
+
+func (c *client) imageExists(ctx context.Context, name string) (bool, error) {
+	return c.exists(ctx, fmt.Sprintf("/images/%s/exists", name))
+}
+
+// imageUser returns the default user of the image.
+func (c *client) imageUser(ctx context.Context, name string) (string, error) {
+	image := struct {
+		Config struct {
+			User string `json:"User"`
+		} `json:"Config"`
+	}{}
+	if err := c.call(ctx, http.MethodGet, fmt.Sprintf("/images/%s/json", name), nil, nil, &image); err != nil {
+		return "", err
+	}
+	return image.Config.User, nil
+}
+
+// pullImage pulls the image, writing the progress reported by
+// podman to w. The registry auth, if any, is the base64 encoded
+// JSON credentials sent in the X-Registry-Auth header.
+func (c *client) pullImage(ctx context.Context, name, auth string, w io.Writer) error {
+	header := http.Header{}
+	if auth != "" {
+		header.Set("X-Registry-Auth", auth)
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/images/pull", url.Values{"reference": {name}}, nil, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		report := struct {
+			Stream string `json:"stream"`
+			Error  string `json:"error"`
+		}{}
+		if err := dec.Decode(&report); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrapf(err, "error reading the pull progress")
+		}
+		if report.Error != "" {
+			return errors.New(report.Error)
+		}
+		if report.Stream != "" {
+			if _, err := io.WriteString(w, report.Stream); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *client) createContainer(ctx context.Context, s *spec) (string, error) {
+	created := struct {
+		ID string `json:"Id"`
+	}{}
+	if err := c.call(ctx, http.MethodPost, "/containers/create", nil, s, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (c *client) startContainer(ctx context.Context, id string) error {
+	return c.call(ctx, http.MethodPost, fmt.Sprintf("/containers/%s/start", id), nil, nil, nil)
+}
+
+// waitContainer waits for the container to
+// exit and returns its exit code.
+func (c *client) waitContainer(ctx context.Context, id string) (int, error) {
+	resp, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/containers/%s/wait", id), nil, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error reading the exit code")
+	}
+	exitCode, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid exit code: %s", string(b))
+	}
+	return exitCode, nil
+}
+
+// containerLogs returns the container's multiplexed stdout and
+// stderr stream, limited to the last lines of it if tail is set.
+func (c *client) containerLogs(ctx context.Context, id string, follow bool, tail string) (io.ReadCloser, error) {
+	query := url.Values{
+		"stdout": {"true"},
+		"stderr": {"true"},
+		"follow": {strconv.FormatBool(follow)},
+	}
+	if tail != "" {
+		query.Set("tail", tail)
+	}
+	resp, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/containers/%s/logs", id), query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// putArchive extracts the tar archive into the
+// given directory of the container.
+func (c *client) putArchive(ctx context.Context, id, dir string, ar io.Reader) error {
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-tar")
+	resp, err := c.do(ctx, http.MethodPut, fmt.Sprintf("/containers/%s/archive", id), url.Values{"path": {dir}}, ar, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+// getArchive returns a tar archive of the given
+// path of the container's filesystem.
+func (c *client) getArchive(ctx context.Context, id, path string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/containers/%s/archive", id), url.Values{"path": {path}}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// removeContainer force removes the container
+// along with its anonymous volumes.
+func (c *client) removeContainer(ctx context.Context, id string) error {
+	query := url.Values{"force": {"true"}, "v": {"true"}}
+	return c.call(ctx, http.MethodDelete, fmt.Sprintf("/containers/%s", id), query, nil, nil)
+}
+
+func (c *client) createVolume(ctx context.Context, name string) error {
+	return c.call(ctx, http.MethodPost, "/volumes/create", nil, map[string]string{"Name": name}, nil)
+}
+
+func (c *client) volumeExists(ctx context.Context, name string) (bool, error) {
+	return c.exists(ctx, fmt.Sprintf("/volumes/%s/exists", name))
+}
+
+func (c *client) removeVolume(ctx context.Context, name string) error {
+	return c.call(ctx, http.MethodDelete, fmt.Sprintf("/volumes/%s", name), url.Values{"force": {"true"}}, nil, nil)
+}
@@ -0,0 +1,684 @@
+package podman
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/syncx"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/mq"
+	"github.com/runabol/tork/runtime"
+	"github.com/runabol/tork/runtime/docker"
+)
+
+// defaultWorkdir is the directory where `Task.File`s are
+// written to by default, should `Task.Workdir` not be set
+const (
+	defaultWorkdir     = "/tork/workdir"
+	defaultSandboxUser = "1000:1000"
+	// cpuPeriod is the CFS period, in microseconds,
+	// against which the CPUs limit is converted to a quota
+	cpuPeriod = 100000
+)
+
+var rootUserPattern = regexp.MustCompile(`^(|root|0|root(:root)?|root:0|0:root|0:0)$`)
+
+// PodmanRuntime executes tasks as containers using podman, for
+// hosts where the Docker daemon is not available. It talks to
+// the libpod REST API served by `podman system service`, which
+// can be socket-activated by systemd rather than kept running.
+type PodmanRuntime struct {
+	host    string
+	client  *client
+	tasks   *syncx.Map[string, string]
+	images  *syncx.Map[string, bool]
+	pullq   chan *pullRequest
+	mounter runtime.Mounter
+	broker  mq.Broker
+	config  string
+	sandbox bool
+}
+
+type pullRequest struct {
+	ctx      context.Context
+	image    string
+	logger   io.Writer
+	registry registry
+	done     chan error
+}
+
+type registry struct {
+	username string
+	password string
+}
+
+type Option = func(rt *PodmanRuntime)
+
+func WithMounter(mounter runtime.Mounter) Option {
+	return func(rt *PodmanRuntime) {
+		rt.mounter = mounter
+	}
+}
+
+func WithBroker(broker mq.Broker) Option {
+	return func(rt *PodmanRuntime) {
+		rt.broker = broker
+	}
+}
+
+// WithConfig sets the path to the docker-style config
+// file used to look up registry credentials.
+func WithConfig(config string) Option {
+	return func(rt *PodmanRuntime) {
+		rt.config = config
+	}
+}
+
+func WithSandbox(val bool) Option {
+	return func(rt *PodmanRuntime) {
+		rt.sandbox = val
+	}
+}
+
+// WithHost sets the address of the podman service, e.g.
+// unix:///run/user/1000/podman/podman.sock. Defaults to
+// $CONTAINER_HOST or unix:///run/podman/podman.sock.
+func WithHost(host string) Option {
+	return func(rt *PodmanRuntime) {
+		rt.host = host
+	}
+}
+
+func NewPodmanRuntime(opts ...Option) (*PodmanRuntime, error) {
+	rt := &PodmanRuntime{
+		tasks:  new(syncx.Map[string, string]),
+		images: new(syncx.Map[string, bool]),
+		pullq:  make(chan *pullRequest, 1),
+	}
+	for _, o := range opts {
+		o(rt)
+	}
+	c, err := newClient(rt.host)
+	if err != nil {
+		return nil, err
+	}
+	rt.client = c
+	// setup a default mounter
+	if rt.mounter == nil {
+		rt.mounter = &VolumeMounter{client: c}
+	}
+	go rt.puller()
+	return rt, nil
+}
+
+func (d *PodmanRuntime) Run(ctx context.Context, t *tork.Task) error {
+	// prepare mounts
+	for i, mnt := range t.Mounts {
+		mnt.ID = uuid.NewUUID()
+		err := d.mounter.Mount(ctx, &mnt)
+		if err != nil {
+			return err
+		}
+		if d.sandbox && mnt.Type == tork.MountTypeVolume {
+			// add a pre-task to adjust volume permissions
+			// to allow access to the tork user
+			t.Pre = append([]*tork.Task{{
+				Internal: true,
+				Image:    "busybox:stable",
+				CMD:      []string{"sh", "-c", fmt.Sprintf("chmod 777 %s", mnt.Target)},
+				Mounts:   []tork.Mount{mnt},
+			}}, t.Pre...)
+		}
+		defer func(m tork.Mount) {
+			uctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+			if err := d.mounter.Unmount(uctx, &m); err != nil {
+				log.Error().
+					Err(err).
+					Msgf("error deleting mount: %s", m)
+			}
+		}(mnt)
+		t.Mounts[i] = mnt
+	}
+	var logger io.Writer
+	if d.broker != nil {
+		logger = mq.NewLogShipper(d.broker, t.ID)
+	} else {
+		logger = os.Stdout
+	}
+	// excute pre-tasks
+	for _, pre := range t.Pre {
+		pre.ID = uuid.NewUUID()
+		pre.Mounts = t.Mounts
+		pre.Networks = t.Networks
+		pre.Limits = t.Limits
+		if err := d.doRun(ctx, pre, logger); err != nil {
+			return err
+		}
+	}
+	// run the actual task
+	if err := d.doRun(ctx, t, logger); err != nil {
+		return err
+	}
+	// execute post tasks
+	for _, post := range t.Post {
+		post.ID = uuid.NewUUID()
+		post.Mounts = t.Mounts
+		post.Networks = t.Networks
+		post.Limits = t.Limits
+		if err := d.doRun(ctx, post, logger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *PodmanRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Writer) error {
+	if t.ID == "" {
+		return errors.New("task id is required")
+	}
+	if t.Artifacts != nil {
+		return errors.New("artifacts are not supported on podman runtime")
+	}
+	if err := d.imagePull(ctx, t, logger); err != nil {
+		return errors.Wrapf(err, "error pulling image: %s", t.Image)
+	}
+
+	torkdir := &tork.Mount{
+		ID:     uuid.NewUUID(),
+		Type:   tork.MountTypeVolume,
+		Target: "/tork",
+	}
+	if err := d.mounter.Mount(ctx, torkdir); err != nil {
+		return err
+	}
+	defer func() {
+		uctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		if err := d.mounter.Unmount(uctx, torkdir); err != nil {
+			log.Error().Err(err).Msgf("error unmounting workdir")
+		}
+	}()
+
+	var user string
+	if d.sandbox && !t.Internal {
+		u, err := d.client.imageUser(ctx, t.Image)
+		if err != nil {
+			return errors.Wrapf(err, "error inspecting image: %s", t.Image)
+		}
+		if rootUserPattern.MatchString(u) {
+			// set a sandboxed (non-root) user
+			// only if the default user is root
+			user = defaultSandboxUser
+		}
+	}
+
+	s, err := createSpec(t, torkdir, user)
+	if err != nil {
+		return err
+	}
+
+	// we want to create the container using a background context
+	// in case the task is being cancelled while the container is
+	// being created. This could lead to a situation where the
+	// container is created in a "zombie" state leading to a situation
+	// where the attached volumes can't be removed and cleaned up.
+	createCtx, createCancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer createCancel()
+	containerID, err := d.client.createContainer(createCtx, s)
+	if err != nil {
+		log.Error().Msgf(
+			"Error creating container using image %s: %v\n",
+			t.Image, err,
+		)
+		return err
+	}
+
+	// create a mapping between task id and container id
+	d.tasks.Set(t.ID, containerID)
+
+	log.Debug().Msgf("created container %s", containerID)
+
+	// remove the container
+	defer func() {
+		stopContext, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		if err := d.Stop(stopContext, t); err != nil {
+			log.Error().
+				Err(err).
+				Str("container-id", containerID).
+				Msg("error removing container upon completion")
+		}
+	}()
+
+	// initialize the tork and, optionally, the work directory
+	if err := d.initTorkdir(ctx, containerID, t); err != nil {
+		return errors.Wrapf(err, "error initializing torkdir")
+	}
+	if err := d.initWorkDir(ctx, containerID, t); err != nil {
+		return errors.Wrapf(err, "error initializing workdir")
+	}
+
+	// start the container
+	log.Debug().Msgf("Starting container %s", containerID)
+	if err := d.client.startContainer(ctx, containerID); err != nil {
+		return errors.Wrapf(err, "error starting container %s", containerID)
+	}
+
+	// report task progress
+	pctx, pcancel := context.WithCancel(ctx)
+	defer pcancel()
+	go d.reportProgress(pctx, containerID, t)
+
+	// read the task's output
+	logs, err := d.client.containerLogs(ctx, containerID, true, "")
+	if err != nil {
+		return errors.Wrapf(err, "error getting logs for container %s", containerID)
+	}
+	defer logs.Close()
+	if _, err := stdcopy.StdCopy(logger, logger, logs); err != nil {
+		return errors.Wrapf(err, "error reading the std out")
+	}
+
+	// wait for the task to finish execution
+	exitCode, err := d.client.waitContainer(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		tail, err := d.tailLogs(ctx, containerID)
+		if err != nil {
+			log.Error().Err(err).Msg("error tailing the log")
+			return errors.Errorf("exit code %d", exitCode)
+		}
+		return errors.Errorf("exit code %d: %s", exitCode, tail)
+	}
+	stdout, err := d.readOutput(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	t.Result = stdout
+	log.Debug().
+		Int("status-code", exitCode).
+		Str("task-id", t.ID).
+		Msg("task completed")
+	return nil
+}
+
+// tailLogs returns the last lines of the container's output.
+func (d *PodmanRuntime) tailLogs(ctx context.Context, containerID string) (string, error) {
+	logs, err := d.client.containerLogs(ctx, containerID, false, "10")
+	if err != nil {
+		return "", err
+	}
+	defer logs.Close()
+	var buf bytes.Buffer
+	if _, err := stdcopy.StdCopy(&buf, &buf, logs); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// createSpec builds the spec of
+// the container of the given task.
+func createSpec(t *tork.Task, torkdir *tork.Mount, user string) (*spec, error) {
+	s := &spec{
+		Image: t.Image,
+		User:  user,
+		Env:   make(map[string]string),
+	}
+
+	for name, value := range t.Env {
+		s.Env[name] = value
+	}
+	s.Env["TORK_OUTPUT"] = "/tork/stdout"
+	s.Env["TORK_PROGRESS"] = "/tork/progress"
+
+	for _, m := range t.Mounts {
+		switch m.Type {
+		case tork.MountTypeVolume:
+			if m.Target == "" {
+				return nil, errors.Errorf("volume target is required")
+			}
+			s.Volumes = append(s.Volumes, specVolume{Name: m.Source, Dest: m.Target})
+		case tork.MountTypeBind:
+			if m.Target == "" {
+				return nil, errors.Errorf("bind target is required")
+			}
+			if m.Source == "" {
+				return nil, errors.Errorf("bind source is required")
+			}
+			s.Mounts = append(s.Mounts, specMount{Type: "bind", Source: m.Source, Destination: m.Target})
+		case tork.MountTypeTmpfs:
+			s.Mounts = append(s.Mounts, specMount{Type: "tmpfs", Source: "tmpfs", Destination: m.Target})
+		default:
+			return nil, errors.Errorf("unknown mount type: %s", m.Type)
+		}
+		log.Debug().Msgf("Mounting %s -> %s", m.Source, m.Target)
+	}
+	s.Volumes = append(s.Volumes, specVolume{Name: torkdir.Source, Dest: torkdir.Target})
+
+	resources, err := parseLimits(t.Limits)
+	if err != nil {
+		return nil, err
+	}
+	s.ResourceLimits = resources
+
+	if t.GPUs != "" {
+		devices, err := parseGPUs(t.GPUs)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error setting GPUs")
+		}
+		s.Devices = devices
+	}
+
+	for _, p := range t.Ports {
+		pm, err := parsePort(p)
+		if err != nil {
+			return nil, err
+		}
+		s.PortMappings = append(s.PortMappings, pm)
+	}
+
+	if len(t.Networks) > 0 {
+		s.Networks = make(map[string]struct{})
+		for _, nw := range t.Networks {
+			s.Networks[nw] = struct{}{}
+		}
+	}
+
+	// we want to override the default
+	// image WORKDIR only if the task
+	// introduces work files _or_ if the
+	// user specifies a WORKDIR
+	if t.Workdir != "" {
+		s.WorkDir = t.Workdir
+	} else if len(t.Files) > 0 {
+		t.Workdir = defaultWorkdir
+		s.WorkDir = t.Workdir
+	}
+
+	s.Entrypoint = t.Entrypoint
+	if len(s.Entrypoint) == 0 && t.Run != "" {
+		s.Entrypoint = []string{"sh", "-c"}
+	}
+
+	s.Command = t.CMD
+	if len(s.Command) == 0 {
+		s.Command = []string{"/tork/entrypoint"}
+	}
+	return s, nil
+}
+
+// parseLimits converts the task's limits to a CFS quota
+// and a memory limit, the way `podman create --cpus` and
+// `--memory` do.
+func parseLimits(limits *tork.TaskLimits) (*specResources, error) {
+	if limits == nil || (limits.CPUs == "" && limits.Memory == "") {
+		return nil, nil
+	}
+	resources := &specResources{}
+	if limits.CPUs != "" {
+		cpus, ok := new(big.Rat).SetString(limits.CPUs)
+		if !ok {
+			return nil, errors.Errorf("invalid CPUs value: failed to parse %v as a rational number", limits.CPUs)
+		}
+		quota := cpus.Mul(cpus, big.NewRat(cpuPeriod, 1))
+		if !quota.IsInt() {
+			return nil, errors.Errorf("invalid CPUs value: value is too precise")
+		}
+		resources.CPU = &specCPU{Quota: quota.Num().Int64(), Period: cpuPeriod}
+	}
+	if limits.Memory != "" {
+		mem, err := units.RAMInBytes(limits.Memory)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid memory value")
+		}
+		resources.Memory = &specMemory{Limit: mem}
+	}
+	return resources, nil
+}
+
+// parseGPUs converts a docker-style --gpus value (e.g. all
+// or device=0,1) to the CDI devices of the NVIDIA GPUs.
+func parseGPUs(gpus string) ([]specDevice, error) {
+	if gpus == "all" || gpus == "count=all" {
+		return []specDevice{{Path: "nvidia.com/gpu=all"}}, nil
+	}
+	ids, ok := strings.CutPrefix(gpus, "device=")
+	if !ok {
+		return nil, errors.Errorf("unsupported GPUs value: %s", gpus)
+	}
+	var devices []specDevice
+	for _, id := range strings.Split(ids, ",") {
+		devices = append(devices, specDevice{Path: fmt.Sprintf("nvidia.com/gpu=%s", strings.TrimSpace(id))})
+	}
+	return devices, nil
+}
+
+func parsePort(p *tork.Port) (specPortMapping, error) {
+	port, protocol, _ := strings.Cut(p.Port, "/")
+	containerPort, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return specPortMapping{}, errors.Wrapf(err, "invalid port: %s", p.Port)
+	}
+	return specPortMapping{
+		HostIP:        "127.0.0.1",
+		ContainerPort: uint16(containerPort),
+		HostPort:      uint16(p.HostPort),
+		Protocol:      protocol,
+	}, nil
+}
+
+func (d *PodmanRuntime) reportProgress(ctx context.Context, containerID string, t *tork.Task) {
+	for {
+		progress, err := d.readProgress(ctx, containerID)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Debug().Err(err).Msgf("error reading progress value")
+			}
+		} else {
+			if progress != t.Progress {
+				t.Progress = progress
+				if d.broker != nil {
+					if err := d.broker.PublishTaskProgress(ctx, t); err != nil {
+						log.Error().Err(err).Msgf("error publishing task progress")
+					}
+				}
+			}
+		}
+		select {
+		case <-time.After(time.Second * 5):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// copyFromContainer reads a single file out
+// of the container's filesystem.
+func (d *PodmanRuntime) copyFromContainer(ctx context.Context, containerID, path string) (string, error) {
+	r, err := d.client.getArchive(ctx, containerID, path)
+	if err != nil {
+		return "", errors.Wrapf(err, "error copying %s", path)
+	}
+	defer r.Close()
+	tr := tar.NewReader(r)
+	var buf bytes.Buffer
+	for {
+		_, err := tr.Next()
+		if err == io.EOF {
+			break // End of archive
+		}
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(&buf, tr); err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+func (d *PodmanRuntime) readOutput(ctx context.Context, containerID string) (string, error) {
+	return d.copyFromContainer(ctx, containerID, "/tork/stdout")
+}
+
+func (d *PodmanRuntime) readProgress(ctx context.Context, containerID string) (float64, error) {
+	s, err := d.copyFromContainer(ctx, containerID, "/tork/progress")
+	if err != nil {
+		return 0, err
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(s, 32)
+}
+
+func (d *PodmanRuntime) initTorkdir(ctx context.Context, containerID string, t *tork.Task) error {
+	ar, err := docker.NewTempArchive()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := ar.Remove(); err != nil {
+			log.Error().Err(err).Msgf("error removing temp archive: %s", ar.Name())
+		}
+	}()
+
+	if err := ar.WriteFile("stdout", 0222, []byte{}); err != nil {
+		return err
+	}
+	if err := ar.WriteFile("progress", 0222, []byte{}); err != nil {
+		return err
+	}
+
+	if t.Run != "" {
+		if err := ar.WriteFile("entrypoint", 0555, []byte(t.Run)); err != nil {
+			return err
+		}
+	}
+
+	return d.client.putArchive(ctx, containerID, "/tork", ar)
+}
+
+func (d *PodmanRuntime) initWorkDir(ctx context.Context, containerID string, t *tork.Task) error {
+	if len(t.Files) == 0 {
+		return nil
+	}
+
+	ar, err := docker.NewTempArchive()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := ar.Remove(); err != nil {
+			log.Error().Err(err).Msgf("error removing temp archive: %s", ar.Name())
+		}
+	}()
+
+	for filename, contents := range t.Files {
+		if err := ar.WriteFile(filename, 0444, []byte(contents)); err != nil {
+			return err
+		}
+	}
+
+	return d.client.putArchive(ctx, containerID, t.Workdir, ar)
+}
+
+func (d *PodmanRuntime) Stop(ctx context.Context, t *tork.Task) error {
+	containerID, ok := d.tasks.Get(t.ID)
+	if !ok {
+		return nil
+	}
+	d.tasks.Delete(t.ID)
+	log.Debug().Msgf("Attempting to stop and remove container %v", containerID)
+	return d.client.removeContainer(ctx, containerID)
+}
+
+func (d *PodmanRuntime) HealthCheck(ctx context.Context) error {
+	return d.client.ping(ctx)
+}
+
+func (d *PodmanRuntime) imagePull(ctx context.Context, t *tork.Task, logger io.Writer) error {
+	_, ok := d.images.Get(t.Image)
+	if ok {
+		return nil
+	}
+	pr := &pullRequest{
+		ctx:    ctx,
+		image:  t.Image,
+		logger: logger,
+		done:   make(chan error),
+	}
+	if t.Registry != nil {
+		pr.registry = registry{
+			username: t.Registry.Username,
+			password: t.Registry.Password,
+		}
+	}
+	d.pullq <- pr
+	err := <-pr.done
+	if err == nil {
+		d.images.Set(t.Image, true)
+	}
+	return err
+}
+
+// puller is a goroutine that serializes all requests
+// to pull images from the registry
+func (d *PodmanRuntime) puller() {
+	for pr := range d.pullq {
+		pr.done <- d.doPullRequest(pr)
+	}
+}
+
+func (d *PodmanRuntime) doPullRequest(pr *pullRequest) error {
+	// let's check if we have the image locally already
+	exists, err := d.client.imageExists(pr.ctx, pr.image)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	username, password := pr.registry.username, pr.registry.password
+	if username == "" {
+		u, p, err := docker.RegistryCredentials(d.config, pr.image)
+		if err != nil {
+			return err
+		}
+		username, password = u, p
+	}
+	var auth string
+	if username != "" {
+		b, err := json.Marshal(map[string]string{
+			"username": username,
+			"password": password,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "error serializing registry credentials")
+		}
+		auth = base64.URLEncoding.EncodeToString(b)
+	}
+	return d.client.pullImage(pr.ctx, pr.image, auth, pr.logger)
+}
@@ -0,0 +1,385 @@
+package podman
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func requirePodman(t *testing.T) {
+	c, err := newClient("")
+	if err != nil {
+		t.Skip("podman is not available")
+	}
+	if err := c.ping(context.Background()); err != nil {
+		t.Skip("podman service is not running")
+	}
+}
+
+// fakePodman is a stand-in for the libpod REST API which
+// records every request and the body of container creates.
+type fakePodman struct {
+	mu       sync.Mutex
+	requests []string
+	volumes  map[string]bool
+	spec     *spec
+	auth     string
+	exitCode int
+}
+
+func newFakePodman(t *testing.T) (*fakePodman, string) {
+	fp := &fakePodman{volumes: make(map[string]bool)}
+	srv := httptest.NewServer(http.HandlerFunc(fp.serve))
+	t.Cleanup(srv.Close)
+	return fp, "tcp://" + strings.TrimPrefix(srv.URL, "http://")
+}
+
+func (fp *fakePodman) log() string {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	return strings.Join(fp.requests, "\n")
+}
+
+func (fp *fakePodman) serve(w http.ResponseWriter, r *http.Request) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/"+apiVersion+"/libpod")
+	fp.requests = append(fp.requests, fmt.Sprintf("%s %s", r.Method, path))
+	switch {
+	case path == "/_ping":
+		_, _ = w.Write([]byte("OK"))
+	case strings.HasPrefix(path, "/images/") && strings.HasSuffix(path, "/exists"):
+		notFound(w, "no such image")
+	case strings.HasPrefix(path, "/images/") && strings.HasSuffix(path, "/json"):
+		_, _ = w.Write([]byte(`{"Config":{"User":"root"}}`))
+	case path == "/images/pull":
+		fp.auth = r.Header.Get("X-Registry-Auth")
+		_, _ = w.Write([]byte(`{"stream":"pulling ` + r.URL.Query().Get("reference") + `\n"}` + "\n"))
+		_, _ = w.Write([]byte(`{"images":["abc"],"id":"abc"}` + "\n"))
+	case path == "/volumes/create":
+		body := struct{ Name string }{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fp.volumes[body.Name] = true
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasPrefix(path, "/volumes/") && strings.HasSuffix(path, "/exists"):
+		if !fp.volumes[strings.TrimSuffix(strings.TrimPrefix(path, "/volumes/"), "/exists")] {
+			notFound(w, "no such volume")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(path, "/volumes/") && r.Method == http.MethodDelete:
+		delete(fp.volumes, strings.TrimPrefix(path, "/volumes/"))
+		w.WriteHeader(http.StatusNoContent)
+	case path == "/containers/create":
+		fp.spec = &spec{}
+		if err := json.NewDecoder(r.Body).Decode(fp.spec); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if strings.HasPrefix(fp.spec.Image, "failing") {
+			fp.exitCode = 1
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"Id":"container1"}`))
+	case path == "/containers/container1/archive" && r.Method == http.MethodPut:
+		if _, err := io.Copy(io.Discard, r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	case path == "/containers/container1/archive":
+		switch r.URL.Query().Get("path") {
+		case "/tork/stdout":
+			writeTar(w, "stdout", "hello world")
+		case "/tork/progress":
+			writeTar(w, "progress", "")
+		default:
+			notFound(w, "no such file")
+		}
+	case path == "/containers/container1/start":
+		w.WriteHeader(http.StatusNoContent)
+	case path == "/containers/container1/logs":
+		line := "some log line\n"
+		if r.URL.Query().Get("tail") != "" {
+			line = "some error\n"
+		}
+		_, _ = stdcopy.NewStdWriter(w, stdcopy.Stdout).Write([]byte(line))
+	case path == "/containers/container1/wait":
+		_, _ = w.Write([]byte(fmt.Sprintf("%d\n", fp.exitCode)))
+	case path == "/containers/container1" && r.Method == http.MethodDelete:
+		_, _ = w.Write([]byte(`[]`))
+	default:
+		notFound(w, "unknown endpoint: "+path)
+	}
+}
+
+func notFound(w http.ResponseWriter, msg string) {
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = w.Write([]byte(fmt.Sprintf(`{"cause":"not found","message":%q,"response":404}`, msg)))
+}
+
+func writeTar(w io.Writer, name, contents string) {
+	tw := tar.NewWriter(w)
+	_ = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))})
+	_, _ = tw.Write([]byte(contents))
+	_ = tw.Close()
+}
+
+func TestCreateSpec(t *testing.T) {
+	torkdir := &tork.Mount{Type: tork.MountTypeVolume, Source: "torkdir", Target: "/tork"}
+	tk := &tork.Task{
+		Image:    "ubuntu:mantic",
+		Run:      "echo hello",
+		Env:      map[string]string{"NAME": "world"},
+		Networks: []string{"mynet"},
+		Limits:   &tork.TaskLimits{CPUs: "1.5", Memory: "10m"},
+		GPUs:     "all",
+		Ports:    []*tork.Port{{Port: "8080", HostPort: 55000}},
+		Mounts: []tork.Mount{{
+			Type:   tork.MountTypeVolume,
+			Source: "vol1",
+			Target: "/data",
+		}, {
+			Type:   tork.MountTypeTmpfs,
+			Target: "/tmp",
+		}},
+		Files: map[string]string{"hello.txt": "hello"},
+	}
+	s, err := createSpec(tk, torkdir, "1000:1000")
+	assert.NoError(t, err)
+	assert.Equal(t, &spec{
+		Image:      "ubuntu:mantic",
+		Command:    []string{"/tork/entrypoint"},
+		Entrypoint: []string{"sh", "-c"},
+		Env: map[string]string{
+			"NAME":          "world",
+			"TORK_OUTPUT":   "/tork/stdout",
+			"TORK_PROGRESS": "/tork/progress",
+		},
+		WorkDir: defaultWorkdir,
+		User:    "1000:1000",
+		Mounts:  []specMount{{Type: "tmpfs", Source: "tmpfs", Destination: "/tmp"}},
+		Volumes: []specVolume{{Name: "vol1", Dest: "/data"}, {Name: "torkdir", Dest: "/tork"}},
+		PortMappings: []specPortMapping{{
+			HostIP:        "127.0.0.1",
+			ContainerPort: 8080,
+			HostPort:      55000,
+		}},
+		Networks: map[string]struct{}{"mynet": {}},
+		Devices:  []specDevice{{Path: "nvidia.com/gpu=all"}},
+		ResourceLimits: &specResources{
+			CPU:    &specCPU{Quota: 150000, Period: cpuPeriod},
+			Memory: &specMemory{Limit: 10 * 1024 * 1024},
+		},
+	}, s)
+	assert.Equal(t, defaultWorkdir, tk.Workdir)
+}
+
+func TestCreateSpecCMD(t *testing.T) {
+	torkdir := &tork.Mount{Type: tork.MountTypeVolume, Source: "torkdir", Target: "/tork"}
+	s, err := createSpec(&tork.Task{
+		Image: "ubuntu:mantic",
+		CMD:   []string{"ls", "-l"},
+	}, torkdir, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ls", "-l"}, s.Command)
+	assert.Empty(t, s.Entrypoint)
+	assert.Empty(t, s.WorkDir)
+	assert.Nil(t, s.ResourceLimits)
+}
+
+func TestCreateSpecBadMount(t *testing.T) {
+	torkdir := &tork.Mount{Type: tork.MountTypeVolume, Source: "torkdir", Target: "/tork"}
+	_, err := createSpec(&tork.Task{
+		Image:  "ubuntu:mantic",
+		Mounts: []tork.Mount{{Type: tork.MountTypeBind, Target: "/data"}},
+	}, torkdir, "")
+	assert.Error(t, err)
+	_, err = createSpec(&tork.Task{
+		Image:  "ubuntu:mantic",
+		Mounts: []tork.Mount{{Type: "nfs", Target: "/data"}},
+	}, torkdir, "")
+	assert.Error(t, err)
+}
+
+func TestParseGPUs(t *testing.T) {
+	devices, err := parseGPUs("device=0,1")
+	assert.NoError(t, err)
+	assert.Equal(t, []specDevice{{Path: "nvidia.com/gpu=0"}, {Path: "nvidia.com/gpu=1"}}, devices)
+	_, err = parseGPUs("count=2")
+	assert.Error(t, err)
+}
+
+func TestRootUserPattern(t *testing.T) {
+	assert.True(t, rootUserPattern.MatchString(""))
+	assert.True(t, rootUserPattern.MatchString("root"))
+	assert.True(t, rootUserPattern.MatchString("0:0"))
+	assert.False(t, rootUserPattern.MatchString("1000"))
+	assert.False(t, rootUserPattern.MatchString("nobody"))
+}
+
+func TestNewPodmanRuntimeBadHost(t *testing.T) {
+	_, err := NewPodmanRuntime(WithHost("ssh://somehost"))
+	assert.Error(t, err)
+}
+
+func TestHealthCheckFailed(t *testing.T) {
+	rt, err := NewPodmanRuntime(WithHost("unix:///no/such/podman.sock"))
+	assert.NoError(t, err)
+	assert.Error(t, rt.HealthCheck(context.Background()))
+}
+
+func TestRunTaskCMD(t *testing.T) {
+	requirePodman(t)
+	rt, err := NewPodmanRuntime()
+	assert.NoError(t, err)
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "busybox:stable",
+		Run:   "echo -n hello world > $TORK_OUTPUT",
+	}
+	err = rt.Run(context.Background(), tk)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", tk.Result)
+}
+
+func TestRunTaskWithError(t *testing.T) {
+	requirePodman(t)
+	rt, err := NewPodmanRuntime()
+	assert.NoError(t, err)
+	err = rt.Run(context.Background(), &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "busybox:stable",
+		Run:   "exit 1",
+	})
+	assert.Error(t, err)
+}
+
+func TestRunTaskInitWorkdir(t *testing.T) {
+	requirePodman(t)
+	rt, err := NewPodmanRuntime(WithSandbox(true))
+	assert.NoError(t, err)
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "busybox:stable",
+		Run:   "cat hello.txt > $TORK_OUTPUT",
+		Files: map[string]string{
+			"hello.txt": "hello world",
+		},
+		Mounts: []tork.Mount{{
+			Type:   tork.MountTypeVolume,
+			Target: "/data",
+		}},
+	}
+	err = rt.Run(context.Background(), tk)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", tk.Result)
+}
+
+func TestHealthCheckFake(t *testing.T) {
+	fp, host := newFakePodman(t)
+	rt, err := NewPodmanRuntime(WithHost(host))
+	assert.NoError(t, err)
+	assert.NoError(t, rt.HealthCheck(context.Background()))
+	assert.Equal(t, "GET /_ping", fp.log())
+}
+
+func TestRunTaskFake(t *testing.T) {
+	fp, host := newFakePodman(t)
+	rt, err := NewPodmanRuntime(WithHost(host))
+	assert.NoError(t, err)
+	var logs bytes.Buffer
+	tk := &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "busybox:stable",
+		Run:   "echo -n hello world > $TORK_OUTPUT",
+	}
+	err = rt.doRun(context.Background(), tk, &logs)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", tk.Result)
+	assert.Equal(t, "pulling busybox:stable\nsome log line\n", logs.String())
+	log := fp.log()
+	assert.Contains(t, log, "POST /images/pull")
+	assert.Contains(t, log, "POST /containers/container1/start")
+	assert.Contains(t, log, "DELETE /containers/container1")
+	assert.Equal(t, []string{"sh", "-c"}, fp.spec.Entrypoint)
+	assert.Equal(t, "/tork", fp.spec.Volumes[0].Dest)
+	// the torkdir volume is removed once the task completes
+	assert.Empty(t, fp.volumes)
+	assert.Empty(t, fp.auth)
+}
+
+func TestRunTaskFakeWithError(t *testing.T) {
+	_, host := newFakePodman(t)
+	rt, err := NewPodmanRuntime(WithHost(host))
+	assert.NoError(t, err)
+	err = rt.doRun(context.Background(), &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "failing:latest",
+		Run:   "exit 1",
+	}, io.Discard)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "exit code 1: some error")
+}
+
+func TestRunTaskFakeSandbox(t *testing.T) {
+	fp, host := newFakePodman(t)
+	rt, err := NewPodmanRuntime(WithHost(host), WithSandbox(true))
+	assert.NoError(t, err)
+	err = rt.doRun(context.Background(), &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "busybox:stable",
+		CMD:   []string{"ls"},
+	}, io.Discard)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultSandboxUser, fp.spec.User)
+}
+
+func TestPullWithCredentials(t *testing.T) {
+	fp, host := newFakePodman(t)
+	rt, err := NewPodmanRuntime(WithHost(host))
+	assert.NoError(t, err)
+	err = rt.doRun(context.Background(), &tork.Task{
+		ID:    uuid.NewUUID(),
+		Image: "registry.example.com/busybox:stable",
+		CMD:   []string{"ls"},
+		Registry: &tork.Registry{
+			Username: "someuser",
+			Password: "somepass",
+		},
+	}, io.Discard)
+	assert.NoError(t, err)
+	assert.NotContains(t, fp.log(), "somepass")
+	b, err := base64.URLEncoding.DecodeString(fp.auth)
+	assert.NoError(t, err)
+	auth := struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}{}
+	assert.NoError(t, json.Unmarshal(b, &auth))
+	assert.Equal(t, "someuser", auth.Username)
+	assert.Equal(t, "somepass", auth.Password)
+}
+
+func TestMain(m *testing.M) {
+	// keep the tests off a podman
+	// service configured by the user
+	os.Unsetenv("CONTAINER_HOST")
+	os.Exit(m.Run())
+}
@@ -0,0 +1,64 @@
+package podman
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/runabol/tork"
+	"github.com/runabol/tork/internal/uuid"
+)
+
+type VolumeMounter struct {
+	client *client
+}
+
+type VolumeOption = func(m *volumeOptions)
+
+type volumeOptions struct {
+	host string
+}
+
+// WithVolumeHost sets the address of the podman service.
+func WithVolumeHost(host string) VolumeOption {
+	return func(o *volumeOptions) {
+		o.host = host
+	}
+}
+
+func NewVolumeMounter(opts ...VolumeOption) (*VolumeMounter, error) {
+	o := &volumeOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	c, err := newClient(o.host)
+	if err != nil {
+		return nil, err
+	}
+	return &VolumeMounter{client: c}, nil
+}
+
+func (m *VolumeMounter) Mount(ctx context.Context, mn *tork.Mount) error {
+	name := uuid.NewUUID()
+	mn.Source = name
+	if err := m.client.createVolume(ctx, name); err != nil {
+		return errors.Wrapf(err, "error creating volume %s", name)
+	}
+	log.Debug().Msgf("created volume %s", name)
+	return nil
+}
+
+func (m *VolumeMounter) Unmount(ctx context.Context, mn *tork.Mount) error {
+	exists, err := m.client.volumeExists(ctx, mn.Source)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.Errorf("unknown volume: %s", mn.Source)
+	}
+	if err := m.client.removeVolume(ctx, mn.Source); err != nil {
+		return errors.Wrapf(err, "error removing volume %s", mn.Source)
+	}
+	log.Debug().Msgf("removed volume %s", mn.Source)
+	return nil
+}
@@ -0,0 +1,63 @@
+package podman
+
+import (
+	"context"
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMountVolume(t *testing.T) {
+	requirePodman(t)
+	ctx := context.Background()
+	m, err := NewVolumeMounter()
+	assert.NoError(t, err)
+	mnt := &tork.Mount{
+		Type:   tork.MountTypeVolume,
+		Target: "/data",
+	}
+	err = m.Mount(ctx, mnt)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, mnt.Source)
+	err = m.Unmount(ctx, mnt)
+	assert.NoError(t, err)
+	err = m.Unmount(ctx, mnt)
+	assert.Error(t, err)
+}
+
+func TestMountVolumeNoPodman(t *testing.T) {
+	m, err := NewVolumeMounter(WithVolumeHost("unix:///no/such/podman.sock"))
+	assert.NoError(t, err)
+	err = m.Mount(context.Background(), &tork.Mount{
+		Type:   tork.MountTypeVolume,
+		Target: "/data",
+	})
+	assert.Error(t, err)
+}
+
+func TestMountVolumeFake(t *testing.T) {
+	fp, host := newFakePodman(t)
+	ctx := context.Background()
+	m, err := NewVolumeMounter(WithVolumeHost(host))
+	assert.NoError(t, err)
+	mnt := &tork.Mount{
+		Type:   tork.MountTypeVolume,
+		Target: "/data",
+	}
+	err = m.Mount(ctx, mnt)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, mnt.Source)
+	assert.True(t, fp.volumes[mnt.Source])
+	err = m.Unmount(ctx, mnt)
+	assert.NoError(t, err)
+	err = m.Unmount(ctx, mnt)
+	assert.Error(t, err)
+	assert.Equal(t,
+		"POST /volumes/create\n"+
+			"GET /volumes/"+mnt.Source+"/exists\n"+
+			"DELETE /volumes/"+mnt.Source+"\n"+
+			"GET /volumes/"+mnt.Source+"/exists",
+		fp.log(),
+	)
+}
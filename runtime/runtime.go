@@ -7,8 +7,10 @@ import (
 )
 
 const (
-	Docker = "docker"
-	Shell  = "shell"
+	Docker     = "docker"
+	Shell      = "shell"
+	Kubernetes = "kubernetes"
+	Podman     = "podman"
 )
 
 // Runtime is the actual runtime environment that executes a task.
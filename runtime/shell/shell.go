@@ -11,10 +11,12 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/artifact"
 	"github.com/runabol/tork/internal/reexec"
 	"github.com/runabol/tork/internal/syncx"
 	"github.com/runabol/tork/internal/uuid"
@@ -34,20 +36,22 @@ func init() {
 }
 
 type ShellRuntime struct {
-	cmds   *syncx.Map[string, *exec.Cmd]
-	shell  []string
-	uid    string
-	gid    string
-	reexec Rexec
-	broker mq.Broker
+	cmds      *syncx.Map[string, *exec.Cmd]
+	shell     []string
+	uid       string
+	gid       string
+	reexec    Rexec
+	broker    mq.Broker
+	artifacts artifact.Store
 }
 
 type Config struct {
-	CMD    []string
-	UID    string
-	GID    string
-	Rexec  Rexec
-	Broker mq.Broker
+	CMD       []string
+	UID       string
+	GID       string
+	Rexec     Rexec
+	Broker    mq.Broker
+	Artifacts artifact.Store
 }
 
 func NewShellRuntime(cfg Config) *ShellRuntime {
@@ -64,12 +68,13 @@ func NewShellRuntime(cfg Config) *ShellRuntime {
 		cfg.GID = DEFAULT_GID
 	}
 	return &ShellRuntime{
-		cmds:   new(syncx.Map[string, *exec.Cmd]),
-		shell:  cfg.CMD,
-		uid:    cfg.UID,
-		gid:    cfg.GID,
-		reexec: cfg.Rexec,
-		broker: cfg.Broker,
+		cmds:      new(syncx.Map[string, *exec.Cmd]),
+		shell:     cfg.CMD,
+		uid:       cfg.UID,
+		gid:       cfg.GID,
+		reexec:    cfg.Rexec,
+		broker:    cfg.Broker,
+		artifacts: cfg.Artifacts,
 	}
 }
 
@@ -98,6 +103,16 @@ func (r *ShellRuntime) Run(ctx context.Context, t *tork.Task) error {
 	if len(t.CMD) > 0 {
 		return errors.New("cmd is not supported on shell runtime")
 	}
+	if t.Artifacts != nil {
+		if r.artifacts == nil {
+			return errors.New("artifact store is not configured")
+		}
+		for _, a := range append(t.Artifacts.Outputs, t.Artifacts.Inputs...) {
+			if !filepath.IsLocal(a.Path) {
+				return errors.Errorf("artifact path must be relative to the workdir on shell runtime: %s", a.Path)
+			}
+		}
+	}
 	var logger io.Writer
 	if r.broker != nil {
 		logger = mq.NewLogShipper(r.broker, t.ID)
@@ -151,6 +166,14 @@ func (r *ShellRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Writer
 		}
 	}
 
+	if t.Artifacts != nil {
+		for _, a := range t.Artifacts.Inputs {
+			if err := artifact.Download(ctx, r.artifacts, t.JobID, a.Name, filepath.Join(workdir, a.Path)); err != nil {
+				return err
+			}
+		}
+	}
+
 	env := []string{}
 	for name, value := range t.Env {
 		env = append(env, fmt.Sprintf("%s%s=%s", envVarPrefix, name, value))
@@ -223,6 +246,12 @@ func (r *ShellRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Writer
 	}()
 	select {
 	case err := <-errChan:
+		// surface the script's exit code the same way
+		// the container runtimes do
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			return errors.Errorf("exit code %d", exitErr.ExitCode())
+		}
 		return errors.Wrapf(err, "error executing command")
 	case <-ctx.Done():
 		if err := cmd.Process.Kill(); err != nil {
@@ -239,6 +268,14 @@ func (r *ShellRuntime) doRun(ctx context.Context, t *tork.Task, logger io.Writer
 
 	t.Result = string(output)
 
+	if t.Artifacts != nil {
+		for _, a := range t.Artifacts.Outputs {
+			if err := artifact.Upload(ctx, r.artifacts, t.JobID, a.Name, filepath.Join(workdir, a.Path)); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -292,6 +329,12 @@ func reexecRun() {
 	cmd.Dir = workdir
 
 	if err := cmd.Run(); err != nil {
+		// propagate the exit code of the command
+		// rather than the generic failure of log.Fatal
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			os.Exit(exitErr.ExitCode())
+		}
 		log.Fatal().Err(err).Msgf("error reexecing: %s", strings.Join(flag.Args(), " "))
 	}
 }
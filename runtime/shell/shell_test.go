@@ -7,7 +7,11 @@ import (
 	"time"
 
 	"github.com/runabol/tork"
+	"github.com/runabol/tork/artifact/local"
+	"github.com/runabol/tork/datastore/inmemory"
+	"github.com/runabol/tork/internal/coordinator/handlers"
 	"github.com/runabol/tork/internal/uuid"
+	"github.com/runabol/tork/middleware/task"
 	"github.com/runabol/tork/mq"
 	"github.com/stretchr/testify/assert"
 )
@@ -76,6 +80,77 @@ func TestShellRuntimeRunFile(t *testing.T) {
 	assert.Equal(t, "hello world", tk.Result)
 }
 
+func TestShellRuntimeRunArtifacts(t *testing.T) {
+	store, err := local.NewLocalStore(t.TempDir())
+	assert.NoError(t, err)
+	rt := NewShellRuntime(Config{
+		UID: DEFAULT_UID,
+		GID: DEFAULT_GID,
+		Rexec: func(args ...string) *exec.Cmd {
+			cmd := exec.Command(args[5], args[6:]...)
+			return cmd
+		},
+		Artifacts: store,
+	})
+
+	jobID := uuid.NewUUID()
+	t1 := &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: jobID,
+		Run:   "mkdir out && echo -n hello world > out/report.txt",
+		Artifacts: &tork.TaskArtifacts{
+			Outputs: []tork.TaskArtifact{{Name: "report", Path: "out/report.txt"}},
+		},
+	}
+	err = rt.Run(context.Background(), t1)
+	assert.NoError(t, err)
+
+	t2 := &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: jobID,
+		Run:   "cat in/report.txt > $REEXEC_TORK_OUTPUT",
+		Artifacts: &tork.TaskArtifacts{
+			Inputs: []tork.TaskArtifact{{Name: "report", Path: "in/report.txt"}},
+		},
+	}
+	err = rt.Run(context.Background(), t2)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", t2.Result)
+
+	t3 := &tork.Task{
+		ID:    uuid.NewUUID(),
+		JobID: jobID,
+		Run:   "echo -n hello",
+		Artifacts: &tork.TaskArtifacts{
+			Outputs: []tork.TaskArtifact{{Name: "missing", Path: "missing.txt"}},
+		},
+	}
+	err = rt.Run(context.Background(), t3)
+	assert.Error(t, err)
+}
+
+func TestShellRuntimeRunArtifactsNotAllowed(t *testing.T) {
+	store, err := local.NewLocalStore(t.TempDir())
+	assert.NoError(t, err)
+	rt := NewShellRuntime(Config{Artifacts: store})
+	err = rt.Run(context.Background(), &tork.Task{
+		ID:  uuid.NewUUID(),
+		Run: "echo hello",
+		Artifacts: &tork.TaskArtifacts{
+			Outputs: []tork.TaskArtifact{{Name: "passwd", Path: "/etc/passwd"}},
+		},
+	})
+	assert.Error(t, err)
+	err = NewShellRuntime(Config{}).Run(context.Background(), &tork.Task{
+		ID:  uuid.NewUUID(),
+		Run: "echo hello",
+		Artifacts: &tork.TaskArtifacts{
+			Outputs: []tork.TaskArtifact{{Name: "report", Path: "report.txt"}},
+		},
+	})
+	assert.Error(t, err)
+}
+
 func TestShellRuntimeRunNotSupported(t *testing.T) {
 	rt := NewShellRuntime(Config{})
 
@@ -110,6 +185,92 @@ func TestShellRuntimeRunError(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestShellRuntimeRunExitCode(t *testing.T) {
+	rt := NewShellRuntime(Config{
+		UID: DEFAULT_UID,
+		GID: DEFAULT_GID,
+		Rexec: func(args ...string) *exec.Cmd {
+			cmd := exec.Command(args[5], args[6:]...)
+			return cmd
+		},
+	})
+
+	tk := &tork.Task{
+		ID:  uuid.NewUUID(),
+		Run: "exit 2",
+	}
+
+	err := rt.Run(context.Background(), tk)
+
+	assert.Error(t, err)
+	assert.Equal(t, "exit code 2", err.Error())
+}
+
+func TestShellRuntimeRunRetryOnExitCode(t *testing.T) {
+	ctx := context.Background()
+	rt := NewShellRuntime(Config{
+		UID: DEFAULT_UID,
+		GID: DEFAULT_GID,
+		Rexec: func(args ...string) *exec.Cmd {
+			cmd := exec.Command(args[5], args[6:]...)
+			return cmd
+		},
+	})
+
+	b := mq.NewInMemoryBroker()
+	retried := make(chan *tork.Task, 1)
+	err := b.SubscribeForTasks(mq.QUEUE_PENDING, func(t *tork.Task) error {
+		retried <- t
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ds := inmemory.NewInMemoryDatastore()
+	j1 := &tork.Job{
+		ID:       uuid.NewUUID(),
+		State:    tork.JobStateRunning,
+		Position: 1,
+		Tasks: []*tork.Task{
+			{
+				Name: "task-1",
+			},
+		},
+	}
+	err = ds.CreateJob(ctx, j1)
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+	tk := &tork.Task{
+		ID:        uuid.NewUUID(),
+		JobID:     j1.ID,
+		Position:  1,
+		State:     tork.TaskStateRunning,
+		StartedAt: &now,
+		Run:       "exit 2",
+		Retry: &tork.TaskRetry{
+			Limit:   1,
+			RetryOn: []string{"2"},
+		},
+	}
+	err = ds.CreateTask(ctx, tk)
+	assert.NoError(t, err)
+
+	err = rt.Run(ctx, tk)
+	assert.Error(t, err)
+
+	// fail the task the way the worker does
+	tk.Error = err.Error()
+	tk.FailedAt = &now
+	tk.State = tork.TaskStateFailed
+	handler := handlers.NewErrorHandler(ds, b)
+	err = handler(ctx, task.StateChange, tk)
+	assert.NoError(t, err)
+
+	rt1 := <-retried
+	assert.NotEqual(t, tk.ID, rt1.ID)
+	assert.Equal(t, 1, rt1.Retry.Attempts)
+}
+
 func TestShellRuntimeRunTimeout(t *testing.T) {
 	rt := NewShellRuntime(Config{
 		UID: DEFAULT_UID,
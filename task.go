@@ -32,48 +32,53 @@ var TaskStateActive = []TaskState{
 
 // Task is the basic unit of work that a Worker can handle.
 type Task struct {
-	ID          string            `json:"id,omitempty"`
-	JobID       string            `json:"jobId,omitempty"`
-	ParentID    string            `json:"parentId,omitempty"`
-	Position    int               `json:"position,omitempty"`
-	Name        string            `json:"name,omitempty"`
-	Description string            `json:"description,omitempty"`
-	State       TaskState         `json:"state,omitempty"`
-	CreatedAt   *time.Time        `json:"createdAt,omitempty"`
-	ScheduledAt *time.Time        `json:"scheduledAt,omitempty"`
-	StartedAt   *time.Time        `json:"startedAt,omitempty"`
-	CompletedAt *time.Time        `json:"completedAt,omitempty"`
-	FailedAt    *time.Time        `json:"failedAt,omitempty"`
-	CMD         []string          `json:"cmd,omitempty"`
-	Entrypoint  []string          `json:"entrypoint,omitempty"`
-	Run         string            `json:"run,omitempty"`
-	Image       string            `json:"image,omitempty"`
-	Registry    *Registry         `json:"registry,omitempty"`
-	Env         map[string]string `json:"env,omitempty"`
-	Files       map[string]string `json:"files,omitempty"`
-	Queue       string            `json:"queue,omitempty"`
-	Error       string            `json:"error,omitempty"`
-	Pre         []*Task           `json:"pre,omitempty"`
-	Post        []*Task           `json:"post,omitempty"`
-	Mounts      []Mount           `json:"mounts,omitempty"`
-	Networks    []string          `json:"networks,omitempty"`
-	NodeID      string            `json:"nodeId,omitempty"`
-	Retry       *TaskRetry        `json:"retry,omitempty"`
-	Limits      *TaskLimits       `json:"limits,omitempty"`
-	Timeout     string            `json:"timeout,omitempty"`
-	Result      string            `json:"result,omitempty"`
-	Var         string            `json:"var,omitempty"`
-	If          string            `json:"if,omitempty"`
-	Parallel    *ParallelTask     `json:"parallel,omitempty"`
-	Each        *EachTask         `json:"each,omitempty"`
-	SubJob      *SubJobTask       `json:"subjob,omitempty"`
-	GPUs        string            `json:"gpus,omitempty"`
-	Tags        []string          `json:"tags,omitempty"`
-	Workdir     string            `json:"workdir,omitempty"`
-	Priority    int               `json:"priority,omitempty"`
-	Progress    float64           `json:"progress,omitempty"`
-	Ports       []*Port           `json:"ports,omitempty"`
-	Internal    bool              `json:"-"`
+	ID           string            `json:"id,omitempty"`
+	JobID        string            `json:"jobId,omitempty"`
+	ParentID     string            `json:"parentId,omitempty"`
+	Position     int               `json:"position,omitempty"`
+	Name         string            `json:"name,omitempty"`
+	Description  string            `json:"description,omitempty"`
+	State        TaskState         `json:"state,omitempty"`
+	CreatedAt    *time.Time        `json:"createdAt,omitempty"`
+	ScheduledAt  *time.Time        `json:"scheduledAt,omitempty"`
+	StartedAt    *time.Time        `json:"startedAt,omitempty"`
+	CompletedAt  *time.Time        `json:"completedAt,omitempty"`
+	FailedAt     *time.Time        `json:"failedAt,omitempty"`
+	NotBefore    *time.Time        `json:"notBefore,omitempty"`
+	CMD          []string          `json:"cmd,omitempty"`
+	Entrypoint   []string          `json:"entrypoint,omitempty"`
+	Run          string            `json:"run,omitempty"`
+	Image        string            `json:"image,omitempty"`
+	Registry     *Registry         `json:"registry,omitempty"`
+	Env          map[string]string `json:"env,omitempty"`
+	Files        map[string]string `json:"files,omitempty"`
+	Queue        string            `json:"queue,omitempty"`
+	Error        string            `json:"error,omitempty"`
+	Pre          []*Task           `json:"pre,omitempty"`
+	Post         []*Task           `json:"post,omitempty"`
+	Mounts       []Mount           `json:"mounts,omitempty"`
+	Networks     []string          `json:"networks,omitempty"`
+	NodeID       string            `json:"nodeId,omitempty"`
+	Retry        *TaskRetry        `json:"retry,omitempty"`
+	Limits       *TaskLimits       `json:"limits,omitempty"`
+	Timeout      string            `json:"timeout,omitempty"`
+	Result       string            `json:"result,omitempty"`
+	Var          string            `json:"var,omitempty"`
+	If           string            `json:"if,omitempty"`
+	DependsOn    []string          `json:"dependsOn,omitempty"`
+	Parallel     *ParallelTask     `json:"parallel,omitempty"`
+	Each         *EachTask         `json:"each,omitempty"`
+	SubJob       *SubJobTask       `json:"subjob,omitempty"`
+	GPUs         string            `json:"gpus,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	Workdir      string            `json:"workdir,omitempty"`
+	Priority     int               `json:"priority,omitempty"`
+	Progress     float64           `json:"progress,omitempty"`
+	Ports        []*Port           `json:"ports,omitempty"`
+	Artifacts    *TaskArtifacts    `json:"artifacts,omitempty"`
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	Affinity     map[string]string `json:"affinity,omitempty"`
+	Internal     bool              `json:"-"`
 }
 
 type TaskSummary struct {
@@ -131,8 +136,12 @@ type EachTask struct {
 }
 
 type TaskRetry struct {
-	Limit    int `json:"limit,omitempty"`
-	Attempts int `json:"attempts,omitempty"`
+	Limit         int      `json:"limit,omitempty"`
+	Attempts      int      `json:"attempts,omitempty"`
+	InitialDelay  string   `json:"initialDelay,omitempty"`
+	MaxDelay      string   `json:"maxDelay,omitempty"`
+	ScalingFactor float64  `json:"scalingFactor,omitempty"`
+	RetryOn       []string `json:"retryOn,omitempty"`
 }
 
 type TaskLimits struct {
@@ -140,6 +149,20 @@ type TaskLimits struct {
 	Memory string `json:"memory,omitempty"`
 }
 
+// TaskArtifacts lists the files a task uploads to the
+// artifact store upon completion (Outputs) and the files,
+// uploaded by earlier tasks of the same job, that it
+// needs before it starts (Inputs).
+type TaskArtifacts struct {
+	Outputs []TaskArtifact `json:"outputs,omitempty"`
+	Inputs  []TaskArtifact `json:"inputs,omitempty"`
+}
+
+type TaskArtifact struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
 type Registry struct {
 	Username string `json:"username,omitempty"`
 	Password string `json:"password,omitempty"`
@@ -179,48 +202,57 @@ func (t *Task) Clone() *Task {
 	if t.Registry != nil {
 		registry = t.Registry.Clone()
 	}
+	var artifacts *TaskArtifacts
+	if t.Artifacts != nil {
+		artifacts = t.Artifacts.Clone()
+	}
 	return &Task{
-		ID:          t.ID,
-		JobID:       t.JobID,
-		ParentID:    t.ParentID,
-		Position:    t.Position,
-		Name:        t.Name,
-		State:       t.State,
-		CreatedAt:   t.CreatedAt,
-		ScheduledAt: t.ScheduledAt,
-		StartedAt:   t.StartedAt,
-		CompletedAt: t.CompletedAt,
-		FailedAt:    t.FailedAt,
-		CMD:         t.CMD,
-		Entrypoint:  t.Entrypoint,
-		Run:         t.Run,
-		Image:       t.Image,
-		Registry:    registry,
-		Env:         maps.Clone(t.Env),
-		Files:       maps.Clone(t.Files),
-		Queue:       t.Queue,
-		Error:       t.Error,
-		Pre:         CloneTasks(t.Pre),
-		Post:        CloneTasks(t.Post),
-		Mounts:      slices.Clone(t.Mounts),
-		Networks:    t.Networks,
-		NodeID:      t.NodeID,
-		Retry:       retry,
-		Limits:      limits,
-		Timeout:     t.Timeout,
-		Result:      t.Result,
-		Var:         t.Var,
-		If:          t.If,
-		Parallel:    parallel,
-		Each:        each,
-		Description: t.Description,
-		SubJob:      subjob,
-		GPUs:        t.GPUs,
-		Tags:        t.Tags,
-		Workdir:     t.Workdir,
-		Priority:    t.Priority,
-		Progress:    t.Progress,
-		Ports:       ClonePorts(t.Ports),
+		ID:           t.ID,
+		JobID:        t.JobID,
+		ParentID:     t.ParentID,
+		Position:     t.Position,
+		Name:         t.Name,
+		State:        t.State,
+		CreatedAt:    t.CreatedAt,
+		ScheduledAt:  t.ScheduledAt,
+		StartedAt:    t.StartedAt,
+		CompletedAt:  t.CompletedAt,
+		FailedAt:     t.FailedAt,
+		NotBefore:    t.NotBefore,
+		CMD:          t.CMD,
+		Entrypoint:   t.Entrypoint,
+		Run:          t.Run,
+		Image:        t.Image,
+		Registry:     registry,
+		Env:          maps.Clone(t.Env),
+		Files:        maps.Clone(t.Files),
+		Queue:        t.Queue,
+		Error:        t.Error,
+		Pre:          CloneTasks(t.Pre),
+		Post:         CloneTasks(t.Post),
+		Mounts:       slices.Clone(t.Mounts),
+		Networks:     t.Networks,
+		NodeID:       t.NodeID,
+		Retry:        retry,
+		Limits:       limits,
+		Timeout:      t.Timeout,
+		Result:       t.Result,
+		Var:          t.Var,
+		If:           t.If,
+		DependsOn:    slices.Clone(t.DependsOn),
+		Parallel:     parallel,
+		Each:         each,
+		Description:  t.Description,
+		SubJob:       subjob,
+		GPUs:         t.GPUs,
+		Tags:         t.Tags,
+		Workdir:      t.Workdir,
+		Priority:     t.Priority,
+		Progress:     t.Progress,
+		Ports:        ClonePorts(t.Ports),
+		Artifacts:    artifacts,
+		NodeSelector: maps.Clone(t.NodeSelector),
+		Affinity:     maps.Clone(t.Affinity),
 	}
 }
 
@@ -234,8 +266,12 @@ func CloneTasks(tasks []*Task) []*Task {
 
 func (r *TaskRetry) Clone() *TaskRetry {
 	return &TaskRetry{
-		Limit:    r.Limit,
-		Attempts: r.Attempts,
+		Limit:         r.Limit,
+		Attempts:      r.Attempts,
+		InitialDelay:  r.InitialDelay,
+		MaxDelay:      r.MaxDelay,
+		ScalingFactor: r.ScalingFactor,
+		RetryOn:       slices.Clone(r.RetryOn),
 	}
 }
 
@@ -291,6 +327,13 @@ func (r *Registry) Clone() *Registry {
 	}
 }
 
+func (a *TaskArtifacts) Clone() *TaskArtifacts {
+	return &TaskArtifacts{
+		Outputs: slices.Clone(a.Outputs),
+		Inputs:  slices.Clone(a.Inputs),
+	}
+}
+
 func NewTaskSummary(t *Task) *TaskSummary {
 	return &TaskSummary{
 		ID:          t.ID,
@@ -15,6 +15,9 @@ func TestCloneTask(t *testing.T) {
 		Limits: &tork.TaskLimits{
 			CPUs: "1",
 		},
+		Artifacts: &tork.TaskArtifacts{
+			Outputs: []tork.TaskArtifact{{Name: "report", Path: "report.txt"}},
+		},
 		Parallel: &tork.ParallelTask{
 			Tasks: []*tork.Task{
 				{
@@ -32,6 +35,8 @@ func TestCloneTask(t *testing.T) {
 
 	t2.Env["VAR2"] = "VAL2"
 	t2.Limits.CPUs = "2"
+	t2.Artifacts.Outputs[0].Path = "other.txt"
+	assert.Equal(t, "report.txt", t1.Artifacts.Outputs[0].Path)
 	t2.Parallel.Tasks[0].Env["PVAR2"] = "PVAL2"
 	assert.NotEqual(t, t1.Env, t2.Env)
 	assert.NotEqual(t, t1.Limits.CPUs, t2.Limits.CPUs)
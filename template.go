@@ -0,0 +1,67 @@
+package tork
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// JobTemplate is a versioned job definition stored on the
+// server, from which jobs can be created by reference.
+type JobTemplate struct {
+	ID          string          `json:"id,omitempty"`
+	Name        string          `json:"name,omitempty"`
+	Version     int             `json:"version,omitempty"`
+	Description string          `json:"description,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt,omitempty"`
+	CreatedBy   *User           `json:"createdBy,omitempty"`
+	Permissions []*Permission   `json:"permissions,omitempty"`
+	Job         json.RawMessage `json:"job,omitempty"`
+}
+
+func (t *JobTemplate) Clone() *JobTemplate {
+	var createdBy *User
+	if t.CreatedBy != nil {
+		createdBy = t.CreatedBy.Clone()
+	}
+	return &JobTemplate{
+		ID:          t.ID,
+		Name:        t.Name,
+		Version:     t.Version,
+		Description: t.Description,
+		CreatedAt:   t.CreatedAt,
+		CreatedBy:   createdBy,
+		Permissions: ClonePermissions(t.Permissions),
+		Job:         slices.Clone(t.Job),
+	}
+}
+
+// Ref returns the reference to this version of the template.
+func (t *JobTemplate) Ref() string {
+	return fmt.Sprintf("%s@%d", t.Name, t.Version)
+}
+
+// ParseRef parses a reference to a versioned definition -- such as
+// a job template or a library task -- of the form name@version. The
+// version may be prefixed with a v (e.g. lint@v2). A reference without
+// a version refers to the latest version, in which case the returned
+// version is 0.
+func ParseRef(ref string) (string, int, error) {
+	name, v, ok := strings.Cut(ref, "@")
+	if name == "" {
+		return "", 0, errors.Errorf("invalid reference: %s", ref)
+	}
+	if !ok {
+		return name, 0, nil
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(v, "v"))
+	if err != nil || version < 1 {
+		return "", 0, errors.Errorf("invalid version: %s", ref)
+	}
+	return name, version, nil
+}
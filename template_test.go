@@ -0,0 +1,34 @@
+package tork_test
+
+import (
+	"testing"
+
+	"github.com/runabol/tork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRef(t *testing.T) {
+	name, version, err := tork.ParseRef("deploy")
+	assert.NoError(t, err)
+	assert.Equal(t, "deploy", name)
+	assert.Equal(t, 0, version)
+
+	name, version, err = tork.ParseRef("deploy@3")
+	assert.NoError(t, err)
+	assert.Equal(t, "deploy", name)
+	assert.Equal(t, 3, version)
+
+	name, version, err = tork.ParseRef("lint@v2")
+	assert.NoError(t, err)
+	assert.Equal(t, "lint", name)
+	assert.Equal(t, 2, version)
+
+	_, _, err = tork.ParseRef("lint@v0")
+	assert.Error(t, err)
+
+	_, _, err = tork.ParseRef("lint@latest")
+	assert.Error(t, err)
+
+	_, _, err = tork.ParseRef("@2")
+	assert.Error(t, err)
+}